@@ -0,0 +1,232 @@
+// Backs up and restores a 3DHub.io instance.
+//
+// A backup is a gzipped tar archive holding a pg_dump of the PostgreSQL metadata, along with every database file in
+// Minio referenced by a commit.  Restoring one into an empty PostgreSQL database and Minio server gives a working
+// instance, once the search index has been rebuilt (which restoring does automatically).
+//
+// The scheduled backups uploaded by the webui server are encrypted.  They can be restored directly, using the backup
+// passphrase from the configuration file.
+//
+// Usage:
+//
+//	3dhub-backup backup -o 3dhub-backup.tar.gz
+//	3dhub-backup restore -i 3dhub-backup.tar.gz
+//
+// The pg_dump and pg_restore utilities need to be in the PATH.
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	com "github.com/justinclift/3dhub.io/common"
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage:\n  %s backup -o FILE\n  %s restore -i FILE\n", os.Args[0], os.Args[0])
+	}
+	if len(os.Args) < 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// Parse the command line flags for the sub command
+	cmd := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+	var fileName *string
+	switch os.Args[1] {
+	case "backup":
+		fileName = cmd.String("o", "", "File to write the backup archive to")
+	case "restore":
+		fileName = cmd.String("i", "", "Backup archive to restore from")
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	cmd.Parse(os.Args[2:])
+	if *fileName == "" {
+		cmd.Usage()
+		os.Exit(2)
+	}
+
+	// Read server configuration
+	var err error
+	if err = com.ReadConfig(); err != nil {
+		com.Log.Fatalf("Configuration file problem\n\n%v", err)
+	}
+
+	// Connect to Minio server
+	err = com.ConnectMinio()
+	if err != nil {
+		com.Log.Fatalf(err.Error())
+	}
+
+	if os.Args[1] == "backup" {
+		err = backup(*fileName)
+	} else {
+		err = restore(*fileName)
+	}
+	if err != nil {
+		com.Log.Fatal(err)
+	}
+}
+
+// Writes a backup of the PostgreSQL metadata and the Minio objects it references to a new archive file.
+func backup(fileName string) error {
+	// Connect to PostgreSQL server
+	err := com.ConnectPostgreSQL()
+	if err != nil {
+		return err
+	}
+	defer com.DisconnectPostgreSQL()
+
+	// Create the archive
+	f, err := os.OpenFile(fileName, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	numObjects, err := com.WriteBackup(f)
+	if err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	com.Log.Infof("Backup written to '%s', with %d database files", fileName, numObjects)
+	return nil
+}
+
+// Rebuilds an instance from a backup archive.  The metadata replaces whatever is in the configured PostgreSQL
+// database, and the database files are added to Minio.
+func restore(fileName string) error {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Decrypt the archive as it's read, if it's one of the scheduled backups
+	br := bufio.NewReader(f)
+	var r io.Reader = br
+	if header, _ := br.Peek(8); com.IsEncryptedBackup(header) {
+		r, err = com.NewBackupDecrypter(r, com.Conf().Backup.Passphrase)
+		if err != nil {
+			return err
+		}
+	}
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gz)
+
+	// The manifest comes first
+	hdr, err := tr.Next()
+	if err != nil {
+		return err
+	}
+	if hdr.Name != com.BackupManifestName {
+		return errors.New("Not a 3DHub.io backup archive")
+	}
+	var m com.BackupManifest
+	if err = json.NewDecoder(tr).Decode(&m); err != nil {
+		return fmt.Errorf("Reading the backup manifest failed: %v", err)
+	}
+	com.Log.Infof("Restoring backup of '%s' from %s, with %d database files", m.Server,
+		m.Created.Format(time.RFC3339), m.NumObjects)
+
+	var numObjects int
+	for {
+		hdr, err = tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		switch {
+		case hdr.Name == com.BackupMetadataName:
+			err = restoreMetadata(tr)
+		case strings.HasPrefix(hdr.Name, com.BackupObjectPrefix):
+			err = restoreObject(tr, path.Base(hdr.Name), hdr.Size)
+			numObjects++
+		default:
+			com.Log.Warnf("Ignoring unknown backup archive entry '%s'", hdr.Name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if numObjects != m.NumObjects {
+		com.Log.Warnf("The backup manifest lists %d database files, but %d were restored", m.NumObjects, numObjects)
+	}
+
+	// Connect to PostgreSQL server
+	err = com.ConnectPostgreSQL()
+	if err != nil {
+		return err
+	}
+	defer com.DisconnectPostgreSQL()
+
+	// The search index isn't part of the backup, so needs to be rebuilt from the restored metadata
+	err = com.ConnectSearch()
+	if err != nil {
+		return err
+	}
+	numProjects, err := com.RebuildSearchIndex()
+	if err != nil {
+		return fmt.Errorf("Rebuilding the search index failed after %d projects: %v", numProjects, err)
+	}
+	com.Log.Infof("Restore complete.  %d database files restored, %d projects indexed", numObjects, numProjects)
+	return nil
+}
+
+// Loads the PostgreSQL metadata from the backup, replacing anything already in the database.
+func restoreMetadata(r io.Reader) error {
+	dump, err := ioutil.TempFile(com.Conf().DiskCache.Directory, "3dhub-restore-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(dump.Name())
+	defer dump.Close()
+	if _, err = io.Copy(dump, r); err != nil {
+		return err
+	}
+	err = com.PgCommand("pg_restore", "--clean", "--if-exists", "--no-owner", "--single-transaction",
+		"--dbname="+com.Conf().Pg.Database, dump.Name())
+	if err != nil {
+		return err
+	}
+	com.Log.Infof("PostgreSQL metadata restored")
+	return nil
+}
+
+// Stores a database file from the backup in Minio, checking it hasn't been corrupted along the way.
+func restoreObject(r io.Reader, sha string, size int64) error {
+	if len(sha) <= com.MinioFolderChars {
+		return fmt.Errorf("Invalid database file name in backup: '%s'", sha)
+	}
+	h := sha256.New()
+	err := com.StoreDatabaseFile(io.TeeReader(r, h), sha, size)
+	if err != nil {
+		return err
+	}
+	if hex.EncodeToString(h.Sum(nil)) != sha {
+		com.Log.Warnf("Database file '%s' doesn't match its sha256, so has been corrupted", sha)
+	}
+	return nil
+}
@@ -0,0 +1,589 @@
+// Imports a collection of 3D models into 3DHub.io in bulk, through the same API DB4S uses.
+//
+// The given directory is walked, and each model file in it becomes a project.  Files whose names only differ by a
+// version suffix (eg "bracket.stl", "bracket_v2.stl", and "bracket v3.stl") become versions of the one project,
+// uploaded in version order.  Files with the same name in different directories are versions of the one project too,
+// ordered by when they were last modified.
+//
+// Thingiverse exports, either the zip archive from the "Download all files" button of a thing or the directory it
+// extracts to, are imported under the licence of the thing, with a commit message crediting its creator.  Things
+// under a licence without an equivalent here are skipped.
+//
+// What's been uploaded is saved to a state file in the directory as the import goes, so an interrupted import carries
+// on where it left off when the same command is run again.  Running it again after adding files (or new versions of
+// them) to the directory uploads just the new ones.
+//
+// Usage:
+//
+//	3dhub-import -cert myname.cert.pem [-public] [-licence CC-BY-4.0] [-dry-run] DIRECTORY
+//
+// The client certificate is the one from the Preferences page of the website.  A dry run checks which projects
+// already exist on the server, but doesn't change anything.
+package main
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	com "github.com/justinclift/3dhub.io/common"
+)
+
+// The name of the state file, kept in the directory being imported
+const stateFileName = ".3dhub-import.json"
+
+var (
+	// The API server, its client, and the user the client certificate is for
+	apiClient *http.Client
+	apiServer string
+	owner     string
+
+	// Matches a version suffix on the name of a model file (without its extension), eg "bracket_v2" or "bracket
+	// version 1.3"
+	regexVersion = regexp.MustCompile(`(?i)^(.*?[^ _.-])[ _.-]*v(?:er(?:sion)?)?[ _.-]?([0-9]+(?:\.[0-9]+)*)$`)
+)
+
+// Closes the archive a model file is being read from, along with the file
+type archiveReadCloser struct {
+	io.ReadCloser
+	archive *zip.ReadCloser
+}
+
+func (a archiveReadCloser) Close() error {
+	a.ReadCloser.Close()
+	return a.archive.Close()
+}
+
+// What's been uploaded so far.  It's saved after each upload, so an interrupted import can carry on from there.
+type importState struct {
+	Owner    string                   `json:"owner"`
+	Projects map[string]*stateProject `json:"projects"`
+}
+
+// A project to be created, with the versions of it to upload
+type project struct {
+	Conflict string // Set when the files can't be imported as the one project, saying why
+	Name     string
+	Source   string // The URL of the Thingiverse thing the project is from, if it's from one
+	Versions []version
+}
+
+// The versions of a project which have been uploaded, and the latest commit on the server the next one builds on
+type stateProject struct {
+	Branch   string   `json:"branch"`
+	Commit   string   `json:"commit_id"`
+	Uploaded []string `json:"uploaded"`
+}
+
+// A model file to be uploaded as a version of a project
+type version struct {
+	CommitMsg string
+	Licence   string
+	ModTime   time.Time
+	Number    []int // From the version suffix of the file name, if it has one
+	Open      func() (io.ReadCloser, error)
+	Path      string // Where the file is, for the messages about it
+	SourceURL string
+}
+
+func main() {
+	certFile := flag.String("cert", "", "Client certificate (with its key) from the Preferences page of the website")
+	caFile := flag.String("ca", "", "CA chain to verify the server with, if it uses a self signed certificate")
+	serverURL := flag.String("server", "", "API server URL (defaults to the server named in the client "+
+		"certificate, on port 5550)")
+	licence := flag.String("licence", "", "Licence for the projects, other than ones from Thingiverse")
+	public := flag.Bool("public", false, "Make the new projects public")
+	scrub := flag.Bool("scrub", false, "Remove details identifying the creator (eg author names) from the models")
+	dryRun := flag.Bool("dry-run", false, "Show what would be uploaded, without changing anything")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage:\n  %s -cert FILE [options] DIRECTORY\n\nOptions:\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 || *certFile == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	dir := flag.Arg(0)
+
+	err := connect(*certFile, *caFile, *serverURL)
+	if err != nil {
+		com.Log.Fatal(err)
+	}
+
+	// Work out the projects to create from the files in the directory
+	projects, problems, err := scan(dir, *licence)
+	if err != nil {
+		com.Log.Fatal(err)
+	}
+	if len(projects) == 0 {
+		com.Log.Fatalf("No model files were found in '%s'", dir)
+	}
+
+	// Load the details of any earlier run of the import
+	statePath := filepath.Join(dir, stateFileName)
+	state, err := loadState(statePath)
+	if err != nil {
+		com.Log.Fatal(err)
+	}
+
+	var numUploaded, numSkipped int
+	for _, p := range projects {
+		if p.Conflict != "" {
+			com.Log.Warnf("Skipping '%s': %s", p.Name, p.Conflict)
+			problems++
+			continue
+		}
+
+		// Projects which exist on the server, but weren't created by the import, are left alone
+		sp := state.Projects[p.Name]
+		if sp == nil {
+			exists, err := projectExists(p.Name)
+			if err != nil {
+				com.Log.Errorf("Couldn't check whether '%s' exists already: %v", p.Name, err)
+				problems++
+				continue
+			}
+			if exists {
+				com.Log.Warnf("Skipping '%s', as you already have a project of that name", p.Name)
+				problems++
+				continue
+			}
+			sp = &stateProject{}
+		}
+
+		for i, v := range p.Versions {
+			sha, size, err := fileHash(v)
+			if err != nil {
+				com.Log.Errorf("Couldn't read '%s': %v", v.Path, err)
+				problems++
+				break
+			}
+			if uploaded(sp, sha) {
+				numSkipped++
+				continue
+			}
+			if *dryRun {
+				com.Log.Infof("Would upload '%s' as version %d of '%s'", v.Path, i+1, p.Name)
+				numUploaded++
+				continue
+			}
+			err = upload(p.Name, v, sp, size, *public, *scrub)
+			if err != nil {
+				// The later versions build on this one, so they're left for the next run
+				com.Log.Errorf("Uploading '%s' to '%s' failed: %v", v.Path, p.Name, err)
+				problems++
+				break
+			}
+			sp.Uploaded = append(sp.Uploaded, sha)
+			state.Projects[p.Name] = sp
+			if err = saveState(statePath, state); err != nil {
+				com.Log.Fatal(err)
+			}
+			com.Log.Infof("Uploaded '%s' as version %d of '%s'", v.Path, i+1, p.Name)
+			numUploaded++
+		}
+	}
+
+	if *dryRun {
+		com.Log.Infof("Dry run complete.  %d files would be uploaded, %d were uploaded previously, %d problems",
+			numUploaded, numSkipped, problems)
+	} else {
+		com.Log.Infof("Import complete.  %d files uploaded, %d were uploaded previously, %d problems", numUploaded,
+			numSkipped, problems)
+	}
+	if problems != 0 {
+		os.Exit(1)
+	}
+}
+
+// Adds the model files of a thing from Thingiverse.  Each of them becomes its own project.
+func addThing(projects map[string]*project, thing com.ImportedThing, where string, modTime time.Time) {
+	sourceURL := thing.SourceURL
+	if com.Validate.Var(sourceURL, "url,min=5,max=255") != nil {
+		sourceURL = ""
+	}
+	commitMsg := fmt.Sprintf("Imported from %s.  Originally created by %s: <%s>", thing.Site, thing.Creator,
+		thing.SourceURL)
+	if com.ValidateMarkdown(commitMsg) != nil {
+		commitMsg = fmt.Sprintf("Imported from %s", thing.Site)
+	}
+	for _, f := range thing.Files {
+		addVersion(projects, com.NormaliseName(f.Name), thing.SourceURL, version{
+			CommitMsg: commitMsg,
+			Licence:   thing.Licence,
+			ModTime:   modTime,
+			Open:      f.Open,
+			Path:      filepath.Join(where, f.Name),
+			SourceURL: sourceURL,
+		})
+	}
+}
+
+// Adds a version to the project of the given name, creating the project if needed.  Versions from different places
+// (eg two Thingiverse things with a file of the same name) can't be combined, so the project is marked as conflicting
+// instead.
+func addVersion(projects map[string]*project, name string, source string, v version) {
+	p := projects[name]
+	if p == nil {
+		projects[name] = &project{Name: name, Source: source, Versions: []version{v}}
+		return
+	}
+	if p.Source != source || source != "" {
+		p.Conflict = fmt.Sprintf("'%s' and '%s' are from different places, so can't be versions of the same "+
+			"project", p.Versions[0].Path, v.Path)
+	}
+	p.Versions = append(p.Versions, v)
+}
+
+// Returns a function opening a model file in a Thingiverse export archive.
+func archiveFile(archivePath string, name string) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		z, err := zip.OpenReader(archivePath)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range z.File {
+			if path.Base(f.Name) == name && !f.FileInfo().IsDir() {
+				rc, err := f.Open()
+				if err != nil {
+					z.Close()
+					return nil, err
+				}
+				return archiveReadCloser{rc, z}, nil
+			}
+		}
+		z.Close()
+		return nil, fmt.Errorf("'%s' isn't in the archive", name)
+	}
+}
+
+// Compares two version numbers, returning -1, 0, or 1.  Files without a version number come first.
+func compareVersions(a []int, b []int) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case len(a) < len(b):
+		return -1
+	case len(a) > len(b):
+		return 1
+	}
+	return 0
+}
+
+// Loads the client certificate, and sets up the client for the API server with it.
+func connect(certFile string, caFile string, serverURL string) error {
+	cert, err := tls.LoadX509KeyPair(certFile, certFile)
+	if err != nil {
+		return fmt.Errorf("Loading the client certificate failed: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("Reading the client certificate failed: %v", err)
+	}
+
+	// The common name of the certificate is the user and the server it's for, eg "someuser@3dhub.io"
+	s := strings.Split(leaf.Subject.CommonName, "@")
+	if len(s) != 2 || s[0] == "" || s[1] == "" {
+		return errors.New("Missing information in client certificate")
+	}
+	owner = s[0]
+	apiServer = strings.TrimSuffix(serverURL, "/")
+	if apiServer == "" {
+		apiServer = fmt.Sprintf("https://%s:5550", s[1])
+	}
+
+	tlsConf := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if caFile != "" {
+		data, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return err
+		}
+		tlsConf.RootCAs = x509.NewCertPool()
+		if !tlsConf.RootCAs.AppendCertsFromPEM(data) {
+			return fmt.Errorf("No certificates were found in '%s'", caFile)
+		}
+	}
+	apiClient = &http.Client{
+		Timeout:   10 * time.Minute, // Model files can be large
+		Transport: &http.Transport{TLSClientConfig: tlsConf},
+	}
+	return nil
+}
+
+// Returns the SHA256 and size of a file to be uploaded.
+func fileHash(v version) (sha string, size int64, err error) {
+	rc, err := v.Open()
+	if err != nil {
+		return
+	}
+	defer rc.Close()
+	h := sha256.New()
+	size, err = io.Copy(h, rc)
+	if err != nil {
+		return
+	}
+	if size > com.MaxFileSize*1024*1024 {
+		err = fmt.Errorf("The file is larger than the maximum file size of %d MB", com.MaxFileSize)
+		return
+	}
+	return hex.EncodeToString(h.Sum(nil)), size, nil
+}
+
+// Loads the state file from an earlier run of the import, if there is one.
+func loadState(statePath string) (state importState, err error) {
+	data, err := ioutil.ReadFile(statePath)
+	if os.IsNotExist(err) {
+		return importState{Owner: owner, Projects: make(map[string]*stateProject)}, nil
+	}
+	if err != nil {
+		return
+	}
+	if err = json.Unmarshal(data, &state); err != nil {
+		return state, fmt.Errorf("Reading the state file '%s' failed: %v", statePath, err)
+	}
+	if !strings.EqualFold(state.Owner, owner) {
+		return state, fmt.Errorf("The state file '%s' is from an import by '%s', not '%s'", statePath, state.Owner,
+			owner)
+	}
+	if state.Projects == nil {
+		state.Projects = make(map[string]*stateProject)
+	}
+	return
+}
+
+// Reports whether the user has a project of the given name on the server.
+func projectExists(name string) (bool, error) {
+	q := url.Values{"username": {owner}, "folder": {"/"}, "dbname": {name}}
+	resp, err := apiClient.Get(apiServer + "/branch/list?" + q.Encode())
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, responseError(resp)
+	}
+	var list struct {
+		Branches map[string]com.BranchEntry `json:"branches"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return false, err
+	}
+	return len(list.Branches) != 0, nil
+}
+
+// Returns the error message from an unsuccessful API response.
+func responseError(resp *http.Response) error {
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	if m := strings.TrimSpace(string(msg)); m != "" {
+		return fmt.Errorf("%s: %s", resp.Status, m)
+	}
+	return errors.New(resp.Status)
+}
+
+// Saves the state of the import.  It's written to a temporary file first, so an interruption can't leave it half
+// written.
+func saveState(statePath string, state importState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := statePath + ".tmp"
+	if err = ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, statePath)
+}
+
+// Walks the directory being imported, returning the projects to create from the files in it.  The problems found
+// along the way (eg Thingiverse exports which can't be imported) are logged, and counted.
+func scan(dir string, licence string) (list []project, problems int, err error) {
+	projects := make(map[string]*project)
+	err = filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if p != dir && strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		// The directories Thingiverse export archives extract to have a README.txt and LICENSE.txt file in them
+		if info.IsDir() {
+			_, errReadme := os.Stat(filepath.Join(p, "README.txt"))
+			_, errLicence := os.Stat(filepath.Join(p, "LICENSE.txt"))
+			if errReadme != nil || errLicence != nil {
+				return nil
+			}
+			thing, err := com.ReadImportDirectory(p)
+			if err != nil {
+				com.Log.Warnf("Skipping '%s': %v", p, err)
+				problems++
+			} else {
+				addThing(projects, thing, p, info.ModTime())
+			}
+			return filepath.SkipDir
+		}
+
+		if strings.EqualFold(filepath.Ext(p), ".zip") {
+			f, err := os.Open(p)
+			if err != nil {
+				return err
+			}
+			thing, err := com.ReadImportArchive(f, info.Size())
+			f.Close()
+			if err != nil {
+				com.Log.Warnf("Skipping '%s': %v", p, err)
+				problems++
+				return nil
+			}
+
+			// The archive is opened again when each file is read from it, rather than being kept open throughout
+			for i := range thing.Files {
+				thing.Files[i].Open = archiveFile(p, thing.Files[i].Name)
+			}
+			addThing(projects, thing, p, info.ModTime())
+			return nil
+		}
+		if !com.IsImportFile(p) {
+			return nil
+		}
+
+		// Files with a version suffix on their name are versions of the project without it
+		ext := filepath.Ext(info.Name())
+		name := strings.TrimSuffix(info.Name(), ext)
+		var num []int
+		if m := regexVersion.FindStringSubmatch(name); m != nil {
+			name = m[1]
+			for _, n := range strings.Split(m[2], ".") {
+				i, _ := strconv.Atoi(n)
+				num = append(num, i)
+			}
+		}
+		filePath := p
+		addVersion(projects, com.NormaliseName(name+ext), "", version{
+			CommitMsg: fmt.Sprintf("Imported %s", info.Name()),
+			Licence:   licence,
+			ModTime:   info.ModTime(),
+			Number:    num,
+			Open: func() (io.ReadCloser, error) {
+				return os.Open(filePath)
+			},
+			Path: p,
+		})
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	for _, p := range projects {
+		if p.Conflict == "" {
+			if err := com.ValidateFileName(p.Name); err != nil {
+				p.Conflict = "it isn't a valid project name"
+			}
+		}
+		sort.SliceStable(p.Versions, func(i, j int) bool {
+			a, b := p.Versions[i], p.Versions[j]
+			if c := compareVersions(a.Number, b.Number); c != 0 {
+				return c < 0
+			}
+			return a.ModTime.Before(b.ModTime)
+		})
+		list = append(list, *p)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return
+}
+
+// Uploads a version of a project, building on the last version uploaded by the import.
+func upload(name string, v version, sp *stateProject, size int64, public bool, scrub bool) error {
+	rc, err := v.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("%s/v1/%s/%s", apiServer, url.PathEscape(owner),
+		url.PathEscape(name)), rc)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("X-Commit-Message", v.CommitMsg)
+	req.Header.Set("X-Last-Modified", v.ModTime.UTC().Format(time.RFC3339))
+	req.Header.Set("X-Public", strconv.FormatBool(public))
+	req.Header.Set("X-Scrub-Metadata", strconv.FormatBool(scrub))
+	if v.Licence != "" {
+		req.Header.Set("X-Licence", v.Licence)
+	}
+	if v.SourceURL != "" {
+		req.Header.Set("X-Source-URL", v.SourceURL)
+	}
+	if sp.Commit != "" {
+		req.Header.Set("X-Branch", sp.Branch)
+		req.Header.Set("X-Commit", sp.Commit)
+	}
+	resp, err := apiClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return responseError(resp)
+	}
+	var result struct {
+		CommitID string `json:"commit_id"`
+		URL      string `json:"url"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+
+	// New projects start out on the default branch
+	if sp.Branch == "" {
+		sp.Branch = "master"
+		if u, err := url.Parse(result.URL); err == nil && u.Query().Get("branch") != "" {
+			sp.Branch = u.Query().Get("branch")
+		}
+	}
+	sp.Commit = result.CommitID
+	return nil
+}
+
+// Reports whether a file has been uploaded to a project already.
+func uploaded(sp *stateProject, sha string) bool {
+	for _, s := range sp.Uploaded {
+		if s == sha {
+			return true
+		}
+	}
+	return false
+}
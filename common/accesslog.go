@@ -0,0 +1,88 @@
+package common
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// Owners can see the requests made for their projects (page views, downloads, and API calls), taken from the request
+// logging.  The requests are held in memory and added to PostgreSQL in batches, like the usage statistics.  Client
+// addresses are anonymised before they're stored, and the entries are removed after a configurable number of days.
+
+// The most requests held in memory between flushes.  Anything past this is dropped, so a PostgreSQL outage can't use
+// up all the memory
+const maxPendingAccesses = 100000
+
+// How often old access log entries are removed
+const accessLogPruneInterval = time.Hour
+
+// A request for a project waiting to be added to its access log
+type projectAccess struct {
+	ProjectAccess
+	fileName string
+	folder   string
+	owner    string
+}
+
+// Requests recorded since the access logs were last flushed to PostgreSQL
+var (
+	pendingAccesses   []projectAccess
+	pendingAccessesMu sync.Mutex
+)
+
+// Removes the host part of a client address, so the access logs don't identify people.  IPv4 addresses keep their
+// first three bytes, and IPv6 addresses their first six.
+func AnonymiseIP(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return ""
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(48, 128)).String()
+}
+
+// Periodically adds the recorded requests to the project access logs in PostgreSQL, and removes the entries which
+// are older than the configured number of days.
+func FlushProjectAccessLog() {
+	Log.Infof("Project access log flushing loop started.  %d second refresh.", Conf().Memcache.ViewCountFlushDelay)
+	var lastPruned time.Time
+	for {
+		time.Sleep(Conf().Memcache.ViewCountFlushDelay * time.Second)
+
+		// Take the requests recorded so far, so new ones can be recorded while these are being stored
+		pendingAccessesMu.Lock()
+		list := pendingAccesses
+		pendingAccesses = nil
+		pendingAccessesMu.Unlock()
+		if len(list) > 0 {
+			err := storeProjectAccesses(list)
+			if err != nil {
+				Log.Errorf("Storing %d project access log entries failed: %v", len(list), err)
+			}
+		}
+
+		// When several instances are running, only one of them removes the old entries
+		if time.Since(lastPruned) > accessLogPruneInterval && HoldJobLock("prune-access-log", accessLogPruneInterval) {
+			PruneProjectAccessLog(Conf().Web.AccessLogDays)
+			lastPruned = time.Now()
+		}
+	}
+}
+
+// Records a request for a project, for its owner's access log.
+func RecordProjectAccess(owner string, folder string, fileName string, a ProjectAccess) {
+	a.Client = AnonymiseIP(a.Client)
+	pendingAccessesMu.Lock()
+	defer pendingAccessesMu.Unlock()
+	if len(pendingAccesses) >= maxPendingAccesses {
+		return
+	}
+	pendingAccesses = append(pendingAccesses, projectAccess{ProjectAccess: a, fileName: fileName, folder: folder,
+		owner: owner})
+}
@@ -0,0 +1,468 @@
+package common
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go"
+	"golang.org/x/crypto/argon2"
+)
+
+// A backup is a gzipped tar archive holding a pg_dump of the PostgreSQL metadata, along with every database file in
+// Minio referenced by a commit.  They're written by the 3dhub-backup utility, and on a schedule by the webui server,
+// which encrypts them and uploads them to an S3 bucket.
+//
+// Encrypted archives start with a header holding a random salt and nonce prefix, followed by the archive in chunks
+// sealed with AES-256-GCM.  The key is derived from the configured passphrase using Argon2id.  Each chunk's nonce
+// includes its position, and the last chunk is marked as such, so chunks can't be reordered, dropped, or truncated
+// without it being noticed.
+
+const (
+	// Names of the entries in the backup archive
+	BackupManifestName = "manifest.json"
+	BackupMetadataName = "postgresql.dump"
+	BackupObjectPrefix = "minio/"
+
+	// How often the webui server checks whether a scheduled backup is due
+	backupCheckInterval = 5 * time.Minute
+
+	// The start of encrypted backup archives, and the size of each encrypted chunk
+	backupChunkSize = 64 * 1024
+	backupMagic     = "3DHUBENC"
+)
+
+// Summary of a backup, stored as the first entry in its archive
+type BackupManifest struct {
+	Created    time.Time `json:"created"`
+	NumObjects int       `json:"num_objects"`
+	Server     string    `json:"server"`
+}
+
+// Decrypts an encrypted backup archive as it's read.
+type backupDecrypter struct {
+	aead    cipher.AEAD
+	buf     []byte
+	chunk   []byte
+	counter uint64
+	done    bool
+	prefix  []byte
+	r       *bufio.Reader
+}
+
+// Encrypts a backup archive as it's written.  Close() needs to be called to write the last chunk.
+type backupEncrypter struct {
+	aead    cipher.AEAD
+	buf     []byte
+	counter uint64
+	prefix  []byte
+	w       io.Writer
+}
+
+func (d *backupDecrypter) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+
+		// A short chunk is the last one, as is a full one with nothing after it
+		n, err := io.ReadFull(d.r, d.chunk)
+		final := false
+		switch err {
+		case nil:
+			if _, err = d.r.Peek(1); err == io.EOF {
+				final = true
+			} else if err != nil {
+				return 0, err
+			}
+		case io.ErrUnexpectedEOF:
+			final = true
+		case io.EOF:
+			return 0, errors.New("The backup archive has been truncated")
+		default:
+			return 0, err
+		}
+		d.buf, err = d.aead.Open(d.chunk[:0], backupNonce(d.prefix, d.counter), d.chunk[:n], backupChunkAD(final))
+		if err != nil {
+			return 0, errors.New("The backup archive couldn't be decrypted.  Either the passphrase is wrong, or " +
+				"the archive has been corrupted")
+		}
+		d.counter++
+		d.done = final
+	}
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
+
+func (e *backupEncrypter) Close() error {
+	return e.seal(true)
+}
+
+// Seals the buffered data as the next chunk, and writes it out.
+func (e *backupEncrypter) seal(final bool) error {
+	out := e.aead.Seal(nil, backupNonce(e.prefix, e.counter), e.buf, backupChunkAD(final))
+	e.counter++
+	e.buf = e.buf[:0]
+	_, err := e.w.Write(out)
+	return err
+}
+
+func (e *backupEncrypter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		// A full buffer is only written out once there's more data, as the last chunk needs to be marked as such
+		if len(e.buf) == backupChunkSize {
+			if err := e.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(e.buf[len(e.buf):backupChunkSize], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// Returns the cipher for encrypted backups, with the key derived from the passphrase and salt.
+func backupCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	if passphrase == "" {
+		return nil, errors.New("No passphrase has been set for encrypting backups")
+	}
+	block, err := aes.NewCipher(argon2.IDKey([]byte(passphrase), salt, 1, 64*1024, 4, 32))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Returns the additional data sealed with each encrypted chunk, which marks the last one.
+func backupChunkAD(final bool) []byte {
+	if final {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+// Returns the nonce for an encrypted chunk, from the random prefix and the chunk's position.
+func backupNonce(prefix []byte, counter uint64) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint64(nonce[4:], counter)
+	return nonce
+}
+
+// Checks whether a scheduled backup is due, and queues it if so.  Only one of the webui servers does the checking.
+func BackupLoop() {
+	for {
+		interval := time.Duration(Conf().Backup.IntervalHours) * time.Hour
+		if interval > 0 && Conf().Backup.Bucket != "" && HoldJobLock("backup", backupCheckInterval) {
+			last, err := LastBackupQueued()
+			if err == nil && time.Since(last) >= interval {
+				_, err = QueueBackup()
+			}
+			if err != nil {
+				Log.Errorf("Error when checking for a scheduled backup: %v", err)
+			}
+		}
+		time.Sleep(backupCheckInterval)
+	}
+}
+
+// Runs a backup queued by QueueBackup(), recording how it went.  The payload holds the ID of the backup run.
+func BackupJob(payload json.RawMessage) error {
+	var p struct {
+		ID int64 `json:"id"`
+	}
+	err := json.Unmarshal(payload, &p)
+	if err != nil {
+		return err
+	}
+	err = StartBackupRun(p.ID)
+	if err != nil {
+		return err
+	}
+	objectName, size, numObjects, backupErr := uploadBackup()
+	err = StoreBackupResult(p.ID, objectName, size, numObjects, backupErr)
+	if err != nil {
+		return err
+	}
+	return backupErr
+}
+
+// Returns whether the start of a file is the header of an encrypted backup archive.
+func IsEncryptedBackup(header []byte) bool {
+	return bytes.HasPrefix(header, []byte(backupMagic))
+}
+
+// Returns a reader which decrypts the encrypted backup archive being read from r.
+func NewBackupDecrypter(r io.Reader, passphrase string) (io.Reader, error) {
+	header := make([]byte, len(backupMagic)+16+4)
+	_, err := io.ReadFull(r, header)
+	if err != nil || !IsEncryptedBackup(header) {
+		return nil, errors.New("Not an encrypted 3DHub.io backup archive")
+	}
+	salt := header[len(backupMagic) : len(backupMagic)+16]
+	aead, err := backupCipher(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	return &backupDecrypter{
+		aead:   aead,
+		chunk:  make([]byte, backupChunkSize+aead.Overhead()),
+		prefix: header[len(backupMagic)+16:],
+		r:      bufio.NewReader(r),
+	}, nil
+}
+
+// Returns a writer which encrypts a backup archive with the passphrase, writing it to w.  The writer needs to be
+// closed once the archive has been written.
+func NewBackupEncrypter(w io.Writer, passphrase string) (io.WriteCloser, error) {
+	header := make([]byte, len(backupMagic)+16+4)
+	copy(header, backupMagic)
+	_, err := rand.Read(header[len(backupMagic):])
+	if err != nil {
+		return nil, err
+	}
+	salt := header[len(backupMagic) : len(backupMagic)+16]
+	aead, err := backupCipher(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(header); err != nil {
+		return nil, err
+	}
+	return &backupEncrypter{
+		aead:   aead,
+		buf:    make([]byte, 0, backupChunkSize),
+		prefix: header[len(backupMagic)+16:],
+		w:      w,
+	}, nil
+}
+
+// Runs one of the PostgreSQL command line utilities against the configured database.  The connection details are
+// passed using the standard libpq environment variables, so the password doesn't show up in the process list.
+func PgCommand(name string, args ...string) error {
+	sslMode := "disable"
+	if Conf().Pg.SSL {
+		sslMode = "require"
+	}
+	cmd := exec.Command(name, args...)
+	cmd.Env = append(os.Environ(),
+		"PGDATABASE="+Conf().Pg.Database,
+		"PGHOST="+Conf().Pg.Server,
+		"PGPASSWORD="+Conf().Pg.Password,
+		"PGPORT="+strconv.Itoa(Conf().Pg.Port),
+		"PGSSLMODE="+sslMode,
+		"PGUSER="+Conf().Pg.Username)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s failed: %v\n%s", name, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// Removes the oldest backups in the bucket, leaving the configured number of them.
+func pruneBackups(client *minio.Client) error {
+	done := make(chan struct{})
+	defer close(done)
+	var names []string
+	for obj := range client.ListObjectsV2(Conf().Backup.Bucket, Conf().Backup.Prefix+"3dhub-backup-", false, done) {
+		if obj.Err != nil {
+			return obj.Err
+		}
+		names = append(names, obj.Key)
+	}
+
+	// The names include the time of the backup, so sort oldest first
+	sort.Strings(names)
+	for i := 0; i < len(names)-Conf().Backup.Keep; i++ {
+		err := client.RemoveObject(Conf().Backup.Bucket, names[i])
+		if err != nil {
+			return err
+		}
+		Log.Infof("Removed old backup '%s' from the backup bucket", names[i])
+	}
+	return nil
+}
+
+// Queues a backup to be run in the background, returning the ID of the backup run.
+func QueueBackup() (id int64, err error) {
+	id, err = AddBackupRun()
+	if err != nil {
+		return
+	}
+	_, err = QueueJob("backup", map[string]int64{"id": id})
+	return
+}
+
+// Writes an encrypted backup to a temporary file, then uploads it to the backup bucket.  Returns the name of the
+// uploaded backup, its size, and the number of database files in it.
+func uploadBackup() (objectName string, size int64, numObjects int, err error) {
+	b := Conf().Backup
+	if b.Bucket == "" {
+		err = errors.New("No bucket has been set for backups")
+		return
+	}
+	client, err := minio.NewWithRegion(b.Server, b.AccessKey, b.Secret, b.HTTPS, b.Region)
+	if err != nil {
+		err = fmt.Errorf("Problem with the backup bucket configuration: %v", err)
+		return
+	}
+
+	// Write the encrypted archive
+	tmp, err := ioutil.TempFile(Conf().DiskCache.Directory, "3dhub-backup-")
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	enc, err := NewBackupEncrypter(tmp, b.Passphrase)
+	if err != nil {
+		return
+	}
+	numObjects, err = WriteBackup(enc)
+	if err != nil {
+		return
+	}
+	if err = enc.Close(); err != nil {
+		return
+	}
+	if err = tmp.Sync(); err != nil {
+		return
+	}
+
+	// Upload it
+	objectName = b.Prefix + "3dhub-backup-" + time.Now().UTC().Format("20060102-150405") + ".tar.gz.enc"
+	size, err = client.FPutObject(b.Bucket, objectName, tmp.Name(),
+		minio.PutObjectOptions{ContentType: "application/octet-stream"})
+	if err != nil {
+		err = fmt.Errorf("Uploading the backup failed: %v", err)
+		return
+	}
+	Log.Infof("Backup uploaded to '%s', with %d database files", objectName, numObjects)
+
+	// Remove the old backups.  The new one is safely stored, so failing here isn't counted as the backup failing
+	if b.Keep > 0 {
+		if pruneErr := pruneBackups(client); pruneErr != nil {
+			Log.Warnf("Removing old backups from the backup bucket failed: %v", pruneErr)
+		}
+	}
+	return
+}
+
+// Writes a backup archive of the PostgreSQL metadata and the Minio objects it references.  Returns the number of
+// database files in it.
+func WriteBackup(w io.Writer) (numObjects int, err error) {
+	// Dump the metadata first, so every object it references is in the list retrieved afterwards.  Files uploaded
+	// in between won't be in the dump, but they're harmless extras in the archive
+	tmp, err := ioutil.TempFile(Conf().DiskCache.Directory, "3dhub-dump-")
+	if err != nil {
+		return
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	err = PgCommand("pg_dump", "--format=custom", "--no-owner", "--file="+tmp.Name())
+	if err != nil {
+		return
+	}
+	dump, err := os.Open(tmp.Name())
+	if err != nil {
+		return
+	}
+	defer dump.Close()
+	shas, err := AllDatabaseFiles()
+	if err != nil {
+		return
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	// Add the manifest and the metadata
+	m, err := json.MarshalIndent(BackupManifest{
+		Created:    time.Now().UTC(),
+		NumObjects: len(shas),
+		Server:     Conf().Web.ServerName,
+	}, "", "  ")
+	if err != nil {
+		return
+	}
+	err = tw.WriteHeader(&tar.Header{Name: BackupManifestName, Mode: 0600, Size: int64(len(m)), ModTime: time.Now()})
+	if err != nil {
+		return
+	}
+	if _, err = tw.Write(m); err != nil {
+		return
+	}
+	info, err := dump.Stat()
+	if err != nil {
+		return
+	}
+	err = tw.WriteHeader(&tar.Header{Name: BackupMetadataName, Mode: 0600, Size: info.Size(),
+		ModTime: info.ModTime()})
+	if err != nil {
+		return
+	}
+	if _, err = io.Copy(tw, dump); err != nil {
+		return
+	}
+
+	// Add the Minio objects
+	for i, sha := range shas {
+		if len(sha) <= MinioFolderChars {
+			Log.Warnf("Skipping database file with invalid sha256 '%s'", sha)
+			continue
+		}
+		var obj *minio.Object
+		obj, err = MinioHandle(sha[:MinioFolderChars], sha[MinioFolderChars:])
+		if err != nil {
+			return
+		}
+		var objInfo minio.ObjectInfo
+		objInfo, err = obj.Stat()
+		if err != nil {
+			MinioHandleClose(obj)
+			err = fmt.Errorf("Retrieving details of database file '%s' failed: %v", sha, err)
+			return
+		}
+		err = tw.WriteHeader(&tar.Header{Name: BackupObjectPrefix + sha, Mode: 0600, Size: objInfo.Size,
+			ModTime: objInfo.LastModified})
+		if err == nil {
+			_, err = io.Copy(tw, obj)
+		}
+		MinioHandleClose(obj)
+		if err != nil {
+			err = fmt.Errorf("Adding database file '%s' to the backup failed: %v", sha, err)
+			return
+		}
+		numObjects++
+		if (i+1)%100 == 0 {
+			Log.Infof("%d of %d database files backed up", i+1, len(shas))
+		}
+	}
+
+	// Finish writing the archive
+	if err = tw.Close(); err != nil {
+		return
+	}
+	err = gz.Close()
+	return
+}
@@ -0,0 +1,201 @@
+package common
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Hosted deployments can limit the number of private projects each user has, based on the plan they're on.  Users
+// subscribe to the paid plans using Stripe payment links, and Stripe's webhooks keep the plans stored for them up to
+// date.  Nothing is limited unless billing is enabled in the configuration file.
+
+// The plan users are on when they don't have a subscription
+const FreePlan = "free"
+
+// How old a Stripe webhook request can be before it's refused, to stop old requests being replayed
+const stripeSignatureTolerance = 5 * time.Minute
+
+// The Stripe subscription states which keep the user on their paid plan.  Payments which have failed are retried by
+// Stripe for a while, so users aren't downgraded straight away
+var stripeActiveStates = map[string]bool{"active": true, "past_due": true, "trialing": true}
+
+// Returns the link for subscribing to a plan, which lets Stripe tell us which user subscribed.
+func BillingCheckoutURL(plan BillingPlan, userName string) string {
+	sep := "?"
+	if strings.Contains(plan.CheckoutURL, "?") {
+		sep = "&"
+	}
+	return plan.CheckoutURL + sep + "client_reference_id=" + url.QueryEscape(userName)
+}
+
+// Returns the details of a paid plan.
+func BillingPlanByID(id string) (plan BillingPlan, found bool) {
+	for _, p := range Conf().Billing.Plans {
+		if p.ID == id {
+			return p, true
+		}
+	}
+	return
+}
+
+// Checks the user can have another private project, returning an error explaining why not if they can't.
+func CheckPrivateQuota(userName string) error {
+	if !Conf().Billing.Enabled {
+		return nil
+	}
+	limit, err := PrivateProjectLimit(userName)
+	if err != nil {
+		return err
+	}
+	count, err := PrivateProjectCount(userName)
+	if err != nil {
+		return err
+	}
+	if count >= limit {
+		if limit == 0 {
+			return errors.New("Your plan doesn't include private projects.  You can upgrade from your " +
+				"preferences page, or make the project public")
+		}
+		return fmt.Errorf("Your plan includes %d private projects, which you've already used.  You can upgrade "+
+			"from your preferences page, or make the project public", limit)
+	}
+	return nil
+}
+
+// Checks the signature Stripe gives a webhook request (in its Stripe-Signature header) was made using our webhook
+// secret, and isn't too old.
+func checkStripeSignature(body []byte, header string) bool {
+	secret := Conf().Billing.WebhookSecret
+	if secret == "" {
+		return false
+	}
+	var timestamp string
+	var signatures [][]byte
+	for _, part := range strings.Split(header, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "t":
+			timestamp = kv[1]
+		case "v1":
+			if sig, err := hex.DecodeString(kv[1]); err == nil {
+				signatures = append(signatures, sig)
+			}
+		}
+	}
+	t, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	if age := time.Since(time.Unix(t, 0)); age > stripeSignatureTolerance || age < -stripeSignatureTolerance {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	want := mac.Sum(nil)
+	for _, sig := range signatures {
+		if hmac.Equal(sig, want) {
+			return true
+		}
+	}
+	return false
+}
+
+// Applies a webhook event from Stripe to the plans stored for our users.  Subscription events for customers we don't
+// know yet return an error, so Stripe retries them after the checkout session completes.
+func HandleStripeEvent(body []byte, signature string) error {
+	if !checkStripeSignature(body, signature) {
+		return errors.New("The signature doesn't match")
+	}
+	var event struct {
+		Type string `json:"type"`
+		Data struct {
+			Object json.RawMessage `json:"object"`
+		} `json:"data"`
+	}
+	err := json.Unmarshal(body, &event)
+	if err != nil {
+		return err
+	}
+
+	switch event.Type {
+	case "checkout.session.completed":
+		var session struct {
+			ClientReferenceID string `json:"client_reference_id"`
+			Customer          string `json:"customer"`
+			Subscription      string `json:"subscription"`
+		}
+		err = json.Unmarshal(event.Data.Object, &session)
+		if err != nil {
+			return err
+		}
+		if session.ClientReferenceID == "" || session.Customer == "" {
+			// Not one of our payment links
+			return nil
+		}
+		return LinkStripeCustomer(session.ClientReferenceID, session.Customer, session.Subscription)
+
+	case "customer.subscription.created", "customer.subscription.updated", "customer.subscription.deleted":
+		var sub struct {
+			Customer string `json:"customer"`
+			ID       string `json:"id"`
+			Items    struct {
+				Data []struct {
+					Price struct {
+						ID string `json:"id"`
+					} `json:"price"`
+				} `json:"data"`
+			} `json:"items"`
+			Status string `json:"status"`
+		}
+		err = json.Unmarshal(event.Data.Object, &sub)
+		if err != nil {
+			return err
+		}
+
+		// Work out the plan the subscription is for.  Anything other than a live subscription to one of our plans
+		// puts the user back on the free plan
+		plan := FreePlan
+		if event.Type != "customer.subscription.deleted" && stripeActiveStates[sub.Status] {
+			for _, item := range sub.Items.Data {
+				for _, p := range Conf().Billing.Plans {
+					if p.StripePrice != "" && p.StripePrice == item.Price.ID {
+						plan = p.ID
+					}
+				}
+			}
+		}
+		found, err := SetStripeCustomerPlan(sub.Customer, sub.ID, plan)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("Unknown Stripe customer '%s'", sub.Customer)
+		}
+		Log.Infof("Stripe customer '%s' is now on the '%s' plan", sub.Customer, plan)
+	}
+	return nil
+}
+
+// Returns the number of private projects a user can have on their current plan.
+func PrivateProjectLimit(userName string) (int, error) {
+	planID, err := UserPlan(userName)
+	if err != nil {
+		return 0, err
+	}
+	if p, ok := BillingPlanByID(planID); ok {
+		return p.PrivateProjects, nil
+	}
+	return Conf().Billing.FreePrivateProjects, nil
+}
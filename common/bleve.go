@@ -0,0 +1,209 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// The Bleve search backend.  Bleve is a library rather than a server, so this talks to a Bleve index server using
+// the REST API from the bleve-explorer project (https://github.com/blevesearch/bleve-explorer)
+type bleveSearch struct {
+	index  string
+	server string
+}
+
+// The field mappings for the Bleve index.  The keyword fields are lower cased when indexed, so the filters on them
+// (which aren't analysed) are lower cased before use as well.
+const bleveMapping = `{
+	"default_analyzer": "en",
+	"analysis": {
+		"analyzers": {
+			"lowercase_keyword": {"type": "custom", "tokenizer": "single", "token_filters": ["to_lower"]}
+		}
+	},
+	"default_mapping": {
+		"enabled": true,
+		"dynamic": true,
+		"properties": {
+			"category": {"fields": [{"name": "category", "type": "text", "analyzer": "keyword", "store": true, "index": true}]},
+			"date_created": {"fields": [{"name": "date_created", "type": "datetime", "store": true, "index": true}]},
+			"db_name": {"fields": [
+				{"name": "db_name", "type": "text", "analyzer": "lowercase_keyword", "store": true, "index": true},
+				{"name": "db_name_words", "type": "text", "analyzer": "simple", "index": true}
+			]},
+			"downloads": {"fields": [{"name": "downloads", "type": "number", "store": true, "index": true}]},
+			"folder": {"fields": [{"name": "folder", "type": "text", "analyzer": "keyword", "store": true, "index": true}]},
+			"full_description": {"fields": [{"name": "full_description", "type": "text", "analyzer": "en", "store": true, "index": true, "include_term_vectors": true}]},
+			"last_modified": {"fields": [{"name": "last_modified", "type": "datetime", "store": true, "index": true}]},
+			"licence": {"fields": [{"name": "licence", "type": "text", "analyzer": "lowercase_keyword", "store": true, "index": true}]},
+			"licence_url": {"fields": [{"name": "licence_url", "type": "text", "store": true, "index": false}]},
+			"one_line_description": {"fields": [{"name": "one_line_description", "type": "text", "analyzer": "en", "store": true, "index": true, "include_term_vectors": true}]},
+			"owner": {"fields": [{"name": "owner", "type": "text", "analyzer": "lowercase_keyword", "store": true, "index": true}]},
+			"project_tags": {"fields": [
+				{"name": "project_tags", "type": "text", "analyzer": "lowercase_keyword", "store": true, "index": true},
+				{"name": "project_tags_words", "type": "text", "analyzer": "en", "index": true}
+			]},
+			"public": {"fields": [{"name": "public", "type": "boolean", "store": true, "index": true}]},
+			"stars": {"fields": [{"name": "stars", "type": "number", "store": true, "index": true}]},
+			"triangle_count": {"fields": [{"name": "triangle_count", "type": "number", "store": true, "index": true}]}
+		}
+	}
+}`
+
+func (b bleveSearch) CreateIndex() error {
+	err := searchRequest(http.MethodDelete, b.server+"/api/"+b.index, nil, nil)
+	if err != nil {
+		return err
+	}
+	return searchRequest(http.MethodPut, b.server+"/api/"+b.index, json.RawMessage(bleveMapping), nil)
+}
+
+func (b bleveSearch) IndexProject(doc SearchDocument) error {
+	return searchRequest(http.MethodPut, fmt.Sprintf("%s/api/%s/%s", b.server, b.index,
+		searchDocID(doc.Owner, doc.Folder, doc.DBName)), doc, nil)
+}
+
+func (b bleveSearch) RemoveProject(owner string, folder string, fileName string) error {
+	return searchRequest(http.MethodDelete, fmt.Sprintf("%s/api/%s/%s", b.server, b.index,
+		searchDocID(owner, folder, fileName)), nil, nil)
+}
+
+func (b bleveSearch) Search(loggedInUser string, filters SearchFilters, offset int) (results []SearchResult,
+	total int, err error) {
+	type m map[string]interface{}
+
+	// Only public projects, plus the logged in user's own private ones
+	visible := []interface{}{m{"bool": true, "field": "public"}}
+	if loggedInUser != "" {
+		visible = append(visible, m{"term": strings.ToLower(loggedInUser), "field": "owner"})
+	}
+	conjuncts := []interface{}{m{"disjuncts": visible, "min": 1}}
+
+	// Add the other filters
+	if filters.Tag != "" {
+		conjuncts = append(conjuncts, m{"term": strings.ToLower(filters.Tag), "field": "project_tags"})
+	}
+	if filters.Category != "" {
+		conjuncts = append(conjuncts, m{"disjuncts": []interface{}{
+			m{"term": filters.Category, "field": "category"},
+			m{"prefix": filters.Category + "/", "field": "category"},
+		}, "min": 1})
+	}
+	if filters.Owner != "" {
+		conjuncts = append(conjuncts, m{"term": strings.ToLower(filters.Owner), "field": "owner"})
+	}
+	if filters.Format != "" {
+		conjuncts = append(conjuncts, m{"wildcard": "*." + strings.ToLower(filters.Format), "field": "db_name"})
+	}
+	if filters.Licence != "" {
+		conjuncts = append(conjuncts, m{"term": strings.ToLower(filters.Licence), "field": "licence"})
+	}
+	if filters.MinTris > 0 {
+		conjuncts = append(conjuncts, m{"min": filters.MinTris, "inclusive_min": true, "field": "triangle_count"})
+	}
+	switch filters.StarsOp {
+	case "=":
+		conjuncts = append(conjuncts, m{"min": filters.Stars, "inclusive_min": true, "max": filters.Stars,
+			"inclusive_max": true, "field": "stars"})
+	case ">", ">=":
+		conjuncts = append(conjuncts, m{"min": filters.Stars, "inclusive_min": filters.StarsOp == ">=",
+			"field": "stars"})
+	case "<", "<=":
+		conjuncts = append(conjuncts, m{"max": filters.Stars, "inclusive_max": filters.StarsOp == "<=",
+			"field": "stars"})
+	}
+
+	// Without any search text, all projects matching the filters are returned
+	if filters.Text != "" {
+		conjuncts = append(conjuncts, m{"disjuncts": []interface{}{
+			m{"match": filters.Text, "field": "db_name_words", "boost": 4},
+			m{"match": filters.Text, "field": "one_line_description", "boost": 2},
+			m{"match": filters.Text, "field": "project_tags_words", "boost": 2},
+			m{"match": filters.Text, "field": "full_description"},
+		}, "min": 1})
+	} else {
+		conjuncts = append(conjuncts, m{"match_all": m{}})
+	}
+
+	// Best matches first, unless a different sort order was requested
+	sort := []string{"-_score", "-stars", "-last_modified"}
+	switch filters.Sort {
+	case SORT_DOWNLOADS:
+		sort = []string{"-downloads", "-last_modified"}
+	case SORT_NEWEST:
+		sort = []string{"-date_created"}
+	case SORT_STARS:
+		sort = []string{"-stars", "-last_modified"}
+	case SORT_UPDATED:
+		sort = []string{"-last_modified"}
+	}
+	query := m{
+		"from":      offset,
+		"size":      SearchResultsPageSize,
+		"query":     m{"conjuncts": conjuncts},
+		"fields":    []string{"*"},
+		"sort":      sort,
+		"highlight": m{"style": "html", "fields": []string{"one_line_description", "full_description"}},
+	}
+
+	// Run the search
+	var resp struct {
+		Hits []struct {
+			Fields    map[string]interface{} `json:"fields"`
+			Fragments map[string][]string    `json:"fragments"`
+			Score     float32                `json:"score"`
+		} `json:"hits"`
+		TotalHits int `json:"total_hits"`
+	}
+	err = searchRequest(http.MethodPost, fmt.Sprintf("%s/api/%s/_search", b.server, b.index), query, &resp)
+	if err != nil {
+		return
+	}
+	for _, hit := range resp.Hits {
+		// Stored fields come back as generic JSON values, with multi-value fields holding just one value being
+		// returned as that single value rather than a list
+		str := func(name string) string {
+			s, _ := hit.Fields[name].(string)
+			return s
+		}
+		var tags []string
+		switch t := hit.Fields["project_tags"].(type) {
+		case string:
+			tags = []string{t}
+		case []interface{}:
+			for _, v := range t {
+				if s, ok := v.(string); ok {
+					tags = append(tags, s)
+				}
+			}
+		}
+		stars, _ := hit.Fields["stars"].(float64)
+		lastMod, _ := time.Parse(time.RFC3339, str("last_modified"))
+		oneRow := SearchResult{
+			DBName:        str("db_name"),
+			DescHighlight: html.EscapeString(str("one_line_description")),
+			LastModified:  lastMod,
+			Licence:       str("licence"),
+			LicenceURL:    str("licence_url"),
+			OneLineDesc:   str("one_line_description"),
+			Owner:         str("owner"),
+			ProjectTags:   tags,
+			Rank:          hit.Score,
+			Stars:         int(stars),
+		}
+		oneRow.URL = fmt.Sprintf("/%s/%s", oneRow.Owner, oneRow.DBName)
+		if h := hit.Fragments["one_line_description"]; len(h) > 0 {
+			oneRow.DescHighlight = h[0]
+		}
+		if h := hit.Fragments["full_description"]; len(h) > 0 {
+			oneRow.FullDescHighlight = joinFragments(h)
+		}
+		results = append(results, oneRow)
+	}
+	total = resp.TotalHits
+	return
+}
@@ -0,0 +1,149 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/go-redis/redis"
+)
+
+// A place to cache data.  Memcached holds the sessions and view counts (unless it's optional, in which case the
+// sessions go in PostgreSQL and views aren't counted while it's down), but the general data cache and the rate limiter
+// counts can be kept in Redis instead.  All of the keys are put under the configured prefix, so the cache server can
+// be shared with other things.
+type CacheBackend interface {
+	// Removes an entry.  It's not an error if the entry doesn't exist
+	Delete(key string) error
+
+	// Retrieves an entry, returning false if it doesn't exist
+	Get(key string) ([]byte, bool, error)
+
+	// Adds one to a counter, creating it with the given time to live if it doesn't exist
+	Increment(key string, ttl time.Duration) (uint64, error)
+
+	// Stores an entry, replacing any existing one
+	Set(key string, value []byte, ttl time.Duration) error
+}
+
+// The cache backend in use
+var dataCache CacheBackend = memcacheBackend{}
+
+// Caches data in Memcached.  Errors from the cache being unavailable are ignored, so it's treated as a cache miss.
+type memcacheBackend struct {
+	prefix string
+}
+
+func (b memcacheBackend) Delete(key string) error {
+	err := memCache.Delete(b.prefix + key)
+	if err != nil && err != memcache.ErrCacheMiss && !CacheUnavailable(err) {
+		return err
+	}
+	return nil
+}
+
+func (b memcacheBackend) Get(key string) ([]byte, bool, error) {
+	item, err := memCache.Get(b.prefix + key)
+	if err == memcache.ErrCacheMiss || CacheUnavailable(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return item.Value, true, nil
+}
+
+func (b memcacheBackend) Increment(key string, ttl time.Duration) (uint64, error) {
+	key = b.prefix + key
+	n, err := memCache.Increment(key, 1)
+	if CacheUnavailable(err) {
+		return 0, nil
+	}
+	if err != memcache.ErrCacheMiss {
+		return n, err
+	}
+
+	// The counter doesn't exist yet.  If someone else creates it first, use theirs
+	err = memCache.Add(&memcache.Item{Key: key, Value: []byte("1"), Expiration: int32(ttl / time.Second)})
+	if err == memcache.ErrNotStored {
+		return memCache.Increment(key, 1)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return 1, nil
+}
+
+func (b memcacheBackend) Set(key string, value []byte, ttl time.Duration) error {
+	err := memCache.Set(&memcache.Item{Key: b.prefix + key, Value: value, Expiration: int32(ttl / time.Second)})
+	if err != nil && !CacheUnavailable(err) {
+		return err
+	}
+	return nil
+}
+
+// Caches data in Redis.
+type redisBackend struct {
+	client *redis.Client
+	prefix string
+}
+
+func (b redisBackend) Delete(key string) error {
+	return b.client.Del(b.prefix + key).Err()
+}
+
+func (b redisBackend) Get(key string) ([]byte, bool, error) {
+	data, err := b.client.Get(b.prefix + key).Bytes()
+	if err == redis.Nil {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return data, true, nil
+}
+
+func (b redisBackend) Increment(key string, ttl time.Duration) (uint64, error) {
+	n, err := b.client.Incr(b.prefix + key).Result()
+	if err != nil {
+		return 0, err
+	}
+	if n == 1 {
+		// This is a new counter, so give it an expiry time
+		err = b.client.Expire(b.prefix+key, ttl).Err()
+	}
+	return uint64(n), err
+}
+
+func (b redisBackend) Set(key string, value []byte, ttl time.Duration) error {
+	return b.client.Set(b.prefix+key, value, ttl).Err()
+}
+
+// Sets up the cache backend chosen in the configuration file.
+func connectCacheBackend() error {
+	backend := strings.ToLower(Conf().Cache.Backend)
+	switch backend {
+	case "", "memcache", "memcached":
+		dataCache = memcacheBackend{prefix: Conf().Cache.KeyPrefix}
+		return nil
+	case "redis":
+	default:
+		return fmt.Errorf("Unknown cache backend: '%s'", Conf().Cache.Backend)
+	}
+	if Conf().Cache.Server == "" {
+		return fmt.Errorf("No server given for the %s cache backend", backend)
+	}
+	client := redis.NewClient(&redis.Options{
+		Addr:     Conf().Cache.Server,
+		DB:       Conf().Cache.Database,
+		Password: Conf().Cache.Password,
+	})
+	err := client.Ping().Err()
+	if err != nil {
+		return fmt.Errorf("Couldn't connect to Redis server: %v", err)
+	}
+	dataCache = redisBackend{client: client, prefix: Conf().Cache.KeyPrefix}
+	Log.Infof("Using Redis for caching: %s, database %d", Conf().Cache.Server, Conf().Cache.Database)
+	return nil
+}
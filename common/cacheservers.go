@@ -0,0 +1,142 @@
+package common
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+// How often the Memcached servers are checked
+const cacheCheckInterval = 5 * time.Second
+
+// The Memcached servers in use
+var cacheSelector *cacheServers
+
+// Chooses which Memcached server to use.  Everything goes to a single server, with the others only used if it stops
+// responding.  The memcache library spreads keys over all of its servers instead, which would lose part of the cache
+// (and some of the sessions) each time one of them failed.
+type cacheServers struct {
+	active int
+	addrs  []net.Addr
+	mu     sync.RWMutex
+}
+
+// Returns the Memcached servers from the configuration file, in the order they should be tried.
+func cacheServerNames() (names []string) {
+	if Conf().Memcache.Server != "" {
+		names = append(names, Conf().Memcache.Server)
+	}
+	for _, s := range Conf().Memcache.Servers {
+		if s != "" && s != Conf().Memcache.Server {
+			names = append(names, s)
+		}
+	}
+	return
+}
+
+// Returns whether an error from Memcached means it can't be reached right now, in which case callers carry on without
+// the cache.
+func CacheUnavailable(err error) bool {
+	if err == memcache.ErrNoServers {
+		return true
+	}
+	if _, ok := err.(*memcache.ConnectTimeoutError); ok {
+		return true
+	}
+	_, ok := err.(net.Error)
+	return ok
+}
+
+// Returns whether a Memcached server is responding.
+func pingCacheServer(addr net.Addr) bool {
+	conn, err := net.DialTimeout(addr.Network(), addr.String(), 2*time.Second)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err = conn.Write([]byte("version\r\n")); err != nil {
+		return false
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	return err == nil && strings.HasPrefix(line, "VERSION ")
+}
+
+// Looks up the address of a Memcached server.  Addresses containing a slash are Unix sockets, the same as the memcache
+// library.
+func resolveCacheServer(server string) (net.Addr, error) {
+	if strings.Contains(server, "/") {
+		return net.ResolveUnixAddr("unix", server)
+	}
+	return net.ResolveTCPAddr("tcp", server)
+}
+
+// Switches to the first Memcached server (in the configured order) which is responding.  Every webui server chooses the
+// same way, so they all end up on the same one, and share the sessions, once they've each noticed the change.  That
+// includes moving back to an earlier server once it responds again.  Memcached only keeps things in memory, so a server
+// which was restarted comes back empty rather than holding anything out of date.
+func (c *cacheServers) check() {
+	next := -1
+	for i, addr := range c.addrs {
+		if pingCacheServer(addr) {
+			next = i
+			break
+		}
+	}
+
+	c.mu.Lock()
+	active := c.active
+	c.active = next
+	c.mu.Unlock()
+	if next == active {
+		return
+	}
+	switch {
+	case next < 0:
+		Log.Errorf("No Memcached servers are responding, continuing without caching")
+	case active < 0 && memCache == nil:
+		// Starting up
+	default:
+		Log.Warnf("Switching to Memcached server %v", c.addrs[next])
+	}
+}
+
+// Returns the Memcached server in use, or nil if none are responding.
+func (c *cacheServers) current() net.Addr {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.active < 0 {
+		return nil
+	}
+	return c.addrs[c.active]
+}
+
+// Runs fn on the Memcached server in use.  Part of memcache.ServerSelector.
+func (c *cacheServers) Each(fn func(net.Addr) error) error {
+	addr := c.current()
+	if addr == nil {
+		return nil
+	}
+	return fn(addr)
+}
+
+// Periodically checks the Memcached server in use is still responding.
+func (c *cacheServers) monitor() {
+	for {
+		time.Sleep(cacheCheckInterval)
+		c.check()
+	}
+}
+
+// Returns the Memcached server in use for all keys.  Part of memcache.ServerSelector.
+func (c *cacheServers) PickServer(key string) (net.Addr, error) {
+	addr := c.current()
+	if addr == nil {
+		return nil, memcache.ErrNoServers
+	}
+	return addr, nil
+}
@@ -0,0 +1,146 @@
+package common
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+
+	"lukechampine.com/blake3"
+)
+
+// Besides the SHA256 files are stored under, their MD5, SHA1, and BLAKE3 checksums are worked out when they're stored
+// in Minio, so people can check their downloads with whichever tool they have to hand.  They're sent with downloads
+// (as a Digest header and X-Checksum-* headers), and listed in a CHECKSUMS file for each release.  Files stored before
+// this was added have their checksums worked out the first time a CHECKSUMS file needs them.
+
+// Works out the checksums of everything written to it
+type checksummer struct {
+	blake3 hash.Hash
+	md5    hash.Hash
+	n      int64
+	sha1   hash.Hash
+	sha256 hash.Hash
+}
+
+// Adds the checksums of a file being downloaded to the response headers.  The Digest header (RFC 3230) wants base64,
+// while the X-Checksum-* ones are hex like the sums people compare them with.  Only the SHA256 is known for files
+// whose other checksums haven't been worked out yet.
+func ChecksumHeaders(h http.Header, sums ObjectChecksums) {
+	var digests []string
+	if b := hexToBase64(sums.MD5); b != "" {
+		digests = append(digests, "md5="+b)
+		h.Set("X-Checksum-MD5", sums.MD5)
+	}
+	if b := hexToBase64(sums.SHA1); b != "" {
+		digests = append(digests, "sha="+b)
+		h.Set("X-Checksum-SHA1", sums.SHA1)
+	}
+	if b := hexToBase64(sums.SHA256); b != "" {
+		digests = append(digests, "sha-256="+b)
+		h.Set("X-Checksum-SHA256", sums.SHA256)
+	}
+	if sums.BLAKE3 != "" {
+		h.Set("X-Checksum-BLAKE3", sums.BLAKE3)
+	}
+	if len(digests) != 0 {
+		h.Set("Digest", strings.Join(digests, ","))
+	}
+}
+
+// Returns the contents of a CHECKSUMS file, in the BSD tag format which "sha256sum -c" and friends understand.  The
+// files are listed in the order given.
+func ChecksumsFile(names []string, sums []ObjectChecksums) []byte {
+	var b strings.Builder
+	for i, name := range names {
+		fmt.Fprintf(&b, "MD5 (%s) = %s\n", name, sums[i].MD5)
+		fmt.Fprintf(&b, "SHA1 (%s) = %s\n", name, sums[i].SHA1)
+		fmt.Fprintf(&b, "SHA256 (%s) = %s\n", name, sums[i].SHA256)
+		fmt.Fprintf(&b, "BLAKE3 (%s) = %s\n", name, sums[i].BLAKE3)
+	}
+	return []byte(b.String())
+}
+
+// Converts a hex checksum to base64, returning an empty string if it isn't valid hex.
+func hexToBase64(s string) string {
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// Returns the checksums of a stored file which are known without reading it from Minio.  The SHA256 is always known,
+// as files are stored under it.
+func KnownChecksums(sha string) ObjectChecksums {
+	sums, found, err := StoredChecksums(sha)
+	if err != nil || !found {
+		return ObjectChecksums{SHA256: sha}
+	}
+	return sums
+}
+
+// Returns a checksummer, ready for writing to.
+func newChecksummer() *checksummer {
+	return &checksummer{
+		blake3: blake3.New(32, nil),
+		md5:    md5.New(),
+		sha1:   sha1.New(),
+		sha256: sha256.New(),
+	}
+}
+
+// Returns the checksums of a file stored in Minio.  If they haven't been worked out yet (eg the file was stored before
+// they were kept), the file is read from Minio to do so, and they're stored for next time.
+func ObjectChecksumsFor(sha string) (sums ObjectChecksums, err error) {
+	sums, found, err := StoredChecksums(sha)
+	if err != nil || found {
+		return
+	}
+	if len(sha) <= MinioFolderChars {
+		return sums, fmt.Errorf("'%s' isn't the SHA256 of a stored file", sha)
+	}
+	obj, err := MinioHandle(sha[:MinioFolderChars], sha[MinioFolderChars:])
+	if err != nil {
+		return
+	}
+	defer MinioHandleClose(obj)
+	c := newChecksummer()
+	if _, err = io.Copy(c, obj); err != nil {
+		Log.Errorf("Reading file '%s' from Minio for its checksums failed: %v", sha, err)
+		return
+	}
+	sums = c.Sums()
+	if sums.SHA256 != sha {
+		Log.Errorf("The contents of file '%s' in Minio have a SHA256 of '%s'", sha, sums.SHA256)
+		return sums, fmt.Errorf("The stored file '%s' doesn't match its checksum", sha)
+	}
+	StoreChecksums(sums)
+	return
+}
+
+// Returns the checksums of everything written so far.
+func (c *checksummer) Sums() ObjectChecksums {
+	return ObjectChecksums{
+		BLAKE3: hex.EncodeToString(c.blake3.Sum(nil)),
+		MD5:    hex.EncodeToString(c.md5.Sum(nil)),
+		SHA1:   hex.EncodeToString(c.sha1.Sum(nil)),
+		SHA256: hex.EncodeToString(c.sha256.Sum(nil)),
+	}
+}
+
+// Adds data to the checksums.  Hashes never return errors from Write.
+func (c *checksummer) Write(p []byte) (int, error) {
+	c.blake3.Write(p)
+	c.md5.Write(p)
+	c.sha1.Write(p)
+	c.sha256.Write(p)
+	c.n += int64(len(p))
+	return len(p), nil
+}
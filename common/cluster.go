@@ -0,0 +1,156 @@
+package common
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/jackc/pgx"
+)
+
+// Several instances of the servers can run at once behind a load balancer.  Background jobs which should only run in
+// one place take turns using locks kept in Memcached (or PostgreSQL advisory locks, when Memcached is optional), and
+// live updates are passed between the instances using PostgreSQL notifications.
+
+// The PostgreSQL notification channel used for passing live updates between instances
+const liveUpdateChannel = "live_updates"
+
+// Identifies this instance in the background job locks
+var InstanceID = newInstanceID()
+
+var (
+	// The PostgreSQL connection holding this instance's background job locks when they're kept in PostgreSQL, and the
+	// jobs it holds the locks for
+	jobLockConn   *pgx.Conn
+	jobLockConnMu sync.Mutex
+	jobLocksHeld  = make(map[string]bool)
+
+	// Whether the background job locks are kept in PostgreSQL.  This is set when connecting to Memcached, so a config
+	// reload can't switch it underneath the running jobs
+	jobLocksInPG bool
+)
+
+// Returns whether this instance should run a background job, which is run every interval.  The first instance to ask
+// gets the job, and keeps it for as long as it keeps asking.  If it stops (eg the instance has been shut down), another
+// instance takes over after a few intervals.
+func HoldJobLock(job string, interval time.Duration) bool {
+	if jobLocksInPG {
+		return holdPGJobLock(job)
+	}
+
+	defer traceSpan("memcache", "HoldJobLock", time.Now())
+	ttl := int32((3*interval + 30*time.Second) / time.Second)
+	key := "joblock-" + job
+	err := memCache.Add(&memcache.Item{Key: key, Value: []byte(InstanceID), Expiration: ttl})
+	if err == nil {
+		return true
+	}
+	if err != memcache.ErrNotStored {
+		Log.Warnf("Error when taking the lock for background job '%s': %v", job, err)
+		return false
+	}
+
+	// The lock is already held.  If it's by this instance, extend it
+	item, err := memCache.Get(key)
+	if err != nil || string(item.Value) != InstanceID {
+		return false
+	}
+	item.Expiration = ttl
+	return memCache.CompareAndSwap(item) == nil
+}
+
+// Takes (or keeps) the lock for a background job using a PostgreSQL advisory lock.  The locks are held by a
+// connection kept just for them, so they're released as soon as this instance goes away and its connection closes.
+func holdPGJobLock(job string) bool {
+	jobLockConnMu.Lock()
+	defer jobLockConnMu.Unlock()
+	if jobLockConn != nil && jobLocksHeld[job] {
+		// The lock is kept for as long as the connection is, so make sure it's still there
+		if _, err := jobLockConn.Exec("SELECT 1"); err == nil {
+			return true
+		}
+		jobLockConn.Close()
+		jobLockConn = nil
+	}
+	if jobLockConn == nil || !jobLockConn.IsAlive() {
+		// Any locks held by the old connection were released when it dropped
+		jobLocksHeld = make(map[string]bool)
+		conn, err := pgx.Connect(*pgConfig)
+		if err != nil {
+			Log.Warnf("Error when connecting to PostgreSQL for the background job locks: %v", err)
+			return false
+		}
+		jobLockConn = conn
+	}
+
+	dbQuery := `
+		SELECT pg_try_advisory_lock(hashtext($1))`
+	var held bool
+	err := jobLockConn.QueryRow(dbQuery, "joblock-"+job).Scan(&held)
+	if err != nil {
+		Log.Warnf("Error when taking the lock for background job '%s': %v", job, err)
+		return false
+	}
+	jobLocksHeld[job] = held
+	return held
+}
+
+// Receives the live updates published by all of the instances, passing them to the subscribers on this instance.  If
+// the connection to PostgreSQL drops, it reconnects.
+func ListenLiveUpdates() {
+	for {
+		err := listenLiveUpdates()
+		Log.Warnf("Listening for live updates failed, retrying in 5 seconds: %v", err)
+		time.Sleep(5 * time.Second)
+	}
+}
+
+// Listens for live updates on a single connection, until something goes wrong with it.
+func listenLiveUpdates() error {
+	conn, err := pdb.Acquire()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// Stop listening before handing the connection back, so notifications don't pile up on it
+		conn.Unlisten(liveUpdateChannel)
+		pdb.Release(conn)
+	}()
+	err = conn.Listen(liveUpdateChannel)
+	if err != nil {
+		return err
+	}
+	for {
+		n, err := conn.WaitForNotification(time.Minute)
+		if err == pgx.ErrNotificationTimeout {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		var u LiveUpdate
+		if err = json.Unmarshal([]byte(n.Payload), &u); err != nil {
+			Log.Warnf("Ignoring unreadable live update: %v", err)
+			continue
+		}
+		deliverLiveUpdate(u)
+	}
+}
+
+// Returns a name for this instance which is unique, but still says where it's running for anyone reading Memcached.
+func newInstanceID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	b := make([]byte, 4)
+	if _, err = rand.Read(b); err != nil {
+		return fmt.Sprintf("%s-%d-%d", host, os.Getpid(), time.Now().UnixNano())
+	}
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(b))
+}
@@ -3,10 +3,14 @@ package common
 import (
 	"crypto/tls"
 	"fmt"
-	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/jackc/pgx"
@@ -14,163 +18,386 @@ import (
 )
 
 var (
-	// Our configuration info
-	Conf TomlConfig
+	// Our configuration info.  Reloading the configuration stores a new copy rather than changing the current one, so
+	// it's read through Conf()
+	conf atomic.Value
+
+	// Stops reloads running at the same time from losing each other's changes
+	reloadMutex sync.Mutex
 
 	// PostgreSQL configuration info
 	pgConfig = new(pgx.ConnConfig)
 )
 
-// Read the server configuration file.
-func ReadConfig() error {
+// Returns the current configuration.  It's never changed once in use, as reloading the configuration stores a new one
+// instead, so the settings can be read while a reload is happening.
+func Conf() *TomlConfig {
+	c, _ := conf.Load().(*TomlConfig)
+	if c == nil {
+		// The configuration file hasn't been read yet
+		return &TomlConfig{}
+	}
+	return c
+}
+
+// Reads the server configuration file, applying any overrides given by environment variables.
+func decodeConfig() (c TomlConfig, err error) {
 	// Override config file location via environment variables
-	var err error
 	configFile := os.Getenv("CONFIG_FILE")
 	if configFile == "" {
 		// TODO: Might be a good idea to add permission checks of the dir & conf file, to ensure they're not
 		//       world readable.  Similar in concept to what ssh does for its config files.
 		userHome, err := homedir.Dir()
 		if err != nil {
-			log.Fatalf("User home directory couldn't be determined: %s", "\n")
+			Log.Fatalf("User home directory couldn't be determined: %s", "\n")
 		}
 		configFile = filepath.Join(userHome, ".dbhub", "config.toml")
 	}
 
 	// Reads the server configuration from disk
-	if _, err := toml.DecodeFile(configFile, &Conf); err != nil {
-		return fmt.Errorf("Config file couldn't be parsed: %v\n", err)
+	if _, err := toml.DecodeFile(configFile, &c); err != nil {
+		return c, fmt.Errorf("Config file couldn't be parsed: %v\n", err)
 	}
 
 	// Override config file via environment variables
 	tempString := os.Getenv("MINIO_SERVER")
 	if tempString != "" {
-		Conf.Minio.Server = tempString
+		c.Minio.Server = tempString
 	}
 	tempString = os.Getenv("MINIO_ACCESS_KEY")
 	if tempString != "" {
-		Conf.Minio.AccessKey = tempString
+		c.Minio.AccessKey = tempString
 	}
 	tempString = os.Getenv("MINIO_SECRET")
 	if tempString != "" {
-		Conf.Minio.Secret = tempString
+		c.Minio.Secret = tempString
 	}
 	tempString = os.Getenv("MINIO_HTTPS")
 	if tempString != "" {
-		Conf.Minio.HTTPS, err = strconv.ParseBool(tempString)
+		c.Minio.HTTPS, err = strconv.ParseBool(tempString)
 		if err != nil {
-			return fmt.Errorf("Failed to parse MINIO_HTTPS: %v\n", err)
+			return c, fmt.Errorf("Failed to parse MINIO_HTTPS: %v\n", err)
 		}
 	}
 	tempString = os.Getenv("PG_SERVER")
 	if tempString != "" {
-		Conf.Pg.Server = tempString
+		c.Pg.Server = tempString
 	}
 	tempString = os.Getenv("PG_PORT")
 	if tempString != "" {
 		tempInt, err := strconv.ParseInt(tempString, 10, 0)
 		if err != nil {
-			return fmt.Errorf("Failed to parse PG_PORT: %v\n", err)
+			return c, fmt.Errorf("Failed to parse PG_PORT: %v\n", err)
 		}
-		Conf.Pg.Port = int(tempInt)
+		c.Pg.Port = int(tempInt)
 	}
 	tempString = os.Getenv("PG_USER")
 	if tempString != "" {
-		Conf.Pg.Username = tempString
+		c.Pg.Username = tempString
 	}
 	tempString = os.Getenv("PG_PASS")
 	if tempString != "" {
-		Conf.Pg.Password = tempString
+		c.Pg.Password = tempString
 	}
 	tempString = os.Getenv("PG_DBNAME")
 	if tempString != "" {
-		Conf.Pg.Database = tempString
+		c.Pg.Database = tempString
+	}
+	tempString = os.Getenv("LOG_FORMAT")
+	if tempString != "" {
+		c.Log.Format = tempString
+	}
+	tempString = os.Getenv("LOG_LEVEL")
+	if tempString != "" {
+		c.Log.Level = tempString
 	}
 
 	// Verify we have the needed configuration information
-	// Note - We don't check for a valid Conf.Pg.Password here, as the PostgreSQL password can also be kept
+	// Note - We don't check for a valid c.Pg.Password here, as the PostgreSQL password can also be kept
 	// in a .pgpass file as per https://www.postgresql.org/docs/current/static/libpq-pgpass.html
 	var missingConfig []string
-	if Conf.Minio.Server == "" {
+	if c.Minio.Server == "" {
 		missingConfig = append(missingConfig, "Minio server:port string")
 	}
-	if Conf.Minio.AccessKey == "" && Conf.Environment.Environment != "docker" {
+	if c.Minio.AccessKey == "" && c.Environment.Environment != "docker" {
 		missingConfig = append(missingConfig, "Minio access key string")
 	}
-	if Conf.Minio.Secret == "" && Conf.Environment.Environment != "docker" {
+	if c.Minio.Secret == "" && c.Environment.Environment != "docker" {
 		missingConfig = append(missingConfig, "Minio secret string")
 	}
-	if Conf.Pg.Server == "" {
+	if c.Pg.Server == "" {
 		missingConfig = append(missingConfig, "PostgreSQL server string")
 	}
-	if Conf.Pg.Port == 0 {
+	if c.Pg.Port == 0 {
 		missingConfig = append(missingConfig, "PostgreSQL port number")
 	}
-	if Conf.Pg.Username == "" {
+	if c.Pg.Username == "" {
 		missingConfig = append(missingConfig, "PostgreSQL username string")
 	}
-	if Conf.Pg.Database == "" {
+	if c.Pg.Database == "" {
 		missingConfig = append(missingConfig, "PostgreSQL database string")
 	}
+	if c.Web.Autocert && c.Web.PlainHTTP {
+		return c, fmt.Errorf("The autocert and plain_http web options can't be used together")
+	}
 	if len(missingConfig) > 0 {
 		// Some config is missing
 		returnMessage := fmt.Sprint("Missing or incomplete value(s):\n")
 		for _, value := range missingConfig {
 			returnMessage += fmt.Sprintf("\n \t→ %v", value)
 		}
-		return fmt.Errorf(returnMessage)
+		return c, fmt.Errorf(returnMessage)
+	}
+	return
+}
+
+// Read the server configuration file.
+func ReadConfig() error {
+	c, err := decodeConfig()
+	if err != nil {
+		return err
 	}
 
+	// Set up the logger, so the warnings below use the configured format
+	err = ConfigureLogging(c.Log)
+	if err != nil {
+		return err
+	}
+	proxies, err := parseTrustedProxies(c.Web.TrustedProxies)
+	if err != nil {
+		return err
+	}
+	setConfigDefaults(&c)
+	conf.Store(&c)
+	trustedProxies.Store(proxies)
+
+	// Set the PostgreSQL configuration values
+	pgConfig.Host = c.Pg.Server
+	pgConfig.Port = uint16(c.Pg.Port)
+	pgConfig.User = c.Pg.Username
+	pgConfig.Password = c.Pg.Password
+	pgConfig.Database = c.Pg.Database
+	clientTLSConfig := tls.Config{InsecureSkipVerify: true}
+	if c.Pg.SSL {
+		pgConfig.TLSConfig = &clientTLSConfig
+	} else {
+		pgConfig.TLSConfig = nil
+	}
+
+	// Have PostgreSQL cancel statements which run for too long.  The setting is in milliseconds
+	if c.Pg.StatementTimeout > 0 {
+		pgConfig.RuntimeParams = map[string]string{
+			"statement_timeout": fmt.Sprint(int64(c.Pg.StatementTimeout * time.Second / time.Millisecond)),
+		}
+	}
+
+	// Query timings are always passed through, so tracing can be turned on later by reloading the configuration
+	pgConfig.Logger = pgxTracer{}
+	pgConfig.LogLevel = pgx.LogLevelInfo
+
+	// TODO: Add environment variable overrides for memcached
+
+	// The configuration file seems good
+	return nil
+}
+
+// Re-reads the configuration file while the server is running, so changes can be picked up without a restart (which
+// would drop everyone's sessions).  Only the settings which don't need connections to be re-established are changed.
+// Changes to anything else are logged as needing a restart, and otherwise ignored.
+func ReloadConfig() error {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+	c, err := decodeConfig()
+	if err != nil {
+		Log.Errorf("Reloading the configuration failed: %v", err)
+		return err
+	}
+
+	// Check the new proxy and logging settings are usable before changing anything
+	proxies, err := parseTrustedProxies(c.Web.TrustedProxies)
+	if err != nil {
+		Log.Errorf("Reloading the configuration failed: %v", err)
+		return err
+	}
+	old := Conf()
+	err = ConfigureLogging(c.Log)
+	if err != nil {
+		ConfigureLogging(old.Log)
+		Log.Errorf("Reloading the configuration failed: %v", err)
+		return err
+	}
+
+	// Fill in the defaults before comparing, as the current settings have them too
+	setConfigDefaults(&c)
+
+	// Warn about changes which won't take effect until the server is restarted
+	listenerChanged := c.Web.BindAddress != old.Web.BindAddress || c.Web.Certificate != old.Web.Certificate ||
+		c.Web.Autocert != old.Web.Autocert || c.Web.PlainHTTP != old.Web.PlainHTTP
+	memcacheChanged := c.Memcache.Server != old.Memcache.Server || c.Memcache.Optional != old.Memcache.Optional ||
+		fmt.Sprint(c.Memcache.Servers) != fmt.Sprint(old.Memcache.Servers)
+	restartNeeded := map[string]bool{
+		"admin server": c.Admin.Server != old.Admin.Server,
+		"auth0":        c.Auth0 != old.Auth0,
+		"cache":        c.Cache != old.Cache,
+		"db4s":         c.DB4S != old.DB4S,
+		"jobs":         c.Jobs.Workers != old.Jobs.Workers,
+		"memcache":     memcacheChanged,
+		"minio":        c.Minio != old.Minio,
+		"pg":           c.Pg != old.Pg,
+		"request log": c.Web.RequestLog != old.Web.RequestLog || c.Web.RequestLogFormat != old.Web.RequestLogFormat ||
+			c.Web.RequestLogKeep != old.Web.RequestLogKeep || c.Web.RequestLogMaxSizeMB != old.Web.RequestLogMaxSizeMB ||
+			c.Web.RequestLogRotate != old.Web.RequestLogRotate || c.Web.RequestLogSyslog != old.Web.RequestLogSyslog,
+		"search":       c.Search != old.Search,
+		"web listener": listenerChanged,
+	}
+	for section, changed := range restartNeeded {
+		if changed {
+			Log.Warnf("Configuration changes to '%s' need a server restart to take effect", section)
+		}
+	}
+
+	// Start from a copy of the current settings, and swap in the new values for the ones which can change at run time
+	n := *old
+	n.Admin.Users = c.Admin.Users
+	n.Backup = c.Backup
+	n.Billing = c.Billing
+	n.Event.Delay = c.Event.Delay
+	n.Event.EmailQueueProcessingDelay = c.Event.EmailQueueProcessingDelay
+	n.Filter = c.Filter
+	n.Import = c.Import
+	n.Jobs.Types = c.Jobs.Types
+	n.Log = c.Log
+	n.Memcache.DefaultCacheTime = c.Memcache.DefaultCacheTime
+	n.Memcache.ViewCountFlushDelay = c.Memcache.ViewCountFlushDelay
+	n.Moderation = c.Moderation
+	n.Prewarm = c.Prewarm
+	n.Print = c.Print
+	n.Quota = c.Quota
+	n.Sign.CertDaysValid = c.Sign.CertDaysValid
+	n.Spam = c.Spam
+	n.Torrent = c.Torrent
+	n.Trace = c.Trace
+	n.Web.AccessLogDays = c.Web.AccessLogDays
+	n.Web.DevMode = c.Web.DevMode
+	n.Web.DownloadRateKB = c.Web.DownloadRateKB
+	n.Web.DownloadTokenLimit = c.Web.DownloadTokenLimit
+	n.Web.DownloadTokens = c.Web.DownloadTokens
+	n.Web.DownloadTokensPerIP = c.Web.DownloadTokensPerIP
+	n.Web.DownloadUserRateKB = c.Web.DownloadUserRateKB
+	n.Web.RateLimit = c.Web.RateLimit
+	n.Web.TrustedProxies = c.Web.TrustedProxies
+	n.Web.WebsiteName = c.Web.WebsiteName
+	conf.Store(&n)
+	trustedProxies.Store(proxies)
+	Log.Infof("Configuration reloaded")
+	return nil
+}
+
+// Reloads the configuration file each time the process receives a SIGHUP.
+func ReloadConfigOnSignal() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	go func() {
+		for range sig {
+			Log.Infof("SIGHUP received, reloading the configuration")
+			ReloadConfig()
+		}
+	}()
+}
+
+// Fills in the default values for settings missing from the configuration file.
+func setConfigDefaults(c *TomlConfig) {
 	// Warn if the certificate validity period isn't set in the config file
-	if Conf.Sign.CertDaysValid == 0 {
-		log.Printf("WARN: Cert validity period for cert signing isn't set in the config file. Defaulting to 60 days.")
-		Conf.Sign.CertDaysValid = 60
+	if c.Sign.CertDaysValid == 0 {
+		Log.Warnf("Cert validity period for cert signing isn't set in the config file. Defaulting to 60 days.")
+		c.Sign.CertDaysValid = 60
 	}
 
 	// Warn if the default Memcache cache time isn't set in the config file
-	if Conf.Memcache.DefaultCacheTime == 0 {
-		log.Printf("WARN: Default Memcache cache time isn't set in the config file. Defaulting to 30 days.")
-		Conf.Memcache.DefaultCacheTime = 2592000
+	if c.Memcache.DefaultCacheTime == 0 {
+		Log.Warnf("Default Memcache cache time isn't set in the config file. Defaulting to 30 days.")
+		c.Memcache.DefaultCacheTime = 2592000
 	}
 
 	// Warn if the view count flush delay isn't set in the config file
-	if Conf.Memcache.ViewCountFlushDelay == 0 {
-		log.Printf("WARN: Memcache view count flush delay isn't set in the config file. Defaulting to 2 minutes.")
-		Conf.Memcache.ViewCountFlushDelay = 120
+	if c.Memcache.ViewCountFlushDelay == 0 {
+		Log.Warnf("Memcache view count flush delay isn't set in the config file. Defaulting to 2 minutes.")
+		c.Memcache.ViewCountFlushDelay = 120
 	}
 
 	// Warn if the event processing loop delay isn't set in the config file
-	if Conf.Event.Delay == 0 {
-		log.Printf("WARN: Event processing delay isn't set in the config file. Defaulting to 3 seconds.")
-		Conf.Event.Delay = 3
+	if c.Event.Delay == 0 {
+		Log.Warnf("Event processing delay isn't set in the config file. Defaulting to 3 seconds.")
+		c.Event.Delay = 3
 	}
 
 	// Warn if the email queue processing isn't set in the config file
-	if Conf.Event.EmailQueueProcessingDelay == 0 {
-		log.Printf("WARN: Email queue processing delay isn't set in the config file. Defaulting to 10 seconds.")
-		Conf.Event.EmailQueueProcessingDelay = 10
+	if c.Event.EmailQueueProcessingDelay == 0 {
+		Log.Warnf("Email queue processing delay isn't set in the config file. Defaulting to 10 seconds.")
+		c.Event.EmailQueueProcessingDelay = 10
 	}
 
 	// Warn if the email queue directory isn't set in the config file
-	if Conf.Event.EmailQueueDir == "" {
-		log.Printf("WARN: Email queue directory isn't set in the config file. Defaulting to /tmp.")
-		Conf.Event.EmailQueueDir = "/tmp"
+	if c.Event.EmailQueueDir == "" {
+		Log.Warnf("Email queue directory isn't set in the config file. Defaulting to /tmp.")
+		c.Event.EmailQueueDir = "/tmp"
 	}
 
-	// Set the PostgreSQL configuration values
-	pgConfig.Host = Conf.Pg.Server
-	pgConfig.Port = uint16(Conf.Pg.Port)
-	pgConfig.User = Conf.Pg.Username
-	pgConfig.Password = Conf.Pg.Password
-	pgConfig.Database = Conf.Pg.Database
-	clientTLSConfig := tls.Config{InsecureSkipVerify: true}
-	if Conf.Pg.SSL {
-		pgConfig.TLSConfig = &clientTLSConfig
-	} else {
-		pgConfig.TLSConfig = nil
+	// Default to waiting 2 seconds for a free PostgreSQL connection, and trying 3 more times when one can't be
+	// made
+	if c.Pg.AcquireTimeout == 0 {
+		c.Pg.AcquireTimeout = 2
+	}
+	if c.Pg.Retries == 0 {
+		c.Pg.Retries = 3
 	}
 
-	// TODO: Add environment variable overrides for memcached
+	// Warn if the number of background job workers isn't set in the config file
+	if c.Jobs.Workers == 0 {
+		Log.Warnf("Number of background job workers isn't set in the config file. Defaulting to 4.")
+		c.Jobs.Workers = 4
+	}
 
-	// The configuration file seems good
-	return nil
+	// Default to pre-warming the caches for the 20 most viewed projects of the last 2 days, when turned on
+	if c.Prewarm.Days == 0 {
+		c.Prewarm.Days = 2
+	}
+	if c.Prewarm.Projects == 0 {
+		c.Prewarm.Projects = 20
+	}
+
+	// Default to the Akismet service itself for spam checks, when an API key has been given
+	if c.Spam.AkismetKey != "" && c.Spam.AkismetURL == "" {
+		c.Spam.AkismetURL = "https://rest.akismet.com/1.1/comment-check"
+	}
+
+	// Default to only making torrents for files of 100MB or more
+	if c.Torrent.MinSizeMB == 0 {
+		c.Torrent.MinSizeMB = 100
+	}
+
+	// Default to keeping the per project access logs for 30 days
+	if c.Web.AccessLogDays == 0 {
+		c.Web.AccessLogDays = 30
+	}
+
+	// Default to 30 downloads an hour for each anonymous download token, and 10 new tokens an hour for each address
+	if c.Web.DownloadTokenLimit == 0 {
+		c.Web.DownloadTokenLimit = 30
+	}
+	if c.Web.DownloadTokensPerIP == 0 {
+		c.Web.DownloadTokensPerIP = 10
+	}
+
+	// Default to the standard HTTP port for the ACME challenge listener when using autocert
+	if c.Web.Autocert && c.Web.AutocertHTTPAddress == "" {
+		c.Web.AutocertHTTPAddress = ":80"
+	}
+
+	// Warn if the autocert certificate cache directory isn't set in the config file
+	if c.Web.Autocert && c.Web.AutocertCacheDir == "" {
+		c.Web.AutocertCacheDir = filepath.Join(c.DiskCache.Directory, "autocert")
+		Log.Warnf("Autocert certificate cache directory isn't set in the config file. Defaulting to %s.",
+			c.Web.AutocertCacheDir)
+	}
 }
@@ -0,0 +1,225 @@
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	sqlite "github.com/gwenn/gosqlite"
+)
+
+// Works out the SQLite type for each column of CSV data.  Columns where every value is a whole number are INTEGER,
+// ones where they're all numbers are REAL, and anything else is TEXT.  Empty fields don't count either way, as they're
+// stored as NULL in numeric columns.
+func csvColumnTypes(numCols int, rows [][]string) []string {
+	types := make([]string, numCols)
+	for i := range types {
+		isInt, isReal, seen := true, true, false
+		for _, row := range rows {
+			if i >= len(row) || strings.TrimSpace(row[i]) == "" {
+				continue
+			}
+			seen = true
+			v := strings.TrimSpace(row[i])
+			if _, err := strconv.ParseInt(v, 10, 64); err != nil {
+				isInt = false
+			}
+			if _, err := strconv.ParseFloat(v, 64); err != nil {
+				isReal = false
+				break
+			}
+		}
+		switch {
+		case seen && isInt:
+			types[i] = "INTEGER"
+		case seen && isReal:
+			types[i] = "REAL"
+		default:
+			types[i] = "TEXT"
+		}
+	}
+	return types
+}
+
+// Returns a name which is ok for a table or column created from a CSV file, as the data pages are stricter about
+// names than SQLite itself is.
+func CSVFieldName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') ||
+			strings.ContainsRune(".-_() ", r) {
+			return r
+		}
+		return '_'
+	}, strings.TrimSpace(name))
+	if len(name) > 63 {
+		name = name[:63]
+	}
+	return name
+}
+
+// Builds a SQLite database holding the data from a CSV file, so people with their data in a spreadsheet can upload it
+// without needing to create a database themselves.  The first row of the CSV gives the column names, and the type of
+// each column is worked out from the values in it.  Returns the name of a temporary file holding the new database,
+// which the caller needs to remove when finished with it.
+func CSVToSQLite(data io.Reader, tableName string) (dbFile string, err error) {
+	cols, rows, err := readCSV(data)
+	if err != nil {
+		return "", err
+	}
+	types := csvColumnTypes(len(cols), rows)
+
+	tempFile, err := ioutil.TempFile(Conf().DiskCache.Directory, "csv-")
+	if err != nil {
+		Log.Errorf("Error creating temporary file for CSV import: %v", err)
+		return "", err
+	}
+	dbFile = tempFile.Name()
+	tempFile.Close()
+	defer func() {
+		if err != nil {
+			os.Remove(dbFile)
+		}
+	}()
+	sdb, err := sqlite.Open(dbFile, sqlite.OpenReadWrite|sqlite.OpenCreate)
+	if err != nil {
+		Log.Errorf("Couldn't create database for CSV import: %s", err)
+		return "", err
+	}
+	defer sdb.Close()
+
+	// Create the table
+	var defs, marks []string
+	for i, c := range cols {
+		defs = append(defs, fmt.Sprintf(`"%s" %s`, strings.Replace(c, `"`, `""`, -1), types[i]))
+		marks = append(marks, "?")
+	}
+	quotedTable := `"` + strings.Replace(tableName, `"`, `""`, -1) + `"`
+	err = sdb.Exec(`CREATE TABLE ` + quotedTable + ` (` + strings.Join(defs, ", ") + `)`)
+	if err != nil {
+		Log.Errorf("Error when creating table for CSV import: %v", err)
+		return "", err
+	}
+
+	// Insert the rows, all in the one transaction so it doesn't take forever
+	err = sdb.Begin()
+	if err != nil {
+		return "", err
+	}
+	stmt, err := sdb.Prepare(`INSERT INTO ` + quotedTable + ` VALUES (` + strings.Join(marks, ", ") + `)`)
+	if err != nil {
+		sdb.Rollback()
+		return "", err
+	}
+	vals := make([]interface{}, len(cols))
+	for _, row := range rows {
+		for i := range vals {
+			vals[i] = csvValue(row, i, types[i])
+		}
+		if err = stmt.Exec(vals...); err != nil {
+			stmt.Finalize()
+			sdb.Rollback()
+			Log.Errorf("Error when inserting row for CSV import: %v", err)
+			return "", err
+		}
+	}
+	stmt.Finalize()
+	if err = sdb.Commit(); err != nil {
+		return "", err
+	}
+	return dbFile, nil
+}
+
+// Returns a single CSV field, converted to the type of its column.
+func csvValue(row []string, i int, colType string) interface{} {
+	if i >= len(row) {
+		return nil
+	}
+	v := row[i]
+	if colType == "TEXT" {
+		return v
+	}
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return nil
+	}
+	if colType == "INTEGER" {
+		n, _ := strconv.ParseInt(v, 10, 64)
+		return n
+	}
+	f, _ := strconv.ParseFloat(v, 64)
+	return f
+}
+
+// Reads all of the records from CSV data, returning the column names from the first row separately from the rest.
+// Commas, semicolons, and tabs are all used as separators by spreadsheet programs, so whichever of them is most common
+// in the first line is used.
+func readCSV(data io.Reader) (cols []string, rows [][]string, err error) {
+	buf := bufio.NewReaderSize(data, 64*1024)
+	first, err := buf.Peek(64 * 1024)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, nil, err
+	}
+	first = bytes.TrimPrefix(first, []byte("\xef\xbb\xbf"))
+	if n := bytes.IndexByte(first, '\n'); n >= 0 {
+		first = first[:n]
+	}
+	sep := ','
+	most := bytes.Count(first, []byte{','})
+	for _, s := range []rune{';', '\t'} {
+		if n := bytes.Count(first, []byte(string(s))); n > most {
+			sep, most = s, n
+		}
+	}
+
+	// Skip any byte order mark at the start
+	if bom, _ := buf.Peek(3); bytes.Equal(bom, []byte("\xef\xbb\xbf")) {
+		buf.Discard(3)
+	}
+	r := csv.NewReader(buf)
+	r.Comma = sep
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil, nil, fmt.Errorf("The CSV file is empty")
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("Couldn't read the CSV file: %v", err)
+	}
+
+	// Make sure the column names are usable and unique
+	used := make(map[string]bool)
+	for i, h := range header {
+		name := CSVFieldName(h)
+		if name == "" {
+			name = fmt.Sprintf("field%d", i+1)
+		}
+		base := name
+		for n := 2; used[strings.ToLower(name)]; n++ {
+			name = fmt.Sprintf("%s_%d", base, n)
+		}
+		used[strings.ToLower(name)] = true
+		cols = append(cols, name)
+	}
+
+	for rowNum := 2; ; rowNum++ {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("Couldn't read the CSV file: %v", err)
+		}
+		if len(rec) > len(cols) {
+			return nil, nil, fmt.Errorf("Row %d of the CSV file has more fields than the header row", rowNum)
+		}
+		rows = append(rows, rec)
+	}
+	return cols, rows, nil
+}
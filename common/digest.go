@@ -0,0 +1,127 @@
+package common
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Users can have the activity on the projects they watch collected into a daily or weekly digest email, rather than
+// getting an email for each event.  The status update loop adds the events to the digest_items table, and the digest
+// loop queues a background job to send each user's digest once it's due.
+
+// How often the webui server checks for digests which are due
+const digestCheckInterval = 15 * time.Minute
+
+// Checks whether any users are due their email digest, and queues the jobs to send them.  Only one of the webui
+// servers does the checking.
+func DigestLoop() {
+	for {
+		if HoldJobLock("email-digest", digestCheckInterval) {
+			ids, err := DueDigests()
+			if err == nil {
+				for _, id := range ids {
+					_, err = QueueJob("email_digest", map[string]int64{"user_id": id})
+					if err != nil {
+						Log.Errorf("Error when queuing the email digest for user ID %d: %v", id, err)
+					}
+				}
+			}
+		}
+		time.Sleep(digestCheckInterval)
+	}
+}
+
+// Sends the email digest for a user.  The payload holds the ID of the user.
+func DigestJob(payload json.RawMessage) error {
+	var p struct {
+		UserID int64 `json:"user_id"`
+	}
+	err := json.Unmarshal(payload, &p)
+	if err != nil {
+		return err
+	}
+	userName, email, items, err := UserDigest(p.UserID)
+	if err != nil || len(items) == 0 {
+		return err
+	}
+
+	// Users with the placeholder username@server email address can't receive it
+	serverName := strings.Split(Conf().Web.ServerName, ":")[0]
+	if strings.ToLower(email) == strings.ToLower(userName+"@"+serverName) {
+		email = ""
+	}
+	unsubscribe, err := UnsubscribeURL(p.UserID, userName)
+	if err != nil {
+		return err
+	}
+	subject := "3DHub.io: Your daily digest of activity on the projects you watch"
+	if PrefUserEmailFrequency(userName) == EMAIL_WEEKLY {
+		subject = "3DHub.io: Your weekly digest of activity on the projects you watch"
+	}
+	return CompleteDigest(p.UserID, items[len(items)-1].ID, email, subject, digestBody(items, unsubscribe))
+}
+
+// Writes the text of a digest email, with the events grouped by project.
+func digestBody(items []DigestItem, unsubscribe string) string {
+	byProject := make(map[string][]DigestItem)
+	var projects []string
+	for _, i := range items {
+		if _, ok := byProject[i.Project]; !ok {
+			projects = append(projects, i.Project)
+		}
+		byProject[i.Project] = append(byProject[i.Project], i)
+	}
+	sort.Strings(projects)
+
+	var b strings.Builder
+	b.WriteString("Here's what has happened on the projects you watch since your last digest.\n")
+	for _, p := range projects {
+		fmt.Fprintf(&b, "\n%s\n", p)
+		for _, i := range byProject[p] {
+			fmt.Fprintf(&b, "  * %s: %s\n    https://%s%s\n", digestEventName(i.Type), i.Title, Conf().Web.ServerName,
+				i.URL)
+		}
+	}
+	fmt.Fprintf(&b, "\nTo change how often you receive these emails, visit https://%s/pref\n", Conf().Web.ServerName)
+	fmt.Fprintf(&b, "To stop receiving them, visit %s\n", unsubscribe)
+	return b.String()
+}
+
+// Returns the description of an event type, for digest emails.
+func digestEventName(t EventType) string {
+	switch t {
+	case EVENT_NEW_COMMENT:
+		return "New comment"
+	case EVENT_NEW_DISCUSSION:
+		return "New discussion"
+	case EVENT_NEW_MERGE_REQUEST:
+		return "New merge request"
+	case EVENT_NEW_QUESTION:
+		return "New question"
+	case EVENT_NEW_RELEASE:
+		return "New release"
+	case EVENT_NEW_VERSION:
+		return "New version"
+	}
+	return "Update"
+}
+
+// Returns the link for turning off a user's activity emails with a single click.
+func UnsubscribeURL(userID int64, userName string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token, err := UnsubscribeToken(userID, hex.EncodeToString(b))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("https://%s/unsubscribe?user=%s&token=%s", Conf().Web.ServerName, url.QueryEscape(userName),
+		token), nil
+}
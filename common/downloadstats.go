@@ -0,0 +1,159 @@
+package common
+
+import (
+	"sort"
+	"time"
+)
+
+// Project owners can see how many times each version of their project has been downloaded, so they can track how
+// quickly people move to a new release.  The downloads are counted per file (by SHA256) each day, and are split into
+// daily, weekly, or monthly buckets when asked for.  A file which is unchanged between versions is counted towards
+// each version it's in, as there's no way to tell which of them someone wanted.
+
+// Returns the start (in UTC) of the time bucket a date falls into.  Weeks start on Monday, the same as PostgreSQL's
+// date_trunc().
+func downloadBucket(t time.Time, interval string) time.Time {
+	b := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	switch interval {
+	case "week":
+		b = b.AddDate(0, 0, -((int(b.Weekday()) + 6) % 7))
+	case "month":
+		b = b.AddDate(0, 0, 1-b.Day())
+	}
+	return b
+}
+
+// Returns the start of the time bucket following the given one.
+func nextDownloadBucket(b time.Time, interval string) time.Time {
+	switch interval {
+	case "week":
+		return b.AddDate(0, 0, 7)
+	case "month":
+		return b.AddDate(0, 1, 0)
+	}
+	return b.AddDate(0, 0, 1)
+}
+
+// Returns the downloads of each file and version of a project over the last given number of days (in UTC), split
+// into day, week, or month buckets.  The first bucket can start before the period does, in which case only the
+// downloads inside the period are counted for it.  Versions are newest first.
+func ProjectDownloadStats(owner string, folder string, fileName string, interval string, days int) (stats DownloadStats,
+	err error) {
+	now := time.Now().UTC()
+	since := now.AddDate(0, 0, 1-days)
+	stats.Days, stats.Interval = days, interval
+
+	// Work out the time buckets
+	idx := make(map[time.Time]int)
+	for b := downloadBucket(since, interval); !b.After(now); b = nextDownloadBucket(b, interval) {
+		idx[b] = len(stats.Buckets)
+		stats.Buckets = append(stats.Buckets, b)
+	}
+
+	// Total up the downloads of each file for each bucket
+	counts, err := ProjectDailyDownloads(owner, folder, fileName, since)
+	if err != nil {
+		return
+	}
+	fileDownloads := make(map[string][]int64)
+	for _, c := range counts {
+		i, ok := idx[downloadBucket(c.Date, interval)]
+		if !ok {
+			continue
+		}
+		if fileDownloads[c.Sha256] == nil {
+			fileDownloads[c.Sha256] = make([]int64, len(stats.Buckets))
+		}
+		fileDownloads[c.Sha256][i] += c.Downloads
+	}
+
+	// Retrieve the versions of the project, along with the releases and tags pointing at them
+	commits, err := GetCommitList(owner, folder, fileName)
+	if err != nil {
+		return
+	}
+	releases, err := GetReleases(owner, folder, fileName)
+	if err != nil {
+		return
+	}
+	tags, err := GetTags(owner, folder, fileName)
+	if err != nil {
+		return
+	}
+	for _, c := range commits {
+		v := DownloadStatsVersion{
+			CommitID:  c.ID,
+			Downloads: make([]int64, len(stats.Buckets)),
+			Files:     []string{},
+			Message:   c.Message,
+			Releases:  []string{},
+			Tags:      []string{},
+			Timestamp: c.Timestamp,
+		}
+		for _, e := range c.Tree.Entries {
+			if e.Sha256 == "" {
+				continue
+			}
+			v.Files = append(v.Files, e.Sha256)
+			for i, n := range fileDownloads[e.Sha256] {
+				v.Downloads[i] += n
+				v.Total += n
+			}
+		}
+		for name, rel := range releases {
+			if rel.Commit == c.ID {
+				v.Releases = append(v.Releases, name)
+			}
+		}
+		for name, tag := range tags {
+			if tag.Commit == c.ID {
+				v.Tags = append(v.Tags, name)
+			}
+		}
+		sort.Strings(v.Releases)
+		sort.Strings(v.Tags)
+		stats.Versions = append(stats.Versions, v)
+	}
+	sort.Slice(stats.Versions, func(i, j int) bool {
+		return stats.Versions[i].Timestamp.After(stats.Versions[j].Timestamp)
+	})
+
+	// List the files in the order they first appear in the versions, followed by any downloaded files which are no
+	// longer in the project (eg from a deleted commit)
+	seen := make(map[string]bool)
+	addFile := func(f DownloadStatsFile) {
+		seen[f.Sha256] = true
+		f.Downloads = fileDownloads[f.Sha256]
+		if f.Downloads == nil {
+			f.Downloads = make([]int64, len(stats.Buckets))
+		}
+		for _, n := range f.Downloads {
+			f.Total += n
+		}
+		stats.Files = append(stats.Files, f)
+	}
+	for _, v := range stats.Versions {
+		for _, e := range commits[v.CommitID].Tree.Entries {
+			if e.Sha256 != "" && !seen[e.Sha256] {
+				addFile(DownloadStatsFile{Name: e.Name, Sha256: e.Sha256, Size: e.Size})
+			}
+		}
+	}
+	var gone []string
+	for sha := range fileDownloads {
+		if !seen[sha] {
+			gone = append(gone, sha)
+		}
+	}
+	sort.Strings(gone)
+	for _, sha := range gone {
+		addFile(DownloadStatsFile{Sha256: sha})
+	}
+	if stats.Files == nil {
+		stats.Files = []DownloadStatsFile{}
+	}
+	if stats.Versions == nil {
+		stats.Versions = []DownloadStatsVersion{}
+	}
+	return
+}
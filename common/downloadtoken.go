@@ -0,0 +1,69 @@
+package common
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// Instances can make anonymous downloads need a token, to slow down mass scraping without making people sign up.  A
+// token is given out with the first download of a browser session, and each one only allows so many downloads an
+// hour.  The number of tokens given to each address is limited too, so clients which throw their tokens away don't
+// get around it.  The tokens and counts are kept in the cache backend, so they're shared by all of the webui
+// instances.  If the cache can't be reached the downloads are let through.
+
+// How long a download token lasts for.  Browser sessions don't often last longer than this
+const downloadTokenLifetime = 24 * time.Hour
+
+// Returns the cache key for something to do with download tokens, in the current hour when hourly is set.
+func downloadTokenCacheKey(kind string, id string, hourly bool) string {
+	cacheString := fmt.Sprintf("dltoken-%s-%s", kind, id)
+	if hourly {
+		cacheString += fmt.Sprintf("-%d", time.Now().Unix()/3600)
+	}
+	tempArr := md5.Sum([]byte(cacheString))
+	return hex.EncodeToString(tempArr[:])
+}
+
+// Gives out a new download token to a client (eg an IP address).  Returns false instead if the client has already
+// been given as many tokens as it's allowed this hour.
+func IssueDownloadToken(client string) (token string, ok bool, err error) {
+	defer traceSpan("memcache", "IssueDownloadToken", time.Now())
+	n, err := dataCache.Increment(downloadTokenCacheKey("issued", client, true), 2*time.Hour)
+	if err != nil {
+		return
+	}
+	if n > uint64(Conf().Web.DownloadTokensPerIP) {
+		return "", false, nil
+	}
+	b := make([]byte, 16)
+	if _, err = rand.Read(b); err != nil {
+		return
+	}
+	token = hex.EncodeToString(b)
+	err = dataCache.Set(downloadTokenCacheKey("token", token, false), []byte(client), downloadTokenLifetime)
+	if err != nil {
+		return
+	}
+	return token, true, nil
+}
+
+// Counts a download made using a token.  Returns whether the token is one we gave out, and whether it's still under
+// its download limit for this hour.
+func UseDownloadToken(token string) (valid bool, ok bool, err error) {
+	defer traceSpan("memcache", "UseDownloadToken", time.Now())
+	if token == "" {
+		return false, false, nil
+	}
+	_, valid, err = dataCache.Get(downloadTokenCacheKey("token", token, false))
+	if err != nil || !valid {
+		return
+	}
+	n, err := dataCache.Increment(downloadTokenCacheKey("used", token, true), 2*time.Hour)
+	if err != nil {
+		return
+	}
+	return true, n <= uint64(Conf().Web.DownloadTokenLimit), nil
+}
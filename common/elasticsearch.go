@@ -0,0 +1,188 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+)
+
+// The Elasticsearch (7.x and newer) search backend, talking to the server using its REST API
+type elasticSearch struct {
+	index  string
+	server string
+}
+
+// The settings and field mappings for the Elasticsearch index.  The keyword fields use a lower case normaliser, so
+// the filters on them are case insensitive.
+const elasticMapping = `{
+	"settings": {
+		"analysis": {
+			"normalizer": {
+				"lowercase": {"type": "custom", "filter": ["lowercase"]}
+			}
+		}
+	},
+	"mappings": {
+		"properties": {
+			"category": {"type": "keyword"},
+			"date_created": {"type": "date"},
+			"db_name": {"type": "text", "analyzer": "simple",
+				"fields": {"raw": {"type": "keyword", "normalizer": "lowercase"}}},
+			"downloads": {"type": "integer"},
+			"folder": {"type": "keyword"},
+			"full_description": {"type": "text", "analyzer": "english"},
+			"last_modified": {"type": "date"},
+			"licence": {"type": "keyword", "normalizer": "lowercase"},
+			"licence_url": {"type": "keyword", "index": false},
+			"one_line_description": {"type": "text", "analyzer": "english"},
+			"owner": {"type": "keyword", "normalizer": "lowercase"},
+			"project_tags": {"type": "keyword", "normalizer": "lowercase",
+				"fields": {"words": {"type": "text", "analyzer": "english"}}},
+			"public": {"type": "boolean"},
+			"stars": {"type": "integer"},
+			"triangle_count": {"type": "long"}
+		}
+	}
+}`
+
+func (e elasticSearch) CreateIndex() error {
+	err := searchRequest(http.MethodDelete, e.server+"/"+e.index, nil, nil)
+	if err != nil {
+		return err
+	}
+	return searchRequest(http.MethodPut, e.server+"/"+e.index, json.RawMessage(elasticMapping), nil)
+}
+
+func (e elasticSearch) IndexProject(doc SearchDocument) error {
+	return searchRequest(http.MethodPut, fmt.Sprintf("%s/%s/_doc/%s", e.server, e.index,
+		searchDocID(doc.Owner, doc.Folder, doc.DBName)), doc, nil)
+}
+
+func (e elasticSearch) RemoveProject(owner string, folder string, fileName string) error {
+	return searchRequest(http.MethodDelete, fmt.Sprintf("%s/%s/_doc/%s", e.server, e.index,
+		searchDocID(owner, folder, fileName)), nil, nil)
+}
+
+func (e elasticSearch) Search(loggedInUser string, filters SearchFilters, offset int) (results []SearchResult,
+	total int, err error) {
+	type m map[string]interface{}
+
+	// Only public projects, plus the logged in user's own private ones
+	visible := []interface{}{m{"term": m{"public": true}}}
+	if loggedInUser != "" {
+		visible = append(visible, m{"term": m{"owner": loggedInUser}})
+	}
+	filter := []interface{}{m{"bool": m{"should": visible, "minimum_should_match": 1}}}
+
+	// Add the other filters
+	if filters.Tag != "" {
+		filter = append(filter, m{"term": m{"project_tags": filters.Tag}})
+	}
+	if filters.Category != "" {
+		filter = append(filter, m{"bool": m{"should": []interface{}{
+			m{"term": m{"category": filters.Category}},
+			m{"prefix": m{"category": filters.Category + "/"}},
+		}, "minimum_should_match": 1}})
+	}
+	if filters.Owner != "" {
+		filter = append(filter, m{"term": m{"owner": filters.Owner}})
+	}
+	if filters.Format != "" {
+		filter = append(filter, m{"wildcard": m{"db_name.raw": "*." + filters.Format}})
+	}
+	if filters.Licence != "" {
+		filter = append(filter, m{"term": m{"licence": filters.Licence}})
+	}
+	if filters.MinTris > 0 {
+		filter = append(filter, m{"range": m{"triangle_count": m{"gte": filters.MinTris}}})
+	}
+	switch filters.StarsOp {
+	case "=":
+		filter = append(filter, m{"term": m{"stars": filters.Stars}})
+	case ">", ">=", "<", "<=":
+		op := map[string]string{">": "gt", ">=": "gte", "<": "lt", "<=": "lte"}[filters.StarsOp]
+		filter = append(filter, m{"range": m{"stars": m{op: filters.Stars}}})
+	}
+
+	// Without any search text, all projects matching the filters are returned
+	must := m{"match_all": m{}}
+	if filters.Text != "" {
+		must = m{"multi_match": m{
+			"query":  filters.Text,
+			"fields": []string{"db_name^4", "one_line_description^2", "project_tags.words^2", "full_description"},
+		}}
+	}
+
+	// Best matches first, unless a different sort order was requested
+	sort := []interface{}{"_score", m{"stars": "desc"}, m{"last_modified": "desc"}}
+	switch filters.Sort {
+	case SORT_DOWNLOADS:
+		sort = []interface{}{m{"downloads": "desc"}, m{"last_modified": "desc"}}
+	case SORT_NEWEST:
+		sort = []interface{}{m{"date_created": "desc"}}
+	case SORT_STARS:
+		sort = []interface{}{m{"stars": "desc"}, m{"last_modified": "desc"}}
+	case SORT_UPDATED:
+		sort = []interface{}{m{"last_modified": "desc"}}
+	}
+	query := m{
+		"from":             offset,
+		"size":             SearchResultsPageSize,
+		"track_total_hits": true,
+		"query":            m{"bool": m{"must": must, "filter": filter}},
+		"sort":             sort,
+		"highlight": m{
+			"encoder":   "html",
+			"pre_tags":  []string{"<mark>"},
+			"post_tags": []string{"</mark>"},
+			"fields": m{
+				"one_line_description": m{"number_of_fragments": 0},
+				"full_description":     m{"number_of_fragments": 2},
+			},
+		},
+	}
+
+	// Run the search
+	var resp struct {
+		Hits struct {
+			Total struct {
+				Value int `json:"value"`
+			} `json:"total"`
+			Hits []struct {
+				Highlight map[string][]string `json:"highlight"`
+				Score     float32             `json:"_score"`
+				Source    SearchDocument      `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	err = searchRequest(http.MethodPost, fmt.Sprintf("%s/%s/_search", e.server, e.index), query, &resp)
+	if err != nil {
+		return
+	}
+	for _, hit := range resp.Hits.Hits {
+		doc := hit.Source
+		oneRow := SearchResult{
+			DBName:        doc.DBName,
+			DescHighlight: html.EscapeString(doc.OneLineDesc),
+			LastModified:  doc.LastModified,
+			Licence:       doc.Licence,
+			LicenceURL:    doc.LicenceURL,
+			OneLineDesc:   doc.OneLineDesc,
+			Owner:         doc.Owner,
+			ProjectTags:   doc.ProjectTags,
+			Rank:          hit.Score,
+			Stars:         doc.Stars,
+			URL:           fmt.Sprintf("/%s/%s", doc.Owner, doc.DBName),
+		}
+		if h := hit.Highlight["one_line_description"]; len(h) > 0 {
+			oneRow.DescHighlight = h[0]
+		}
+		if h := hit.Highlight["full_description"]; len(h) > 0 {
+			oneRow.FullDescHighlight = joinFragments(h)
+		}
+		results = append(results, oneRow)
+	}
+	total = resp.Hits.Total.Value
+	return
+}
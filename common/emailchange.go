@@ -0,0 +1,78 @@
+package common
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// Users change their email address from their preferences page.  The change only happens once it's been confirmed
+// using links emailed to both the old and new addresses, so someone with access to a logged in session can't quietly
+// move the account to an address they control.  Addresses which can't receive email (the placeholder username@server
+// one) don't need to confirm.
+
+// How long the confirmation links for a change of email address work for
+const EmailChangeExpiry = 48 * time.Hour
+
+// Returns a random token for an email change confirmation link.
+func emailChangeToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Starts changing a user's email address, emailing confirmation links to the old and new addresses.  If neither can
+// receive email there's nothing to confirm, so the address is changed straight away and the returned value is false.
+func RequestEmailChange(userName string, newEmail string) (pending bool, err error) {
+	usr, err := User(userName)
+	if err != nil {
+		return
+	}
+	var oldToken, newToken string
+	if CanEmailUser(usr) {
+		if oldToken, err = emailChangeToken(); err != nil {
+			return
+		}
+	}
+	if CanEmailUser(UserDetails{Email: newEmail, Username: usr.Username}) {
+		if newToken, err = emailChangeToken(); err != nil {
+			return
+		}
+	}
+	if oldToken == "" && newToken == "" {
+		return false, SetUserEmail(userName, newEmail)
+	}
+
+	err = StoreEmailChange(userName, newEmail, oldToken, newToken, time.Now().Add(EmailChangeExpiry))
+	if err != nil {
+		return
+	}
+	confirmURL := "https://" + Conf().Web.ServerName + "/confirmemail?token="
+	if oldToken != "" {
+		err = QueueEmail(usr.Email, "3DHub.io: Confirm the change of your email address",
+			fmt.Sprintf("Someone (hopefully you) asked for the email address of your 3DHub.io account '%s' to be "+
+				"changed to %s.\n\nTo confirm it, visit %s%s\n\nIf it wasn't you, cancel the change from the same "+
+				"link.  That also logs out everywhere your account is logged in, in case someone else is using it.  "+
+				"The link stops working after %d hours.", usr.Username, newEmail, confirmURL, oldToken,
+				int(EmailChangeExpiry.Hours())))
+		if err != nil {
+			return
+		}
+	}
+	if newToken != "" {
+		err = QueueEmail(newEmail, "3DHub.io: Confirm your new email address",
+			fmt.Sprintf("Someone (hopefully you) asked for the email address of the 3DHub.io account '%s' to be "+
+				"changed to this one.\n\nTo confirm it, visit %s%s\n\nIf it wasn't you, just ignore this email.  "+
+				"The link stops working after %d hours.", usr.Username, confirmURL, newToken,
+				int(EmailChangeExpiry.Hours())))
+		if err != nil {
+			return
+		}
+	}
+	Log.Infof("User '%s' asked to change their email address from '%s' to '%s'", usr.Username, usr.Email,
+		newEmail)
+	return true, nil
+}
@@ -0,0 +1,218 @@
+package common
+
+import (
+	"archive/zip"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	sqlite "github.com/gwenn/gosqlite"
+)
+
+// The most rows an XLSX worksheet can hold
+const MaxXLSXRows = 1048576
+
+// A format table data can be downloaded in, other than CSV (which has its own options, so is handled separately)
+type ExportFormat struct {
+	ContentType string
+	Extension   string
+	write       func(sdb *sqlite.Conn, dbTable string, w io.Writer) error
+}
+
+// The table export formats, by the name used to ask for them
+var ExportFormats = map[string]ExportFormat{
+	"jsonl": {
+		ContentType: "application/x-ndjson",
+		Extension:   "jsonl",
+		write:       writeTableJSONLines,
+	},
+	"parquet": {
+		ContentType: "application/vnd.apache.parquet",
+		Extension:   "parquet",
+		write:       writeTableParquet,
+	},
+	"xlsx": {
+		ContentType: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+		Extension:   "xlsx",
+		write:       writeTableXLSX,
+	},
+}
+
+// Reads the rows of a SQLite table, passing the column names to start() and then each row to fn().  The values are
+// nil, int64, float64, string, or []byte, depending on the type of data in each field.
+func scanTableRows(sdb *sqlite.Conn, dbTable string, start func(cols []string) error,
+	fn func(vals []interface{}) error) error {
+	stmt, err := sdb.Prepare(`SELECT * FROM "` + strings.Replace(dbTable, `"`, `""`, -1) + `"`)
+	if err != nil {
+		Log.Errorf("Error when preparing statement for database: %s", err)
+		return err
+	}
+	defer stmt.Finalize()
+	cols := stmt.ColumnNames()
+	if err = start(cols); err != nil {
+		return err
+	}
+	vals := make([]interface{}, len(cols))
+	return stmt.Select(func(s *sqlite.Stmt) error {
+		s.ScanValues(vals)
+		return fn(vals)
+	})
+}
+
+// Writes the data in a SQLite table in the given format.  The output is sent as it's generated, rather than being
+// built up in memory first.
+func (f ExportFormat) Write(sdb *sqlite.Conn, dbTable string, w io.Writer) error {
+	err := f.write(sdb, dbTable, w)
+	if err != nil {
+		Log.Errorf("Error when writing %s data for table '%s': %s", f.Extension, dbTable, err)
+	}
+	return err
+}
+
+// Writes a table as JSON Lines, with each row being a JSON object on its own line.  Blobs are base64 encoded, the
+// same as for CSV.
+func writeTableJSONLines(sdb *sqlite.Conn, dbTable string, w io.Writer) error {
+	var keys [][]byte
+	start := func(cols []string) error {
+		for _, c := range cols {
+			k, err := json.Marshal(c)
+			if err != nil {
+				return err
+			}
+			keys = append(keys, k)
+		}
+		return nil
+	}
+
+	// The objects are put together by hand, so the fields stay in the same order as the table columns
+	var line []byte
+	return scanTableRows(sdb, dbTable, start, func(vals []interface{}) error {
+		line = append(line[:0], '{')
+		for i, v := range vals {
+			if i > 0 {
+				line = append(line, ',')
+			}
+			line = append(line, keys[i]...)
+			line = append(line, ':')
+			if f, ok := v.(float64); ok && (math.IsInf(f, 0) || math.IsNaN(f)) {
+				// JSON has no way to write infinity
+				v = nil
+			}
+			b, err := json.Marshal(v)
+			if err != nil {
+				return err
+			}
+			line = append(line, b...)
+		}
+		line = append(line, '}', '\n')
+		_, err := w.Write(line)
+		return err
+	})
+}
+
+// Writes a table as an Excel spreadsheet, with the column names as the first row.  Only what's needed for a single
+// worksheet is included, so the rows can be written out as they're read.
+func writeTableXLSX(sdb *sqlite.Conn, dbTable string, w io.Writer) error {
+	z := zip.NewWriter(w)
+
+	// Worksheet names are limited to 31 characters, and can't contain some punctuation
+	sheetName := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return '_'
+		}
+		return r
+	}, dbTable)
+	if r := []rune(sheetName); len(r) > 31 {
+		sheetName = string(r[:31])
+	}
+	var escName strings.Builder
+	xml.EscapeText(&escName, []byte(sheetName))
+
+	parts := []struct{ name, body string }{
+		{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/></Types>`},
+		{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`},
+		{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="` + escName.String() + `" sheetId="1" r:id="rId1"/></sheets></workbook>`},
+		{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/></Relationships>`},
+	}
+	for _, p := range parts {
+		f, err := z.Create(p.name)
+		if err != nil {
+			return err
+		}
+		if _, err = io.WriteString(f, p.body); err != nil {
+			return err
+		}
+	}
+
+	sheet, err := z.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(sheet, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	if err != nil {
+		return err
+	}
+	var buf strings.Builder
+	textCell := func(s string) {
+		buf.WriteString(`<c t="inlineStr"><is><t xml:space="preserve">`)
+		xml.EscapeText(&buf, []byte(s))
+		buf.WriteString(`</t></is></c>`)
+	}
+	start := func(cols []string) error {
+		buf.WriteString(`<row>`)
+		for _, c := range cols {
+			textCell(c)
+		}
+		buf.WriteString(`</row>`)
+		_, err := io.WriteString(sheet, buf.String())
+		return err
+	}
+	rowNum := 1
+	err = scanTableRows(sdb, dbTable, start, func(vals []interface{}) error {
+		if rowNum >= MaxXLSXRows {
+			return errors.New("Too many rows for an XLSX worksheet")
+		}
+		buf.Reset()
+		buf.WriteString(`<row>`)
+		for _, v := range vals {
+			switch v := v.(type) {
+			case nil:
+				buf.WriteString(`<c/>`)
+			case int64:
+				fmt.Fprintf(&buf, `<c><v>%d</v></c>`, v)
+			case float64:
+				if math.IsInf(v, 0) || math.IsNaN(v) {
+					textCell(strconv.FormatFloat(v, 'g', -1, 64))
+				} else {
+					fmt.Fprintf(&buf, `<c><v>%s</v></c>`, strconv.FormatFloat(v, 'g', -1, 64))
+				}
+			case string:
+				textCell(v)
+			case []byte:
+				textCell(base64.StdEncoding.EncodeToString(v))
+			}
+		}
+		buf.WriteString(`</row>`)
+		rowNum++
+		_, err := io.WriteString(sheet, buf.String())
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if _, err = io.WriteString(sheet, `</sheetData></worksheet>`); err != nil {
+		return err
+	}
+	return z.Close()
+}
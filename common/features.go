@@ -0,0 +1,81 @@
+package common
+
+import (
+	"sync"
+	"time"
+)
+
+// Some features can be turned on or off by the site admins, from the admin page.  Features are off until an admin
+// turns them on.
+
+// The features which can be turned on or off
+const (
+	FeatureTips = "tips"
+)
+
+// The details shown on the admin page for each feature
+var Features = []FeatureFlag{
+	{ID: FeatureTips, Name: "Tip links", Description: "Lets users add tip and donation links (Ko-fi, Liberapay, " +
+		"PayPal) to their profile, which are also shown on their project pages"},
+}
+
+// The features which are turned on.  They're only looked up once a minute (or straight after an admin changes one),
+// so checking a feature doesn't need a database query each time.
+var (
+	featuresChecked time.Time
+	featuresEnabled map[string]bool
+	featuresMu      sync.Mutex
+)
+
+// Returns whether a feature is turned on.
+func FeatureEnabled(id string) bool {
+	featuresMu.Lock()
+	defer featuresMu.Unlock()
+	if time.Since(featuresChecked) > time.Minute {
+		flags, err := FeatureFlags()
+		if err == nil {
+			featuresEnabled = make(map[string]bool)
+			for _, f := range flags {
+				featuresEnabled[f.ID] = f.Enabled
+			}
+		}
+		featuresChecked = time.Now()
+	}
+	return featuresEnabled[id]
+}
+
+// Returns whether the given ID is one of the features which can be turned on or off.
+func KnownFeature(id string) bool {
+	for _, f := range Features {
+		if f.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Turns a feature on or off, applying the change straight away.
+func SetFeature(adminUser string, id string, enabled bool) error {
+	err := StoreFeatureFlag(adminUser, id, enabled)
+	if err != nil {
+		return err
+	}
+	featuresMu.Lock()
+	featuresChecked = time.Time{}
+	featuresMu.Unlock()
+	return nil
+}
+
+// Returns the tip links to show on a user's profile and project pages, in the same order as TipServices.  Nothing is
+// shown while the tips feature is off.
+func VisibleTipLinks(links map[string]string) (list []TipLink) {
+	if len(links) == 0 || !FeatureEnabled(FeatureTips) {
+		return
+	}
+	for _, t := range TipServices {
+		if u, ok := links[t.ID]; ok {
+			list = append(list, TipLink{Name: t.Name, URL: u})
+		}
+	}
+	return
+}
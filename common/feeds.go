@@ -0,0 +1,80 @@
+package common
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"time"
+)
+
+// The structures used to generate Atom (RFC 4287) feeds
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Entries []atomEntry `xml:"entry"`
+	ID      string      `xml:"id"`
+	Links   []atomLink  `xml:"link"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+}
+
+type atomEntry struct {
+	Author  atomAuthor `xml:"author"`
+	ID      string     `xml:"id"`
+	Link    atomLink   `xml:"link"`
+	Summary string     `xml:"summary,omitempty"`
+	Title   string     `xml:"title"`
+	Updated string     `xml:"updated"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+// Returns the absolute URL for a path on the website.
+func absoluteURL(path string) string {
+	return fmt.Sprintf("https://%s%s", Conf().Web.ServerName, path)
+}
+
+// Writes an Atom feed with the given entries.  The feed and entry URLs are given as paths on the website, eg
+// "/feeds/recent", and turned into absolute URLs here.
+func WriteAtomFeed(w io.Writer, title string, feedPath string, sitePath string, entries []FeedEntry) error {
+	f := atomFeed{
+		ID:    absoluteURL(feedPath),
+		Title: title,
+		Links: []atomLink{
+			{Href: absoluteURL(feedPath), Rel: "self"},
+			{Href: absoluteURL(sitePath), Rel: "alternate"},
+		},
+	}
+
+	// The feed was last updated when its newest entry was.  Feeds without entries use the current time instead.
+	updated := time.Now()
+	if len(entries) > 0 {
+		updated = entries[0].Updated
+	}
+	f.Updated = updated.UTC().Format(time.RFC3339)
+
+	for _, e := range entries {
+		f.Entries = append(f.Entries, atomEntry{
+			Author:  atomAuthor{Name: e.Author},
+			ID:      absoluteURL(e.ID),
+			Link:    atomLink{Href: absoluteURL(e.URL)},
+			Summary: e.Content,
+			Title:   e.Title,
+			Updated: e.Updated.UTC().Format(time.RFC3339),
+		})
+	}
+
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	return enc.Encode(f)
+}
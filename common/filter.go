@@ -0,0 +1,108 @@
+package common
+
+import (
+	"errors"
+	"path"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// The word filter checks usernames, public project names, and comments for words the site doesn't want to see.  The
+// words come from the config file, plus any added by site admins.  Matches aren't rejected outright, as the filter
+// will always catch some innocent things: comments are held for moderation, projects are hidden until a moderator has
+// looked at them, and new users with matching names are added to a list for moderators to check.
+//
+// Each entry is compared against the separate words of the text, ignoring case.  Entries can use "*" to match any run
+// of characters, and "?" to match a single character, eg "spam*" matches "spammer".  Names are also checked with
+// their punctuation taken out, so "s_p.a-m" is caught too.
+
+// The word filter entries added by site admins, and when they were last looked up
+var (
+	filterWords        []string
+	filterWordsChecked time.Time
+	filterWordsMu      sync.Mutex
+)
+
+// Checks a username or project name against the word filter, returning the entry it matched.
+func FilterName(name string) (pattern string, matched bool) {
+	if pattern, matched = FilterText(name); matched {
+		return
+	}
+	joined := strings.Join(filterSplit(name), "")
+	return filterMatch([]string{joined})
+}
+
+// Checks some text (eg a comment) against the word filter, returning the entry it matched.
+func FilterText(text string) (pattern string, matched bool) {
+	return filterMatch(filterSplit(text))
+}
+
+// Returns whether any of the given words match a word filter entry, and which entry it was.
+func filterMatch(words []string) (pattern string, matched bool) {
+	if !Conf().Filter.Enabled {
+		return
+	}
+	patterns := append(append([]string{}, Conf().Filter.Words...), filterWordList()...)
+	for _, p := range patterns {
+		p = strings.ToLower(strings.TrimSpace(p))
+		if p == "" {
+			continue
+		}
+		for _, w := range words {
+			if ok, _ := path.Match(p, w); ok {
+				return p, true
+			}
+		}
+	}
+	return
+}
+
+// Splits text into lower case words, dropping the spaces and punctuation between them.
+func filterSplit(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsMark(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Returns the word filter entries added by site admins.  Like the reserved usernames, they're only looked up once a
+// minute (or straight after an admin changes them).
+func filterWordList() []string {
+	filterWordsMu.Lock()
+	defer filterWordsMu.Unlock()
+	if time.Since(filterWordsChecked) > time.Minute {
+		list, err := FilterWords()
+		if err == nil {
+			filterWords = nil
+			for _, f := range list {
+				filterWords = append(filterWords, f.Pattern)
+			}
+		}
+		filterWordsChecked = time.Now()
+	}
+	return filterWords
+}
+
+// Makes changes to the word filter apply straight away, rather than waiting for the cached list to expire.
+func ResetFilterWords() {
+	filterWordsMu.Lock()
+	filterWordsChecked = time.Time{}
+	filterWordsMu.Unlock()
+}
+
+// Validate a word filter entry.  It's a single word, which can use the "*" and "?" wildcards.
+func ValidateFilterWord(pattern string) error {
+	if pattern == "" || len(pattern) > 63 {
+		return errors.New("Word filter entries need to be between 1 and 63 characters long")
+	}
+	if strings.Trim(pattern, "*?") == "" {
+		return errors.New("Word filter entries can't be only wildcards")
+	}
+	for _, r := range pattern {
+		if !unicode.IsLetter(r) && !unicode.IsMark(r) && !unicode.IsDigit(r) && r != '*' && r != '?' {
+			return errors.New("Word filter entries can only have letters, digits, and the '*' and '?' wildcards")
+		}
+	}
+	return nil
+}
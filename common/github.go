@@ -0,0 +1,506 @@
+package common
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// GitHub repositories (or a folder in one) can be imported, with each of the 3D model files in them becoming a
+// project.  The projects can be kept up to date with the repository, either on a schedule, or whenever GitHub tells us
+// (using a webhook) that something has been pushed to it.  Only public repositories can be imported.
+//
+// Projects can be mirrored the other way too.  New commits to the default branch of a mirrored project are pushed to
+// a GitHub repository using an access token given by its owner, and releases become tags there.
+
+// How often the GitHub imports are checked to see if any are due to be synced
+const githubSyncCheckInterval = 5 * time.Minute
+
+// The largest file GitHub accepts
+const githubMaxFileSize = 100 * 1024 * 1024
+
+// Used as the request for the uploads made when syncing, as there isn't a web request to go with them
+var githubSyncRequest = &http.Request{
+	Header:     http.Header{"User-Agent": {"3DHub GitHub sync"}},
+	RemoteAddr: "github.com",
+}
+
+// An error response from the GitHub API
+type githubError struct {
+	Message string `json:"message"`
+	Status  int    `json:"-"`
+}
+
+func (e githubError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("GitHub responded with status %d", e.Status)
+	}
+	return fmt.Sprintf("GitHub responded with status %d: %s", e.Status, e.Message)
+}
+
+// Checks the signature GitHub gives a webhook request (in its X-Hub-Signature-256 header) was made using the secret
+// for the import.
+func CheckGitHubSignature(secret string, body []byte, signature string) bool {
+	if secret == "" || !strings.HasPrefix(signature, "sha256=") {
+		return false
+	}
+	want, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), want)
+}
+
+// Returns the default branch of a GitHub repository, and whether it's private.  Private repositories need an access
+// token which can read them, otherwise the API token from the configuration file (if any) is used.
+func GitHubDefaultBranch(repo string, token string) (branch string, private bool, err error) {
+	if token == "" {
+		token = Conf().Import.GitHubToken
+	}
+	var r struct {
+		DefaultBranch string `json:"default_branch"`
+		Private       bool   `json:"private"`
+	}
+	err = githubAPI(http.MethodGet, "https://api.github.com/repos/"+repo, token, nil, &r)
+	return r.DefaultBranch, r.Private, err
+}
+
+// Sends a request to the GitHub API, using the given access token (if any).  The request and response bodies are
+// JSON, and either can be nil.
+func githubAPI(method string, u string, token string, in interface{}, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	if token != "" {
+		req.Header.Set("Authorization", "token "+token)
+	}
+	resp, err := importClient.Do(req)
+	if err != nil {
+		Log.Warnf("GitHub API request to '%s' failed: %v", u, err)
+		return errors.New("Couldn't connect to GitHub")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		e := githubError{Status: resp.StatusCode}
+		json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&e)
+		return e
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 8<<20)).Decode(out)
+}
+
+// Retrieves and decodes a JSON response from the GitHub API, using the API token from the configuration file if
+// there is one.
+func githubGetJSON(u string, v interface{}) error {
+	return githubAPI(http.MethodGet, u, Conf().Import.GitHubToken, nil, v)
+}
+
+// Runs a background job which pushes a project to its GitHub mirror.
+func GitHubMirrorJob(payload json.RawMessage) error {
+	var p struct {
+		FileName string `json:"file_name"`
+		Folder   string `json:"folder"`
+		Owner    string `json:"owner"`
+	}
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return err
+	}
+	return PushGitHubMirror(p.Owner, p.Folder, p.FileName)
+}
+
+// Runs a background job which syncs a GitHub import.
+func GitHubSyncJob(payload json.RawMessage) error {
+	var p struct {
+		ImportID int64 `json:"import_id"`
+	}
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return err
+	}
+	created, _, err := SyncGitHubImport(p.ImportID)
+	if err == nil && len(created) != 0 {
+		Log.Infof("GitHub import %d created projects: %s", p.ImportID, strings.Join(created, ", "))
+	}
+	return err
+}
+
+// Periodically queues a sync of the GitHub imports which are due for one on their schedule.  Only one server does
+// this at a time.
+func GitHubSyncLoop() {
+	for {
+		if HoldJobLock("github-sync", githubSyncCheckInterval) {
+			ids, err := DueGitHubImports()
+			if err == nil {
+				for _, id := range ids {
+					QueueGitHubSync(id)
+				}
+			}
+		}
+		time.Sleep(githubSyncCheckInterval)
+	}
+}
+
+// Works out the repository, branch, and folder from the URL of a GitHub repository, or of a folder in one (eg
+// "https://github.com/owner/repo/tree/main/models").  A plain "owner/repo" is accepted too.  The branch is empty when
+// the URL doesn't give one.
+func ParseGitHubURL(repoURL string) (repo string, branch string, folder string, err error) {
+	s := strings.TrimSpace(repoURL)
+	if strings.Contains(s, "://") {
+		u, err := url.Parse(s)
+		if err != nil || strings.TrimPrefix(strings.ToLower(u.Host), "www.") != "github.com" {
+			return "", "", "", errors.New("That doesn't look like the URL of a GitHub repository")
+		}
+		s = u.Path
+	}
+	parts := strings.Split(strings.Trim(s, "/"), "/")
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", "", errors.New("That doesn't look like the URL of a GitHub repository")
+	}
+	repo = parts[0] + "/" + strings.TrimSuffix(parts[1], ".git")
+	for _, p := range strings.Split(repo, "/") {
+		if strings.Trim(p, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_.") != "" {
+			return "", "", "", errors.New("That doesn't look like the URL of a GitHub repository")
+		}
+	}
+	if len(parts) > 3 && parts[2] == "tree" {
+		branch = parts[3]
+		folder = strings.Join(parts[4:], "/")
+	}
+	return
+}
+
+// Pushes the commits made to the default branch of a project since it was last mirrored to GitHub, then tags the
+// GitHub commits which have releases.  The first time, only the latest commit is pushed.  Commits which only changed
+// the details of the project (eg its description) don't change the file, so they aren't pushed.
+func PushGitHubMirror(owner string, folder string, fileName string) (err error) {
+	m, found, err := GitHubMirror(owner, folder, fileName)
+	if err != nil || !found {
+		return
+	}
+	defer func() {
+		msg := ""
+		if err != nil {
+			msg = err.Error()
+		}
+		StoreGitHubMirrorResult(owner, folder, fileName, m, msg)
+	}()
+	if m.Token == "" {
+		return errors.New("The stored GitHub access token couldn't be used.  Please enter it again")
+	}
+
+	// Work out which commits need pushing, newest first
+	head, err := DefaultCommit(owner, folder, fileName)
+	if err != nil {
+		return
+	}
+	commits, err := GetCommitList(owner, folder, fileName)
+	if err != nil {
+		return
+	}
+	var pending []CommitEntry
+	for id := head; id != m.LastCommit; {
+		c, ok := commits[id]
+		if !ok || len(c.Tree.Entries) == 0 {
+			return fmt.Errorf("Commit '%s' is missing from the project history", id)
+		}
+		pending = append(pending, c)
+		if m.LastCommit == "" {
+			break
+		}
+		if c.Parent == "" {
+			return errors.New("The project history has changed since it was last mirrored, so it can't be pushed " +
+				"to GitHub.  Removing the mirror and adding it again will start it afresh")
+		}
+		id = c.Parent
+	}
+
+	// Push the commits, oldest first, on top of the branch on GitHub
+	api := "https://api.github.com/repos/" + m.Repo + "/git/"
+	var ref struct {
+		Object struct {
+			SHA string `json:"sha"`
+		} `json:"object"`
+	}
+	if len(pending) != 0 {
+		err = githubAPI(http.MethodGet, api+"ref/heads/"+m.Branch, m.Token, nil, &ref)
+		if err != nil {
+			return
+		}
+	}
+	parent := ref.Object.SHA
+	var prevSHA string
+	if c, ok := commits[m.LastCommit]; ok && len(c.Tree.Entries) != 0 {
+		prevSHA = c.Tree.Entries[0].Sha256
+	}
+	for i := len(pending) - 1; i >= 0; i-- {
+		c := pending[i]
+		e := c.Tree.Entries[0]
+		if e.Sha256 == prevSHA {
+			m.PushedCommits[c.ID] = parent
+			m.LastCommit = c.ID
+			continue
+		}
+		if e.Size > githubMaxFileSize {
+			return fmt.Errorf("'%s' is larger than the 100 MB GitHub allows for a file", fileName)
+		}
+
+		// Add the file to GitHub, then a tree with it in, then a commit with that tree
+		obj, err := MinioHandle(e.Sha256[:MinioFolderChars], e.Sha256[MinioFolderChars:])
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadAll(obj)
+		MinioHandleClose(obj)
+		if err != nil {
+			return err
+		}
+		var blob, tree, commit, parentCommit struct {
+			SHA  string `json:"sha"`
+			Tree struct {
+				SHA string `json:"sha"`
+			} `json:"tree"`
+		}
+		err = githubAPI(http.MethodPost, api+"blobs", m.Token, map[string]string{
+			"content":  base64.StdEncoding.EncodeToString(data),
+			"encoding": "base64",
+		}, &blob)
+		if err != nil {
+			return err
+		}
+		err = githubAPI(http.MethodGet, api+"commits/"+parent, m.Token, nil, &parentCommit)
+		if err != nil {
+			return err
+		}
+		err = githubAPI(http.MethodPost, api+"trees", m.Token, map[string]interface{}{
+			"base_tree": parentCommit.Tree.SHA,
+			"tree":      []map[string]string{{"path": m.Path, "mode": "100644", "type": "blob", "sha": blob.SHA}},
+		}, &tree)
+		if err != nil {
+			return err
+		}
+		newCommit := map[string]interface{}{
+			"message": fmt.Sprintf("%s\n\nMirrored from https://%s/%s%s%s (commit %s)",
+				strings.TrimSpace(c.Message), Conf().Web.ServerName, owner, folder, fileName, c.ID),
+			"parents": []string{parent},
+			"tree":    tree.SHA,
+		}
+		if c.AuthorEmail != "" {
+			newCommit["author"] = map[string]string{
+				"date":  c.Timestamp.UTC().Format(time.RFC3339),
+				"email": c.AuthorEmail,
+				"name":  c.AuthorName,
+			}
+		}
+		err = githubAPI(http.MethodPost, api+"commits", m.Token, newCommit, &commit)
+		if err != nil {
+			return err
+		}
+		err = githubAPI(http.MethodPatch, api+"refs/heads/"+m.Branch, m.Token, map[string]string{"sha": commit.SHA},
+			nil)
+		if err != nil {
+			return err
+		}
+		parent = commit.SHA
+		prevSHA = e.Sha256
+		m.PushedCommits[c.ID] = commit.SHA
+		m.LastCommit = c.ID
+	}
+
+	// Tag the pushed commits which have releases.  Characters which can't be in a Git tag name are replaced
+	releases, err := GetReleases(owner, folder, fileName)
+	if err != nil {
+		return
+	}
+	for name, rel := range releases {
+		sha, ok := m.PushedCommits[rel.Commit]
+		if _, done := m.PushedReleases[name]; done || !ok {
+			continue
+		}
+		tag := strings.Map(func(r rune) rune {
+			if r <= ' ' || strings.ContainsRune("~^:?*[\\", r) {
+				return '-'
+			}
+			return r
+		}, name)
+		err = githubAPI(http.MethodPost, api+"refs", m.Token, map[string]string{"ref": "refs/tags/" + tag, "sha": sha},
+			nil)
+		if ge, ok := err.(githubError); ok && ge.Status == http.StatusUnprocessableEntity {
+			// The tag already exists
+			err = nil
+		}
+		if err != nil {
+			return
+		}
+		m.PushedReleases[name] = sha
+	}
+	return
+}
+
+// Adds a push of a project to its GitHub mirror to the background job queue, if it has one.
+func QueueGitHubMirror(owner string, folder string, fileName string) error {
+	_, found, err := GitHubMirror(owner, folder, fileName)
+	if err != nil || !found {
+		return err
+	}
+	_, err = QueueJob("github_mirror", map[string]string{"file_name": fileName, "folder": folder, "owner": owner})
+	return err
+}
+
+// Adds a sync of a GitHub import to the background job queue.
+func QueueGitHubSync(id int64) error {
+	_, err := QueueJob("github_sync", map[string]int64{"import_id": id})
+	return err
+}
+
+// Brings the projects created by a GitHub import up to date with the repository.  Projects are created for any new
+// 3D model files, and new commits are added to the existing ones when their file has changed.  Returns the names of
+// the projects created, and the reasons for any files being skipped.  Syncing stops with an error if the repository
+// can't be read, so it's tried again later.
+func SyncGitHubImport(id int64) (created []string, problems []string, err error) {
+	imp, err := GitHubImport(id)
+	if err != nil {
+		return
+	}
+	var commit struct {
+		SHA string `json:"sha"`
+	}
+	defer func() {
+		msg := strings.Join(problems, ". ")
+		if err != nil {
+			msg = err.Error()
+			commit.SHA = ""
+		}
+		StoreGitHubSyncResult(id, commit.SHA, msg)
+	}()
+
+	// Nothing needs doing if there haven't been any commits since the last sync
+	repoURL := "https://api.github.com/repos/" + imp.Repo
+	err = githubGetJSON(repoURL+"/commits/"+url.PathEscape(imp.Branch), &commit)
+	if err != nil {
+		return
+	}
+	if commit.SHA == imp.LastCommit {
+		return
+	}
+	var tree struct {
+		Tree []struct {
+			Path string `json:"path"`
+			SHA  string `json:"sha"`
+			Type string `json:"type"`
+		} `json:"tree"`
+		Truncated bool `json:"truncated"`
+	}
+	err = githubGetJSON(repoURL+"/git/trees/"+commit.SHA+"?recursive=1", &tree)
+	if err != nil {
+		return
+	}
+	if tree.Truncated {
+		problems = append(problems, "The repository has too many files for them all to be checked")
+	}
+	files, err := GitHubImportFiles(id)
+	if err != nil {
+		return
+	}
+
+	// Add or update the projects for the model files in the chosen folder
+	folder := "/"
+	prefix := ""
+	if imp.Path != "" {
+		prefix = imp.Path + "/"
+	}
+	for _, e := range tree.Tree {
+		if e.Type != "blob" || !strings.HasPrefix(e.Path, prefix) || !importExtensions[strings.ToLower(path.Ext(e.Path))] {
+			continue
+		}
+		existing, known := files[e.Path]
+		if known && existing.BlobSHA == e.SHA {
+			continue
+		}
+
+		// Work out which project the file goes in
+		var fileName, commitID, licence, commitMsg, sourceURL string
+		if known {
+			fileName = existing.FileName
+			commitID, err = DefaultCommit(imp.Owner, folder, fileName)
+			if err != nil {
+				return
+			}
+			commitMsg = fmt.Sprintf("Updated from GitHub commit %s", commit.SHA)
+		} else {
+			fileName = NormaliseName(path.Base(e.Path))
+			if ValidateFileName(fileName) != nil {
+				problems = append(problems, fmt.Sprintf("'%s' isn't a valid file name", e.Path))
+				continue
+			}
+			var exists bool
+			exists, err = CheckFileExists(imp.Owner, imp.Owner, folder, fileName)
+			if err != nil {
+				return
+			}
+			if exists {
+				problems = append(problems, fmt.Sprintf("There's already a project called '%s', so '%s' was "+
+					"skipped", fileName, e.Path))
+				continue
+			}
+			licence = imp.Licence
+			commitMsg = fmt.Sprintf("Imported from GitHub commit %s", commit.SHA)
+			sourceURL = "https://github.com/" + imp.Repo
+		}
+
+		// Download the file, and add it
+		var escaped []string
+		for _, p := range strings.Split(e.Path, "/") {
+			escaped = append(escaped, url.PathEscape(p))
+		}
+		resp, err2 := importGet(fmt.Sprintf("https://raw.githubusercontent.com/%s/%s/%s", imp.Repo, commit.SHA,
+			strings.Join(escaped, "/")), "")
+		if err2 != nil {
+			err = err2
+			return
+		}
+		_, _, err2 = AddFile(githubSyncRequest, imp.Owner, imp.Owner, folder, fileName, false, "", commitID,
+			imp.Public, licence, commitMsg, sourceURL, resp.Body, "github", time.Now(), time.Time{}, "", "", "", "",
+			nil, "", false)
+		resp.Body.Close()
+		if err2 != nil {
+			problems = append(problems, fmt.Sprintf("'%s' couldn't be added: %s", e.Path, err2))
+			continue
+		}
+		err = StoreGitHubImportFile(id, imp.Owner, folder, fileName, e.Path, e.SHA)
+		if err != nil {
+			return
+		}
+		if !known {
+			created = append(created, fileName)
+		}
+		if err2 = UpdateSearchIndex(imp.Owner, folder, fileName); err2 != nil {
+			Log.Errorf("Error when updating the search index: %s", err2.Error())
+		}
+	}
+	return
+}
@@ -0,0 +1,165 @@
+package common
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Project owners can create time limited links, which let people without an account send them a file for the
+// project.  The files are stored in Minio straight away, but are only committed to the project once the owner
+// approves them.  As anyone with a link can use it, the number of files waiting for approval is limited, as is how
+// often each link and each address can be used.  The counts for the latter are kept in the cache backend, so they're
+// shared by all of the webui instances.  If the cache can't be reached the uploads are let through, as the waiting
+// limit still applies.
+
+const (
+	// How many files a project can have waiting for approval
+	guestUploadMaxPending = 20
+
+	// How many files can be sent through each link, and from each address, in an hour
+	guestUploadsPerLink = 10
+	guestUploadsPerIP   = 5
+)
+
+// Returns the cache key for counting the guest uploads of a link or an address in the current hour.
+func guestUploadCacheKey(kind string, id string) string {
+	cacheString := fmt.Sprintf("guestupload-%s-%s-%d", kind, id, time.Now().Unix()/3600)
+	tempArr := md5.Sum([]byte(cacheString))
+	return hex.EncodeToString(tempArr[:])
+}
+
+// Counts a guest upload against its link and the address it came from, returning false if either has gone over its
+// hourly limit.
+func guestUploadAllowed(token string, client string) bool {
+	limits := []struct {
+		kind  string
+		id    string
+		limit uint64
+	}{
+		{"link", token, guestUploadsPerLink},
+		{"ip", client, guestUploadsPerIP},
+	}
+	for _, l := range limits {
+		n, err := dataCache.Increment(guestUploadCacheKey(l.kind, l.id), 2*time.Hour)
+		if err != nil {
+			Log.Errorf("Error counting guest uploads: %v", err)
+			continue
+		}
+		if n > l.limit {
+			return false
+		}
+	}
+	return true
+}
+
+// Checks and stores a file sent through a guest upload link, leaving it waiting for the owner of the project to
+// approve it.  The client is the address it was sent from.
+func AddGuestUpload(token string, client string, name string, email string, message string, file io.Reader) (
+	size int64, err error) {
+	if !guestUploadAllowed(token, client) {
+		return 0, errors.New("Too many files have been sent recently.  Please try again later")
+	}
+
+	// Check there's room for it before going any further, so files which can't be accepted aren't stored
+	ok, err := GuestUploadLinkHasRoom(token, guestUploadMaxPending)
+	if err != nil {
+		return
+	}
+	if !ok {
+		return 0, errors.New("This upload link has expired, or the project has too many uploads waiting for approval")
+	}
+
+	tempFile, err := ioutil.TempFile(Conf().DiskCache.Directory, "guest-upload-")
+	if err != nil {
+		Log.Errorf("Error creating temporary file for a guest upload: %v", err)
+		return
+	}
+	defer os.Remove(tempFile.Name())
+	defer tempFile.Close()
+
+	// Save the upload, hashing it at the same time
+	h := sha256.New()
+	size, err = io.Copy(io.MultiWriter(tempFile, h), io.LimitReader(file, MaxFileSize*1024*1024+1))
+	if err != nil {
+		return
+	}
+	if size > MaxFileSize*1024*1024 {
+		return 0, fmt.Errorf("The file is too large.  The maximum size is %d MB", MaxFileSize)
+	}
+	sha := hex.EncodeToString(h.Sum(nil))
+
+	// Only the same kinds of file as the owner could upload themselves are accepted
+	isDB, err := SanityCheckDatabase(tempFile.Name())
+	if err != nil {
+		return
+	}
+	if !isDB {
+		ok, _, err := SanityCheck3DModel(tempFile.Name())
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			return 0, errors.New("The file doesn't appear to be a 3D model")
+		}
+	}
+
+	// Store it.  If the file was already in Minio something else could be using it, so it's only removed again when
+	// this upload put it there
+	bkt, id := sha[:MinioFolderChars], sha[MinioFolderChars:]
+	existed, err := MinioObjectExists(bkt, id)
+	if err != nil {
+		return
+	}
+	if !existed {
+		if _, err = tempFile.Seek(0, io.SeekStart); err != nil {
+			return
+		}
+		err = StoreDatabaseFile(tempFile, sha, size)
+		if err != nil {
+			return
+		}
+	}
+	err = StoreGuestUpload(token, name, email, message, sha, size, guestUploadMaxPending)
+	if err != nil && !existed {
+		RemoveMinioObject(bkt, id)
+	}
+	return
+}
+
+// Commits a file sent through a guest upload link as a new version of the project, crediting the guest as its author.
+func ApproveGuestUpload(r *http.Request, owner string, folder string, fileName string, id int64) (commitID string,
+	err error) {
+	u, err := GuestUpload(owner, folder, fileName, id)
+	if err != nil {
+		return
+	}
+	obj, err := MinioHandle(u.Sha256[:MinioFolderChars], u.Sha256[MinioFolderChars:])
+	if err != nil {
+		return
+	}
+	defer MinioHandleClose(obj)
+
+	head, err := DefaultCommit(owner, folder, fileName)
+	if err != nil {
+		return
+	}
+	msg := u.Message
+	if msg == "" {
+		msg = fmt.Sprintf("Uploaded by %s", u.Name)
+	}
+	_, commitID, err = AddFile(r, owner, owner, folder, fileName, false, "", head, false, "", msg, "", obj,
+		"guest upload", time.Now(), time.Time{}, u.Name, u.Email, "", "", nil, u.Sha256, false)
+	if err != nil {
+		return
+	}
+	err = DeleteGuestUpload(owner, folder, fileName, id)
+	return
+}
@@ -0,0 +1,450 @@
+package common
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/microcosm-cc/bluemonday"
+)
+
+// Things can be imported from Thingiverse and MyMiniFactory, either from their URL (which needs an API key for the
+// site in the configuration file), or from a Thingiverse export archive.  Only things under a licence with an
+// equivalent here are imported, and the projects created from them say where they came from and who made them.
+
+// Used for the requests to the other sites.  Model files can be large, so the timeout is a generous one.  The download
+// URLs come from the other sites' responses, so the same addresses are refused as for OpenSCAD sources
+var importClient = &http.Client{
+	Timeout: 10 * time.Minute,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Control: importCheckAddress,
+			Timeout: 30 * time.Second,
+		}).DialContext,
+		TLSHandshakeTimeout: 30 * time.Second,
+	},
+}
+
+// The file extensions of the model files which are imported.  Anything else (eg images and PDFs) is skipped
+var importExtensions = map[string]bool{
+	".3mf":  true,
+	".amf":  true,
+	".dae":  true,
+	".fbx":  true,
+	".glb":  true,
+	".gltf": true,
+	".obj":  true,
+	".off":  true,
+	".ply":  true,
+	".step": true,
+	".stl":  true,
+	".stp":  true,
+}
+
+var (
+	// Matches the path of a MyMiniFactory object URL, eg "/object/3d-print-calibration-cube-12345"
+	regexMMFObject = regexp.MustCompile(`^/object/[a-z0-9-]*?([0-9]+)$`)
+
+	// Matches the line in the README.txt of a Thingiverse export archive saying what the thing is, and who made it
+	regexThingiverseCredit = regexp.MustCompile(`(?m)^(.+) by (\S+) on Thingiverse: (https?://\S+)\s*$`)
+
+	// Matches the line in the LICENSE.txt of a Thingiverse export archive saying which licence the thing is under
+	regexThingiverseLicence = regexp.MustCompile(`(?im)licensed under (?:the )?(.+?)(?: license)?\.?\s*$`)
+
+	// Matches the path of a Thingiverse thing URL, eg "/thing:12345"
+	regexThingiverseThing = regexp.MustCompile(`^/thing:([0-9]+)$`)
+)
+
+// A model file from a thing being imported.  It's opened when it's time to add it.
+type ImportedFile struct {
+	Name string
+	Open func() (io.ReadCloser, error)
+}
+
+// A thing being imported from another site, and the details needed to credit its creator.  Licence is the name of
+// the licence here, and OrigLicence is the name the other site gave it.
+type ImportedThing struct {
+	Creator     string
+	CreatorURL  string
+	Description string
+	Files       []ImportedFile
+	Licence     string
+	OrigLicence string
+	Site        string
+	SourceURL   string
+	Title       string
+}
+
+// Closes the response body of a model file being downloaded, along with reading it.
+type importReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// Adds a model file to be downloaded from the other site, if it's one of the types which are imported.
+func (t *ImportedThing) addDownload(name string, downloadURL string, auth string) {
+	if !IsImportFile(name) || downloadURL == "" {
+		return
+	}
+	t.Files = append(t.Files, ImportedFile{
+		Name: path.Base(name),
+		Open: func() (io.ReadCloser, error) {
+			resp, err := importGet(downloadURL, auth)
+			if err != nil {
+				return nil, err
+			}
+			if resp.ContentLength > MaxFileSize*1024*1024 {
+				resp.Body.Close()
+				return nil, fmt.Errorf("'%s' is larger than the maximum file size of %d MB", name, MaxFileSize)
+			}
+			return importReadCloser{io.LimitReader(resp.Body, MaxFileSize*1024*1024), resp.Body}, nil
+		},
+	})
+}
+
+// Works out the licence to use here for the thing, refusing ones without an equivalent.
+func (t *ImportedThing) checkLicence() error {
+	if t.OrigLicence == "" {
+		return fmt.Errorf("'%s' doesn't say which licence it's under, so it can't be imported", t.Title)
+	}
+	var ok bool
+	t.Licence, ok = ImportLicence(t.OrigLicence)
+	if !ok {
+		return fmt.Errorf("'%s' is under the '%s' licence, which there isn't an equivalent for here, so it "+
+			"can't be imported", t.Title, t.OrigLicence)
+	}
+	return nil
+}
+
+// Returns the README for the projects created from the thing, which includes its description and credits its creator.
+func (t ImportedThing) Readme() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", t.Title)
+	if t.Description != "" {
+		b.WriteString(t.Description + "\n\n")
+	}
+	creator := t.Creator
+	if t.CreatorURL != "" {
+		creator = fmt.Sprintf("[%s](%s)", t.Creator, t.CreatorURL)
+	}
+	fmt.Fprintf(&b, "---\n\nOriginally created by %s on %s: <%s>\n\nLicensed under %s.\n", creator, t.Site,
+		t.SourceURL, t.OrigLicence)
+	return b.String()
+}
+
+// Fills in the details of a thing from the README.txt and LICENSE.txt files of its Thingiverse export.  The kind of
+// export (eg "archive") is used in the error message when the README.txt is missing.
+func (t *ImportedThing) setThingiverseDetails(readme string, licence string, kind string) error {
+	m := regexThingiverseCredit.FindStringSubmatch(readme)
+	if m == nil {
+		return fmt.Errorf("The %s doesn't have the README.txt file from Thingiverse, so who created it can't be "+
+			"worked out", kind)
+	}
+	t.Title, t.Creator, t.SourceURL = strings.TrimSpace(m[1]), m[2], m[3]
+	t.CreatorURL = "https://www.thingiverse.com/" + url.PathEscape(m[2])
+	t.Site = "Thingiverse"
+	if i := strings.Index(readme, "Summary:"); i >= 0 {
+		t.Description = strings.TrimSpace(readme[i+len("Summary:"):])
+	}
+	if m = regexThingiverseLicence.FindStringSubmatch(licence); m != nil {
+		t.OrigLicence = strings.TrimSpace(m[1])
+	}
+	return t.checkLicence()
+}
+
+// Retrieves the details of a thing and its model files from MyMiniFactory.
+func fetchMyMiniFactory(id string) (thing ImportedThing, err error) {
+	if Conf().Import.MyMiniFactoryKey == "" {
+		return thing, errors.New("Importing from MyMiniFactory isn't set up on this server")
+	}
+	var o struct {
+		Description string `json:"description"`
+		Designer    struct {
+			Name       string `json:"name"`
+			ProfileURL string `json:"profile_url"`
+			Username   string `json:"username"`
+		} `json:"designer"`
+		Files struct {
+			Items []struct {
+				DownloadURL string `json:"download_url"`
+				Filename    string `json:"filename"`
+			} `json:"items"`
+		} `json:"files"`
+		License string `json:"license"`
+		Name    string `json:"name"`
+		URL     string `json:"url"`
+	}
+	u := fmt.Sprintf("https://www.myminifactory.com/api/v2/objects/%s?key=%s", id,
+		url.QueryEscape(Conf().Import.MyMiniFactoryKey))
+	err = importGetJSON(u, "", &o)
+	if err != nil {
+		return
+	}
+
+	thing = ImportedThing{
+		Creator:     o.Designer.Name,
+		CreatorURL:  o.Designer.ProfileURL,
+		Description: htmlToText(o.Description),
+		OrigLicence: o.License,
+		Site:        "MyMiniFactory",
+		SourceURL:   o.URL,
+		Title:       o.Name,
+	}
+	if thing.Creator == "" {
+		thing.Creator = o.Designer.Username
+	}
+	if err = thing.checkLicence(); err != nil {
+		return
+	}
+	for _, f := range o.Files.Items {
+		thing.addDownload(f.Filename, f.DownloadURL, "")
+	}
+	return
+}
+
+// Retrieves the details of a thing and its model files from Thingiverse.
+func fetchThingiverse(id string) (thing ImportedThing, err error) {
+	if Conf().Import.ThingiverseToken == "" {
+		return thing, errors.New("Importing from Thingiverse URLs isn't set up on this server, but the export " +
+			"archive from the thing's \"Download all files\" button can be uploaded instead")
+	}
+	auth := "Bearer " + Conf().Import.ThingiverseToken
+	var t struct {
+		Creator struct {
+			Name      string `json:"name"`
+			PublicURL string `json:"public_url"`
+		} `json:"creator"`
+		Description string `json:"description"`
+		License     string `json:"license"`
+		Name        string `json:"name"`
+		PublicURL   string `json:"public_url"`
+	}
+	err = importGetJSON("https://api.thingiverse.com/things/"+id, auth, &t)
+	if err != nil {
+		return
+	}
+
+	thing = ImportedThing{
+		Creator:     t.Creator.Name,
+		CreatorURL:  t.Creator.PublicURL,
+		Description: strings.TrimSpace(t.Description),
+		OrigLicence: t.License,
+		Site:        "Thingiverse",
+		SourceURL:   t.PublicURL,
+		Title:       t.Name,
+	}
+	if err = thing.checkLicence(); err != nil {
+		return
+	}
+	var files []struct {
+		DownloadURL string `json:"download_url"`
+		Name        string `json:"name"`
+	}
+	err = importGetJSON("https://api.thingiverse.com/things/"+id+"/files", auth, &files)
+	if err != nil {
+		return
+	}
+	for _, f := range files {
+		thing.addDownload(f.Name, f.DownloadURL, auth)
+	}
+	return
+}
+
+// Retrieves the details of a thing and its model files, from its URL on Thingiverse or MyMiniFactory.
+func FetchImportURL(thingURL string) (thing ImportedThing, err error) {
+	u, err := url.Parse(strings.TrimSpace(thingURL))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return thing, errors.New("That doesn't look like a URL")
+	}
+	p := strings.TrimSuffix(u.Path, "/")
+	switch strings.TrimPrefix(strings.ToLower(u.Host), "www.") {
+	case "myminifactory.com":
+		if m := regexMMFObject.FindStringSubmatch(p); m != nil {
+			return fetchMyMiniFactory(m[1])
+		}
+	case "thingiverse.com":
+		if m := regexThingiverseThing.FindStringSubmatch(p); m != nil {
+			return fetchThingiverse(m[1])
+		}
+	}
+	return thing, errors.New("Only the URLs of things on Thingiverse or MyMiniFactory can be imported")
+}
+
+// Returns the text of an HTML description, keeping its paragraphs apart.
+func htmlToText(s string) string {
+	s = strings.NewReplacer("</p>", "</p>\n\n", "<br>", "\n", "<br/>", "\n", "<br />", "\n").Replace(s)
+	return strings.TrimSpace(html.UnescapeString(bluemonday.StrictPolicy().Sanitize(s)))
+}
+
+// Checks the address being connected to for an import isn't on our own network.
+func importCheckAddress(network string, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if !regenPublicIP(net.ParseIP(host)) {
+		return fmt.Errorf("Downloading from '%s' isn't allowed", host)
+	}
+	return nil
+}
+
+// Sends a GET request to another site, returning an error unless it succeeds.
+func importGet(u string, auth string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	resp, err := importClient.Do(req)
+	if err != nil {
+		Log.Warnf("Import request to '%s' failed: %v", req.URL.Host, err)
+		return nil, fmt.Errorf("Couldn't connect to %s", req.URL.Host)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s responded with '%s'", req.URL.Host, resp.Status)
+	}
+	return resp, nil
+}
+
+// Retrieves and decodes a JSON response from another site.
+func importGetJSON(u string, auth string, v interface{}) error {
+	resp, err := importGet(u, auth)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(io.LimitReader(resp.Body, 8<<20)).Decode(v)
+}
+
+// Returns the licence here which matches a licence name from Thingiverse or MyMiniFactory (eg "Creative Commons -
+// Attribution - Share Alike", or "CC BY-SA").  The ok value is false for licences without an equivalent here, which
+// includes ones that don't allow the files to be shared (eg "All Rights Reserved").
+func ImportLicence(name string) (licence string, ok bool) {
+	words := " " + strings.Join(strings.Fields(strings.NewReplacer("-", " ", "_", " ").Replace(
+		strings.ToLower(name))), " ") + " "
+	has := func(terms ...string) bool {
+		for _, t := range terms {
+			if strings.Contains(words, " "+t+" ") {
+				return true
+			}
+		}
+		return false
+	}
+	shareAlike := has("share alike", "sharealike", "sa")
+	switch {
+	case has("cc0", "public domain"):
+		return "CC0", true
+	case !has("creative commons", "cc"), has("no derivatives", "noderivatives", "nd"):
+		return "", false
+	case has("non commercial", "noncommercial", "nc"):
+		if shareAlike {
+			return "", false
+		}
+		return "CC-BY-NC-4.0", true
+	case shareAlike:
+		return "CC-BY-SA-4.0", true
+	case has("attribution", "by"):
+		return "CC-BY-4.0", true
+	}
+	return "", false
+}
+
+// Reports whether a file is one of the model file types which are imported, going by its name.
+func IsImportFile(name string) bool {
+	return importExtensions[strings.ToLower(path.Ext(name))]
+}
+
+// Reads a Thingiverse export archive (the zip file from the "Download all files" button of a thing).  The README.txt
+// and LICENSE.txt files in it give the details of the thing, and the model files are in its "files" folder.
+func ReadImportArchive(r io.ReaderAt, size int64) (thing ImportedThing, err error) {
+	z, err := zip.NewReader(r, size)
+	if err != nil {
+		return thing, errors.New("That doesn't look like a zip file")
+	}
+	var readme, licence string
+	for _, f := range z.File {
+		name := path.Base(f.Name)
+		switch {
+		case f.FileInfo().IsDir():
+		case strings.EqualFold(name, "README.txt"):
+			readme, err = readZipText(f)
+		case strings.EqualFold(name, "LICENSE.txt"):
+			licence, err = readZipText(f)
+		case IsImportFile(name):
+			thing.Files = append(thing.Files, ImportedFile{Name: name, Open: f.Open})
+		}
+		if err != nil {
+			return
+		}
+	}
+
+	err = thing.setThingiverseDetails(readme, licence, "archive")
+	return
+}
+
+// Reads an extracted Thingiverse export archive.  The README.txt and LICENSE.txt files need to be directly in the
+// given directory, and the model files can be anywhere under it.
+func ReadImportDirectory(dir string) (thing ImportedThing, err error) {
+	var readme, licence string
+	err = filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		name := info.Name()
+		switch {
+		case filepath.Dir(p) == filepath.Clean(dir) && strings.EqualFold(name, "README.txt"):
+			readme, err = readImportText(p)
+		case filepath.Dir(p) == filepath.Clean(dir) && strings.EqualFold(name, "LICENSE.txt"):
+			licence, err = readImportText(p)
+		case IsImportFile(name):
+			thing.Files = append(thing.Files, ImportedFile{Name: name, Open: func() (io.ReadCloser, error) {
+				return os.Open(p)
+			}})
+		}
+		return err
+	})
+	if err != nil {
+		return
+	}
+	err = thing.setThingiverseDetails(readme, licence, "directory")
+	return
+}
+
+// Returns the contents of a (small) text file.
+func readImportText(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(f, 1<<20))
+	return strings.Replace(string(data), "\r\n", "\n", -1), err
+}
+
+// Returns the contents of a (small) text file in a zip archive.
+func readZipText(f *zip.File) (string, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(rc, 1<<20))
+	return strings.Replace(string(data), "\r\n", "\n", -1), err
+}
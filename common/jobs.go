@@ -0,0 +1,156 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Background jobs are kept in PostgreSQL, so they survive restarts and can be picked up by any of the servers.  Each
+// server runs a pool of workers, which take the highest priority job waiting whose type isn't already at its worker
+// limit.  Failed jobs are retried with a growing delay, until they run out of attempts and land on the dead job list
+// in the admin pages.
+
+const (
+	// How long the workers wait before checking again, when there are no jobs to run
+	jobPollInterval = 2 * time.Second
+
+	// How often a running job is marked as still being worked on, and how long until it's assumed its worker has gone
+	jobHeartbeatInterval = time.Minute
+	jobStaleAfter        = 5 * jobHeartbeatInterval
+)
+
+// Runs one type of background job.  The payload is the value given to QueueJob(), as JSON.
+type JobHandler func(payload json.RawMessage) error
+
+var (
+	// The handler for each type of background job
+	jobHandlers = make(map[string]JobHandler)
+
+	// The number of jobs of each type being run by this server
+	jobsRunning   = make(map[string]int)
+	jobsRunningMu sync.Mutex
+)
+
+// Returns the settings for a type of background job, filling in the defaults for anything not in the configuration
+// file.
+func jobTypeSettings(jobType string) JobTypeInfo {
+	s := Conf().Jobs.Types[jobType]
+	if s.MaxAttempts <= 0 {
+		s.MaxAttempts = 3
+	}
+	if s.RetryDelay <= 0 {
+		s.RetryDelay = 60
+	}
+	return s
+}
+
+// Takes jobs from the queue and runs them, one at a time.
+func jobWorker(workerID string, jobTypes []string) {
+	for {
+		// Skip the types which already have as many jobs running as they're allowed.  The lock is held while claiming,
+		// so several workers can't go over the limit at once
+		var skip []string
+		jobsRunningMu.Lock()
+		for _, t := range jobTypes {
+			if limit := jobTypeSettings(t).Workers; limit > 0 && jobsRunning[t] >= limit {
+				skip = append(skip, t)
+			}
+		}
+		job, found, err := ClaimJob(workerID, jobTypes, skip)
+		if found {
+			jobsRunning[job.Type]++
+		}
+		jobsRunningMu.Unlock()
+		if err != nil || !found {
+			time.Sleep(jobPollInterval)
+			continue
+		}
+		runJob(job)
+	}
+}
+
+// Adds a job to the background job queue.  The payload is stored as JSON, and handed to the job type's handler when
+// the job is run by one of the servers which has registered it.
+func QueueJob(jobType string, payload interface{}) (id int64, err error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return 0, err
+	}
+	s := jobTypeSettings(jobType)
+	return StoreJob(jobType, data, s.Priority, s.MaxAttempts)
+}
+
+// Sets the handler for a type of background job.  This needs to be done before the workers are started.
+func RegisterJobType(jobType string, handler JobHandler) {
+	jobHandlers[jobType] = handler
+}
+
+// Runs a single background job, recording whether it worked.
+func runJob(job JobEntry) {
+	defer func() {
+		jobsRunningMu.Lock()
+		jobsRunning[job.Type]--
+		jobsRunningMu.Unlock()
+	}()
+
+	// Keep the job marked as being worked on, for as long as it's running
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		t := time.NewTicker(jobHeartbeatInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+				TouchJob(job.ID)
+			}
+		}
+	}()
+
+	err := func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("Panic: %v", r)
+				Log.Errorf("Background job %d (%s) panicked: %v\n%s", job.ID, job.Type, r, debug.Stack())
+			}
+		}()
+		return jobHandlers[job.Type](json.RawMessage(job.Payload))
+	}()
+	if err == nil {
+		CompleteJob(job.ID)
+		return
+	}
+	Log.Warnf("Background job %d (%s) failed on attempt %d of %d: %v", job.ID, job.Type, job.Attempts,
+		job.MaxAttempts, err)
+	FailJob(job.ID, err.Error(), jobTypeSettings(job.Type).RetryDelay*time.Second)
+}
+
+// Starts the background job workers.  Runs until the server is stopped.
+func RunJobWorkers() {
+	if len(jobHandlers) == 0 {
+		return
+	}
+	var jobTypes []string
+	for t := range jobHandlers {
+		jobTypes = append(jobTypes, t)
+	}
+	sort.Strings(jobTypes)
+	Log.Infof("Starting %d background job workers", Conf().Jobs.Workers)
+	for i := 0; i < Conf().Jobs.Workers; i++ {
+		go jobWorker(fmt.Sprintf("%s-%d", InstanceID, i), jobTypes)
+	}
+
+	// Only one server needs to look for jobs whose workers have gone away
+	for {
+		if HoldJobLock("requeue-jobs", jobHeartbeatInterval) {
+			RequeueStaleJobs(jobStaleAfter)
+		}
+		time.Sleep(jobHeartbeatInterval)
+	}
+}
@@ -0,0 +1,187 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Projects can declare the projects (here or elsewhere) they were remixed from.  The licence compliance report follows
+// those declarations, along with forks, back through the whole remix chain.  It then checks the licence of each
+// project meets the terms of the licences of everything it was remixed from.
+
+// The most sources a project can declare it was remixed from
+const MaxRemixSources = 50
+
+// How far back through a remix chain the report goes, and the most projects it covers
+const (
+	licenceReportMaxDepth = 20
+	licenceReportMaxNodes = 200
+)
+
+// The licences which can be given for files elsewhere.  Projects here can also use these, apart from the ones which
+// don't allow sharing
+var RemixLicences = []string{"CC0", "CC-BY-4.0", "CC-BY-SA-4.0", "CC-BY-NC-4.0", "CC-BY-NC-SA-4.0", "CC-BY-ND-4.0",
+	"CC-BY-NC-ND-4.0", "CC-BY-IGO-3.0", "ODbL-1.0", "UK-OGL-3", "All rights reserved"}
+
+// The terms of a licence which matter for remixes.  Share alike licences give the licence remixes need to use
+type licenceTerms struct {
+	nonCommercial bool
+	noDerivatives bool
+	shareAlike    string
+}
+
+// The terms of each licence the report knows about.  Others (including "Not specified", and licences added by users)
+// can't be checked
+var licenceTermsList = map[string]licenceTerms{
+	"All rights reserved": {noDerivatives: true},
+	"CC0":                 {},
+	"CC-BY-4.0":           {},
+	"CC-BY-IGO-3.0":       {},
+	"CC-BY-NC-4.0":        {nonCommercial: true},
+	"CC-BY-NC-ND-4.0":     {nonCommercial: true, noDerivatives: true},
+	"CC-BY-NC-SA-4.0":     {nonCommercial: true, shareAlike: "CC-BY-NC-SA-4.0"},
+	"CC-BY-ND-4.0":        {noDerivatives: true},
+	"CC-BY-SA-4.0":        {shareAlike: "CC-BY-SA-4.0"},
+	"ODbL-1.0":            {shareAlike: "ODbL-1.0"},
+	"UK-OGL-3":            {},
+}
+
+// Returns why a remix using the target licence doesn't meet the terms of the source licence, or an empty string if it
+// does.  Warning is true when one of the licences can't be checked.
+func licenceConflict(source string, target string) (reason string, warning bool) {
+	src, ok := licenceTermsList[source]
+	if !ok {
+		return fmt.Sprintf("The licence '%s' can't be checked, so its terms need checking by hand", source), true
+	}
+	if src.noDerivatives {
+		return fmt.Sprintf("%s doesn't allow remixes to be shared", source), false
+	}
+	dst, ok := licenceTermsList[target]
+	if !ok {
+		return fmt.Sprintf("The licence '%s' can't be checked against %s, so it needs checking by hand", target,
+			source), true
+	}
+	if src.shareAlike != "" && target != src.shareAlike {
+		return fmt.Sprintf("%s requires remixes to use the same licence, but this uses %s", source, target), false
+	}
+	if src.nonCommercial && !dst.nonCommercial {
+		return fmt.Sprintf("%s doesn't allow commercial use, but %s does", source, target), false
+	}
+	return "", false
+}
+
+// Builds the licence compliance report for a project, following everything it was remixed from (and forked from)
+// back through the remix chain.
+func LicenceComplianceReport(loggedInUser string, owner string, folder string, fileName string) (report LicenceReport,
+	err error) {
+	// The projects and licences further down the chain than the one being looked at, which its licence needs to
+	// meet the terms of
+	type ancestor struct {
+		licence string
+		name    string
+	}
+	seen := make(map[string]bool)
+	problems := make(map[string]bool)
+	addProblem := func(source string, target string, reason string, warning bool) {
+		key := source + "\x00" + target + "\x00" + reason
+		if problems[key] {
+			return
+		}
+		problems[key] = true
+		report.Problems = append(report.Problems, LicenceProblem{Reason: reason, Source: source, Target: target,
+			Warning: warning})
+	}
+	checkAgainst := func(name string, licence string, remixes []ancestor) {
+		for _, a := range remixes {
+			if reason, warning := licenceConflict(licence, a.licence); reason != "" {
+				addProblem(name, a.name, reason, warning)
+			}
+		}
+	}
+
+	var visit func(o string, f string, n string, depth int, fork bool, remixes []ancestor) error
+	visit = func(o string, f string, n string, depth int, fork bool, remixes []ancestor) error {
+		if len(report.Nodes) >= licenceReportMaxNodes {
+			return nil
+		}
+		node := LicenceReportNode{Depth: depth, Fork: fork, Name: o + f + n, URL: "/" + o + f + n}
+
+		// Private projects the user can't see are only listed, so their details don't leak
+		allowed, err := CheckFileExists(loggedInUser, o, f, n)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			node.Hidden, node.Name, node.URL = true, "A private project", ""
+			report.Nodes = append(report.Nodes, node)
+			if len(remixes) > 0 {
+				addProblem(node.Name, remixes[len(remixes)-1].name, "The licence of a private project can't be "+
+					"checked", true)
+			}
+			return nil
+		}
+		node.Licence, err = ProjectLicence(o, f, n)
+		if err != nil {
+			return err
+		}
+		report.Nodes = append(report.Nodes, node)
+		checkAgainst(node.Name, node.Licence, remixes)
+
+		// Projects already in the report aren't followed again, so loops in the chain don't go on forever
+		key := strings.ToLower(node.Name)
+		if seen[key] || depth >= licenceReportMaxDepth {
+			return nil
+		}
+		seen[key] = true
+		remixes = append(remixes[:len(remixes):len(remixes)], ancestor{licence: node.Licence, name: node.Name})
+
+		// Follow the project it was forked from, then the ones it declares it was remixed from
+		forkOwn, forkFol, forkDB, forkDel, err := ForkedFrom(o, f, n)
+		if err != nil {
+			return err
+		}
+		if forkOwn != "" && !forkDel {
+			if err = visit(forkOwn, forkFol, forkDB, depth+1, true, remixes); err != nil {
+				return err
+			}
+		}
+		sources, err := RemixSources(o, f, n)
+		if err != nil {
+			return err
+		}
+		for _, s := range sources {
+			if s.URL == "" {
+				if err = visit(s.Owner, s.Folder, s.FileName, depth+1, false, remixes); err != nil {
+					return err
+				}
+				continue
+			}
+			if len(report.Nodes) >= licenceReportMaxNodes {
+				return nil
+			}
+			report.Nodes = append(report.Nodes, LicenceReportNode{Depth: depth + 1, External: true,
+				Licence: s.Licence, Name: s.URL, URL: s.URL})
+			checkAgainst(s.URL, s.Licence, remixes)
+		}
+		return nil
+	}
+	err = visit(owner, folder, fileName, 0, false, nil)
+	return
+}
+
+// Returns the name of the licence used by the latest version of a project.
+func ProjectLicence(owner string, folder string, fileName string) (licence string, err error) {
+	commitID, err := DefaultCommit(owner, folder, fileName)
+	if err != nil {
+		return
+	}
+	sha, err := CommitLicenceSHA(owner, folder, fileName, commitID)
+	if err != nil {
+		return
+	}
+	if sha == "" {
+		return "Not specified", nil
+	}
+	licence, _, err = GetLicenceInfoFromSha256(owner, sha)
+	return
+}
@@ -0,0 +1,79 @@
+package common
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+)
+
+var (
+	// Live update subscribers, keyed by project
+	liveSubs   = make(map[string]map[chan LiveUpdate]struct{})
+	liveSubsMu sync.Mutex
+)
+
+// The number of live updates buffered for each subscriber before new ones get dropped
+const liveUpdateBuffer = 16
+
+// Returns the key used for tracking live update subscribers of a project.
+func liveKey(owner string, folder string, fileName string) string {
+	return strings.ToLower(owner) + folder + fileName
+}
+
+// Passes a live update to the subscribers of its project on this instance.  Subscribers which aren't keeping up with
+// their updates miss out, rather than slowing down the caller.
+func deliverLiveUpdate(u LiveUpdate) {
+	liveSubsMu.Lock()
+	defer liveSubsMu.Unlock()
+	for c := range liveSubs[liveKey(u.Owner, u.Folder, u.DBName)] {
+		select {
+		case c <- u:
+		default:
+		}
+	}
+}
+
+// Sends a live update to everyone currently subscribed to the given project.  The update goes out through PostgreSQL,
+// so subscribers connected to other instances get it too.
+func PublishLiveUpdate(owner string, folder string, fileName string, updateType LiveUpdateType, data interface{}) {
+	u := LiveUpdate{
+		Data:   data,
+		DBName: fileName,
+		Folder: folder,
+		Owner:  owner,
+		Type:   updateType,
+	}
+	payload, err := json.Marshal(u)
+	if err == nil {
+		_, err = pdb.Exec(`SELECT pg_notify($1, $2)`, liveUpdateChannel, string(payload))
+		if err == nil {
+			return
+		}
+	}
+
+	// If the update couldn't be sent through PostgreSQL, at least the subscribers on this instance get it
+	Log.Warnf("Sending live update for '%s%s%s' to the other instances failed: %v", owner, folder, fileName, err)
+	deliverLiveUpdate(u)
+}
+
+// Subscribes to live updates for a project.  The returned function must be called to unsubscribe when finished.
+func SubscribeLiveUpdates(owner string, folder string, fileName string) (<-chan LiveUpdate, func()) {
+	key := liveKey(owner, folder, fileName)
+	c := make(chan LiveUpdate, liveUpdateBuffer)
+	liveSubsMu.Lock()
+	if liveSubs[key] == nil {
+		liveSubs[key] = make(map[chan LiveUpdate]struct{})
+	}
+	liveSubs[key][c] = struct{}{}
+	liveSubsMu.Unlock()
+
+	unsubscribe := func() {
+		liveSubsMu.Lock()
+		delete(liveSubs[key], c)
+		if len(liveSubs[key]) == 0 {
+			delete(liveSubs, key)
+		}
+		liveSubsMu.Unlock()
+	}
+	return c, unsubscribe
+}
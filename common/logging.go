@@ -0,0 +1,41 @@
+package common
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// The leveled, structured logger used throughout the servers.  Until the configuration file has been read, it logs at
+// info level in text format.
+var Log = logrus.New()
+
+// Sets the format and level of the logger, using the [log] section of the configuration file.  Anything still written
+// using the standard library logger (eg by other packages) is sent through it too, at info level.
+func ConfigureLogging(l LogInfo) error {
+	switch strings.ToLower(l.Format) {
+	case "", "text":
+		Log.Formatter = &logrus.TextFormatter{FullTimestamp: true}
+	case "json":
+		Log.Formatter = &logrus.JSONFormatter{}
+	default:
+		return fmt.Errorf("Unknown log format: '%s'", l.Format)
+	}
+	level := logrus.InfoLevel
+	if l.Level != "" {
+		var err error
+		level, err = logrus.ParseLevel(l.Level)
+		if err != nil {
+			return fmt.Errorf("Unknown log level: '%s'", l.Level)
+		}
+	}
+	Log.SetLevel(level)
+	Log.SetOutput(os.Stderr)
+
+	log.SetFlags(0)
+	log.SetOutput(Log.WriterLevel(logrus.InfoLevel))
+	return nil
+}
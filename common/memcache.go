@@ -8,9 +8,10 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"net"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/bradfitz/gomemcache/memcache"
 )
@@ -20,8 +21,16 @@ var (
 	memCache *memcache.Client
 )
 
-// Caches data in Memcached
+// Generate a predictable cache key for the account status of a user
+func accountStatusCacheKey(userName string) string {
+	cacheString := fmt.Sprintf("account-status-%s", strings.ToLower(userName))
+	tempArr := md5.Sum([]byte(cacheString))
+	return hex.EncodeToString(tempArr[:])
+}
+
+// Caches data in the cache backend
 func CacheData(cacheKey string, cacheData interface{}, cacheSeconds int) error {
+	defer traceSpan("memcache", "CacheData", time.Now())
 	// Encode the data
 	var encodedData bytes.Buffer
 	enc := gob.NewEncoder(&encodedData)
@@ -30,48 +39,76 @@ func CacheData(cacheKey string, cacheData interface{}, cacheSeconds int) error {
 		return err
 	}
 
-	// Send the data to memcached
-	cachedData := memcache.Item{Key: cacheKey, Value: encodedData.Bytes(), Expiration: int32(cacheSeconds)}
-	err = memCache.Set(&cachedData)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	// Send the data to the cache
+	return dataCache.Set(cacheKey, encodedData.Bytes(), time.Duration(cacheSeconds)*time.Second)
 }
 
+// Connects to the Memcached servers.  The first one which responds is used, with the others kept in reserve in case it
+// stops responding.  When caching is optional, the server can start without any of them.  The sessions and background
+// job locks are then kept in PostgreSQL, so nothing breaks while Memcached is away.
 func ConnectCache() error {
-	// Connect to memcached server
-	memCache = memcache.New(Conf.Memcache.Server)
+	var addrs []net.Addr
+	for _, server := range cacheServerNames() {
+		addr, err := resolveCacheServer(server)
+		if err != nil {
+			return fmt.Errorf("Couldn't resolve memcached server address '%s': %v", server, err)
+		}
+		addrs = append(addrs, addr)
+	}
+	if len(addrs) == 0 && !Conf().Memcache.Optional {
+		return errors.New("No memcached server has been set in the config file")
+	}
+	jobLocksInPG = Conf().Memcache.Optional
+	cacheSelector = &cacheServers{active: -1, addrs: addrs}
+	cacheSelector.check()
+	memCache = memcache.NewFromSelector(cacheSelector)
+	if len(addrs) == 0 {
+		Log.Warnf("No Memcached servers are set, so caching is disabled")
+		return connectCacheBackend()
+	}
 
 	// Test the memcached connection
 	cacheTest := memcache.Item{Key: "connecttext", Value: []byte("1"), Expiration: 10}
 	err := memCache.Set(&cacheTest)
 	if err != nil {
-		return errors.New(fmt.Sprintf("Couldn't connect to memcached server: %s", err))
+		if !Conf().Memcache.Optional {
+			return errors.New(fmt.Sprintf("Couldn't connect to memcached server: %s", err))
+		}
+		Log.Warnf("Couldn't connect to any Memcached server, continuing without caching until one responds: %v", err)
+	} else {
+		// Log successful connection message for Memcached
+		Log.Infof("Connected to Memcached: %v", cacheSelector.current())
 	}
 
-	// Log successful connection message for Memcached
-	log.Printf("Connected to Memcached: %v\n", Conf.Memcache.Server)
+	// Keep an eye on the servers, switching between them as needed
+	go cacheSelector.monitor()
+	return connectCacheBackend()
+}
+
+// Counts a request from a client in the current minute, returning the number of requests it has made so far in that
+// minute.  The counts are kept in the cache backend, so they're shared by all of the webui instances.
+func CountClientRequest(client string) (uint64, error) {
+	defer traceSpan("memcache", "CountClientRequest", time.Now())
+	cacheString := fmt.Sprintf("ratelimit-%s-%d", client, time.Now().Unix()/60)
+	tempArr := md5.Sum([]byte(cacheString))
+	cacheKey := hex.EncodeToString(tempArr[:])
 
-	return nil
+	return dataCache.Increment(cacheKey, 2*time.Minute)
 }
 
-// Retrieves cached data from Memcached
+// Retrieves cached data from the cache backend
 func GetCachedData(cacheKey string, cacheData interface{}) (bool, error) {
-	cacheItem, err := memCache.Get(cacheKey)
+	defer traceSpan("memcache", "GetCachedData", time.Now())
+	value, ok, err := dataCache.Get(cacheKey)
 	if err != nil {
-		if err == memcache.ErrCacheMiss {
-			return false, nil
-		}
 		return false, err
 	}
 
 	// If a value was retrieved, return it
-	if cacheItem != nil {
+	if ok {
 		// Decode the serialised data
 		var decBuf bytes.Buffer
-		io.Copy(&decBuf, bytes.NewReader(cacheItem.Value))
+		io.Copy(&decBuf, bytes.NewReader(value))
 		dec := gob.NewDecoder(&decBuf)
 		dec.Decode(cacheData)
 		return true, nil
@@ -82,6 +119,7 @@ func GetCachedData(cacheKey string, cacheData interface{}) (bool, error) {
 
 // Retrieves the view count in memcached for a database
 func GetViewCount(owner string, folder string, fileName string) (count int, err error) {
+	defer traceSpan("memcache", "GetViewCount", time.Now())
 	// Generate the cache key
 	cacheString := fmt.Sprintf("viewcount-%s-%s-%s", owner, folder, fileName)
 	tempArr := md5.Sum([]byte(cacheString))
@@ -90,7 +128,7 @@ func GetViewCount(owner string, folder string, fileName string) (count int, err
 	// Retrieve the view count
 	data, err := memCache.Get(cacheKey)
 	if err != nil {
-		if err != memcache.ErrCacheMiss {
+		if err != memcache.ErrCacheMiss && !CacheUnavailable(err) {
 			// A real error occurred
 			return -1, err
 		}
@@ -109,6 +147,7 @@ func GetViewCount(owner string, folder string, fileName string) (count int, err
 
 // Increments the view counter in memcached for a database
 func IncrementViewCount(owner string, folder string, fileName string) error {
+	defer traceSpan("memcache", "IncrementViewCount", time.Now())
 	// Generate the cache key
 	cacheString := fmt.Sprintf("viewcount-%s-%s-%s", owner, folder, fileName)
 	tempArr := md5.Sum([]byte(cacheString))
@@ -117,6 +156,10 @@ func IncrementViewCount(owner string, folder string, fileName string) error {
 	// Attempt to directly increment the counter
 	_, err := memCache.Increment(cacheKey, 1)
 	if err != nil {
+		if CacheUnavailable(err) {
+			// Views can't be counted without the cache
+			return nil
+		}
 		if err != memcache.ErrCacheMiss {
 			// A real error occurred
 			return err
@@ -133,10 +176,10 @@ func IncrementViewCount(owner string, folder string, fileName string) error {
 		cachedData := memcache.Item{
 			Key:        cacheKey,
 			Value:      []byte(fmt.Sprintf("%d", cnt+1)),
-			Expiration: int32(Conf.Memcache.DefaultCacheTime),
+			Expiration: int32(Conf().Memcache.DefaultCacheTime),
 		}
 		err = memCache.Set(&cachedData)
-		if err != nil {
+		if err != nil && !CacheUnavailable(err) {
 			return err
 		}
 	}
@@ -145,6 +188,7 @@ func IncrementViewCount(owner string, folder string, fileName string) error {
 
 // Invalidate memcache data for a database entry or entries
 func InvalidateCacheEntry(loggedInUser string, owner string, folder string, fileName string, commitID string) error {
+	defer traceSpan("memcache", "InvalidateCacheEntry", time.Now())
 	// If commitID is "", that means "for all commits".  Otherwise, just invalidate the data for the requested one
 	var commitList []string
 	if commitID == "" {
@@ -167,47 +211,41 @@ func InvalidateCacheEntry(loggedInUser string, owner string, folder string, file
 	for _, c := range commitList {
 		// Invalidate the meta info, for private database versions
 		cacheKey := MetadataCacheKey("meta", loggedInUser, owner, folder, fileName, c)
-		err := memCache.Delete(cacheKey)
+		err := dataCache.Delete(cacheKey)
 		if err != nil {
-			if err != memcache.ErrCacheMiss {
-				// Cache miss is not an error we care about
-				return err
-			}
+			return err
 		}
 
 		// Invalidate the meta info for public database versions
 		cacheKey = MetadataCacheKey("meta", "", owner, folder, fileName, c)
-		err = memCache.Delete(cacheKey)
+		err = dataCache.Delete(cacheKey)
 		if err != nil {
-			if err != memcache.ErrCacheMiss {
-				// Cache miss is not an error we care about
-				return err
-			}
+			return err
 		}
 
 		// Invalidate the download page data, for private database versions
 		cacheKey = MetadataCacheKey("dwndb-meta", owner, owner, folder, fileName, c)
-		err = memCache.Delete(cacheKey)
+		err = dataCache.Delete(cacheKey)
 		if err != nil {
-			if err != memcache.ErrCacheMiss {
-				// Cache miss is not an error we care about
-				return err
-			}
+			return err
 		}
 
 		// Invalidate the download page data for public database versions
 		cacheKey = MetadataCacheKey("dwndb-meta", "", owner, folder, fileName, c)
-		err = memCache.Delete(cacheKey)
+		err = dataCache.Delete(cacheKey)
 		if err != nil {
-			if err != memcache.ErrCacheMiss {
-				// Cache miss is not an error we care about
-				return err
-			}
+			return err
 		}
 	}
 	return nil
 }
 
+// Removes a single entry from the cache backend
+func InvalidateCachedData(cacheKey string) error {
+	defer traceSpan("memcache", "InvalidateCachedData", time.Now())
+	return dataCache.Delete(cacheKey)
+}
+
 // Returns the Memcached handle
 func MemcacheHandle() *memcache.Client {
 	return memCache
@@ -228,6 +266,7 @@ func MetadataCacheKey(prefix string, loggedInUser string, owner string, folder s
 
 // Increments the view counter in memcached for a database
 func SetUserStatusUpdates(userName string, numUpdates int) error {
+	defer traceSpan("memcache", "SetUserStatusUpdates", time.Now())
 	// Generate the cache key
 	cacheString := fmt.Sprintf("status-updates-%s", userName)
 	tempArr := md5.Sum([]byte(cacheString))
@@ -237,10 +276,10 @@ func SetUserStatusUpdates(userName string, numUpdates int) error {
 	cachedData := memcache.Item{
 		Key:        cacheKey,
 		Value:      []byte(fmt.Sprintf("%d", numUpdates)),
-		Expiration: int32(Conf.Memcache.DefaultCacheTime),
+		Expiration: int32(Conf().Memcache.DefaultCacheTime),
 	}
 	err := memCache.Set(&cachedData)
-	if err != nil {
+	if err != nil && !CacheUnavailable(err) {
 		return err
 	}
 	return nil
@@ -263,6 +302,7 @@ func TableRowsCacheKey(prefix string, loggedInUser string, owner string, folder
 
 // Returns the number of status updates outstanding for a user
 func UserStatusUpdates(userName string) (numUpdates int, err error) {
+	defer traceSpan("memcache", "UserStatusUpdates", time.Now())
 	// Generate the cache key
 	cacheString := fmt.Sprintf("status-updates-%s", userName)
 	tempArr := md5.Sum([]byte(cacheString))
@@ -271,7 +311,7 @@ func UserStatusUpdates(userName string) (numUpdates int, err error) {
 	// Retrieve the status updates counter
 	data, err := memCache.Get(cacheKey)
 	if err != nil {
-		if err != memcache.ErrCacheMiss {
+		if err != memcache.ErrCacheMiss && !CacheUnavailable(err) {
 			// A real error occurred
 			return 0, err
 		}
@@ -289,10 +329,10 @@ func UserStatusUpdates(userName string) (numUpdates int, err error) {
 		cachedData := memcache.Item{
 			Key:        cacheKey,
 			Value:      []byte(fmt.Sprintf("%d", numUpdates)),
-			Expiration: int32(Conf.Memcache.DefaultCacheTime),
+			Expiration: int32(Conf().Memcache.DefaultCacheTime),
 		}
 		err = memCache.Set(&cachedData)
-		if err != nil {
+		if err != nil && !CacheUnavailable(err) {
 			return 0, err
 		}
 		return numUpdates, nil
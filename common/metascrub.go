@@ -0,0 +1,333 @@
+package common
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// People wanting to stay anonymous can have the details which identify them removed from their model files, either
+// when uploading them or afterwards from the project settings.  Author and copyright names, absolute file paths (which
+// often hold a user name), and the serial numbers and IDs of printers and accounts which slicers save are removed.
+// Nothing else about the model is changed.
+
+// Absolute file paths, on Windows, macOS, and Linux.  Only the file name at the end of them is kept.  They need to
+// start a word, so the paths in URLs aren't matched
+var scrubPathRE = regexp.MustCompile(`(^|[\s"'=>(,;])((?:file://)?(?:[A-Za-z]:[\\/]|\\\\[^\\/"'<>\r\n]+[\\/]|` +
+	`/(?:home|media|mnt|opt|root|srv|tmp|Users|var|Volumes)/)[^"'<>\r\n]*)`)
+
+// Settings in slicer configuration files which hold the IDs of machines or accounts, either as "key = value" lines or
+// as JSON strings
+var scrubIDSettingRE = regexp.MustCompile(`(?im)^(\s*;?\s*[\w.]*(?:dev_id|device_id|machine_id|printer_id|serial|` +
+	`user_id|uuid)[\w.]*\s*[=:]).*$`)
+var scrubIDFieldRE = regexp.MustCompile(`(?i)("[\w.]*(?:dev_id|device_id|machine_id|printer_id|serial|user_id|` +
+	`uuid)[\w.]*"\s*:\s*)"[^"]*"`)
+
+// The 3MF metadata entries which name people, or the accounts they use
+var scrub3MFMetaRE = regexp.MustCompile(`<metadata\s[^>]*name="(?:Copyright|Designer|DesignerUserId|ProfileUserId|` +
+	`ProfileUserName)"[^>]*(?:/>|>[^<]*</metadata>)`)
+
+// The glTF asset extras which name people
+var scrubGLTFExtras = []string{"author", "copyright", "creator", "designer"}
+
+// The solid and endsolid lines of ASCII STL files, with the name after them
+var stlSolidNameRE = regexp.MustCompile(`(?m)^(\s*(?:end)?solid)[ \t]+[^\r\n]*`)
+
+// Removes identifying details from a 3MF file.  The metadata entries are at the start of the model part, before its
+// resources, so only that part of it is looked at.  The configuration files slicers keep in the Metadata folder have
+// paths and IDs removed from them.
+func scrub3MF(data []byte) ([]byte, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, zf := range zr.File {
+		rc, err := zf.Open()
+		if err != nil {
+			return nil, err
+		}
+		content, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		ext := strings.ToLower(filepath.Ext(zf.Name))
+		switch {
+		case ext == ".model":
+			head, rest := content, []byte(nil)
+			if i := bytes.Index(content, []byte("<resources")); i != -1 {
+				head, rest = content[:i], content[i:]
+			}
+			head = scrub3MFMetaRE.ReplaceAll(head, nil)
+			content = append(scrubPaths(head), rest...)
+		case strings.HasPrefix(zf.Name, "Metadata/") && (ext == ".config" || ext == ".json" || ext == ".xml"):
+			content = scrubPaths(content)
+			content = scrubIDSettingRE.ReplaceAll(content, []byte("$1"))
+			content = scrubIDFieldRE.ReplaceAll(content, []byte(`$1""`))
+		}
+		hdr := zf.FileHeader
+		w, err := zw.CreateHeader(&hdr)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = w.Write(content); err != nil {
+			return nil, err
+		}
+	}
+	if err = zw.Close(); err != nil {
+		return nil, err
+	}
+
+	// Rewriting an archive changes its bytes even when nothing in it has, so the original is kept in that case
+	if changed, err := zipContentsDiffer(data, buf.Bytes()); err != nil || !changed {
+		return data, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Removes identifying details from the JSON chunk of a binary glTF file.  The binary chunks after it aren't touched.
+func scrubGLB(data []byte) ([]byte, error) {
+	if len(data) < 20 || string(data[0:4]) != "glTF" || string(data[16:20]) != "JSON" {
+		return nil, errors.New("Not a binary glTF file")
+	}
+	chunkLen := int(binary.LittleEndian.Uint32(data[12:16]))
+	if len(data) < 20+chunkLen {
+		return nil, errors.New("The JSON chunk is longer than the file")
+	}
+	doc, err := scrubGLTF(data[20 : 20+chunkLen])
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(doc, data[20:20+chunkLen]) {
+		return data, nil
+	}
+
+	// Chunks need to be padded to four bytes, with spaces for the JSON one
+	for len(doc)%4 != 0 {
+		doc = append(doc, ' ')
+	}
+	rest := data[20+chunkLen:]
+	out := make([]byte, 20, 20+len(doc)+len(rest))
+	copy(out, data[:12])
+	binary.LittleEndian.PutUint32(out[8:12], uint32(20+len(doc)+len(rest)))
+	binary.LittleEndian.PutUint32(out[12:16], uint32(len(doc)))
+	copy(out[16:20], "JSON")
+	out = append(out, doc...)
+	return append(out, rest...), nil
+}
+
+// Removes the copyright and author names from a glTF document, along with absolute paths anywhere in it (eg the
+// URIs of textures).  The document is only rewritten if something was removed.
+func scrubGLTF(data []byte) ([]byte, error) {
+	var doc map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	changed := false
+	if asset, ok := doc["asset"].(map[string]interface{}); ok {
+		if _, ok = asset["copyright"]; ok {
+			delete(asset, "copyright")
+			changed = true
+		}
+		if extras, ok := asset["extras"].(map[string]interface{}); ok {
+			for _, k := range scrubGLTFExtras {
+				if _, ok = extras[k]; ok {
+					delete(extras, k)
+					changed = true
+				}
+			}
+		}
+	}
+	if scrubJSONPaths(doc) {
+		changed = true
+	}
+	if !changed {
+		return data, nil
+	}
+	return json.Marshal(doc)
+}
+
+// Removes absolute paths from the strings in decoded JSON, returning whether any were changed.
+func scrubJSONPaths(v interface{}) (changed bool) {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for k, item := range val {
+			if s, ok := item.(string); ok {
+				if clean := string(scrubPaths([]byte(s))); clean != s {
+					val[k] = clean
+					changed = true
+				}
+			} else if scrubJSONPaths(item) {
+				changed = true
+			}
+		}
+	case []interface{}:
+		for i, item := range val {
+			if s, ok := item.(string); ok {
+				if clean := string(scrubPaths([]byte(s))); clean != s {
+					val[i] = clean
+					changed = true
+				}
+			} else if scrubJSONPaths(item) {
+				changed = true
+			}
+		}
+	}
+	return
+}
+
+// Removes identifying details from a 3D model file, rewriting it in place.  The format is worked out from the
+// extension of the name it was uploaded with.  Returns whether anything was removed.
+func ScrubModelMetadata(fileName string, uploadName string) (changed bool, err error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return
+	}
+	var out []byte
+	switch strings.ToLower(filepath.Ext(uploadName)) {
+	case ".3mf":
+		out, err = scrub3MF(data)
+	case ".glb":
+		out, err = scrubGLB(data)
+	case ".gltf":
+		out, err = scrubGLTF(data)
+	case ".obj":
+		out = scrubOBJ(data)
+	case ".ply":
+		out = scrubPLY(data)
+	case ".stl":
+		out = scrubSTL(data)
+	default:
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("The metadata couldn't be removed from the model: %v", err)
+	}
+	if bytes.Equal(data, out) {
+		return false, nil
+	}
+	return true, ioutil.WriteFile(fileName, out, 0600)
+}
+
+// Removes the comments from an OBJ file, which exporters fill with their name and the path of the source file, and
+// the paths from its material library references.
+func scrubOBJ(data []byte) []byte {
+	var out bytes.Buffer
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		if bytes.HasPrefix(bytes.TrimSpace(line), []byte("#")) {
+			continue
+		}
+		if bytes.HasPrefix(line, []byte("mtllib")) {
+			line = scrubPaths(line)
+		}
+		out.Write(line)
+	}
+	return out.Bytes()
+}
+
+// Replaces the absolute paths in some text with the file names at the end of them.
+func scrubPaths(data []byte) []byte {
+	return scrubPathRE.ReplaceAllFunc(data, func(match []byte) []byte {
+		m := scrubPathRE.FindSubmatch(match)
+		p := bytes.TrimRight(m[2], " \t")
+		if i := bytes.LastIndexAny(p, `/\`); i != -1 {
+			p = p[i+1:]
+		}
+		return append(append([]byte{}, m[1]...), p...)
+	})
+}
+
+// Removes the comment and object info lines from the header of a PLY file.
+func scrubPLY(data []byte) []byte {
+	end := bytes.Index(data, []byte("end_header"))
+	if end == -1 {
+		return data
+	}
+	var out bytes.Buffer
+	for _, line := range bytes.SplitAfter(data[:end], []byte("\n")) {
+		if bytes.HasPrefix(line, []byte("comment ")) || bytes.HasPrefix(line, []byte("obj_info ")) {
+			continue
+		}
+		out.Write(line)
+	}
+	out.Write(data[end:])
+	return out.Bytes()
+}
+
+// Removes identifying details from the default branch head of a project, committing the result as a new version.
+// Returns the ID of the new commit, which is empty if there was nothing to remove.  Older versions are left as they
+// are.
+func ScrubProjectMetadata(r *http.Request, owner string, folder string, fileName string) (commitID string,
+	err error) {
+	head, err := DefaultCommit(owner, folder, fileName)
+	if err != nil {
+		return
+	}
+	bucket, id, _, err := MinioLocation(owner, folder, fileName, head, owner)
+	if err != nil {
+		return
+	}
+	tempFile, err := printModelFile(bucket, id, fileName)
+	if err != nil {
+		return
+	}
+	defer os.Remove(tempFile)
+	changed, err := ScrubModelMetadata(tempFile, fileName)
+	if err != nil || !changed {
+		return
+	}
+	f, err := os.Open(tempFile)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	_, commitID, err = AddFile(r, owner, owner, folder, fileName, false, "", head, false, "",
+		"Removed identifying metadata from the model.", "", f, "webui", time.Now(), time.Time{}, "", "", "", "", nil,
+		"", false)
+	return
+}
+
+// Blanks the header of a binary STL file, or the solid names of an ASCII one.
+func scrubSTL(data []byte) []byte {
+	if len(data) >= 84 && int64(len(data)) == 84+int64(binary.LittleEndian.Uint32(data[80:84]))*50 {
+		out := make([]byte, len(data))
+		copy(out[80:], data[80:])
+		return out
+	}
+	return stlSolidNameRE.ReplaceAll(data, []byte("$1"))
+}
+
+// Reports whether the files in two zip archives differ in their names or contents.
+func zipContentsDiffer(a []byte, b []byte) (bool, error) {
+	za, err := zip.NewReader(bytes.NewReader(a), int64(len(a)))
+	if err != nil {
+		return false, err
+	}
+	zb, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		return false, err
+	}
+	if len(za.File) != len(zb.File) {
+		return true, nil
+	}
+	for i := range za.File {
+		if za.File[i].Name != zb.File[i].Name || za.File[i].CRC32 != zb.File[i].CRC32 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
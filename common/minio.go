@@ -4,9 +4,9 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"os"
 	"path/filepath"
+	"time"
 
 	sqlite "github.com/gwenn/gosqlite"
 	"github.com/minio/minio-go"
@@ -21,22 +21,23 @@ var (
 // Note - this doesn't actually open a connection to the Minio server.
 func ConnectMinio() (err error) {
 	// Connect to the Minio server
-	minioClient, err = minio.New(Conf.Minio.Server, Conf.Minio.AccessKey, Conf.Minio.Secret, Conf.Minio.HTTPS)
+	minioClient, err = minio.New(Conf().Minio.Server, Conf().Minio.AccessKey, Conf().Minio.Secret, Conf().Minio.HTTPS)
 	if err != nil {
 		return errors.New(fmt.Sprintf("Problem with Minio server configuration: %v\n", err))
 	}
 
 	// Log Minio server end point
-	log.Printf("Minio server config ok. Address: %v\n", Conf.Minio.Server)
+	Log.Infof("Minio server config ok. Address: %v", Conf().Minio.Server)
 
 	return nil
 }
 
 // Get a handle from Minio for a SQLite database object.
 func MinioHandle(bucket string, id string) (*minio.Object, error) {
+	defer traceSpan("minio", "MinioHandle", time.Now())
 	userDB, err := minioClient.GetObject(bucket, id, minio.GetObjectOptions{})
 	if err != nil {
-		log.Printf("Error retrieving DB from Minio: %v\n", err)
+		Log.Errorf("Error retrieving DB from Minio: %v", err)
 		return nil, errors.New("Error retrieving database from internal storage")
 	}
 
@@ -47,17 +48,33 @@ func MinioHandle(bucket string, id string) (*minio.Object, error) {
 func MinioHandleClose(userDB *minio.Object) (err error) {
 	err = userDB.Close()
 	if err != nil {
-		log.Printf("Error closing object handle: %v\n", err)
+		Log.Errorf("Error closing object handle: %v", err)
 	}
 	return
 }
 
+// Returns whether an object is stored in Minio.
+func MinioObjectExists(bucket string, id string) (bool, error) {
+	defer traceSpan("minio", "MinioObjectExists", time.Now())
+	_, err := minioClient.StatObject(bucket, id, minio.StatObjectOptions{})
+	if err != nil {
+		switch minio.ToErrorResponse(err).Code {
+		case "NoSuchBucket", "NoSuchKey":
+			return false, nil
+		}
+		Log.Errorf("Error checking whether Minio object '%s/%s' exists: %v", bucket, id, err)
+		return false, err
+	}
+	return true, nil
+}
+
 // Retrieves a SQLite database from Minio, opens it, returns the connection handle.
 // Also returns the name of the temp file created, which the caller needs to delete (os.Remove()) when finished with it
 func OpenMinioObject(bucket string, id string) (*sqlite.Conn, error) {
+	defer traceSpan("minio", "OpenMinioObject", time.Now())
 
 	// Check if the database file already exists
-	newDB := filepath.Join(Conf.DiskCache.Directory, bucket, id)
+	newDB := filepath.Join(Conf().DiskCache.Directory, bucket, id)
 	if _, err := os.Stat(newDB); os.IsNotExist(err) {
 		// * The database doesn't yet exist locally, so fetch it from Minio
 
@@ -78,22 +95,22 @@ func OpenMinioObject(bucket string, id string) (*sqlite.Conn, error) {
 			}()
 
 			// Create the needed directory path in the disk cache
-			err = os.MkdirAll(filepath.Join(Conf.DiskCache.Directory, bucket), 0750)
+			err = os.MkdirAll(filepath.Join(Conf().DiskCache.Directory, bucket), 0750)
 
 			// Save the database locally to the local disk cache, with ".new" on the end (will be renamed after file is
 			// finished writing)
 			f, err := os.OpenFile(newDB+".new", os.O_CREATE|os.O_WRONLY, 0750)
 			if err != nil {
-				log.Printf("Error creating new database file in the disk cache: %v\n", err)
+				Log.Errorf("Error creating new database file in the disk cache: %v", err)
 				return nil, errors.New("Internal server error")
 			}
 			bytesWritten, err := io.Copy(f, userDB)
 			if err != nil {
-				log.Printf("Error writing to new database file in the disk cache : %v\n", err)
+				Log.Errorf("Error writing to new database file in the disk cache : %v", err)
 				return nil, errors.New("Internal server error")
 			}
 			if bytesWritten == 0 {
-				log.Printf("0 bytes written to the new SQLite database file: %s\n", newDB+".new")
+				Log.Errorf("0 bytes written to the new SQLite database file: %s", newDB+".new")
 				return nil, errors.New("Internal server error")
 			}
 			f.Close()
@@ -101,7 +118,7 @@ func OpenMinioObject(bucket string, id string) (*sqlite.Conn, error) {
 			// Now that the database file has been fully written to disk, remove the .new on the end of the name
 			err = os.Rename(newDB+".new", newDB)
 			if err != nil {
-				log.Printf("Error when renaming .new database file to final form in the disk cache: %s\n", err.Error())
+				Log.Errorf("Error when renaming .new database file to final form in the disk cache: %s", err.Error())
 				return nil, errors.New("Internal server error")
 			}
 		} else {
@@ -121,47 +138,65 @@ func OpenMinioObject(bucket string, id string) (*sqlite.Conn, error) {
 	// screw things up, but it wouldn't be a bad idea to keep it in mind if weirdness shows up
 	sdb, err := sqlite.Open(newDB, sqlite.OpenReadWrite|sqlite.OpenFullMutex)
 	if err != nil {
-		log.Printf("Couldn't open database: %s", err)
+		Log.Errorf("Couldn't open database: %s", err)
 		return nil, errors.New("Internal server error")
 	}
 	err = sdb.EnableExtendedResultCodes(true)
 	if err != nil {
-		log.Printf("Couldn't enable extended result codes! Error: %v\n", err.Error())
+		Log.Errorf("Couldn't enable extended result codes! Error: %v", err.Error())
 	}
 	return sdb, nil
 }
 
+// Removes an object from Minio.
+func RemoveMinioObject(bucket string, id string) error {
+	defer traceSpan("minio", "RemoveMinioObject", time.Now())
+	err := minioClient.RemoveObject(bucket, id)
+	if err != nil {
+		Log.Errorf("Error removing Minio object '%s/%s': %v", bucket, id, err)
+	}
+	return err
+}
+
 // Store a database file in Minio.
-func StoreDatabaseFile(db *os.File, sha string, dbSize int64) error {
+func StoreDatabaseFile(db io.Reader, sha string, dbSize int64) error {
+	defer traceSpan("minio", "StoreDatabaseFile", time.Now())
 	bkt := sha[:MinioFolderChars]
 	id := sha[MinioFolderChars:]
 
 	// If a Minio bucket with the desired name doesn't already exist, create it
 	found, err := minioClient.BucketExists(bkt)
 	if err != nil {
-		log.Printf("Error when checking if Minio bucket '%s' already exists: %v\n", bkt, err)
+		Log.Errorf("Error when checking if Minio bucket '%s' already exists: %v", bkt, err)
 		return err
 	}
 	if !found {
 		err := minioClient.MakeBucket(bkt, "us-east-1")
 		if err != nil {
-			log.Printf("Error creating Minio bucket '%v': %v\n", bkt, err)
+			Log.Errorf("Error creating Minio bucket '%v': %v", bkt, err)
 			return err
 		}
 	}
 
-	// Store the SQLite database file in Minio
-	numBytes, err := minioClient.PutObject(bkt, id, db, dbSize, minio.PutObjectOptions{ContentType: "application/x-sqlite3"})
+	// Store the SQLite database file in Minio, working out its other checksums as it goes
+	hasher := newChecksummer()
+	numBytes, err := minioClient.PutObject(bkt, id, io.TeeReader(db, hasher), dbSize, minio.PutObjectOptions{ContentType: "application/x-sqlite3"})
 	if err != nil {
-		log.Printf("Storing file in Minio failed: %v\n", err)
+		Log.Errorf("Storing file in Minio failed: %v", err)
 		return err
 	}
 
 	// Sanity check.  Make sure the # of bytes written is equal to the size of the buffer we were given
 	if dbSize != numBytes {
-		log.Printf("Something went wrong storing the database file.  dbSize = %v, numBytes = %v\n", dbSize,
+		Log.Errorf("Something went wrong storing the database file.  dbSize = %v, numBytes = %v", dbSize,
 			numBytes)
 		return err
 	}
+
+	// The checksums are only kept when the whole file went through them.  Failing to store them isn't fatal, as
+	// they're worked out again when needed
+	if sums := hasher.Sums(); hasher.n == dbSize && sums.SHA256 == sha {
+		StoreChecksums(sums)
+	}
 	return nil
 }
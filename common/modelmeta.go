@@ -0,0 +1,337 @@
+package common
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Many model files carry details about where they came from, put there by the software which created them.  These
+// are read when a new project is uploaded, and used to fill in its description and README so people don't need to
+// type them in again.  STL files have a free text header (binary) or solid name (ASCII), 3MF files have metadata
+// entries in their model part, and glTF files have an asset block.
+
+// The most characters kept for each metadata field.  Headers are free text, so they can hold anything
+const modelMetaMaxLength = 1000
+
+// The solid names some software gives ASCII STL files, and the software each one means.  An empty name means the
+// solid name is a generic one, which says nothing about the model
+var stlSolidNames = map[string]string{
+	"ascii":          "",
+	"Mesh":           "",
+	"OpenSCAD_Model": "OpenSCAD",
+}
+
+// Picks out the name of the software from STL headers such as "Exported from Blender-2.93.1" or "STL generated by
+// SolidWorks"
+var stlSoftwareRE = regexp.MustCompile(`(?i)\b(?:created|exported|generated|made|saved|written)\s+` +
+	`(?:by|from|in|using|with)\s+(.+)$`)
+
+// Removes control characters and surrounding space from a metadata field, and limits its length.
+func cleanModelMeta(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\t' {
+			return r
+		}
+		if unicode.IsControl(r) || r == unicode.ReplacementChar {
+			return -1
+		}
+		return r
+	}, s)
+	s = strings.TrimSpace(s)
+	if r := []rune(s); len(r) > modelMetaMaxLength {
+		s = string(r[:modelMetaMaxLength-1]) + "…"
+	}
+	return s
+}
+
+// Reads the asset block from the JSON chunk of a binary glTF file.
+func glbMetadata(fileName string) (meta ModelMetadata, err error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	// The file starts with a 12 byte header, then the JSON chunk's length and type
+	var hdr [20]byte
+	if _, err = io.ReadFull(f, hdr[:]); err != nil {
+		return
+	}
+	if string(hdr[0:4]) != "glTF" || string(hdr[16:20]) != "JSON" {
+		return meta, errors.New("Not a binary glTF file")
+	}
+	chunkLen := int64(binary.LittleEndian.Uint32(hdr[12:16]))
+	return gltfMetadata(io.LimitReader(f, chunkLen))
+}
+
+// Reads the asset block of glTF JSON.  Besides the fields in the spec, the title and author which Sketchfab (and
+// others) put in its extras are used.
+func gltfMetadata(r io.Reader) (meta ModelMetadata, err error) {
+	var doc struct {
+		Asset struct {
+			Copyright string `json:"copyright"`
+			Extras    struct {
+				Author      string `json:"author"`
+				Description string `json:"description"`
+				Title       string `json:"title"`
+			} `json:"extras"`
+			Generator string `json:"generator"`
+		} `json:"asset"`
+	}
+	if err = json.NewDecoder(r).Decode(&doc); err != nil {
+		return
+	}
+	meta.Copyright = doc.Asset.Copyright
+	meta.Creator = doc.Asset.Extras.Author
+	meta.Description = doc.Asset.Extras.Description
+	meta.Software = doc.Asset.Generator
+	meta.Title = doc.Asset.Extras.Title
+	return
+}
+
+// Returns the details embedded in a model file.  The format is worked out from the extension of the name it was
+// uploaded with.  Anything which can't be read is skipped, as the details are only used to save people typing.
+func ModelFileMetadata(fileName string, uploadName string) (meta ModelMetadata) {
+	var err error
+	switch strings.ToLower(filepath.Ext(uploadName)) {
+	case ".3mf":
+		meta, err = threeMFMetadata(fileName)
+	case ".glb":
+		meta, err = glbMetadata(fileName)
+	case ".gltf":
+		var f *os.File
+		f, err = os.Open(fileName)
+		if err == nil {
+			meta, err = gltfMetadata(f)
+			f.Close()
+		}
+	case ".stl":
+		meta, err = stlMetadata(fileName)
+	}
+	if err != nil {
+		Log.Infof("Couldn't read the metadata from model '%s': %v", uploadName, err)
+		return ModelMetadata{}
+	}
+	meta.Comment = cleanModelMeta(meta.Comment)
+	meta.Copyright = cleanModelMeta(meta.Copyright)
+	meta.Creator = cleanModelMeta(meta.Creator)
+	meta.Description = cleanModelMeta(meta.Description)
+	meta.Software = cleanModelMeta(meta.Software)
+	meta.Title = cleanModelMeta(meta.Title)
+	return
+}
+
+// Fills in the one line description and README of a new project from the details embedded in its model.  Failing to
+// store them is only logged, as people can still add them themselves.
+func prefillProjectDetails(owner string, folder string, fileName string, meta ModelMetadata) {
+	oneLineDesc := meta.Title
+	if oneLineDesc == "" {
+		oneLineDesc = strings.SplitN(meta.Description, "\n", 2)[0]
+	}
+	if r := []rune(oneLineDesc); len(r) > 120 {
+		oneLineDesc = string(r[:119]) + "…"
+	}
+	if ValidateOneLineDescription(oneLineDesc) != nil {
+		oneLineDesc = ""
+	}
+	readme := meta.Readme()
+	if ValidateReadme(readme) != nil {
+		readme = ""
+	}
+	if oneLineDesc != "" {
+		err := StoreProjectDescriptions(owner, folder, fileName, oneLineDesc, "")
+		if err != nil {
+			Log.Errorf("Error when storing the description for '%s%s%s' from its model: %v", owner, folder,
+				fileName, err)
+		}
+	}
+	if readme != "" {
+		err := StoreProjectReadme(owner, folder, fileName, readme)
+		if err != nil {
+			Log.Errorf("Error when storing the README for '%s%s%s' from its model: %v", owner, folder, fileName,
+				err)
+		}
+	}
+}
+
+// Returns the README text for a new project, from the details embedded in its model file.  An empty string is
+// returned when there aren't any.
+func (m ModelMetadata) Readme() string {
+	var credits []string
+	if m.Creator != "" {
+		credits = append(credits, fmt.Sprintf("Designed by %s.", m.Creator))
+	}
+	if m.Software != "" {
+		credits = append(credits, fmt.Sprintf("Created with %s.", m.Software))
+	}
+	if m.Copyright != "" {
+		credits = append(credits, m.Copyright)
+	}
+	if m.Description == "" && m.Comment == "" && len(credits) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	if m.Title != "" {
+		fmt.Fprintf(&b, "# %s\n\n", m.Title)
+	}
+	if m.Description != "" {
+		b.WriteString(m.Description + "\n\n")
+	}
+	if m.Comment != "" {
+		b.WriteString(m.Comment + "\n\n")
+	}
+	if len(credits) != 0 {
+		b.WriteString(strings.Join(credits, "  ") + "\n")
+	}
+	return strings.TrimSpace(b.String()) + "\n"
+}
+
+// Reads the header of an STL file.  Binary STL files have an 80 byte header, which exporters often put their name in.
+// ASCII ones have the name on their "solid" line instead.
+func stlMetadata(fileName string) (meta ModelMetadata, err error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return
+	}
+	var hdr [84]byte
+	n, err := io.ReadFull(f, hdr[:])
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return
+	}
+	err = nil
+
+	// Binary files can start with "solid" too, so they're told apart by whether their size matches the triangle count
+	var text string
+	if n == len(hdr) && fi.Size() == 84+int64(binary.LittleEndian.Uint32(hdr[80:84]))*50 {
+		text = string(bytes.TrimRight(hdr[:80], "\x00 "))
+		text = strings.TrimPrefix(text, "solid ")
+	} else {
+		line := string(hdr[:n])
+		if i := strings.IndexAny(line, "\r\n"); i != -1 {
+			line = line[:i]
+		}
+		text = strings.TrimPrefix(strings.TrimSpace(line), "solid")
+	}
+	text = strings.TrimSpace(text)
+
+	// Skip the colour and material settings some exporters (eg Materialise Magics) keep in the header
+	if strings.HasPrefix(text, "COLOR=") || strings.HasPrefix(text, "MATERIAL=") {
+		return
+	}
+	if sw, ok := stlSolidNames[text]; ok {
+		meta.Software = sw
+		return
+	}
+	if m := stlSoftwareRE.FindStringSubmatch(text); m != nil {
+		meta.Software = m[1]
+		return
+	}
+	meta.Comment = text
+	return
+}
+
+// Reads the metadata entries from the model part of a 3MF file.  They come before the resources in the part, so it
+// isn't read any further than that.
+func threeMFMetadata(fileName string) (meta ModelMetadata, err error) {
+	zr, err := zip.OpenReader(fileName)
+	if err != nil {
+		return
+	}
+	defer zr.Close()
+
+	// The package relationships say where the model part is, though it's nearly always in the same place
+	partName := "3D/3dmodel.model"
+	for _, zf := range zr.File {
+		if zf.Name != "_rels/.rels" {
+			continue
+		}
+		var rels struct {
+			Relationships []struct {
+				Target string `xml:"Target,attr"`
+				Type   string `xml:"Type,attr"`
+			} `xml:"Relationship"`
+		}
+		rc, err := zf.Open()
+		if err != nil {
+			return meta, err
+		}
+		err = xml.NewDecoder(io.LimitReader(rc, 1<<20)).Decode(&rels)
+		rc.Close()
+		if err != nil {
+			return meta, err
+		}
+		for _, rel := range rels.Relationships {
+			if strings.HasSuffix(rel.Type, "/3dmodel") {
+				partName = strings.TrimPrefix(path.Clean("/"+rel.Target), "/")
+			}
+		}
+	}
+
+	for _, zf := range zr.File {
+		if zf.Name != partName {
+			continue
+		}
+		rc, err := zf.Open()
+		if err != nil {
+			return meta, err
+		}
+		defer rc.Close()
+		dec := xml.NewDecoder(rc)
+		for {
+			tok, err := dec.Token()
+			if err == io.EOF {
+				return meta, nil
+			}
+			if err != nil {
+				return meta, err
+			}
+			el, ok := tok.(xml.StartElement)
+			if !ok {
+				continue
+			}
+			if el.Name.Local == "resources" {
+				return meta, nil
+			}
+			if el.Name.Local != "metadata" {
+				continue
+			}
+			var entry struct {
+				Name  string `xml:"name,attr"`
+				Value string `xml:",chardata"`
+			}
+			if err = dec.DecodeElement(&entry, &el); err != nil {
+				return meta, err
+			}
+			switch entry.Name {
+			case "Application":
+				meta.Software = entry.Value
+			case "Copyright":
+				meta.Copyright = entry.Value
+			case "Description":
+				meta.Description = entry.Value
+			case "Designer":
+				meta.Creator = entry.Value
+			case "Title":
+				meta.Title = entry.Value
+			}
+		}
+	}
+	return meta, fmt.Errorf("The model part '%s' wasn't found", partName)
+}
@@ -0,0 +1,66 @@
+package common
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// File and project names can use letters and digits from any script, along with spaces and a few punctuation
+// characters.  The same name can be written using different sequences of code points (eg "é" as one character, or as
+// "e" followed by a combining accent), so names are normalised to NFC wherever they come in.  That way a name typed on
+// one system finds the project uploaded from another.  Names go into URLs percent-encoded, and into the
+// Content-Disposition header of downloads using RFC 6266's UTF-8 form.
+
+// The punctuation allowed in file names, besides letters, marks, and digits.  Characters which mean something in URLs
+// or paths (eg "/", "?", "#", and "%") aren't allowed
+const fileNamePunctuation = ".-_()+ "
+
+// Returns the Content-Disposition header for downloading a file.  Older clients get a plain ASCII version of the name,
+// with anything else replaced by underscores, while others use the full name.
+func AttachmentDisposition(fileName string) string {
+	var ascii strings.Builder
+	for _, r := range fileName {
+		if r < 0x20 || r > 0x7e || r == '"' || r == '\\' {
+			ascii.WriteRune('_')
+		} else {
+			ascii.WriteRune(r)
+		}
+	}
+	if ascii.String() == fileName {
+		return fmt.Sprintf(`attachment; filename="%s"`, fileName)
+	}
+	return fmt.Sprintf(`attachment; filename="%s"; filename*=UTF-8''%s`, ascii.String(),
+		strings.Replace(url.PathEscape(fileName), "'", "%27", -1))
+}
+
+// Normalises a file or project name to NFC, so names which look the same are the same.
+func NormaliseName(name string) string {
+	return norm.NFC.String(name)
+}
+
+// Returns the path on the website for a project, with the owner and file name percent-encoded where needed.
+func ProjectPath(owner string, folder string, fileName string) string {
+	return "/" + url.PathEscape(owner) + folder + url.PathEscape(fileName)
+}
+
+// Reports whether a file name is one which can be used for a project.  It needs to be normalised already, and can't be
+// a path element such as "..".
+func validFileName(name string) bool {
+	if !utf8.ValidString(name) || !norm.NFC.IsNormalString(name) || strings.Trim(name, ".") == "" {
+		return false
+	}
+	for _, r := range name {
+		if unicode.IsLetter(r) || unicode.IsMark(r) || unicode.IsDigit(r) {
+			continue
+		}
+		if !strings.ContainsRune(fileNamePunctuation, r) {
+			return false
+		}
+	}
+	return true
+}
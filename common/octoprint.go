@@ -0,0 +1,231 @@
+package common
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Users can link their OctoPrint instance from their preferences page, then send models to it from project pages.
+// The API key for it is stored encrypted, using the secrets key from the configuration file.  G-code files are
+// uploaded to OctoPrint as they are, and selected ready for printing (but not started, as the printer might not be
+// ready).  Other models are converted to STL first, which OctoPrint can slice itself.
+
+// The file extensions OctoPrint treats as G-code
+var octoPrintGCode = map[string]bool{".g": true, ".gco": true, ".gcode": true}
+
+// Used for the requests to OctoPrint instances.  The address is checked after it's been looked up, so host names
+// can't be used to get around the blocked ranges
+var octoPrintClient = &http.Client{
+	Timeout: 10 * time.Minute,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Control: octoPrintCheckAddress,
+			Timeout: 30 * time.Second,
+		}).DialContext,
+		TLSHandshakeTimeout: 30 * time.Second,
+	},
+}
+
+// Links an OctoPrint instance to a user's account.  The URL and API key are checked by asking the instance for its
+// version first.
+func LinkOctoPrint(userName string, octoURL string, apiKey string) error {
+	u, err := url.Parse(strings.TrimSpace(octoURL))
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || u.User != nil {
+		return errors.New("The OctoPrint address needs to be a http or https URL")
+	}
+	u.RawQuery, u.Fragment = "", ""
+	baseURL := strings.TrimSuffix(u.String(), "/")
+	apiKey = strings.TrimSpace(apiKey)
+	if apiKey == "" {
+		return errors.New("The API key for OctoPrint is needed")
+	}
+
+	data, err := octoPrintRequest(http.MethodGet, baseURL, apiKey, "/api/version", "", nil)
+	if err != nil {
+		return err
+	}
+	var ver struct {
+		Server string `json:"server"`
+	}
+	if err = json.Unmarshal(data, &ver); err != nil || ver.Server == "" {
+		return errors.New("That doesn't look like an OctoPrint instance")
+	}
+
+	sealed, err := sealSecret(apiKey)
+	if err != nil {
+		Log.Errorf("Encrypting the OctoPrint API key of '%s' failed: %v", userName, err)
+		return errors.New("The API key couldn't be stored")
+	}
+	err = StoreOctoPrintLink(userName, baseURL, sealed)
+	if err != nil {
+		return err
+	}
+	Log.Infof("User '%s' linked the OctoPrint %s instance at '%s'", userName, ver.Server, baseURL)
+	return nil
+}
+
+// Checks the address being connected to for an OctoPrint instance isn't on our own network.  The same addresses are
+// refused as for OpenSCAD sources.
+func octoPrintCheckAddress(network string, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if !regenPublicIP(net.ParseIP(host)) {
+		return errors.New("OctoPrint instances need to be reachable from the internet")
+	}
+	return nil
+}
+
+// Calls the API of an OctoPrint instance, returning its response body.
+func octoPrintRequest(method string, baseURL string, apiKey string, path string, contentType string,
+	body io.Reader) ([]byte, error) {
+	req, err := http.NewRequest(method, baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "3DHub.io")
+	req.Header.Set("X-Api-Key", apiKey)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := octoPrintClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Connecting to OctoPrint failed: %v", err)
+	}
+	defer resp.Body.Close()
+	var out bytes.Buffer
+	io.Copy(&out, io.LimitReader(resp.Body, 1<<20))
+	switch {
+	case resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusUnauthorized:
+		return nil, errors.New("OctoPrint didn't accept the API key")
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return nil, fmt.Errorf("OctoPrint responded with status %d", resp.StatusCode)
+	}
+	return out.Bytes(), nil
+}
+
+// Decrypts a secret encrypted by sealSecret().
+func openSecret(sealed []byte) (string, error) {
+	aead, err := secretsCipher()
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", errors.New("The stored secret is too short")
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+// Encrypts a secret a user is storing with us (eg an API key), using the secrets key from the configuration file.
+// The random nonce is kept at the start of the result.
+func sealSecret(secret string) ([]byte, error) {
+	aead, err := secretsCipher()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, []byte(secret), nil), nil
+}
+
+// Returns the cipher for the secrets users store with us.
+func secretsCipher() (cipher.AEAD, error) {
+	if Conf().Web.SecretsKey == "" {
+		return nil, errors.New("No secrets key has been set in the configuration file")
+	}
+	key := sha256.Sum256([]byte(Conf().Web.SecretsKey))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Sends the model of a project to the OctoPrint instance the user has linked.  Returns the name the file was given
+// there.
+func SendToOctoPrint(userName string, bucket string, id string, fileName string) (name string, err error) {
+	link, found, err := OctoPrintLinkDetails(userName)
+	if err != nil {
+		return
+	}
+	if !found {
+		return "", errors.New("You haven't linked an OctoPrint instance")
+	}
+	apiKey, err := openSecret(link.APIKey)
+	if err != nil {
+		Log.Errorf("Decrypting the OctoPrint API key of '%s' failed: %v", userName, err)
+		return "", errors.New("The stored OctoPrint API key couldn't be used.  Please link OctoPrint again")
+	}
+
+	// G-code goes as it is, and anything else is converted to STL for OctoPrint to slice
+	inFile, err := printModelFile(bucket, id, fileName)
+	if err != nil {
+		return
+	}
+	defer os.Remove(inFile)
+	ext := strings.ToLower(filepath.Ext(fileName))
+	gcode := octoPrintGCode[ext]
+	modelFile, name := inFile, fileName
+	if !gcode && ext != ".stl" {
+		modelFile, err = convertForPrint(inFile, "stl", 1)
+		if err != nil {
+			return
+		}
+		defer os.Remove(modelFile)
+		name = strings.TrimSuffix(fileName, filepath.Ext(fileName)) + ".stl"
+	}
+
+	f, err := os.Open(modelFile)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, err := mw.CreateFormFile("file", name)
+	if err != nil {
+		return
+	}
+	if _, err = io.Copy(part, f); err != nil {
+		return
+	}
+	if gcode {
+		if err = mw.WriteField("select", "true"); err != nil {
+			return
+		}
+	}
+	if err = mw.Close(); err != nil {
+		return
+	}
+	_, err = octoPrintRequest(http.MethodPost, link.URL, apiKey, "/api/files/local", mw.FormDataContentType(),
+		&body)
+	if err != nil {
+		return
+	}
+	OctoPrintLinkUsed(userName)
+	Log.Infof("User '%s' sent '%s' to their OctoPrint instance", userName, name)
+	return name, nil
+}
@@ -0,0 +1,399 @@
+package common
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	sqlite "github.com/gwenn/gosqlite"
+)
+
+// A minimal Parquet file writer, for exporting tables.  Each column is written as a single uncompressed data page per
+// row group, using the plain encoding.  That's all that's needed for the files to be readable by other tools, and it
+// keeps the memory used down to one row group at a time.
+
+// The number of rows in each Parquet row group
+const parquetRowGroupSize = 10000
+
+// The Parquet physical and logical types used.  The numbers are from the Parquet format definition
+const (
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	parquetConvertedUTF8 = 0
+	parquetOptional      = 1
+	parquetEncodingPlain = 0
+	parquetEncodingRLE   = 3
+)
+
+// Thrift compact protocol types, used for the Parquet metadata
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// One column of the Parquet file being written
+type parquetColumn struct {
+	name     string
+	present  []bool
+	typ      int32
+	utf8     bool
+	values   bytes.Buffer
+	metadata []parquetChunk
+}
+
+// Where a column's data for a row group was written
+type parquetChunk struct {
+	numValues int64
+	offset    int64
+	size      int64
+}
+
+// Writes a table as an Apache Parquet file.  SQLite columns can hold any type of data, so the table is read through
+// once first to find the types in each column.  Columns with only integers are written as 64 bit integers, ones with
+// only numbers as doubles, and anything else as strings (or byte arrays when they're all blobs).
+func writeTableParquet(sdb *sqlite.Conn, dbTable string, w io.Writer) error {
+	cols, err := parquetColumns(sdb, dbTable)
+	if err != nil {
+		return err
+	}
+	out := &countingWriter{w: w}
+	if _, err = io.WriteString(out, "PAR1"); err != nil {
+		return err
+	}
+
+	// Write the rows out a row group at a time
+	var groupRows []int64
+	rows := 0
+	flush := func() error {
+		if rows == 0 {
+			return nil
+		}
+		for _, c := range cols {
+			if err := c.writeChunk(out); err != nil {
+				return err
+			}
+		}
+		groupRows = append(groupRows, int64(rows))
+		rows = 0
+		return nil
+	}
+	start := func([]string) error { return nil }
+	err = scanTableRows(sdb, dbTable, start, func(vals []interface{}) error {
+		for i, v := range vals {
+			cols[i].add(v)
+		}
+		rows++
+		if rows == parquetRowGroupSize {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err = flush(); err != nil {
+		return err
+	}
+
+	// Finish with the file metadata, its length, and the magic number again
+	meta := parquetFileMetadata(cols, groupRows)
+	if _, err = out.Write(meta); err != nil {
+		return err
+	}
+	if err = binary.Write(out, binary.LittleEndian, uint32(len(meta))); err != nil {
+		return err
+	}
+	_, err = io.WriteString(out, "PAR1")
+	return err
+}
+
+// Works out the Parquet type for each column of a table, from the types of data in it.
+func parquetColumns(sdb *sqlite.Conn, dbTable string) ([]*parquetColumn, error) {
+	stmt, err := sdb.Prepare(`SELECT * FROM "` + strings.Replace(dbTable, `"`, `""`, -1) + `" LIMIT 0`)
+	if err != nil {
+		Log.Errorf("Error when preparing statement for database: %s", err)
+		return nil, err
+	}
+	names := stmt.ColumnNames()
+	stmt.Finalize()
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	var q []string
+	for _, n := range names {
+		q = append(q, `group_concat(DISTINCT typeof("`+strings.Replace(n, `"`, `""`, -1)+`"))`)
+	}
+	stmt, err = sdb.Prepare(`SELECT ` + strings.Join(q, ", ") + ` FROM "` + strings.Replace(dbTable, `"`, `""`, -1) +
+		`"`)
+	if err != nil {
+		Log.Errorf("Error when preparing statement for database: %s", err)
+		return nil, err
+	}
+	defer stmt.Finalize()
+	types := make([]interface{}, len(names))
+	for i := range types {
+		types[i] = new(string)
+	}
+	if _, err = stmt.SelectOneRow(types...); err != nil {
+		return nil, err
+	}
+
+	var cols []*parquetColumn
+	for i, n := range names {
+		t := map[string]bool{}
+		for _, s := range strings.Split(*types[i].(*string), ",") {
+			if s != "" && s != "null" {
+				t[s] = true
+			}
+		}
+		c := &parquetColumn{name: n, typ: parquetByteArray, utf8: true}
+		switch {
+		case len(t) == 1 && t["integer"]:
+			c.typ, c.utf8 = parquetInt64, false
+		case len(t) > 0 && len(t) <= 2 && !t["text"] && !t["blob"]:
+			c.typ, c.utf8 = parquetDouble, false
+		case len(t) == 1 && t["blob"]:
+			c.utf8 = false
+		}
+		cols = append(cols, c)
+	}
+	return cols, nil
+}
+
+// Returns the Parquet file metadata, encoded with the Thrift compact protocol.
+func parquetFileMetadata(cols []*parquetColumn, groupRows []int64) []byte {
+	var numRows int64
+	for _, n := range groupRows {
+		numRows += n
+	}
+	t := &thriftWriter{}
+	t.i32(1, 1) // Version
+
+	// The schema is a root element, followed by one for each column
+	t.listHeader(2, thriftStruct, len(cols)+1)
+	t.beginStruct(-1)
+	t.binary(4, []byte("schema"))
+	t.i32(5, int32(len(cols)))
+	t.endStruct()
+	for _, c := range cols {
+		t.beginStruct(-1)
+		t.i32(1, c.typ)
+		t.i32(3, parquetOptional)
+		t.binary(4, []byte(c.name))
+		if c.utf8 {
+			t.i32(6, parquetConvertedUTF8)
+		}
+		t.endStruct()
+	}
+	t.i64(3, numRows)
+
+	// The row groups, and where each of their columns was written
+	t.listHeader(4, thriftStruct, len(groupRows))
+	for g, n := range groupRows {
+		t.beginStruct(-1)
+		t.listHeader(1, thriftStruct, len(cols))
+		var groupSize int64
+		for _, c := range cols {
+			m := c.metadata[g]
+			groupSize += m.size
+			t.beginStruct(-1)
+			t.i64(2, m.offset)
+			t.beginStruct(3)
+			t.i32(1, c.typ)
+			t.listHeader(2, thriftI32, 2)
+			t.varint(zigzag(parquetEncodingPlain))
+			t.varint(zigzag(parquetEncodingRLE))
+			t.listHeader(3, thriftBinary, 1)
+			t.varint(uint64(len(c.name)))
+			t.buf.WriteString(c.name)
+			t.i32(4, 0) // Uncompressed
+			t.i64(5, m.numValues)
+			t.i64(6, m.size)
+			t.i64(7, m.size)
+			t.i64(9, m.offset)
+			t.endStruct()
+			t.endStruct()
+		}
+		t.i64(2, groupSize)
+		t.i64(3, n)
+		t.endStruct()
+	}
+	t.binary(6, []byte("3dhub.io"))
+	t.buf.WriteByte(0)
+	return t.buf.Bytes()
+}
+
+// Adds a value to the column, converting it to the column's type.
+func (c *parquetColumn) add(v interface{}) {
+	if v == nil {
+		c.present = append(c.present, false)
+		return
+	}
+	c.present = append(c.present, true)
+	switch c.typ {
+	case parquetInt64:
+		binary.Write(&c.values, binary.LittleEndian, v.(int64))
+		return
+	case parquetDouble:
+		f, ok := v.(float64)
+		if !ok {
+			f = float64(v.(int64))
+		}
+		binary.Write(&c.values, binary.LittleEndian, math.Float64bits(f))
+		return
+	}
+	var b []byte
+	switch v := v.(type) {
+	case int64:
+		b = []byte(strconv.FormatInt(v, 10))
+	case float64:
+		b = []byte(strconv.FormatFloat(v, 'g', -1, 64))
+	case string:
+		b = []byte(v)
+	case []byte:
+		b = v
+	}
+	binary.Write(&c.values, binary.LittleEndian, uint32(len(b)))
+	c.values.Write(b)
+}
+
+// Writes out the values added to the column since the last row group, as a single data page.
+func (c *parquetColumn) writeChunk(out *countingWriter) error {
+	// The definition levels say which values are present (1) or null (0), and are run length encoded
+	var levels bytes.Buffer
+	for i := 0; i < len(c.present); {
+		j := i
+		for j < len(c.present) && c.present[j] == c.present[i] {
+			j++
+		}
+		var b [binary.MaxVarintLen64]byte
+		levels.Write(b[:binary.PutUvarint(b[:], uint64(j-i)<<1)])
+		if c.present[i] {
+			levels.WriteByte(1)
+		} else {
+			levels.WriteByte(0)
+		}
+		i = j
+	}
+	pageSize := 4 + levels.Len() + c.values.Len()
+
+	t := &thriftWriter{}
+	t.i32(1, 0) // Data page
+	t.i32(2, int32(pageSize))
+	t.i32(3, int32(pageSize))
+	t.beginStruct(5)
+	t.i32(1, int32(len(c.present)))
+	t.i32(2, parquetEncodingPlain)
+	t.i32(3, parquetEncodingRLE)
+	t.i32(4, parquetEncodingRLE)
+	t.endStruct()
+	t.buf.WriteByte(0)
+
+	chunk := parquetChunk{numValues: int64(len(c.present)), offset: out.n}
+	if _, err := out.Write(t.buf.Bytes()); err != nil {
+		return err
+	}
+	if err := binary.Write(out, binary.LittleEndian, uint32(levels.Len())); err != nil {
+		return err
+	}
+	if _, err := out.Write(levels.Bytes()); err != nil {
+		return err
+	}
+	if _, err := out.Write(c.values.Bytes()); err != nil {
+		return err
+	}
+	chunk.size = out.n - chunk.offset
+	c.metadata = append(c.metadata, chunk)
+	c.present = c.present[:0]
+	c.values.Reset()
+	return nil
+}
+
+// Keeps track of how much has been written, for the offsets in the Parquet metadata.
+type countingWriter struct {
+	n int64
+	w io.Writer
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Encodes structures with the Thrift compact protocol.  Only the parts needed for the Parquet metadata are included.
+type thriftWriter struct {
+	buf     bytes.Buffer
+	lastID  int16
+	idStack []int16
+}
+
+// Starts a struct.  An id of -1 is for a struct which is a list element, rather than a field.
+func (t *thriftWriter) beginStruct(id int16) {
+	if id >= 0 {
+		t.fieldHeader(id, thriftStruct)
+	}
+	t.idStack = append(t.idStack, t.lastID)
+	t.lastID = 0
+}
+
+func (t *thriftWriter) binary(id int16, b []byte) {
+	t.fieldHeader(id, thriftBinary)
+	t.varint(uint64(len(b)))
+	t.buf.Write(b)
+}
+
+func (t *thriftWriter) endStruct() {
+	t.buf.WriteByte(0)
+	t.lastID = t.idStack[len(t.idStack)-1]
+	t.idStack = t.idStack[:len(t.idStack)-1]
+}
+
+func (t *thriftWriter) fieldHeader(id int16, typ byte) {
+	if delta := id - t.lastID; delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		t.buf.WriteByte(typ)
+		t.varint(zigzag(int64(id)))
+	}
+	t.lastID = id
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.fieldHeader(id, thriftI32)
+	t.varint(zigzag(int64(v)))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.fieldHeader(id, thriftI64)
+	t.varint(zigzag(v))
+}
+
+// Starts a list field.  The elements are written straight after.
+func (t *thriftWriter) listHeader(id int16, elemType byte, size int) {
+	t.fieldHeader(id, thriftList)
+	if size < 15 {
+		t.buf.WriteByte(byte(size)<<4 | elemType)
+		return
+	}
+	t.buf.WriteByte(0xf0 | elemType)
+	t.varint(uint64(size))
+}
+
+func (t *thriftWriter) varint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	t.buf.Write(b[:binary.PutUvarint(b[:], v)])
+}
+
+func zigzag(v int64) uint64 {
+	return uint64((v << 1) ^ (v >> 63))
+}
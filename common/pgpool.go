@@ -0,0 +1,133 @@
+package common
+
+import (
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx"
+)
+
+// The PostgreSQL connection pool.  When a connection can't be acquired (eg the server is being restarted, or a network
+// blip stops new connections being made), it's retried a few times rather than the request failing straight away.
+// Statements which fail after being sent aren't retried, as there's no way to tell whether PostgreSQL committed them
+// before the connection broke.
+type pgPool struct {
+	lastUsed int64 // Unix time in nanoseconds, accessed atomically
+	*pgx.ConnPool
+}
+
+// How long to wait before the first retry.  It's doubled for each one after that
+const pgRetryDelay = 100 * time.Millisecond
+
+// Acquires a connection from the pool, retrying if it couldn't be made.
+func (p *pgPool) acquire() (c *pgx.Conn, err error) {
+	delay := pgRetryDelay
+	for attempt := 0; ; attempt++ {
+		atomic.StoreInt64(&p.lastUsed, time.Now().UnixNano())
+		c, err = p.ConnPool.Acquire()
+		if !pgConnectionError(err) || attempt >= Conf().Pg.Retries {
+			return
+		}
+		Log.Warnf("PostgreSQL connection problem, retrying in %v: %v", delay, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+func (p *pgPool) Begin() (*pgx.Tx, error) {
+	c, err := p.acquire()
+	if err != nil {
+		return nil, err
+	}
+	tx, err := c.Begin()
+	if err != nil {
+		p.release(c, err)
+		return nil, err
+	}
+	tx.AfterClose(func(*pgx.Tx) {
+		p.Release(c)
+	})
+	return tx, nil
+}
+
+// Closes the pool's connections when nothing has used them for the configured idle timeout, so a quiet server doesn't
+// keep connections open on the PostgreSQL server.  They're opened again when needed.
+func (p *pgPool) closeIdle(timeout time.Duration) {
+	interval := timeout / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+	for {
+		time.Sleep(interval)
+		idle := time.Since(time.Unix(0, atomic.LoadInt64(&p.lastUsed)))
+		if idle >= timeout && p.Stat().CurrentConnections > 0 {
+			Log.Debugf("Closing PostgreSQL connections, as they've been idle for %v", idle.Round(time.Second))
+			p.Reset()
+		}
+	}
+}
+
+func (p *pgPool) Exec(sql string, arguments ...interface{}) (commandTag pgx.CommandTag, err error) {
+	c, err := p.acquire()
+	if err != nil {
+		return
+	}
+	commandTag, err = c.Exec(sql, arguments...)
+	p.release(c, err)
+	return
+}
+
+// Returns whether an error means the connection to PostgreSQL failed, rather than something being wrong with the
+// statement itself.
+func pgConnectionError(err error) bool {
+	switch err {
+	case nil:
+		return false
+	case pgx.ErrAcquireTimeout, pgx.ErrDeadConn, io.EOF, io.ErrUnexpectedEOF:
+		return true
+	}
+	if pgErr, ok := err.(pgx.PgError); ok {
+		// Class 08 is connection exceptions.  57P01 to 57P03 are the server shutting down or starting up
+		return strings.HasPrefix(pgErr.Code, "08") || pgErr.Code == "57P01" || pgErr.Code == "57P02" ||
+			pgErr.Code == "57P03"
+	}
+	_, ok := err.(net.Error)
+	return ok
+}
+
+func (p *pgPool) Query(sql string, args ...interface{}) (*pgx.Rows, error) {
+	c, err := p.acquire()
+	if err != nil {
+		// Leave one last attempt to the pool itself, as when that fails too it returns rows holding the error for
+		// Scan() to return
+		return p.ConnPool.Query(sql, args...)
+	}
+	rows, err := c.Query(sql, args...)
+	if err != nil {
+		p.release(c, err)
+		return rows, err
+	}
+	rows.AfterClose(func(*pgx.Rows) {
+		p.Release(c)
+	})
+	return rows, nil
+}
+
+// Errors from running the query are returned when Scan() is called, the same as for pgx.ConnPool.
+func (p *pgPool) QueryRow(sql string, args ...interface{}) *pgx.Row {
+	rows, _ := p.Query(sql, args...)
+	return (*pgx.Row)(rows)
+}
+
+// Returns a connection to the pool after running a statement on it.  When the statement failed because the connection
+// broke, the others in the pool probably have too (eg the server was restarted), so the pool starts afresh rather than
+// the following statements working through them one by one.
+func (p *pgPool) release(c *pgx.Conn, err error) {
+	p.Release(c)
+	if pgConnectionError(err) && err != pgx.ErrAcquireTimeout {
+		p.Reset()
+	}
+}
@@ -6,7 +6,6 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
-	"log"
 	"net/url"
 	"os"
 	"strings"
@@ -17,13 +16,356 @@ import (
 	"github.com/jackc/pgx"
 	gfm "github.com/sqlitebrowser/github_flavored_markdown"
 	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/sync/errgroup"
 )
 
 var (
 	// PostgreSQL connection pool handle
-	pdb *pgx.ConnPool
+	pdb *pgPool
 )
 
+// The activity stats shown on the front page are cached for this many seconds, under this key
+const (
+	activityStatsCacheKey  = "activity-stats"
+	activityStatsCacheTime = 60
+)
+
+// The full category tree, with the "/" separated name and slug paths for each category.  Used as a sub-select
+const categoryTree = `(
+		WITH RECURSIVE tree AS (
+			SELECT cat_id, parent_id, cat_name, slug, cat_name AS path, slug AS slug_path, 0 AS depth
+			FROM categories
+			WHERE parent_id IS NULL
+			UNION ALL
+			SELECT c.cat_id, c.parent_id, c.cat_name, c.slug, tree.path || '/' || c.cat_name,
+				tree.slug_path || '/' || c.slug, tree.depth + 1
+			FROM categories AS c
+				JOIN tree ON c.parent_id = tree.cat_id
+		)
+		SELECT * FROM tree
+	)`
+
+// The licence of a project, as given by the licence sha256 for the head commit of its default branch.  Used as a
+// lateral join against sqlite_databases (aliased as "db"), preferring the owner's licence details over the defaults
+const headLicence = `LATERAL (
+				SELECT dl.friendly_name, dl.licence_url
+				FROM database_licences AS dl
+				WHERE dl.lic_sha256 = db.commit_list->(db.branch_heads->db.default_branch->>'commit')->'tree'->'entries'->0->>'licence'
+					AND (dl.user_id = db.user_id
+						OR dl.user_id = (SELECT user_id FROM users WHERE user_name = 'default'))
+				ORDER BY dl.user_id = db.user_id DESC
+				LIMIT 1
+			)`
+
+// True when a user (aliased as "u") has nothing on the site which others could be relying on: no projects (even
+// deleted ones), no redirects from projects they used to own, and no discussions or comments.  Used to make sure
+// reclaiming a username can't let someone else take over links to things the old user made
+const userHasNoContent = `NOT EXISTS (SELECT 1 FROM sqlite_databases AS db WHERE db.user_id = u.user_id)
+			AND NOT EXISTS (SELECT 1 FROM project_redirects AS pr WHERE pr.user_id = u.user_id)
+			AND NOT EXISTS (SELECT 1 FROM discussions AS disc WHERE disc.creator = u.user_id)
+			AND NOT EXISTS (SELECT 1 FROM discussion_comments AS com WHERE com.commenter = u.user_id)
+			AND NOT EXISTS (SELECT 1 FROM held_comments AS held WHERE held.commenter = u.user_id)`
+
+// The text search vector for a project.  This needs to be kept in sync with the sqlite_databases_search_idx index
+const searchVector = `(setweight(to_tsvector('simple', regexp_replace(db_name, '[._-]', ' ', 'g')), 'A') || ` +
+	`setweight(to_tsvector('english', coalesce(one_line_description, '')), 'B') || ` +
+	`setweight(to_tsvector('english', coalesce(full_description, '')), 'C') || ` +
+	`setweight(to_tsvector('english', project_tags_text(project_tags)), 'B'))`
+
+// Marks one of the answers to a question as the accepted one.  An answer ID of 0 removes the accepted answer.
+func AcceptAnswer(owner string, folder string, fileName string, discID int, comID int) error {
+	dbQuery := `
+		WITH d AS (
+			SELECT db.db_id
+			FROM sqlite_databases AS db
+			WHERE db.user_id = (
+					SELECT user_id
+					FROM users
+					WHERE lower(user_name) = lower($1)
+				)
+				AND db.folder = $2
+				AND db.db_name = $3
+		)
+		UPDATE discussions AS disc
+		SET accepted_answer = (
+				SELECT com.com_id
+				FROM discussion_comments AS com
+				WHERE com.disc_id = disc.internal_id
+					AND com.com_id = $5
+					AND com.entry_type = 'txt'
+			)
+		WHERE disc.db_id = (SELECT db_id FROM d)
+			AND disc.disc_id = $4
+			AND disc.discussion_type = $6
+			AND ($5 = 0 OR EXISTS (
+				SELECT 1
+				FROM discussion_comments AS com
+				WHERE com.disc_id = disc.internal_id
+					AND com.com_id = $5
+					AND com.entry_type = 'txt'
+			))`
+	commandTag, err := pdb.Exec(dbQuery, owner, folder, fileName, discID, comID, QUESTION)
+	if err != nil {
+		Log.Errorf("Accepting answer '%d' to question '%d' of '%s%s%s' failed: %v", comID, discID, owner, folder,
+			fileName, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		errMsg := fmt.Sprintf("Wrong number of rows (%v) affected when accepting answer '%d' to question '%d' of "+
+			"'%s%s%s'", numRows, comID, discID, owner, folder, fileName)
+		Log.Error(errMsg)
+		return errors.New(errMsg)
+	}
+	return nil
+}
+
+// Runs one of the activity stats queries, returning its rows.  The description is used in the error message.
+func activityList(dbQuery string, desc string) (list []ActivityRow, err error) {
+	rows, err := pdb.Query(dbQuery)
+	if err != nil {
+		Log.Errorf("Database query failed: %v", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var oneRow ActivityRow
+		err = rows.Scan(&oneRow.Owner, &oneRow.DBName, &oneRow.Count)
+		if err != nil {
+			Log.Errorf("Error retrieving list of %s: %v", desc, err)
+			return
+		}
+		list = append(list, oneRow)
+	}
+	return
+}
+
+// Moves a project to the user a transfer was requested for.  The project keeps its ID, so its history, stars, watchers,
+// and discussions move with it, and its old location redirects to the new one.  The GitHub syncing, GitHub mirroring,
+// and regeneration set up by the old owner are removed, as they use the old owner's details.
+func AcceptProjectTransfer(userName string, id int64) (oldOwner string, folder string, fileName string, err error) {
+	tx, err := pdb.Begin()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	// Make sure the transfer is for this user, and lock the project while it's moved
+	dbQuery := `
+		SELECT db.user_id, own.user_name, db.folder, db.db_name, tran.to_user_id
+		FROM project_transfers AS tran
+			JOIN sqlite_databases AS db ON db.db_id = tran.db_id
+			JOIN users AS own ON own.user_id = db.user_id
+		WHERE tran.db_id = $1
+			AND tran.to_user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($2)
+			)
+			AND db.is_deleted = false
+		FOR UPDATE OF db`
+	var oldID, newID int64
+	err = tx.QueryRow(dbQuery, id, userName).Scan(&oldID, &oldOwner, &folder, &fileName, &newID)
+	if err == pgx.ErrNoRows {
+		return "", "", "", errors.New("That project transfer doesn't exist")
+	}
+	if err != nil {
+		Log.Errorf("Looking up the transfer of project %d to '%s' failed: %v", id, userName, err)
+		return
+	}
+
+	// The new owner can't already have a project with the same name
+	dbQuery = `
+		SELECT count(*)
+		FROM sqlite_databases
+		WHERE user_id = $1
+			AND folder = $2
+			AND db_name = $3`
+	var clash int
+	err = tx.QueryRow(dbQuery, newID, folder, fileName).Scan(&clash)
+	if err != nil {
+		Log.Errorf("Checking if '%s' already has a project called '%s' failed: %v", userName, fileName, err)
+		return
+	}
+	if clash != 0 {
+		return "", "", "", fmt.Errorf("You already have a project called '%s'.  Please rename it first", fileName)
+	}
+
+	// Give the new owner a copy of any custom licences the project's versions use
+	dbQuery = `
+		INSERT INTO database_licences (lic_sha256, friendly_name, user_id, licence_url, licence_text, display_order,
+			full_name, file_format)
+		SELECT lic.lic_sha256, lic.friendly_name, $2, lic.licence_url, lic.licence_text, lic.display_order,
+			lic.full_name, lic.file_format
+		FROM database_licences AS lic
+		WHERE lic.user_id = $1
+			AND lic.lic_sha256 IN (
+				SELECT entry->>'licence'
+				FROM sqlite_databases AS db, jsonb_each(db.commit_list) AS c(id, commit),
+					jsonb_array_elements(c.commit->'tree'->'entries') AS entry
+				WHERE db.db_id = $3
+			)
+		ON CONFLICT DO NOTHING`
+	_, err = tx.Exec(dbQuery, oldID, newID, id)
+	if err != nil {
+		Log.Errorf("Copying the licences of project %d to '%s' failed: %v", id, userName, err)
+		return
+	}
+
+	// Redirect the old location to the project, then move it
+	dbQuery = `
+		INSERT INTO project_redirects (user_id, folder, db_name, db_id)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id, folder, db_name)
+			DO UPDATE
+			SET db_id = excluded.db_id, date_created = now()`
+	_, err = tx.Exec(dbQuery, oldID, folder, fileName, id)
+	if err != nil {
+		Log.Errorf("Adding the redirect for transferred project %d failed: %v", id, err)
+		return
+	}
+	dbQuery = `
+		DELETE FROM project_redirects
+		WHERE user_id = $1
+			AND folder = $2
+			AND db_name = $3`
+	_, err = tx.Exec(dbQuery, newID, folder, fileName)
+	if err != nil {
+		Log.Errorf("Removing the old redirect for transferred project %d failed: %v", id, err)
+		return
+	}
+	dbQuery = `
+		UPDATE sqlite_databases
+		SET user_id = $2
+		WHERE db_id = $1`
+	commandTag, err := tx.Exec(dbQuery, id, newID)
+	if err != nil {
+		Log.Errorf("Transferring project %d to '%s' failed: %v", id, userName, err)
+		return
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		errMsg := fmt.Sprintf("Wrong number of rows (%v) affected when transferring project %d to '%s'", numRows,
+			id, userName)
+		Log.Error(errMsg)
+		return "", "", "", errors.New(errMsg)
+	}
+	for _, table := range []string{"github_import_files", "github_mirrors", "guest_upload_links", "regeneration_hooks",
+		"project_transfers"} {
+		_, err = tx.Exec(`DELETE FROM `+table+` WHERE db_id = $1`, id)
+		if err != nil {
+			Log.Errorf("Removing the %s entries for transferred project %d failed: %v", table, id, err)
+			return
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return
+	}
+
+	// Log the transfer
+	Log.Infof("Project '%s%s%s' transferred to '%s'", oldOwner, folder, fileName, userName)
+	return
+}
+
+// Adds a global webhook, which is told about the given events happening anywhere on the site.
+func AddAdminWebhook(adminUser string, hookURL string, events []string, secret string) (id int64, err error) {
+	dbQuery := `
+		INSERT INTO admin_webhooks (url, events, secret, added_by)
+		VALUES ($1, $2, $3, $4)
+		RETURNING hook_id`
+	err = pdb.QueryRow(dbQuery, hookURL, events, secret, adminUser).Scan(&id)
+	if err != nil {
+		Log.Errorf("Adding global webhook '%s' failed: %v", hookURL, err)
+	}
+	return
+}
+
+// Adds a site-wide announcement, shown at the top of every page between its start and end times.  A zero end time
+// means the announcement stays up until it's removed.
+func AddAnnouncement(adminUser string, message string, start time.Time, end time.Time) error {
+	var endTime pgx.NullTime
+	if !end.IsZero() {
+		endTime.Time = end
+		endTime.Valid = true
+	}
+	dbQuery := `
+		INSERT INTO announcements (message, start_time, end_time, created_by)
+		VALUES ($1, $2, $3, $4)`
+	commandTag, err := pdb.Exec(dbQuery, message, start, endTime, adminUser)
+	if err != nil {
+		Log.Errorf("Adding announcement failed: %v", err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		Log.Warnf("Wrong number of rows (%v) affected when adding announcement", numRows)
+	}
+	return nil
+}
+
+// Adds an entry to the admin audit log.  Every action taken by a site administrator should be recorded here.
+func AddAuditLogEntry(adminUser string, action string, target string, details string) error {
+	dbQuery := `
+		INSERT INTO admin_audit_log (admin_user, action, target, details)
+		VALUES ($1, $2, $3, $4)`
+	commandTag, err := pdb.Exec(dbQuery, adminUser, action, target, details)
+	if err != nil {
+		Log.Errorf("Adding audit log entry failed. Admin: '%s', action: '%s', target: '%s'. Error: %v",
+			adminUser, action, target, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		Log.Warnf("Wrong number of rows (%v) affected when adding audit log entry", numRows)
+	}
+	return nil
+}
+
+// Adds a backup run to the list of backups, returning its ID.
+func AddBackupRun() (id int64, err error) {
+	dbQuery := `
+		INSERT INTO backup_runs DEFAULT VALUES
+		RETURNING run_id`
+	err = pdb.QueryRow(dbQuery).Scan(&id)
+	if err != nil {
+		Log.Errorf("Adding a backup run failed: %v", err)
+	}
+	return
+}
+
+// Adds a new category to the category tree.  A parent ID of 0 adds a top level category.
+func AddCategory(parentID int64, name string) error {
+	var parent pgx.NullInt64
+	if parentID != 0 {
+		parent.Int64 = parentID
+		parent.Valid = true
+	}
+	dbQuery := `
+		INSERT INTO categories (parent_id, cat_name, slug)
+		VALUES ($1, $2, $3)`
+	commandTag, err := pdb.Exec(dbQuery, parent, name, CategorySlug(name))
+	if err != nil {
+		Log.Errorf("Adding category '%s' (parent ID %d) failed: %v", name, parentID, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		Log.Warnf("Wrong number of rows (%v) affected when adding category '%s'", numRows, name)
+	}
+	return nil
+}
+
+// Adds a request from a user for an export of all of their data, returning its ID.
+func AddDataExport(userName string) (id int64, err error) {
+	dbQuery := `
+		INSERT INTO data_exports (user_id)
+		SELECT user_id
+		FROM users
+		WHERE lower(user_name) = lower($1)
+		RETURNING export_id`
+	err = pdb.QueryRow(dbQuery, userName).Scan(&id)
+	if err != nil {
+		Log.Errorf("Adding a data export for '%s' failed: %v", userName, err)
+	}
+	return
+}
+
 // Add the default user to the system, used so the referential integrity of licence user_id 0 works.
 func AddDefaultUser() error {
 	// Add the new user to the database
@@ -38,26 +380,174 @@ func AddDefaultUser() error {
 	}
 
 	// Log addition of the default user
-	log.Println("Added default user to the database")
+	Log.Info("Added default user to the database")
+
+	return nil
+}
+
+// Adds a word (or pattern of words) to the word filter.
+func AddFilterWord(adminUser string, pattern string) error {
+	dbQuery := `
+		INSERT INTO filter_words (pattern, added_by)
+		VALUES (lower($1), $2)
+		ON CONFLICT (pattern)
+			DO UPDATE
+			SET added_by = excluded.added_by, date_added = now()`
+	_, err := pdb.Exec(dbQuery, pattern, adminUser)
+	if err != nil {
+		Log.Errorf("Adding '%s' to the word filter failed: %v", pattern, err)
+	}
+	return err
+}
+
+// Adds an IP address or network to the allow or block list.  The action is either "allow" or "block".
+func AddIPRule(adminUser string, network string, action string, reason string) error {
+	dbQuery := `
+		INSERT INTO ip_rules (network, action, reason, created_by)
+		VALUES ($1, $2, $3, $4)`
+	commandTag, err := pdb.Exec(dbQuery, network, action, reason, adminUser)
+	if err != nil {
+		Log.Errorf("Adding IP rule for '%s' failed: %v", network, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		Log.Warnf("Wrong number of rows (%v) affected when adding IP rule for '%s'", numRows, network)
+	}
+	return nil
+}
+
+// Records a report about a project, for the moderators to look at.
+func AddProjectReport(reporter string, owner string, folder string, fileName string, reason string) error {
+	dbQuery := `
+		INSERT INTO project_reports (db_id, reporter_id, reason)
+		SELECT db.db_id, (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			), $5
+		FROM sqlite_databases AS db
+		WHERE db.user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($2)
+			)
+			AND db.folder = $3
+			AND db.db_name = $4
+			AND db.is_deleted = false`
+	commandTag, err := pdb.Exec(dbQuery, reporter, owner, folder, fileName, reason)
+	if err != nil {
+		Log.Errorf("Adding report for project '%s%s%s' failed: %v", owner, folder, fileName, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		return fmt.Errorf("Wrong number of rows (%v) affected when reporting project '%s%s%s'", numRows, owner,
+			folder, fileName)
+	}
+	return nil
+}
 
+// Records a project here, or a file elsewhere, which a project was remixed from.  Projects can have up to maxSources
+// of them.
+func AddRemixSource(owner string, folder string, fileName string, src RemixSource, maxSources int) error {
+	dbQuery := `
+		WITH proj AS (
+			SELECT db_id
+			FROM sqlite_databases
+			WHERE user_id = (
+					SELECT user_id
+					FROM users
+					WHERE lower(user_name) = lower($1)
+				)
+				AND folder = $2
+				AND db_name = $3
+				AND is_deleted = false
+		)
+		INSERT INTO project_remix_sources (db_id, source_db_id, source_url, source_licence)
+		SELECT proj.db_id, (
+				SELECT db_id
+				FROM sqlite_databases
+				WHERE user_id = (
+						SELECT user_id
+						FROM users
+						WHERE lower(user_name) = lower($4)
+					)
+					AND folder = $5
+					AND db_name = $6
+					AND is_deleted = false
+			), nullif($7, ''), nullif($8, '')
+		FROM proj
+		WHERE (SELECT count(*) FROM project_remix_sources WHERE db_id = proj.db_id) < $9`
+	commandTag, err := pdb.Exec(dbQuery, owner, folder, fileName, src.Owner, src.Folder, src.FileName, src.URL,
+		src.Licence, maxSources)
+	if err != nil {
+		Log.Errorf("Adding a remix source for '%s%s%s' failed: %v", owner, folder, fileName, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		return fmt.Errorf("Projects can't have more than %d sources", maxSources)
+	}
 	return nil
 }
 
+// Reserves a username, or a pattern of usernames, so new users can't choose it.
+func AddReservedUsername(adminUser string, pattern string, reason string) error {
+	dbQuery := `
+		INSERT INTO reserved_usernames (pattern, reason, added_by)
+		VALUES (lower($1), $2, $3)
+		ON CONFLICT (pattern)
+			DO UPDATE
+			SET reason = excluded.reason, added_by = excluded.added_by, date_added = now()`
+	_, err := pdb.Exec(dbQuery, pattern, reason, adminUser)
+	if err != nil {
+		Log.Errorf("Reserving the username pattern '%s' failed: %v", pattern, err)
+	}
+	return err
+}
+
+// Adds a short URL for a project, or a specific version of it.  Returns false if the code is already in use, or the
+// project version already has a short URL.
+func AddShortURL(code string, owner string, folder string, fileName string, commitID string,
+	creator string) (added bool, err error) {
+	dbQuery := `
+		INSERT INTO short_urls (code, db_id, commit_id, created_by)
+		SELECT $1, db_id, $5, (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($6)
+			)
+		FROM sqlite_databases
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($2)
+			)
+			AND folder = $3
+			AND db_name = $4
+			AND is_deleted = false
+		ON CONFLICT DO NOTHING`
+	commandTag, err := pdb.Exec(dbQuery, code, owner, folder, fileName, commitID, creator)
+	if err != nil {
+		Log.Errorf("Adding a short URL for '%s%s%s' failed: %v", owner, folder, fileName, err)
+		return
+	}
+	return commandTag.RowsAffected() == 1, nil
+}
+
 // Add a user to the system.
 func AddUser(auth0ID string, userName string, password string, email string, displayName string, avatarURL string) error {
 	// Hash the user's password
 	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
-		log.Printf("Failed to hash user password. User: '%v', error: %v.\n", userName, err)
+		Log.Errorf("Failed to hash user password. User: '%v', error: %v.", userName, err)
 		return err
 	}
 
 	// Generate a new HTTPS client certificate for the user
 	var cert []byte
-	if Conf.Sign.Enabled {
+	if Conf().Sign.Enabled {
 		cert, err = GenerateClientCert(userName)
 		if err != nil {
-			log.Printf("Error when generating client certificate for '%s': %v\n", userName, err)
+			Log.Errorf("Error when generating client certificate for '%s': %v", userName, err)
 			return err
 		}
 	}
@@ -75,41 +565,419 @@ func AddUser(auth0ID string, userName string, password string, email string, dis
 
 	// Add the new user to the database
 	insertQuery := `
-		INSERT INTO users (auth0_id, user_name, email, password_hash, client_cert, display_name, avatar_url)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)`
+		INSERT INTO users (auth0_id, user_name, email, password_hash, client_cert, display_name, avatar_url,
+			auth0_email)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, nullif($3, ''))`
 	commandTag, err := pdb.Exec(insertQuery, auth0ID, userName, email, hash, cert, dn, av)
 	if err != nil {
-		log.Printf("Adding user to database failed: %v\n", err)
+		Log.Errorf("Adding user to database failed: %v", err)
 		return err
 	}
 	if numRows := commandTag.RowsAffected(); numRows != 1 {
-		log.Printf("Wrong number of rows affected when creating user: %v, username: %v\n", numRows, userName)
+		Log.Warnf("Wrong number of rows affected when creating user: %v, username: %v", numRows, userName)
 	}
 
 	// Log the user registration
-	log.Printf("User registered: '%s' Email: '%s'\n", userName, email)
+	Log.Infof("User registered: '%s' Email: '%s'", userName, email)
 
 	return nil
 }
 
-// Check if a given database ID is available, and return it's folder/name so the caller can determine if it has been
-// renamed.  If an error occurs, the true/false value should be ignored, as only the error value is valid.
-func CheckDBID(loggedInUser string, owner string, dbID int64) (avail bool, folder string, fileName string, err error) {
-	dbQuery := `
-		SELECT folder, db_name
-		FROM sqlite_databases
-		WHERE user_id = (
-				SELECT user_id
-				FROM users
-				WHERE lower(user_name) = lower($1)
-			)
-			AND db_id = $2
+// Carries out an action on a user account for a site administrator, recording it in the audit log in the same
+// transaction.  If either part fails, neither is kept.
+func AdminUserAction(adminUser string, action string, userName string, details string) error {
+	var dbQuery string
+	switch action {
+	case "suspend":
+		dbQuery = `
+			UPDATE users
+			SET suspended = true, sessions_revoked_at = now()
+			WHERE lower(user_name) = lower($1)`
+	case "unsuspend":
+		dbQuery = `
+			UPDATE users
+			SET suspended = false
+			WHERE lower(user_name) = lower($1)`
+	case "resetquota":
+		dbQuery = `
+			UPDATE users
+			SET quota_bytes_used = 0, quota_period_start = now()
+			WHERE lower(user_name) = lower($1)`
+	case "logout":
+		dbQuery = `
+			UPDATE users
+			SET sessions_revoked_at = now()
+			WHERE lower(user_name) = lower($1)`
+	case "delete":
+		dbQuery = `
+			DELETE FROM users
+			WHERE lower(user_name) = lower($1)`
+	default:
+		return fmt.Errorf("Unknown user account action '%s'", action)
+	}
+
+	tx, err := pdb.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	commandTag, err := tx.Exec(dbQuery, userName)
+	if err != nil {
+		Log.Errorf("The '%s' action failed for user '%s'. Error: %v", action, userName, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		return fmt.Errorf("Wrong number of rows (%v) affected by the '%s' action on user '%s'", numRows, action,
+			userName)
+	}
+	dbQuery = `
+		INSERT INTO admin_audit_log (admin_user, action, target, details)
+		VALUES ($1, $2, $3, $4)`
+	_, err = tx.Exec(dbQuery, adminUser, action, userName, details)
+	if err != nil {
+		Log.Errorf("Adding audit log entry failed. Admin: '%s', action: '%s', target: '%s'. Error: %v",
+			adminUser, action, userName, err)
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	// Forget the cached account status, so the change applies to the user's existing sessions too
+	return InvalidateCachedData(accountStatusCacheKey(userName))
+}
+
+// Returns the list of user accounts, for the admin user management page.
+func AdminUserList() (list []AdminUserEntry, err error) {
+	dbQuery := `
+		SELECT u.user_name, coalesce(u.display_name, ''), coalesce(u.email, ''), u.date_joined, u.suspended,
+			CASE WHEN u.quota_period_start < now() - interval '1 day' THEN 0 ELSE u.quota_bytes_used END,
+			(SELECT count(*)
+			FROM sqlite_databases AS db
+			WHERE db.user_id = u.user_id
+				AND db.is_deleted = false)
+		FROM users AS u
+		WHERE u.user_name != 'default'
+		ORDER BY lower(u.user_name)`
+	rows, err := pdb.Query(dbQuery)
+	if err != nil {
+		Log.Errorf("Database query failed: %v", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var oneRow AdminUserEntry
+		err = rows.Scan(&oneRow.UserName, &oneRow.DisplayName, &oneRow.Email, &oneRow.DateJoined, &oneRow.Suspended,
+			&oneRow.QuotaUsed, &oneRow.NumProjects)
+		if err != nil {
+			Log.Errorf("Error retrieving user list: %v", err)
+			return
+		}
+		list = append(list, oneRow)
+	}
+	return
+}
+
+// Returns the details of a global webhook.
+func AdminWebhook(id int64) (hook AdminWebhookEntry, found bool, err error) {
+	dbQuery := `
+		SELECT hook_id, url, events, secret, added_by, date_added, last_sent, coalesce(last_error, '')
+		FROM admin_webhooks
+		WHERE hook_id = $1`
+	var lastSent pgx.NullTime
+	err = pdb.QueryRow(dbQuery, id).Scan(&hook.ID, &hook.URL, &hook.Events, &hook.Secret, &hook.AddedBy,
+		&hook.DateAdded, &lastSent, &hook.LastError)
+	if err == pgx.ErrNoRows {
+		return hook, false, nil
+	}
+	if err != nil {
+		Log.Errorf("Retrieving global webhook %d failed: %v", id, err)
+		return
+	}
+	if lastSent.Valid {
+		hook.LastSent = lastSent.Time
+	}
+	return hook, true, nil
+}
+
+// Returns the global webhooks which are told about an event, or all of them if no event is given.
+func AdminWebhooks(event string) (list []AdminWebhookEntry, err error) {
+	dbQuery := `
+		SELECT hook_id, url, events, secret, added_by, date_added, last_sent, coalesce(last_error, '')
+		FROM admin_webhooks
+		WHERE $1 = ''
+			OR $1 = ANY(events)
+		ORDER BY hook_id`
+	rows, err := pdb.Query(dbQuery, event)
+	if err != nil {
+		Log.Errorf("Database query failed: %v", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var oneRow AdminWebhookEntry
+		var lastSent pgx.NullTime
+		err = rows.Scan(&oneRow.ID, &oneRow.URL, &oneRow.Events, &oneRow.Secret, &oneRow.AddedBy,
+			&oneRow.DateAdded, &lastSent, &oneRow.LastError)
+		if err != nil {
+			Log.Errorf("Error retrieving global webhooks: %v", err)
+			return
+		}
+		if lastSent.Valid {
+			oneRow.LastSent = lastSent.Time
+		}
+		list = append(list, oneRow)
+	}
+	return
+}
+
+// Returns the sha256 of every file referenced by any commit of any project, for working out which objects in Minio
+// need to be included in backups.
+func AllDatabaseFiles() (list []string, err error) {
+	dbQuery := `
+		SELECT DISTINCT entry->>'sha256'
+		FROM sqlite_databases AS db, jsonb_each(db.commit_list) AS c,
+			jsonb_array_elements(c.value->'tree'->'entries') AS entry
+		WHERE coalesce(entry->>'sha256', '') != ''
+		ORDER BY 1`
+	rows, err := pdb.Query(dbQuery)
+	if err != nil {
+		Log.Errorf("Database query failed: %v", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var sha string
+		err = rows.Scan(&sha)
+		if err != nil {
+			Log.Errorf("Error retrieving database file list: %v", err)
+			return
+		}
+		list = append(list, sha)
+	}
+	return
+}
+
+// Returns every project which hasn't been deleted, for jobs which need to work through all of them.
+func AllProjects() (list []ProjectRef, err error) {
+	dbQuery := `
+		SELECT u.user_name, db.folder, db.db_name
+		FROM sqlite_databases AS db, users AS u
+		WHERE db.user_id = u.user_id
+			AND db.is_deleted = false
+		ORDER BY u.user_name, db.folder, db.db_name`
+	rows, err := pdb.Query(dbQuery)
+	if err != nil {
+		Log.Errorf("Database query failed: %v", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var oneRow ProjectRef
+		err = rows.Scan(&oneRow.Owner, &oneRow.Folder, &oneRow.DBName)
+		if err != nil {
+			Log.Errorf("Error retrieving project list: %v", err)
+			return
+		}
+		list = append(list, oneRow)
+	}
+	return
+}
+
+// Returns the site-wide announcements which are either showing now, or scheduled to in future, ordered by start time.
+func Announcements() (list []Announcement, err error) {
+	dbQuery := `
+		SELECT announcement_id, message, start_time, end_time, created_by
+		FROM announcements
+		WHERE end_time IS NULL
+			OR end_time > now()
+		ORDER BY start_time, announcement_id`
+	rows, err := pdb.Query(dbQuery)
+	if err != nil {
+		Log.Errorf("Database query failed: %v", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var oneRow Announcement
+		var end pgx.NullTime
+		err = rows.Scan(&oneRow.ID, &oneRow.Message, &oneRow.Start, &end, &oneRow.CreatedBy)
+		if err != nil {
+			Log.Errorf("Error retrieving announcements: %v", err)
+			return
+		}
+		if end.Valid {
+			oneRow.End = end.Time
+		}
+		list = append(list, oneRow)
+	}
+	return
+}
+
+// Returns the most recent entries from the admin audit log, newest first.
+func AuditLog(limit int) (list []AuditLogEntry, err error) {
+	dbQuery := `
+		SELECT log_id, event_timestamp, admin_user, action, target, coalesce(details, '')
+		FROM admin_audit_log
+		ORDER BY event_timestamp DESC
+		LIMIT $1`
+	rows, err := pdb.Query(dbQuery, limit)
+	if err != nil {
+		Log.Errorf("Database query failed: %v", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var oneRow AuditLogEntry
+		err = rows.Scan(&oneRow.ID, &oneRow.Timestamp, &oneRow.AdminUser, &oneRow.Action, &oneRow.Target,
+			&oneRow.Details)
+		if err != nil {
+			Log.Errorf("Error retrieving audit log: %v", err)
+			return
+		}
+		list = append(list, oneRow)
+	}
+	return
+}
+
+// Returns the most recent backup runs, newest first.
+func BackupRuns(limit int) (list []BackupRun, err error) {
+	dbQuery := `
+		SELECT run_id, date_queued, date_started, date_finished, success, coalesce(object_name, ''),
+			coalesce(size, 0), coalesce(num_objects, 0), coalesce(last_error, '')
+		FROM backup_runs
+		ORDER BY run_id DESC
+		LIMIT $1`
+	rows, err := pdb.Query(dbQuery, limit)
+	if err != nil {
+		Log.Errorf("Database query failed: %v", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var oneRow BackupRun
+		var started, finished pgx.NullTime
+		err = rows.Scan(&oneRow.ID, &oneRow.DateQueued, &started, &finished, &oneRow.Success, &oneRow.ObjectName,
+			&oneRow.Size, &oneRow.NumObjects, &oneRow.Error)
+		if err != nil {
+			Log.Errorf("Error retrieving backup runs: %v", err)
+			return
+		}
+		if started.Valid {
+			oneRow.DateStarted = started.Time
+		}
+		if finished.Valid {
+			oneRow.DateFinished = finished.Time
+		}
+		list = append(list, oneRow)
+	}
+	return
+}
+
+// Cancels a user's change of email address, if one is waiting to be confirmed.
+func CancelEmailChange(userName string) error {
+	dbQuery := `
+		DELETE FROM email_changes
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)`
+	_, err := pdb.Exec(dbQuery, userName)
+	if err != nil {
+		Log.Errorf("Cancelling the email change for user '%s' failed: %v", userName, err)
+	}
+	return err
+}
+
+// Cancels the requested transfer of a project, if there is one.
+func CancelProjectTransfer(owner string, folder string, fileName string) error {
+	dbQuery := `
+		DELETE FROM project_transfers
+		WHERE db_id = (
+				SELECT db_id
+				FROM sqlite_databases
+				WHERE user_id = (
+						SELECT user_id
+						FROM users
+						WHERE lower(user_name) = lower($1)
+					)
+					AND folder = $2
+					AND db_name = $3
+					AND is_deleted = false
+			)`
+	_, err := pdb.Exec(dbQuery, owner, folder, fileName)
+	if err != nil {
+		Log.Errorf("Cancelling the transfer of '%s%s%s' failed: %v", owner, folder, fileName, err)
+	}
+	return err
+}
+
+// Stops the username of an inactive account from being reclaimed.
+func CancelUsernameReclamation(userName string) error {
+	dbQuery := `
+		DELETE FROM username_reclamations
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)`
+	commandTag, err := pdb.Exec(dbQuery, userName)
+	if err != nil {
+		Log.Errorf("Cancelling the reclamation of username '%s' failed: %v", userName, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		Log.Warnf("Wrong number of rows (%v) affected when cancelling the reclamation of username '%s'", numRows,
+			userName)
+	}
+	return nil
+}
+
+// Returns the full category tree, sorted so each category directly follows its parent.
+func Categories() (list []Category, err error) {
+	dbQuery := `
+		SELECT cat_id, coalesce(parent_id, 0), cat_name, slug, path, slug_path, depth
+		FROM ` + categoryTree + ` AS cat
+		ORDER BY slug_path`
+	rows, err := pdb.Query(dbQuery)
+	if err != nil {
+		Log.Errorf("Database query failed: %v", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var oneRow Category
+		err = rows.Scan(&oneRow.ID, &oneRow.ParentID, &oneRow.Name, &oneRow.Slug, &oneRow.Path, &oneRow.SlugPath,
+			&oneRow.Depth)
+		if err != nil {
+			Log.Errorf("Error retrieving category list: %v", err)
+			return
+		}
+		list = append(list, oneRow)
+	}
+	return
+}
+
+// Check if a given database ID is available, and return it's folder/name so the caller can determine if it has been
+// renamed.  If an error occurs, the true/false value should be ignored, as only the error value is valid.
+func CheckDBID(loggedInUser string, owner string, dbID int64) (avail bool, folder string, fileName string, err error) {
+	dbQuery := `
+		SELECT folder, db_name
+		FROM sqlite_databases
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND db_id = $2
 			AND is_deleted = false`
 	// If the request is from someone who's not logged in, or is for another users database, ensure we only consider
 	// public databases
 	if strings.ToLower(loggedInUser) != strings.ToLower(owner) || loggedInUser == "" {
 		dbQuery += `
-			AND public = true`
+			AND ` + publicProject("")
 	}
 	err = pdb.QueryRow(dbQuery, owner, dbID).Scan(&folder, &fileName)
 	if err != nil {
@@ -117,7 +985,7 @@ func CheckDBID(loggedInUser string, owner string, dbID int64) (avail bool, folde
 			avail = false
 			return
 		} else {
-			log.Printf("Checking if a database exists failed: %v\n", err)
+			Log.Errorf("Checking if a database exists failed: %v", err)
 			return
 		}
 	}
@@ -151,7 +1019,7 @@ func CheckDBStarred(loggedInUser string, owner string, folder string, fileName s
 	var starCount int
 	err := pdb.QueryRow(dbQuery, owner, folder, fileName, loggedInUser).Scan(&starCount)
 	if err != nil {
-		log.Printf("Error looking up star count for database. User: '%s' DB: '%s/%s'. Error: %v\n",
+		Log.Errorf("Error looking up star count for database. User: '%s' DB: '%s/%s'. Error: %v",
 			loggedInUser, owner, fileName, err)
 		return true, err
 	}
@@ -188,7 +1056,7 @@ func CheckDBWatched(loggedInUser string, owner string, folder string, fileName s
 	var watchCount int
 	err := pdb.QueryRow(dbQuery, owner, folder, fileName, loggedInUser).Scan(&watchCount)
 	if err != nil {
-		log.Printf("Error looking up watchers count for database. User: '%s' DB: '%s%s%s'. Error: %v\n",
+		Log.Errorf("Error looking up watchers count for database. User: '%s' DB: '%s%s%s'. Error: %v",
 			loggedInUser, owner, folder, fileName, err)
 		return true, err
 	}
@@ -212,7 +1080,7 @@ func CheckEmailExists(email string) (bool, error) {
 	var emailCount int
 	err := pdb.QueryRow(dbQuery, email).Scan(&emailCount)
 	if err != nil {
-		log.Printf("Database query failed: %v\n", err)
+		Log.Errorf("Database query failed: %v", err)
 		return true, err
 	}
 	if emailCount == 0 {
@@ -243,12 +1111,12 @@ func CheckFileExists(loggedInUser string, owner string, folder string, fileName
 	// public databases
 	if strings.ToLower(loggedInUser) != strings.ToLower(owner) || loggedInUser == "" {
 		dbQuery += `
-			AND public = true`
+			AND ` + publicProject("")
 	}
 	var DBCount int
 	err := pdb.QueryRow(dbQuery, owner, folder, fileName).Scan(&DBCount)
 	if err != nil {
-		log.Printf("Checking if a database exists failed: %v\n", err)
+		Log.Errorf("Checking if a database exists failed: %v", err)
 		return true, err
 	}
 	if DBCount == 0 {
@@ -279,7 +1147,7 @@ func CheckLicenceExists(userName string, licenceName string) (exists bool, err e
 	var count int
 	err = pdb.QueryRow(dbQuery, userName, licenceName).Scan(&count)
 	if err != nil {
-		log.Printf("Error checking if licence '%s' exists for user '%s' in database: %v\n", licenceName,
+		Log.Errorf("Error checking if licence '%s' exists for user '%s' in database: %v", licenceName,
 			userName, err)
 		return false, err
 	}
@@ -300,7 +1168,7 @@ func CheckUserExists(userName string) (bool, error) {
 	var userCount int
 	err := pdb.QueryRow(dbQuery, userName).Scan(&userCount)
 	if err != nil {
-		log.Printf("Database query failed: %v\n", err)
+		Log.Errorf("Database query failed: %v", err)
 		return true, err
 	}
 	if userCount == 0 {
@@ -311,6 +1179,38 @@ func CheckUserExists(userName string) (bool, error) {
 	return true, nil
 }
 
+// Takes the next background job which is ready to run, marking it as running by the given worker.  Only jobs of the
+// given types are considered, leaving any other types to the servers which handle them.  Returns false if there are
+// no jobs waiting.
+func ClaimJob(workerID string, jobTypes []string, skipTypes []string) (job JobEntry, found bool, err error) {
+	dbQuery := `
+		UPDATE background_jobs
+		SET status = 'running', attempts = attempts + 1, locked_by = $1, date_updated = now()
+		WHERE job_id = (
+			SELECT job_id
+			FROM background_jobs
+			WHERE status = 'queued'
+				AND run_after <= now()
+				AND job_type = ANY($2)
+				AND job_type <> ALL($3)
+			ORDER BY priority DESC, job_id
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING job_id, job_type, payload::text, priority, attempts, max_attempts, date_created, date_updated`
+	err = pdb.QueryRow(dbQuery, workerID, jobTypes, skipTypes).Scan(&job.ID, &job.Type, &job.Payload, &job.Priority,
+		&job.Attempts, &job.MaxAttempts, &job.DateCreated, &job.DateUpdated)
+	if err == pgx.ErrNoRows {
+		return job, false, nil
+	}
+	if err != nil {
+		Log.Errorf("Claiming a background job failed: %v", err)
+		return
+	}
+	job.Status = "running"
+	return job, true, nil
+}
+
 // Returns the certificate for a given user.
 func ClientCert(userName string) ([]byte, error) {
 	var cert []byte
@@ -319,47 +1219,160 @@ func ClientCert(userName string) ([]byte, error) {
 		FROM users
 		WHERE lower(user_name) = lower($1)`, userName).Scan(&cert)
 	if err != nil {
-		log.Printf("Retrieving client cert for '%s' from database failed: %v\n", userName, err)
+		Log.Errorf("Retrieving client cert for '%s' from database failed: %v", userName, err)
 		return nil, err
 	}
 
 	return cert, nil
 }
 
-// Creates a connection pool to the PostgreSQL server.
-func ConnectPostgreSQL() (err error) {
-	pgPoolConfig := pgx.ConnPoolConfig{*pgConfig, Conf.Pg.NumConnections, nil, 2 * time.Second}
-	pdb, err = pgx.NewConnPool(pgPoolConfig)
+// Confirms a change of email address from one of the links emailed for it.  Once it's been confirmed from both the old
+// and new addresses, the user's email address is changed.  The returned value is true when that's happened.
+func ConfirmEmailChange(token string) (changed bool, err error) {
+	tx, err := pdb.Begin()
 	if err != nil {
-		return errors.New(fmt.Sprintf("Couldn't connect to PostgreSQL server: %v\n", err))
+		return
 	}
+	defer tx.Rollback()
 
-	// Log successful connection
-	log.Printf("Connected to PostgreSQL server: %v:%v\n", Conf.Pg.Server, uint16(Conf.Pg.Port))
-
-	return nil
-}
-
-// Returns the ID number for a given user's database.
-func databaseID(owner string, folder string, fileName string) (dbID int, err error) {
-	// Retrieve the database id
 	dbQuery := `
-		SELECT db_id
-		FROM sqlite_databases
-		WHERE user_id = (
-				SELECT user_id
-				FROM users
-				WHERE lower(user_name) = lower($1))
-			AND folder = $2
-			AND db_name = $3
-			AND is_deleted = false`
-	err = pdb.QueryRow(dbQuery, owner, folder, fileName).Scan(&dbID)
+		UPDATE email_changes
+		SET old_token = CASE WHEN old_token = $1 THEN NULL ELSE old_token END,
+			new_token = CASE WHEN new_token = $1 THEN NULL ELSE new_token END
+		WHERE (old_token = $1 OR new_token = $1)
+			AND expires > now()
+		RETURNING user_id, new_email, old_token IS NULL AND new_token IS NULL`
+	var userID int64
+	var newEmail string
+	var complete bool
+	err = tx.QueryRow(dbQuery, token).Scan(&userID, &newEmail, &complete)
+	if err == pgx.ErrNoRows {
+		return false, errors.New("That confirmation link isn't valid, or has expired")
+	}
 	if err != nil {
-		log.Printf("Error looking up database id. Owner: '%s', Database: '%s'. Error: %v\n", owner, fileName,
-			err)
+		Log.Errorf("Confirming an email change failed: %v", err)
+		return
+	}
+	if !complete {
+		return false, tx.Commit()
 	}
-	return
-}
+
+	// The address may have been taken by someone else since the change was asked for
+	dbQuery = `
+		SELECT count(*)
+		FROM users
+		WHERE lower(email) = lower($1)
+			AND user_id != $2`
+	var clash int
+	err = tx.QueryRow(dbQuery, newEmail, userID).Scan(&clash)
+	if err != nil {
+		Log.Errorf("Checking if email address '%s' is in use failed: %v", newEmail, err)
+		return
+	}
+	if clash != 0 {
+		return false, errors.New("That email address is now used by a different account")
+	}
+	dbQuery = `
+		UPDATE users
+		SET email = $2
+		WHERE user_id = $1`
+	_, err = tx.Exec(dbQuery, userID, newEmail)
+	if err != nil {
+		Log.Errorf("Changing the email address for user ID %d failed: %v", userID, err)
+		return
+	}
+	dbQuery = `
+		DELETE FROM email_changes
+		WHERE user_id = $1`
+	_, err = tx.Exec(dbQuery, userID)
+	if err != nil {
+		Log.Errorf("Removing the completed email change for user ID %d failed: %v", userID, err)
+		return
+	}
+	return true, tx.Commit()
+}
+
+// Queues the email digest for a user, and removes the events it covers from their list of digest items.  When the user
+// has no email address the events are just removed.
+func CompleteDigest(userID int64, lastItemID int64, mailTo string, subject string, body string) error {
+	tx, err := pdb.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if mailTo != "" {
+		dbQuery := `
+			INSERT INTO email_queue (mail_to, subject, body)
+			VALUES ($1, $2, $3)`
+		_, err = tx.Exec(dbQuery, mailTo, subject, body)
+		if err != nil {
+			Log.Errorf("Adding the email digest for user ID %d to the email queue failed: %v", userID, err)
+			return err
+		}
+	}
+	dbQuery := `
+		DELETE FROM digest_items
+		WHERE user_id = $1
+			AND item_id <= $2`
+	_, err = tx.Exec(dbQuery, userID, lastItemID)
+	if err != nil {
+		Log.Errorf("Removing the sent digest items for user ID %d failed: %v", userID, err)
+		return err
+	}
+	return tx.Commit()
+}
+
+// Removes a background job which has finished successfully.
+func CompleteJob(id int64) error {
+	dbQuery := `
+		DELETE FROM background_jobs
+		WHERE job_id = $1`
+	_, err := pdb.Exec(dbQuery, id)
+	if err != nil {
+		Log.Errorf("Removing finished background job '%d' failed: %v", id, err)
+		return err
+	}
+	return nil
+}
+
+// Creates a connection pool to the PostgreSQL server.
+func ConnectPostgreSQL() (err error) {
+	pgPoolConfig := pgx.ConnPoolConfig{*pgConfig, Conf().Pg.NumConnections, nil, Conf().Pg.AcquireTimeout * time.Second}
+	pool, err := pgx.NewConnPool(pgPoolConfig)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Couldn't connect to PostgreSQL server: %v\n", err))
+	}
+	pdb = &pgPool{ConnPool: pool, lastUsed: time.Now().UnixNano()}
+	if Conf().Pg.IdleTimeout > 0 {
+		go pdb.closeIdle(Conf().Pg.IdleTimeout * time.Second)
+	}
+
+	// Log successful connection
+	Log.Infof("Connected to PostgreSQL server: %v:%v", Conf().Pg.Server, uint16(Conf().Pg.Port))
+
+	return nil
+}
+
+// Returns the ID number for a given user's database.
+func databaseID(owner string, folder string, fileName string) (dbID int, err error) {
+	// Retrieve the database id
+	dbQuery := `
+		SELECT db_id
+		FROM sqlite_databases
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1))
+			AND folder = $2
+			AND db_name = $3
+			AND is_deleted = false`
+	err = pdb.QueryRow(dbQuery, owner, folder, fileName).Scan(&dbID)
+	if err != nil {
+		Log.Errorf("Error looking up database id. Owner: '%s', Database: '%s'. Error: %v", owner, fileName,
+			err)
+	}
+	return
+}
 
 // Return a list of 1) users with public databases, 2) along with the logged in users' most recently modified database
 // (including their private one(s)).
@@ -369,7 +1382,7 @@ func DB4SDefaultList(loggedInUser string) (map[string]UserInfo, error) {
 		WITH public_dbs AS (
 			SELECT db_id, last_modified
 			FROM sqlite_databases
-			WHERE public = true
+			WHERE ` + publicProject("") + `
 			AND is_deleted = false
 			ORDER BY last_modified DESC
 		), public_users AS (
@@ -384,7 +1397,7 @@ func DB4SDefaultList(loggedInUser string) (map[string]UserInfo, error) {
 		ORDER BY last_modified DESC`
 	rows, err := pdb.Query(dbQuery)
 	if err != nil {
-		log.Printf("Database query failed: %v\n", err)
+		Log.Errorf("Database query failed: %v", err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -393,7 +1406,7 @@ func DB4SDefaultList(loggedInUser string) (map[string]UserInfo, error) {
 		var oneRow UserInfo
 		err = rows.Scan(&oneRow.Username, &oneRow.LastModified)
 		if err != nil {
-			log.Printf("Error list of users with public databases: %v\n", err)
+			Log.Errorf("Error list of users with public databases: %v", err)
 			return nil, err
 		}
 		list[oneRow.Username] = oneRow
@@ -420,7 +1433,7 @@ func DB4SDefaultList(loggedInUser string) (map[string]UserInfo, error) {
 		FROM most_recent_user_db`
 	rows, err = pdb.Query(dbQuery, loggedInUser)
 	if err != nil {
-		log.Printf("Database query failed: %v\n", err)
+		Log.Errorf("Database query failed: %v", err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -428,7 +1441,7 @@ func DB4SDefaultList(loggedInUser string) (map[string]UserInfo, error) {
 		oneRow := UserInfo{Username: loggedInUser}
 		err = rows.Scan(&oneRow.LastModified)
 		if err != nil {
-			log.Printf("Error retrieving database list for user: %v\n", err)
+			Log.Errorf("Error retrieving database list for user: %v", err)
 			return nil, err
 		}
 		list[oneRow.Username] = oneRow
@@ -453,8 +1466,11 @@ func DBDetails(DB *SQLiteDBinfo, loggedInUser string, owner string, folder strin
 		SELECT db.date_created, db.last_modified, db.watchers, db.stars, db.discussions, db.merge_requests,
 			$4::text AS commit_id, db.commit_list->$4::text->'tree'->'entries'->0 AS db_entry,
 			db.branches, db.release_count, db.contributors, db.one_line_description, db.full_description,
-			db.default_table, db.public, db.source_url, db.tags, db.default_branch
+			db.default_table, db.public, db.source_url, db.tags, db.default_branch, db.project_tags,
+			coalesce(cat.cat_id, 0), coalesce(cat.cat_name, ''), coalesce(cat.path, ''),
+			coalesce(cat.slug_path, ''), db.archived, db.is_draft
 		FROM sqlite_databases AS db
+			LEFT JOIN ` + categoryTree + ` AS cat ON cat.cat_id = db.category_id
 		WHERE db.user_id = (
 				SELECT user_id
 				FROM users
@@ -467,7 +1483,7 @@ func DBDetails(DB *SQLiteDBinfo, loggedInUser string, owner string, folder strin
 	// If the request is for another users database, ensure we only look up public ones
 	if strings.ToLower(loggedInUser) != strings.ToLower(owner) {
 		dbQuery += `
-			AND db.public = true`
+			AND ` + publicProject("db")
 	}
 
 	// Generate a predictable cache key for this functions' metadata.  Probably not sharable with other functions
@@ -477,7 +1493,7 @@ func DBDetails(DB *SQLiteDBinfo, loggedInUser string, owner string, folder strin
 	// Use a cached version of the query response if it exists
 	ok, err := GetCachedData(mdataCacheKey, &DB)
 	if err != nil {
-		log.Printf("Error retrieving data from cache: %v\n", err)
+		Log.Errorf("Error retrieving data from cache: %v", err)
 	}
 	if ok {
 		// Data was in cache, so we use that
@@ -491,10 +1507,12 @@ func DBDetails(DB *SQLiteDBinfo, loggedInUser string, owner string, folder strin
 		&DB.Info.CommitID,
 		&DB.Info.DBEntry,
 		&DB.Info.Branches, &DB.Info.Releases, &DB.Info.Contributors, &oneLineDesc, &fullDesc, &defTable,
-		&DB.Info.Public, &sourceURL, &DB.Info.Tags, &DB.Info.DefaultBranch)
+		&DB.Info.Public, &sourceURL, &DB.Info.Tags, &DB.Info.DefaultBranch, &DB.Info.ProjectTags,
+		&DB.Info.Category.ID, &DB.Info.Category.Name, &DB.Info.Category.Path, &DB.Info.Category.SlugPath,
+		&DB.Info.Archived, &DB.Info.Draft)
 
 	if err != nil {
-		log.Printf("Error when retrieving database details: %v\n", err.Error())
+		Log.Errorf("Error when retrieving database details: %v", err.Error())
 		return errors.New("The requested database doesn't exist")
 	}
 	if !oneLineDesc.Valid {
@@ -538,14 +1556,14 @@ func DBDetails(DB *SQLiteDBinfo, loggedInUser string, owner string, folder strin
 			AND db_name = $3)`
 	err = pdb.QueryRow(dbQuery, owner, folder, fileName).Scan(&DB.Info.Forks)
 	if err != nil {
-		log.Printf("Error retrieving fork count for '%s%s%s': %v\n", owner, folder, fileName, err)
+		Log.Errorf("Error retrieving fork count for '%s%s%s': %v", owner, folder, fileName, err)
 		return err
 	}
 
 	// Cache the database details
-	err = CacheData(mdataCacheKey, DB, Conf.Memcache.DefaultCacheTime)
+	err = CacheData(mdataCacheKey, DB, Conf().Memcache.DefaultCacheTime)
 	if err != nil {
-		log.Printf("Error when caching page data: %v\n", err)
+		Log.Errorf("Error when caching page data: %v", err)
 	}
 
 	return nil
@@ -567,7 +1585,7 @@ func DBStars(owner string, folder string, fileName string) (starCount int, err e
 			AND is_deleted = false`
 	err = pdb.QueryRow(dbQuery, owner, folder, fileName).Scan(&starCount)
 	if err != nil {
-		log.Printf("Error looking up star count for database '%s/%s'. Error: %v\n", owner, fileName, err)
+		Log.Errorf("Error looking up star count for database '%s/%s'. Error: %v", owner, fileName, err)
 		return -1, err
 	}
 	return starCount, nil
@@ -589,13 +1607,121 @@ func DBWatchers(owner string, folder string, fileName string) (watcherCount int,
 			AND is_deleted = false`
 	err = pdb.QueryRow(dbQuery, owner, folder, fileName).Scan(&watcherCount)
 	if err != nil {
-		log.Printf("Error looking up watcher count for database '%s%s%s'. Error: %v\n", owner, folder,
+		Log.Errorf("Error looking up watcher count for database '%s%s%s'. Error: %v", owner, folder,
 			fileName, err)
 		return -1, err
 	}
 	return watcherCount, nil
 }
 
+// Returns the details of a data export.  Exports whose user has since been removed aren't found.
+func DataExport(id int64) (exp DataExportEntry, found bool, err error) {
+	dbQuery := `
+		SELECT exp.export_id, u.user_name, exp.status, exp.date_requested, exp.date_finished, exp.expires,
+			coalesce(exp.object_name, ''), coalesce(exp.size, 0), coalesce(exp.last_error, '')
+		FROM data_exports AS exp
+			JOIN users AS u ON u.user_id = exp.user_id
+		WHERE exp.export_id = $1`
+	var finished, expires pgx.NullTime
+	err = pdb.QueryRow(dbQuery, id).Scan(&exp.ID, &exp.UserName, &exp.Status, &exp.DateRequested, &finished,
+		&expires, &exp.ObjectName, &exp.Size, &exp.Error)
+	if err == pgx.ErrNoRows {
+		return exp, false, nil
+	}
+	if err != nil {
+		Log.Errorf("Retrieving data export %d failed: %v", id, err)
+		return
+	}
+	if finished.Valid {
+		exp.DateFinished = finished.Time
+	}
+	if expires.Valid {
+		exp.Expires = expires.Time
+	}
+	return exp, true, nil
+}
+
+// Returns a user's data exports which haven't expired yet, newest first.
+func DataExports(userName string) (list []DataExportEntry, err error) {
+	dbQuery := `
+		SELECT exp.export_id, u.user_name, exp.status, exp.date_requested, exp.date_finished, exp.expires,
+			coalesce(exp.object_name, ''), coalesce(exp.size, 0), coalesce(exp.last_error, '')
+		FROM data_exports AS exp
+			JOIN users AS u ON u.user_id = exp.user_id
+		WHERE lower(u.user_name) = lower($1)
+			AND (exp.expires IS NULL OR exp.expires > now())
+		ORDER BY exp.export_id DESC`
+	rows, err := pdb.Query(dbQuery, userName)
+	if err != nil {
+		Log.Errorf("Database query failed: %v", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var oneRow DataExportEntry
+		var finished, expires pgx.NullTime
+		err = rows.Scan(&oneRow.ID, &oneRow.UserName, &oneRow.Status, &oneRow.DateRequested, &finished, &expires,
+			&oneRow.ObjectName, &oneRow.Size, &oneRow.Error)
+		if err != nil {
+			Log.Errorf("Error retrieving data exports for '%s': %v", userName, err)
+			return
+		}
+		if finished.Valid {
+			oneRow.DateFinished = finished.Time
+		}
+		if expires.Valid {
+			oneRow.Expires = expires.Time
+		}
+		list = append(list, oneRow)
+	}
+	return
+}
+
+// Returns the background jobs which have failed too many times to be retried, most recent first.
+func DeadJobs() (list []JobEntry, err error) {
+	dbQuery := `
+		SELECT job_id, job_type, payload::text, priority, status, attempts, max_attempts, coalesce(last_error, ''),
+			date_created, date_updated
+		FROM background_jobs
+		WHERE status = 'dead'
+		ORDER BY date_updated DESC
+		LIMIT 100`
+	rows, err := pdb.Query(dbQuery)
+	if err != nil {
+		Log.Errorf("Database query failed: %v", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var oneRow JobEntry
+		err = rows.Scan(&oneRow.ID, &oneRow.Type, &oneRow.Payload, &oneRow.Priority, &oneRow.Status, &oneRow.Attempts,
+			&oneRow.MaxAttempts, &oneRow.LastError, &oneRow.DateCreated, &oneRow.DateUpdated)
+		if err != nil {
+			Log.Errorf("Error retrieving failed background jobs: %v", err)
+			return
+		}
+		list = append(list, oneRow)
+	}
+	return
+}
+
+// Turns down a transfer of a project to the given user.
+func DeclineProjectTransfer(userName string, id int64) error {
+	dbQuery := `
+		DELETE FROM project_transfers
+		WHERE db_id = $1
+			AND to_user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($2)
+			)`
+	_, err := pdb.Exec(dbQuery, id, userName)
+	if err != nil {
+		Log.Errorf("Declining the transfer of project %d to '%s' failed: %v", id, userName, err)
+	}
+	return err
+}
+
 // Retrieve the default commit ID for a specific database
 func DefaultCommit(owner string, folder string, fileName string) (string, error) {
 	// If no commit ID was supplied, we retrieve the latest commit ID from the default branch
@@ -613,12 +1739,65 @@ func DefaultCommit(owner string, folder string, fileName string) (string, error)
 	var commitID string
 	err := pdb.QueryRow(dbQuery, owner, folder, fileName).Scan(&commitID)
 	if err != nil {
-		log.Printf("Error when retrieving head commit ID of default branch: %v\n", err.Error())
+		Log.Errorf("Error when retrieving head commit ID of default branch: %v", err.Error())
 		return "", errors.New("Internal error when looking up database details")
 	}
 	return commitID, nil
 }
 
+// Removes a global webhook.
+func DeleteAdminWebhook(id int64) error {
+	dbQuery := `
+		DELETE FROM admin_webhooks
+		WHERE hook_id = $1`
+	commandTag, err := pdb.Exec(dbQuery, id)
+	if err != nil {
+		Log.Errorf("Removing global webhook %d failed: %v", id, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		return fmt.Errorf("Global webhook %d doesn't exist", id)
+	}
+	return nil
+}
+
+// Removes a site-wide announcement.
+func DeleteAnnouncement(id int64) error {
+	dbQuery := `
+		DELETE FROM announcements
+		WHERE announcement_id = $1`
+	commandTag, err := pdb.Exec(dbQuery, id)
+	if err != nil {
+		Log.Errorf("Deleting announcement '%d' failed: %v", id, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		errMsg := fmt.Sprintf("Wrong number of rows (%v) affected when deleting announcement '%d'", numRows, id)
+		Log.Error(errMsg)
+		return errors.New(errMsg)
+	}
+	return nil
+}
+
+// Removes a category from the category tree, along with all of its sub-categories.  Projects in the removed
+// categories become uncategorised.
+func DeleteCategory(catID int64) error {
+	dbQuery := `
+		DELETE FROM categories
+		WHERE cat_id = $1`
+	commandTag, err := pdb.Exec(dbQuery, catID)
+	if err != nil {
+		Log.Errorf("Deleting category '%d' failed: %v", catID, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		errMsg := fmt.Sprintf("Wrong number of rows (%v) affected when deleting category '%d'", numRows, catID)
+		Log.Error(errMsg)
+		return errors.New(errMsg)
+	}
+	return nil
+}
+
 // Delete a specific comment from a discussion
 func DeleteComment(owner string, folder string, fileName string, discID int, comID int) error {
 	// Begin a transaction
@@ -653,12 +1832,12 @@ func DeleteComment(owner string, folder string, fileName string, discID int, com
 			AND com_id = $5`
 	commandTag, err := tx.Exec(dbQuery, owner, folder, fileName, discID, comID)
 	if err != nil {
-		log.Printf("Deleting comment '%d' from '%s%s%s', discussion '%d' failed: %v\n", comID, owner,
+		Log.Errorf("Deleting comment '%d' from '%s%s%s', discussion '%d' failed: %v", comID, owner,
 			folder, fileName, discID, err)
 		return err
 	}
 	if numRows := commandTag.RowsAffected(); numRows != 1 {
-		log.Printf("Wrong number of rows (%v) affected when deleting comment '%d' from database '%s%s%s, discussion '%d''\n",
+		Log.Warnf("Wrong number of rows (%v) affected when deleting comment '%d' from database '%s%s%s, discussion '%d''",
 			numRows, comID, owner, folder, fileName, discID)
 	}
 
@@ -691,13 +1870,13 @@ func DeleteComment(owner string, folder string, fileName string, discID int, com
 		WHERE internal_id = (SELECT int_id FROM int)`
 	commandTag, err = tx.Exec(dbQuery, owner, folder, fileName, discID)
 	if err != nil {
-		log.Printf("Updating comment count for discussion '%v' of '%s%s%s' in PostgreSQL failed: %v\n",
+		Log.Errorf("Updating comment count for discussion '%v' of '%s%s%s' in PostgreSQL failed: %v",
 			discID, owner, folder, fileName, err)
 		return err
 	}
 	if numRows := commandTag.RowsAffected(); numRows != 1 {
-		log.Printf("Wrong number of rows (%v) affected when updating comment count for discussion '%v' in "+
-			"'%s%s%s'\n", numRows, discID, owner, folder, fileName)
+		Log.Warnf("Wrong number of rows (%v) affected when updating comment count for discussion '%v' in "+
+			"'%s%s%s'", numRows, discID, owner, folder, fileName)
 	}
 
 	// Commit the transaction
@@ -709,6 +1888,18 @@ func DeleteComment(owner string, folder string, fileName string, discID int, com
 	return nil
 }
 
+// Removes a data export from the list of exports.  The archive itself needs removing from Minio separately.
+func DeleteDataExport(id int64) error {
+	dbQuery := `
+		DELETE FROM data_exports
+		WHERE export_id = $1`
+	_, err := pdb.Exec(dbQuery, id)
+	if err != nil {
+		Log.Errorf("Removing data export %d failed: %v", id, err)
+	}
+	return err
+}
+
 // Deletes a database from PostgreSQL.
 func DeleteDatabase(owner string, folder string, fileName string) error {
 	// TODO: At some point we'll need to figure out a garbage collection approach to remove databases from Minio which
@@ -741,7 +1932,7 @@ func DeleteDatabase(owner string, folder string, fileName string) error {
 	var numForks int
 	err = tx.QueryRow(dbQuery, owner, folder, fileName).Scan(&numForks)
 	if err != nil {
-		log.Printf("Retreving fork list failed for database '%s%s%s': %v\n", owner, folder, fileName, err)
+		Log.Errorf("Retreving fork list failed for database '%s%s%s': %v", owner, folder, fileName, err)
 		return err
 	}
 	if numForks == 0 {
@@ -773,12 +1964,12 @@ func DeleteDatabase(owner string, folder string, fileName string) error {
 			WHERE sqlite_databases.db_id = root_db.id`
 		commandTag, err := tx.Exec(dbQuery, owner, folder, fileName)
 		if err != nil {
-			log.Printf("Updating fork count for '%s%s%s' in PostgreSQL failed: %v\n", owner, folder, fileName,
+			Log.Errorf("Updating fork count for '%s%s%s' in PostgreSQL failed: %v", owner, folder, fileName,
 				err)
 			return err
 		}
 		if numRows := commandTag.RowsAffected(); numRows != 1 {
-			log.Printf("Wrong number of rows (%v) affected (spot 1) when updating fork count for database '%s%s%s'\n",
+			Log.Warnf("Wrong number of rows (%v) affected (spot 1) when updating fork count for database '%s%s%s'",
 				numRows, owner, folder, fileName)
 		}
 
@@ -796,13 +1987,13 @@ func DeleteDatabase(owner string, folder string, fileName string) error {
 				AND db_name = $3`
 		commandTag, err = tx.Exec(dbQuery, owner, folder, fileName)
 		if err != nil {
-			log.Printf("Deleting database entry failed for database '%s%s%s': %v\n", owner, folder, fileName,
+			Log.Errorf("Deleting database entry failed for database '%s%s%s': %v", owner, folder, fileName,
 				err)
 			return err
 		}
 		if numRows := commandTag.RowsAffected(); numRows != 1 {
-			log.Printf(
-				"Wrong number of rows (%v) affected when deleting database '%s%s%s'\n", numRows, owner,
+			Log.Warnf(
+				"Wrong number of rows (%v) affected when deleting database '%s%s%s'", numRows, owner,
 				folder, fileName)
 		}
 
@@ -813,7 +2004,7 @@ func DeleteDatabase(owner string, folder string, fileName string) error {
 		}
 
 		// Log the database deletion
-		log.Printf("Database '%s%s%s' deleted\n", owner, folder, fileName)
+		Log.Infof("Database '%s%s%s' deleted", owner, folder, fileName)
 		return nil
 	}
 
@@ -837,7 +2028,7 @@ func DeleteDatabase(owner string, folder string, fileName string) error {
 			)`
 	commandTag, err := tx.Exec(dbQuery, owner, folder, fileName)
 	if err != nil {
-		log.Printf("Deleting (forked) database stars failed for database '%s%s%s': %v\n", owner, folder,
+		Log.Errorf("Deleting (forked) database stars failed for database '%s%s%s': %v", owner, folder,
 			fileName, err)
 		return err
 	}
@@ -859,13 +2050,13 @@ func DeleteDatabase(owner string, folder string, fileName string) error {
 			AND db_name = $3`
 	commandTag, err = tx.Exec(dbQuery, owner, folder, fileName, newName)
 	if err != nil {
-		log.Printf("Deleting (forked) database entry failed for database '%s%s%s': %v\n", owner, folder,
+		Log.Errorf("Deleting (forked) database entry failed for database '%s%s%s': %v", owner, folder,
 			fileName, err)
 		return err
 	}
 	if numRows := commandTag.RowsAffected(); numRows != 1 {
-		log.Printf(
-			"Wrong number of rows (%v) affected when deleting (forked) database '%s%s%s'\n", numRows, owner,
+		Log.Warnf(
+			"Wrong number of rows (%v) affected when deleting (forked) database '%s%s%s'", numRows, owner,
 			folder, fileName)
 	}
 
@@ -893,12 +2084,12 @@ func DeleteDatabase(owner string, folder string, fileName string) error {
 		WHERE sqlite_databases.db_id = root_db.id`
 	commandTag, err = tx.Exec(dbQuery, owner, folder, newName)
 	if err != nil {
-		log.Printf("Updating fork count for '%s%s%s' in PostgreSQL failed: %v\n", owner, folder, fileName,
+		Log.Errorf("Updating fork count for '%s%s%s' in PostgreSQL failed: %v", owner, folder, fileName,
 			err)
 		return err
 	}
 	if numRows := commandTag.RowsAffected(); numRows != 1 {
-		log.Printf("Wrong number of rows (%v) affected (spot 2) when updating fork count for database '%s%s%s'\n",
+		Log.Warnf("Wrong number of rows (%v) affected (spot 2) when updating fork count for database '%s%s%s'",
 			numRows, owner, folder, fileName)
 	}
 
@@ -909,104 +2100,294 @@ func DeleteDatabase(owner string, folder string, fileName string) error {
 	}
 
 	// Log the database deletion
-	log.Printf("(Forked) database '%s%s%s' deleted\n", owner, folder, fileName)
+	Log.Infof("(Forked) database '%s%s%s' deleted", owner, folder, fileName)
 	return nil
 }
 
-// Removes a (user supplied) database licence from the system.
-func DeleteLicence(userName string, licenceName string) (err error) {
-	// Begin a transaction
-	tx, err := pdb.Begin()
+// Removes a word (or pattern of words) from the word filter.
+func DeleteFilterWord(pattern string) error {
+	dbQuery := `
+		DELETE FROM filter_words
+		WHERE pattern = lower($1)`
+	commandTag, err := pdb.Exec(dbQuery, pattern)
 	if err != nil {
+		Log.Errorf("Removing '%s' from the word filter failed: %v", pattern, err)
 		return err
 	}
-	// Set up an automatic transaction roll back if the function exits without committing
-	defer tx.Rollback()
-
-	// Don't allow deletion of the default licences
-	switch licenceName {
-	case "Not specified":
-	case "CC0":
-	case "CC-BY-4.0":
-	case "CC-BY-SA-4.0":
-	case "CC-BY-NC-4.0":
-	case "CC-BY-IGO-3.0":
-	case "ODbL-1.0":
-	case "UK-OGL-3":
-		return errors.New("Default licences can't be removed")
-	}
-
-	// Retrieve the SHA256 for the licence
-	licSHA, err := GetLicenceSha256FromName(userName, licenceName)
-	if err != nil {
-		return err
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		Log.Warnf("Wrong number of rows (%v) affected when removing '%s' from the word filter", numRows, pattern)
 	}
+	return nil
+}
 
-	// * Check if there are databases present which use this licence.  If there are, then abort. *
-
-	// TODO: Get around to adding appropriate GIN indexes
-
-	// Note - This uses the JsQuery extension for PostgreSQL, which needs compiling on the server and adding in.
-	//        However, this seems like it'll be much more straight forward and usable for writing queries with than
-	//        than to use straight PG SQL
-	//        JsQuery repo: https://github.com/postgrespro/jsquery
-	//        Some useful examples: https://postgrespro.ru/media/2017/04/04/jsonb-pgconf.us-2017.pdf
+// Removes a user from the list of flagged usernames, once a moderator has looked at it.
+func DeleteFlaggedUsername(userName string) error {
 	dbQuery := `
-		SELECT DISTINCT count(*)
-		FROM sqlite_databases AS db
-		WHERE db.commit_list @@ '*.licence = %s'
-			AND (user_id = (
-				SELECT user_id
-				FROM users
-				WHERE user_name = 'default'
-			)
-			OR user_id = (
+		DELETE FROM flagged_usernames
+		WHERE user_id = (
 				SELECT user_id
 				FROM users
 				WHERE lower(user_name) = lower($1)
-			))`
-	// We do this because licSHA needs to be unquoted for JsQuery to work, and the Go PG driver mucks things
-	// up if it's given as a parameter to that (eg as $2)
-	dbQuery = fmt.Sprintf(dbQuery, licSHA)
-
-	// The same query in straight PG:
-	//dbQuery := `
-	//	WITH working_set AS (
-	//		SELECT DISTINCT db.db_id
-	//		FROM sqlite_databases AS db
-	//			CROSS JOIN jsonb_each(db.commit_list) AS firstjoin
-	//			CROSS JOIN jsonb_array_elements(firstjoin.value -> 'tree' -> 'entries') AS secondjoin
-	//		WHERE secondjoin ->> 'licence' = $2
-	//			AND (
-	//				user_id = (
-	//					SELECT user_id
-	//					FROM users
-	//					WHERE user_name = 'default'
-	//				)
-	//				OR user_id = (
-	//					SELECT user_id
-	//					FROM users
-	//					WHERE lower(user_name) = lower($1)
-	//				)
-	//			)
-	//	)
-	//	SELECT count(*)
-	//	FROM working_set`
+			)`
+	commandTag, err := pdb.Exec(dbQuery, userName)
+	if err != nil {
+		Log.Errorf("Removing flagged username '%s' failed: %v", userName, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		errMsg := fmt.Sprintf("Wrong number of rows (%v) affected when removing flagged username '%s'", numRows,
+			userName)
+		Log.Error(errMsg)
+		return errors.New(errMsg)
+	}
+	return nil
+}
 
-	var DBCount int
-	err = pdb.QueryRow(dbQuery, userName).Scan(&DBCount)
+// Stops a GitHub repository from being synced.  The projects created from it are left as they are.
+func DeleteGitHubImport(id int64) error {
+	dbQuery := `
+		DELETE FROM github_imports
+		WHERE import_id = $1`
+	commandTag, err := pdb.Exec(dbQuery, id)
 	if err != nil {
-		log.Printf("Checking if the licence is in use failed: %v\n", err)
+		Log.Errorf("Deleting GitHub import '%d' failed: %v", id, err)
 		return err
 	}
-	if DBCount != 0 {
-		// Database isn't in our system
-		return errors.New("Can't delete the licence, as it's already being used by databases")
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		errMsg := fmt.Sprintf("Wrong number of rows (%v) affected when deleting GitHub import '%d'", numRows, id)
+		Log.Error(errMsg)
+		return errors.New(errMsg)
 	}
+	return nil
+}
 
-	// Delete the licence
-	dbQuery = `
-		DELETE FROM database_licences
+// Stops a project from being mirrored to GitHub.
+func DeleteGitHubMirror(owner string, folder string, fileName string) error {
+	dbQuery := `
+		DELETE FROM github_mirrors
+		WHERE db_id = (
+				SELECT db_id
+				FROM sqlite_databases
+				WHERE user_id = (
+						SELECT user_id
+						FROM users
+						WHERE lower(user_name) = lower($1)
+					)
+					AND folder = $2
+					AND db_name = $3
+					AND is_deleted = false
+			)`
+	_, err := pdb.Exec(dbQuery, owner, folder, fileName)
+	if err != nil {
+		Log.Errorf("Removing the GitHub mirror for '%s%s%s' failed: %v", owner, folder, fileName, err)
+	}
+	return err
+}
+
+// Removes a file sent through a guest upload link, once the owner of the project has approved or rejected it.
+func DeleteGuestUpload(owner string, folder string, fileName string, id int64) error {
+	dbQuery := `
+		DELETE FROM guest_uploads
+		WHERE upload_id = $4
+			AND db_id = (
+				SELECT db_id
+				FROM sqlite_databases
+				WHERE user_id = (
+						SELECT user_id
+						FROM users
+						WHERE lower(user_name) = lower($1)
+					)
+					AND folder = $2
+					AND db_name = $3
+					AND is_deleted = false
+			)`
+	commandTag, err := pdb.Exec(dbQuery, owner, folder, fileName, id)
+	if err != nil {
+		Log.Errorf("Removing guest upload %d for '%s%s%s' failed: %v", id, owner, folder, fileName, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		errMsg := fmt.Sprintf("Wrong number of rows (%v) affected when removing guest upload %d for '%s%s%s'",
+			numRows, id, owner, folder, fileName)
+		Log.Error(errMsg)
+		return errors.New(errMsg)
+	}
+	return nil
+}
+
+// Revokes a guest upload link for a project.  Files already sent through it are kept.
+func DeleteGuestUploadLink(owner string, folder string, fileName string, id int64) error {
+	dbQuery := `
+		DELETE FROM guest_upload_links
+		WHERE link_id = $4
+			AND db_id = (
+				SELECT db_id
+				FROM sqlite_databases
+				WHERE user_id = (
+						SELECT user_id
+						FROM users
+						WHERE lower(user_name) = lower($1)
+					)
+					AND folder = $2
+					AND db_name = $3
+					AND is_deleted = false
+			)`
+	_, err := pdb.Exec(dbQuery, owner, folder, fileName, id)
+	if err != nil {
+		Log.Errorf("Revoking guest upload link %d for '%s%s%s' failed: %v", id, owner, folder, fileName, err)
+	}
+	return err
+}
+
+// Removes a comment from the moderation queue, without adding it to its discussion.
+func DeleteHeldComment(id int64) error {
+	dbQuery := `
+		DELETE FROM held_comments
+		WHERE held_id = $1`
+	commandTag, err := pdb.Exec(dbQuery, id)
+	if err != nil {
+		Log.Errorf("Deleting held comment '%d' failed: %v", id, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		errMsg := fmt.Sprintf("Wrong number of rows (%v) affected when deleting held comment '%d'", numRows, id)
+		Log.Error(errMsg)
+		return errors.New(errMsg)
+	}
+	return nil
+}
+
+// Removes an IP address or network from the allow or block list.
+func DeleteIPRule(id int64) error {
+	dbQuery := `
+		DELETE FROM ip_rules
+		WHERE rule_id = $1`
+	commandTag, err := pdb.Exec(dbQuery, id)
+	if err != nil {
+		Log.Errorf("Deleting IP rule '%d' failed: %v", id, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		errMsg := fmt.Sprintf("Wrong number of rows (%v) affected when deleting IP rule '%d'", numRows, id)
+		Log.Error(errMsg)
+		return errors.New(errMsg)
+	}
+	return nil
+}
+
+// Removes a failed background job, without running it again.
+func DeleteJob(id int64) error {
+	dbQuery := `
+		DELETE FROM background_jobs
+		WHERE job_id = $1
+			AND status = 'dead'`
+	commandTag, err := pdb.Exec(dbQuery, id)
+	if err != nil {
+		Log.Errorf("Deleting background job '%d' failed: %v", id, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		errMsg := fmt.Sprintf("Wrong number of rows (%v) affected when deleting background job '%d'", numRows, id)
+		Log.Error(errMsg)
+		return errors.New(errMsg)
+	}
+	return nil
+}
+
+// Removes a (user supplied) database licence from the system.
+func DeleteLicence(userName string, licenceName string) (err error) {
+	// Begin a transaction
+	tx, err := pdb.Begin()
+	if err != nil {
+		return err
+	}
+	// Set up an automatic transaction roll back if the function exits without committing
+	defer tx.Rollback()
+
+	// Don't allow deletion of the default licences
+	switch licenceName {
+	case "Not specified":
+	case "CC0":
+	case "CC-BY-4.0":
+	case "CC-BY-SA-4.0":
+	case "CC-BY-NC-4.0":
+	case "CC-BY-IGO-3.0":
+	case "ODbL-1.0":
+	case "UK-OGL-3":
+		return errors.New("Default licences can't be removed")
+	}
+
+	// Retrieve the SHA256 for the licence
+	licSHA, err := GetLicenceSha256FromName(userName, licenceName)
+	if err != nil {
+		return err
+	}
+
+	// * Check if there are databases present which use this licence.  If there are, then abort. *
+
+	// TODO: Get around to adding appropriate GIN indexes
+
+	// Note - This uses the JsQuery extension for PostgreSQL, which needs compiling on the server and adding in.
+	//        However, this seems like it'll be much more straight forward and usable for writing queries with than
+	//        than to use straight PG SQL
+	//        JsQuery repo: https://github.com/postgrespro/jsquery
+	//        Some useful examples: https://postgrespro.ru/media/2017/04/04/jsonb-pgconf.us-2017.pdf
+	dbQuery := `
+		SELECT DISTINCT count(*)
+		FROM sqlite_databases AS db
+		WHERE db.commit_list @@ '*.licence = %s'
+			AND (user_id = (
+				SELECT user_id
+				FROM users
+				WHERE user_name = 'default'
+			)
+			OR user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			))`
+	// We do this because licSHA needs to be unquoted for JsQuery to work, and the Go PG driver mucks things
+	// up if it's given as a parameter to that (eg as $2)
+	dbQuery = fmt.Sprintf(dbQuery, licSHA)
+
+	// The same query in straight PG:
+	//dbQuery := `
+	//	WITH working_set AS (
+	//		SELECT DISTINCT db.db_id
+	//		FROM sqlite_databases AS db
+	//			CROSS JOIN jsonb_each(db.commit_list) AS firstjoin
+	//			CROSS JOIN jsonb_array_elements(firstjoin.value -> 'tree' -> 'entries') AS secondjoin
+	//		WHERE secondjoin ->> 'licence' = $2
+	//			AND (
+	//				user_id = (
+	//					SELECT user_id
+	//					FROM users
+	//					WHERE user_name = 'default'
+	//				)
+	//				OR user_id = (
+	//					SELECT user_id
+	//					FROM users
+	//					WHERE lower(user_name) = lower($1)
+	//				)
+	//			)
+	//	)
+	//	SELECT count(*)
+	//	FROM working_set`
+
+	var DBCount int
+	err = pdb.QueryRow(dbQuery, userName).Scan(&DBCount)
+	if err != nil {
+		Log.Errorf("Checking if the licence is in use failed: %v", err)
+		return err
+	}
+	if DBCount != 0 {
+		// Database isn't in our system
+		return errors.New("Can't delete the licence, as it's already being used by databases")
+	}
+
+	// Delete the licence
+	dbQuery = `
+		DELETE FROM database_licences
 		WHERE lic_sha256 = $2
 			AND friendly_name = $3
 			AND (user_id = (
@@ -1021,12 +2402,12 @@ func DeleteLicence(userName string, licenceName string) (err error) {
 			))`
 	commandTag, err := tx.Exec(dbQuery, userName, licSHA, licenceName)
 	if err != nil {
-		log.Printf("Error when retrieving sha256 for licence '%s', user '%s' from database: %v\n", licenceName,
+		Log.Errorf("Error when retrieving sha256 for licence '%s', user '%s' from database: %v", licenceName,
 			userName, err)
 		return err
 	}
 	if numRows := commandTag.RowsAffected(); numRows != 1 {
-		log.Printf("Wrong number of rows (%v) affected when deleting licence '%s' for user '%s'\n",
+		Log.Warnf("Wrong number of rows (%v) affected when deleting licence '%s' for user '%s'",
 			numRows, licenceName, userName)
 	}
 
@@ -1039,61 +2420,240 @@ func DeleteLicence(userName string, licenceName string) (err error) {
 	return nil
 }
 
-// Disconnects the PostgreSQL database connection.
-func DisconnectPostgreSQL() {
-	pdb.Close()
-
-	// Log successful disconnection
-	log.Printf("Disconnected from PostgreSQL server: %v:%v\n", Conf.Pg.Server, uint16(Conf.Pg.Port))
+// Removes the OctoPrint instance a user has linked.  It's not an error if they haven't linked one.
+func DeleteOctoPrintLink(userName string) error {
+	dbQuery := `
+		DELETE FROM octoprint_links
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)`
+	_, err := pdb.Exec(dbQuery, userName)
+	if err != nil {
+		Log.Errorf("Removing the OctoPrint link of '%s' failed: %v", userName, err)
+	}
+	return err
 }
 
-// Returns the list of discussions or MRs for a given database.
-// If a non-0 discID value is passed, it will only return the details for that specific discussion/MR.  Otherwise it
-// will return a list of all discussions or MRs for a given database
-// Note - This returns a slice of DiscussionEntry, instead of a map.  We use a slice because it lets us use an ORDER
-//        BY clause in the SQL and preserve the returned order (maps don't preserve order).  If in future we no longer
-//        need to preserve the order, it might be useful to switch to using a map instead since they're often simpler
-//        to work with.
-func Discussions(owner string, folder string, fileName string, discType DiscussionType, discID int) (list []DiscussionEntry, err error) {
+// Removes a project template.  Instance templates belong to the "default" user.
+func DeleteProjectTemplate(userName string, name string) error {
 	dbQuery := `
-		WITH u AS (
-			SELECT user_id
-			FROM users
-			WHERE lower(user_name) = lower($1)
-		), d AS (
-			SELECT db.db_id
-			FROM sqlite_databases AS db, u
-			WHERE db.user_id = u.user_id
-				AND db.folder = $2
-				AND db.db_name = $3)
-		SELECT disc.disc_id, disc.title, disc.open, disc.date_created, users.user_name, users.email, users.avatar_url,
-			disc.description, last_modified, comment_count, mr_source_db_id, mr_source_db_branch,
-			mr_destination_branch, mr_state, mr_commits
-		FROM discussions AS disc, d, users
-		WHERE disc.db_id = d.db_id
-			AND disc.discussion_type = $4
-			AND disc.creator = users.user_id`
-	if discID != 0 {
-		dbQuery += fmt.Sprintf(`
-			AND disc_id = %d`, discID)
+		DELETE FROM project_templates
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND template_name = $2`
+	commandTag, err := pdb.Exec(dbQuery, userName, name)
+	if err != nil {
+		Log.Errorf("Removing project template '%s' of user '%s' failed: %v", name, userName, err)
+		return err
 	}
-	dbQuery += `
-		ORDER BY last_modified DESC`
-	var rows *pgx.Rows
-	rows, err = pdb.Query(dbQuery, owner, folder, fileName, discType)
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		return errors.New("That template doesn't exist")
+	}
+	return nil
+}
+
+// Removes the translation of a project's descriptions and README into a language.
+func DeleteProjectTranslation(owner string, folder string, fileName string, language string) error {
+	dbQuery := `
+		DELETE FROM project_translations
+		WHERE db_id = (
+				SELECT db_id
+				FROM sqlite_databases
+				WHERE user_id = (
+						SELECT user_id
+						FROM users
+						WHERE lower(user_name) = lower($1)
+					)
+					AND folder = $2
+					AND db_name = $3
+					AND is_deleted = false
+			)
+			AND language = $4`
+	_, err := pdb.Exec(dbQuery, owner, folder, fileName, language)
 	if err != nil {
-		log.Printf("Database query failed: %v\n", err)
-		return
+		Log.Errorf("Removing the '%s' translation of '%s%s%s' failed: %v", language, owner, folder, fileName, err)
 	}
-	for rows.Next() {
-		var av, em, sb, db pgx.NullString
-		var sdb pgx.NullInt64
-		var oneRow DiscussionEntry
-		err = rows.Scan(&oneRow.ID, &oneRow.Title, &oneRow.Open, &oneRow.DateCreated, &oneRow.Creator, &em, &av,
-			&oneRow.Body, &oneRow.LastModified, &oneRow.CommentCount, &sdb, &sb, &db, &oneRow.MRDetails.State,
-			&oneRow.MRDetails.Commits)
-		if err != nil {
-			log.Printf("Error retrieving discussion/MR list for database '%s%s%s': %v\n", owner, folder,
+	return err
+}
+
+// Removes the regeneration webhook from a project.
+func DeleteRegenerationHook(owner string, folder string, fileName string) error {
+	dbQuery := `
+		DELETE FROM regeneration_hooks
+		WHERE db_id = (
+				SELECT db_id
+				FROM sqlite_databases
+				WHERE user_id = (
+						SELECT user_id
+						FROM users
+						WHERE lower(user_name) = lower($1)
+					)
+					AND folder = $2
+					AND db_name = $3
+					AND is_deleted = false
+			)`
+	_, err := pdb.Exec(dbQuery, owner, folder, fileName)
+	if err != nil {
+		Log.Errorf("Removing the regeneration webhook for '%s%s%s' failed: %v", owner, folder, fileName, err)
+	}
+	return err
+}
+
+// Removes one of the sources a project declares it was remixed from.
+func DeleteRemixSource(owner string, folder string, fileName string, id int64) error {
+	dbQuery := `
+		DELETE FROM project_remix_sources
+		WHERE source_id = $4
+			AND db_id = (
+				SELECT db_id
+				FROM sqlite_databases
+				WHERE user_id = (
+						SELECT user_id
+						FROM users
+						WHERE lower(user_name) = lower($1)
+					)
+					AND folder = $2
+					AND db_name = $3
+					AND is_deleted = false
+			)`
+	_, err := pdb.Exec(dbQuery, owner, folder, fileName, id)
+	if err != nil {
+		Log.Errorf("Removing remix source %d from '%s%s%s' failed: %v", id, owner, folder, fileName, err)
+	}
+	return err
+}
+
+// Removes a reserved username pattern, so new users can choose names matching it again.
+func DeleteReservedUsername(pattern string) error {
+	dbQuery := `
+		DELETE FROM reserved_usernames
+		WHERE pattern = lower($1)`
+	commandTag, err := pdb.Exec(dbQuery, pattern)
+	if err != nil {
+		Log.Errorf("Removing the reserved username pattern '%s' failed: %v", pattern, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		Log.Warnf("Wrong number of rows (%v) affected when removing the reserved username pattern '%s'", numRows,
+			pattern)
+	}
+	return nil
+}
+
+// Removes a scheduled publication, so the project stays private (or the release unpublished).  Release is empty for
+// the project itself.
+func DeleteScheduledPublication(owner string, folder string, fileName string, release string) error {
+	dbQuery := `
+		DELETE FROM scheduled_publications
+		WHERE db_id = (
+				SELECT db_id
+				FROM sqlite_databases
+				WHERE user_id = (
+						SELECT user_id
+						FROM users
+						WHERE lower(user_name) = lower($1)
+					)
+					AND folder = $2
+					AND db_name = $3
+			)
+			AND release_name = $4`
+	commandTag, err := pdb.Exec(dbQuery, owner, folder, fileName, release)
+	if err != nil {
+		Log.Errorf("Removing the scheduled publication of '%s' for '%s%s%s' failed: %v", release, owner, folder,
+			fileName, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		Log.Warnf("Wrong number of rows (%v) affected when removing the scheduled publication of '%s' for '%s%s%s'",
+			numRows, release, owner, folder, fileName)
+	}
+	return nil
+}
+
+// Removes a page from a project's wiki, along with all of its history.
+func DeleteWikiPage(owner string, folder string, fileName string, pageName string) error {
+	dbQuery := `
+		DELETE FROM project_wiki_revisions
+		WHERE db_id = (
+				SELECT db_id
+				FROM sqlite_databases
+				WHERE user_id = (
+						SELECT user_id
+						FROM users
+						WHERE lower(user_name) = lower($1)
+					)
+					AND folder = $2
+					AND db_name = $3
+					AND is_deleted = false
+			)
+			AND page_name = $4`
+	_, err := pdb.Exec(dbQuery, owner, folder, fileName, pageName)
+	if err != nil {
+		Log.Errorf("Removing wiki page '%s' from '%s%s%s' failed: %v", pageName, owner, folder, fileName, err)
+	}
+	return err
+}
+
+// Disconnects the PostgreSQL database connection.
+func DisconnectPostgreSQL() {
+	pdb.Close()
+
+	// Log successful disconnection
+	Log.Infof("Disconnected from PostgreSQL server: %v:%v", Conf().Pg.Server, uint16(Conf().Pg.Port))
+}
+
+// Returns the list of discussions or MRs for a given database.
+// If a non-0 discID value is passed, it will only return the details for that specific discussion/MR.  Otherwise it
+// will return a list of all discussions or MRs for a given database
+// Note - This returns a slice of DiscussionEntry, instead of a map.  We use a slice because it lets us use an ORDER
+//        BY clause in the SQL and preserve the returned order (maps don't preserve order).  If in future we no longer
+//        need to preserve the order, it might be useful to switch to using a map instead since they're often simpler
+//        to work with.
+func Discussions(owner string, folder string, fileName string, discType DiscussionType, discID int) (list []DiscussionEntry, err error) {
+	dbQuery := `
+		WITH u AS (
+			SELECT user_id
+			FROM users
+			WHERE lower(user_name) = lower($1)
+		), d AS (
+			SELECT db.db_id
+			FROM sqlite_databases AS db, u
+			WHERE db.user_id = u.user_id
+				AND db.folder = $2
+				AND db.db_name = $3)
+		SELECT disc.disc_id, disc.title, disc.open, disc.date_created, users.user_name, users.email, users.avatar_url,
+			disc.description, last_modified, comment_count, mr_source_db_id, mr_source_db_branch,
+			mr_destination_branch, mr_state, mr_commits, coalesce(disc.accepted_answer, 0)
+		FROM discussions AS disc, d, users
+		WHERE disc.db_id = d.db_id
+			AND disc.discussion_type = $4
+			AND disc.creator = users.user_id`
+	if discID != 0 {
+		dbQuery += fmt.Sprintf(`
+			AND disc_id = %d`, discID)
+	}
+	dbQuery += `
+		ORDER BY last_modified DESC`
+	var rows *pgx.Rows
+	rows, err = pdb.Query(dbQuery, owner, folder, fileName, discType)
+	if err != nil {
+		Log.Errorf("Database query failed: %v", err)
+		return
+	}
+	for rows.Next() {
+		var av, em, sb, db pgx.NullString
+		var sdb pgx.NullInt64
+		var oneRow DiscussionEntry
+		err = rows.Scan(&oneRow.ID, &oneRow.Title, &oneRow.Open, &oneRow.DateCreated, &oneRow.Creator, &em, &av,
+			&oneRow.Body, &oneRow.LastModified, &oneRow.CommentCount, &sdb, &sb, &db, &oneRow.MRDetails.State,
+			&oneRow.MRDetails.Commits, &oneRow.AcceptedAnswer)
+		if err != nil {
+			Log.Errorf("Error retrieving discussion/MR list for database '%s%s%s': %v", owner, folder,
 				fileName, err)
 			rows.Close()
 			return
@@ -1132,7 +2692,7 @@ func Discussions(owner string, folder string, fileName string, discType Discussi
 			var o, f, n pgx.NullString
 			err2 := pdb.QueryRow(dbQuery, j.MRDetails.SourceDBID).Scan(&o, &f, &n)
 			if err2 != nil && err2 != pgx.ErrNoRows {
-				log.Printf("Retrieving source database owner/folder/name failed: %v\n", err)
+				Log.Errorf("Retrieving source database owner/folder/name failed: %v", err)
 				return
 			}
 			if o.Valid {
@@ -1189,7 +2749,7 @@ func DiscussionComments(owner string, folder string, fileName string, discID int
 	var rows *pgx.Rows
 	rows, err = pdb.Query(dbQuery, owner, folder, fileName, discID)
 	if err != nil {
-		log.Printf("Database query failed: %v\n", err)
+		Log.Errorf("Database query failed: %v", err)
 		return
 	}
 	for rows.Next() {
@@ -1197,7 +2757,7 @@ func DiscussionComments(owner string, folder string, fileName string, discID int
 		var oneRow DiscussionCommentEntry
 		err = rows.Scan(&oneRow.ID, &oneRow.Commenter, &em, &av, &oneRow.DateCreated, &oneRow.Body, &oneRow.EntryType)
 		if err != nil {
-			log.Printf("Error retrieving comment list for database '%s%s%s', discussion '%d': %v\n", owner,
+			Log.Errorf("Error retrieving comment list for database '%s%s%s', discussion '%d': %v", owner,
 				folder, fileName, discID, err)
 			rows.Close()
 			return
@@ -1219,129 +2779,430 @@ func DiscussionComments(owner string, folder string, fileName string, discID int
 	return
 }
 
-// Periodically flushes the database view count from memcache to PostgreSQL
-func FlushViewCount() {
-	type dbEntry struct {
-		Owner  string
-		Folder string
-		Name   string
+// Returns the users whose daily or weekly email digest is due, and have something to put in it.  Each user is marked
+// as having been sent their digest, so they're only returned once each day or week.  The intervals are a little
+// short of a whole day or week, so the digests don't gradually drift later.
+func DueDigests() (list []int64, err error) {
+	dbQuery := `
+		WITH due AS (
+			UPDATE users
+			SET digest_sent_at = now()
+			WHERE (pref_email_frequency = 'daily'
+					AND coalesce(digest_sent_at, '-infinity') < now() - interval '23 hours')
+				OR (pref_email_frequency = 'weekly'
+					AND coalesce(digest_sent_at, '-infinity') < now() - interval '6 days 23 hours')
+			RETURNING user_id
+		)
+		SELECT user_id
+		FROM due
+		WHERE EXISTS (SELECT 1 FROM digest_items AS i WHERE i.user_id = due.user_id)`
+	rows, err := pdb.Query(dbQuery)
+	if err != nil {
+		Log.Errorf("Retrieving the users due an email digest failed: %v", err)
+		return
 	}
-
-	// Log the start of the loop
-	log.Printf("Periodic view count flushing loop started.  %d second refresh.\n",
-		Conf.Memcache.ViewCountFlushDelay)
-
-	// Start the endless flush loop
-	var rows *pgx.Rows
-	var err error
-	for true {
-		// Retrieve the list of all public databases
-		dbQuery := `
-			SELECT users.user_name, db.folder, db.db_name
-			FROM sqlite_databases AS db, users
-			WHERE db.public = true
-				AND db.is_deleted = false
-				AND db.user_id = users.user_id`
-		rows, err = pdb.Query(dbQuery)
-		if err != nil {
-			log.Printf("Database query failed: %v\n", err)
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			Log.Errorf("Error retrieving the users due an email digest: %v", err)
 			return
 		}
-		var dbList []dbEntry
-		for rows.Next() {
-			var oneRow dbEntry
-			err = rows.Scan(&oneRow.Owner, &oneRow.Folder, &oneRow.Name)
-			if err != nil {
-				log.Printf("Error retrieving database list for view count flush thread: %v\n", err)
-				rows.Close()
-				return
-			}
-			dbList = append(dbList, oneRow)
-		}
-		rows.Close()
-
-		// For each public database, retrieve the latest view count from memcache and save it back to PostgreSQL
-		for _, db := range dbList {
-			owner := db.Owner
-			folder := db.Folder
-			fileName := db.Name
-
-			// Retrieve the view count from Memcached
-			newValue, err := GetViewCount(owner, folder, fileName)
-			if err != nil {
-				log.Printf("Error when getting memcached view count for %s%s%s: %s\n", owner, folder, fileName,
-					err.Error())
-				continue
-			}
+		list = append(list, id)
+	}
+	return
+}
 
-			// We use a value of -1 to indicate there wasn't an entry in memcache for the database
-			if newValue != -1 {
-				// Update the view count in PostgreSQL
-				dbQuery = `
-					UPDATE sqlite_databases
-					SET page_views = $4
-					WHERE user_id = (
-							SELECT user_id
-							FROM users
-							WHERE lower(user_name) = lower($1)
-						)
-						AND folder = $2
-						AND db_name = $3`
-				commandTag, err := pdb.Exec(dbQuery, owner, folder, fileName, newValue)
-				if err != nil {
-					log.Printf("Flushing view count for '%s%s%s' failed: %v\n", owner, folder, fileName, err)
-					continue
-				}
-				if numRows := commandTag.RowsAffected(); numRows != 1 {
-					log.Printf("Wrong number of rows affected (%v) when flushing view count for '%s%s%s'\n",
-						numRows, owner, folder, fileName)
-					continue
-				}
-			}
+// Returns the GitHub imports which are due to be synced on their schedule, marking them as queued so they're only
+// returned once.
+func DueGitHubImports() (list []int64, err error) {
+	dbQuery := `
+		UPDATE github_imports
+		SET last_queued = now()
+		WHERE (schedule = 'hourly' AND coalesce(last_queued, '-infinity') < now() - interval '1 hour')
+			OR (schedule = 'daily' AND coalesce(last_queued, '-infinity') < now() - interval '1 day')
+		RETURNING import_id`
+	rows, err := pdb.Query(dbQuery)
+	if err != nil {
+		Log.Errorf("Retrieving the GitHub imports due to be synced failed: %v", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			Log.Errorf("Error retrieving the GitHub imports due to be synced: %v", err)
+			return
 		}
-
-		// Wait before running the loop again
-		time.Sleep(Conf.Memcache.ViewCountFlushDelay * time.Second)
+		list = append(list, id)
 	}
 	return
 }
 
-// Fork the PostgreSQL entry for a SQLite database from one user to another
-func ForkDatabase(srcOwner string, folder string, fileName string, dstOwner string) (newForkCount int, err error) {
-	// Copy the main database entry
+// Returns the projects and releases whose scheduled publishing time has arrived, taking them off the schedule so
+// they're only published once.
+func DuePublications() (list []ScheduledPublication, err error) {
 	dbQuery := `
-		WITH dst_u AS (
-			SELECT user_id
-			FROM users
-			WHERE lower(user_name) = lower($1)
+		WITH due AS (
+			DELETE FROM scheduled_publications
+			WHERE publish_at <= now()
+			RETURNING db_id, release_name, release_info, publish_at
 		)
-		INSERT INTO sqlite_databases (user_id, folder, db_name, public, forks, one_line_description, full_description,
-			branches, contributors, root_database, default_table, source_url, commit_list, branch_heads, tags,
-			default_branch, forked_from)
-		SELECT dst_u.user_id, folder, db_name, public, 0, one_line_description, full_description, branches,
-			contributors, root_database, default_table, source_url, commit_list, branch_heads, tags, default_branch,
-			db_id
-		FROM sqlite_databases, dst_u
-		WHERE sqlite_databases.user_id = (
-				SELECT user_id
-				FROM users
-				WHERE lower(user_name) = lower($2)
-			)
-			AND folder = $3
-			AND db_name = $4`
-	commandTag, err := pdb.Exec(dbQuery, dstOwner, srcOwner, folder, fileName)
+		SELECT usr.user_name, db.folder, db.db_name, due.release_name, coalesce(due.release_info, '{}'),
+			due.publish_at
+		FROM due
+			JOIN sqlite_databases AS db ON db.db_id = due.db_id
+			JOIN users AS usr ON usr.user_id = db.user_id
+		WHERE db.is_deleted = false`
+	rows, err := pdb.Query(dbQuery)
 	if err != nil {
-		log.Printf("Forking database '%s%s%s' in PostgreSQL failed: %v\n", srcOwner, folder, fileName, err)
-		return 0, err
+		Log.Errorf("Retrieving the publications which are due failed: %v", err)
+		return
 	}
-	if numRows := commandTag.RowsAffected(); numRows != 1 {
-		log.Printf("Wrong number of rows affected (%d) when forking main database entry: "+
-			"'%s%s%s' to '%s%s%s'\n", numRows, srcOwner, folder, fileName, dstOwner, folder, fileName)
+	defer rows.Close()
+	for rows.Next() {
+		var oneRow ScheduledPublication
+		err = rows.Scan(&oneRow.Owner, &oneRow.Folder, &oneRow.FileName, &oneRow.Release, &oneRow.ReleaseInfo,
+			&oneRow.PublishAt)
+		if err != nil {
+			Log.Errorf("Error retrieving the publications which are due: %v", err)
+			return nil, err
+		}
+		list = append(list, oneRow)
 	}
+	return
+}
 
-	// Update the fork count for the root database
-	dbQuery = `
-		WITH root_db AS (
+// Returns the public projects (other than the uploader's own) which already have a file being uploaded, so the
+// uploader can be warned before adding a copy.  Every version of a project is checked for the file.  When the number of
+// triangles in the model is given, projects whose latest model has the same number are included as well, as they're
+// likely copies which have been converted or re-exported.  Identical files are listed first.
+func DuplicateProjects(loggedInUser string, sha string, numTris int64) (list []DuplicateProject, err error) {
+	if numTris < DuplicateMinTriangles {
+		numTris = 0
+	}
+	dbQuery := `
+		SELECT own.user_name, db.folder, db.db_name, bool_or(up.up_id IS NOT NULL) AS identical
+		FROM sqlite_databases AS db
+			JOIN users AS own ON own.user_id = db.user_id
+			LEFT JOIN database_uploads AS up ON up.db_id = db.db_id AND up.db_sha256 = $1
+		WHERE db.is_deleted = false
+			AND ` + publicProject("db") + `
+			AND lower(own.user_name) <> lower($2)
+			AND (up.up_id IS NOT NULL OR ($3 > 0 AND db.triangle_count = $3))
+		GROUP BY db.db_id, own.user_name
+		ORDER BY identical DESC, db.stars DESC, db.date_created
+		LIMIT $4`
+	rows, err := pdb.Query(dbQuery, sha, loggedInUser, numTris, DuplicateProjectsSize)
+	if err != nil {
+		Log.Errorf("Database query failed: %v", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var oneRow DuplicateProject
+		var folder string
+		err = rows.Scan(&oneRow.Owner, &folder, &oneRow.DBName, &oneRow.Identical)
+		if err != nil {
+			Log.Errorf("Error retrieving the possible duplicates of '%s': %v", sha, err)
+			return
+		}
+		oneRow.URL = ProjectPath(oneRow.Owner, folder, oneRow.DBName)
+		list = append(list, oneRow)
+	}
+	return
+}
+
+// Records a background job as having failed.  If it has attempts left it's queued again, after waiting retryDelay
+// (doubled for each attempt so far).  Otherwise it's moved to the dead job list for an admin to look at.
+func FailJob(id int64, jobErr string, retryDelay time.Duration) error {
+	dbQuery := `
+		UPDATE background_jobs
+		SET status = CASE WHEN attempts >= max_attempts THEN 'dead' ELSE 'queued' END,
+			last_error = $2, locked_by = NULL, date_updated = now(),
+			run_after = now() + ($3::bigint * power(2, least(attempts - 1, 10))) * interval '1 second'
+		WHERE job_id = $1`
+	_, err := pdb.Exec(dbQuery, id, jobErr, int64(retryDelay/time.Second))
+	if err != nil {
+		Log.Errorf("Recording the failure of background job '%d' failed: %v", id, err)
+		return err
+	}
+	return nil
+}
+
+// Returns the change of email address a confirmation link is for.  ForOld is true when the link was sent to the old
+// address.
+func EmailChangeFromToken(token string) (change EmailChange, forOld bool, found bool, err error) {
+	dbQuery := `
+		SELECT u.user_name, coalesce(u.email, ''), ec.new_email, ec.old_token IS NULL, ec.new_token IS NULL,
+			ec.date_requested, ec.expires, ec.old_token = $1
+		FROM email_changes AS ec
+			JOIN users AS u ON u.user_id = ec.user_id
+		WHERE (ec.old_token = $1 OR ec.new_token = $1)
+			AND ec.expires > now()`
+	err = pdb.QueryRow(dbQuery, token).Scan(&change.UserName, &change.OldEmail, &change.NewEmail,
+		&change.OldConfirmed, &change.NewConfirmed, &change.DateRequested, &change.Expires, &forOld)
+	if err == pgx.ErrNoRows {
+		return change, false, false, nil
+	}
+	if err != nil {
+		Log.Errorf("Looking up an email change confirmation link failed: %v", err)
+		return
+	}
+	return change, forOld, true, nil
+}
+
+// Returns the data exports which have expired, or whose user has been removed, so they can be cleaned up.
+func ExpiredDataExports() (list []DataExportEntry, err error) {
+	dbQuery := `
+		SELECT export_id, coalesce(object_name, '')
+		FROM data_exports
+		WHERE expires < now()
+			OR user_id IS NULL`
+	rows, err := pdb.Query(dbQuery)
+	if err != nil {
+		Log.Errorf("Database query failed: %v", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var oneRow DataExportEntry
+		err = rows.Scan(&oneRow.ID, &oneRow.ObjectName)
+		if err != nil {
+			Log.Errorf("Error retrieving expired data exports: %v", err)
+			return
+		}
+		list = append(list, oneRow)
+	}
+	return
+}
+
+// Returns the features which can be turned on or off, along with whether they're on and who last changed them.
+func FeatureFlags() (list []FeatureFlag, err error) {
+	dbQuery := `
+		SELECT name, enabled, changed_by, date_changed
+		FROM feature_flags`
+	rows, err := pdb.Query(dbQuery)
+	if err != nil {
+		Log.Errorf("Retrieving the feature flags failed: %v", err)
+		return
+	}
+	defer rows.Close()
+	stored := make(map[string]FeatureFlag)
+	for rows.Next() {
+		var f FeatureFlag
+		err = rows.Scan(&f.ID, &f.Enabled, &f.ChangedBy, &f.DateChanged)
+		if err != nil {
+			Log.Errorf("Error retrieving the feature flags: %v", err)
+			return nil, err
+		}
+		stored[f.ID] = f
+	}
+	for _, f := range Features {
+		if s, ok := stored[f.ID]; ok {
+			f.ChangedBy, f.DateChanged, f.Enabled = s.ChangedBy, s.DateChanged, s.Enabled
+		}
+		list = append(list, f)
+	}
+	return
+}
+
+// Returns the words and patterns added to the word filter by the site admins.
+func FilterWords() (list []FilterWord, err error) {
+	dbQuery := `
+		SELECT pattern, added_by, date_added
+		FROM filter_words
+		ORDER BY pattern`
+	rows, err := pdb.Query(dbQuery)
+	if err != nil {
+		Log.Errorf("Retrieving the word filter list failed: %v", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var oneRow FilterWord
+		err = rows.Scan(&oneRow.Pattern, &oneRow.AddedBy, &oneRow.DateAdded)
+		if err != nil {
+			Log.Errorf("Error retrieving the word filter list: %v", err)
+			return nil, err
+		}
+		list = append(list, oneRow)
+	}
+	return
+}
+
+// Returns the users whose usernames matched the word filter, and are waiting for a moderator to look at them.
+func FlaggedUsernames() (list []FlaggedUsername, err error) {
+	dbQuery := `
+		SELECT usr.user_name, usr.date_joined, f.pattern, f.date_flagged
+		FROM flagged_usernames AS f
+			JOIN users AS usr ON usr.user_id = f.user_id
+		ORDER BY f.date_flagged`
+	rows, err := pdb.Query(dbQuery)
+	if err != nil {
+		Log.Errorf("Retrieving the flagged usernames failed: %v", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var oneRow FlaggedUsername
+		err = rows.Scan(&oneRow.UserName, &oneRow.DateJoined, &oneRow.Pattern, &oneRow.DateFlagged)
+		if err != nil {
+			Log.Errorf("Error retrieving the flagged usernames: %v", err)
+			return nil, err
+		}
+		list = append(list, oneRow)
+	}
+	return
+}
+
+// Adds a user to the list of flagged usernames, for a moderator to look at.  The pattern is the word filter entry
+// their username matched.
+func FlagUsername(userName string, pattern string) error {
+	dbQuery := `
+		INSERT INTO flagged_usernames (user_id, pattern)
+		SELECT user_id, $2
+		FROM users
+		WHERE lower(user_name) = lower($1)
+		ON CONFLICT (user_id)
+			DO UPDATE
+			SET pattern = excluded.pattern, date_flagged = now()`
+	_, err := pdb.Exec(dbQuery, userName, pattern)
+	if err != nil {
+		Log.Errorf("Flagging username '%s' failed: %v", userName, err)
+	}
+	return err
+}
+
+// Periodically flushes the database view count from memcache to PostgreSQL
+func FlushViewCount() {
+	type dbEntry struct {
+		Owner  string
+		Folder string
+		Name   string
+	}
+
+	// Log the start of the loop
+	Log.Infof("Periodic view count flushing loop started.  %d second refresh.",
+		Conf().Memcache.ViewCountFlushDelay)
+
+	// Start the endless flush loop
+	var rows *pgx.Rows
+	var err error
+	for true {
+		// When several instances are running, only one of them flushes the view counts
+		if !HoldJobLock("flush-view-count", Conf().Memcache.ViewCountFlushDelay*time.Second) {
+			time.Sleep(Conf().Memcache.ViewCountFlushDelay * time.Second)
+			continue
+		}
+
+		// Retrieve the list of all public databases
+		dbQuery := `
+			SELECT users.user_name, db.folder, db.db_name
+			FROM sqlite_databases AS db, users
+			WHERE db.public = true
+				AND db.is_deleted = false
+				AND db.user_id = users.user_id`
+		rows, err = pdb.Query(dbQuery)
+		if err != nil {
+			Log.Errorf("Database query failed: %v", err)
+			return
+		}
+		var dbList []dbEntry
+		for rows.Next() {
+			var oneRow dbEntry
+			err = rows.Scan(&oneRow.Owner, &oneRow.Folder, &oneRow.Name)
+			if err != nil {
+				Log.Errorf("Error retrieving database list for view count flush thread: %v", err)
+				rows.Close()
+				return
+			}
+			dbList = append(dbList, oneRow)
+		}
+		rows.Close()
+
+		// For each public database, retrieve the latest view count from memcache and save it back to PostgreSQL
+		for _, db := range dbList {
+			owner := db.Owner
+			folder := db.Folder
+			fileName := db.Name
+
+			// Retrieve the view count from Memcached
+			newValue, err := GetViewCount(owner, folder, fileName)
+			if err != nil {
+				Log.Errorf("Error when getting memcached view count for %s%s%s: %s", owner, folder, fileName,
+					err.Error())
+				continue
+			}
+
+			// We use a value of -1 to indicate there wasn't an entry in memcache for the database
+			if newValue != -1 {
+				// Update the view count in PostgreSQL
+				dbQuery = `
+					UPDATE sqlite_databases
+					SET page_views = $4
+					WHERE user_id = (
+							SELECT user_id
+							FROM users
+							WHERE lower(user_name) = lower($1)
+						)
+						AND folder = $2
+						AND db_name = $3`
+				commandTag, err := pdb.Exec(dbQuery, owner, folder, fileName, newValue)
+				if err != nil {
+					Log.Errorf("Flushing view count for '%s%s%s' failed: %v", owner, folder, fileName, err)
+					continue
+				}
+				if numRows := commandTag.RowsAffected(); numRows != 1 {
+					Log.Warnf("Wrong number of rows affected (%v) when flushing view count for '%s%s%s'",
+						numRows, owner, folder, fileName)
+					continue
+				}
+			}
+		}
+
+		// Wait before running the loop again
+		time.Sleep(Conf().Memcache.ViewCountFlushDelay * time.Second)
+	}
+	return
+}
+
+// Fork the PostgreSQL entry for a SQLite database from one user to another
+func ForkDatabase(srcOwner string, folder string, fileName string, dstOwner string) (newForkCount int, err error) {
+	// Copy the main database entry
+	dbQuery := `
+		WITH dst_u AS (
+			SELECT user_id
+			FROM users
+			WHERE lower(user_name) = lower($1)
+		)
+		INSERT INTO sqlite_databases (user_id, folder, db_name, public, forks, one_line_description, full_description,
+			branches, contributors, root_database, default_table, source_url, commit_list, branch_heads, tags,
+			default_branch, forked_from, readme)
+		SELECT dst_u.user_id, folder, db_name, public, 0, one_line_description, full_description, branches,
+			contributors, root_database, default_table, source_url, commit_list, branch_heads, tags, default_branch,
+			db_id, readme
+		FROM sqlite_databases, dst_u
+		WHERE sqlite_databases.user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($2)
+			)
+			AND folder = $3
+			AND db_name = $4`
+	commandTag, err := pdb.Exec(dbQuery, dstOwner, srcOwner, folder, fileName)
+	if err != nil {
+		Log.Errorf("Forking database '%s%s%s' in PostgreSQL failed: %v", srcOwner, folder, fileName, err)
+		return 0, err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		Log.Warnf("Wrong number of rows affected (%d) when forking main database entry: "+
+			"'%s%s%s' to '%s%s%s'", numRows, srcOwner, folder, fileName, dstOwner, folder, fileName)
+	}
+
+	// Update the fork count for the root database
+	dbQuery = `
+		WITH root_db AS (
 			SELECT root_database AS id
 			FROM sqlite_databases
 			WHERE user_id = (
@@ -1364,7 +3225,7 @@ func ForkDatabase(srcOwner string, folder string, fileName string, dstOwner stri
 		RETURNING new_count.forks - 1`
 	err = pdb.QueryRow(dbQuery, dstOwner, folder, fileName).Scan(&newForkCount)
 	if err != nil {
-		log.Printf("Updating fork count in PostgreSQL failed: %v\n", err)
+		Log.Errorf("Updating fork count in PostgreSQL failed: %v", err)
 		return 0, err
 	}
 	return newForkCount, nil
@@ -1386,7 +3247,7 @@ func ForkedFrom(owner string, folder string, fileName string) (forkOwn string, f
 			AND db_name = $3`
 	err = pdb.QueryRow(dbQuery, owner, folder, fileName).Scan(&dbID, &forkedFrom)
 	if err != nil {
-		log.Printf("Error checking if database was forked from another '%s%s%s'. Error: %v\n", owner,
+		Log.Errorf("Error checking if database was forked from another '%s%s%s'. Error: %v", owner,
 			folder, fileName, err)
 		return "", "", "", false, err
 	}
@@ -1403,7 +3264,7 @@ func ForkedFrom(owner string, folder string, fileName string) (forkOwn string, f
 			AND u.user_id = db.user_id`
 	err = pdb.QueryRow(dbQuery, forkedFrom).Scan(&forkOwn, &forkFol, &forkDB, &forkDel)
 	if err != nil {
-		log.Printf("Error retrieving forked database information for '%s%s%s'. Error: %v\n", owner,
+		Log.Errorf("Error retrieving forked database information for '%s%s%s'. Error: %v", owner,
 			folder, fileName, err)
 		return "", "", "", false, err
 	}
@@ -1420,7 +3281,8 @@ func ForkedFrom(owner string, folder string, fileName string) (forkOwn string, f
 func ForkParent(loggedInUser string, owner string, folder string, fileName string) (parentOwner string,
 	parentFolder string, parentDBName string, err error) {
 	dbQuery := `
-		SELECT users.user_name, db.folder, db.db_name, db.public, db.db_id, db.forked_from, db.is_deleted
+		SELECT users.user_name, db.folder, db.db_name, (db.public AND NOT db.is_draft), db.db_id, db.forked_from,
+			db.is_deleted
 		FROM sqlite_databases AS db, users
 		WHERE db.root_database = (
 				SELECT root_database
@@ -1437,7 +3299,7 @@ func ForkParent(loggedInUser string, owner string, folder string, fileName strin
 		ORDER BY db.forked_from NULLS FIRST`
 	rows, err := pdb.Query(dbQuery, owner, folder, fileName)
 	if err != nil {
-		log.Printf("Database query failed: %v\n", err)
+		Log.Errorf("Database query failed: %v", err)
 		return
 	}
 	defer rows.Close()
@@ -1447,7 +3309,7 @@ func ForkParent(loggedInUser string, owner string, folder string, fileName strin
 		var oneRow ForkEntry
 		err = rows.Scan(&oneRow.Owner, &oneRow.Folder, &oneRow.DBName, &oneRow.Public, &oneRow.ID, &frk, &oneRow.Deleted)
 		if err != nil {
-			log.Printf("Error retrieving fork parent for '%s%s%s': %v\n", owner, folder, fileName,
+			Log.Errorf("Error retrieving fork parent for '%s%s%s': %v", owner, folder, fileName,
 				err)
 			return
 		}
@@ -1499,7 +3361,8 @@ func ForkParent(loggedInUser string, owner string, folder string, fileName strin
 // Return the complete fork tree for a given database
 func ForkTree(loggedInUser string, owner string, folder string, fileName string) (outputList []ForkEntry, err error) {
 	dbQuery := `
-		SELECT users.user_name, db.folder, db.db_name, db.public, db.db_id, db.forked_from, db.is_deleted
+		SELECT users.user_name, db.folder, db.db_name, (db.public AND NOT db.is_draft), db.db_id, db.forked_from,
+			db.is_deleted
 		FROM sqlite_databases AS db, users
 		WHERE db.root_database = (
 				SELECT root_database
@@ -1516,7 +3379,7 @@ func ForkTree(loggedInUser string, owner string, folder string, fileName string)
 		ORDER BY db.forked_from NULLS FIRST`
 	rows, err := pdb.Query(dbQuery, owner, folder, fileName)
 	if err != nil {
-		log.Printf("Database query failed: %v\n", err)
+		Log.Errorf("Database query failed: %v", err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -1526,7 +3389,7 @@ func ForkTree(loggedInUser string, owner string, folder string, fileName string)
 		var oneRow ForkEntry
 		err = rows.Scan(&oneRow.Owner, &oneRow.Folder, &oneRow.DBName, &oneRow.Public, &oneRow.ID, &frk, &oneRow.Deleted)
 		if err != nil {
-			log.Printf("Error retrieving fork list for '%s%s%s': %v\n", owner, folder, fileName,
+			Log.Errorf("Error retrieving fork list for '%s%s%s': %v", owner, folder, fileName,
 				err)
 			return nil, err
 		}
@@ -1629,145 +3492,120 @@ func ForkTree(loggedInUser string, owner string, folder string, fileName string)
 	return outputList, nil
 }
 
+// Returns the most starred, forked, downloaded, and viewed projects, along with the most recent uploads.  The lists
+// are retrieved at the same time, and cached for a short while as they're shown on the front page.
 func GetActivityStats() (stats ActivityStats, err error) {
-	// Retrieve a list of which databases are the most starred
-	dbQuery := `
-		WITH most_starred AS (
-			SELECT s.db_id, COUNT(s.db_id), max(s.date_starred)
-			FROM database_stars AS s, sqlite_databases AS db
-			WHERE s.db_id = db.db_id
-				AND db.public = true
+	ok, err := GetCachedData(activityStatsCacheKey, &stats)
+	if err != nil {
+		Log.Errorf("Error retrieving activity stats from cache: %v", err)
+	}
+	if ok {
+		return stats, nil
+	}
+
+	var g errgroup.Group
+	g.Go(func() (err error) {
+		// Retrieve a list of which databases are the most starred
+		dbQuery := `
+			WITH most_starred AS (
+				SELECT s.db_id, COUNT(s.db_id), max(s.date_starred)
+				FROM database_stars AS s, sqlite_databases AS db
+				WHERE s.db_id = db.db_id
+					AND ` + publicProject("db") + `
+					AND db.is_deleted = false
+				GROUP BY s.db_id
+				ORDER BY count DESC
+				LIMIT 5
+			)
+			SELECT users.user_name, db.db_name, stars.count
+			FROM most_starred AS stars, sqlite_databases AS db, users
+			WHERE stars.db_id = db.db_id
+				AND users.user_id = db.user_id
+			ORDER BY count DESC, max ASC`
+		stats.Starred, err = activityList(dbQuery, "most starred databases")
+		return
+	})
+	g.Go(func() (err error) {
+		// Retrieve a list of which databases are the most forked
+		dbQuery := `
+			SELECT users.user_name, db.db_name, db.forks
+			FROM sqlite_databases AS db, users
+			WHERE db.forks > 0
+				AND ` + publicProject("db") + `
 				AND db.is_deleted = false
-			GROUP BY s.db_id
-			ORDER BY count DESC
-			LIMIT 5
-		)
-		SELECT users.user_name, db.db_name, stars.count
-		FROM most_starred AS stars, sqlite_databases AS db, users
-		WHERE stars.db_id = db.db_id
-			AND users.user_id = db.user_id
-		ORDER BY count DESC, max ASC`
-	starRows, err := pdb.Query(dbQuery)
-	if err != nil {
-		log.Printf("Database query failed: %v\n", err)
+				AND db.user_id = users.user_id
+			ORDER BY db.forks DESC, db.last_modified
+			LIMIT 5`
+		stats.Forked, err = activityList(dbQuery, "most forked databases")
 		return
-	}
-	defer starRows.Close()
-	for starRows.Next() {
-		var oneRow ActivityRow
-		err = starRows.Scan(&oneRow.Owner, &oneRow.DBName, &oneRow.Count)
+	})
+	g.Go(func() error {
+		// Retrieve a list of the most recent uploads
+		dbQuery := `
+			SELECT user_name, db.db_name, db.last_modified
+			FROM sqlite_databases AS db, users
+			WHERE db.forked_from IS NULL
+				AND ` + publicProject("db") + `
+				AND db.is_deleted = false
+				AND db.user_id = users.user_id
+			ORDER BY db.last_modified DESC
+			LIMIT 5`
+		upRows, err := pdb.Query(dbQuery)
 		if err != nil {
-			log.Printf("Error retrieving list of most starred databases: %v\n", err)
-			return
+			Log.Errorf("Database query failed: %v", err)
+			return err
 		}
-		stats.Starred = append(stats.Starred, oneRow)
-	}
-
-	// Retrieve a list of which databases are the most forked
-	dbQuery = `
-		SELECT users.user_name, db.db_name, db.forks
-		FROM sqlite_databases AS db, users
-		WHERE db.forks > 0
-			AND db.public = true
-			AND db.is_deleted = false
-			AND db.user_id = users.user_id
-		ORDER BY db.forks DESC, db.last_modified
-		LIMIT 5`
-	forkRows, err := pdb.Query(dbQuery)
+		defer upRows.Close()
+		for upRows.Next() {
+			var oneRow UploadRow
+			err = upRows.Scan(&oneRow.Owner, &oneRow.DBName, &oneRow.UploadDate)
+			if err != nil {
+				Log.Errorf("Error retrieving list of most recent uploads: %v", err)
+				return err
+			}
+			stats.Uploads = append(stats.Uploads, oneRow)
+		}
+		return nil
+	})
+	g.Go(func() (err error) {
+		// Retrieve a list of which databases have been downloaded the most times by someone other than their owner
+		dbQuery := `
+			SELECT users.user_name, db.db_name, db.download_count
+			FROM sqlite_databases AS db, users
+			WHERE db.download_count > 0
+				AND ` + publicProject("db") + `
+				AND db.is_deleted = false
+				AND db.user_id = users.user_id
+			ORDER BY db.download_count DESC, db.last_modified
+			LIMIT 5`
+		stats.Downloads, err = activityList(dbQuery, "most downloaded databases")
+		return
+	})
+	g.Go(func() (err error) {
+		// Retrieve the list of databases which have been viewed the most times
+		dbQuery := `
+			SELECT users.user_name, db.db_name, db.page_views
+			FROM sqlite_databases AS db, users
+			WHERE db.page_views > 0
+				AND ` + publicProject("db") + `
+				AND db.is_deleted = false
+				AND db.user_id = users.user_id
+			ORDER BY db.page_views DESC, db.last_modified
+			LIMIT 5`
+		stats.Viewed, err = activityList(dbQuery, "most viewed databases")
+		return
+	})
+	err = g.Wait()
 	if err != nil {
-		log.Printf("Database query failed: %v\n", err)
 		return
 	}
-	defer forkRows.Close()
-	for forkRows.Next() {
-		var oneRow ActivityRow
-		err = forkRows.Scan(&oneRow.Owner, &oneRow.DBName, &oneRow.Count)
-		if err != nil {
-			log.Printf("Error retrieving list of most forked databases: %v\n", err)
-			return
-		}
-		stats.Forked = append(stats.Forked, oneRow)
+
+	err = CacheData(activityStatsCacheKey, stats, activityStatsCacheTime)
+	if err != nil {
+		Log.Errorf("Error when caching activity stats: %v", err)
 	}
-
-	// Retrieve a list of the most recent uploads
-	dbQuery = `
-		SELECT user_name, db.db_name, db.last_modified
-		FROM sqlite_databases AS db, users
-		WHERE db.forked_from IS NULL
-			AND db.public = true
-			AND db.is_deleted = false
-			AND db.user_id = users.user_id
-		ORDER BY db.last_modified DESC
-		LIMIT 5`
-	upRows, err := pdb.Query(dbQuery)
-	if err != nil {
-		log.Printf("Database query failed: %v\n", err)
-		return
-	}
-	defer upRows.Close()
-	for upRows.Next() {
-		var oneRow UploadRow
-		err = upRows.Scan(&oneRow.Owner, &oneRow.DBName, &oneRow.UploadDate)
-		if err != nil {
-			log.Printf("Error retrieving list of most recent uploads: %v\n", err)
-			return
-		}
-		stats.Uploads = append(stats.Uploads, oneRow)
-	}
-
-	// Retrieve a list of which databases have been downloaded the most times by someone other than their owner
-	dbQuery = `
-		SELECT users.user_name, db.db_name, db.download_count
-		FROM sqlite_databases AS db, users
-		WHERE db.download_count > 0
-			AND db.public = true
-			AND db.is_deleted = false
-			AND db.user_id = users.user_id
-		ORDER BY db.download_count DESC, db.last_modified
-		LIMIT 5`
-	dlRows, err := pdb.Query(dbQuery)
-	if err != nil {
-		log.Printf("Database query failed: %v\n", err)
-		return
-	}
-	defer dlRows.Close()
-	for dlRows.Next() {
-		var oneRow ActivityRow
-		err = dlRows.Scan(&oneRow.Owner, &oneRow.DBName, &oneRow.Count)
-		if err != nil {
-			log.Printf("Error retrieving list of most downloaded databases: %v\n", err)
-			return
-		}
-		stats.Downloads = append(stats.Downloads, oneRow)
-	}
-
-	// Retrieve the list of databases which have been viewed the most times
-	dbQuery = `
-		SELECT users.user_name, db.db_name, db.page_views
-		FROM sqlite_databases AS db, users
-		WHERE db.page_views > 0
-			AND db.public = true
-			AND db.is_deleted = false
-			AND db.user_id = users.user_id
-		ORDER BY db.page_views DESC, db.last_modified
-		LIMIT 5`
-	viewRows, err := pdb.Query(dbQuery)
-	if err != nil {
-		log.Printf("Database query failed: %v\n", err)
-		return
-	}
-	defer viewRows.Close()
-	for viewRows.Next() {
-		var oneRow ActivityRow
-		err = viewRows.Scan(&oneRow.Owner, &oneRow.DBName, &oneRow.Count)
-		if err != nil {
-			log.Printf("Error retrieving list of most viewed databases: %v\n", err)
-			return
-		}
-		stats.Viewed = append(stats.Viewed, oneRow)
-	}
-	return
-}
+	return stats, nil
+}
 
 // Load the branch heads for a database.
 // TODO: It might be better to have the default branch name be returned as part of this list, by indicating in the list
@@ -1785,7 +3623,7 @@ func GetBranches(owner string, folder string, fileName string) (branches map[str
 			AND db.db_name = $3`
 	err = pdb.QueryRow(dbQuery, owner, folder, fileName).Scan(&branches)
 	if err != nil {
-		log.Printf("Error when retrieving branch heads for database '%s%s%s': %v\n", owner, folder, fileName,
+		Log.Errorf("Error when retrieving branch heads for database '%s%s%s': %v", owner, folder, fileName,
 			err)
 		return nil, err
 	}
@@ -1809,7 +3647,7 @@ func GetCommitList(owner string, folder string, fileName string) (map[string]Com
 	var l map[string]CommitEntry
 	err := pdb.QueryRow(dbQuery, owner, folder, fileName).Scan(&l)
 	if err != nil {
-		log.Printf("Retrieving commit list for '%s%s%s' failed: %v\n", owner, folder, fileName, err)
+		Log.Errorf("Retrieving commit list for '%s%s%s' failed: %v", owner, folder, fileName, err)
 		return map[string]CommitEntry{}, err
 	}
 	return l, nil
@@ -1838,11 +3676,11 @@ func GetDefaultBranchName(owner string, folder string, fileName string) (branchN
 	err = pdb.QueryRow(dbQuery, owner, folder, fileName).Scan(&b)
 	if err != nil {
 		if err != pgx.ErrNoRows {
-			log.Printf("Error when retrieving default branch name for database '%s%s%s': %v\n", owner,
+			Log.Errorf("Error when retrieving default branch name for database '%s%s%s': %v", owner,
 				folder, fileName, err)
 			return
 		} else {
-			log.Printf("No default branch name exists for database '%s%s%s'. This shouldn't happen\n", owner,
+			Log.Warnf("No default branch name exists for database '%s%s%s'. This shouldn't happen", owner,
 				folder, fileName)
 			return
 		}
@@ -1870,7 +3708,7 @@ func GetDefaultTableName(owner string, folder string, fileName string) (tableNam
 	err = pdb.QueryRow(dbQuery, owner, folder, fileName).Scan(&t)
 	if err != nil {
 		if err != pgx.ErrNoRows {
-			log.Printf("Error when retrieving default table name for database '%s%s%s': %v\n", owner,
+			Log.Errorf("Error when retrieving default table name for database '%s%s%s': %v", owner,
 				folder, fileName, err)
 			return
 		}
@@ -1900,12 +3738,12 @@ func GetDiscussionAndMRCount(owner string, folder string, fileName string) (disc
 	err = pdb.QueryRow(dbQuery, owner, folder, fileName).Scan(&discCount, &mrCount)
 	if err != nil {
 		if err != pgx.ErrNoRows {
-			log.Printf("Error when retrieving discussion and MR count for database '%s%s%s': %v\n", owner,
+			Log.Errorf("Error when retrieving discussion and MR count for database '%s%s%s': %v", owner,
 				folder, fileName, err)
 			return
 		} else {
-			log.Printf("Database '%s%s%s' not found when attempting to retrieve discussion and MR count. This"+
-				"shouldn't happen\n", owner, folder, fileName)
+			Log.Errorf("Database '%s%s%s' not found when attempting to retrieve discussion and MR count. This"+
+				"shouldn't happen", owner, folder, fileName)
 			return
 		}
 	}
@@ -1936,7 +3774,7 @@ func GetLicence(userName string, licenceName string) (txt string, format string,
 			// The requested licence text wasn't found
 			return "", "", errors.New("unknown licence")
 		}
-		log.Printf("Error when retrieving licence '%s', user '%s': %v\n", licenceName, userName, err)
+		Log.Errorf("Error when retrieving licence '%s', user '%s': %v", licenceName, userName, err)
 		return "", "", err
 	}
 	return txt, format, nil
@@ -1945,7 +3783,7 @@ func GetLicence(userName string, licenceName string) (txt string, format string,
 // Returns the list of licences available to a user.
 func GetLicences(user string) (map[string]LicenceEntry, error) {
 	dbQuery := `
-		SELECT friendly_name, full_name, lic_sha256, licence_url, file_format, display_order
+		SELECT friendly_name, full_name, lic_sha256, licence_url, file_format, display_order, coalesce(spdx_id, '')
 		FROM database_licences
 		WHERE user_id = (
 				SELECT user_id
@@ -1959,7 +3797,7 @@ func GetLicences(user string) (map[string]LicenceEntry, error) {
 			)`
 	rows, err := pdb.Query(dbQuery, user)
 	if err != nil {
-		log.Printf("Database query failed: %v\n", err)
+		Log.Errorf("Database query failed: %v", err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -1967,9 +3805,10 @@ func GetLicences(user string) (map[string]LicenceEntry, error) {
 	for rows.Next() {
 		var name string
 		var oneRow LicenceEntry
-		err = rows.Scan(&name, &oneRow.FullName, &oneRow.Sha256, &oneRow.URL, &oneRow.FileFormat, &oneRow.Order)
+		err = rows.Scan(&name, &oneRow.FullName, &oneRow.Sha256, &oneRow.URL, &oneRow.FileFormat, &oneRow.Order,
+			&oneRow.SPDX)
 		if err != nil {
-			log.Printf("Error retrieving licence list: %v\n", err)
+			Log.Errorf("Error retrieving licence list: %v", err)
 			return nil, err
 		}
 		lics[name] = oneRow
@@ -1998,7 +3837,7 @@ func GetLicenceInfoFromSha256(userName string, sha256 string) (lName string, lUR
 			))`
 	rows, err := pdb.Query(dbQuery, userName, sha256)
 	if err != nil {
-		log.Printf("Error when retrieving friendly name for licence sha256 '%s', user '%s': %v\n", sha256,
+		Log.Errorf("Error when retrieving friendly name for licence sha256 '%s', user '%s': %v", sha256,
 			userName, err)
 		return "", "", err
 	}
@@ -2013,7 +3852,7 @@ func GetLicenceInfoFromSha256(userName string, sha256 string) (lName string, lUR
 		var oneRow lic
 		err = rows.Scan(&oneRow.User, &oneRow.Name, &oneRow.Licence)
 		if err != nil {
-			log.Printf("Error retrieving friendly name for licence sha256 '%s', user: %v\n", sha256, err)
+			Log.Errorf("Error retrieving friendly name for licence sha256 '%s', user: %v", sha256, err)
 			return "", "", err
 		}
 		list = append(list, oneRow)
@@ -2072,7 +3911,7 @@ func GetLicenceSha256FromName(userName string, licenceName string) (sha256 strin
 			))`
 	err = pdb.QueryRow(dbQuery, userName, licenceName).Scan(&sha256)
 	if err != nil {
-		log.Printf("Error when retrieving sha256 for licence '%s', user '%s' from database: %v\n", licenceName,
+		Log.Errorf("Error when retrieving sha256 for licence '%s', user '%s' from database: %v", licenceName,
 			userName, err)
 		return "", err
 	}
@@ -2083,6 +3922,33 @@ func GetLicenceSha256FromName(userName string, licenceName string) (sha256 strin
 	return sha256, nil
 }
 
+// Returns the SPDX identifier for the licence matching a given sha256, for automated compliance tools.  Licences
+// without an SPDX identifier (eg ones added by users) get a "LicenseRef-" one made from their friendly name, as SPDX
+// suggests.  Projects without a licence get "NOASSERTION".
+func GetLicenceSPDXFromSha256(userName string, sha256 string) (spdxID string, err error) {
+	if sha256 == "" {
+		return "NOASSERTION", nil
+	}
+	dbQuery := `
+		SELECT coalesce(dl.spdx_id, 'LicenseRef-' || regexp_replace(dl.friendly_name, '[^A-Za-z0-9.-]+', '-', 'g'))
+		FROM database_licences AS dl
+			JOIN users AS u ON u.user_id = dl.user_id
+		WHERE dl.lic_sha256 = $2
+			AND (u.user_name = 'default' OR lower(u.user_name) = lower($1))
+		ORDER BY dl.spdx_id IS NULL, u.user_name = 'default'
+		LIMIT 1`
+	err = pdb.QueryRow(dbQuery, userName, sha256).Scan(&spdxID)
+	if err == pgx.ErrNoRows {
+		return "NOASSERTION", nil
+	}
+	if err != nil {
+		Log.Errorf("Error when retrieving the SPDX identifier for licence sha256 '%s', user '%s': %v", sha256,
+			userName, err)
+		return "", err
+	}
+	return
+}
+
 // Retrieve the list of releases for a database.
 func GetReleases(owner string, folder string, fileName string) (releases map[string]ReleaseEntry, err error) {
 	dbQuery := `
@@ -2097,7 +3963,7 @@ func GetReleases(owner string, folder string, fileName string) (releases map[str
 			AND db_name = $3`
 	err = pdb.QueryRow(dbQuery, owner, folder, fileName).Scan(&releases)
 	if err != nil {
-		log.Printf("Error when retrieving releases for database '%s%s%s': %v\n", owner, folder, fileName, err)
+		Log.Errorf("Error when retrieving releases for database '%s%s%s': %v", owner, folder, fileName, err)
 		return nil, err
 	}
 	if releases == nil {
@@ -2121,7 +3987,7 @@ func GetTags(owner string, folder string, fileName string) (tags map[string]TagE
 			AND db_name = $3`
 	err = pdb.QueryRow(dbQuery, owner, folder, fileName).Scan(&tags)
 	if err != nil {
-		log.Printf("Error when retrieving tags for database '%s%s%s': %v\n", owner, folder, fileName, err)
+		Log.Errorf("Error when retrieving tags for database '%s%s%s': %v", owner, folder, fileName, err)
 		return nil, err
 	}
 	if tags == nil {
@@ -2145,7 +4011,7 @@ func GetUsernameFromEmail(email string) (userName string, avatarURL string, err
 			err = nil
 			return
 		}
-		log.Printf("Looking up username for email address '%s' failed: %v\n", email, err)
+		Log.Errorf("Looking up username for email address '%s' failed: %v", email, err)
 		return
 	}
 
@@ -2159,135 +4025,61 @@ func GetUsernameFromEmail(email string) (userName string, avatarURL string, err
 	return
 }
 
-// Increments the download count for a database
-func IncrementDownloadCount(owner string, folder string, fileName string) error {
+// Returns the details of a GitHub import.
+func GitHubImport(id int64) (imp GitHubImportEntry, err error) {
 	dbQuery := `
-		UPDATE sqlite_databases
-		SET download_count = download_count + 1
-		WHERE user_id = (
-				SELECT user_id
-				FROM users
-				WHERE lower(user_name) = lower($1)
-			)
-			AND folder = $2
-			AND db_name = $3`
-	commandTag, err := pdb.Exec(dbQuery, owner, folder, fileName)
+		SELECT imp.import_id, u.user_name, imp.repo, imp.branch, imp.path, imp.licence, imp.public, imp.schedule,
+			imp.webhook_secret, coalesce(imp.last_commit, ''), coalesce(imp.last_error, ''), imp.last_synced
+		FROM github_imports AS imp
+			JOIN users AS u ON u.user_id = imp.user_id
+		WHERE imp.import_id = $1`
+	var lastSynced pgx.NullTime
+	err = pdb.QueryRow(dbQuery, id).Scan(&imp.ID, &imp.Owner, &imp.Repo, &imp.Branch, &imp.Path, &imp.Licence,
+		&imp.Public, &imp.Schedule, &imp.WebhookSecret, &imp.LastCommit, &imp.LastError, &lastSynced)
 	if err != nil {
-		log.Printf("Increment download count for '%s%s%s' failed: %v\n", owner, folder,
-			fileName, err)
-		return err
+		Log.Errorf("Retrieving GitHub import '%d' failed: %v", id, err)
+		return
 	}
-	if numRows := commandTag.RowsAffected(); numRows != 1 {
-		errMsg := fmt.Sprintf("Wrong number of rows affected (%v) when incrementing download count for '%s%s%s'\n",
-			numRows, owner, folder, fileName)
-		log.Printf(errMsg)
-		return errors.New(errMsg)
+	if lastSynced.Valid {
+		imp.LastSynced = lastSynced.Time
 	}
-	return nil
+	return
 }
 
-// Create a download log entry
-func LogDownload(owner string, folder string, fileName string, loggedInUser string, ipAddr string, serverSw string,
-	userAgent string, downloadDate time.Time, sha string) error {
-	// If the downloader isn't a logged in user, use a NULL value for that column
-	var downloader pgx.NullString
-	if loggedInUser != "" {
-		downloader.String = loggedInUser
-		downloader.Valid = true
-	}
-
-	// Store the download details
+// Returns the projects created by a GitHub import, by the path of their file in the repository.
+func GitHubImportFiles(id int64) (files map[string]GitHubImportFile, err error) {
 	dbQuery := `
-		WITH d AS (
-			SELECT db.db_id, db.folder, db.db_name
-			FROM sqlite_databases AS db
-			WHERE user_id = (
-					SELECT user_id
-					FROM users
-					WHERE lower(user_name) = lower($1)
-				)
-				AND db.folder = $2
-				AND db.db_name = $3
-		)
-		INSERT INTO database_downloads (db_id, user_id, ip_addr, server_sw, user_agent, download_date, db_sha256)
-		SELECT (SELECT db_id FROM d), (SELECT user_id FROM users WHERE lower(user_name) = lower($4)), $5, $6, $7, $8, $9`
-	commandTag, err := pdb.Exec(dbQuery, owner, folder, fileName, downloader, ipAddr, serverSw, userAgent,
-		downloadDate, sha)
+		SELECT f.repo_path, f.blob_sha, db.db_name
+		FROM github_import_files AS f
+			JOIN sqlite_databases AS db ON db.db_id = f.db_id
+		WHERE f.import_id = $1
+			AND db.is_deleted = false`
+	rows, err := pdb.Query(dbQuery, id)
 	if err != nil {
-		log.Printf("Storing record of download '%s%s%s', sha '%s' by '%s' failed: %v\n", owner, folder,
-			fileName, sha, downloader, err)
-		return err
+		Log.Errorf("Retrieving the files for GitHub import '%d' failed: %v", id, err)
+		return
 	}
-	if numRows := commandTag.RowsAffected(); numRows != 1 {
-		log.Printf("Wrong number of rows (%v) affected while storing download record for '%s%s%s'\n", numRows,
-			owner, folder, fileName)
+	defer rows.Close()
+	files = make(map[string]GitHubImportFile)
+	for rows.Next() {
+		var repoPath string
+		var f GitHubImportFile
+		if err = rows.Scan(&repoPath, &f.BlobSHA, &f.FileName); err != nil {
+			Log.Errorf("Error retrieving the files for GitHub import '%d': %v", id, err)
+			return
+		}
+		files[repoPath] = f
 	}
-	return nil
+	return
 }
 
-// Create an upload log entry
-func LogUpload(owner string, folder string, fileName string, loggedInUser string, ipAddr string, serverSw string,
-	userAgent string, uploadDate time.Time, sha string) error {
-	// If the uploader isn't a logged in user, use a NULL value for that column
-	var uploader pgx.NullString
-	if loggedInUser != "" {
-		uploader.String = loggedInUser
-		uploader.Valid = true
-	}
-
-	// Store the upload details
+// Returns where a project is mirrored to on GitHub, if anywhere.
+func GitHubMirror(owner string, folder string, fileName string) (m GitHubMirrorEntry, found bool, err error) {
 	dbQuery := `
-		WITH d AS (
-			SELECT db.db_id, db.folder, db.db_name
-			FROM sqlite_databases AS db
-			WHERE user_id = (
-					SELECT user_id
-					FROM users
-					WHERE lower(user_name) = lower($1)
-				)
-				AND db.folder = $2
-				AND db.db_name = $3
-		)
-		INSERT INTO database_uploads (db_id, user_id, ip_addr, server_sw, user_agent, upload_date, db_sha256)
-		SELECT (SELECT db_id FROM d), (SELECT user_id FROM users WHERE lower(user_name) = lower($4)), $5, $6, $7, $8, $9`
-	commandTag, err := pdb.Exec(dbQuery, owner, folder, fileName, uploader, ipAddr, serverSw, userAgent,
-		uploadDate, sha)
-	if err != nil {
-		log.Printf("Storing record of upload '%s%s%s', sha '%s' by '%s' failed: %v\n", owner, folder,
-			fileName, sha, uploader, err)
-		return err
-	}
-	if numRows := commandTag.RowsAffected(); numRows != 1 {
-		log.Printf("Wrong number of rows (%v) affected while storing upload record for '%s%s%s'\n", numRows,
-			owner, folder, fileName)
-	}
-	return nil
-}
-
-// Return the Minio bucket and ID for a given database. owner, folder, & fileName are from owner/folder/database URL
-// fragment, // loggedInUser is the name for the currently logged in user, for access permission check.  Use an empty
-// string ("") as the loggedInUser parameter if the true value isn't set or known.
-// If the requested database doesn't exist, or the loggedInUser doesn't have access to it, then an error will be
-// returned.
-func MinioLocation(owner string, folder string, fileName string, commitID string, loggedInUser string) (minioBucket string,
-	minioID string, lastModified time.Time, err error) {
-
-	// TODO: This will likely need updating to query the "database_files" table to retrieve the Minio server name
-
-	// If no commit was provided, we grab the default one
-	if commitID == "" {
-		commitID, err = DefaultCommit(owner, folder, fileName)
-		if err != nil {
-			return // Bucket and ID are still the initial default empty string
-		}
-	}
-
-	// Retrieve the sha256 and last modified date for the requested commit's database file
-	var dbQuery string
-	dbQuery = `
-		SELECT commit_list->$4::text->'tree'->'entries'->0->'sha256' AS sha256,
-			commit_list->$4::text->'tree'->'entries'->0->'last_modified' AS last_modified
-		FROM sqlite_databases AS db
+		SELECT m.repo, m.branch, m.path, m.token, m.pushed_commits, m.pushed_releases, coalesce(m.last_commit, ''),
+			coalesce(m.last_error, ''), m.last_pushed
+		FROM github_mirrors AS m
+			JOIN sqlite_databases AS db ON db.db_id = m.db_id
 		WHERE db.user_id = (
 				SELECT user_id
 				FROM users
@@ -2296,550 +4088,3193 @@ func MinioLocation(owner string, folder string, fileName string, commitID string
 			AND db.folder = $2
 			AND db.db_name = $3
 			AND db.is_deleted = false`
-
-	// If the request is for another users database, it needs to be a public one
-	if strings.ToLower(loggedInUser) != strings.ToLower(owner) {
-		dbQuery += `
-				AND db.public = true`
+	var lastPushed pgx.NullTime
+	var sealed []byte
+	err = pdb.QueryRow(dbQuery, owner, folder, fileName).Scan(&m.Repo, &m.Branch, &m.Path, &sealed,
+		&m.PushedCommits, &m.PushedReleases, &m.LastCommit, &m.LastError, &lastPushed)
+	if err == pgx.ErrNoRows {
+		return m, false, nil
 	}
-
-	var sha, mod string
-	err = pdb.QueryRow(dbQuery, owner, folder, fileName, commitID).Scan(&sha, &mod)
 	if err != nil {
-		log.Printf("Error retrieving MinioID for %s/%s version %v: %v\n", owner, fileName, commitID, err)
-		return // Bucket and ID are still the initial default empty string
+		Log.Errorf("Retrieving the GitHub mirror for '%s%s%s' failed: %v", owner, folder, fileName, err)
+		return
 	}
 
-	if sha == "" {
-		// The requested database doesn't exist, or the logged in user doesn't have access to it
-		err = fmt.Errorf("The requested database wasn't found")
-		return // Bucket and ID are still the initial default empty string
+	// A token which can't be decrypted (eg after the secrets key changed) is left empty, so the pushes fail until the
+	// owner enters it again
+	var openErr error
+	m.Token, openErr = openSecret(sealed)
+	if openErr != nil {
+		Log.Errorf("Decrypting the GitHub access token of '%s%s%s' failed: %v", owner, folder, fileName, openErr)
+		m.LastError = "The stored GitHub access token couldn't be used.  Please enter it again"
+	}
+	if lastPushed.Valid {
+		m.LastPushed = lastPushed.Time
 	}
+	if m.PushedCommits == nil {
+		m.PushedCommits = make(map[string]string)
+	}
+	if m.PushedReleases == nil {
+		m.PushedReleases = make(map[string]string)
+	}
+	return m, true, nil
+}
 
-	lastModified, err = time.Parse(time.RFC3339, mod)
+// Returns a file sent to a project through a guest upload link.
+func GuestUpload(owner string, folder string, fileName string, id int64) (u GuestUploadEntry, err error) {
+	dbQuery := `
+		SELECT up.upload_id, up.uploader_name, coalesce(up.uploader_email, ''), coalesce(up.message, ''), up.sha256,
+			up.size, up.date_uploaded
+		FROM guest_uploads AS up
+			JOIN sqlite_databases AS db ON db.db_id = up.db_id
+		WHERE up.upload_id = $4
+			AND db.user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND db.folder = $2
+			AND db.db_name = $3
+			AND db.is_deleted = false`
+	err = pdb.QueryRow(dbQuery, owner, folder, fileName, id).Scan(&u.ID, &u.Name, &u.Email, &u.Message, &u.Sha256,
+		&u.Size, &u.DateUploaded)
+	if err == pgx.ErrNoRows {
+		return u, errors.New("That guest upload doesn't exist")
+	}
 	if err != nil {
-		return // Bucket and ID are still the initial default empty string
+		Log.Errorf("Retrieving guest upload %d for '%s%s%s' failed: %v", id, owner, folder, fileName, err)
 	}
-
-	minioBucket = sha[:MinioFolderChars]
-	minioID = sha[MinioFolderChars:]
 	return
 }
 
-// Adds an event entry to PostgreSQL
-func NewEvent(details EventDetails) (err error) {
+// Returns true if a guest upload link hasn't expired, and its project has fewer than maxPending files waiting for
+// approval.
+func GuestUploadLinkHasRoom(token string, maxPending int) (ok bool, err error) {
 	dbQuery := `
-		WITH d AS (
-			SELECT db_id
-			FROM sqlite_databases
-			WHERE user_id = (
-					SELECT user_id
-					FROM users
-					WHERE lower(user_name) = lower($1)
-				)
-				AND folder = $2
-				AND db_name = $3
-				AND is_deleted = false
-		)
-		INSERT INTO events (db_id, event_type, event_data)
-		VALUES ((SELECT db_id FROM d), $4, $5)`
-	_, err = pdb.Exec(dbQuery, details.Owner, details.Folder, details.DBName, details.Type, details)
+		SELECT (
+				SELECT count(*)
+				FROM guest_uploads
+				WHERE db_id = link.db_id
+			) < $2
+		FROM guest_upload_links AS link
+			JOIN sqlite_databases AS db ON db.db_id = link.db_id
+		WHERE link.token = $1
+			AND link.expiry_date > now()
+			AND db.is_deleted = false`
+	err = pdb.QueryRow(dbQuery, token, maxPending).Scan(&ok)
+	if err == pgx.ErrNoRows {
+		return false, nil
+	}
 	if err != nil {
-		return err
+		Log.Errorf("Checking a guest upload link failed: %v", err)
 	}
 	return
 }
 
-// Return the user's preference for maximum number of SQLite rows to display.
-func PrefUserMaxRows(loggedInUser string) int {
-	// Retrieve the user preference data
+// Returns the project a guest upload link is for.  Expired links aren't found.
+func GuestUploadLinkProject(token string) (owner string, folder string, fileName string, found bool, err error) {
 	dbQuery := `
-		SELECT pref_max_rows
-		FROM users
-		WHERE user_id = (
-			SELECT user_id
-			FROM users
-			WHERE lower(user_name) = lower($1))`
-	var maxRows int
-	err := pdb.QueryRow(dbQuery, loggedInUser).Scan(&maxRows)
+		SELECT users.user_name, db.folder, db.db_name
+		FROM guest_upload_links AS link
+			JOIN sqlite_databases AS db ON db.db_id = link.db_id
+			JOIN users ON users.user_id = db.user_id
+		WHERE link.token = $1
+			AND link.expiry_date > now()
+			AND db.is_deleted = false`
+	err = pdb.QueryRow(dbQuery, token).Scan(&owner, &folder, &fileName)
+	if err == pgx.ErrNoRows {
+		return "", "", "", false, nil
+	}
 	if err != nil {
-		log.Printf("Error retrieving user '%s' preference data: %v\n", loggedInUser, err)
-		return DefaultNumDisplayRows // Use the default value
+		Log.Errorf("Looking up a guest upload link failed: %v", err)
+		return
 	}
-
-	return maxRows
+	return owner, folder, fileName, true, nil
 }
 
-// Rename a SQLite database.
-func RenameDatabase(userName string, folder string, fileName string, newName string) error {
-	// Save the database settings
+// Returns the guest upload links for a project which haven't expired yet.
+func GuestUploadLinks(owner string, folder string, fileName string) (list []GuestUploadLink, err error) {
 	dbQuery := `
-		UPDATE sqlite_databases
-		SET db_name = $4
-		WHERE user_id = (
+		SELECT link.link_id, link.token, link.expiry_date, link.date_created
+		FROM guest_upload_links AS link
+			JOIN sqlite_databases AS db ON db.db_id = link.db_id
+		WHERE db.user_id = (
 				SELECT user_id
 				FROM users
 				WHERE lower(user_name) = lower($1)
 			)
-			AND folder = $2
-			AND db_name = $3`
-	commandTag, err := pdb.Exec(dbQuery, userName, folder, fileName, newName)
+			AND db.folder = $2
+			AND db.db_name = $3
+			AND db.is_deleted = false
+			AND link.expiry_date > now()
+		ORDER BY link.date_created`
+	rows, err := pdb.Query(dbQuery, owner, folder, fileName)
 	if err != nil {
-		log.Printf("Renaming database '%s%s%s' failed: %v\n", userName, folder, fileName, err)
-		return err
+		Log.Errorf("Retrieving the guest upload links for '%s%s%s' failed: %v", owner, folder, fileName, err)
+		return
 	}
-	if numRows := commandTag.RowsAffected(); numRows != 1 {
-		errMsg := fmt.Sprintf("Wrong number of rows affected (%v) when renaming '%s%s%s' to '%s%s%s'\n",
-			numRows, userName, folder, fileName, userName, folder, newName)
-		log.Printf(errMsg)
-		return errors.New(errMsg)
+	defer rows.Close()
+	for rows.Next() {
+		var l GuestUploadLink
+		err = rows.Scan(&l.ID, &l.Token, &l.Expiry, &l.DateCreated)
+		if err != nil {
+			Log.Errorf("Error retrieving the guest upload links for '%s%s%s': %v", owner, folder, fileName, err)
+			return nil, err
+		}
+		list = append(list, l)
 	}
-
-	// Log the rename
-	log.Printf("Database renamed from '%s%s%s' to '%s%s%s'\n", userName, folder, fileName, userName, folder,
-		newName)
-
-	return nil
+	return
 }
 
-// Saves updated database settings to PostgreSQL.
-func SaveDBSettings(userName string, folder string, fileName string, oneLineDesc string, fullDesc string,
-	defaultTable string, public bool, sourceURL string, defaultBranch string) error {
-	// Check for values which should be NULL
-	var nullable1LineDesc, nullableFullDesc, nullableSourceURL pgx.NullString
-	if oneLineDesc == "" {
-		nullable1LineDesc.Valid = false
-	} else {
-		nullable1LineDesc.String = oneLineDesc
-		nullable1LineDesc.Valid = true
-	}
-	if fullDesc == "" {
-		nullableFullDesc.Valid = false
-	} else {
-		nullableFullDesc.String = fullDesc
-		nullableFullDesc.Valid = true
-	}
-	if sourceURL == "" {
-		nullableSourceURL.Valid = false
-	} else {
-		nullableSourceURL.String = sourceURL
-		nullableSourceURL.Valid = true
-	}
-
-	// Save the database settings
-	SQLQuery := `
-		UPDATE sqlite_databases
-		SET one_line_description = $4, full_description = $5, default_table = $6, public = $7, source_url = $8,
-			default_branch = $9
-		WHERE user_id = (
+// Returns the files sent to a project through guest upload links, which are waiting for the owner to approve or
+// reject them.
+func GuestUploads(owner string, folder string, fileName string) (list []GuestUploadEntry, err error) {
+	dbQuery := `
+		SELECT up.upload_id, up.uploader_name, coalesce(up.uploader_email, ''), coalesce(up.message, ''), up.sha256,
+			up.size, up.date_uploaded
+		FROM guest_uploads AS up
+			JOIN sqlite_databases AS db ON db.db_id = up.db_id
+		WHERE db.user_id = (
 				SELECT user_id
 				FROM users
 				WHERE lower(user_name) = lower($1)
 			)
-			AND folder = $2
-			AND db_name = $3`
-	commandTag, err := pdb.Exec(SQLQuery, userName, folder, fileName, nullable1LineDesc, nullableFullDesc, defaultTable,
-		public, nullableSourceURL, defaultBranch)
+			AND db.folder = $2
+			AND db.db_name = $3
+			AND db.is_deleted = false
+		ORDER BY up.date_uploaded`
+	rows, err := pdb.Query(dbQuery, owner, folder, fileName)
 	if err != nil {
-		log.Printf("Updating description for database '%s%s%s' failed: %v\n", userName, folder,
-			fileName, err)
-		return err
+		Log.Errorf("Retrieving the guest uploads for '%s%s%s' failed: %v", owner, folder, fileName, err)
+		return
 	}
-	if numRows := commandTag.RowsAffected(); numRows != 1 {
-		errMsg := fmt.Sprintf("Wrong number of rows affected (%v) when updating description for '%s%s%s'\n",
-			numRows, userName, folder, fileName)
-		log.Printf(errMsg)
-		return errors.New(errMsg)
+	defer rows.Close()
+	for rows.Next() {
+		var u GuestUploadEntry
+		err = rows.Scan(&u.ID, &u.Name, &u.Email, &u.Message, &u.Sha256, &u.Size, &u.DateUploaded)
+		if err != nil {
+			Log.Errorf("Error retrieving the guest uploads for '%s%s%s': %v", owner, folder, fileName, err)
+			return nil, err
+		}
+		list = append(list, u)
 	}
+	return
+}
 
-	// Invalidate the old memcached entry for the database
-	err = InvalidateCacheEntry(userName, userName, folder, fileName, "") // Empty string indicates "for all versions"
+// Returns the details of a comment being held for moderation.
+func HeldComment(id int64) (c HeldCommentEntry, err error) {
+	dbQuery := `
+		SELECT held.held_id, own.user_name, db.folder, db.db_name, held.disc_id, coalesce(disc.title, ''),
+			coalesce(disc.discussion_type, 0), com.user_name, held.body, held.reason, held.date_created
+		FROM held_comments AS held
+			JOIN sqlite_databases AS db ON db.db_id = held.db_id
+			JOIN users AS own ON own.user_id = db.user_id
+			JOIN users AS com ON com.user_id = held.commenter
+			LEFT JOIN discussions AS disc ON disc.db_id = held.db_id AND disc.disc_id = held.disc_id
+		WHERE held.held_id = $1`
+	var discType int64
+	err = pdb.QueryRow(dbQuery, id).Scan(&c.ID, &c.Owner, &c.Folder, &c.DBName, &c.DiscID, &c.Title, &discType,
+		&c.Commenter, &c.Body, &c.Reason, &c.DateCreated)
 	if err != nil {
-		// Something went wrong when invalidating memcached entries for the database
-		log.Printf("Error when invalidating memcache entries: %s\n", err.Error())
-		return err
+		Log.Errorf("Retrieving held comment '%d' failed: %v", id, err)
 	}
-
-	return nil
+	c.DiscType = DiscussionType(discType)
+	return
 }
 
-// Sends status update emails to people watching databases
-func SendEmails() {
-	// Create Hectane email queue
-	cfg := &queue.Config{
-		Directory:              Conf.Event.EmailQueueDir,
-		DisableSSLVerification: true,
-	}
-	q, err := queue.NewQueue(cfg)
+// Returns the comments being held for moderation, oldest first.
+func HeldComments() (list []HeldCommentEntry, err error) {
+	dbQuery := `
+		SELECT held.held_id, own.user_name, db.folder, db.db_name, held.disc_id, coalesce(disc.title, ''),
+			coalesce(disc.discussion_type, 0), com.user_name, held.body, held.reason, held.date_created
+		FROM held_comments AS held
+			JOIN sqlite_databases AS db ON db.db_id = held.db_id
+			JOIN users AS own ON own.user_id = db.user_id
+			JOIN users AS com ON com.user_id = held.commenter
+			LEFT JOIN discussions AS disc ON disc.db_id = held.db_id AND disc.disc_id = held.disc_id
+		WHERE db.is_deleted = false
+		ORDER BY held.date_created`
+	rows, err := pdb.Query(dbQuery)
 	if err != nil {
-		log.Printf("Couldn't start Hectane queue: %s", err.Error())
+		Log.Errorf("Database query failed: %v", err)
 		return
 	}
-	log.Printf("Created Hectane email queue in '%s'.  Queue processing loop refreshes every %d seconds",
-		Conf.Event.EmailQueueDir, Conf.Event.EmailQueueProcessingDelay)
-
-	for {
-		// Retrieve unsent emails from the email_queue
-		type eml struct {
-			Address string
-			Body    string
-			ID      int64
-			Subject string
-		}
-		var emailList []eml
-		dbQuery := `
-				SELECT email_id, mail_to, subject, body
-				FROM email_queue
-				WHERE sent = false`
-		rows, err := pdb.Query(dbQuery)
+	defer rows.Close()
+	for rows.Next() {
+		var c HeldCommentEntry
+		var discType int64
+		err = rows.Scan(&c.ID, &c.Owner, &c.Folder, &c.DBName, &c.DiscID, &c.Title, &discType, &c.Commenter,
+			&c.Body, &c.Reason, &c.DateCreated)
 		if err != nil {
-			log.Printf("Database query failed: %v", err.Error())
-			return // Abort, as we don't want to continuously resend the same emails
-		}
-		for rows.Next() {
-			var oneRow eml
-			err = rows.Scan(&oneRow.ID, &oneRow.Address, &oneRow.Subject, &oneRow.Body)
-			if err != nil {
-				log.Printf("Error retrieving queued emails: %v", err.Error())
-				rows.Close()
-				return // Abort, as we don't want to continuously resend the same emails
-			}
-			emailList = append(emailList, oneRow)
-		}
-		rows.Close()
-
-		// Send emails
-		for _, j := range emailList {
-			e := &email.Email{
-				From:    "updates@dbhub.io",
-				To:      []string{j.Address},
-				Subject: j.Subject,
-				Text:    j.Body,
-			}
-			msgs, err := e.Messages(q.Storage)
-			if err != nil {
-				log.Printf("Queuing email in Hectane failed: %v", err.Error())
-				return // Abort, as we don't want to continuously resend the same emails
-			}
-			for _, m := range msgs {
-				q.Deliver(m)
-			}
-
-			// Mark message as sent
-			dbQuery := `
-				UPDATE email_queue
-				SET sent = true, sent_timestamp = now()
-				WHERE email_id = $1`
-			commandTag, err := pdb.Exec(dbQuery, j.ID)
-			if err != nil {
-				log.Printf("Changing email status to sent failed for email '%v': '%v'", j.ID, err.Error())
-				return // Abort, as we don't want to continuously resend the same emails
-			}
-			if numRows := commandTag.RowsAffected(); numRows != 1 {
-				log.Printf("Wrong # of rows (%v) affected when changing email status to sent for email '%v'",
-					numRows, j.ID)
-			}
+			Log.Errorf("Error retrieving held comments: %v", err)
+			return
 		}
-
-		// Pause before running the loop again
-		time.Sleep(Conf.Event.EmailQueueProcessingDelay * time.Second)
+		c.DiscType = DiscussionType(discType)
+		list = append(list, c)
 	}
+	return
 }
 
-// Stores a certificate for a given client.
-func SetClientCert(newCert []byte, userName string) error {
-	SQLQuery := `
-		UPDATE users
-		SET client_cert = $1
-		WHERE lower(user_name) = lower($2)`
-	commandTag, err := pdb.Exec(SQLQuery, newCert, userName)
+// Holds a comment for moderation, instead of adding it to its discussion.  If a moderator approves it, it's added to
+// the discussion then.
+func HoldComment(owner string, folder string, fileName string, commenter string, discID int, body string,
+	reason string) error {
+	dbQuery := `
+		INSERT INTO held_comments (db_id, disc_id, commenter, body, reason)
+		SELECT db.db_id, $5, (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($4)
+			), $6, $7
+		FROM sqlite_databases AS db
+		WHERE db.user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND db.folder = $2
+			AND db.db_name = $3
+			AND db.is_deleted = false`
+	commandTag, err := pdb.Exec(dbQuery, owner, folder, fileName, commenter, discID, body, reason)
 	if err != nil {
-		log.Printf("Updating client certificate for '%s' failed: %v\n", userName, err)
+		Log.Errorf("Holding comment by '%s' on '%s%s%s' for moderation failed: %v", commenter, owner, folder,
+			fileName, err)
 		return err
 	}
 	if numRows := commandTag.RowsAffected(); numRows != 1 {
-		errMsg := fmt.Sprintf("Wrong number of rows affected (%v) when storing client cert for '%s'\n",
-			numRows, userName)
-		log.Printf(errMsg)
-		return errors.New(errMsg)
+		return fmt.Errorf("Wrong number of rows (%v) affected when holding comment on '%s%s%s' for moderation",
+			numRows, owner, folder, fileName)
 	}
-
 	return nil
 }
 
-// Sets the user's preference for maximum number of SQLite rows to display.
-func SetUserPreferences(userName string, maxRows int, displayName string, email string) error {
-	dbQuery := `
-		UPDATE users
-		SET pref_max_rows = $2, display_name = $3, email = $4
-		WHERE lower(user_name) = lower($1)`
-	commandTag, err := pdb.Exec(dbQuery, userName, maxRows, displayName, email)
+// Hides a project until a moderator has looked at it, adding an automatic report to the moderation queue explaining
+// why.
+func HoldProject(owner string, folder string, fileName string, reason string) error {
+	tx, err := pdb.Begin()
 	if err != nil {
-		log.Printf("Updating user preferences failed for user '%s'. Error: '%v'\n", userName, err)
 		return err
 	}
-	if numRows := commandTag.RowsAffected(); numRows != 1 {
-		log.Printf("Wrong # of rows (%v) affected when updating user preferences. User: '%s'\n", numRows,
-			userName)
-	}
-	return nil
-}
-
-// Retrieve the latest social stats for a given database.
-func SocialStats(owner string, folder string, fileName string) (wa int, st int, fo int, err error) {
-
-	// TODO: Implement caching of these stats
+	// Set up an automatic transaction roll back if the function exits without committing
+	defer tx.Rollback()
 
-	// Retrieve latest star count
 	dbQuery := `
-		SELECT stars
-		FROM sqlite_databases
+		UPDATE sqlite_databases
+		SET moderation_status = 'hidden'
 		WHERE user_id = (
 				SELECT user_id
 				FROM users
 				WHERE lower(user_name) = lower($1)
 			)
 			AND folder = $2
-			AND db_name = $3`
-	err = pdb.QueryRow(dbQuery, owner, folder, fileName).Scan(&st)
+			AND db_name = $3
+			AND is_deleted = false
+		RETURNING db_id`
+	var dbID int64
+	err = tx.QueryRow(dbQuery, owner, folder, fileName).Scan(&dbID)
 	if err != nil {
-		log.Printf("Error retrieving star count for '%s%s%s': %v\n", owner, folder, fileName, err)
-		return -1, -1, -1, err
+		Log.Errorf("Hiding project '%s%s%s' for moderation failed: %v", owner, folder, fileName, err)
+		return err
 	}
-
-	// Retrieve latest fork count
 	dbQuery = `
-		SELECT forks
-		FROM sqlite_databases
-		WHERE db_id = (
-				SELECT root_database
-				FROM sqlite_databases
-				WHERE user_id = (
-					SELECT user_id
-					FROM users
-					WHERE lower(user_name) = lower($1)
-					)
-			AND folder = $2
-			AND db_name = $3)`
-	err = pdb.QueryRow(dbQuery, owner, folder, fileName).Scan(&fo)
+		INSERT INTO project_reports (db_id, reason, automatic)
+		VALUES ($1, $2, true)`
+	_, err = tx.Exec(dbQuery, dbID, reason)
 	if err != nil {
-		log.Printf("Error retrieving fork count for '%s%s%s': %v\n", owner, folder, fileName, err)
-		return -1, -1, -1, err
+		Log.Errorf("Adding automatic report for project '%s%s%s' failed: %v", owner, folder, fileName, err)
+		return err
 	}
+	return tx.Commit()
+}
 
-	// TODO: Implement watchers
-	return 0, st, fo, nil
+// Returns the accounts which haven't been used for at least the given number of years, and have nothing on the site,
+// longest unused first.  Accounts already being reclaimed aren't included.
+func InactiveAccounts(years int, limit int) (list []InactiveAccount, err error) {
+	dbQuery := `
+		SELECT u.user_name, coalesce(u.email, ''), u.date_joined, u.last_seen
+		FROM users AS u
+		WHERE u.user_name != 'default'
+			AND coalesce(u.last_seen, u.date_joined) < now() - $1::integer * interval '1 year'
+			AND NOT EXISTS (SELECT 1 FROM username_reclamations AS rc WHERE rc.user_id = u.user_id)
+			AND ` + userHasNoContent + `
+		ORDER BY coalesce(u.last_seen, u.date_joined)
+		LIMIT $2`
+	rows, err := pdb.Query(dbQuery, years, limit)
+	if err != nil {
+		Log.Errorf("Retrieving the inactive accounts failed: %v", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var oneRow InactiveAccount
+		var lastSeen pgx.NullTime
+		err = rows.Scan(&oneRow.UserName, &oneRow.Email, &oneRow.DateJoined, &lastSeen)
+		if err != nil {
+			Log.Errorf("Error retrieving the inactive accounts: %v", err)
+			return nil, err
+		}
+		if lastSeen.Valid {
+			oneRow.LastSeen = lastSeen.Time
+		}
+		list = append(list, oneRow)
+	}
+	return
 }
 
-// Retrieve the list of outstanding status updates for a user
-func StatusUpdates(loggedInUser string) (statusUpdates map[string][]StatusUpdateEntry, err error) {
+// Returns the transfers of projects to a user which are waiting for them to accept or decline.
+func IncomingTransfers(userName string) (list []ProjectTransfer, err error) {
 	dbQuery := `
-		SELECT status_updates
-		FROM users
-		WHERE user_name = $1`
-	err = pdb.QueryRow(dbQuery, loggedInUser).Scan(&statusUpdates)
+		SELECT tran.db_id, own.user_name, db.folder, db.db_name, tran.date_requested, db.public
+		FROM project_transfers AS tran
+			JOIN sqlite_databases AS db ON db.db_id = tran.db_id
+			JOIN users AS own ON own.user_id = db.user_id
+		WHERE tran.to_user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND db.is_deleted = false
+		ORDER BY tran.date_requested`
+	rows, err := pdb.Query(dbQuery, userName)
 	if err != nil {
-		log.Printf("Error retrieving status updates list for user '%s': %v", loggedInUser, err)
+		Log.Errorf("Retrieving the incoming project transfers for '%s' failed: %v", userName, err)
 		return
 	}
+	defer rows.Close()
+	for rows.Next() {
+		t := ProjectTransfer{To: userName}
+		err = rows.Scan(&t.ID, &t.Owner, &t.Folder, &t.FileName, &t.DateRequested, &t.Public)
+		if err != nil {
+			Log.Errorf("Error retrieving the incoming project transfers for '%s': %v", userName, err)
+			return nil, err
+		}
+		list = append(list, t)
+	}
 	return
 }
 
-// Periodically generates status updates (alert emails TBD) from the event queue
-func StatusUpdatesLoop() {
-	// Ensure a warning message is displayed on the console if the status update loop exits
-	defer func() {
-		log.Printf("WARN: Status update loop exited")
-	}()
-
-	// Log the start of the loop
-	log.Printf("Status update processing loop started.  %d second refresh.", Conf.Event.Delay)
+// Increments the download count for a database
+func IncrementDownloadCount(owner string, folder string, fileName string) error {
+	dbQuery := `
+		UPDATE sqlite_databases
+		SET download_count = download_count + 1
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND folder = $2
+			AND db_name = $3`
+	commandTag, err := pdb.Exec(dbQuery, owner, folder, fileName)
+	if err != nil {
+		Log.Errorf("Increment download count for '%s%s%s' failed: %v", owner, folder,
+			fileName, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		errMsg := fmt.Sprintf("Wrong number of rows affected (%v) when incrementing download count for '%s%s%s'\n",
+			numRows, owner, folder, fileName)
+		Log.Errorf(errMsg)
+		return errors.New(errMsg)
+	}
+	return nil
+}
 
-	// Start the endless status update processing loop
-	var rows *pgx.Rows
-	var err error
-	type evEntry struct {
-		dbID      int64
-		details   EventDetails
-		eType     EventType
-		eventID   int64
-		timeStamp time.Time
+// Returns the IP address and network allow and block lists.
+func IPRules() (list []IPRule, err error) {
+	dbQuery := `
+		SELECT rule_id, network, action, coalesce(reason, ''), created_by, date_created
+		FROM ip_rules
+		ORDER BY action, network`
+	rows, err := pdb.Query(dbQuery)
+	if err != nil {
+		Log.Errorf("Database query failed: %v", err)
+		return
 	}
-	for {
-		// Begin a transaction
-		var tx *pgx.Tx
-		tx, err = pdb.Begin()
+	defer rows.Close()
+	for rows.Next() {
+		var oneRow IPRule
+		err = rows.Scan(&oneRow.ID, &oneRow.Network, &oneRow.Action, &oneRow.Reason, &oneRow.CreatedBy,
+			&oneRow.DateCreated)
 		if err != nil {
-			log.Printf("Couldn't begin database transaction for status update processing loop: %s", err.Error())
-			continue
+			Log.Errorf("Error retrieving IP rules: %v", err)
+			return
 		}
+		list = append(list, oneRow)
+	}
+	return
+}
 
-		// Retrieve the list of outstanding events
-		// NOTE - We gather the db_id here instead of owner/folder/fileName as it should be faster for PG to deal
-		//        with when generating the watcher list
-		dbQuery := `
-			SELECT event_id, event_timestamp, db_id, event_type, event_data
-			FROM events
-			ORDER BY event_id ASC`
-		rows, err = tx.Query(dbQuery)
+// Returns the number of waiting, running, and dead background jobs of each type.
+func JobCounts() (list []JobTypeCount, err error) {
+	dbQuery := `
+		SELECT job_type, count(*) FILTER (WHERE status = 'queued'), count(*) FILTER (WHERE status = 'running'),
+			count(*) FILTER (WHERE status = 'dead')
+		FROM background_jobs
+		GROUP BY job_type
+		ORDER BY job_type`
+	rows, err := pdb.Query(dbQuery)
+	if err != nil {
+		Log.Errorf("Database query failed: %v", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var oneRow JobTypeCount
+		var queued, running, dead int64
+		err = rows.Scan(&oneRow.Type, &queued, &running, &dead)
 		if err != nil {
-			log.Printf("Generating status update event list failed: %v\n", err)
-			tx.Rollback()
-			continue
-		}
-		evList := make(map[int64]evEntry)
-		for rows.Next() {
-			var ev evEntry
-			err = rows.Scan(&ev.eventID, &ev.timeStamp, &ev.dbID, &ev.eType, &ev.details)
-			if err != nil {
-				log.Printf("Error retrieving event list for status updates thread: %v\n", err)
-				rows.Close()
-				tx.Rollback()
-				continue
-			}
-			evList[ev.eventID] = ev
+			Log.Errorf("Error retrieving background job counts: %v", err)
+			return
 		}
-		rows.Close()
+		oneRow.Queued, oneRow.Running, oneRow.Dead = int(queued), int(running), int(dead)
+		list = append(list, oneRow)
+	}
+	return
+}
 
-		// For each event, add a status update to the status_updates list for each watcher it's for
-		for id, ev := range evList {
-			// Retrieve the list of watchers for the database the event occurred on
-			dbQuery := `
-				SELECT user_id
-				FROM watchers
-				WHERE db_id = $1`
-			rows, err = tx.Query(dbQuery, ev.dbID)
-			if err != nil {
-				log.Printf("Database query failed: %v\n", err)
-				tx.Rollback()
-				continue
-			}
-			var users []int64
-			for rows.Next() {
-				var user int64
-				err = rows.Scan(&user)
-				if err != nil {
-					log.Printf("Error retrieving user list for status updates thread: %v\n", err)
-					rows.Close()
-					tx.Rollback()
-					continue
-				}
-				users = append(users, user)
-			}
-			rows.Close()
+// Returns when the most recent backup was queued, or the zero time if there haven't been any.
+func LastBackupQueued() (queued time.Time, err error) {
+	dbQuery := `
+		SELECT max(date_queued)
+		FROM backup_runs`
+	var t pgx.NullTime
+	err = pdb.QueryRow(dbQuery).Scan(&t)
+	if err != nil {
+		Log.Errorf("Retrieving the time of the last backup failed: %v", err)
+		return
+	}
+	if t.Valid {
+		queued = t.Time
+	}
+	return
+}
 
-			// For each watcher, add the new status update to their existing list
-			// TODO: It might be better to store this list in Memcached instead of hitting the database like this
-			for _, u := range users {
-				// Retrieve the current status updates list for the user
-				var eml pgx.NullString
-				dbQuery := `
-					SELECT user_name, email, status_updates
+// Links a user to their Stripe customer and subscription, after they've subscribed using one of our payment links.
+func LinkStripeCustomer(userName string, customer string, subscription string) error {
+	dbQuery := `
+		UPDATE users
+		SET stripe_customer = $2, stripe_subscription = nullif($3, '')
+		WHERE lower(user_name) = lower($1)`
+	commandTag, err := pdb.Exec(dbQuery, userName, customer, subscription)
+	if err != nil {
+		Log.Errorf("Linking user '%s' to Stripe customer '%s' failed: %v", userName, customer, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		return fmt.Errorf("Wrong number of rows (%v) affected when linking user '%s' to Stripe customer '%s'",
+			numRows, userName, customer)
+	}
+	return nil
+}
+
+// Create a download log entry
+func LogDownload(owner string, folder string, fileName string, loggedInUser string, ipAddr string, serverSw string,
+	userAgent string, downloadDate time.Time, sha string) error {
+	// If the downloader isn't a logged in user, use a NULL value for that column
+	var downloader pgx.NullString
+	if loggedInUser != "" {
+		downloader.String = loggedInUser
+		downloader.Valid = true
+	}
+
+	// Store the download details
+	dbQuery := `
+		WITH d AS (
+			SELECT db.db_id, db.folder, db.db_name
+			FROM sqlite_databases AS db
+			WHERE user_id = (
+					SELECT user_id
 					FROM users
-					WHERE user_id = $1`
-				userEvents := make(map[string][]StatusUpdateEntry)
-				var userName string
-				err := tx.QueryRow(dbQuery, u).Scan(&userName, &eml, &userEvents)
-				if err != nil {
-					log.Printf("Database query failed: %v\n", err)
-					tx.Rollback()
-					continue
-				}
+					WHERE lower(user_name) = lower($1)
+				)
+				AND db.folder = $2
+				AND db.db_name = $3
+		)
+		INSERT INTO database_downloads (db_id, user_id, ip_addr, server_sw, user_agent, download_date, db_sha256)
+		SELECT (SELECT db_id FROM d), (SELECT user_id FROM users WHERE lower(user_name) = lower($4)), $5, $6, $7, $8, $9`
+	commandTag, err := pdb.Exec(dbQuery, owner, folder, fileName, downloader, ipAddr, serverSw, userAgent,
+		downloadDate, sha)
+	if err != nil {
+		Log.Errorf("Storing record of download '%s%s%s', sha '%s' by '%s' failed: %v", owner, folder,
+			fileName, sha, downloader, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		Log.Warnf("Wrong number of rows (%v) affected while storing download record for '%s%s%s'", numRows,
+			owner, folder, fileName)
+	}
 
-				// * Add the new event to the users status updates list *
+	// Add it to the per version download statistics.  Downloads by the project owner aren't counted, the same as for
+	// the download count
+	if strings.ToLower(loggedInUser) != strings.ToLower(owner) {
+		RecordProjectDownload(owner, folder, fileName, sha)
+	}
+	return nil
+}
 
-				// Group the status updates by database, and coalesce multiple updates for the same discussion or MR
-				// into a single entry (keeping the most recent one of each)
-				fileName := fmt.Sprintf("%s%s%s", ev.details.Owner, ev.details.Folder, ev.details.DBName)
-				var a StatusUpdateEntry
-				lst, ok := userEvents[fileName]
-				if ev.details.Type == EVENT_NEW_DISCUSSION || ev.details.Type == EVENT_NEW_MERGE_REQUEST || ev.details.Type == EVENT_NEW_COMMENT {
-					if ok {
-						// Check if an entry already exists for the discussion/MR/comment
-						for i, j := range lst {
-							if j.DiscID == ev.details.DiscID {
-								// Yes, there's already an existing entry for the discussion/MR/comment so delete the old entry
-								lst = append(lst[:i], lst[i+1:]...) // Delete the old element
-							}
-						}
-					}
-				}
-				// Add the new entry
-				a.DiscID = ev.details.DiscID
-				a.Title = ev.details.Title
-				a.URL = ev.details.URL
-				lst = append(lst, a)
-				userEvents[fileName] = lst
-
-				// Save the updated list for the user back to PG
-				dbQuery = `
-					UPDATE users
-					SET status_updates = $2
-					WHERE user_id = $1`
-				commandTag, err := tx.Exec(dbQuery, u, userEvents)
-				if err != nil {
-					log.Printf("Adding status update for database ID '%d' to user '%s' failed: %v", ev.dbID,
-						u, err)
-					tx.Rollback()
-					continue
-				}
-				if numRows := commandTag.RowsAffected(); numRows != 1 {
-					log.Printf("Wrong number of rows affected (%v) when adding status update for database ID "+
-						"'%d' to user '%s'", numRows, ev.dbID, u)
-					tx.Rollback()
-					continue
-				}
+// Create an upload log entry
+func LogUpload(owner string, folder string, fileName string, loggedInUser string, ipAddr string, serverSw string,
+	userAgent string, uploadDate time.Time, sha string) error {
+	// If the uploader isn't a logged in user, use a NULL value for that column
+	var uploader pgx.NullString
+	if loggedInUser != "" {
+		uploader.String = loggedInUser
+		uploader.Valid = true
+	}
 
-				// Count the number of status updates for the user, to be displayed in the webUI header row
-				var numUpdates int
-				for _, i := range userEvents {
-					numUpdates += len(i)
-				}
+	// Store the upload details
+	dbQuery := `
+		WITH d AS (
+			SELECT db.db_id, db.folder, db.db_name
+			FROM sqlite_databases AS db
+			WHERE user_id = (
+					SELECT user_id
+					FROM users
+					WHERE lower(user_name) = lower($1)
+				)
+				AND db.folder = $2
+				AND db.db_name = $3
+		)
+		INSERT INTO database_uploads (db_id, user_id, ip_addr, server_sw, user_agent, upload_date, db_sha256)
+		SELECT (SELECT db_id FROM d), (SELECT user_id FROM users WHERE lower(user_name) = lower($4)), $5, $6, $7, $8, $9`
+	commandTag, err := pdb.Exec(dbQuery, owner, folder, fileName, uploader, ipAddr, serverSw, userAgent,
+		uploadDate, sha)
+	if err != nil {
+		Log.Errorf("Storing record of upload '%s%s%s', sha '%s' by '%s' failed: %v", owner, folder,
+			fileName, sha, uploader, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		Log.Warnf("Wrong number of rows (%v) affected while storing upload record for '%s%s%s'", numRows,
+			owner, folder, fileName)
+	}
+	return nil
+}
 
-				// Add an entry to memcached for the user, indicating they have outstanding status updates available
-				err = SetUserStatusUpdates(userName, numUpdates)
-				if err != nil {
-					log.Printf("Error when updating user status updates # in memcached: %v", err)
-					continue
-				}
+// Turns a newly uploaded project into a draft.  Drafts can only be seen by their owner until they're published, at
+// which point the public setting given here applies.
+func MakeProjectDraft(owner string, folder string, fileName string, public bool) error {
+	dbQuery := `
+		UPDATE sqlite_databases
+		SET is_draft = true, public = $4
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND folder = $2
+			AND db_name = $3
+			AND is_deleted = false`
+	commandTag, err := pdb.Exec(dbQuery, owner, folder, fileName, public)
+	if err != nil {
+		Log.Errorf("Making '%s%s%s' a draft failed: %v", owner, folder, fileName, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		errMsg := fmt.Sprintf("Wrong number of rows (%v) affected when making '%s%s%s' a draft", numRows, owner,
+			folder, fileName)
+		Log.Error(errMsg)
+		return errors.New(errMsg)
+	}
+	return nil
+}
 
-				// TODO: Add a email for the status notification to the outgoing email queue
-				var msg, subj string
-				switch ev.details.Type {
-				case EVENT_NEW_DISCUSSION:
-					msg = fmt.Sprintf("A new discussion has been created for %s%s%s.\n\nVisit https://%s%s "+
-						"for the details", ev.details.Owner, ev.details.Folder, ev.details.DBName, Conf.Web.ServerName,
-						ev.details.URL)
-					subj = fmt.Sprintf("DBHub.io: New discussion created on %s%s%s", ev.details.Owner,
-						ev.details.Folder, ev.details.DBName)
-				case EVENT_NEW_MERGE_REQUEST:
-					msg = fmt.Sprintf("A new merge request has been created for %s%s%s.\n\nVisit https://%s%s "+
-						"for the details", ev.details.Owner, ev.details.Folder, ev.details.DBName, Conf.Web.ServerName,
-						ev.details.URL)
-					subj = fmt.Sprintf("DBHub.io: New merge request created on %s%s%s", ev.details.Owner,
-						ev.details.Folder, ev.details.DBName)
-				case EVENT_NEW_COMMENT:
+// Return the Minio bucket and ID for a given database. owner, folder, & fileName are from owner/folder/database URL
+// fragment, // loggedInUser is the name for the currently logged in user, for access permission check.  Use an empty
+// string ("") as the loggedInUser parameter if the true value isn't set or known.
+// If the requested database doesn't exist, or the loggedInUser doesn't have access to it, then an error will be
+// returned.
+func MinioLocation(owner string, folder string, fileName string, commitID string, loggedInUser string) (minioBucket string,
+	minioID string, lastModified time.Time, err error) {
+
+	// TODO: This will likely need updating to query the "database_files" table to retrieve the Minio server name
+
+	// If no commit was provided, we grab the default one
+	if commitID == "" {
+		commitID, err = DefaultCommit(owner, folder, fileName)
+		if err != nil {
+			return // Bucket and ID are still the initial default empty string
+		}
+	}
+
+	// Retrieve the sha256 and last modified date for the requested commit's database file
+	var dbQuery string
+	dbQuery = `
+		SELECT commit_list->$4::text->'tree'->'entries'->0->'sha256' AS sha256,
+			commit_list->$4::text->'tree'->'entries'->0->'last_modified' AS last_modified
+		FROM sqlite_databases AS db
+		WHERE db.user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND db.folder = $2
+			AND db.db_name = $3
+			AND db.is_deleted = false`
+
+	// If the request is for another users database, it needs to be a public one
+	if strings.ToLower(loggedInUser) != strings.ToLower(owner) {
+		dbQuery += `
+				AND ` + publicProject("db")
+	}
+
+	var sha, mod string
+	err = pdb.QueryRow(dbQuery, owner, folder, fileName, commitID).Scan(&sha, &mod)
+	if err != nil {
+		Log.Errorf("Error retrieving MinioID for %s/%s version %v: %v", owner, fileName, commitID, err)
+		return // Bucket and ID are still the initial default empty string
+	}
+
+	if sha == "" {
+		// The requested database doesn't exist, or the logged in user doesn't have access to it
+		err = fmt.Errorf("The requested database wasn't found")
+		return // Bucket and ID are still the initial default empty string
+	}
+
+	lastModified, err = time.Parse(time.RFC3339, mod)
+	if err != nil {
+		return // Bucket and ID are still the initial default empty string
+	}
+
+	minioBucket = sha[:MinioFolderChars]
+	minioID = sha[MinioFolderChars:]
+	return
+}
+
+// Returns the projects waiting for a moderator.  These are the public projects which haven't been moderated yet, along
+// with any projects having unresolved reports about them.  Reported projects are listed first.
+func ModerationQueue() (list []ModerationEntry, err error) {
+	dbQuery := `
+		SELECT own.user_name, db.folder, db.db_name, coalesce(db.one_line_description, ''), db.date_created,
+			db.public, db.moderation_status, coalesce(rep.reports, '[]')
+		FROM sqlite_databases AS db
+			JOIN users AS own ON own.user_id = db.user_id
+			LEFT JOIN (
+				SELECT r.db_id, json_agg(json_build_object('Automatic', r.automatic, 'DateReported', r.date_reported,
+					'Reason', r.reason, 'Reporter', coalesce(u.user_name, '')) ORDER BY r.date_reported) AS reports
+				FROM project_reports AS r
+					LEFT JOIN users AS u ON u.user_id = r.reporter_id
+				WHERE r.resolved = false
+				GROUP BY r.db_id
+			) AS rep ON rep.db_id = db.db_id
+		WHERE db.is_deleted = false
+			AND ((db.public = true AND db.is_draft = false AND db.moderation_status = 'pending')
+				OR rep.db_id IS NOT NULL)
+		ORDER BY rep.db_id IS NULL, db.date_created`
+	rows, err := pdb.Query(dbQuery)
+	if err != nil {
+		Log.Errorf("Database query failed: %v", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var oneRow ModerationEntry
+		err = rows.Scan(&oneRow.Owner, &oneRow.Folder, &oneRow.DBName, &oneRow.OneLineDesc, &oneRow.DateCreated,
+			&oneRow.Public, &oneRow.Status, &oneRow.Reports)
+		if err != nil {
+			Log.Errorf("Error retrieving moderation queue: %v", err)
+			return
+		}
+		list = append(list, oneRow)
+	}
+	return
+}
+
+// Adds an event entry to PostgreSQL
+func NewEvent(details EventDetails) (err error) {
+	dbQuery := `
+		WITH d AS (
+			SELECT db_id
+			FROM sqlite_databases
+			WHERE user_id = (
+					SELECT user_id
+					FROM users
+					WHERE lower(user_name) = lower($1)
+				)
+				AND folder = $2
+				AND db_name = $3
+				AND is_deleted = false
+		)
+		INSERT INTO events (db_id, event_type, event_data)
+		VALUES ((SELECT db_id FROM d), $4, $5)`
+	_, err = pdb.Exec(dbQuery, details.Owner, details.Folder, details.DBName, details.Type, details)
+	if err != nil {
+		return err
+	}
+	return
+}
+
+// Returns which kinds of activity a user wants to hear about, and how, in the order of NotificationEvents.  Anything
+// the user hasn't changed is turned on.
+func NotificationPrefs(userName string) (list []NotificationPref, err error) {
+	dbQuery := `
+		SELECT np.event_type, np.email, np.in_app
+		FROM notification_prefs AS np, users AS u
+		WHERE np.user_id = u.user_id
+			AND lower(u.user_name) = lower($1)`
+	rows, err := pdb.Query(dbQuery, userName)
+	if err != nil {
+		Log.Errorf("Database query failed: %v", err)
+		return
+	}
+	defer rows.Close()
+	stored := make(map[EventType]NotificationPref)
+	for rows.Next() {
+		var p NotificationPref
+		err = rows.Scan(&p.Type, &p.Email, &p.InApp)
+		if err != nil {
+			Log.Errorf("Error retrieving notification preferences for '%s': %v", userName, err)
+			return
+		}
+		stored[p.Type] = p
+	}
+	for _, e := range NotificationEvents {
+		p, ok := stored[e.Type]
+		if !ok {
+			p = NotificationPref{Email: true, InApp: true}
+		}
+		p.Name, p.Type = e.Name, e.Type
+		list = append(list, p)
+	}
+	return
+}
+
+// Returns the OctoPrint instance a user has linked, if any.  The API key is still encrypted.
+func OctoPrintLinkDetails(userName string) (link OctoPrintLink, found bool, err error) {
+	dbQuery := `
+		SELECT url, api_key, date_linked, last_used
+		FROM octoprint_links
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)`
+	var lastUsed pgx.NullTime
+	err = pdb.QueryRow(dbQuery, userName).Scan(&link.URL, &link.APIKey, &link.DateLinked, &lastUsed)
+	if err == pgx.ErrNoRows {
+		return link, false, nil
+	}
+	if err != nil {
+		Log.Errorf("Retrieving the OctoPrint link of '%s' failed: %v", userName, err)
+		return
+	}
+	if lastUsed.Valid {
+		link.LastUsed = lastUsed.Time
+	}
+	return link, true, nil
+}
+
+// Records a user's linked OctoPrint instance being sent something.
+func OctoPrintLinkUsed(userName string) error {
+	dbQuery := `
+		UPDATE octoprint_links
+		SET last_used = now()
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)`
+	_, err := pdb.Exec(dbQuery, userName)
+	if err != nil {
+		Log.Errorf("Recording the use of the OctoPrint link of '%s' failed: %v", userName, err)
+	}
+	return err
+}
+
+// Returns a user's change of email address which is waiting to be confirmed, if there is one.
+func PendingEmailChange(userName string) (change EmailChange, found bool, err error) {
+	dbQuery := `
+		SELECT u.user_name, coalesce(u.email, ''), ec.new_email, ec.old_token IS NULL, ec.new_token IS NULL,
+			ec.date_requested, ec.expires
+		FROM email_changes AS ec
+			JOIN users AS u ON u.user_id = ec.user_id
+		WHERE lower(u.user_name) = lower($1)
+			AND ec.expires > now()`
+	err = pdb.QueryRow(dbQuery, userName).Scan(&change.UserName, &change.OldEmail, &change.NewEmail,
+		&change.OldConfirmed, &change.NewConfirmed, &change.DateRequested, &change.Expires)
+	if err == pgx.ErrNoRows {
+		return change, false, nil
+	}
+	if err != nil {
+		Log.Errorf("Retrieving the pending email change for user '%s' failed: %v", userName, err)
+		return
+	}
+	return change, true, nil
+}
+
+// Returns the public projects with the most views over the last given number of days, most viewed first.
+func PopularProjects(days int, limit int) (list []ProjectRef, err error) {
+	dbQuery := `
+		SELECT u.user_name, db.folder, db.db_name
+		FROM project_daily_views AS v, sqlite_databases AS db, users AS u
+		WHERE v.db_id = db.db_id
+			AND db.user_id = u.user_id
+			AND v.stat_date > (now() AT TIME ZONE 'UTC')::date - $1::integer
+			AND db.is_deleted = false
+			AND ` + publicProject("db") + `
+		GROUP BY u.user_name, db.folder, db.db_name
+		ORDER BY sum(v.views) DESC, u.user_name, db.folder, db.db_name
+		LIMIT $2`
+	rows, err := pdb.Query(dbQuery, days, limit)
+	if err != nil {
+		Log.Errorf("Database query failed: %v", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var oneRow ProjectRef
+		err = rows.Scan(&oneRow.Owner, &oneRow.Folder, &oneRow.DBName)
+		if err != nil {
+			Log.Errorf("Error retrieving popular project list: %v", err)
+			return
+		}
+		list = append(list, oneRow)
+	}
+	return
+}
+
+// Returns the timezone and date format a user has chosen for showing dates.  An empty timezone means the timezone of
+// their browser is used.
+func PrefUserDates(userName string) (timeZone string, dateFormat string) {
+	dbQuery := `
+		SELECT coalesce(pref_timezone, ''), coalesce(pref_date_format, '')
+		FROM users
+		WHERE lower(user_name) = lower($1)`
+	err := pdb.QueryRow(dbQuery, userName).Scan(&timeZone, &dateFormat)
+	if err != nil {
+		Log.Errorf("Error retrieving user '%s' date preferences: %v", userName, err)
+		return "", DateFormats[0].ID
+	}
+	if dateFormat == "" {
+		dateFormat = DateFormats[0].ID
+	}
+	return
+}
+
+// Returns how often a user wants to be emailed about activity on the projects they watch.
+func PrefUserEmailFrequency(userName string) EmailFrequency {
+	dbQuery := `
+		SELECT coalesce(pref_email_frequency, 'instant')
+		FROM users
+		WHERE lower(user_name) = lower($1)`
+	var freq EmailFrequency
+	err := pdb.QueryRow(dbQuery, userName).Scan(&freq)
+	if err != nil {
+		Log.Errorf("Error retrieving user '%s' email frequency preference: %v", userName, err)
+		return EMAIL_INSTANT
+	}
+	return freq
+}
+
+// Returns the language a user has chosen for the web pages, or an empty string if they haven't picked one.
+func PrefUserLocale(userName string) string {
+	dbQuery := `
+		SELECT coalesce(pref_locale, '')
+		FROM users
+		WHERE lower(user_name) = lower($1)`
+	var locale string
+	err := pdb.QueryRow(dbQuery, userName).Scan(&locale)
+	if err != nil {
+		Log.Errorf("Error retrieving user '%s' language preference: %v", userName, err)
+		return ""
+	}
+	return locale
+}
+
+// Return the user's preference for maximum number of SQLite rows to display.
+func PrefUserMaxRows(loggedInUser string) int {
+	// Retrieve the user preference data
+	dbQuery := `
+		SELECT pref_max_rows
+		FROM users
+		WHERE user_id = (
+			SELECT user_id
+			FROM users
+			WHERE lower(user_name) = lower($1))`
+	var maxRows int
+	err := pdb.QueryRow(dbQuery, loggedInUser).Scan(&maxRows)
+	if err != nil {
+		Log.Errorf("Error retrieving user '%s' preference data: %v", loggedInUser, err)
+		return DefaultNumDisplayRows // Use the default value
+	}
+
+	return maxRows
+}
+
+// Returns the colour theme a user has chosen for the web pages.
+func PrefUserTheme(userName string) Theme {
+	dbQuery := `
+		SELECT coalesce(pref_theme, 'auto')
+		FROM users
+		WHERE lower(user_name) = lower($1)`
+	var theme string
+	err := pdb.QueryRow(dbQuery, userName).Scan(&theme)
+	if err != nil {
+		Log.Errorf("Error retrieving user '%s' theme preference: %v", userName, err)
+		return THEME_AUTO
+	}
+	return Theme(theme)
+}
+
+// Returns the number of private projects a user owns.  Drafts count as private, as nobody else can see them either.
+func PrivateProjectCount(userName string) (count int, err error) {
+	dbQuery := `
+		SELECT count(*)
+		FROM sqlite_databases
+		WHERE user_id = (SELECT user_id FROM users WHERE lower(user_name) = lower($1))
+			AND (public = false OR is_draft = true)
+			AND is_deleted = false`
+	err = pdb.QueryRow(dbQuery, userName).Scan(&count)
+	if err != nil {
+		Log.Errorf("Counting the private projects of user '%s' failed: %v", userName, err)
+	}
+	return
+}
+
+// Returns the newest entries in a project's access log, optionally only those of one kind.
+func ProjectAccessLog(owner string, folder string, fileName string, kind string, limit int) (list []ProjectAccess,
+	err error) {
+	dbQuery := `
+		SELECT log.access_time, log.kind, log.method, log.path, log.status, log.client, coalesce(log.user_agent, '')
+		FROM project_access_log AS log
+			JOIN sqlite_databases AS db ON db.db_id = log.db_id
+		WHERE db.user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND db.folder = $2
+			AND db.db_name = $3
+			AND db.is_deleted = false
+			AND ($4 = '' OR log.kind = $4)
+		ORDER BY log.access_time DESC
+		LIMIT $5`
+	rows, err := pdb.Query(dbQuery, owner, folder, fileName, kind, limit)
+	if err != nil {
+		Log.Errorf("Retrieving the access log for '%s%s%s' failed: %v", owner, folder, fileName, err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var a ProjectAccess
+		err = rows.Scan(&a.Time, &a.Kind, &a.Method, &a.Path, &a.Status, &a.Client, &a.UserAgent)
+		if err != nil {
+			Log.Errorf("Error retrieving the access log for '%s%s%s': %v", owner, folder, fileName, err)
+			return nil, err
+		}
+		list = append(list, a)
+	}
+	return
+}
+
+// Returns the result of the most recent re-analysis of a project's file.
+func ProjectAnalysis(owner string, folder string, fileName string) (result AnalysisResult, err error) {
+	dbQuery := `
+		SELECT analysed_at, analysis_problem
+		FROM sqlite_databases
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND folder = $2
+			AND db_name = $3
+			AND is_deleted = false`
+	var date pgx.NullTime
+	var problem pgx.NullString
+	err = pdb.QueryRow(dbQuery, owner, folder, fileName).Scan(&date, &problem)
+	if err != nil {
+		Log.Errorf("Retrieving the analysis result for '%s%s%s' failed: %v", owner, folder, fileName, err)
+		return
+	}
+	if date.Valid {
+		result.Date = date.Time
+	}
+	if problem.Valid {
+		result.Problem = problem.String
+	}
+	return
+}
+
+// Returns whether a project has been archived, which makes it read-only.  Projects which don't exist (yet) aren't
+// archived.
+func ProjectArchived(owner string, folder string, fileName string) (archived bool, err error) {
+	dbQuery := `
+		SELECT archived
+		FROM sqlite_databases
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND folder = $2
+			AND db_name = $3
+			AND is_deleted = false`
+	err = pdb.QueryRow(dbQuery, owner, folder, fileName).Scan(&archived)
+	if err == pgx.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		Log.Errorf("Checking if '%s%s%s' is archived failed: %v", owner, folder, fileName, err)
+	}
+	return
+}
+
+// Returns the number of downloads of each version of a project's file on each day since the given date (in UTC),
+// oldest first.  Days without downloads of a version are left out.
+func ProjectDailyDownloads(owner string, folder string, fileName string, since time.Time) (list []ProjectDownloadCount,
+	err error) {
+	dbQuery := `
+		SELECT dl.stat_date, dl.db_sha256, dl.downloads
+		FROM project_daily_downloads AS dl, sqlite_databases AS db
+		WHERE dl.db_id = db.db_id
+			AND db.user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND db.folder = $2
+			AND db.db_name = $3
+			AND db.is_deleted = false
+			AND dl.stat_date >= $4::date
+		ORDER BY dl.stat_date, dl.db_sha256`
+	rows, err := pdb.Query(dbQuery, owner, folder, fileName, since.Format("2006-01-02"))
+	if err != nil {
+		Log.Errorf("Retrieving download statistics for '%s%s%s' failed: %v", owner, folder, fileName, err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var oneRow ProjectDownloadCount
+		err = rows.Scan(&oneRow.Date, &oneRow.Sha256, &oneRow.Downloads)
+		if err != nil {
+			Log.Errorf("Error retrieving download statistics for '%s%s%s': %v", owner, folder, fileName, err)
+			return
+		}
+		list = append(list, oneRow)
+	}
+	return
+}
+
+// Returns the ORDER BY expression for sorting a list of projects.  The projects need to be aliased as "db".  The
+// sqlite_databases table has an index for each of these, so sorting large lists stays quick.
+func projectOrder(sort SortOrder) string {
+	switch sort {
+	case SORT_DOWNLOADS:
+		return "db.download_count DESC NULLS LAST, db.last_modified DESC"
+	case SORT_NEWEST:
+		return "db.date_created DESC"
+	case SORT_STARS:
+		return "db.stars DESC, db.last_modified DESC"
+	default:
+		return "db.last_modified DESC"
+	}
+}
+
+// Returns the ID of the GitHub import a project was created by, if any.
+func ProjectGitHubImport(owner string, folder string, fileName string) (id int64, found bool, err error) {
+	dbQuery := `
+		SELECT f.import_id
+		FROM github_import_files AS f
+			JOIN sqlite_databases AS db ON db.db_id = f.db_id
+		WHERE db.user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND db.folder = $2
+			AND db.db_name = $3
+			AND db.is_deleted = false`
+	err = pdb.QueryRow(dbQuery, owner, folder, fileName).Scan(&id)
+	if err == pgx.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		Log.Errorf("Looking up the GitHub import for '%s%s%s' failed: %v", owner, folder, fileName, err)
+		return
+	}
+	return id, true, nil
+}
+
+// Returns the one line and full descriptions of a project.  Empty strings are returned for ones it doesn't have.
+func ProjectDescriptions(owner string, folder string, fileName string) (oneLineDesc string, fullDesc string,
+	err error) {
+	dbQuery := `
+		SELECT coalesce(one_line_description, ''), coalesce(full_description, '')
+		FROM sqlite_databases
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+				)
+			AND folder = $2
+			AND db_name = $3
+			AND is_deleted = false`
+	err = pdb.QueryRow(dbQuery, owner, folder, fileName).Scan(&oneLineDesc, &fullDesc)
+	if err != nil && err != pgx.ErrNoRows {
+		Log.Errorf("Retrieving descriptions for '%s%s%s' failed: %v", owner, folder, fileName, err)
+		return "", "", err
+	}
+	return oneLineDesc, fullDesc, nil
+}
+
+// Returns whether a project is public.  Drafts aren't, even when they're set to be public once published.
+func ProjectPublic(owner string, folder string, fileName string) (public bool, err error) {
+	dbQuery := `
+		SELECT public AND NOT is_draft
+		FROM sqlite_databases
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND folder = $2
+			AND db_name = $3
+			AND is_deleted = false`
+	err = pdb.QueryRow(dbQuery, owner, folder, fileName).Scan(&public)
+	if err != nil {
+		Log.Errorf("Checking if '%s%s%s' is public failed: %v", owner, folder, fileName, err)
+	}
+	return
+}
+
+// Returns the README for a project, in its raw Markdown form.  Projects without one return an empty string.
+func ProjectReadme(owner string, folder string, fileName string) (readme string, err error) {
+	dbQuery := `
+		SELECT coalesce(readme, '')
+		FROM sqlite_databases
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+				)
+			AND folder = $2
+			AND db_name = $3
+			AND is_deleted = false`
+	err = pdb.QueryRow(dbQuery, owner, folder, fileName).Scan(&readme)
+	if err != nil && err != pgx.ErrNoRows {
+		Log.Errorf("Retrieving README for '%s%s%s' failed: %v", owner, folder, fileName, err)
+		return "", err
+	}
+	return readme, nil
+}
+
+// Returns where a project which used to be at the given location has moved to (eg after being transferred to another
+// user).
+func ProjectRedirect(owner string, folder string, fileName string) (newOwner string, newFolder string,
+	newName string, found bool, err error) {
+	dbQuery := `
+		SELECT own.user_name, db.folder, db.db_name
+		FROM project_redirects AS redir
+			JOIN sqlite_databases AS db ON db.db_id = redir.db_id
+			JOIN users AS own ON own.user_id = db.user_id
+		WHERE redir.user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND redir.folder = $2
+			AND redir.db_name = $3
+			AND db.is_deleted = false`
+	err = pdb.QueryRow(dbQuery, owner, folder, fileName).Scan(&newOwner, &newFolder, &newName)
+	if err == pgx.ErrNoRows {
+		return "", "", "", false, nil
+	}
+	if err != nil {
+		Log.Errorf("Looking up the redirect for '%s%s%s' failed: %v", owner, folder, fileName, err)
+		return
+	}
+	return newOwner, newFolder, newName, true, nil
+}
+
+// Returns the sites linking to a project over the last given number of days, with the number of views each sent.
+// Views without a referring site (eg the address was typed in) are included with an empty site name.
+func ProjectReferrers(owner string, folder string, fileName string, days int, limit int) (list []ReferrerCount,
+	err error) {
+	dbQuery := `
+		SELECT ref.referrer, sum(ref.hits) AS hits
+		FROM project_referrers AS ref, sqlite_databases AS db
+		WHERE db.user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND db.folder = $2
+			AND db.db_name = $3
+			AND db.is_deleted = false
+			AND ref.db_id = db.db_id
+			AND ref.stat_date > (now() AT TIME ZONE 'UTC')::date - $4::integer
+		GROUP BY ref.referrer
+		ORDER BY hits DESC, ref.referrer
+		LIMIT $5`
+	rows, err := pdb.Query(dbQuery, owner, folder, fileName, days, limit)
+	if err != nil {
+		Log.Errorf("Retrieving referrers for '%s%s%s' failed: %v", owner, folder, fileName, err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var oneRow ReferrerCount
+		err = rows.Scan(&oneRow.Site, &oneRow.Hits)
+		if err != nil {
+			Log.Errorf("Error retrieving referrers for '%s%s%s': %v", owner, folder, fileName, err)
+			return
+		}
+		list = append(list, oneRow)
+	}
+	return
+}
+
+// Returns the regeneration webhook for a project, if it has one.
+func ProjectRegenerationHook(owner string, folder string, fileName string) (hook RegenerationHookEntry, found bool,
+	err error) {
+	dbQuery := `
+		SELECT db_id
+		FROM sqlite_databases
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND folder = $2
+			AND db_name = $3
+			AND is_deleted = false`
+	var id int64
+	err = pdb.QueryRow(dbQuery, owner, folder, fileName).Scan(&id)
+	if err == pgx.ErrNoRows {
+		return hook, false, nil
+	}
+	if err != nil {
+		Log.Errorf("Looking up the ID of '%s%s%s' failed: %v", owner, folder, fileName, err)
+		return
+	}
+	hook, err = RegenerationHook(id)
+	if err == pgx.ErrNoRows {
+		return hook, false, nil
+	}
+	return hook, err == nil, err
+}
+
+// Returns the short URL for a project, or a specific version of it, if it has one.
+func ProjectShortURL(owner string, folder string, fileName string, commitID string) (s ShortURLEntry, found bool,
+	err error) {
+	dbQuery := `
+		SELECT s.code, s.clicks, s.last_click, s.date_created
+		FROM short_urls AS s
+			JOIN sqlite_databases AS db ON db.db_id = s.db_id
+		WHERE db.user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND db.folder = $2
+			AND db.db_name = $3
+			AND db.is_deleted = false
+			AND s.commit_id = $4`
+	var lastClick pgx.NullTime
+	err = pdb.QueryRow(dbQuery, owner, folder, fileName, commitID).Scan(&s.Code, &s.Clicks, &lastClick,
+		&s.DateCreated)
+	if err == pgx.ErrNoRows {
+		return s, false, nil
+	}
+	if err != nil {
+		Log.Errorf("Retrieving the short URL for '%s%s%s' failed: %v", owner, folder, fileName, err)
+		return
+	}
+	s.CommitID, s.FileName, s.Folder, s.Owner = commitID, fileName, folder, owner
+	if lastClick.Valid {
+		s.LastClick = lastClick.Time
+	}
+	return s, true, nil
+}
+
+// Returns the views, downloads, and new stars for a project on each of the last given number of days (in UTC), oldest
+// first.  Downloads by the project owner aren't counted, the same as for the download count.
+func ProjectStats(owner string, folder string, fileName string, days int) (list []ProjectDayStats, err error) {
+	dbQuery := `
+		WITH d AS (
+			SELECT db.db_id, db.user_id
+			FROM sqlite_databases AS db
+			WHERE db.user_id = (
+					SELECT user_id
+					FROM users
+					WHERE lower(user_name) = lower($1)
+				)
+				AND db.folder = $2
+				AND db.db_name = $3
+				AND db.is_deleted = false
+		), days AS (
+			SELECT generate_series((now() AT TIME ZONE 'UTC')::date - $4::integer + 1,
+				(now() AT TIME ZONE 'UTC')::date, interval '1 day')::date AS day
+		), downloads AS (
+			SELECT (dl.download_date AT TIME ZONE 'UTC')::date AS day, count(*) AS num
+			FROM database_downloads AS dl, d
+			WHERE dl.db_id = d.db_id
+				AND dl.download_date >= (now() AT TIME ZONE 'UTC')::date - $4::integer + 1
+				AND (dl.user_id IS NULL OR dl.user_id != d.user_id)
+			GROUP BY day
+		), stars AS (
+			SELECT (st.date_starred AT TIME ZONE 'UTC')::date AS day, count(*) AS num
+			FROM database_stars AS st, d
+			WHERE st.db_id = d.db_id
+			GROUP BY day
+		)
+		SELECT days.day, coalesce(v.views, 0), coalesce(downloads.num, 0), coalesce(stars.num, 0)
+		FROM days
+			LEFT JOIN project_daily_views AS v ON v.db_id = (SELECT db_id FROM d) AND v.stat_date = days.day
+			LEFT JOIN downloads ON downloads.day = days.day
+			LEFT JOIN stars ON stars.day = days.day
+		WHERE EXISTS (SELECT 1 FROM d)
+		ORDER BY days.day`
+	rows, err := pdb.Query(dbQuery, owner, folder, fileName, days)
+	if err != nil {
+		Log.Errorf("Retrieving usage statistics for '%s%s%s' failed: %v", owner, folder, fileName, err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var oneRow ProjectDayStats
+		err = rows.Scan(&oneRow.Date, &oneRow.Views, &oneRow.Downloads, &oneRow.Stars)
+		if err != nil {
+			Log.Errorf("Error retrieving usage statistics for '%s%s%s': %v", owner, folder, fileName, err)
+			return
+		}
+		list = append(list, oneRow)
+	}
+	return
+}
+
+// Returns the project templates a user can choose from.  That's the instance templates, followed by the user's own.
+func ProjectTemplates(userName string) (list []ProjectTemplate, err error) {
+	dbQuery := `
+		SELECT u.user_name = 'default', t.template_name, coalesce(t.one_line_description, ''),
+			coalesce(t.readme, ''), coalesce(t.licence, ''), coalesce(t.category_id, 0), coalesce(cat.path, ''),
+			t.project_tags, coalesce(t.print_settings, '')
+		FROM project_templates AS t
+			JOIN users AS u ON u.user_id = t.user_id
+			LEFT JOIN ` + categoryTree + ` AS cat ON cat.cat_id = t.category_id
+		WHERE u.user_name = 'default'
+			OR lower(u.user_name) = lower($1)
+		ORDER BY u.user_name = 'default' DESC, lower(t.template_name)`
+	rows, err := pdb.Query(dbQuery, userName)
+	if err != nil {
+		Log.Errorf("Retrieving the project templates for user '%s' failed: %v", userName, err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var oneRow ProjectTemplate
+		err = rows.Scan(&oneRow.Instance, &oneRow.Name, &oneRow.OneLineDesc, &oneRow.Readme, &oneRow.Licence,
+			&oneRow.Category, &oneRow.CategoryPath, &oneRow.Tags, &oneRow.PrintSettings)
+		if err != nil {
+			Log.Errorf("Error retrieving the project templates for user '%s': %v", userName, err)
+			return nil, err
+		}
+		list = append(list, oneRow)
+	}
+	return
+}
+
+// Returns the user a project is waiting to be transferred to, if its transfer has been requested.
+func ProjectTransferTo(owner string, folder string, fileName string) (to string, found bool, err error) {
+	dbQuery := `
+		SELECT usr.user_name
+		FROM project_transfers AS tran
+			JOIN users AS usr ON usr.user_id = tran.to_user_id
+		WHERE tran.db_id = (
+				SELECT db_id
+				FROM sqlite_databases
+				WHERE user_id = (
+						SELECT user_id
+						FROM users
+						WHERE lower(user_name) = lower($1)
+					)
+					AND folder = $2
+					AND db_name = $3
+					AND is_deleted = false
+			)`
+	err = pdb.QueryRow(dbQuery, owner, folder, fileName).Scan(&to)
+	if err == pgx.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		Log.Errorf("Looking up the transfer of '%s%s%s' failed: %v", owner, folder, fileName, err)
+		return
+	}
+	return to, true, nil
+}
+
+// Returns the translations of a project's descriptions and README, ordered by language tag.
+func ProjectTranslations(owner string, folder string, fileName string) (list []ProjectTranslation, err error) {
+	dbQuery := `
+		SELECT t.language, coalesce(t.one_line_description, ''), coalesce(t.full_description, ''),
+			coalesce(t.readme, ''), t.last_modified
+		FROM project_translations AS t, sqlite_databases AS db
+		WHERE t.db_id = db.db_id
+			AND db.user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND db.folder = $2
+			AND db.db_name = $3
+			AND db.is_deleted = false
+		ORDER BY t.language`
+	rows, err := pdb.Query(dbQuery, owner, folder, fileName)
+	if err != nil {
+		Log.Errorf("Database query failed: %v", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var oneRow ProjectTranslation
+		err = rows.Scan(&oneRow.Language, &oneRow.OneLineDesc, &oneRow.FullDesc, &oneRow.Readme,
+			&oneRow.LastModified)
+		if err != nil {
+			Log.Errorf("Error retrieving the translations of '%s%s%s': %v", owner, folder, fileName, err)
+			return
+		}
+		list = append(list, oneRow)
+	}
+	return
+}
+
+// Returns the number of triangles in the model at the head of a project's default branch.
+func ProjectTriangleCount(owner string, folder string, fileName string) (count int64, err error) {
+	dbQuery := `
+		SELECT triangle_count
+		FROM sqlite_databases
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND folder = $2
+			AND db_name = $3
+			AND is_deleted = false`
+	err = pdb.QueryRow(dbQuery, owner, folder, fileName).Scan(&count)
+	if err != nil {
+		Log.Errorf("Retrieving the triangle count of '%s%s%s' failed: %v", owner, folder, fileName, err)
+	}
+	return
+}
+
+// Removes the project access log entries older than the given number of days.
+func PruneProjectAccessLog(days int) {
+	dbQuery := `
+		DELETE FROM project_access_log
+		WHERE access_time < now() - $1::integer * interval '1 day'`
+	commandTag, err := pdb.Exec(dbQuery, days)
+	if err != nil {
+		Log.Errorf("Removing old project access log entries failed: %v", err)
+		return
+	}
+	if n := commandTag.RowsAffected(); n > 0 {
+		Log.Infof("Removed %d project access log entries older than %d days", n, days)
+	}
+}
+
+// Returns a page of recent public events (uploads, forks, releases), newest first.  Only events which come after the
+// given timestamp and event ID in that order are included, which allows callers to page back through the event
+// history without skipping events sharing a timestamp.  An empty event ID includes all of the events older than the
+// timestamp.
+func PublicEvents(before time.Time, beforeID string, limit int) (list []PublicEvent, err error) {
+	dbQuery := `
+		SELECT ev.event_id, ev.event_type, ev.event_timestamp, ev.owner, ev.db_name, ev.actor, ev.detail
+		FROM (
+			SELECT 'upload/' || up.up_id AS event_id, 'upload' AS event_type, up.upload_date AS event_timestamp,
+				own.user_name AS owner, db.db_name, coalesce(act.user_name, '') AS actor, up.db_sha256 AS detail
+			FROM database_uploads AS up
+				JOIN sqlite_databases AS db ON db.db_id = up.db_id
+				JOIN users AS own ON own.user_id = db.user_id
+				LEFT JOIN users AS act ON act.user_id = up.user_id
+			WHERE ` + publicProject("db") + `
+				AND db.is_deleted = false
+			UNION ALL
+			SELECT 'fork/' || db.db_id, 'fork', db.date_created, own.user_name, db.db_name, own.user_name,
+				CASE WHEN ` + publicProject("src") + ` AND src.is_deleted = false
+					THEN src_own.user_name || src.folder || src.db_name
+					ELSE ''
+				END
+			FROM sqlite_databases AS db
+				JOIN sqlite_databases AS src ON src.db_id = db.forked_from
+				JOIN users AS own ON own.user_id = db.user_id
+				JOIN users AS src_own ON src_own.user_id = src.user_id
+			WHERE ` + publicProject("db") + `
+				AND db.is_deleted = false
+			UNION ALL
+			SELECT 'release/' || db.db_id || '/' || rel.name, 'release', (rel.data->>'date')::timestamptz,
+				own.user_name, db.db_name, coalesce(rel.data->>'name', ''), rel.name
+			FROM sqlite_databases AS db
+				JOIN users AS own ON own.user_id = db.user_id,
+				jsonb_each(db.release_list) AS rel(name, data)
+			WHERE ` + publicProject("db") + `
+				AND db.is_deleted = false
+		) AS ev
+		WHERE ev.event_timestamp < $1
+			OR (ev.event_timestamp = $1 AND ev.event_id < $2)
+		ORDER BY ev.event_timestamp DESC, ev.event_id DESC
+		LIMIT $3`
+	rows, err := pdb.Query(dbQuery, before, beforeID, limit)
+	if err != nil {
+		Log.Errorf("Database query failed: %v", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var oneRow PublicEvent
+		err = rows.Scan(&oneRow.ID, &oneRow.Type, &oneRow.Timestamp, &oneRow.Owner, &oneRow.DBName, &oneRow.Actor,
+			&oneRow.Detail)
+		if err != nil {
+			Log.Errorf("Error retrieving list of public events: %v", err)
+			return
+		}
+		oneRow.URL = fmt.Sprintf("/%s/%s", oneRow.Owner, oneRow.DBName)
+		list = append(list, oneRow)
+	}
+	return
+}
+
+// Returns the SQL condition for a project being visible to the public, which takes moderation into account as
+// well.  Projects hidden by a moderator are never visible, and in strict mode neither are projects still waiting to be
+// moderated.  Drafts aren't visible until their owner publishes them.  The alias is the name the sqlite_databases
+// table has in the query, if any.
+func publicProject(alias string) string {
+	if alias != "" {
+		alias += "."
+	}
+	visible := alias + "public = true AND " + alias + "is_draft = false AND "
+	if Conf().Moderation.Strict {
+		return visible + alias + "moderation_status = 'approved'"
+	}
+	return visible + alias + "moderation_status <> 'hidden'"
+}
+
+// Publishes a draft project, so its public/private setting applies from now on.  Returns false if the project wasn't
+// a draft.
+func PublishDraft(owner string, folder string, fileName string) (published bool, err error) {
+	dbQuery := `
+		UPDATE sqlite_databases
+		SET is_draft = false
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND folder = $2
+			AND db_name = $3
+			AND is_draft = true
+			AND is_deleted = false`
+	commandTag, err := pdb.Exec(dbQuery, owner, folder, fileName)
+	if err != nil {
+		Log.Errorf("Publishing draft '%s%s%s' failed: %v", owner, folder, fileName, err)
+		return false, err
+	}
+	return commandTag.RowsAffected() == 1, nil
+}
+
+// Makes a private project public, for scheduled publishing.  Returns false if the project was already public (eg the
+// owner didn't wait), so nobody is told about it twice.
+func PublishProject(owner string, folder string, fileName string) (published bool, err error) {
+	dbQuery := `
+		UPDATE sqlite_databases
+		SET public = true
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND folder = $2
+			AND db_name = $3
+			AND public = false
+			AND is_deleted = false`
+	commandTag, err := pdb.Exec(dbQuery, owner, folder, fileName)
+	if err != nil {
+		Log.Errorf("Making project '%s%s%s' public failed: %v", owner, folder, fileName, err)
+		return false, err
+	}
+	return commandTag.RowsAffected() == 1, nil
+}
+
+// Adds an email to the queue for sending.
+func QueueEmail(mailTo string, subject string, body string) error {
+	dbQuery := `
+		INSERT INTO email_queue (mail_to, subject, body)
+		VALUES ($1, $2, $3)`
+	_, err := pdb.Exec(dbQuery, mailTo, subject, body)
+	if err != nil {
+		Log.Errorf("Adding an email for '%s' to the email queue failed: %v", mailTo, err)
+	}
+	return err
+}
+
+// Removes an inactive account whose username is being reclaimed, then reserves the username so only a site admin can
+// hand it on.  Nothing is removed unless the grace period has ended, the user hasn't been seen since they were told,
+// and the account still has nothing on the site.  The returned value is false when any of those aren't true.
+func ReclaimUsername(adminUser string, userName string, reason string) (reclaimed bool, err error) {
+	tx, err := pdb.Begin()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	dbQuery := `
+		DELETE FROM users AS u
+		USING username_reclamations AS rc
+		WHERE lower(u.user_name) = lower($1)
+			AND rc.user_id = u.user_id
+			AND rc.reclaim_after <= now()
+			AND coalesce(u.last_seen, u.date_joined) < rc.date_notified
+			AND ` + userHasNoContent + `
+		RETURNING u.user_name`
+	var name string
+	err = tx.QueryRow(dbQuery, userName).Scan(&name)
+	if err == pgx.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		Log.Errorf("Reclaiming username '%s' failed: %v", userName, err)
+		return
+	}
+
+	// Reserve the username, so it isn't available to whoever registers next
+	dbQuery = `
+		INSERT INTO reserved_usernames (pattern, reason, added_by)
+		VALUES (lower($1), $2, $3)
+		ON CONFLICT (pattern)
+			DO NOTHING`
+	_, err = tx.Exec(dbQuery, name, reason, adminUser)
+	if err != nil {
+		Log.Errorf("Reserving reclaimed username '%s' failed: %v", name, err)
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		return
+	}
+
+	// Forget the cached account status, so any remaining sessions for the user are ended
+	return true, InvalidateCachedData(accountStatusCacheKey(name))
+}
+
+// Counts a short URL being followed.
+func RecordShortURLClick(code string) error {
+	dbQuery := `
+		UPDATE short_urls
+		SET clicks = clicks + 1, last_click = now()
+		WHERE code = $1`
+	_, err := pdb.Exec(dbQuery, code)
+	if err != nil {
+		Log.Errorf("Counting a click on short URL '%s' failed: %v", code, err)
+	}
+	return err
+}
+
+// Counts a wrong authenticator app code given by a user.  Once there have been maxFailures in a row, the app is locked
+// for the lockout period and the count starts again.  Returns whether the app is now locked.
+func RecordTOTPFailure(userName string, maxFailures int, lockout time.Duration) (locked bool, err error) {
+	dbQuery := `
+		UPDATE users
+		SET totp_failures = CASE WHEN totp_failures + 1 >= $2 THEN 0 ELSE totp_failures + 1 END,
+			totp_locked_until = CASE
+				WHEN totp_failures + 1 >= $2 THEN now() + $3::bigint * interval '1 second'
+				ELSE totp_locked_until
+			END
+		WHERE lower(user_name) = lower($1)
+		RETURNING coalesce(totp_locked_until > now(), false)`
+	err = pdb.QueryRow(dbQuery, userName, maxFailures, int64(lockout/time.Second)).Scan(&locked)
+	if err != nil {
+		Log.Errorf("Recording a wrong authenticator app code for '%s' failed: %v", userName, err)
+	}
+	return
+}
+
+// Records that a user has used the site, which also cancels the reclamation of their username if one was started.
+// The returned value is true when a reclamation was cancelled.
+func RecordUserActivity(userName string) (cancelled bool, err error) {
+	dbQuery := `
+		WITH seen AS (
+			UPDATE users
+			SET last_seen = now()
+			WHERE lower(user_name) = lower($1)
+			RETURNING user_id
+		)
+		DELETE FROM username_reclamations
+		WHERE user_id IN (SELECT user_id FROM seen)`
+	commandTag, err := pdb.Exec(dbQuery, userName)
+	if err != nil {
+		Log.Errorf("Recording activity for user '%s' failed: %v", userName, err)
+		return
+	}
+	return commandTag.RowsAffected() > 0, nil
+}
+
+// Returns the most recent uploads to public projects, newest first, for use in feeds.  If an owner or project tag is
+// given, only uploads to projects matching those are included.
+func RecentUploads(owner string, tag string, limit int) (list []FeedEntry, err error) {
+	dbQuery := `
+		SELECT up.up_id, up.upload_date, own.user_name, db.db_name, coalesce(db.one_line_description, ''),
+			coalesce(nullif(act.display_name, ''), act.user_name, own.user_name)
+		FROM database_uploads AS up
+			JOIN sqlite_databases AS db ON db.db_id = up.db_id
+			JOIN users AS own ON own.user_id = db.user_id
+			LEFT JOIN users AS act ON act.user_id = up.user_id
+		WHERE ` + publicProject("db") + `
+			AND db.is_deleted = false`
+	args := []interface{}{limit}
+	if owner != "" {
+		args = append(args, owner)
+		dbQuery += fmt.Sprintf(`
+			AND lower(own.user_name) = lower($%d)`, len(args))
+	}
+	if tag != "" {
+		args = append(args, tag)
+		dbQuery += fmt.Sprintf(`
+			AND db.project_tags @> ARRAY[$%d::text]`, len(args))
+	}
+	dbQuery += `
+		ORDER BY up.upload_date DESC
+		LIMIT $1`
+	rows, err := pdb.Query(dbQuery, args...)
+	if err != nil {
+		Log.Errorf("Database query failed: %v", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var upID int64
+		var dbOwner, dbName string
+		var oneRow FeedEntry
+		err = rows.Scan(&upID, &oneRow.Updated, &dbOwner, &dbName, &oneRow.Content, &oneRow.Author)
+		if err != nil {
+			Log.Errorf("Error retrieving list of recent uploads: %v", err)
+			return
+		}
+		oneRow.Title = fmt.Sprintf("%s/%s", dbOwner, dbName)
+		oneRow.URL = fmt.Sprintf("/%s/%s", dbOwner, dbName)
+		oneRow.ID = fmt.Sprintf("%s#upload-%d", oneRow.URL, upID)
+		list = append(list, oneRow)
+	}
+	return
+}
+
+// Gives back the quota used by an upload which wasn't stored.  Nothing is given back once the quota period the upload
+// was charged to has ended, as the usage has been reset since.
+func RefundUploadQuota(userName string, numBytes int64) error {
+	if Conf().Quota.DailyUploadMB == 0 {
+		return nil
+	}
+	dbQuery := `
+		UPDATE users
+		SET quota_bytes_used = greatest(quota_bytes_used - $2, 0)
+		WHERE lower(user_name) = lower($1)
+			AND quota_period_start >= now() - interval '1 day'`
+	_, err := pdb.Exec(dbQuery, userName, numBytes)
+	if err != nil {
+		Log.Errorf("Refunding upload quota failed for user '%s'. Error: '%v'", userName, err)
+	}
+	return err
+}
+
+// Returns the regeneration webhook for the project with the given ID.  pgx.ErrNoRows is returned if there isn't one.
+func RegenerationHook(id int64) (hook RegenerationHookEntry, err error) {
+	dbQuery := `
+		SELECT hook.db_id, own.user_name, db.folder, db.db_name, hook.source_url, hook.token,
+			coalesce(hook.last_commit, ''), coalesce(hook.last_error, ''), hook.last_run
+		FROM regeneration_hooks AS hook
+			JOIN sqlite_databases AS db ON db.db_id = hook.db_id
+			JOIN users AS own ON own.user_id = db.user_id
+		WHERE hook.db_id = $1
+			AND db.is_deleted = false`
+	var lastRun pgx.NullTime
+	err = pdb.QueryRow(dbQuery, id).Scan(&hook.ID, &hook.Owner, &hook.Folder, &hook.FileName, &hook.SourceURL,
+		&hook.Token, &hook.LastCommit, &hook.LastError, &lastRun)
+	if err != nil {
+		if err != pgx.ErrNoRows {
+			Log.Errorf("Retrieving regeneration webhook %d failed: %v", id, err)
+		}
+		return
+	}
+	if lastRun.Valid {
+		hook.LastRun = lastRun.Time
+	}
+	return
+}
+
+// Returns the projects most related to a given one, best matches first.  Projects are scored on the number of
+// project tags they share with it (3 points each), having the same owner (2 points), and the number of people who
+// have starred both (1 point each).  The results are cached, as working out the co-starring is fairly expensive.
+func RelatedProjects(loggedInUser string, owner string, folder string, fileName string) (list []RelatedProject,
+	err error) {
+	// Use a cached version of the list if it exists
+	cacheKey := MetadataCacheKey("related", loggedInUser, owner, folder, fileName, "")
+	ok, err := GetCachedData(cacheKey, &list)
+	if err != nil {
+		Log.Errorf("Error retrieving data from cache: %v", err)
+	}
+	if ok {
+		return list, nil
+	}
+
+	dbQuery := `
+		WITH src AS (
+			SELECT db_id, user_id, project_tags
+			FROM sqlite_databases
+			WHERE user_id = (
+					SELECT user_id
+					FROM users
+					WHERE lower(user_name) = lower($1)
+				)
+				AND folder = $2
+				AND db_name = $3
+				AND is_deleted = false
+		), costar AS (
+			SELECT s2.db_id, count(*) AS num
+			FROM database_stars AS s1
+				JOIN database_stars AS s2 ON s2.user_id = s1.user_id AND s2.db_id <> s1.db_id
+			WHERE s1.db_id = (SELECT db_id FROM src)
+			GROUP BY s2.db_id
+		)
+		SELECT own.user_name, db.db_name, coalesce(db.one_line_description, ''), db.stars, db.project_tags,
+			(SELECT count(*) FROM unnest(db.project_tags) AS t WHERE t = ANY(src.project_tags)) * 3 +
+				CASE WHEN db.user_id = src.user_id THEN 2 ELSE 0 END +
+				coalesce(costar.num, 0) AS score
+		FROM sqlite_databases AS db
+			JOIN users AS own ON own.user_id = db.user_id
+			CROSS JOIN src
+			LEFT JOIN costar ON costar.db_id = db.db_id
+		WHERE db.db_id <> src.db_id
+			AND db.is_deleted = false
+			AND (` + publicProject("db") + ` OR lower(own.user_name) = lower($4))
+			AND (db.project_tags && src.project_tags OR db.user_id = src.user_id OR costar.num IS NOT NULL)
+		ORDER BY score DESC, db.stars DESC, db.last_modified DESC
+		LIMIT $5`
+	rows, err := pdb.Query(dbQuery, owner, folder, fileName, loggedInUser, RelatedProjectsSize)
+	if err != nil {
+		Log.Errorf("Database query failed: %v", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var oneRow RelatedProject
+		var score int64
+		err = rows.Scan(&oneRow.Owner, &oneRow.DBName, &oneRow.OneLineDesc, &oneRow.Stars, &oneRow.ProjectTags,
+			&score)
+		if err != nil {
+			Log.Errorf("Error retrieving related projects for '%s%s%s': %v", owner, folder, fileName, err)
+			return
+		}
+		oneRow.Score = int(score)
+		oneRow.URL = fmt.Sprintf("/%s/%s", oneRow.Owner, oneRow.DBName)
+		list = append(list, oneRow)
+	}
+
+	// Cache the list
+	err = CacheData(cacheKey, list, Conf().Memcache.DefaultCacheTime)
+	if err != nil {
+		Log.Errorf("Error when caching related projects: %v", err)
+	}
+	return list, nil
+}
+
+// Returns the projects here, and files elsewhere, which a project declares it was remixed from.  Projects which have
+// since been deleted are left out.
+func RemixSources(owner string, folder string, fileName string) (list []RemixSource, err error) {
+	dbQuery := `
+		SELECT src.source_id, coalesce(u.user_name, ''), coalesce(db.folder, ''), coalesce(db.db_name, ''),
+			coalesce(src.source_url, ''), coalesce(src.source_licence, ''), src.date_added
+		FROM project_remix_sources AS src
+			LEFT JOIN sqlite_databases AS db ON db.db_id = src.source_db_id
+			LEFT JOIN users AS u ON u.user_id = db.user_id
+		WHERE src.db_id = (
+				SELECT db_id
+				FROM sqlite_databases
+				WHERE user_id = (
+						SELECT user_id
+						FROM users
+						WHERE lower(user_name) = lower($1)
+					)
+					AND folder = $2
+					AND db_name = $3
+					AND is_deleted = false
+			)
+			AND (src.source_db_id IS NULL OR db.is_deleted = false)
+		ORDER BY src.date_added`
+	rows, err := pdb.Query(dbQuery, owner, folder, fileName)
+	if err != nil {
+		Log.Errorf("Retrieving the remix sources for '%s%s%s' failed: %v", owner, folder, fileName, err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s RemixSource
+		err = rows.Scan(&s.ID, &s.Owner, &s.Folder, &s.FileName, &s.URL, &s.Licence, &s.DateAdded)
+		if err != nil {
+			Log.Errorf("Error retrieving the remix sources for '%s%s%s': %v", owner, folder, fileName, err)
+			return nil, err
+		}
+		list = append(list, s)
+	}
+	return
+}
+
+// Rename a SQLite database.
+func RenameDatabase(userName string, folder string, fileName string, newName string) error {
+	// Save the database settings
+	dbQuery := `
+		UPDATE sqlite_databases
+		SET db_name = $4
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND folder = $2
+			AND db_name = $3`
+	commandTag, err := pdb.Exec(dbQuery, userName, folder, fileName, newName)
+	if err != nil {
+		Log.Errorf("Renaming database '%s%s%s' failed: %v", userName, folder, fileName, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		errMsg := fmt.Sprintf("Wrong number of rows affected (%v) when renaming '%s%s%s' to '%s%s%s'\n",
+			numRows, userName, folder, fileName, userName, folder, newName)
+		Log.Errorf(errMsg)
+		return errors.New(errMsg)
+	}
+
+	// Log the rename
+	Log.Infof("Database renamed from '%s%s%s' to '%s%s%s'", userName, folder, fileName, userName, folder,
+		newName)
+
+	return nil
+}
+
+// Returns the username patterns reserved by the site admins, along with how many existing users have names matching
+// each one.
+func ReservedUsernames() (list []ReservedUsername, err error) {
+	dbQuery := `
+		SELECT r.pattern, coalesce(r.reason, ''), r.added_by, r.date_added, (
+				SELECT count(*)
+				FROM users
+				WHERE lower(user_name) LIKE replace(replace(replace(r.pattern, '_', '\_'), '*', '%'), '?', '_')
+			)
+		FROM reserved_usernames AS r
+		ORDER BY r.pattern`
+	rows, err := pdb.Query(dbQuery)
+	if err != nil {
+		Log.Errorf("Retrieving the reserved usernames failed: %v", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var oneRow ReservedUsername
+		err = rows.Scan(&oneRow.Pattern, &oneRow.Reason, &oneRow.AddedBy, &oneRow.DateAdded, &oneRow.Matches)
+		if err != nil {
+			Log.Errorf("Error retrieving the reserved usernames: %v", err)
+			return nil, err
+		}
+		list = append(list, oneRow)
+	}
+	return
+}
+
+// Requests the transfer of a project to another user.  The transfer happens once they accept it.  A project only has
+// one transfer waiting at a time, so this replaces any earlier request.
+func RequestProjectTransfer(owner string, folder string, fileName string, newOwner string) error {
+	dbQuery := `
+		INSERT INTO project_transfers (db_id, to_user_id)
+		SELECT db.db_id, usr.user_id
+		FROM sqlite_databases AS db, users AS usr
+		WHERE db.user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND db.folder = $2
+			AND db.db_name = $3
+			AND db.is_deleted = false
+			AND lower(usr.user_name) = lower($4)
+		ON CONFLICT (db_id)
+			DO UPDATE
+			SET to_user_id = excluded.to_user_id, date_requested = now()`
+	commandTag, err := pdb.Exec(dbQuery, owner, folder, fileName, newOwner)
+	if err != nil {
+		Log.Errorf("Requesting the transfer of '%s%s%s' to '%s' failed: %v", owner, folder, fileName, newOwner, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		errMsg := fmt.Sprintf("Wrong number of rows (%v) affected when requesting the transfer of '%s%s%s' to '%s'",
+			numRows, owner, folder, fileName, newOwner)
+		Log.Error(errMsg)
+		return errors.New(errMsg)
+	}
+	return nil
+}
+
+// Puts running background jobs back in the queue when the worker running them has stopped checking in (eg its server
+// was shut down part way through).  Jobs which have used up their attempts are moved to the dead job list instead.
+func RequeueStaleJobs(staleAfter time.Duration) error {
+	dbQuery := `
+		UPDATE background_jobs
+		SET status = CASE WHEN attempts >= max_attempts THEN 'dead' ELSE 'queued' END,
+			last_error = 'Worker stopped responding', locked_by = NULL, date_updated = now()
+		WHERE status = 'running'
+			AND date_updated < now() - $1::bigint * interval '1 second'`
+	commandTag, err := pdb.Exec(dbQuery, int64(staleAfter/time.Second))
+	if err != nil {
+		Log.Errorf("Requeuing stale background jobs failed: %v", err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows > 0 {
+		Log.Warnf("Requeued %d background jobs whose workers stopped responding", numRows)
+	}
+	return nil
+}
+
+// Puts a failed background job back in the queue, with a fresh set of attempts.
+func RetryJob(id int64) error {
+	dbQuery := `
+		UPDATE background_jobs
+		SET status = 'queued', attempts = 0, run_after = now(), date_updated = now()
+		WHERE job_id = $1
+			AND status = 'dead'`
+	commandTag, err := pdb.Exec(dbQuery, id)
+	if err != nil {
+		Log.Errorf("Retrying background job '%d' failed: %v", id, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		errMsg := fmt.Sprintf("Wrong number of rows (%v) affected when retrying background job '%d'", numRows, id)
+		Log.Error(errMsg)
+		return errors.New(errMsg)
+	}
+	return nil
+}
+
+// Ends all of the existing login sessions for a user.  Sessions started before the revocation time are rejected.
+func RevokeUserSessions(userName string) error {
+	dbQuery := `
+		UPDATE users
+		SET sessions_revoked_at = now()
+		WHERE lower(user_name) = lower($1)`
+	commandTag, err := pdb.Exec(dbQuery, userName)
+	if err != nil {
+		Log.Errorf("Revoking sessions failed for user '%s'. Error: '%v'", userName, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		Log.Warnf("Wrong # of rows (%v) affected when revoking sessions. User: '%s'", numRows, userName)
+	}
+	return InvalidateCachedData(accountStatusCacheKey(userName))
+}
+
+// Saves updated database settings to PostgreSQL.
+func SaveDBSettings(userName string, folder string, fileName string, oneLineDesc string, fullDesc string,
+	defaultTable string, public bool, sourceURL string, defaultBranch string) error {
+	// Check for values which should be NULL
+	var nullable1LineDesc, nullableFullDesc, nullableSourceURL pgx.NullString
+	if oneLineDesc == "" {
+		nullable1LineDesc.Valid = false
+	} else {
+		nullable1LineDesc.String = oneLineDesc
+		nullable1LineDesc.Valid = true
+	}
+	if fullDesc == "" {
+		nullableFullDesc.Valid = false
+	} else {
+		nullableFullDesc.String = fullDesc
+		nullableFullDesc.Valid = true
+	}
+	if sourceURL == "" {
+		nullableSourceURL.Valid = false
+	} else {
+		nullableSourceURL.String = sourceURL
+		nullableSourceURL.Valid = true
+	}
+
+	// Save the database settings
+	SQLQuery := `
+		UPDATE sqlite_databases
+		SET one_line_description = $4, full_description = $5, default_table = $6, public = $7, source_url = $8,
+			default_branch = $9
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND folder = $2
+			AND db_name = $3`
+	commandTag, err := pdb.Exec(SQLQuery, userName, folder, fileName, nullable1LineDesc, nullableFullDesc, defaultTable,
+		public, nullableSourceURL, defaultBranch)
+	if err != nil {
+		Log.Errorf("Updating description for database '%s%s%s' failed: %v", userName, folder,
+			fileName, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		errMsg := fmt.Sprintf("Wrong number of rows affected (%v) when updating description for '%s%s%s'\n",
+			numRows, userName, folder, fileName)
+		Log.Errorf(errMsg)
+		return errors.New(errMsg)
+	}
+
+	// Invalidate the old memcached entry for the database
+	err = InvalidateCacheEntry(userName, userName, folder, fileName, "") // Empty string indicates "for all versions"
+	if err != nil {
+		// Something went wrong when invalidating memcached entries for the database
+		Log.Errorf("Error when invalidating memcache entries: %s", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// Returns the scheduled publications of a project, soonest first.
+func ScheduledPublications(owner string, folder string, fileName string) (list []ScheduledPublication, err error) {
+	dbQuery := `
+		SELECT s.release_name, coalesce(s.release_info, '{}'), s.publish_at
+		FROM scheduled_publications AS s
+		WHERE s.db_id = (
+				SELECT db_id
+				FROM sqlite_databases
+				WHERE user_id = (
+						SELECT user_id
+						FROM users
+						WHERE lower(user_name) = lower($1)
+					)
+					AND folder = $2
+					AND db_name = $3
+			)
+		ORDER BY s.publish_at, s.release_name`
+	rows, err := pdb.Query(dbQuery, owner, folder, fileName)
+	if err != nil {
+		Log.Errorf("Retrieving the scheduled publications for '%s%s%s' failed: %v", owner, folder, fileName, err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		oneRow := ScheduledPublication{FileName: fileName, Folder: folder, Owner: owner}
+		err = rows.Scan(&oneRow.Release, &oneRow.ReleaseInfo, &oneRow.PublishAt)
+		if err != nil {
+			Log.Errorf("Error retrieving the scheduled publications for '%s%s%s': %v", owner, folder, fileName, err)
+			return nil, err
+		}
+		list = append(list, oneRow)
+	}
+	return
+}
+
+// Schedules a private project to be made public, or a release to be published, at a later time.  Release is empty for
+// the project itself.  Scheduling the same thing again changes its time.
+func SchedulePublication(owner string, folder string, fileName string, release string, info ReleaseEntry,
+	publishAt time.Time) error {
+	var relInfo interface{}
+	if release != "" {
+		relInfo = info
+	}
+	dbQuery := `
+		INSERT INTO scheduled_publications (db_id, release_name, release_info, publish_at)
+		SELECT db_id, $4, $5, $6
+		FROM sqlite_databases
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND folder = $2
+			AND db_name = $3
+			AND is_deleted = false
+		ON CONFLICT (db_id, release_name)
+			DO UPDATE
+			SET release_info = excluded.release_info, publish_at = excluded.publish_at, date_scheduled = now()`
+	commandTag, err := pdb.Exec(dbQuery, owner, folder, fileName, release, relInfo, publishAt)
+	if err != nil {
+		Log.Errorf("Scheduling the publication of '%s' for '%s%s%s' failed: %v", release, owner, folder, fileName,
+			err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		errMsg := fmt.Sprintf("Wrong number of rows (%v) affected when scheduling the publication of '%s' for "+
+			"'%s%s%s'", numRows, release, owner, folder, fileName)
+		Log.Error(errMsg)
+		return errors.New(errMsg)
+	}
+	return nil
+}
+
+// Retrieves projects in the form they're given to the external search engines.  If a project owner is given, just that
+// one project is returned.  Otherwise all (non-deleted) projects are.  Projects hidden by moderation are given as
+// private, so only their owner finds them.
+func SearchDocuments(owner string, folder string, fileName string) (docs []SearchDocument, err error) {
+	dbQuery := `
+		SELECT own.user_name, db.folder, db.db_name, coalesce(db.one_line_description, ''),
+			coalesce(db.full_description, ''), db.stars, db.date_created, db.last_modified,
+			coalesce(db.download_count, 0), db.project_tags, (` + publicProject("db") + `), db.triangle_count,
+			coalesce(cat.slug_path, ''), coalesce(lic.friendly_name, 'Not specified'), coalesce(lic.licence_url, '')
+		FROM sqlite_databases AS db
+			JOIN users AS own ON own.user_id = db.user_id
+			LEFT JOIN ` + categoryTree + ` AS cat ON cat.cat_id = db.category_id
+			LEFT JOIN ` + headLicence + ` AS lic ON true
+		WHERE db.is_deleted = false
+			AND ($1 = '' OR (lower(own.user_name) = lower($1) AND db.folder = $2 AND db.db_name = $3))`
+	rows, err := pdb.Query(dbQuery, owner, folder, fileName)
+	if err != nil {
+		Log.Errorf("Database query failed: %v", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var oneRow SearchDocument
+		err = rows.Scan(&oneRow.Owner, &oneRow.Folder, &oneRow.DBName, &oneRow.OneLineDesc, &oneRow.FullDesc,
+			&oneRow.Stars, &oneRow.DateCreated, &oneRow.LastModified, &oneRow.Downloads, &oneRow.ProjectTags,
+			&oneRow.Public, &oneRow.TriangleCount, &oneRow.Category, &oneRow.Licence, &oneRow.LicenceURL)
+		if err != nil {
+			Log.Errorf("Error retrieving search documents: %v", err)
+			return
+		}
+		docs = append(docs, oneRow)
+	}
+	return
+}
+
+// Searches the project names, descriptions, and tags for the search text, returning a page of the matching projects
+// (best matches first) plus the total number of matches.  Matching words in the descriptions are highlighted using
+// <mark>.  Only projects matching all of the other given filters (tag, category, owner, etc) are included.  When no
+// search text is given, all projects matching the filters are returned, most starred first.
+func SearchProjects(loggedInUser string, filters SearchFilters, offset int) (results []SearchResult, total int,
+	err error) {
+	// NOTE - The search vector expression here needs to exactly match the one used by the sqlite_databases_search_idx
+	//        index, otherwise PG won't use the index
+	dbQuery := `
+		SELECT own.user_name, db.db_name, coalesce(db.one_line_description, ''), db.stars, db.last_modified,
+			db.project_tags, coalesce(lic.friendly_name, 'Not specified'), coalesce(lic.licence_url, ''),
+			ts_rank(` + searchVector + `, q) AS rank,
+			ts_headline('english', coalesce(db.one_line_description, ''), q,
+				'StartSel=<mark>, StopSel=</mark>, HighlightAll=true'),
+			ts_headline('english', coalesce(db.full_description, ''), q,
+				'StartSel=<mark>, StopSel=</mark>, MaxFragments=2'),
+			count(*) OVER () AS total
+		FROM sqlite_databases AS db
+			JOIN users AS own ON own.user_id = db.user_id
+			LEFT JOIN ` + headLicence + ` AS lic ON true,
+			plainto_tsquery('english', $1) AS q
+		WHERE db.is_deleted = false
+			AND (` + publicProject("db") + ` OR lower(own.user_name) = lower($2))`
+	if filters.Text != "" {
+		dbQuery += `
+			AND ` + searchVector + ` @@ q`
+	}
+	args := []interface{}{filters.Text, loggedInUser, SearchResultsPageSize, offset}
+	if filters.Tag != "" {
+		args = append(args, filters.Tag)
+		dbQuery += fmt.Sprintf(`
+			AND db.project_tags @> ARRAY[$%d::text]`, len(args))
+	}
+	if filters.Category != "" {
+		args = append(args, filters.Category)
+		dbQuery += fmt.Sprintf(`
+			AND db.category_id IN (
+				SELECT cat_id
+				FROM `+categoryTree+` AS cat
+				WHERE slug_path = $%[1]d
+					OR slug_path LIKE $%[1]d || '/%%'
+			)`, len(args))
+	}
+	if filters.Owner != "" {
+		args = append(args, filters.Owner)
+		dbQuery += fmt.Sprintf(`
+			AND lower(own.user_name) = lower($%d)`, len(args))
+	}
+	if filters.Format != "" {
+		args = append(args, "%."+filters.Format)
+		dbQuery += fmt.Sprintf(`
+			AND lower(db.db_name) LIKE $%d`, len(args))
+	}
+	if filters.Licence != "" {
+		args = append(args, filters.Licence)
+		dbQuery += fmt.Sprintf(`
+			AND lower(lic.friendly_name) = lower($%d)`, len(args))
+	}
+	if filters.MinTris > 0 {
+		args = append(args, filters.MinTris)
+		dbQuery += fmt.Sprintf(`
+			AND db.triangle_count >= $%d`, len(args))
+	}
+	if filters.StarsOp != "" {
+		// The operator is validated when the query is parsed, so is safe to include directly
+		args = append(args, filters.Stars)
+		dbQuery += fmt.Sprintf(`
+			AND db.stars %s $%d`, filters.StarsOp, len(args))
+	}
+	if filters.Sort != "" {
+		dbQuery += `
+		ORDER BY ` + projectOrder(filters.Sort)
+	} else {
+		dbQuery += `
+		ORDER BY rank DESC, db.stars DESC, db.last_modified DESC`
+	}
+	dbQuery += `
+		LIMIT $3 OFFSET $4`
+	rows, err := pdb.Query(dbQuery, args...)
+	if err != nil {
+		Log.Errorf("Database query failed: %v", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var oneRow SearchResult
+		err = rows.Scan(&oneRow.Owner, &oneRow.DBName, &oneRow.OneLineDesc, &oneRow.Stars,
+			&oneRow.LastModified, &oneRow.ProjectTags, &oneRow.Licence, &oneRow.LicenceURL, &oneRow.Rank,
+			&oneRow.DescHighlight, &oneRow.FullDescHighlight, &total)
+		if err != nil {
+			Log.Errorf("Error retrieving search results: %v", err)
+			return
+		}
+		oneRow.URL = fmt.Sprintf("/%s/%s", oneRow.Owner, oneRow.DBName)
+		results = append(results, oneRow)
+	}
+	return
+}
+
+// Sends status update emails to people watching databases
+func SendEmails() {
+	// Create Hectane email queue
+	cfg := &queue.Config{
+		Directory:              Conf().Event.EmailQueueDir,
+		DisableSSLVerification: true,
+	}
+	q, err := queue.NewQueue(cfg)
+	if err != nil {
+		Log.Errorf("Couldn't start Hectane queue: %s", err.Error())
+		return
+	}
+	Log.Infof("Created Hectane email queue in '%s'.  Queue processing loop refreshes every %d seconds",
+		Conf().Event.EmailQueueDir, Conf().Event.EmailQueueProcessingDelay)
+
+	for {
+		// When several instances are running, only one of them sends the emails
+		if !HoldJobLock("send-emails", Conf().Event.EmailQueueProcessingDelay*time.Second) {
+			time.Sleep(Conf().Event.EmailQueueProcessingDelay * time.Second)
+			continue
+		}
+
+		// Retrieve unsent emails from the email_queue
+		type eml struct {
+			Address string
+			Body    string
+			ID      int64
+			Subject string
+		}
+		var emailList []eml
+		dbQuery := `
+				SELECT email_id, mail_to, subject, body
+				FROM email_queue
+				WHERE sent = false`
+		rows, err := pdb.Query(dbQuery)
+		if err != nil {
+			Log.Errorf("Database query failed: %v", err.Error())
+			return // Abort, as we don't want to continuously resend the same emails
+		}
+		for rows.Next() {
+			var oneRow eml
+			err = rows.Scan(&oneRow.ID, &oneRow.Address, &oneRow.Subject, &oneRow.Body)
+			if err != nil {
+				Log.Errorf("Error retrieving queued emails: %v", err.Error())
+				rows.Close()
+				return // Abort, as we don't want to continuously resend the same emails
+			}
+			emailList = append(emailList, oneRow)
+		}
+		rows.Close()
+
+		// Send emails
+		for _, j := range emailList {
+			e := &email.Email{
+				From:    "updates@dbhub.io",
+				To:      []string{j.Address},
+				Subject: j.Subject,
+				Text:    j.Body,
+			}
+			msgs, err := e.Messages(q.Storage)
+			if err != nil {
+				Log.Errorf("Queuing email in Hectane failed: %v", err.Error())
+				return // Abort, as we don't want to continuously resend the same emails
+			}
+			for _, m := range msgs {
+				q.Deliver(m)
+			}
+
+			// Mark message as sent
+			dbQuery := `
+				UPDATE email_queue
+				SET sent = true, sent_timestamp = now()
+				WHERE email_id = $1`
+			commandTag, err := pdb.Exec(dbQuery, j.ID)
+			if err != nil {
+				Log.Errorf("Changing email status to sent failed for email '%v': '%v'", j.ID, err.Error())
+				return // Abort, as we don't want to continuously resend the same emails
+			}
+			if numRows := commandTag.RowsAffected(); numRows != 1 {
+				Log.Warnf("Wrong # of rows (%v) affected when changing email status to sent for email '%v'",
+					numRows, j.ID)
+			}
+		}
+
+		// Pause before running the loop again
+		time.Sleep(Conf().Event.EmailQueueProcessingDelay * time.Second)
+	}
+}
+
+// Stores a certificate for a given client.
+func SetClientCert(newCert []byte, userName string) error {
+	SQLQuery := `
+		UPDATE users
+		SET client_cert = $1
+		WHERE lower(user_name) = lower($2)`
+	commandTag, err := pdb.Exec(SQLQuery, newCert, userName)
+	if err != nil {
+		Log.Errorf("Updating client certificate for '%s' failed: %v", userName, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		errMsg := fmt.Sprintf("Wrong number of rows affected (%v) when storing client cert for '%s'\n",
+			numRows, userName)
+		Log.Errorf(errMsg)
+		return errors.New(errMsg)
+	}
+
+	return nil
+}
+
+// Sets the moderation status ("approved", "hidden", or "pending") of a project.  Any open reports about the project
+// are marked as resolved at the same time.
+func SetModerationStatus(owner string, folder string, fileName string, status string) error {
+	// Begin a transaction
+	tx, err := pdb.Begin()
+	if err != nil {
+		return err
+	}
+	// Set up an automatic transaction roll back if the function exits without committing
+	defer tx.Rollback()
+
+	dbQuery := `
+		UPDATE sqlite_databases
+		SET moderation_status = $4
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND folder = $2
+			AND db_name = $3
+			AND is_deleted = false
+		RETURNING db_id`
+	var dbID int64
+	err = tx.QueryRow(dbQuery, owner, folder, fileName, status).Scan(&dbID)
+	if err != nil {
+		Log.Errorf("Changing moderation status for project '%s%s%s' failed: %v", owner, folder, fileName, err)
+		return err
+	}
+	dbQuery = `
+		UPDATE project_reports
+		SET resolved = true
+		WHERE db_id = $1
+			AND resolved = false`
+	_, err = tx.Exec(dbQuery, dbID)
+	if err != nil {
+		Log.Errorf("Resolving reports for project '%s%s%s' failed: %v", owner, folder, fileName, err)
+		return err
+	}
+	return tx.Commit()
+}
+
+// Saves which kinds of activity a user wants to hear about, and how.  Only the kinds with something turned off are
+// stored, so kinds of activity added later start out turned on.
+func SetNotificationPrefs(userName string, prefs []NotificationPref) error {
+	tx, err := pdb.Begin()
+	if err != nil {
+		Log.Errorf("Couldn't begin database transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+	var userID int64
+	dbQuery := `
+		SELECT user_id
+		FROM users
+		WHERE lower(user_name) = lower($1)`
+	err = tx.QueryRow(dbQuery, userName).Scan(&userID)
+	if err != nil {
+		Log.Errorf("Looking up user '%s' failed: %v", userName, err)
+		return err
+	}
+	dbQuery = `
+		DELETE FROM notification_prefs
+		WHERE user_id = $1`
+	_, err = tx.Exec(dbQuery, userID)
+	if err != nil {
+		Log.Errorf("Clearing the notification preferences of '%s' failed: %v", userName, err)
+		return err
+	}
+	for _, p := range prefs {
+		if p.Email && p.InApp {
+			continue
+		}
+		dbQuery = `
+			INSERT INTO notification_prefs (user_id, event_type, email, in_app)
+			VALUES ($1, $2, $3, $4)`
+		_, err = tx.Exec(dbQuery, userID, p.Type, p.Email, p.InApp)
+		if err != nil {
+			Log.Errorf("Saving the notification preferences of '%s' failed: %v", userName, err)
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Archives or unarchives a project.
+func SetProjectArchived(owner string, folder string, fileName string, archived bool) error {
+	dbQuery := `
+		UPDATE sqlite_databases
+		SET archived = $4
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND folder = $2
+			AND db_name = $3
+			AND is_deleted = false`
+	commandTag, err := pdb.Exec(dbQuery, owner, folder, fileName, archived)
+	if err != nil {
+		Log.Errorf("Changing the archived state of '%s%s%s' failed: %v", owner, folder, fileName, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		errMsg := fmt.Sprintf("Wrong number of rows (%v) affected when changing the archived state of '%s%s%s'",
+			numRows, owner, folder, fileName)
+		Log.Error(errMsg)
+		return errors.New(errMsg)
+	}
+	return nil
+}
+
+// Sets the plan of the user with the given Stripe customer ID.  When a subscription is cancelled, the user is only
+// moved back to the free plan if it's the subscription they're currently on, so cancelling an old subscription after
+// starting a new one doesn't downgrade them.
+func SetStripeCustomerPlan(customer string, subscription string, plan string) (found bool, err error) {
+	dbQuery := `
+		UPDATE users
+		SET plan = $3, stripe_subscription = CASE WHEN $3 = 'free' THEN NULL ELSE $2 END
+		WHERE stripe_customer = $1
+			AND ($3 <> 'free' OR stripe_subscription IS NULL OR stripe_subscription = $2)`
+	commandTag, err := pdb.Exec(dbQuery, customer, subscription, plan)
+	if err != nil {
+		Log.Errorf("Setting the plan for Stripe customer '%s' failed: %v", customer, err)
+		return
+	}
+	if commandTag.RowsAffected() > 0 {
+		return true, nil
+	}
+
+	// Nothing was updated, so check whether the customer is one of ours
+	dbQuery = `
+		SELECT exists(SELECT 1 FROM users WHERE stripe_customer = $1)`
+	err = pdb.QueryRow(dbQuery, customer).Scan(&found)
+	if err != nil {
+		Log.Errorf("Looking up Stripe customer '%s' failed: %v", customer, err)
+	}
+	return
+}
+
+// Changes the email address for a user, cancelling any change of address waiting to be confirmed.
+func SetUserEmail(userName string, email string) error {
+	dbQuery := `
+		WITH changed AS (
+			UPDATE users
+			SET email = $2
+			WHERE lower(user_name) = lower($1)
+			RETURNING user_id
+		)
+		DELETE FROM email_changes
+		WHERE user_id IN (SELECT user_id FROM changed)`
+	_, err := pdb.Exec(dbQuery, userName, email)
+	if err != nil {
+		Log.Errorf("Changing the email address for user '%s' failed: %v", userName, err)
+	}
+	return err
+}
+
+// Sets how often a user is emailed about activity on the projects they watch.  Any events waiting for their next
+// digest are dropped if they no longer get digests.
+func SetUserEmailFrequency(userName string, freq EmailFrequency) error {
+	dbQuery := `
+		UPDATE users
+		SET pref_email_frequency = $2
+		WHERE lower(user_name) = lower($1)`
+	commandTag, err := pdb.Exec(dbQuery, userName, string(freq))
+	if err != nil {
+		Log.Errorf("Updating email frequency preference failed for user '%s'. Error: '%v'", userName, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		Log.Warnf("Wrong # of rows (%v) affected when updating email frequency preference. User: '%s'", numRows,
+			userName)
+	}
+	if freq == EMAIL_DAILY || freq == EMAIL_WEEKLY {
+		return nil
+	}
+	dbQuery = `
+		DELETE FROM digest_items
+		WHERE user_id = (SELECT user_id FROM users WHERE lower(user_name) = lower($1))`
+	_, err = pdb.Exec(dbQuery, userName)
+	if err != nil {
+		Log.Errorf("Removing the digest items for user '%s' failed: %v", userName, err)
+	}
+	return err
+}
+
+// Sets the user's preferences for the maximum number of SQLite rows to display, the language of the web pages, and
+// how dates are shown, along with their display name and email address.
+func SetUserPreferences(userName string, maxRows int, displayName string, email string, locale string,
+	timeZone string, dateFormat string) error {
+	dbQuery := `
+		UPDATE users
+		SET pref_max_rows = $2, display_name = $3, email = $4, pref_locale = nullif($5, ''),
+			pref_timezone = nullif($6, ''), pref_date_format = $7
+		WHERE lower(user_name) = lower($1)`
+	commandTag, err := pdb.Exec(dbQuery, userName, maxRows, displayName, email, locale, timeZone, dateFormat)
+	if err != nil {
+		Log.Errorf("Updating user preferences failed for user '%s'. Error: '%v'", userName, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		Log.Warnf("Wrong # of rows (%v) affected when updating user preferences. User: '%s'", numRows,
+			userName)
+	}
+	return nil
+}
+
+// Updates the public profile details for a user.
+func SetUserProfile(userName string, profile UserProfile) error {
+	dbQuery := `
+		UPDATE users
+		SET bio = nullif($2, ''), location = nullif($3, ''), website = nullif($4, ''), social_links = $5,
+			tip_links = $6
+		WHERE lower(user_name) = lower($1)`
+	commandTag, err := pdb.Exec(dbQuery, userName, profile.Bio, profile.Location, profile.Website,
+		profile.SocialLinks, profile.TipLinks)
+	if err != nil {
+		Log.Errorf("Updating profile failed for user '%s'. Error: '%v'", userName, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		Log.Warnf("Wrong # of rows (%v) affected when updating user profile. User: '%s'", numRows, userName)
+	}
+	return nil
+}
+
+// Adds (or replaces) the encrypted authenticator app secret for a user.  An empty secret removes it.
+func SetUserTOTP(userName string, sealed []byte) error {
+	dbQuery := `
+		UPDATE users
+		SET totp_secret = nullif($2, ''::bytea), totp_last_step = 0
+		WHERE lower(user_name) = lower($1)`
+	commandTag, err := pdb.Exec(dbQuery, userName, sealed)
+	if err != nil {
+		Log.Errorf("Changing the authenticator app for user '%s' failed: %v", userName, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		errMsg := fmt.Sprintf("Wrong number of rows (%v) affected when changing the authenticator app for user "+
+			"'%s'", numRows, userName)
+		Log.Error(errMsg)
+		return errors.New(errMsg)
+	}
+	return nil
+}
+
+// Sets the colour theme a user has chosen for the web pages.
+func SetUserTheme(userName string, theme Theme) error {
+	dbQuery := `
+		UPDATE users
+		SET pref_theme = $2
+		WHERE lower(user_name) = lower($1)`
+	commandTag, err := pdb.Exec(dbQuery, userName, string(theme))
+	if err != nil {
+		Log.Errorf("Updating theme preference failed for user '%s'. Error: '%v'", userName, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		Log.Warnf("Wrong # of rows (%v) affected when updating theme preference. User: '%s'", numRows, userName)
+	}
+	return nil
+}
+
+// Suspends or unsuspends a user account.  Suspending an account also ends any login sessions it has.
+func SetUserSuspended(userName string, suspended bool) error {
+	dbQuery := `
+		UPDATE users
+		SET suspended = $2, sessions_revoked_at = CASE WHEN $2 THEN now() ELSE sessions_revoked_at END
+		WHERE lower(user_name) = lower($1)`
+	commandTag, err := pdb.Exec(dbQuery, userName, suspended)
+	if err != nil {
+		Log.Errorf("Changing suspension status failed for user '%s'. Error: '%v'", userName, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		Log.Warnf("Wrong # of rows (%v) affected when changing suspension status. User: '%s'", numRows,
+			userName)
+	}
+	return InvalidateCachedData(accountStatusCacheKey(userName))
+}
+
+// Returns the project (and version) a short URL points to.  Short URLs for deleted projects aren't found.
+func ShortURL(code string) (s ShortURLEntry, found bool, err error) {
+	dbQuery := `
+		SELECT s.code, own.user_name, db.folder, db.db_name, s.commit_id, s.clicks, s.last_click, s.date_created
+		FROM short_urls AS s
+			JOIN sqlite_databases AS db ON db.db_id = s.db_id
+			JOIN users AS own ON own.user_id = db.user_id
+		WHERE s.code = $1
+			AND db.is_deleted = false`
+	var lastClick pgx.NullTime
+	err = pdb.QueryRow(dbQuery, code).Scan(&s.Code, &s.Owner, &s.Folder, &s.FileName, &s.CommitID, &s.Clicks,
+		&lastClick, &s.DateCreated)
+	if err == pgx.ErrNoRows {
+		return s, false, nil
+	}
+	if err != nil {
+		Log.Errorf("Retrieving short URL '%s' failed: %v", code, err)
+		return
+	}
+	if lastClick.Valid {
+		s.LastClick = lastClick.Time
+	}
+	return s, true, nil
+}
+
+// Returns the short URLs for a project, along with how often each has been followed.  The one for the latest version
+// comes first, then the ones for specific versions, newest first.
+func ShortURLs(owner string, folder string, fileName string) (list []ShortURLEntry, err error) {
+	dbQuery := `
+		SELECT s.code, s.commit_id, coalesce(u.user_name, ''), s.clicks, s.last_click, s.date_created
+		FROM short_urls AS s
+			JOIN sqlite_databases AS db ON db.db_id = s.db_id
+			LEFT JOIN users AS u ON u.user_id = s.created_by
+		WHERE db.user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND db.folder = $2
+			AND db.db_name = $3
+		ORDER BY s.commit_id <> '', s.date_created DESC`
+	rows, err := pdb.Query(dbQuery, owner, folder, fileName)
+	if err != nil {
+		Log.Errorf("Retrieving the short URLs for '%s%s%s' failed: %v", owner, folder, fileName, err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		s := ShortURLEntry{FileName: fileName, Folder: folder, Owner: owner}
+		var lastClick pgx.NullTime
+		err = rows.Scan(&s.Code, &s.CommitID, &s.CreatedBy, &s.Clicks, &lastClick, &s.DateCreated)
+		if err != nil {
+			Log.Errorf("Error retrieving the short URLs for '%s%s%s': %v", owner, folder, fileName, err)
+			return nil, err
+		}
+		if lastClick.Valid {
+			s.LastClick = lastClick.Time
+		}
+		list = append(list, s)
+	}
+	return
+}
+
+// Retrieve the latest social stats for a given database.
+func SocialStats(owner string, folder string, fileName string) (wa int, st int, fo int, err error) {
+
+	// TODO: Implement caching of these stats
+
+	// Retrieve latest star count
+	dbQuery := `
+		SELECT stars
+		FROM sqlite_databases
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND folder = $2
+			AND db_name = $3`
+	err = pdb.QueryRow(dbQuery, owner, folder, fileName).Scan(&st)
+	if err != nil {
+		Log.Errorf("Error retrieving star count for '%s%s%s': %v", owner, folder, fileName, err)
+		return -1, -1, -1, err
+	}
+
+	// Retrieve latest fork count
+	dbQuery = `
+		SELECT forks
+		FROM sqlite_databases
+		WHERE db_id = (
+				SELECT root_database
+				FROM sqlite_databases
+				WHERE user_id = (
+					SELECT user_id
+					FROM users
+					WHERE lower(user_name) = lower($1)
+					)
+			AND folder = $2
+			AND db_name = $3)`
+	err = pdb.QueryRow(dbQuery, owner, folder, fileName).Scan(&fo)
+	if err != nil {
+		Log.Errorf("Error retrieving fork count for '%s%s%s': %v", owner, folder, fileName, err)
+		return -1, -1, -1, err
+	}
+
+	// TODO: Implement watchers
+	return 0, st, fo, nil
+}
+
+// Records that a backup run has started.  Retries of a failed run start it again.
+func StartBackupRun(id int64) error {
+	dbQuery := `
+		UPDATE backup_runs
+		SET date_started = now(), date_finished = NULL, last_error = NULL
+		WHERE run_id = $1`
+	commandTag, err := pdb.Exec(dbQuery, id)
+	if err != nil {
+		Log.Errorf("Starting backup run %d failed: %v", id, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		return fmt.Errorf("Wrong number of rows (%v) affected when starting backup run %d", numRows, id)
+	}
+	return nil
+}
+
+// Records that a data export has started.  Retries of a failed export start it again.
+func StartDataExport(id int64) error {
+	dbQuery := `
+		UPDATE data_exports
+		SET status = 'running', date_finished = NULL, last_error = NULL
+		WHERE export_id = $1`
+	commandTag, err := pdb.Exec(dbQuery, id)
+	if err != nil {
+		Log.Errorf("Starting data export %d failed: %v", id, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		return fmt.Errorf("Wrong number of rows (%v) affected when starting data export %d", numRows, id)
+	}
+	return nil
+}
+
+// Starts reclaiming the username of an inactive account, which can then be completed once the grace period ends.
+// The returned value is false when the account isn't inactive for the given number of years, has something on the
+// site, or is already being reclaimed.
+func StartUsernameReclamation(adminUser string, userName string, reason string, years int,
+	grace time.Duration) (started bool, err error) {
+	dbQuery := `
+		INSERT INTO username_reclamations (user_id, requested_by, reason, reclaim_after)
+		SELECT u.user_id, $2, $3, now() + $5::bigint * interval '1 second'
+		FROM users AS u
+		WHERE lower(u.user_name) = lower($1)
+			AND u.user_name != 'default'
+			AND coalesce(u.last_seen, u.date_joined) < now() - $4::integer * interval '1 year'
+			AND ` + userHasNoContent + `
+		ON CONFLICT (user_id)
+			DO NOTHING`
+	commandTag, err := pdb.Exec(dbQuery, userName, adminUser, reason, years, int64(grace.Seconds()))
+	if err != nil {
+		Log.Errorf("Starting the reclamation of username '%s' failed: %v", userName, err)
+		return
+	}
+	return commandTag.RowsAffected() == 1, nil
+}
+
+// Retrieve the list of outstanding status updates for a user
+func StatusUpdates(loggedInUser string) (statusUpdates map[string][]StatusUpdateEntry, err error) {
+	dbQuery := `
+		SELECT status_updates
+		FROM users
+		WHERE user_name = $1`
+	err = pdb.QueryRow(dbQuery, loggedInUser).Scan(&statusUpdates)
+	if err != nil {
+		Log.Errorf("Error retrieving status updates list for user '%s': %v", loggedInUser, err)
+		return
+	}
+	return
+}
+
+// Periodically generates status updates from the event queue, emailing them to the watchers straight away or adding
+// them to their next digest
+func StatusUpdatesLoop() {
+	// Ensure a warning message is displayed on the console if the status update loop exits
+	defer func() {
+		Log.Warnf("Status update loop exited")
+	}()
+
+	// Log the start of the loop
+	Log.Infof("Status update processing loop started.  %d second refresh.", Conf().Event.Delay)
+
+	// Start the endless status update processing loop
+	var rows *pgx.Rows
+	var err error
+	type evEntry struct {
+		dbID      int64
+		details   EventDetails
+		eType     EventType
+		eventID   int64
+		timeStamp time.Time
+	}
+	for {
+		// When several instances are running, only one of them processes the events
+		if !HoldJobLock("status-updates", Conf().Event.Delay*time.Second) {
+			time.Sleep(Conf().Event.Delay * time.Second)
+			continue
+		}
+
+		// Begin a transaction
+		var tx *pgx.Tx
+		tx, err = pdb.Begin()
+		if err != nil {
+			Log.Errorf("Couldn't begin database transaction for status update processing loop: %s", err.Error())
+			continue
+		}
+
+		// Retrieve the list of outstanding events
+		// NOTE - We gather the db_id here instead of owner/folder/fileName as it should be faster for PG to deal
+		//        with when generating the watcher list
+		dbQuery := `
+			SELECT event_id, event_timestamp, db_id, event_type, event_data
+			FROM events
+			ORDER BY event_id ASC`
+		rows, err = tx.Query(dbQuery)
+		if err != nil {
+			Log.Errorf("Generating status update event list failed: %v", err)
+			tx.Rollback()
+			continue
+		}
+		evList := make(map[int64]evEntry)
+		for rows.Next() {
+			var ev evEntry
+			err = rows.Scan(&ev.eventID, &ev.timeStamp, &ev.dbID, &ev.eType, &ev.details)
+			if err != nil {
+				Log.Errorf("Error retrieving event list for status updates thread: %v", err)
+				rows.Close()
+				tx.Rollback()
+				continue
+			}
+			evList[ev.eventID] = ev
+		}
+		rows.Close()
+
+		// For each event, add a status update to the status_updates list for each watcher it's for
+		for id, ev := range evList {
+			// Retrieve the list of watchers for the database the event occurred on, along with how they want to hear
+			// about this kind of event.  The owner of the database always hears about new questions, even when they
+			// aren't watching it
+			dbQuery := `
+				SELECT w.user_id, coalesce(np.email, true), coalesce(np.in_app, true)
+				FROM watchers AS w
+					LEFT JOIN notification_prefs AS np ON np.user_id = w.user_id AND np.event_type = $2
+				WHERE w.db_id = $1
+				UNION
+				SELECT db.user_id, coalesce(np.email, true), coalesce(np.in_app, true)
+				FROM sqlite_databases AS db
+					LEFT JOIN notification_prefs AS np ON np.user_id = db.user_id AND np.event_type = $2
+				WHERE db.db_id = $1
+					AND $2 = $3`
+			rows, err = tx.Query(dbQuery, ev.dbID, ev.details.Type, EVENT_NEW_QUESTION)
+			if err != nil {
+				Log.Errorf("Database query failed: %v", err)
+				tx.Rollback()
+				continue
+			}
+			type watcher struct {
+				email bool
+				inApp bool
+				user  int64
+			}
+			var users []watcher
+			for rows.Next() {
+				var w watcher
+				err = rows.Scan(&w.user, &w.email, &w.inApp)
+				if err != nil {
+					Log.Errorf("Error retrieving user list for status updates thread: %v", err)
+					rows.Close()
+					tx.Rollback()
+					continue
+				}
+				users = append(users, w)
+			}
+			rows.Close()
+
+			// For each watcher, add the new status update to their existing list
+			// TODO: It might be better to store this list in Memcached instead of hitting the database like this
+			for _, w := range users {
+				u := w.user
+				if !w.email && !w.inApp {
+					continue
+				}
+				// Retrieve the current status updates list for the user
+				var eml pgx.NullString
+				dbQuery := `
+					SELECT user_name, email, status_updates, coalesce(pref_email_frequency, 'instant')
+					FROM users
+					WHERE user_id = $1`
+				userEvents := make(map[string][]StatusUpdateEntry)
+				var userName string
+				var freq EmailFrequency
+				err := tx.QueryRow(dbQuery, u).Scan(&userName, &eml, &userEvents, &freq)
+				if err != nil {
+					Log.Errorf("Database query failed: %v", err)
+					tx.Rollback()
+					continue
+				}
+
+				// Add the new event to the users status updates list, unless they've turned off seeing this kind of
+				// event on the site
+				fileName := fmt.Sprintf("%s%s%s", ev.details.Owner, ev.details.Folder, ev.details.DBName)
+				if w.inApp {
+					// Group the status updates by database, and coalesce multiple updates for the same discussion or
+					// MR into a single entry (keeping the most recent one of each)
+					var a StatusUpdateEntry
+					lst, ok := userEvents[fileName]
+					if ev.details.Type == EVENT_NEW_DISCUSSION || ev.details.Type == EVENT_NEW_MERGE_REQUEST || ev.details.Type == EVENT_NEW_COMMENT || ev.details.Type == EVENT_NEW_QUESTION {
+						if ok {
+							// Check if an entry already exists for the discussion/MR/comment
+							for i, j := range lst {
+								if j.DiscID == ev.details.DiscID {
+									// Yes, there's already an existing entry for the discussion/MR/comment so delete the old entry
+									lst = append(lst[:i], lst[i+1:]...) // Delete the old element
+								}
+							}
+						}
+					}
+					// Add the new entry
+					a.DiscID = ev.details.DiscID
+					a.Title = ev.details.Title
+					a.URL = ev.details.URL
+					lst = append(lst, a)
+					userEvents[fileName] = lst
+
+					// Save the updated list for the user back to PG
+					dbQuery = `
+						UPDATE users
+						SET status_updates = $2
+						WHERE user_id = $1`
+					commandTag, err := tx.Exec(dbQuery, u, userEvents)
+					if err != nil {
+						Log.Errorf("Adding status update for database ID '%d' to user '%s' failed: %v", ev.dbID,
+							u, err)
+						tx.Rollback()
+						continue
+					}
+					if numRows := commandTag.RowsAffected(); numRows != 1 {
+						Log.Warnf("Wrong number of rows affected (%v) when adding status update for database ID "+
+							"'%d' to user '%s'", numRows, ev.dbID, u)
+						tx.Rollback()
+						continue
+					}
+
+					// Count the number of status updates for the user, to be displayed in the webUI header row
+					var numUpdates int
+					for _, i := range userEvents {
+						numUpdates += len(i)
+					}
+
+					// Add an entry to memcached for the user, indicating they have outstanding status updates available
+					err = SetUserStatusUpdates(userName, numUpdates)
+					if err != nil {
+						Log.Errorf("Error when updating user status updates # in memcached: %v", err)
+						continue
+					}
+				}
+
+				// The remaining notifications are by email
+				if !w.email {
+					continue
+				}
+
+				// Users who get digests have the event added to their next one instead of being emailed now
+				if freq == EMAIL_DAILY || freq == EMAIL_WEEKLY {
+					dbQuery = `
+						INSERT INTO digest_items (user_id, db_id, event_type, project, title, url)
+						VALUES ($1, $2, $3, $4, $5, $6)`
+					_, err = tx.Exec(dbQuery, u, ev.dbID, ev.details.Type, fileName, ev.details.Title, ev.details.URL)
+					if err != nil {
+						Log.Errorf("Adding status update to the email digest for user '%s' failed: %v", userName, err)
+						tx.Rollback()
+					}
+					continue
+				}
+				if freq == EMAIL_NEVER {
+					continue
+				}
+
+				// Add an email for the status notification to the outgoing email queue
+				var msg, subj string
+				switch ev.details.Type {
+				case EVENT_NEW_DISCUSSION:
+					msg = fmt.Sprintf("A new discussion has been created for %s%s%s.\n\nVisit https://%s%s "+
+						"for the details", ev.details.Owner, ev.details.Folder, ev.details.DBName,
+						Conf().Web.ServerName, ev.details.URL)
+					subj = fmt.Sprintf("DBHub.io: New discussion created on %s%s%s", ev.details.Owner,
+						ev.details.Folder, ev.details.DBName)
+				case EVENT_NEW_MERGE_REQUEST:
+					msg = fmt.Sprintf("A new merge request has been created for %s%s%s.\n\nVisit https://%s%s "+
+						"for the details", ev.details.Owner, ev.details.Folder, ev.details.DBName,
+						Conf().Web.ServerName, ev.details.URL)
+					subj = fmt.Sprintf("DBHub.io: New merge request created on %s%s%s", ev.details.Owner,
+						ev.details.Folder, ev.details.DBName)
+				case EVENT_NEW_COMMENT:
 					msg = fmt.Sprintf("A new comment has been created for %s%s%s.\n\nVisit https://%s%s for "+
-						"the details", ev.details.Owner, ev.details.Folder, ev.details.DBName, Conf.Web.ServerName,
+						"the details", ev.details.Owner, ev.details.Folder, ev.details.DBName, Conf().Web.ServerName,
 						ev.details.URL)
 					subj = fmt.Sprintf("DBHub.io: New comment on %s%s%s", ev.details.Owner, ev.details.Folder,
 						ev.details.DBName)
+				case EVENT_NEW_QUESTION:
+					msg = fmt.Sprintf("A new question has been asked about %s%s%s: %s\n\nVisit https://%s%s to "+
+						"answer it", ev.details.Owner, ev.details.Folder, ev.details.DBName, ev.details.Title,
+						Conf().Web.ServerName, ev.details.URL)
+					subj = fmt.Sprintf("3DHub.io: New question about %s%s%s", ev.details.Owner, ev.details.Folder,
+						ev.details.DBName)
+				case EVENT_NEW_RELEASE:
+					msg = fmt.Sprintf("A new release (%s) has been made of %s%s%s.\n\nVisit https://%s%s for the "+
+						"details", ev.details.Title, ev.details.Owner, ev.details.Folder, ev.details.DBName,
+						Conf().Web.ServerName, ev.details.URL)
+					subj = fmt.Sprintf("3DHub.io: New release of %s%s%s", ev.details.Owner, ev.details.Folder,
+						ev.details.DBName)
+				case EVENT_NEW_VERSION:
+					msg = fmt.Sprintf("A new version of %s%s%s has been uploaded: %s\n\nVisit https://%s%s to see "+
+						"it", ev.details.Owner, ev.details.Folder, ev.details.DBName, ev.details.Title,
+						Conf().Web.ServerName, ev.details.URL)
+					subj = fmt.Sprintf("3DHub.io: New version of %s%s%s", ev.details.Owner, ev.details.Folder,
+						ev.details.DBName)
 				default:
-					log.Printf("Unknown message type when creating email message")
+					Log.Errorf("Unknown message type when creating email message")
 				}
 				if eml.Valid {
 					// TODO: Check if the email is username@thisserver, which indicates a non-functional email address
+					if link, err := UnsubscribeURL(u, userName); err == nil {
+						msg += "\n\nTo stop receiving these emails, visit " + link
+					}
 					dbQuery = `
 						INSERT INTO email_queue (mail_to, subject, body)
 						VALUES ($1, $2, $3)`
-					commandTag, err = tx.Exec(dbQuery, eml.String, subj, msg)
+					commandTag, err := tx.Exec(dbQuery, eml.String, subj, msg)
 					if err != nil {
-						log.Printf("Adding status update to email queue for user '%s' failed: %v", u, err)
+						Log.Errorf("Adding status update to email queue for user '%s' failed: %v", u, err)
 						tx.Rollback()
 						continue
 					}
 					if numRows := commandTag.RowsAffected(); numRows != 1 {
-						log.Printf("Wrong number of rows affected (%v) when adding status update to email"+
+						Log.Warnf("Wrong number of rows affected (%v) when adding status update to email"+
 							"queue for user '%s'", numRows, u)
 						tx.Rollback()
 						continue
@@ -2847,39 +7282,605 @@ func StatusUpdatesLoop() {
 				}
 			}
 
-			// Remove the processed event from PG
-			dbQuery = `
-				DELETE FROM events
-				WHERE event_id = $1`
-			commandTag, err := tx.Exec(dbQuery, id)
-			if err != nil {
-				log.Printf("Removing event ID '%d' failed: %v", id, err)
-				continue
-			}
-			if numRows := commandTag.RowsAffected(); numRows != 1 {
-				log.Printf("Wrong number of rows affected (%v) when removing event ID '%d'", numRows, id)
-				continue
-			}
+			// Remove the processed event from PG
+			dbQuery = `
+				DELETE FROM events
+				WHERE event_id = $1`
+			commandTag, err := tx.Exec(dbQuery, id)
+			if err != nil {
+				Log.Errorf("Removing event ID '%d' failed: %v", id, err)
+				continue
+			}
+			if numRows := commandTag.RowsAffected(); numRows != 1 {
+				Log.Warnf("Wrong number of rows affected (%v) when removing event ID '%d'", numRows, id)
+				continue
+			}
+		}
+
+		// Commit the transaction
+		err = tx.Commit()
+		if err != nil {
+			Log.Errorf("Could not commit transaction when processing status updates: %v", err.Error())
+			continue
+		}
+
+		// Wait before running the loop again
+		time.Sleep(Conf().Event.Delay * time.Second)
+	}
+	return
+}
+
+// Records how the latest call to a global webhook went.
+func StoreAdminWebhookResult(id int64, hookErr error) error {
+	var errMsg pgx.NullString
+	if hookErr != nil {
+		errMsg.String = hookErr.Error()
+		errMsg.Valid = true
+	}
+	dbQuery := `
+		UPDATE admin_webhooks
+		SET last_sent = now(), last_error = $2
+		WHERE hook_id = $1`
+	_, err := pdb.Exec(dbQuery, id, errMsg)
+	if err != nil {
+		Log.Errorf("Storing the result of global webhook %d failed: %v", id, err)
+	}
+	return err
+}
+
+// Records the result of re-analysing a project's file.  An empty problem means the file passed.
+func StoreAnalysisResult(owner string, folder string, fileName string, problem string) error {
+	var p pgx.NullString
+	if problem != "" {
+		p.String = problem
+		p.Valid = true
+	}
+	dbQuery := `
+		UPDATE sqlite_databases
+		SET analysed_at = now(), analysis_problem = $4
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND folder = $2
+			AND db_name = $3`
+	commandTag, err := pdb.Exec(dbQuery, owner, folder, fileName, p)
+	if err != nil {
+		Log.Errorf("Storing the analysis result for '%s%s%s' failed: %v", owner, folder, fileName, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		Log.Warnf("Wrong number of rows (%v) affected when storing the analysis result for '%s%s%s'", numRows,
+			owner, folder, fileName)
+	}
+	return nil
+}
+
+// Records how a backup run went.
+func StoreBackupResult(id int64, objectName string, size int64, numObjects int, backupErr error) error {
+	var errMsg pgx.NullString
+	if backupErr != nil {
+		errMsg.String = backupErr.Error()
+		errMsg.Valid = true
+	}
+	dbQuery := `
+		UPDATE backup_runs
+		SET date_finished = now(), success = $2, object_name = nullif($3, ''), size = $4, num_objects = $5,
+			last_error = $6
+		WHERE run_id = $1`
+	commandTag, err := pdb.Exec(dbQuery, id, backupErr == nil, objectName, size, numObjects, errMsg)
+	if err != nil {
+		Log.Errorf("Storing the result of backup run %d failed: %v", id, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		Log.Warnf("Wrong number of rows (%v) affected when storing the result of backup run %d", numRows, id)
+	}
+	return nil
+}
+
+// Updates the branches list for a database.
+func StoreBranches(owner string, folder string, fileName string, branches map[string]BranchEntry) error {
+	dbQuery := `
+		UPDATE sqlite_databases
+		SET branch_heads = $4, branches = $5
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+				)
+			AND folder = $2
+			AND db_name = $3`
+	commandTag, err := pdb.Exec(dbQuery, owner, folder, fileName, branches, len(branches))
+	if err != nil {
+		Log.Errorf("Updating branch heads for database '%s%s%s' to '%v' failed: %v", owner, folder,
+			fileName, branches, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		Log.Warnf(
+			"Wrong number of rows (%v) affected when updating branch heads for database '%s%s%s' to '%v'",
+			numRows, owner, folder, fileName, branches)
+	}
+	return nil
+}
+
+// Stores the checksums of a file kept in Minio.  Files with the same SHA256 have the same contents, so checksums which
+// have already been stored are left alone.
+func StoreChecksums(sums ObjectChecksums) error {
+	dbQuery := `
+		INSERT INTO object_checksums (db_sha256, md5, sha1, blake3)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (db_sha256)
+			DO NOTHING`
+	_, err := pdb.Exec(dbQuery, sums.SHA256, sums.MD5, sums.SHA1, sums.BLAKE3)
+	if err != nil {
+		Log.Errorf("Storing the checksums of file '%s' failed: %v", sums.SHA256, err)
+		return err
+	}
+	return nil
+}
+
+// Adds a comment to a discussion.
+func StoreComment(owner string, folder string, fileName string, commenter string, discID int, comText string,
+	discClose bool, mrState MergeRequestState) error {
+	// Begin a transaction
+	tx, err := pdb.Begin()
+	if err != nil {
+		return err
+	}
+	// Set up an automatic transaction roll back if the function exits without committing
+	defer tx.Rollback()
+
+	// Get the current details for the discussion or MR
+	var discCreator string
+	var discState bool
+	var discType int64
+	var discTitle string
+	dbQuery := `
+		SELECT disc.open, u.user_name, disc.discussion_type, disc.title
+		FROM discussions AS disc, users AS u
+		WHERE disc.db_id = (
+				SELECT db.db_id
+				FROM sqlite_databases AS db
+				WHERE db.user_id = (
+						SELECT user_id
+						FROM users
+						WHERE lower(user_name) = lower($1)
+					)
+					AND folder = $2
+					AND db_name = $3
+			)
+			AND disc.disc_id = $4
+			AND disc.creator = u.user_id`
+	err = tx.QueryRow(dbQuery, owner, folder, fileName, discID).Scan(&discState, &discCreator, &discType, &discTitle)
+	if err != nil {
+		Log.Errorf("Error retrieving current open state for '%s%s%s', discussion '%d': %v", owner,
+			folder, fileName, discID, err)
+		return err
+	}
+
+	// If the discussion is to be closed or reopened, ensure the person doing so is either the database owner or the
+	// person who started the discussion
+	if discClose == true {
+		if (strings.ToLower(commenter) != strings.ToLower(owner)) && (strings.ToLower(commenter) != strings.ToLower(discCreator)) {
+			return errors.New("Not authorised")
+		}
+	}
+
+	// If comment text was provided, insert it into the database
+	var commandTag pgx.CommandTag
+	var comID int64
+	if comText != "" {
+		dbQuery = `
+			WITH d AS (
+				SELECT db.db_id
+				FROM sqlite_databases AS db
+				WHERE db.user_id = (
+						SELECT user_id
+						FROM users
+						WHERE lower(user_name) = lower($1)
+					)
+					AND folder = $2
+					AND db_name = $3
+			), int AS (
+				SELECT internal_id AS int_id
+				FROM discussions
+				WHERE db_id = (SELECT db_id FROM d)
+				AND disc_id = $5
+			)
+			INSERT INTO discussion_comments (db_id, disc_id, commenter, body, entry_type)
+			SELECT (SELECT db_id FROM d), (SELECT int_id FROM int), (SELECT user_id FROM users WHERE lower(user_name) = lower($4)), $6, 'txt'
+			RETURNING com_id`
+		err = tx.QueryRow(dbQuery, owner, folder, fileName, commenter, discID, comText).Scan(&comID)
+		if err != nil {
+			Log.Errorf("Adding comment for database '%s%s%s', discussion '%d' failed: %v", owner, folder,
+				fileName, discID, err)
+			return err
+		}
+	}
+
+	// If the discussion is to be closed or reopened, insert a close or reopen record as appropriate
+	if discClose == true {
+		var eventTxt, eventType string
+		if discState {
+			// Discussion is open, so a close event should be inserted
+			eventTxt = "close"
+			eventType = "cls"
+		} else {
+			// Discussion is closed, so a re-open event should be inserted
+			eventTxt = "reopen"
+			eventType = "rop"
+		}
+
+		// Insert the appropriate close or reopen record
+		dbQuery = `
+			WITH d AS (
+				SELECT db.db_id
+				FROM sqlite_databases AS db
+				WHERE db.user_id = (
+						SELECT user_id
+						FROM users
+						WHERE lower(user_name) = lower($1)
+					)
+					AND folder = $2
+					AND db_name = $3
+			), int AS (
+				SELECT internal_id AS int_id
+				FROM discussions
+				WHERE db_id = (SELECT db_id FROM d)
+				AND disc_id = $5
+			)
+			INSERT INTO discussion_comments (db_id, disc_id, commenter, body, entry_type)
+			SELECT (SELECT db_id FROM d), (SELECT int_id FROM int), (SELECT user_id FROM users WHERE lower(user_name) = lower($4)), $6, $7`
+		commandTag, err = tx.Exec(dbQuery, owner, folder, fileName, commenter, discID, eventTxt, eventType)
+		if err != nil {
+			Log.Errorf("Adding comment for database '%s%s%s', discussion '%d' failed: %v", owner, folder,
+				fileName, discID, err)
+			return err
+		}
+		if numRows := commandTag.RowsAffected(); numRows != 1 {
+			Log.Warnf(
+				"Wrong number of rows (%v) affected when adding a comment to database '%s%s%s', discussion '%d'",
+				numRows, owner, folder, fileName, discID)
+		}
+	}
+
+	// Update the merge request state for MR's being closed
+	if discClose == true && discType == MERGE_REQUEST {
+		dbQuery = `
+			UPDATE discussions
+			SET mr_state = $5
+			WHERE db_id = (
+					SELECT db.db_id
+					FROM sqlite_databases AS db
+					WHERE db.user_id = (
+							SELECT user_id
+							FROM users
+							WHERE lower(user_name) = lower($1)
+						)
+						AND folder = $2
+						AND db_name = $3
+				)
+				AND disc_id = $4`
+		commandTag, err = tx.Exec(dbQuery, owner, folder, fileName, discID, mrState)
+		if err != nil {
+			Log.Errorf("Updating MR state for database '%s%s%s', discussion '%d' failed: %v", owner,
+				folder, fileName, discID, err)
+			return err
+		}
+		if numRows := commandTag.RowsAffected(); numRows != 1 {
+			Log.Warnf(
+				"Wrong number of rows (%v) affected when updating MR state for database '%s%s%s', discussion '%d'",
+				numRows, owner, folder, fileName, discID)
+		}
+	}
+
+	// Update the last_modified date for the parent discussion
+	dbQuery = `
+		UPDATE discussions
+		SET last_modified = now()`
+	if discClose == true {
+		if discState {
+			// Discussion is open, so set it to closed
+			dbQuery += `, open = false`
+		} else {
+			// Discussion is closed, so set it to open
+			dbQuery += `, open = true`
 		}
+	}
+	if comText != "" {
+		dbQuery += `, comment_count = comment_count + 1`
+	}
+	dbQuery += `
+		WHERE db_id = (
+				SELECT db.db_id
+				FROM sqlite_databases AS db
+				WHERE db.user_id = (
+						SELECT user_id
+						FROM users
+						WHERE lower(user_name) = lower($1)
+					)
+					AND folder = $2
+					AND db_name = $3
+			)
+			AND disc_id = $4`
+	commandTag, err = tx.Exec(dbQuery, owner, folder, fileName, discID)
+	if err != nil {
+		Log.Errorf("Updating last modified date for database '%s%s%s', discussion '%d' failed: %v", owner,
+			folder, fileName, discID, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		Log.Warnf(
+			"Wrong number of rows (%v) affected when updating last_modified date for database '%s%s%s', discussion '%d'",
+			numRows, owner, folder, fileName, discID)
+	}
 
-		// Commit the transaction
-		err = tx.Commit()
+	// Update the open discussion and MR counters for the database
+	dbQuery = `
+		WITH d AS (
+			SELECT db.db_id
+			FROM sqlite_databases AS db
+			WHERE db.user_id = (
+					SELECT user_id
+					FROM users
+					WHERE lower(user_name) = lower($1)
+				)
+				AND folder = $2
+				AND db_name = $3
+		)
+		UPDATE sqlite_databases
+		SET discussions = (
+				SELECT count(disc.*)
+				FROM discussions AS disc, d
+				WHERE disc.db_id = d.db_id
+					AND open = true
+					AND discussion_type = 0
+			),
+			merge_requests = (
+				SELECT count(disc.*)
+				FROM discussions AS disc, d
+				WHERE disc.db_id = d.db_id
+					AND open = true
+					AND discussion_type = 1
+			)
+		WHERE db_id = (SELECT db_id FROM d)`
+	commandTag, err = tx.Exec(dbQuery, owner, folder, fileName)
+	if err != nil {
+		Log.Errorf("Updating discussion count for database '%s%s%s' failed: %v", owner, folder, fileName,
+			err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		Log.Warnf(
+			"Wrong number of rows (%v) affected when updating discussion count for database '%s%s%s'",
+			numRows, owner, folder, fileName)
+	}
+
+	// If comment text was provided, generate an event about the new comment
+	if comText != "" {
+		var commentURL string
+		if discType == MERGE_REQUEST {
+			commentURL = fmt.Sprintf("/merge/%s%s%s?id=%d#c%d", url.PathEscape(owner), folder,
+				url.PathEscape(fileName), discID, comID)
+		} else if discType == QUESTION {
+			commentURL = fmt.Sprintf("/questions/%s%s%s?id=%d#c%d", url.PathEscape(owner), folder,
+				url.PathEscape(fileName), discID, comID)
+		} else {
+			commentURL = fmt.Sprintf("/discuss/%s%s%s?id=%d#c%d", url.PathEscape(owner), folder,
+				url.PathEscape(fileName), discID, comID)
+		}
+		details := EventDetails{
+			DBName:   fileName,
+			DiscID:   discID,
+			Folder:   folder,
+			Owner:    owner,
+			Type:     EVENT_NEW_COMMENT,
+			Title:    discTitle,
+			URL:      commentURL,
+			UserName: commenter,
+		}
+		err = NewEvent(details)
 		if err != nil {
-			log.Printf("Could not commit transaction when processing status updates: %v", err.Error())
-			continue
+			Log.Errorf("Error when creating a new event: %s", err.Error())
+			return err
 		}
+	}
 
-		// Wait before running the loop again
-		time.Sleep(Conf.Event.Delay * time.Second)
+	// Commit the transaction
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Updates the commit list for a database.
+func StoreCommits(owner string, folder string, fileName string, commitList map[string]CommitEntry) error {
+	dbQuery := `
+		UPDATE sqlite_databases
+		SET commit_list = $4, last_modified = now()
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+				)
+			AND folder = $2
+			AND db_name = $3`
+	commandTag, err := pdb.Exec(dbQuery, owner, folder, fileName, commitList)
+	if err != nil {
+		Log.Errorf("Updating commit list for database '%s%s%s' failed: %v", owner, folder, fileName, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		Log.Warnf(
+			"Wrong number of rows (%v) affected when updating commit list for database '%s%s%s'", numRows,
+			owner, folder, fileName)
+	}
+	return nil
+}
+
+// Stores database details in PostgreSQL, and the database data itself in Minio.
+func StoreFile(owner string, folder string, fileName string, branches map[string]BranchEntry, c CommitEntry,
+	pub bool, buf *os.File, sha string, dbSize int64, oneLineDesc string, fullDesc string, createDefBranch bool,
+	branchName string, sourceURL string) error {
+	// Store the database file
+	err := StoreDatabaseFile(buf, sha, dbSize)
+	if err != nil {
+		return err
+	}
+
+	// Check for values which should be NULL
+	var nullable1LineDesc, nullableFullDesc pgx.NullString
+	if oneLineDesc == "" {
+		nullable1LineDesc.Valid = false
+	} else {
+		nullable1LineDesc.String = oneLineDesc
+		nullable1LineDesc.Valid = true
+	}
+	if fullDesc == "" {
+		nullableFullDesc.Valid = false
+	} else {
+		nullableFullDesc.String = fullDesc
+		nullableFullDesc.Valid = true
+	}
+
+	// Store the database metadata
+	cMap := map[string]CommitEntry{c.ID: c}
+	var commandTag pgx.CommandTag
+	dbQuery := `
+		WITH root AS (
+			SELECT nextval('sqlite_databases_db_id_seq') AS val
+		)
+		INSERT INTO sqlite_databases (user_id, db_id, folder, db_name, public, one_line_description, full_description,
+			branch_heads, root_database, commit_list`
+	if sourceURL != "" {
+		dbQuery += `, source_url`
+	}
+	dbQuery +=
+		`)
+		SELECT (
+			SELECT user_id
+			FROM users
+			WHERE lower(user_name) = lower($1)), (SELECT val FROM root), $2, $3, $4, $5, $6, $8, (SELECT val FROM root), $7`
+	if sourceURL != "" {
+		dbQuery += `, $9`
+	}
+	dbQuery += `
+		ON CONFLICT (user_id, folder, db_name)
+			DO UPDATE
+			SET commit_list = sqlite_databases.commit_list || $7,
+				branch_heads = sqlite_databases.branch_heads || $8,
+				last_modified = now()`
+	if sourceURL != "" {
+		dbQuery += `,
+			source_url = $9`
+		commandTag, err = pdb.Exec(dbQuery, owner, folder, fileName, pub, nullable1LineDesc, nullableFullDesc,
+			cMap, branches, sourceURL)
+	} else {
+		commandTag, err = pdb.Exec(dbQuery, owner, folder, fileName, pub, nullable1LineDesc, nullableFullDesc,
+			cMap, branches)
+	}
+	if err != nil {
+		Log.Errorf("Storing database '%s%s%s' failed: %v", owner, folder, fileName, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		Log.Warnf("Wrong number of rows (%v) affected while storing database '%s%s%s'", numRows, owner,
+			folder, fileName)
+	}
+
+	if createDefBranch {
+		err = StoreDefaultBranchName(owner, folder, fileName, branchName)
+		if err != nil {
+			Log.Errorf("Storing default branch '%s' name for '%s%s%s' failed: %v", branchName, owner,
+				folder, fileName, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// Records how a data export went.  Failed exports expire too, so they drop off the user's list.
+func StoreDataExportResult(id int64, objectName string, size int64, exportErr error, expires time.Time) error {
+	status := "done"
+	var errMsg pgx.NullString
+	if exportErr != nil {
+		status = "failed"
+		errMsg.String = exportErr.Error()
+		errMsg.Valid = true
+	}
+	dbQuery := `
+		UPDATE data_exports
+		SET status = $2, date_finished = now(), expires = $3, object_name = nullif($4, ''), size = $5,
+			last_error = $6
+		WHERE export_id = $1`
+	commandTag, err := pdb.Exec(dbQuery, id, status, expires, objectName, size, errMsg)
+	if err != nil {
+		Log.Errorf("Storing the result of data export %d failed: %v", id, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		Log.Warnf("Wrong number of rows (%v) affected when storing the result of data export %d", numRows, id)
+	}
+	return nil
+}
+
+// Returns the stored checksums of a file kept in Minio.  Files stored before checksums were kept won't have them.
+func StoredChecksums(sha string) (sums ObjectChecksums, found bool, err error) {
+	dbQuery := `
+		SELECT md5, sha1, blake3
+		FROM object_checksums
+		WHERE db_sha256 = $1`
+	err = pdb.QueryRow(dbQuery, sha).Scan(&sums.MD5, &sums.SHA1, &sums.BLAKE3)
+	if err == pgx.ErrNoRows {
+		return sums, false, nil
+	}
+	if err != nil {
+		Log.Errorf("Retrieving the checksums of file '%s' failed: %v", sha, err)
+		return
+	}
+	sums.SHA256 = sha
+	return sums, true, nil
+}
+
+// Stores the default branch name for a database.
+func StoreDefaultBranchName(owner string, folder string, fileName string, branchName string) error {
+	dbQuery := `
+		UPDATE sqlite_databases
+		SET default_branch = $4
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+				)
+			AND folder = $2
+			AND db_name = $3`
+	commandTag, err := pdb.Exec(dbQuery, owner, folder, fileName, branchName)
+	if err != nil {
+		Log.Errorf("Changing default branch for database '%v' to '%v' failed: %v", fileName, branchName, err)
+		return err
 	}
-	return
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		Log.Warnf("Wrong number of rows (%v) affected during update: database: %v, new branch name: '%v'",
+			numRows, fileName, branchName)
+	}
+	return nil
 }
 
-// Updates the branches list for a database.
-func StoreBranches(owner string, folder string, fileName string, branches map[string]BranchEntry) error {
+// Stores the default table name for a database.
+func StoreDefaultTableName(owner string, folder string, fileName string, tableName string) error {
+	var t pgx.NullString
+	if tableName != "" {
+		t.String = tableName
+		t.Valid = true
+	}
 	dbQuery := `
 		UPDATE sqlite_databases
-		SET branch_heads = $4, branches = $5
+		SET default_table = $4
 		WHERE user_id = (
 				SELECT user_id
 				FROM users
@@ -2887,593 +7888,830 @@ func StoreBranches(owner string, folder string, fileName string, branches map[st
 				)
 			AND folder = $2
 			AND db_name = $3`
-	commandTag, err := pdb.Exec(dbQuery, owner, folder, fileName, branches, len(branches))
+	commandTag, err := pdb.Exec(dbQuery, owner, folder, fileName, t)
 	if err != nil {
-		log.Printf("Updating branch heads for database '%s%s%s' to '%v' failed: %v\n", owner, folder,
-			fileName, branches, err)
+		Log.Errorf("Changing default table for database '%v' to '%v' failed: %v", fileName, tableName, err)
 		return err
 	}
 	if numRows := commandTag.RowsAffected(); numRows != 1 {
-		log.Printf(
-			"Wrong number of rows (%v) affected when updating branch heads for database '%s%s%s' to '%v'\n",
-			numRows, owner, folder, fileName, branches)
+		Log.Warnf("Wrong number of rows (%v) affected during update: database: %v, new table name: '%v'",
+			numRows, fileName, tableName)
 	}
 	return nil
 }
 
-// Adds a comment to a discussion.
-func StoreComment(owner string, folder string, fileName string, commenter string, discID int, comText string,
-	discClose bool, mrState MergeRequestState) error {
+// Stores a new discussion for a database.
+func StoreDiscussion(owner string, folder string, fileName string, loggedInUser string, title string, text string,
+	discType DiscussionType, mr MergeRequestEntry) (newID int, err error) {
+
 	// Begin a transaction
 	tx, err := pdb.Begin()
 	if err != nil {
-		return err
+		return
 	}
 	// Set up an automatic transaction roll back if the function exits without committing
 	defer tx.Rollback()
 
-	// Get the current details for the discussion or MR
-	var discCreator string
-	var discState bool
-	var discType int64
-	var discTitle string
+	// Add the discussion details to PostgreSQL
 	dbQuery := `
-		SELECT disc.open, u.user_name, disc.discussion_type, disc.title
-		FROM discussions AS disc, users AS u
-		WHERE disc.db_id = (
-				SELECT db.db_id
-				FROM sqlite_databases AS db
-				WHERE db.user_id = (
-						SELECT user_id
-						FROM users
-						WHERE lower(user_name) = lower($1)
-					)
-					AND folder = $2
-					AND db_name = $3
+		WITH u AS (
+			SELECT user_id
+			FROM users
+			WHERE lower(user_name) = lower($1)
+		), d AS (
+			SELECT db.db_id
+			FROM sqlite_databases AS db, u
+			WHERE db.user_id = u.user_id
+				AND db.folder = $2
+				AND db.db_name = $3
+		), next_id AS (
+			SELECT coalesce(max(disc.disc_id), 0) + 1 AS id
+			FROM discussions AS disc, d
+			WHERE disc.db_id = d.db_id
+		)
+		INSERT INTO discussions (db_id, disc_id, creator, title, description, open, discussion_type`
+	if discType == MERGE_REQUEST {
+		dbQuery += `, mr_source_db_id, mr_source_db_branch, mr_destination_branch, mr_commits`
+	}
+	dbQuery += `
 			)
-			AND disc.disc_id = $4
-			AND disc.creator = u.user_id`
-	err = tx.QueryRow(dbQuery, owner, folder, fileName, discID).Scan(&discState, &discCreator, &discType, &discTitle)
-	if err != nil {
-		log.Printf("Error retrieving current open state for '%s%s%s', discussion '%d': %v\n", owner,
-			folder, fileName, discID, err)
-		return err
+		SELECT (SELECT db_id FROM d),
+			(SELECT id FROM next_id),
+			(SELECT user_id FROM users WHERE lower(user_name) = lower($4)),
+			$5,
+			$6,
+			true,
+			$7`
+	if discType == MERGE_REQUEST {
+		dbQuery += `,(
+			SELECT db_id
+			FROM sqlite_databases
+			WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($8))
+			AND folder = $9
+			AND db_name = $10
+			AND is_deleted = false
+		), $11, $12, $13`
 	}
-
-	// If the discussion is to be closed or reopened, ensure the person doing so is either the database owner or the
-	// person who started the discussion
-	if discClose == true {
-		if (strings.ToLower(commenter) != strings.ToLower(owner)) && (strings.ToLower(commenter) != strings.ToLower(discCreator)) {
-			return errors.New("Not authorised")
-		}
+	dbQuery += `
+		RETURNING (SELECT id FROM next_id)`
+	if discType == MERGE_REQUEST {
+		err = tx.QueryRow(dbQuery, owner, folder, fileName, loggedInUser, title, text, discType, mr.SourceOwner,
+			mr.SourceFolder, mr.SourceDBName, mr.SourceBranch, mr.DestBranch, mr.Commits).Scan(&newID)
+	} else {
+		err = tx.QueryRow(dbQuery, owner, folder, fileName, loggedInUser, title, text, discType).Scan(&newID)
 	}
-
-	// If comment text was provided, insert it into the database
-	var commandTag pgx.CommandTag
-	var comID int64
-	if comText != "" {
-		dbQuery = `
-			WITH d AS (
-				SELECT db.db_id
-				FROM sqlite_databases AS db
-				WHERE db.user_id = (
-						SELECT user_id
-						FROM users
-						WHERE lower(user_name) = lower($1)
-					)
-					AND folder = $2
-					AND db_name = $3
-			), int AS (
-				SELECT internal_id AS int_id
-				FROM discussions
-				WHERE db_id = (SELECT db_id FROM d)
-				AND disc_id = $5
-			)
-			INSERT INTO discussion_comments (db_id, disc_id, commenter, body, entry_type)
-			SELECT (SELECT db_id FROM d), (SELECT int_id FROM int), (SELECT user_id FROM users WHERE lower(user_name) = lower($4)), $6, 'txt'
-			RETURNING com_id`
-		err = tx.QueryRow(dbQuery, owner, folder, fileName, commenter, discID, comText).Scan(&comID)
-		if err != nil {
-			log.Printf("Adding comment for database '%s%s%s', discussion '%d' failed: %v\n", owner, folder,
-				fileName, discID, err)
-			return err
-		}
+	if err != nil {
+		Log.Errorf("Adding new discussion or merge request '%s' for '%s%s%s' failed: %v", title, owner,
+			folder, fileName, err)
+		return
 	}
 
-	// If the discussion is to be closed or reopened, insert a close or reopen record as appropriate
-	if discClose == true {
-		var eventTxt, eventType string
-		if discState {
-			// Discussion is open, so a close event should be inserted
-			eventTxt = "close"
-			eventType = "cls"
+	// Increment the discussion or merge request counter for the database.  Questions don't have a counter
+	if discType != QUESTION {
+		dbQuery = `
+			UPDATE sqlite_databases`
+		if discType == DISCUSSION {
+			dbQuery += `
+				SET discussions = discussions + 1`
 		} else {
-			// Discussion is closed, so a re-open event should be inserted
-			eventTxt = "reopen"
-			eventType = "rop"
+			dbQuery += `
+				SET merge_requests = merge_requests + 1`
 		}
-
-		// Insert the appropriate close or reopen record
-		dbQuery = `
-			WITH d AS (
-				SELECT db.db_id
-				FROM sqlite_databases AS db
-				WHERE db.user_id = (
-						SELECT user_id
-						FROM users
-						WHERE lower(user_name) = lower($1)
-					)
-					AND folder = $2
-					AND db_name = $3
-			), int AS (
-				SELECT internal_id AS int_id
-				FROM discussions
-				WHERE db_id = (SELECT db_id FROM d)
-				AND disc_id = $5
-			)
-			INSERT INTO discussion_comments (db_id, disc_id, commenter, body, entry_type)
-			SELECT (SELECT db_id FROM d), (SELECT int_id FROM int), (SELECT user_id FROM users WHERE lower(user_name) = lower($4)), $6, $7`
-		commandTag, err = tx.Exec(dbQuery, owner, folder, fileName, commenter, discID, eventTxt, eventType)
+		dbQuery += `
+			WHERE user_id = (
+					SELECT user_id
+					FROM users
+					WHERE lower(user_name) = lower($1)
+				)
+				AND folder = $2
+				AND db_name = $3`
+		commandTag, err := tx.Exec(dbQuery, owner, folder, fileName)
 		if err != nil {
-			log.Printf("Adding comment for database '%s%s%s', discussion '%d' failed: %v\n", owner, folder,
-				fileName, discID, err)
-			return err
+			Log.Errorf("Updating discussion counter for '%s%s%s' failed: %v", owner, folder, fileName, err)
+			return 0, err
 		}
 		if numRows := commandTag.RowsAffected(); numRows != 1 {
-			log.Printf(
-				"Wrong number of rows (%v) affected when adding a comment to database '%s%s%s', discussion '%d'\n",
-				numRows, owner, folder, fileName, discID)
+			Log.Warnf("Wrong number of rows (%v) affected when updating discussion counter for '%s%s%s'",
+				numRows, owner, folder, fileName)
 		}
 	}
 
-	// Update the merge request state for MR's being closed
-	if discClose == true && discType == MERGE_REQUEST {
-		dbQuery = `
-			UPDATE discussions
-			SET mr_state = $5
-			WHERE db_id = (
-					SELECT db.db_id
-					FROM sqlite_databases AS db
-					WHERE db.user_id = (
-							SELECT user_id
-							FROM users
-							WHERE lower(user_name) = lower($1)
-						)
-						AND folder = $2
-						AND db_name = $3
-				)
-				AND disc_id = $4`
-		commandTag, err = tx.Exec(dbQuery, owner, folder, fileName, discID, mrState)
-		if err != nil {
-			log.Printf("Updating MR state for database '%s%s%s', discussion '%d' failed: %v\n", owner,
-				folder, fileName, discID, err)
-			return err
-		}
-		if numRows := commandTag.RowsAffected(); numRows != 1 {
-			log.Printf(
-				"Wrong number of rows (%v) affected when updating MR state for database '%s%s%s', discussion '%d'\n",
-				numRows, owner, folder, fileName, discID)
-		}
+	// Commit the transaction
+	err = tx.Commit()
+	if err != nil {
+		return
+	}
+	return
+}
+
+// Stores a change of email address to be confirmed, replacing any earlier one for the user.  An empty token means that
+// address doesn't need to confirm the change.
+func StoreEmailChange(userName string, newEmail string, oldToken string, newToken string, expires time.Time) error {
+	dbQuery := `
+		INSERT INTO email_changes (user_id, new_email, old_token, new_token, expires)
+		SELECT user_id, $2, nullif($3, ''), nullif($4, ''), $5
+		FROM users
+		WHERE lower(user_name) = lower($1)
+		ON CONFLICT (user_id)
+			DO UPDATE
+			SET new_email = excluded.new_email, old_token = excluded.old_token, new_token = excluded.new_token,
+				date_requested = now(), expires = excluded.expires`
+	commandTag, err := pdb.Exec(dbQuery, userName, newEmail, oldToken, newToken, expires)
+	if err != nil {
+		Log.Errorf("Storing the email change for user '%s' failed: %v", userName, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		Log.Warnf("Wrong number of rows (%v) affected when storing the email change for user '%s'", numRows,
+			userName)
+	}
+	return nil
+}
+
+// Turns a feature on or off.
+func StoreFeatureFlag(adminUser string, id string, enabled bool) error {
+	dbQuery := `
+		INSERT INTO feature_flags (name, enabled, changed_by)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (name)
+			DO UPDATE
+			SET enabled = excluded.enabled, changed_by = excluded.changed_by, date_changed = now()`
+	_, err := pdb.Exec(dbQuery, id, enabled, adminUser)
+	if err != nil {
+		Log.Errorf("Setting the '%s' feature flag failed: %v", id, err)
+	}
+	return err
+}
+
+// Adds a GitHub repository to be imported, returning the ID of the new import.
+func StoreGitHubImport(imp GitHubImportEntry) (id int64, err error) {
+	dbQuery := `
+		INSERT INTO github_imports (user_id, repo, branch, path, licence, public, schedule, webhook_secret)
+		SELECT user_id, $2, $3, $4, $5, $6, $7, $8
+		FROM users
+		WHERE lower(user_name) = lower($1)
+		RETURNING import_id`
+	err = pdb.QueryRow(dbQuery, imp.Owner, imp.Repo, imp.Branch, imp.Path, imp.Licence, imp.Public, imp.Schedule,
+		imp.WebhookSecret).Scan(&id)
+	if err != nil {
+		Log.Errorf("Adding GitHub import of '%s' for '%s' failed: %v", imp.Repo, imp.Owner, err)
+	}
+	return
+}
+
+// Records the project created from a file in a GitHub repository, along with the Git blob SHA of the file, so it's
+// only updated when the file changes.
+func StoreGitHubImportFile(id int64, owner string, folder string, fileName string, repoPath string,
+	blobSHA string) error {
+	dbQuery := `
+		INSERT INTO github_import_files (import_id, db_id, repo_path, blob_sha)
+		SELECT $1, db_id, $5, $6
+		FROM sqlite_databases
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($2)
+			)
+			AND folder = $3
+			AND db_name = $4
+			AND is_deleted = false
+		ON CONFLICT (import_id, repo_path)
+			DO UPDATE SET db_id = excluded.db_id, blob_sha = excluded.blob_sha`
+	commandTag, err := pdb.Exec(dbQuery, id, owner, folder, fileName, repoPath, blobSHA)
+	if err != nil {
+		Log.Errorf("Storing the GitHub import file '%s' for '%s%s%s' failed: %v", repoPath, owner, folder, fileName,
+			err)
+		return err
 	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		errMsg := fmt.Sprintf("Wrong number of rows (%v) affected when storing the GitHub import file '%s' for "+
+			"'%s%s%s'", numRows, repoPath, owner, folder, fileName)
+		Log.Error(errMsg)
+		return errors.New(errMsg)
+	}
+	return nil
+}
 
-	// Update the last_modified date for the parent discussion
-	dbQuery = `
-		UPDATE discussions
-		SET last_modified = now()`
-	if discClose == true {
-		if discState {
-			// Discussion is open, so set it to closed
-			dbQuery += `, open = false`
-		} else {
-			// Discussion is closed, so set it to open
-			dbQuery += `, open = true`
+// Records the outcome of syncing a GitHub import.  The commit is only updated when the sync worked, so a failed one
+// is tried again in full next time.
+func StoreGitHubSyncResult(id int64, commit string, syncErr string) error {
+	dbQuery := `
+		UPDATE github_imports
+		SET last_commit = coalesce(nullif($2, ''), last_commit), last_error = nullif($3, ''), last_synced = now()
+		WHERE import_id = $1`
+	_, err := pdb.Exec(dbQuery, id, commit, syncErr)
+	if err != nil {
+		Log.Errorf("Storing the sync result for GitHub import '%d' failed: %v", id, err)
+	}
+	return err
+}
+
+// Sets where a project is mirrored to on GitHub.  An empty token keeps the existing one, and new ones are stored
+// encrypted.  Changing the repository or branch starts the mirroring afresh, from the latest commit.
+func StoreGitHubMirror(owner string, folder string, fileName string, repo string, branch string, repoPath string,
+	token string) error {
+	var sealed []byte
+	if token != "" {
+		var err error
+		sealed, err = sealSecret(token)
+		if err != nil {
+			Log.Errorf("Encrypting the GitHub access token of '%s%s%s' failed: %v", owner, folder, fileName, err)
+			return errors.New("The access token couldn't be stored")
 		}
 	}
-	if comText != "" {
-		dbQuery += `, comment_count = comment_count + 1`
+	dbQuery := `
+		INSERT INTO github_mirrors (db_id, repo, branch, path, token)
+		SELECT db_id, $4, $5, $6, $7
+		FROM sqlite_databases
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND folder = $2
+			AND db_name = $3
+			AND is_deleted = false
+		ON CONFLICT (db_id)
+			DO UPDATE
+			SET repo = excluded.repo,
+				branch = excluded.branch,
+				path = excluded.path,
+				token = coalesce(nullif(excluded.token, ''::bytea), github_mirrors.token),
+				pushed_commits = CASE WHEN (github_mirrors.repo, github_mirrors.branch) = (excluded.repo, excluded.branch)
+					THEN github_mirrors.pushed_commits ELSE '{}' END,
+				pushed_releases = CASE WHEN (github_mirrors.repo, github_mirrors.branch) = (excluded.repo, excluded.branch)
+					THEN github_mirrors.pushed_releases ELSE '{}' END,
+				last_commit = CASE WHEN (github_mirrors.repo, github_mirrors.branch) = (excluded.repo, excluded.branch)
+					THEN github_mirrors.last_commit END,
+				last_error = NULL`
+	commandTag, err := pdb.Exec(dbQuery, owner, folder, fileName, repo, branch, repoPath, sealed)
+	if err != nil {
+		Log.Errorf("Storing the GitHub mirror for '%s%s%s' failed: %v", owner, folder, fileName, err)
+		return err
 	}
-	dbQuery += `
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		errMsg := fmt.Sprintf("Wrong number of rows (%v) affected when storing the GitHub mirror for '%s%s%s'",
+			numRows, owner, folder, fileName)
+		Log.Error(errMsg)
+		return errors.New(errMsg)
+	}
+	return nil
+}
+
+// Records the outcome of pushing a project to its GitHub mirror.
+func StoreGitHubMirrorResult(owner string, folder string, fileName string, m GitHubMirrorEntry, pushErr string) error {
+	dbQuery := `
+		UPDATE github_mirrors
+		SET pushed_commits = $4, pushed_releases = $5, last_commit = nullif($6, ''), last_error = nullif($7, ''),
+			last_pushed = now()
 		WHERE db_id = (
-				SELECT db.db_id
-				FROM sqlite_databases AS db
-				WHERE db.user_id = (
+				SELECT db_id
+				FROM sqlite_databases
+				WHERE user_id = (
 						SELECT user_id
 						FROM users
 						WHERE lower(user_name) = lower($1)
 					)
 					AND folder = $2
 					AND db_name = $3
+					AND is_deleted = false
+			)`
+	_, err := pdb.Exec(dbQuery, owner, folder, fileName, m.PushedCommits, m.PushedReleases, m.LastCommit, pushErr)
+	if err != nil {
+		Log.Errorf("Storing the GitHub mirror result for '%s%s%s' failed: %v", owner, folder, fileName, err)
+	}
+	return err
+}
+
+// Records a file sent through a guest upload link, as waiting for the owner of the project to approve it.  Projects
+// can only have a limited number of files waiting, so a leaked link can't be used to fill up the server.
+func StoreGuestUpload(token string, name string, email string, message string, sha string, size int64,
+	maxPending int) error {
+	dbQuery := `
+		INSERT INTO guest_uploads (db_id, uploader_name, uploader_email, message, sha256, size)
+		SELECT link.db_id, $2, nullif($3, ''), nullif($4, ''), $5, $6
+		FROM guest_upload_links AS link
+		WHERE link.token = $1
+			AND link.expiry_date > now()
+			AND (
+				SELECT count(*)
+				FROM guest_uploads
+				WHERE db_id = link.db_id
+			) < $7`
+	commandTag, err := pdb.Exec(dbQuery, token, name, email, message, sha, size, maxPending)
+	if err != nil {
+		Log.Errorf("Storing a guest upload failed: %v", err)
+		return err
+	}
+	if commandTag.RowsAffected() != 1 {
+		return errors.New("This upload link has expired, or the project has too many uploads waiting for approval")
+	}
+	return nil
+}
+
+// Adds a guest upload link to a project, which works until the given expiry time.
+func StoreGuestUploadLink(owner string, folder string, fileName string, token string, expiry time.Time) error {
+	dbQuery := `
+		INSERT INTO guest_upload_links (db_id, token, expiry_date)
+		SELECT db_id, $4, $5
+		FROM sqlite_databases
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
 			)
-			AND disc_id = $4`
-	commandTag, err = tx.Exec(dbQuery, owner, folder, fileName, discID)
+			AND folder = $2
+			AND db_name = $3
+			AND is_deleted = false`
+	commandTag, err := pdb.Exec(dbQuery, owner, folder, fileName, token, expiry)
 	if err != nil {
-		log.Printf("Updating last modified date for database '%s%s%s', discussion '%d' failed: %v\n", owner,
-			folder, fileName, discID, err)
+		Log.Errorf("Storing a guest upload link for '%s%s%s' failed: %v", owner, folder, fileName, err)
 		return err
 	}
 	if numRows := commandTag.RowsAffected(); numRows != 1 {
-		log.Printf(
-			"Wrong number of rows (%v) affected when updating last_modified date for database '%s%s%s', discussion '%d'\n",
-			numRows, owner, folder, fileName, discID)
+		errMsg := fmt.Sprintf("Wrong number of rows (%v) affected when storing a guest upload link for '%s%s%s'",
+			numRows, owner, folder, fileName)
+		Log.Error(errMsg)
+		return errors.New(errMsg)
 	}
+	return nil
+}
 
-	// Update the open discussion and MR counters for the database
-	dbQuery = `
-		WITH d AS (
-			SELECT db.db_id
-			FROM sqlite_databases AS db
-			WHERE db.user_id = (
-					SELECT user_id
-					FROM users
-					WHERE lower(user_name) = lower($1)
-				)
-				AND folder = $2
-				AND db_name = $3
+// Adds a background job to the queue, returning its ID.
+func StoreJob(jobType string, payload []byte, priority int, maxAttempts int) (id int64, err error) {
+	dbQuery := `
+		INSERT INTO background_jobs (job_type, payload, priority, max_attempts)
+		VALUES ($1, $2::jsonb, $3, $4)
+		RETURNING job_id`
+	err = pdb.QueryRow(dbQuery, jobType, string(payload), priority, maxAttempts).Scan(&id)
+	if err != nil {
+		Log.Errorf("Queuing a '%s' background job failed: %v", jobType, err)
+	}
+	return
+}
+
+// Store a licence.  The SPDX identifier is optional, as not every licence has one.
+func StoreLicence(userName string, licenceName string, txt []byte, url string, orderNum int, fullName string,
+	fileFormat string, spdxID string) error {
+	// Store the licence in PostgreSQL
+	sha := sha256.Sum256(txt)
+	dbQuery := `
+		WITH u AS (
+			SELECT user_id
+			FROM users
+			WHERE lower(user_name) = lower($1)
 		)
-		UPDATE sqlite_databases
-		SET discussions = (
-				SELECT count(disc.*)
-				FROM discussions AS disc, d
-				WHERE disc.db_id = d.db_id
-					AND open = true
-					AND discussion_type = 0
-			),
-			merge_requests = (
-				SELECT count(disc.*)
-				FROM discussions AS disc, d
-				WHERE disc.db_id = d.db_id
-					AND open = true
-					AND discussion_type = 1
-			)
-		WHERE db_id = (SELECT db_id FROM d)`
-	commandTag, err = tx.Exec(dbQuery, owner, folder, fileName)
+		INSERT INTO database_licences (user_id, friendly_name, lic_sha256, licence_text, licence_url, display_order,
+			full_name, file_format, spdx_id)
+		SELECT (SELECT user_id FROM u), $2, $3, $4, $5, $6, $7, $8, nullif($9, '')
+		ON CONFLICT (user_id, friendly_name)
+			DO UPDATE
+			SET friendly_name = $2,
+				lic_sha256 = $3,
+				licence_text = $4,
+				licence_url = $5,
+				user_id = (SELECT user_id FROM u),
+				display_order = $6,
+				full_name = $7,
+				file_format = $8,
+				spdx_id = nullif($9, '')`
+	commandTag, err := pdb.Exec(dbQuery, userName, licenceName, hex.EncodeToString(sha[:]), txt, url, orderNum,
+		fullName, fileFormat, spdxID)
 	if err != nil {
-		log.Printf("Updating discussion count for database '%s%s%s' failed: %v\n", owner, folder, fileName,
-			err)
+		Log.Errorf("Inserting licence '%v' in database failed: %v", licenceName, err)
 		return err
 	}
 	if numRows := commandTag.RowsAffected(); numRows != 1 {
-		log.Printf(
-			"Wrong number of rows (%v) affected when updating discussion count for database '%s%s%s'\n",
-			numRows, owner, folder, fileName)
+		Log.Warnf("Wrong number of rows (%v) affected when storing licence '%v'", numRows, licenceName)
 	}
+	return nil
+}
 
-	// If comment text was provided, generate an event about the new comment
-	if comText != "" {
-		var commentURL string
-		if discType == MERGE_REQUEST {
-			commentURL = fmt.Sprintf("/merge/%s%s%s?id=%d#c%d", url.PathEscape(owner), folder,
-				url.PathEscape(fileName), discID, comID)
-		} else {
-			commentURL = fmt.Sprintf("/discuss/%s%s%s?id=%d#c%d", url.PathEscape(owner), folder,
-				url.PathEscape(fileName), discID, comID)
-		}
-		details := EventDetails{
-			DBName:   fileName,
-			DiscID:   discID,
-			Folder:   folder,
-			Owner:    owner,
-			Type:     EVENT_NEW_COMMENT,
-			Title:    discTitle,
-			URL:      commentURL,
-			UserName: commenter,
-		}
-		err = NewEvent(details)
+// Adds a batch of requests to the project access logs.  Requests for projects which don't exist (or have since been
+// deleted) are skipped.
+func storeProjectAccesses(list []projectAccess) error {
+	tx, err := pdb.Begin()
+	if err != nil {
+		return err
+	}
+	// Set up an automatic transaction roll back if the function exits without committing
+	defer tx.Rollback()
+
+	dbQuery := `
+		INSERT INTO project_access_log (db_id, access_time, kind, method, path, status, client, user_agent)
+		SELECT db_id, $4, $5, $6, $7, $8, $9, nullif($10, '')
+		FROM sqlite_databases
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND folder = $2
+			AND db_name = $3
+			AND is_deleted = false`
+	for _, a := range list {
+		_, err = tx.Exec(dbQuery, a.owner, a.folder, a.fileName, a.Time, a.Kind, a.Method, a.Path, a.Status,
+			a.Client, a.UserAgent)
 		if err != nil {
-			log.Printf("Error when creating a new event: %s\n", err.Error())
 			return err
 		}
 	}
+	return tx.Commit()
+}
 
-	// Commit the transaction
-	err = tx.Commit()
+// Links an OctoPrint instance to a user's account, replacing any they'd linked before.  The API key needs to be
+// encrypted already.
+func StoreOctoPrintLink(userName string, octoURL string, apiKey []byte) error {
+	dbQuery := `
+		INSERT INTO octoprint_links (user_id, url, api_key)
+		SELECT user_id, $2, $3
+		FROM users
+		WHERE lower(user_name) = lower($1)
+		ON CONFLICT (user_id)
+			DO UPDATE
+			SET url = excluded.url, api_key = excluded.api_key, date_linked = now(), last_used = NULL`
+	commandTag, err := pdb.Exec(dbQuery, userName, octoURL, apiKey)
 	if err != nil {
+		Log.Errorf("Storing the OctoPrint link of '%s' failed: %v", userName, err)
 		return err
 	}
-
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		errMsg := fmt.Sprintf("Wrong number of rows (%v) affected when storing the OctoPrint link of '%s'",
+			numRows, userName)
+		Log.Error(errMsg)
+		return errors.New(errMsg)
+	}
 	return nil
 }
 
-// Updates the commit list for a database.
-func StoreCommits(owner string, folder string, fileName string, commitList map[string]CommitEntry) error {
+// Sets the category for a database.  A category ID of 0 removes the database from its category.
+func StoreProjectCategory(owner string, folder string, fileName string, catID int64) error {
+	var cat pgx.NullInt64
+	if catID != 0 {
+		cat.Int64 = catID
+		cat.Valid = true
+	}
 	dbQuery := `
 		UPDATE sqlite_databases
-		SET commit_list = $4, last_modified = now()
+		SET category_id = $4
 		WHERE user_id = (
 				SELECT user_id
 				FROM users
 				WHERE lower(user_name) = lower($1)
 				)
 			AND folder = $2
-			AND db_name = $3`
-	commandTag, err := pdb.Exec(dbQuery, owner, folder, fileName, commitList)
+			AND db_name = $3
+			AND is_deleted = false`
+	commandTag, err := pdb.Exec(dbQuery, owner, folder, fileName, cat)
 	if err != nil {
-		log.Printf("Updating commit list for database '%s%s%s' failed: %v\n", owner, folder, fileName, err)
+		Log.Errorf("Updating category for database '%s%s%s' to '%d' failed: %v", owner, folder, fileName,
+			catID, err)
 		return err
 	}
 	if numRows := commandTag.RowsAffected(); numRows != 1 {
-		log.Printf(
-			"Wrong number of rows (%v) affected when updating commit list for database '%s%s%s'\n", numRows,
-			owner, folder, fileName)
+		errMsg := fmt.Sprintf("Wrong number of rows (%v) affected when updating category for database "+
+			"'%s%s%s'", numRows, owner, folder, fileName)
+		Log.Error(errMsg)
+		return errors.New(errMsg)
 	}
 	return nil
 }
 
-// Stores database details in PostgreSQL, and the database data itself in Minio.
-func StoreFile(owner string, folder string, fileName string, branches map[string]BranchEntry, c CommitEntry,
-	pub bool, buf *os.File, sha string, dbSize int64, oneLineDesc string, fullDesc string, createDefBranch bool,
-	branchName string, sourceURL string) error {
-	// Store the database file
-	err := StoreDatabaseFile(buf, sha, dbSize)
+// Stores the one line and full descriptions for a project.  Empty strings remove them.
+func StoreProjectDescriptions(owner string, folder string, fileName string, oneLineDesc string, fullDesc string) error {
+	dbQuery := `
+		UPDATE sqlite_databases
+		SET one_line_description = nullif($4, ''), full_description = nullif($5, '')
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+				)
+			AND folder = $2
+			AND db_name = $3
+			AND is_deleted = false`
+	commandTag, err := pdb.Exec(dbQuery, owner, folder, fileName, oneLineDesc, fullDesc)
 	if err != nil {
+		Log.Errorf("Updating descriptions for database '%s%s%s' failed: %v", owner, folder, fileName, err)
 		return err
 	}
-
-	// Check for values which should be NULL
-	var nullable1LineDesc, nullableFullDesc pgx.NullString
-	if oneLineDesc == "" {
-		nullable1LineDesc.Valid = false
-	} else {
-		nullable1LineDesc.String = oneLineDesc
-		nullable1LineDesc.Valid = true
-	}
-	if fullDesc == "" {
-		nullableFullDesc.Valid = false
-	} else {
-		nullableFullDesc.String = fullDesc
-		nullableFullDesc.Valid = true
-	}
-
-	// Store the database metadata
-	cMap := map[string]CommitEntry{c.ID: c}
-	var commandTag pgx.CommandTag
-	dbQuery := `
-		WITH root AS (
-			SELECT nextval('sqlite_databases_db_id_seq') AS val
-		)
-		INSERT INTO sqlite_databases (user_id, db_id, folder, db_name, public, one_line_description, full_description,
-			branch_heads, root_database, commit_list`
-	if sourceURL != "" {
-		dbQuery += `, source_url`
-	}
-	dbQuery +=
-		`)
-		SELECT (
-			SELECT user_id
-			FROM users
-			WHERE lower(user_name) = lower($1)), (SELECT val FROM root), $2, $3, $4, $5, $6, $8, (SELECT val FROM root), $7`
-	if sourceURL != "" {
-		dbQuery += `, $9`
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		errMsg := fmt.Sprintf("Wrong number of rows (%v) affected when updating descriptions for database '%s%s%s'",
+			numRows, owner, folder, fileName)
+		Log.Errorf(errMsg)
+		return errors.New(errMsg)
 	}
-	dbQuery += `
-		ON CONFLICT (user_id, folder, db_name)
-			DO UPDATE
-			SET commit_list = sqlite_databases.commit_list || $7,
-				branch_heads = sqlite_databases.branch_heads || $8,
-				last_modified = now()`
-	if sourceURL != "" {
-		dbQuery += `,
-			source_url = $9`
-		commandTag, err = pdb.Exec(dbQuery, owner, folder, fileName, pub, nullable1LineDesc, nullableFullDesc,
-			cMap, branches, sourceURL)
-	} else {
-		commandTag, err = pdb.Exec(dbQuery, owner, folder, fileName, pub, nullable1LineDesc, nullableFullDesc,
-			cMap, branches)
+
+	// Invalidate the old memcached entries for the database
+	err = InvalidateCacheEntry(owner, owner, folder, fileName, "") // Empty string indicates "for all versions"
+	if err != nil {
+		Log.Errorf("Error when invalidating memcache entries: %s", err.Error())
+		return err
 	}
+	return nil
+}
+
+// Adds downloads to the daily download statistics for each version of a project.  The counts are keyed by the SHA256
+// of the file downloaded.
+func storeProjectDownloads(owner string, folder string, fileName string, date string, counts map[string]int64) error {
+	tx, err := pdb.Begin()
 	if err != nil {
-		log.Printf("Storing database '%s%s%s' failed: %v\n", owner, folder, fileName, err)
 		return err
 	}
-	if numRows := commandTag.RowsAffected(); numRows != 1 {
-		log.Printf("Wrong number of rows (%v) affected while storing database '%s%s%s'\n", numRows, owner,
-			folder, fileName)
+	// Set up an automatic transaction roll back if the function exits without committing
+	defer tx.Rollback()
+
+	// Look up the project
+	dbQuery := `
+		SELECT db_id
+		FROM sqlite_databases
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND folder = $2
+			AND db_name = $3
+			AND is_deleted = false`
+	var dbID int64
+	err = tx.QueryRow(dbQuery, owner, folder, fileName).Scan(&dbID)
+	if err == pgx.ErrNoRows {
+		// The project has been deleted since it was downloaded
+		return nil
+	}
+	if err != nil {
+		return err
 	}
 
-	if createDefBranch {
-		err = StoreDefaultBranchName(owner, folder, fileName, branchName)
+	// Add to the download counts for the day
+	for sha, downloads := range counts {
+		dbQuery = `
+			INSERT INTO project_daily_downloads (db_id, stat_date, db_sha256, downloads)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (db_id, stat_date, db_sha256)
+				DO UPDATE SET downloads = project_daily_downloads.downloads + excluded.downloads`
+		_, err = tx.Exec(dbQuery, dbID, date, sha, downloads)
 		if err != nil {
-			log.Printf("Storing default branch '%s' name for '%s%s%s' failed: %v\n", branchName, owner,
-				folder, fileName, err)
 			return err
 		}
 	}
-	return nil
+	return tx.Commit()
 }
 
-// Stores the default branch name for a database.
-func StoreDefaultBranchName(owner string, folder string, fileName string, branchName string) error {
+// Stores the README for a project.  An empty string removes it.
+func StoreProjectReadme(owner string, folder string, fileName string, readme string) error {
+	var r pgx.NullString
+	if readme != "" {
+		r.String = readme
+		r.Valid = true
+	}
 	dbQuery := `
 		UPDATE sqlite_databases
-		SET default_branch = $4
+		SET readme = $4
 		WHERE user_id = (
 				SELECT user_id
 				FROM users
 				WHERE lower(user_name) = lower($1)
 				)
 			AND folder = $2
-			AND db_name = $3`
-	commandTag, err := pdb.Exec(dbQuery, owner, folder, fileName, branchName)
+			AND db_name = $3
+			AND is_deleted = false`
+	commandTag, err := pdb.Exec(dbQuery, owner, folder, fileName, r)
 	if err != nil {
-		log.Printf("Changing default branch for database '%v' to '%v' failed: %v\n", fileName, branchName, err)
+		Log.Errorf("Updating README for database '%s%s%s' failed: %v", owner, folder, fileName, err)
 		return err
 	}
 	if numRows := commandTag.RowsAffected(); numRows != 1 {
-		log.Printf("Wrong number of rows (%v) affected during update: database: %v, new branch name: '%v'\n",
-			numRows, fileName, branchName)
+		errMsg := fmt.Sprintf("Wrong number of rows (%v) affected when updating README for database '%s%s%s'",
+			numRows, owner, folder, fileName)
+		Log.Errorf(errMsg)
+		return errors.New(errMsg)
 	}
 	return nil
 }
 
-// Stores the default table name for a database.
-func StoreDefaultTableName(owner string, folder string, fileName string, tableName string) error {
-	var t pgx.NullString
-	if tableName != "" {
-		t.String = tableName
-		t.Valid = true
+// Updates the list of project tags for a database.
+func StoreProjectTags(owner string, folder string, fileName string, tags []string) error {
+	if tags == nil {
+		tags = []string{}
 	}
 	dbQuery := `
 		UPDATE sqlite_databases
-		SET default_table = $4
+		SET project_tags = $4
 		WHERE user_id = (
 				SELECT user_id
 				FROM users
 				WHERE lower(user_name) = lower($1)
 				)
 			AND folder = $2
-			AND db_name = $3`
-	commandTag, err := pdb.Exec(dbQuery, owner, folder, fileName, t)
+			AND db_name = $3
+			AND is_deleted = false`
+	commandTag, err := pdb.Exec(dbQuery, owner, folder, fileName, tags)
 	if err != nil {
-		log.Printf("Changing default table for database '%v' to '%v' failed: %v\n", fileName, tableName, err)
+		Log.Errorf("Updating project tags for database '%s%s%s' to '%v' failed: %v", owner, folder,
+			fileName, tags, err)
 		return err
 	}
 	if numRows := commandTag.RowsAffected(); numRows != 1 {
-		log.Printf("Wrong number of rows (%v) affected during update: database: %v, new table name: '%v'\n",
-			numRows, fileName, tableName)
+		errMsg := fmt.Sprintf("Wrong number of rows (%v) affected when updating project tags for database "+
+			"'%s%s%s'", numRows, owner, folder, fileName)
+		Log.Error(errMsg)
+		return errors.New(errMsg)
 	}
 	return nil
 }
 
-// Stores a new discussion for a database.
-func StoreDiscussion(owner string, folder string, fileName string, loggedInUser string, title string, text string,
-	discType DiscussionType, mr MergeRequestEntry) (newID int, err error) {
-
-	// Begin a transaction
-	tx, err := pdb.Begin()
+// Saves a project template, replacing any existing one of the same name.  Instance templates belong to the "default"
+// user.
+func StoreProjectTemplate(userName string, t ProjectTemplate) error {
+	if t.Tags == nil {
+		t.Tags = []string{}
+	}
+	dbQuery := `
+		INSERT INTO project_templates (user_id, template_name, one_line_description, readme, licence, category_id,
+			project_tags, print_settings)
+		SELECT user_id, $2, NULLIF($3, ''), NULLIF($4, ''), NULLIF($5, ''), NULLIF($6, 0), $7, NULLIF($8, '')
+		FROM users
+		WHERE lower(user_name) = lower($1)
+		ON CONFLICT (user_id, template_name)
+			DO UPDATE
+			SET one_line_description = excluded.one_line_description,
+				readme = excluded.readme,
+				licence = excluded.licence,
+				category_id = excluded.category_id,
+				project_tags = excluded.project_tags,
+				print_settings = excluded.print_settings`
+	commandTag, err := pdb.Exec(dbQuery, userName, t.Name, t.OneLineDesc, t.Readme, t.Licence, t.Category, t.Tags,
+		t.PrintSettings)
 	if err != nil {
-		return
+		Log.Errorf("Storing project template '%s' of user '%s' failed: %v", t.Name, userName, err)
+		return err
 	}
-	// Set up an automatic transaction roll back if the function exits without committing
-	defer tx.Rollback()
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		errMsg := fmt.Sprintf("Wrong number of rows (%v) affected when storing project template '%s' of user '%s'",
+			numRows, t.Name, userName)
+		Log.Error(errMsg)
+		return errors.New(errMsg)
+	}
+	return nil
+}
 
-	// Add the discussion details to PostgreSQL
-	dbQuery := `
-		WITH u AS (
-			SELECT user_id
-			FROM users
-			WHERE lower(user_name) = lower($1)
-		), d AS (
-			SELECT db.db_id
-			FROM sqlite_databases AS db, u
-			WHERE db.user_id = u.user_id
-				AND db.folder = $2
-				AND db.db_name = $3
-		), next_id AS (
-			SELECT coalesce(max(disc.disc_id), 0) + 1 AS id
-			FROM discussions AS disc, d
-			WHERE disc.db_id = d.db_id
-		)
-		INSERT INTO discussions (db_id, disc_id, creator, title, description, open, discussion_type`
-	if discType == MERGE_REQUEST {
-		dbQuery += `, mr_source_db_id, mr_source_db_branch, mr_destination_branch, mr_commits`
+// Saves the translation of a project's descriptions and README into a language, replacing any existing one.  Empty
+// fields are stored as NULL, so the project's own ones get used for them.
+func StoreProjectTranslation(owner string, folder string, fileName string, t ProjectTranslation) error {
+	var oneLineDesc, fullDesc, readme pgx.NullString
+	if t.OneLineDesc != "" {
+		oneLineDesc.String = t.OneLineDesc
+		oneLineDesc.Valid = true
 	}
-	dbQuery += `
-			)
-		SELECT (SELECT db_id FROM d),
-			(SELECT id FROM next_id),
-			(SELECT user_id FROM users WHERE lower(user_name) = lower($4)),
-			$5,
-			$6,
-			true,
-			$7`
-	if discType == MERGE_REQUEST {
-		dbQuery += `,(
+	if t.FullDesc != "" {
+		fullDesc.String = t.FullDesc
+		fullDesc.Valid = true
+	}
+	if t.Readme != "" {
+		readme.String = t.Readme
+		readme.Valid = true
+	}
+	dbQuery := `
+		WITH d AS (
 			SELECT db_id
 			FROM sqlite_databases
 			WHERE user_id = (
-				SELECT user_id
-				FROM users
-				WHERE lower(user_name) = lower($8))
-			AND folder = $9
-			AND db_name = $10
-			AND is_deleted = false
-		), $11, $12, $13`
+					SELECT user_id
+					FROM users
+					WHERE lower(user_name) = lower($1)
+				)
+				AND folder = $2
+				AND db_name = $3
+				AND is_deleted = false
+		)
+		INSERT INTO project_translations (db_id, language, one_line_description, full_description, readme)
+		SELECT d.db_id, $4, $5, $6, $7
+		FROM d
+		ON CONFLICT (db_id, language)
+			DO UPDATE SET one_line_description = excluded.one_line_description,
+				full_description = excluded.full_description, readme = excluded.readme, last_modified = now()`
+	commandTag, err := pdb.Exec(dbQuery, owner, folder, fileName, t.Language, oneLineDesc, fullDesc, readme)
+	if err != nil {
+		Log.Errorf("Saving the '%s' translation of '%s%s%s' failed: %v", t.Language, owner, folder, fileName, err)
+		return err
 	}
-	dbQuery += `
-		RETURNING (SELECT id FROM next_id)`
-	if discType == MERGE_REQUEST {
-		err = tx.QueryRow(dbQuery, owner, folder, fileName, loggedInUser, title, text, discType, mr.SourceOwner,
-			mr.SourceFolder, mr.SourceDBName, mr.SourceBranch, mr.DestBranch, mr.Commits).Scan(&newID)
-	} else {
-		err = tx.QueryRow(dbQuery, owner, folder, fileName, loggedInUser, title, text, discType).Scan(&newID)
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		return fmt.Errorf("Project '%s%s%s' doesn't exist", owner, folder, fileName)
 	}
+	return nil
+}
+
+// Adds page views to the daily usage statistics for a project, along with the sites they were referred from.
+func storeProjectViews(owner string, folder string, fileName string, date string, referrers map[string]int64) error {
+	tx, err := pdb.Begin()
 	if err != nil {
-		log.Printf("Adding new discussion or merge request '%s' for '%s%s%s' failed: %v\n", title, owner,
-			folder, fileName, err)
-		return
+		return err
 	}
+	// Set up an automatic transaction roll back if the function exits without committing
+	defer tx.Rollback()
 
-	// Increment the discussion or merge request counter for the database
-	dbQuery = `
-		UPDATE sqlite_databases`
-	if discType == DISCUSSION {
-		dbQuery += `
-			SET discussions = discussions + 1`
-	} else {
-		dbQuery += `
-			SET merge_requests = merge_requests + 1`
-	}
-	dbQuery += `
+	// Look up the project
+	dbQuery := `
+		SELECT db_id
+		FROM sqlite_databases
 		WHERE user_id = (
 				SELECT user_id
 				FROM users
 				WHERE lower(user_name) = lower($1)
 			)
 			AND folder = $2
-			AND db_name = $3`
-	commandTag, err := tx.Exec(dbQuery, owner, folder, fileName)
-	if err != nil {
-		log.Printf("Updating discussion counter for '%s%s%s' failed: %v\n", owner, folder, fileName, err)
-		return
+			AND db_name = $3
+			AND is_deleted = false`
+	var dbID int64
+	err = tx.QueryRow(dbQuery, owner, folder, fileName).Scan(&dbID)
+	if err == pgx.ErrNoRows {
+		// The project has been deleted since it was viewed
+		return nil
 	}
-	if numRows := commandTag.RowsAffected(); numRows != 1 {
-		log.Printf("Wrong number of rows (%v) affected when updating discussion counter for '%s%s%s'\n",
-			numRows, owner, folder, fileName)
+	if err != nil {
+		return err
 	}
 
-	// Commit the transaction
-	err = tx.Commit()
+	// Add to the view and referrer counts for the day
+	var views int64
+	for referrer, hits := range referrers {
+		views += hits
+		dbQuery = `
+			INSERT INTO project_referrers (db_id, stat_date, referrer, hits)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (db_id, stat_date, referrer)
+				DO UPDATE SET hits = project_referrers.hits + excluded.hits`
+		_, err = tx.Exec(dbQuery, dbID, date, referrer, hits)
+		if err != nil {
+			return err
+		}
+	}
+	dbQuery = `
+		INSERT INTO project_daily_views (db_id, stat_date, views)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (db_id, stat_date)
+			DO UPDATE SET views = project_daily_views.views + excluded.views`
+	_, err = tx.Exec(dbQuery, dbID, date, views)
 	if err != nil {
-		return
+		return err
 	}
-	return
+	return tx.Commit()
 }
 
-// Store a licence.
-func StoreLicence(userName string, licenceName string, txt []byte, url string, orderNum int, fullName string,
-	fileFormat string) error {
-	// Store the licence in PostgreSQL
-	sha := sha256.Sum256(txt)
+// Adds or updates the regeneration webhook for a project.  An empty token keeps the existing one.
+func StoreRegenerationHook(owner string, folder string, fileName string, sourceURL string, token string) error {
 	dbQuery := `
-		WITH u AS (
-			SELECT user_id
-			FROM users
-			WHERE lower(user_name) = lower($1)
-		)
-		INSERT INTO database_licences (user_id, friendly_name, lic_sha256, licence_text, licence_url, display_order,
-			full_name, file_format)
-		SELECT (SELECT user_id FROM u), $2, $3, $4, $5, $6, $7, $8
-		ON CONFLICT (user_id, friendly_name)
+		INSERT INTO regeneration_hooks (db_id, source_url, token)
+		SELECT db_id, $4, $5
+		FROM sqlite_databases
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND folder = $2
+			AND db_name = $3
+			AND is_deleted = false
+		ON CONFLICT (db_id)
 			DO UPDATE
-			SET friendly_name = $2,
-				lic_sha256 = $3,
-				licence_text = $4,
-				licence_url = $5,
-				user_id = (SELECT user_id FROM u),
-				display_order = $6,
-				full_name = $7,
-				file_format = $8`
-	commandTag, err := pdb.Exec(dbQuery, userName, licenceName, hex.EncodeToString(sha[:]), txt, url, orderNum,
-		fullName, fileFormat)
+			SET source_url = excluded.source_url,
+				token = coalesce(nullif(excluded.token, ''), regeneration_hooks.token)`
+	commandTag, err := pdb.Exec(dbQuery, owner, folder, fileName, sourceURL, token)
 	if err != nil {
-		log.Printf("Inserting licence '%v' in database failed: %v\n", licenceName, err)
+		Log.Errorf("Storing the regeneration webhook for '%s%s%s' failed: %v", owner, folder, fileName, err)
 		return err
 	}
 	if numRows := commandTag.RowsAffected(); numRows != 1 {
-		log.Printf("Wrong number of rows (%v) affected when storing licence '%v'\n", numRows, licenceName)
+		errMsg := fmt.Sprintf("Wrong number of rows (%v) affected when storing the regeneration webhook for "+
+			"'%s%s%s'", numRows, owner, folder, fileName)
+		Log.Error(errMsg)
+		return errors.New(errMsg)
 	}
 	return nil
 }
 
+// Records the outcome of regenerating a project.  The commit ID is empty when no new version was needed.
+func StoreRegenerationResult(id int64, commitID string, regenErr error) error {
+	var errMsg pgx.NullString
+	if regenErr != nil {
+		errMsg.String = regenErr.Error()
+		errMsg.Valid = true
+	}
+	dbQuery := `
+		UPDATE regeneration_hooks
+		SET last_commit = coalesce(nullif($2, ''), last_commit), last_error = $3, last_run = now()
+		WHERE db_id = $1`
+	_, err := pdb.Exec(dbQuery, id, commitID, errMsg)
+	if err != nil {
+		Log.Errorf("Storing the result of regenerating project %d failed: %v", id, err)
+	}
+	return err
+}
+
 // Store the releases for a database.
 func StoreReleases(owner string, folder string, fileName string, releases map[string]ReleaseEntry) error {
 	dbQuery := `
@@ -3488,11 +8726,11 @@ func StoreReleases(owner string, folder string, fileName string, releases map[st
 			AND db_name = $3`
 	commandTag, err := pdb.Exec(dbQuery, owner, folder, fileName, releases, len(releases))
 	if err != nil {
-		log.Printf("Storing releases for database '%s%s%s' failed: %v\n", owner, folder, fileName, err)
+		Log.Errorf("Storing releases for database '%s%s%s' failed: %v", owner, folder, fileName, err)
 		return err
 	}
 	if numRows := commandTag.RowsAffected(); numRows != 1 {
-		log.Printf("Wrong number of rows (%v) affected when storing releases for database: '%s%s%s'\n", numRows,
+		Log.Warnf("Wrong number of rows (%v) affected when storing releases for database: '%s%s%s'", numRows,
 			owner, folder, fileName)
 	}
 	return nil
@@ -3506,22 +8744,46 @@ func StoreStatusUpdates(userName string, statusUpdates map[string][]StatusUpdate
 		WHERE user_name = $1`
 	commandTag, err := pdb.Exec(dbQuery, userName, statusUpdates)
 	if err != nil {
-		log.Printf("Adding status update for user '%s' failed: %v", userName, err)
+		Log.Errorf("Adding status update for user '%s' failed: %v", userName, err)
 		return err
 	}
 	if numRows := commandTag.RowsAffected(); numRows != 1 {
-		log.Printf("Wrong number of rows affected (%v) when storing status update for user '%s'", numRows,
+		Log.Warnf("Wrong number of rows affected (%v) when storing status update for user '%s'", numRows,
 			userName)
 		return err
 	}
 	return nil
 }
 
-// Store the tags for a database.
-func StoreTags(owner string, folder string, fileName string, tags map[string]TagEntry) error {
+// Store the tags for a database.
+func StoreTags(owner string, folder string, fileName string, tags map[string]TagEntry) error {
+	dbQuery := `
+		UPDATE sqlite_databases
+		SET tag_list = $4, tags = $5
+		WHERE user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND folder = $2
+			AND db_name = $3`
+	commandTag, err := pdb.Exec(dbQuery, owner, folder, fileName, tags, len(tags))
+	if err != nil {
+		Log.Errorf("Storing tags for database '%s%s%s' failed: %v", owner, folder, fileName, err)
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		Log.Warnf("Wrong number of rows (%v) affected when storing tags for database: '%s%s%s'", numRows,
+			owner, folder, fileName)
+	}
+	return nil
+}
+
+// Stores the number of triangles (faces) in the model on the default branch of a project, for use in searches.
+func StoreTriangleCount(owner string, folder string, fileName string, numTris int64) error {
 	dbQuery := `
 		UPDATE sqlite_databases
-		SET tag_list = $4, tags = $5
+		SET triangle_count = $4
 		WHERE user_id = (
 				SELECT user_id
 				FROM users
@@ -3529,18 +8791,93 @@ func StoreTags(owner string, folder string, fileName string, tags map[string]Tag
 			)
 			AND folder = $2
 			AND db_name = $3`
-	commandTag, err := pdb.Exec(dbQuery, owner, folder, fileName, tags, len(tags))
+	commandTag, err := pdb.Exec(dbQuery, owner, folder, fileName, numTris)
 	if err != nil {
-		log.Printf("Storing tags for database '%s%s%s' failed: %v\n", owner, folder, fileName, err)
+		Log.Errorf("Storing triangle count for database '%s%s%s' failed: %v", owner, folder, fileName, err)
 		return err
 	}
 	if numRows := commandTag.RowsAffected(); numRows != 1 {
-		log.Printf("Wrong number of rows (%v) affected when storing tags for database: '%s%s%s'\n", numRows,
-			owner, folder, fileName)
+		Log.Warnf("Wrong number of rows (%v) affected when storing triangle count for database: '%s%s%s'",
+			numRows, owner, folder, fileName)
 	}
 	return nil
 }
 
+// Saves a new revision of a page in a project's wiki, creating the page if it doesn't exist yet.  Returns the number
+// of the new revision.
+func StoreWikiPage(owner string, folder string, fileName string, pageName string, userName string, body string,
+	summary string) (revision int, err error) {
+	// If no edit summary was given, use a NULL value for that column
+	var editSummary pgx.NullString
+	if summary != "" {
+		editSummary.String = summary
+		editSummary.Valid = true
+	}
+	dbQuery := `
+		WITH d AS (
+			SELECT db_id
+			FROM sqlite_databases
+			WHERE user_id = (
+					SELECT user_id
+					FROM users
+					WHERE lower(user_name) = lower($1)
+				)
+				AND folder = $2
+				AND db_name = $3
+				AND is_deleted = false
+		)
+		INSERT INTO project_wiki_revisions (db_id, page_name, revision, body, edit_summary, user_id)
+		SELECT d.db_id, $4, coalesce((
+				SELECT max(w.revision)
+				FROM project_wiki_revisions AS w
+				WHERE w.db_id = d.db_id
+					AND w.page_name = $4
+			), 0) + 1, $5, $6, (SELECT user_id FROM users WHERE lower(user_name) = lower($7))
+		FROM d
+		RETURNING revision`
+	err = pdb.QueryRow(dbQuery, owner, folder, fileName, pageName, body, editSummary, userName).Scan(&revision)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			err = fmt.Errorf("Project '%s%s%s' doesn't exist", owner, folder, fileName)
+		}
+		Log.Errorf("Saving wiki page '%s' for '%s%s%s' failed: %v", pageName, owner, folder, fileName, err)
+		return 0, err
+	}
+	return
+}
+
+// Keeps a user's email address in sync with the one Auth0 has for them.  When the address at Auth0 changes (Auth0 has
+// already verified it), ours is changed to match, unless another account uses it.  Addresses users have changed ours
+// to aren't touched while the Auth0 one stays the same.  The returned value is true when our address was changed.
+func SyncAuth0Email(userName string, email string) (changed bool, err error) {
+	dbQuery := `
+		WITH synced AS (
+			UPDATE users AS u
+			SET email = CASE WHEN prev.auth0_email IS NULL THEN u.email ELSE $2 END, auth0_email = $2
+			FROM users AS prev
+			WHERE prev.user_id = u.user_id
+				AND lower(u.user_name) = lower($1)
+				AND u.auth0_email IS DISTINCT FROM $2
+				AND NOT EXISTS (
+					SELECT 1
+					FROM users AS other
+					WHERE lower(other.email) = lower($2)
+						AND other.user_id != u.user_id
+				)
+			RETURNING u.user_id, prev.auth0_email IS NOT NULL AND prev.email IS DISTINCT FROM $2 AS changed
+		), cancelled AS (
+			DELETE FROM email_changes
+			WHERE user_id IN (SELECT user_id FROM synced WHERE changed)
+		)
+		SELECT coalesce(bool_or(changed), false)
+		FROM synced`
+	err = pdb.QueryRow(dbQuery, userName, email).Scan(&changed)
+	if err != nil {
+		Log.Errorf("Syncing the Auth0 email address for user '%s' failed: %v", userName, err)
+	}
+	return
+}
+
 // Toggle on or off the starring of a database by a user.
 func ToggleDBStar(loggedInUser string, owner string, folder string, fileName string) error {
 	// Check if the database is already starred
@@ -3569,12 +8906,12 @@ func ToggleDBStar(loggedInUser string, owner string, folder string, fileName str
 			FROM u`
 		commandTag, err := pdb.Exec(insertQuery, dbID, loggedInUser)
 		if err != nil {
-			log.Printf("Adding star to database failed. Database ID: '%v' Username: '%s' Error '%v'\n",
+			Log.Errorf("Adding star to database failed. Database ID: '%v' Username: '%s' Error '%v'",
 				dbID, loggedInUser, err)
 			return err
 		}
 		if numRows := commandTag.RowsAffected(); numRows != 1 {
-			log.Printf("Wrong # of rows affected (%v) when starring database ID: '%v' Username: '%s'\n",
+			Log.Warnf("Wrong # of rows affected (%v) when starring database ID: '%v' Username: '%s'",
 				numRows, dbID, loggedInUser)
 		}
 	} else {
@@ -3589,12 +8926,12 @@ func ToggleDBStar(loggedInUser string, owner string, folder string, fileName str
 			)`
 		commandTag, err := pdb.Exec(deleteQuery, dbID, loggedInUser)
 		if err != nil {
-			log.Printf("Removing star from database failed. Database ID: '%v' Username: '%s' Error: '%v'\n",
+			Log.Errorf("Removing star from database failed. Database ID: '%v' Username: '%s' Error: '%v'",
 				dbID, loggedInUser, err)
 			return err
 		}
 		if numRows := commandTag.RowsAffected(); numRows != 1 {
-			log.Printf("Wrong # of rows (%v) affected when unstarring database ID: '%v' Username: '%s'\n",
+			Log.Warnf("Wrong # of rows (%v) affected when unstarring database ID: '%v' Username: '%s'",
 				numRows, dbID, loggedInUser)
 		}
 	}
@@ -3609,11 +8946,11 @@ func ToggleDBStar(loggedInUser string, owner string, folder string, fileName str
 		) WHERE db_id = $1`
 	commandTag, err := pdb.Exec(updateQuery, dbID)
 	if err != nil {
-		log.Printf("Updating star count in database failed: %v\n", err)
+		Log.Errorf("Updating star count in database failed: %v", err)
 		return err
 	}
 	if numRows := commandTag.RowsAffected(); numRows != 1 {
-		log.Printf("Wrong # of rows affected (%v) when updating star count. Database ID: '%v'\n", numRows, dbID)
+		Log.Warnf("Wrong # of rows affected (%v) when updating star count. Database ID: '%v'", numRows, dbID)
 	}
 	return nil
 }
@@ -3651,12 +8988,12 @@ func ToggleProjectWatch(loggedInUser string, owner string, folder string, fileNa
 			FROM d, u`
 		commandTag, err := pdb.Exec(insertQuery, owner, folder, fileName, loggedInUser)
 		if err != nil {
-			log.Printf("Adding '%s' to watchers list for database '%s%s%s' failed: Error '%v'\n", loggedInUser,
+			Log.Errorf("Adding '%s' to watchers list for database '%s%s%s' failed: Error '%v'", loggedInUser,
 				owner, folder, fileName, err)
 			return err
 		}
 		if numRows := commandTag.RowsAffected(); numRows != 1 {
-			log.Printf("Wrong # of rows affected (%v) when adding '%s' to watchers list for database '%s%s%s'",
+			Log.Warnf("Wrong # of rows affected (%v) when adding '%s' to watchers list for database '%s%s%s'",
 				numRows, loggedInUser, owner, folder, fileName)
 		}
 	} else {
@@ -3682,12 +9019,12 @@ func ToggleProjectWatch(loggedInUser string, owner string, folder string, fileNa
 			)`
 		commandTag, err := pdb.Exec(deleteQuery, owner, folder, fileName, loggedInUser)
 		if err != nil {
-			log.Printf("Removing '%s' from watchers list for database '%s%s%s' failed: Error '%v'\n",
+			Log.Errorf("Removing '%s' from watchers list for database '%s%s%s' failed: Error '%v'",
 				loggedInUser, owner, folder, fileName, err)
 			return err
 		}
 		if numRows := commandTag.RowsAffected(); numRows != 1 {
-			log.Printf("Wrong # of rows affected (%v) when removing '%s' from watchers list for database '%s%s%s'",
+			Log.Warnf("Wrong # of rows affected (%v) when removing '%s' from watchers list for database '%s%s%s'",
 				numRows, loggedInUser, owner, folder, fileName)
 		}
 	}
@@ -3714,16 +9051,63 @@ func ToggleProjectWatch(loggedInUser string, owner string, folder string, fileNa
 		) WHERE db_id = (SELECT db_id FROM d)`
 	commandTag, err := pdb.Exec(updateQuery, owner, folder, fileName)
 	if err != nil {
-		log.Printf("Updating watchers count for '%s%s%s' failed: %v", owner, folder, fileName, err)
+		Log.Errorf("Updating watchers count for '%s%s%s' failed: %v", owner, folder, fileName, err)
 		return err
 	}
 	if numRows := commandTag.RowsAffected(); numRows != 1 {
-		log.Printf("Wrong # of rows affected (%v) when updating watchers count for '%s%s%s'", numRows, owner,
+		Log.Warnf("Wrong # of rows affected (%v) when updating watchers count for '%s%s%s'", numRows, owner,
 			folder, fileName)
 	}
 	return nil
 }
 
+// Records that a background job is still being worked on, so it isn't mistaken for one whose worker has gone away.
+func TouchJob(id int64) error {
+	dbQuery := `
+		UPDATE background_jobs
+		SET date_updated = now()
+		WHERE job_id = $1`
+	_, err := pdb.Exec(dbQuery, id)
+	if err != nil {
+		Log.Errorf("Updating background job '%d' failed: %v", id, err)
+		return err
+	}
+	return nil
+}
+
+// Returns the token for a user's unsubscribe links.  The first time, newToken is stored as their token.
+func UnsubscribeToken(userID int64, newToken string) (token string, err error) {
+	dbQuery := `
+		UPDATE users
+		SET unsubscribe_token = coalesce(unsubscribe_token, $2)
+		WHERE user_id = $1
+		RETURNING unsubscribe_token`
+	err = pdb.QueryRow(dbQuery, userID, newToken).Scan(&token)
+	if err != nil {
+		Log.Errorf("Retrieving the unsubscribe token for user ID %d failed: %v", userID, err)
+	}
+	return
+}
+
+// Turns off the activity emails for a user, from the unsubscribe link in one of them.  Returns false if the token
+// doesn't match the user's.
+func UnsubscribeUser(userName string, token string) (bool, error) {
+	dbQuery := `
+		UPDATE users
+		SET pref_email_frequency = 'never'
+		WHERE lower(user_name) = lower($1)
+			AND unsubscribe_token = $2`
+	commandTag, err := pdb.Exec(dbQuery, userName, token)
+	if err != nil {
+		Log.Errorf("Unsubscribing user '%s' from emails failed: %v", userName, err)
+		return false, err
+	}
+	if commandTag.RowsAffected() != 1 {
+		return false, nil
+	}
+	return true, SetUserEmailFrequency(userName, EMAIL_NEVER)
+}
+
 // Updates the Avatar URL for a user.
 func UpdateAvatarURL(userName string, avatarURL string) error {
 	dbQuery := `
@@ -3732,11 +9116,11 @@ func UpdateAvatarURL(userName string, avatarURL string) error {
 		WHERE lower(user_name) = lower($1)`
 	commandTag, err := pdb.Exec(dbQuery, userName, avatarURL)
 	if err != nil {
-		log.Printf("Updating avatar URL failed for user '%s'. Error: '%v'\n", userName, err)
+		Log.Errorf("Updating avatar URL failed for user '%s'. Error: '%v'", userName, err)
 		return err
 	}
 	if numRows := commandTag.RowsAffected(); numRows != 1 {
-		log.Printf("Wrong # of rows (%v) affected when updating avatar URL. User: '%s'\n", numRows,
+		Log.Warnf("Wrong # of rows (%v) affected when updating avatar URL. User: '%s'", numRows,
 			userName)
 	}
 	return nil
@@ -3770,12 +9154,12 @@ func UpdateContributorsCount(owner string, folder, fileName string) error {
 				AND db_name = $3`
 	commandTag, err := pdb.Exec(dbQuery, owner, folder, fileName, n)
 	if err != nil {
-		log.Printf("Updating contributor count in database '%s%s%s' failed: %v\n", owner, folder, fileName,
+		Log.Errorf("Updating contributor count in database '%s%s%s' failed: %v", owner, folder, fileName,
 			err)
 		return err
 	}
 	if numRows := commandTag.RowsAffected(); numRows != 1 {
-		log.Printf("Wrong # of rows affected (%v) when updating contributor count for database '%s%s%s'\n",
+		Log.Warnf("Wrong # of rows affected (%v) when updating contributor count for database '%s%s%s'",
 			numRows, owner, folder, fileName)
 	}
 	return nil
@@ -3818,7 +9202,7 @@ func UpdateComment(owner string, folder string, fileName string, loggedInUser st
 			AND com.commenter = u.user_id`
 	err = tx.QueryRow(dbQuery, owner, folder, fileName, discID, comID).Scan(&comCreator)
 	if err != nil {
-		log.Printf("Error retrieving name of comment creator for '%s%s%s', discussion '%d', comment '%d': %v\n",
+		Log.Errorf("Error retrieving name of comment creator for '%s%s%s', discussion '%d', comment '%d': %v",
 			owner, folder, fileName, discID, comID, err)
 		return err
 	}
@@ -3853,13 +9237,13 @@ func UpdateComment(owner string, folder string, fileName string, loggedInUser st
 			AND com.com_id = $5`
 	commandTag, err := tx.Exec(dbQuery, owner, folder, fileName, discID, comID, newText)
 	if err != nil {
-		log.Printf("Updating comment for database '%s%s%s', discussion '%d', comment '%d' failed: %v\n",
+		Log.Errorf("Updating comment for database '%s%s%s', discussion '%d', comment '%d' failed: %v",
 			owner, folder, fileName, discID, comID, err)
 		return err
 	}
 	if numRows := commandTag.RowsAffected(); numRows != 1 {
-		log.Printf(
-			"Wrong number of rows (%v) affected when updating comment for database '%s%s%s', discussion '%d', comment '%d'\n",
+		Log.Warnf(
+			"Wrong number of rows (%v) affected when updating comment for database '%s%s%s', discussion '%d', comment '%d'",
 			numRows, owner, folder, fileName, discID, comID)
 	}
 
@@ -3901,7 +9285,7 @@ func UpdateDiscussion(owner string, folder string, fileName string, loggedInUser
 			AND disc.creator = u.user_id`
 	err = tx.QueryRow(dbQuery, owner, folder, fileName, discID).Scan(&discCreator)
 	if err != nil {
-		log.Printf("Error retrieving name of discussion creator for '%s%s%s', discussion '%d': %v\n",
+		Log.Errorf("Error retrieving name of discussion creator for '%s%s%s', discussion '%d': %v",
 			owner, folder, fileName, discID, err)
 		return err
 	}
@@ -3930,13 +9314,13 @@ func UpdateDiscussion(owner string, folder string, fileName string, loggedInUser
 			AND disc.disc_id = $4`
 	commandTag, err := tx.Exec(dbQuery, owner, folder, fileName, discID, newTitle, newText)
 	if err != nil {
-		log.Printf("Updating discussion for database '%s%s%s', discussion '%d' failed: %v\n", owner,
+		Log.Errorf("Updating discussion for database '%s%s%s', discussion '%d' failed: %v", owner,
 			folder, fileName, discID, err)
 		return err
 	}
 	if numRows := commandTag.RowsAffected(); numRows != 1 {
-		log.Printf(
-			"Wrong number of rows (%v) affected when updating discussion for database '%s%s%s', discussion '%d'\n",
+		Log.Warnf(
+			"Wrong number of rows (%v) affected when updating discussion for database '%s%s%s', discussion '%d'",
 			numRows, owner, folder, fileName, discID)
 	}
 
@@ -3968,27 +9352,83 @@ func UpdateMergeRequestCommits(owner string, folder string, fileName string, dis
 			AND disc.disc_id = $4`
 	commandTag, err := pdb.Exec(dbQuery, owner, folder, fileName, discID, mrCommits)
 	if err != nil {
-		log.Printf("Updating commit list for database '%s%s%s', MR '%d' failed: %v\n", owner,
+		Log.Errorf("Updating commit list for database '%s%s%s', MR '%d' failed: %v", owner,
 			folder, fileName, discID, err)
 		return err
 	}
 	if numRows := commandTag.RowsAffected(); numRows != 1 {
-		log.Printf(
-			"Wrong number of rows (%v) affected when updating commit list for database '%s%s%s', MR '%d'\n",
+		Log.Warnf(
+			"Wrong number of rows (%v) affected when updating commit list for database '%s%s%s', MR '%d'",
 			numRows, owner, folder, fileName, discID)
 	}
 	return nil
 }
 
+// Records the time step of an authenticator app code a user has just used, so it can't be used again, and clears their
+// count of wrong codes.  The ok value is false if that code (or a later one) has already been used.
+func UseTOTPStep(userName string, step int64) (ok bool, err error) {
+	dbQuery := `
+		UPDATE users
+		SET totp_last_step = $2, totp_failures = 0
+		WHERE lower(user_name) = lower($1)
+			AND totp_last_step < $2`
+	commandTag, err := pdb.Exec(dbQuery, userName, step)
+	if err != nil {
+		Log.Errorf("Recording the authenticator app code used by '%s' failed: %v", userName, err)
+		return false, err
+	}
+	return commandTag.RowsAffected() == 1, nil
+}
+
+// Adds an upload to a user's daily upload quota usage, returning an error if it would take them over their quota.
+// The usage resets a day after the first upload of the period.
+func UseUploadQuota(userName string, numBytes int64) error {
+	// Nothing to track if there's no quota
+	if Conf().Quota.DailyUploadMB == 0 {
+		return nil
+	}
+
+	// Begin a transaction
+	tx, err := pdb.Begin()
+	if err != nil {
+		return err
+	}
+	// Set up an automatic transaction roll back if the function exits without committing
+	defer tx.Rollback()
+
+	dbQuery := `
+		UPDATE users
+		SET quota_bytes_used = CASE WHEN quota_period_start < now() - interval '1 day' THEN $2
+				ELSE quota_bytes_used + $2 END,
+			quota_period_start = CASE WHEN quota_period_start < now() - interval '1 day' THEN now()
+				ELSE quota_period_start END
+		WHERE lower(user_name) = lower($1)
+		RETURNING quota_bytes_used`
+	var used int64
+	err = tx.QueryRow(dbQuery, userName, numBytes).Scan(&used)
+	if err != nil {
+		Log.Errorf("Updating upload quota usage failed for user '%s'. Error: '%v'", userName, err)
+		return err
+	}
+	if used > Conf().Quota.DailyUploadMB*1024*1024 {
+		return fmt.Errorf("This upload would take you over your daily upload limit of %d MB",
+			Conf().Quota.DailyUploadMB)
+	}
+	return tx.Commit()
+}
+
 // Returns details for a user.
 func User(userName string) (user UserDetails, err error) {
 	dbQuery := `
-		SELECT user_name, display_name, email, avatar_url, password_hash, date_joined, client_cert
+		SELECT user_name, display_name, email, avatar_url, password_hash, date_joined, client_cert,
+			coalesce(bio, ''), coalesce(location, ''), coalesce(website, ''), coalesce(social_links, '{}'),
+			coalesce(tip_links, '{}')
 		FROM users
 		WHERE lower(user_name) = lower($1)`
 	var av, dn, em pgx.NullString
 	err = pdb.QueryRow(dbQuery, userName).Scan(&user.Username, &dn, &em, &av, &user.PHash, &user.DateJoined,
-		&user.ClientCert)
+		&user.ClientCert, &user.Profile.Bio, &user.Profile.Location, &user.Profile.Website,
+		&user.Profile.SocialLinks, &user.Profile.TipLinks)
 	if err != nil {
 		if err == pgx.ErrNoRows {
 			// The error was just "no such user found"
@@ -3996,7 +9436,7 @@ func User(userName string) (user UserDetails, err error) {
 		}
 
 		// A real occurred
-		log.Printf("Error retrieving details for user '%s' from database: %v\n", userName, err)
+		Log.Errorf("Error retrieving details for user '%s' from database: %v", userName, err)
 		return user, nil
 	}
 
@@ -4022,8 +9462,51 @@ func User(userName string) (user UserDetails, err error) {
 	return user, nil
 }
 
+// Returns whether a user account is suspended, and the time (if any) its login sessions were last revoked.  This is
+// checked on every request, so is cached in memcached.
+func UserAccountStatus(userName string) (suspended bool, revokedAt time.Time, err error) {
+	type accountStatus struct {
+		RevokedAt time.Time
+		Suspended bool
+	}
+	var status accountStatus
+	cacheKey := accountStatusCacheKey(userName)
+	ok, err := GetCachedData(cacheKey, &status)
+	if err != nil {
+		Log.Errorf("Error retrieving data from cache: %v", err)
+	}
+	if ok {
+		return status.Suspended, status.RevokedAt, nil
+	}
+
+	dbQuery := `
+		SELECT suspended, sessions_revoked_at
+		FROM users
+		WHERE lower(user_name) = lower($1)`
+	var revoked pgx.NullTime
+	err = pdb.QueryRow(dbQuery, userName).Scan(&status.Suspended, &revoked)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			// The account no longer exists, so treat it the same as a suspended one
+			return true, time.Now(), nil
+		}
+		Log.Errorf("Retrieving account status failed for user '%s'. Error: '%v'", userName, err)
+		return false, time.Time{}, err
+	}
+	if revoked.Valid {
+		status.RevokedAt = revoked.Time
+	}
+
+	// Cache the status
+	err = CacheData(cacheKey, status, Conf().Memcache.DefaultCacheTime)
+	if err != nil {
+		Log.Errorf("Error when caching account status for user '%s': %v", userName, err)
+	}
+	return status.Suspended, status.RevokedAt, nil
+}
+
 // Returns the list of databases for a user.
-func UserDBs(userName string, public AccessType) (list []DBInfo, err error) {
+func UserDBs(userName string, public AccessType, sort SortOrder) (list []DBInfo, err error) {
 	// Construct SQL query for retrieving the requested database list
 	dbQuery := `
 		WITH u AS (
@@ -4039,17 +9522,17 @@ func UserDBs(userName string, public AccessType) (list []DBInfo, err error) {
 				db.watchers, db.stars, db.discussions, db.merge_requests, db.branches, db.release_count, db.tags,
 				db.contributors, db.one_line_description, default_commits.id,
 				db.commit_list->default_commits.id->'tree'->'entries'->0, db.source_url, db.default_branch,
-				db.download_count, db.page_views
+				db.download_count, db.page_views, db.is_draft
 			FROM sqlite_databases AS db, default_commits
 			WHERE db.db_id = default_commits.db_id
 				AND db.is_deleted = false`
 	switch public {
 	case DB_PUBLIC:
 		// Only public databases
-		dbQuery += ` AND db.public = true`
+		dbQuery += ` AND ` + publicProject("db")
 	case DB_PRIVATE:
-		// Only private databases
-		dbQuery += ` AND db.public = false`
+		// Only private databases, including drafts
+		dbQuery += ` AND (db.public = false OR db.is_draft = true)`
 	case DB_BOTH:
 		// Both public and private, so no need to add a query clause
 	default:
@@ -4059,11 +9542,11 @@ func UserDBs(userName string, public AccessType) (list []DBInfo, err error) {
 	dbQuery += `
 		)
 		SELECT *
-		FROM dbs
-		ORDER BY last_modified DESC`
+		FROM dbs AS db
+		ORDER BY ` + projectOrder(sort)
 	rows, err := pdb.Query(dbQuery, userName)
 	if err != nil {
-		log.Printf("Getting list of databases for user failed: %v\n", err)
+		Log.Errorf("Getting list of databases for user failed: %v", err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -4073,9 +9556,9 @@ func UserDBs(userName string, public AccessType) (list []DBInfo, err error) {
 		err = rows.Scan(&oneRow.Database, &oneRow.Folder, &oneRow.DateCreated, &oneRow.RepoModified, &oneRow.Public,
 			&oneRow.Watchers, &oneRow.Stars, &oneRow.Discussions, &oneRow.MRs, &oneRow.Branches,
 			&oneRow.Releases, &oneRow.Tags, &oneRow.Contributors, &desc, &oneRow.CommitID, &oneRow.DBEntry, &source,
-			&defBranch, &oneRow.Downloads, &oneRow.Views)
+			&defBranch, &oneRow.Downloads, &oneRow.Views, &oneRow.Draft)
 		if err != nil {
-			log.Printf("Error retrieving database list for user: %v\n", err)
+			Log.Errorf("Error retrieving database list for user: %v", err)
 			return nil, err
 		}
 		if defBranch.Valid {
@@ -4123,7 +9606,7 @@ func UserDBs(userName string, public AccessType) (list []DBInfo, err error) {
 					AND db_name = $3)`
 		err = pdb.QueryRow(dbQuery, userName, j.Folder, j.Database).Scan(&list[i].Forks)
 		if err != nil {
-			log.Printf("Error retrieving fork count for '%s%s%s': %v\n", userName, j.Folder,
+			Log.Errorf("Error retrieving fork count for '%s%s%s': %v", userName, j.Folder,
 				j.Database, err)
 			return nil, err
 		}
@@ -4132,6 +9615,175 @@ func UserDBs(userName string, public AccessType) (list []DBInfo, err error) {
 	return list, nil
 }
 
+// Returns the name and email address of a user, along with the events waiting to go in their next email digest.
+func UserDigest(userID int64) (userName string, email string, items []DigestItem, err error) {
+	dbQuery := `
+		SELECT user_name, coalesce(email, '')
+		FROM users
+		WHERE user_id = $1`
+	err = pdb.QueryRow(dbQuery, userID).Scan(&userName, &email)
+	if err != nil {
+		Log.Errorf("Retrieving the details of user ID %d failed: %v", userID, err)
+		return
+	}
+	dbQuery = `
+		SELECT item_id, project, title, url, event_type, event_timestamp
+		FROM digest_items
+		WHERE user_id = $1
+		ORDER BY item_id`
+	rows, err := pdb.Query(dbQuery, userID)
+	if err != nil {
+		Log.Errorf("Database query failed: %v", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var oneRow DigestItem
+		err = rows.Scan(&oneRow.ID, &oneRow.Project, &oneRow.Title, &oneRow.URL, &oneRow.Type, &oneRow.Timestamp)
+		if err != nil {
+			Log.Errorf("Error retrieving the digest items for user ID %d: %v", userID, err)
+			return
+		}
+		items = append(items, oneRow)
+	}
+	return
+}
+
+// Returns everything stored about a user, for an export of all of their data.  Each section is JSON, keyed by the
+// name of the section.  Also returns the sha256 of each file in the user's projects, for adding the files themselves.
+// Secrets (password hash, client certificate, two factor secret) aren't included.
+func UserExportData(userName string) (sections map[string]string, files []string, err error) {
+	var userID int64
+	dbQuery := `
+		SELECT user_id
+		FROM users
+		WHERE lower(user_name) = lower($1)`
+	err = pdb.QueryRow(dbQuery, userName).Scan(&userID)
+	if err != nil {
+		Log.Errorf("Looking up user '%s' for a data export failed: %v", userName, err)
+		return
+	}
+
+	queries := map[string]string{
+		"profile": `
+			SELECT row_to_json(p)::text
+			FROM (
+				SELECT user_name, display_name, email, avatar_url, bio, location, website, social_links, tip_links,
+					date_joined, last_seen, plan, pref_max_rows, pref_locale, pref_theme, pref_timezone,
+					pref_date_format, pref_email_frequency, status_updates
+				FROM users
+				WHERE user_id = $1
+			) AS p`,
+		"projects": `
+			SELECT coalesce(json_agg(p ORDER BY p.folder, p.db_name), '[]')::text
+			FROM (
+				SELECT folder, db_name, public, archived, date_created, last_modified, one_line_description,
+					full_description, readme, source_url, project_tags, stars, watchers, forks, download_count,
+					default_branch, branch_heads, commit_list, tag_list, release_list
+				FROM sqlite_databases
+				WHERE user_id = $1
+					AND is_deleted = false
+			) AS p`,
+		"discussions": `
+			SELECT coalesce(json_agg(d ORDER BY d.date_created), '[]')::text
+			FROM (
+				SELECT own.user_name AS owner, db.folder, db.db_name, disc.disc_id, disc.title, disc.description,
+					disc.discussion_type, disc.open, disc.date_created
+				FROM discussions AS disc
+					JOIN sqlite_databases AS db ON db.db_id = disc.db_id
+					JOIN users AS own ON own.user_id = db.user_id
+				WHERE disc.creator = $1
+					AND db.is_deleted = false
+			) AS d`,
+		"comments": `
+			SELECT coalesce(json_agg(c ORDER BY c.date_created), '[]')::text
+			FROM (
+				SELECT own.user_name AS owner, db.folder, db.db_name, disc.disc_id, com.entry_type, com.body,
+					com.date_created
+				FROM discussion_comments AS com
+					JOIN discussions AS disc ON disc.internal_id = com.disc_id
+					JOIN sqlite_databases AS db ON db.db_id = disc.db_id
+					JOIN users AS own ON own.user_id = db.user_id
+				WHERE com.commenter = $1
+					AND db.is_deleted = false
+			) AS c`,
+		"stars": `
+			SELECT coalesce(json_agg(s ORDER BY s.date_starred), '[]')::text
+			FROM (
+				SELECT own.user_name AS owner, db.folder, db.db_name, star.date_starred
+				FROM database_stars AS star
+					JOIN sqlite_databases AS db ON db.db_id = star.db_id
+					JOIN users AS own ON own.user_id = db.user_id
+				WHERE star.user_id = $1
+			) AS s`,
+		"watching": `
+			SELECT coalesce(json_agg(w ORDER BY w.date_watched), '[]')::text
+			FROM (
+				SELECT own.user_name AS owner, db.folder, db.db_name, wat.date_watched
+				FROM watchers AS wat
+					JOIN sqlite_databases AS db ON db.db_id = wat.db_id
+					JOIN users AS own ON own.user_id = db.user_id
+				WHERE wat.user_id = $1
+			) AS w`,
+		"uploads": `
+			SELECT coalesce(json_agg(u ORDER BY u.upload_date), '[]')::text
+			FROM (
+				SELECT own.user_name AS owner, db.folder, db.db_name, up.upload_date, up.db_sha256, up.ip_addr,
+					up.user_agent, up.server_sw
+				FROM database_uploads AS up
+					JOIN sqlite_databases AS db ON db.db_id = up.db_id
+					JOIN users AS own ON own.user_id = db.user_id
+				WHERE up.user_id = $1
+			) AS u`,
+		"downloads": `
+			SELECT coalesce(json_agg(d ORDER BY d.download_date), '[]')::text
+			FROM (
+				SELECT own.user_name AS owner, db.folder, db.db_name, dl.download_date, dl.db_sha256, dl.ip_addr,
+					dl.user_agent, dl.server_sw
+				FROM database_downloads AS dl
+					JOIN sqlite_databases AS db ON db.db_id = dl.db_id
+					JOIN users AS own ON own.user_id = db.user_id
+				WHERE dl.user_id = $1
+			) AS d`,
+	}
+	sections = make(map[string]string)
+	for name, q := range queries {
+		var data string
+		err = pdb.QueryRow(q, userID).Scan(&data)
+		if err != nil {
+			Log.Errorf("Retrieving the '%s' section of the data export for '%s' failed: %v", name, userName, err)
+			return
+		}
+		sections[name] = data
+	}
+
+	// The files in every commit of the user's projects
+	dbQuery = `
+		SELECT DISTINCT entry->>'sha256'
+		FROM sqlite_databases AS db, jsonb_each(db.commit_list) AS c,
+			jsonb_array_elements(c.value->'tree'->'entries') AS entry
+		WHERE db.user_id = $1
+			AND db.is_deleted = false
+			AND coalesce(entry->>'sha256', '') != ''
+		ORDER BY 1`
+	rows, err := pdb.Query(dbQuery, userID)
+	if err != nil {
+		Log.Errorf("Database query failed: %v", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var sha string
+		err = rows.Scan(&sha)
+		if err != nil {
+			Log.Errorf("Error retrieving the file list for the data export of '%s': %v", userName, err)
+			return
+		}
+		files = append(files, sha)
+	}
+	return
+}
+
 // Returns the username for a given Auth0 ID.
 func UserNameFromAuth0ID(auth0id string) (string, error) {
 	// Query the database for a username matching the given Auth0 ID
@@ -4148,13 +9800,52 @@ func UserNameFromAuth0ID(auth0id string) (string, error) {
 		}
 
 		// A real occurred
-		log.Printf("Error looking up username in database: %v\n", err)
+		Log.Errorf("Error looking up username in database: %v", err)
 		return "", nil
 	}
 
 	return userName, nil
 }
 
+// Returns the usernames being reclaimed, soonest first.
+func UsernameReclamations() (list []UsernameReclamation, err error) {
+	dbQuery := `
+		SELECT u.user_name, rc.requested_by, coalesce(rc.reason, ''), rc.date_notified, rc.reclaim_after
+		FROM username_reclamations AS rc
+			JOIN users AS u ON u.user_id = rc.user_id
+		ORDER BY rc.reclaim_after`
+	rows, err := pdb.Query(dbQuery)
+	if err != nil {
+		Log.Errorf("Retrieving the username reclamations failed: %v", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var oneRow UsernameReclamation
+		err = rows.Scan(&oneRow.UserName, &oneRow.RequestedBy, &oneRow.Reason, &oneRow.DateNotified,
+			&oneRow.ReclaimAfter)
+		if err != nil {
+			Log.Errorf("Error retrieving the username reclamations: %v", err)
+			return nil, err
+		}
+		list = append(list, oneRow)
+	}
+	return
+}
+
+// Returns the ID of the plan a user is on.
+func UserPlan(userName string) (plan string, err error) {
+	dbQuery := `
+		SELECT plan
+		FROM users
+		WHERE lower(user_name) = lower($1)`
+	err = pdb.QueryRow(dbQuery, userName).Scan(&plan)
+	if err != nil {
+		Log.Errorf("Retrieving the plan for user '%s' failed: %v", userName, err)
+	}
+	return
+}
+
 // Returns the list of databases starred by a user.
 func UserStarredDBs(userName string) (list []DBEntry, err error) {
 	dbQuery := `
@@ -4179,7 +9870,7 @@ func UserStarredDBs(userName string) (list []DBEntry, err error) {
 		ORDER BY date_starred DESC`
 	rows, err := pdb.Query(dbQuery, userName)
 	if err != nil {
-		log.Printf("Database query failed: %v\n", err)
+		Log.Errorf("Database query failed: %v", err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -4187,7 +9878,7 @@ func UserStarredDBs(userName string) (list []DBEntry, err error) {
 		var oneRow DBEntry
 		err = rows.Scan(&oneRow.Owner, &oneRow.Folder, &oneRow.DBName, &oneRow.DateEntry)
 		if err != nil {
-			log.Printf("Error retrieving stars list for user: %v\n", err)
+			Log.Errorf("Error retrieving stars list for user: %v", err)
 			return nil, err
 		}
 		list = append(list, oneRow)
@@ -4196,6 +9887,36 @@ func UserStarredDBs(userName string) (list []DBEntry, err error) {
 	return list, nil
 }
 
+// Returns the tip and donation links a user has added to their profile.
+func UserTipLinks(userName string) (links map[string]string, err error) {
+	dbQuery := `
+		SELECT coalesce(tip_links, '{}')
+		FROM users
+		WHERE lower(user_name) = lower($1)`
+	err = pdb.QueryRow(dbQuery, userName).Scan(&links)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		Log.Errorf("Retrieving the tip links for user '%s' failed: %v", userName, err)
+	}
+	return
+}
+
+// Returns the encrypted authenticator app secret for a user, the time step of the last code they used, and whether it's
+// locked after too many wrong codes.  The secret is empty if they haven't added an authenticator app.
+func UserTOTP(userName string) (sealed []byte, lastStep int64, locked bool, err error) {
+	dbQuery := `
+		SELECT coalesce(totp_secret, ''::bytea), totp_last_step, coalesce(totp_locked_until > now(), false)
+		FROM users
+		WHERE lower(user_name) = lower($1)`
+	err = pdb.QueryRow(dbQuery, userName).Scan(&sealed, &lastStep, &locked)
+	if err != nil {
+		Log.Errorf("Retrieving the authenticator app details for user '%s' failed: %v", userName, err)
+	}
+	return
+}
+
 // Returns the list of users who starred a database.
 func UsersStarredDB(owner string, folder string, fileName string) (list []DBEntry, err error) {
 	dbQuery := `
@@ -4221,7 +9942,7 @@ func UsersStarredDB(owner string, folder string, fileName string) (list []DBEntr
 		ORDER BY star_users.date_starred DESC`
 	rows, err := pdb.Query(dbQuery, owner, folder, fileName)
 	if err != nil {
-		log.Printf("Database query failed: %v\n", err)
+		Log.Errorf("Database query failed: %v", err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -4230,7 +9951,7 @@ func UsersStarredDB(owner string, folder string, fileName string) (list []DBEntr
 		var dn pgx.NullString
 		err = rows.Scan(&oneRow.Owner, &dn, &oneRow.DateEntry)
 		if err != nil {
-			log.Printf("Error retrieving list of stars for %s/%s: %v\n", owner, fileName, err)
+			Log.Errorf("Error retrieving list of stars for %s/%s: %v", owner, fileName, err)
 			return nil, err
 		}
 
@@ -4270,7 +9991,7 @@ func UsersWatchingDB(owner string, folder string, fileName string) (list []DBEnt
 		ORDER BY lst.date_watched DESC`
 	rows, err := pdb.Query(dbQuery, owner, folder, fileName)
 	if err != nil {
-		log.Printf("Database query failed: %v\n", err)
+		Log.Errorf("Database query failed: %v", err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -4279,7 +10000,7 @@ func UsersWatchingDB(owner string, folder string, fileName string) (list []DBEnt
 		var dn pgx.NullString
 		err = rows.Scan(&oneRow.Owner, &dn, &oneRow.DateEntry)
 		if err != nil {
-			log.Printf("Error retrieving list of watchers for %s%s%s: %v\n", owner, folder, fileName, err)
+			Log.Errorf("Error retrieving list of watchers for %s%s%s: %v", owner, folder, fileName, err)
 			return nil, err
 		}
 
@@ -4318,7 +10039,7 @@ func UserWatchingDBs(userName string) (list []DBEntry, err error) {
 		ORDER BY date_watched DESC`
 	rows, err := pdb.Query(dbQuery, userName)
 	if err != nil {
-		log.Printf("Database query failed: %v\n", err)
+		Log.Errorf("Database query failed: %v", err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -4326,7 +10047,7 @@ func UserWatchingDBs(userName string) (list []DBEntry, err error) {
 		var oneRow DBEntry
 		err = rows.Scan(&oneRow.Owner, &oneRow.Folder, &oneRow.DBName, &oneRow.DateEntry)
 		if err != nil {
-			log.Printf("Error retrieving database watch list for user: %v\n", err)
+			Log.Errorf("Error retrieving database watch list for user: %v", err)
 			return nil, err
 		}
 		list = append(list, oneRow)
@@ -4349,8 +10070,118 @@ func ViewCount(owner string, folder string, fileName string) (viewCount int, err
 			AND db_name = $3`
 	err = pdb.QueryRow(dbQuery, owner, folder, fileName).Scan(&viewCount)
 	if err != nil {
-		log.Printf("Retrieving view count for '%s%s%s' failed: %v\n", owner, folder, fileName, err)
+		Log.Errorf("Retrieving view count for '%s%s%s' failed: %v", owner, folder, fileName, err)
 		return 0, err
 	}
 	return
 }
+
+// Returns a revision of a page in a project's wiki.  A revision of 0 returns the latest one.
+func WikiPage(owner string, folder string, fileName string, pageName string, revision int) (page WikiRevision,
+	found bool, err error) {
+	dbQuery := `
+		SELECT w.page_name, w.revision, w.body, coalesce(w.edit_summary, ''), coalesce(u.user_name, ''),
+			w.date_created
+		FROM project_wiki_revisions AS w
+			JOIN sqlite_databases AS db ON db.db_id = w.db_id
+			LEFT JOIN users AS u ON u.user_id = w.user_id
+		WHERE db.user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND db.folder = $2
+			AND db.db_name = $3
+			AND db.is_deleted = false
+			AND w.page_name = $4
+			AND ($5::integer = 0 OR w.revision = $5::integer)
+		ORDER BY w.revision DESC
+		LIMIT 1`
+	err = pdb.QueryRow(dbQuery, owner, folder, fileName, pageName, revision).Scan(&page.PageName, &page.Revision,
+		&page.Body, &page.Summary, &page.Author, &page.Date)
+	if err == pgx.ErrNoRows {
+		return page, false, nil
+	}
+	if err != nil {
+		Log.Errorf("Retrieving wiki page '%s' for '%s%s%s' failed: %v", pageName, owner, folder, fileName, err)
+		return
+	}
+	return page, true, nil
+}
+
+// Returns the revisions of a page in a project's wiki, newest first.
+func WikiPageHistory(owner string, folder string, fileName string, pageName string) (list []WikiRevision,
+	err error) {
+	dbQuery := `
+		SELECT w.page_name, w.revision, coalesce(w.edit_summary, ''), coalesce(u.user_name, ''), w.date_created
+		FROM project_wiki_revisions AS w
+			JOIN sqlite_databases AS db ON db.db_id = w.db_id
+			LEFT JOIN users AS u ON u.user_id = w.user_id
+		WHERE db.user_id = (
+				SELECT user_id
+				FROM users
+				WHERE lower(user_name) = lower($1)
+			)
+			AND db.folder = $2
+			AND db.db_name = $3
+			AND db.is_deleted = false
+			AND w.page_name = $4
+		ORDER BY w.revision DESC`
+	rows, err := pdb.Query(dbQuery, owner, folder, fileName, pageName)
+	if err != nil {
+		Log.Errorf("Retrieving the history of wiki page '%s' for '%s%s%s' failed: %v", pageName, owner, folder,
+			fileName, err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var oneRow WikiRevision
+		err = rows.Scan(&oneRow.PageName, &oneRow.Revision, &oneRow.Summary, &oneRow.Author, &oneRow.Date)
+		if err != nil {
+			Log.Errorf("Error retrieving the history of wiki page '%s' for '%s%s%s': %v", pageName, owner, folder,
+				fileName, err)
+			return nil, err
+		}
+		list = append(list, oneRow)
+	}
+	return
+}
+
+// Returns the pages in a project's wiki, with the details of their latest revision.  They're sorted by name.
+func WikiPages(owner string, folder string, fileName string) (list []WikiRevision, err error) {
+	dbQuery := `
+		SELECT p.page_name, p.revision, p.edit_summary, p.author, p.date_created
+		FROM (
+			SELECT DISTINCT ON (w.page_name) w.page_name, w.revision, coalesce(w.edit_summary, '') AS edit_summary,
+				coalesce(u.user_name, '') AS author, w.date_created
+			FROM project_wiki_revisions AS w
+				JOIN sqlite_databases AS db ON db.db_id = w.db_id
+				LEFT JOIN users AS u ON u.user_id = w.user_id
+			WHERE db.user_id = (
+					SELECT user_id
+					FROM users
+					WHERE lower(user_name) = lower($1)
+				)
+				AND db.folder = $2
+				AND db.db_name = $3
+				AND db.is_deleted = false
+			ORDER BY w.page_name, w.revision DESC
+		) AS p
+		ORDER BY lower(p.page_name)`
+	rows, err := pdb.Query(dbQuery, owner, folder, fileName)
+	if err != nil {
+		Log.Errorf("Retrieving the wiki pages for '%s%s%s' failed: %v", owner, folder, fileName, err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var oneRow WikiRevision
+		err = rows.Scan(&oneRow.PageName, &oneRow.Revision, &oneRow.Summary, &oneRow.Author, &oneRow.Date)
+		if err != nil {
+			Log.Errorf("Error retrieving the wiki pages for '%s%s%s': %v", owner, folder, fileName, err)
+			return nil, err
+		}
+		list = append(list, oneRow)
+	}
+	return
+}
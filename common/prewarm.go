@@ -0,0 +1,121 @@
+package common
+
+import (
+	"time"
+)
+
+// When a project suddenly gets a lot of visitors (eg after being linked from a popular site), the first of them all
+// miss the caches at once, and each server fetches the same file from Minio and reads it.  The pre-warm loop fills the
+// caches ahead of time for the projects viewed the most recently, as seen by visitors who aren't logged in.  Memcached
+// is shared, so the entries already there are skipped, but the disk cache of files from Minio belongs to each server,
+// so every webui server runs the loop rather than just one of them.
+
+// How often the pre-warm loop checks whether it's been turned on, when it's off
+const prewarmCheckInterval = time.Minute
+
+// Fills the caches for the most viewed public projects every Prewarm.IntervalMinutes.  The settings are read each time
+// around, so reloading the configuration can turn it on or off.
+func PrewarmLoop() {
+	for {
+		interval := time.Duration(Conf().Prewarm.IntervalMinutes) * time.Minute
+		if interval <= 0 {
+			time.Sleep(prewarmCheckInterval)
+			continue
+		}
+		list, err := PopularProjects(Conf().Prewarm.Days, Conf().Prewarm.Projects)
+		if err == nil {
+			for _, p := range list {
+				err = PrewarmProject(p.Owner, p.Folder, p.DBName)
+				if err != nil {
+					Log.Warnf("Pre-warming the caches for '%s%s%s' failed: %v", p.Owner, p.Folder, p.DBName, err)
+				}
+			}
+		}
+		time.Sleep(interval)
+	}
+}
+
+// Fills the caches used when showing the head of a public project's default branch to visitors who aren't logged in.
+// That's the project details, the file itself in the local disk cache, and for databases the schema and the first page
+// of rows from the table shown by default.
+func PrewarmProject(owner string, folder string, fileName string) error {
+	commitID, err := DefaultCommit(owner, folder, fileName)
+	if err != nil {
+		return err
+	}
+	var DB SQLiteDBinfo
+	err = DBDetails(&DB, "", owner, folder, fileName, commitID)
+	if err != nil {
+		return err
+	}
+	sha := DB.Info.DBEntry.Sha256
+	if len(sha) <= MinioFolderChars {
+		return nil
+	}
+	bucket, id := sha[:MinioFolderChars], sha[MinioFolderChars:]
+
+	// Fetching the file puts it in the disk cache, if it isn't there already
+	sdb, err := OpenMinioObject(bucket, id)
+	if err != nil {
+		return err
+	}
+	defer sdb.Close()
+	if DB.Info.DBEntry.EntryType != DATABASE {
+		return nil
+	}
+
+	// The schema is cached by the Minio ID, the same as the schema end point does
+	schemaKey := MetadataCacheKey("schema", "", owner, folder, fileName, id)
+	var schema []SchemaTable
+	ok, err := GetCachedData(schemaKey, &schema)
+	if err != nil {
+		Log.Errorf("Error retrieving schema from cache: %v", err)
+	}
+	if !ok {
+		schema, err = ReadSQLiteDBSchema(sdb)
+		if err != nil {
+			return err
+		}
+		err = CacheData(schemaKey, schema, Conf().Memcache.DefaultCacheTime)
+		if err != nil {
+			Log.Errorf("Error when caching schema for '%s%s%s': %v", owner, folder, fileName, err)
+		}
+	}
+
+	// The first page of rows is cached under the default table name when one has been chosen, otherwise under an empty
+	// one.  Either way, the rows are from the table the database page would show.
+	var keyTable, dbTable string
+	if DB.Info.DefaultTable != "" && ValidatePGTable(DB.Info.DefaultTable) == nil {
+		keyTable, dbTable = DB.Info.DefaultTable, DB.Info.DefaultTable
+	} else {
+		tables, err := Tables(sdb, fileName)
+		if err != nil {
+			return err
+		}
+		for _, t := range tables {
+			if t != "" && ValidatePGTable(t) == nil {
+				dbTable = t
+				break
+			}
+		}
+	}
+	if dbTable == "" {
+		return nil
+	}
+	rowKey := TableRowsCacheKey("tablejson///0", "", owner, folder, fileName, commitID, keyTable,
+		DefaultNumDisplayRows)
+	var data SQLiteRecordSet
+	ok, err = GetCachedData(rowKey, &data)
+	if err != nil {
+		Log.Errorf("Error retrieving table rows from cache: %v", err)
+	}
+	if ok {
+		return nil
+	}
+	data, err = ReadSQLiteDB(sdb, dbTable, DefaultNumDisplayRows, "", "", 0)
+	if err != nil {
+		return err
+	}
+	data.Tablename = dbTable
+	return CacheData(rowKey, data, Conf().Memcache.DefaultCacheTime)
+}
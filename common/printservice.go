@@ -0,0 +1,264 @@
+package common
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Models can be sent to the print services set in the configuration file (eg a local print shop, or a printing bureau)
+// to get a quote for printing them.  The model is converted to the format the service wants, scaled to the size chosen,
+// and uploaded to the service's API, which gives back a link to its quote.  Each service uses one of the APIs in
+// printServiceAPIs, so other kinds of service can be supported by adding an implementation of PrintServiceAPI there.
+
+// The smallest and largest scales (as a percentage of the model's size) models can be sent to print services at
+const (
+	PrintScaleMax = 1000
+	PrintScaleMin = 1
+)
+
+// An API for sending models to print services
+type PrintServiceAPI interface {
+	// Uploads a model to the service, returning the link to its quote for printing it
+	Quote(svc PrintService, fileName string, model io.Reader) (quoteURL string, err error)
+}
+
+// The APIs print services can use, by the type given for them in the configuration file
+var printServiceAPIs = map[string]PrintServiceAPI{
+	"generic": genericPrintAPI{},
+}
+
+// The formats models can be converted to for print services, and the Assimp exporter used for each
+var printFormats = map[string]string{
+	"3mf": "3mf",
+	"obj": "obj",
+	"stl": "stlb",
+}
+
+// Used for the requests to print services.  Models can be large, so the timeout is a generous one
+var printClient = &http.Client{Timeout: 5 * time.Minute}
+
+// Talks to print services which take a model as the "file" field of a multipart form POST, and respond with JSON
+// holding the link to their quote.
+type genericPrintAPI struct{}
+
+func (genericPrintAPI) Quote(svc PrintService, fileName string, model io.Reader) (quoteURL string, err error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, err := mw.CreateFormFile("file", fileName)
+	if err != nil {
+		return
+	}
+	if _, err = io.Copy(part, model); err != nil {
+		return
+	}
+	if err = mw.WriteField("units", "mm"); err != nil {
+		return
+	}
+	if err = mw.Close(); err != nil {
+		return
+	}
+
+	req, err := http.NewRequest(http.MethodPost, svc.APIURL, &body)
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("User-Agent", "3DHub.io")
+	if svc.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+svc.APIKey)
+	}
+	resp, err := printClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("Sending the model to %s failed: %v", svc.Name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", fmt.Errorf("%s responded with status %d", svc.Name, resp.StatusCode)
+	}
+	var result map[string]interface{}
+	err = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&result)
+	if err != nil {
+		return "", fmt.Errorf("The response from %s couldn't be understood: %v", svc.Name, err)
+	}
+	field := svc.QuoteField
+	if field == "" {
+		field = "quote_url"
+	}
+	quoteURL, _ = result[field].(string)
+	return
+}
+
+// Converts a model file to the given format, scaled by the given factor.  Assimp can't scale models itself, so the
+// model is converted to a binary STL first and scaled, then converted from that to any other format.  Returns the name
+// of the converted file, which the caller needs to remove.
+func convertForPrint(inFile string, format string, scale float64) (outFile string, err error) {
+	exporter, ok := printFormats[format]
+	if !ok {
+		return "", fmt.Errorf("Unknown print format '%s'", format)
+	}
+	stlFile, err := tempPrintFile(".stl")
+	if err != nil {
+		return
+	}
+	err = exec.Command("/usr/local/bin/assimp", "export", inFile, stlFile, "--format=stlb").Run()
+	if err != nil {
+		os.Remove(stlFile)
+		return "", fmt.Errorf("Converting the model failed: %v", err)
+	}
+	if err = scaleBinarySTL(stlFile, scale); err != nil {
+		os.Remove(stlFile)
+		return
+	}
+	if format == "stl" {
+		return stlFile, nil
+	}
+	defer os.Remove(stlFile)
+	outFile, err = tempPrintFile("." + format)
+	if err != nil {
+		return
+	}
+	err = exec.Command("/usr/local/bin/assimp", "export", stlFile, outFile, "--format="+exporter).Run()
+	if err != nil {
+		os.Remove(outFile)
+		return "", fmt.Errorf("Converting the model failed: %v", err)
+	}
+	return
+}
+
+// Copies a model from Minio to a temporary file, returning its name.  Assimp works out the format of files from their
+// extension, so the copy keeps the one the model has.  The caller needs to remove the file.
+func printModelFile(bucket string, id string, fileName string) (name string, err error) {
+	obj, err := MinioHandle(bucket, id)
+	if err != nil {
+		return
+	}
+	defer MinioHandleClose(obj)
+	name, err = tempPrintFile(strings.ToLower(filepath.Ext(fileName)))
+	if err != nil {
+		return
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY, 0600)
+	if err == nil {
+		_, err = io.Copy(f, obj)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		os.Remove(name)
+		return "", err
+	}
+	return
+}
+
+// Returns the details of a print service.
+func PrintServiceByID(id string) (svc PrintService, found bool) {
+	for _, s := range Conf().Print.Services {
+		if s.ID == id {
+			return s, true
+		}
+	}
+	return
+}
+
+// Scales the vertices of a binary STL file by the given factor, in place.  The normals don't change with a uniform
+// scale, so they're left alone.
+func scaleBinarySTL(fileName string, scale float64) error {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+	if len(data) < 84 {
+		return errors.New("The converted model is too short to be a binary STL file")
+	}
+	numTris := int(binary.LittleEndian.Uint32(data[80:84]))
+	if len(data) < 84+numTris*50 {
+		return errors.New("The converted model is shorter than its triangle count says")
+	}
+
+	// Each triangle is a normal and three vertices (12 float32s), followed by a two byte attribute count
+	for i := 0; i < numTris; i++ {
+		tri := data[84+i*50:]
+		for off := 12; off < 48; off += 4 {
+			v := math.Float32frombits(binary.LittleEndian.Uint32(tri[off:]))
+			binary.LittleEndian.PutUint32(tri[off:], math.Float32bits(float32(float64(v)*scale)))
+		}
+	}
+	return ioutil.WriteFile(fileName, data, 0600)
+}
+
+// Sends the model of a project to a print service, converted to the service's format and scaled to the given
+// percentage of its size.  Returns the link to the service's quote for printing it.
+func SendToPrintService(svc PrintService, bucket string, id string, fileName string, scale int) (quoteURL string,
+	err error) {
+	if scale < PrintScaleMin || scale > PrintScaleMax {
+		return "", fmt.Errorf("The scale needs to be between %d%% and %d%%", PrintScaleMin, PrintScaleMax)
+	}
+	apiType := svc.Type
+	if apiType == "" {
+		apiType = "generic"
+	}
+	api, ok := printServiceAPIs[apiType]
+	if !ok {
+		Log.Errorf("Print service '%s' uses the unknown API type '%s'", svc.ID, apiType)
+		return "", fmt.Errorf("%s isn't set up correctly", svc.Name)
+	}
+	format := strings.ToLower(svc.Format)
+	if format == "" {
+		format = "stl"
+	}
+
+	inFile, err := printModelFile(bucket, id, fileName)
+	if err != nil {
+		return
+	}
+	defer os.Remove(inFile)
+	outFile, err := convertForPrint(inFile, format, float64(scale)/100)
+	if err != nil {
+		return
+	}
+	defer os.Remove(outFile)
+	model, err := os.Open(outFile)
+	if err != nil {
+		return
+	}
+	defer model.Close()
+	name := strings.TrimSuffix(fileName, filepath.Ext(fileName)) + "." + format
+	quoteURL, err = api.Quote(svc, name, model)
+	if err != nil {
+		return
+	}
+
+	// The link is shown to the user, so make sure it's one for a web page
+	u, err := url.Parse(quoteURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		Log.Warnf("Print service '%s' gave back an unusable quote link: '%s'", svc.ID, quoteURL)
+		return "", fmt.Errorf("%s didn't give back a link to its quote", svc.Name)
+	}
+	Log.Infof("Model '%s' sent to print service '%s' at %d%% scale", fileName, svc.ID, scale)
+	return quoteURL, nil
+}
+
+// Creates an empty temporary file with the given extension, for converting models in.  Returns its name.
+func tempPrintFile(ext string) (string, error) {
+	f, err := ioutil.TempFile(Conf().DiskCache.Directory, "3dhub-print-*"+ext)
+	if err != nil {
+		return "", err
+	}
+	f.Close()
+	return f.Name(), nil
+}
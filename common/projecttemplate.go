@@ -0,0 +1,59 @@
+package common
+
+import (
+	"strings"
+)
+
+// New projects can be created from a template, which fills in the parts people otherwise set up by hand each time: a
+// README skeleton, the licence, the category, the one line description, and the tags.  Templates can also hold the
+// print settings which usually suit the kind of model, which are added to the README under their own heading.
+// Instance templates are set up by the site admins and offered to everyone, and users can save their own as well.
+// Templates only apply to new projects, so uploading a new version of a project never changes what's already set.
+
+// The README heading the print settings from a template go under
+const templatePrintHeading = "## Print settings"
+
+// Applies the parts of a template which are stored separately from the uploaded file (the one line description and
+// the tags) to a newly created project.
+func ApplyProjectTemplate(owner string, folder string, fileName string, t ProjectTemplate) error {
+	if t.OneLineDesc != "" {
+		err := StoreProjectDescriptions(owner, folder, fileName, t.OneLineDesc, "")
+		if err != nil {
+			return err
+		}
+	}
+	if len(t.Tags) > 0 {
+		err := StoreProjectTags(owner, folder, fileName, t.Tags)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the project template of the given name a user can use.  The user's own templates take precedence over the
+// instance ones.
+func FindProjectTemplate(userName string, name string) (t ProjectTemplate, found bool, err error) {
+	list, err := ProjectTemplates(userName)
+	if err != nil {
+		return
+	}
+	for _, j := range list {
+		if j.Name == name && (!found || !j.Instance) {
+			t, found = j, true
+		}
+	}
+	return
+}
+
+// Returns the README for a project created from a template, with the template's print settings (if any) included.
+func TemplateReadme(t ProjectTemplate) string {
+	readme := strings.TrimSpace(t.Readme)
+	if s := strings.TrimSpace(t.PrintSettings); s != "" {
+		if readme != "" {
+			readme += "\n\n"
+		}
+		readme += templatePrintHeading + "\n\n" + s
+	}
+	return readme
+}
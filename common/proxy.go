@@ -0,0 +1,102 @@
+package common
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// The reverse proxies (from the configuration file) whose X-Forwarded-* headers are trusted.  It holds a []*net.IPNet,
+// which is replaced rather than changed when the configuration is reloaded
+var trustedProxies atomic.Value
+
+// Rewrites the remote address and URL scheme of a request using its X-Forwarded-For and X-Forwarded-Proto headers, when
+// the request has come through one of the trusted reverse proxies.  Those headers are ignored for requests from
+// anywhere else, as they're trivial to forge.
+func ApplyProxyHeaders(r *http.Request) {
+	if !isTrustedProxy(hostIP(r.RemoteAddr)) {
+		return
+	}
+
+	// The client address is the right most one in the X-Forwarded-For list which isn't one of our proxies.  Anything
+	// to the left of it was added by the client (or proxies we know nothing about), so can't be relied on.  Each proxy
+	// may add its own header line rather than appending to the existing one, so all of the lines are joined together
+	// first, in the order they were received
+	if fwd := strings.Join(r.Header["X-Forwarded-For"], ","); fwd != "" {
+		addrs := strings.Split(fwd, ",")
+		for i := len(addrs) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(addrs[i]))
+			if ip == nil {
+				break
+			}
+			r.RemoteAddr = ip.String()
+			if !isTrustedProxy(ip) {
+				break
+			}
+		}
+	}
+	if proto := strings.ToLower(r.Header.Get("X-Forwarded-Proto")); proto == "http" || proto == "https" {
+		r.URL.Scheme = proto
+	}
+}
+
+// Returns the IP address part of a "host:port" network address.  Addresses without a port are handled as well, as
+// that's what ApplyProxyHeaders() leaves in place.
+func hostIP(addr string) net.IP {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	return net.ParseIP(addr)
+}
+
+// Returns true if the IP address is one of the trusted reverse proxies.
+func isTrustedProxy(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	nets, _ := trustedProxies.Load().([]*net.IPNet)
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Parses an IP address or a network in CIDR notation (eg 10.0.0.0/8).  Single addresses are returned as a network
+// containing just that address.
+func ParseNetwork(entry string) (*net.IPNet, error) {
+	if !strings.Contains(entry, "/") {
+		ip := net.ParseIP(entry)
+		if ip == nil {
+			return nil, fmt.Errorf("Invalid IP address: '%s'", entry)
+		}
+		bits := 8 * net.IPv6len
+		if ip.To4() != nil {
+			ip = ip.To4()
+			bits = 8 * net.IPv4len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, n, err := net.ParseCIDR(entry)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid network: '%s'", entry)
+	}
+	return n, nil
+}
+
+// Parses the list of trusted reverse proxies.  Each entry can be either a single IP address, or a network in CIDR
+// notation.
+func parseTrustedProxies(list []string) (nets []*net.IPNet, err error) {
+	for _, entry := range list {
+		var n *net.IPNet
+		n, err = ParseNetwork(entry)
+		if err != nil {
+			return nil, fmt.Errorf("Trusted proxy problem: %v", err)
+		}
+		nets = append(nets, n)
+	}
+	return
+}
@@ -0,0 +1,61 @@
+package common
+
+import (
+	"bytes"
+	"fmt"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// The smallest, largest, and default widths (in pixels) of PNG QR codes
+const (
+	QRCodeDefaultSize = 256
+	QRCodeMaxSize     = 2048
+	QRCodeMinSize     = 64
+)
+
+// Returns a QR code for the given text, as a PNG of the given width, or as an SVG.  A medium level of error correction
+// is used, so printed codes still scan when they're a bit worn.
+func QRCode(text string, format string, size int) (data []byte, contentType string, err error) {
+	switch format {
+	case "png":
+		if size < QRCodeMinSize || size > QRCodeMaxSize {
+			return nil, "", fmt.Errorf("The size needs to be between %d and %d pixels", QRCodeMinSize,
+				QRCodeMaxSize)
+		}
+		data, err = qrcode.Encode(text, qrcode.Medium, size)
+		return data, "image/png", err
+	case "svg":
+		var q *qrcode.QRCode
+		q, err = qrcode.New(text, qrcode.Medium)
+		if err != nil {
+			return
+		}
+		return qrCodeSVG(q.Bitmap()), "image/svg+xml", nil
+	}
+	return nil, "", fmt.Errorf("Unknown QR code format '%s'", format)
+}
+
+// Draws a QR code as an SVG, with one unit per module.  The dark modules are drawn as a single path, with each run of
+// them along a row being one rectangle, which keeps the file small.
+func qrCodeSVG(bitmap [][]bool) []byte {
+	var path bytes.Buffer
+	for y, row := range bitmap {
+		for x := 0; x < len(row); x++ {
+			if !row[x] {
+				continue
+			}
+			start := x
+			for x < len(row) && row[x] {
+				x++
+			}
+			fmt.Fprintf(&path, "M%d %dh%dv1h-%dz", start, y, x-start, x-start)
+		}
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges">`,
+		len(bitmap), len(bitmap))
+	fmt.Fprintf(&b, `<rect width="100%%" height="100%%" fill="#fff"/><path fill="#000" d="%s"/></svg>`,
+		path.String())
+	return b.Bytes()
+}
@@ -0,0 +1,154 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+)
+
+// When the checks run on uploads improve (eg a newer Assimp which understands more formats, or counts triangles
+// differently), the files already stored can be analysed again so their details catch up.  This re-runs the checks on
+// the file at the head of a project's default branch, and updates the triangle count and checksums kept for it.  Any
+// problem found is recorded against the project rather than failing the job, so owners and admins can see it.
+
+// Queues a background job to re-analyse a project's file.
+func QueueReanalysis(owner string, folder string, fileName string) error {
+	_, err := QueueJob("reanalyse", ProjectRef{DBName: fileName, Folder: folder, Owner: owner})
+	if err != nil {
+		Log.Errorf("Error when queuing the re-analysis of '%s%s%s': %v", owner, folder, fileName, err)
+	}
+	return err
+}
+
+// Queues a background job to re-analyse each project on the server, returning the number queued.
+func QueueReanalysisAll() (n int, err error) {
+	projects, err := AllProjects()
+	if err != nil {
+		return
+	}
+	for _, p := range projects {
+		err = QueueReanalysis(p.Owner, p.Folder, p.DBName)
+		if err != nil {
+			return
+		}
+		n++
+	}
+	return
+}
+
+// Re-analyses a project's file, recording the result against the project.  The payload is a ProjectRef.
+func ReanalyseJob(payload json.RawMessage) error {
+	var p ProjectRef
+	err := json.Unmarshal(payload, &p)
+	if err != nil {
+		return err
+	}
+
+	// Skip projects deleted since the job was queued
+	exists, err := CheckFileExists(p.Owner, p.Owner, p.Folder, p.DBName)
+	if err != nil || !exists {
+		return err
+	}
+	problem, err := reanalyse(p.Owner, p.Folder, p.DBName)
+	if err != nil {
+		return err
+	}
+	if problem != "" {
+		Log.Warnf("Re-analysing '%s%s%s' found a problem: %s", p.Owner, p.Folder, p.DBName, problem)
+	}
+	err = StoreAnalysisResult(p.Owner, p.Folder, p.DBName, problem)
+	if err != nil {
+		return err
+	}
+	err = UpdateSearchIndex(p.Owner, p.Folder, p.DBName)
+	if err != nil {
+		Log.Errorf("Error when updating the search index: %s", err.Error())
+	}
+	return InvalidateCacheEntry(p.Owner, p.Owner, p.Folder, p.DBName, "")
+}
+
+// Runs the upload checks again on the file at the head of a project's default branch, updating the details kept about
+// it.  Problems with the file itself are returned as a description, while the error is for things (eg Minio being
+// unreachable) which are worth retrying.
+func reanalyse(owner string, folder string, fileName string) (problem string, err error) {
+	defBranch, err := GetDefaultBranchName(owner, folder, fileName)
+	if err != nil {
+		return
+	}
+	branches, err := GetBranches(owner, folder, fileName)
+	if err != nil {
+		return
+	}
+	head, ok := branches[defBranch]
+	if !ok {
+		return "", fmt.Errorf("The default branch '%s' of '%s%s%s' doesn't exist", defBranch, owner, folder,
+			fileName)
+	}
+	commits, err := GetCommitList(owner, folder, fileName)
+	if err != nil {
+		return
+	}
+	c, ok := commits[head.Commit]
+	if !ok || len(c.Tree.Entries) == 0 {
+		return "", fmt.Errorf("The head commit '%s' of '%s%s%s' couldn't be found", head.Commit, owner, folder,
+			fileName)
+	}
+	entry := c.Tree.Entries[0]
+	if len(entry.Sha256) <= MinioFolderChars {
+		return fmt.Sprintf("'%s' isn't the SHA256 of a stored file", entry.Sha256), nil
+	}
+
+	// Copy the file from Minio to a temporary file, checking it hasn't been corrupted along the way
+	tempFile, err := ioutil.TempFile(Conf().DiskCache.Directory, "reanalyse-")
+	if err != nil {
+		return
+	}
+	defer os.Remove(tempFile.Name())
+	obj, err := MinioHandle(entry.Sha256[:MinioFolderChars], entry.Sha256[MinioFolderChars:])
+	if err != nil {
+		tempFile.Close()
+		return
+	}
+	hasher := newChecksummer()
+	_, err = io.Copy(io.MultiWriter(tempFile, hasher), obj)
+	MinioHandleClose(obj)
+	tempFile.Close()
+	if err != nil {
+		Log.Errorf("Reading file '%s' from Minio for re-analysis failed: %v", entry.Sha256, err)
+		return
+	}
+	sums := hasher.Sums()
+	if sums.SHA256 != entry.Sha256 {
+		Log.Errorf("The contents of file '%s' in Minio have a SHA256 of '%s'", entry.Sha256, sums.SHA256)
+		return "The stored file is corrupted, as it doesn't match its checksum", nil
+	}
+	StoreChecksums(sums)
+
+	// Run the same checks as for uploads
+	if entry.EntryType == DATABASE {
+		var isDB bool
+		isDB, err = SanityCheckDatabase(tempFile.Name())
+		if !isDB && err == nil {
+			return "The file is no longer recognised as a SQLite database", nil
+		}
+		if isDB && err != nil {
+			return err.Error(), nil
+		}
+		return
+	}
+	ok, numTris, err := SanityCheck3DModel(tempFile.Name())
+	if _, exitErr := err.(*exec.ExitError); exitErr {
+		return "Assimp couldn't read the model", nil
+	}
+	if err != nil {
+		return
+	}
+	if !ok {
+		return "The file doesn't appear to be a 3D model", nil
+	}
+	err = StoreTriangleCount(owner, folder, fileName, numTris)
+	return
+}
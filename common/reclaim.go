@@ -0,0 +1,59 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Site admins can reclaim the usernames of accounts which haven't been used for years, and have nothing on the site
+// (no projects, discussions, or comments).  The user is emailed first, and their account is only removed once the
+// grace period has passed without them using the site.  Using the site in the meantime cancels the reclamation.
+// Reclaimed usernames are then reserved, so nobody can register them until an admin removes the reservation.
+
+// How long users have after being emailed to keep their account, by using the site
+const ReclaimGracePeriod = 30 * 24 * time.Hour
+
+// The fewest years an account needs to have been unused for, before its username can be reclaimed
+const ReclaimMinInactiveYears = 2
+
+// Tells the user of an inactive account that its username will be reclaimed, unless they use the site before the
+// grace period ends.
+func NotifyUsernameReclamation(adminUser string, userName string, reason string, years int) error {
+	if years < ReclaimMinInactiveYears {
+		return fmt.Errorf("Accounts need to have been unused for at least %d years", ReclaimMinInactiveYears)
+	}
+	if IsAdmin(userName) {
+		return errors.New("The usernames of site admins can't be reclaimed")
+	}
+
+	// The user needs to be told, so accounts we can't email are left alone
+	usr, err := User(userName)
+	if err != nil {
+		return err
+	}
+	if !CanEmailUser(usr) {
+		return errors.New("That account doesn't have an email address we can send to, so its user can't be told")
+	}
+
+	started, err := StartUsernameReclamation(adminUser, userName, reason, years, ReclaimGracePeriod)
+	if err != nil {
+		return err
+	}
+	if !started {
+		return fmt.Errorf("That account has been used in the last %d years, has something on the site, or is "+
+			"already being reclaimed", years)
+	}
+	deadline := time.Now().Add(ReclaimGracePeriod).UTC().Format("2 January 2006")
+	err = QueueEmail(usr.Email, fmt.Sprintf("3DHub.io: Your account '%s' is due to be removed", usr.Username),
+		fmt.Sprintf("Your 3DHub.io account '%s' hasn't been used for over %d years, and has nothing on the site, so "+
+			"its username is due to be freed up for someone else.\n\nIf you'd like to keep it, just log in at "+
+			"https://%s before %s.  Otherwise the account will be removed after that date.", usr.Username, years,
+			Conf().Web.ServerName, deadline))
+	if err != nil {
+		// Don't remove an account whose user hasn't been told
+		CancelUsernameReclamation(userName)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+package common
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Projects whose model is generated from an OpenSCAD source file can have an inbound webhook, which CI (or anything
+// else) calls after the source has been updated.  The source is then fetched, rendered again with OpenSCAD, and the
+// result is committed as a new version of the project.  The openscad executable needs to be in the PATH.
+
+// The most OpenSCAD source we'll download for a regeneration
+const regenMaxSourceSize = 8 << 20
+
+// How long OpenSCAD is allowed to take to render a model
+const regenRenderTimeout = 10 * time.Minute
+
+// The model formats OpenSCAD can export, which regeneration is available for
+var regenFormats = map[string]bool{".3mf": true, ".amf": true, ".off": true, ".stl": true}
+
+// The client used for fetching OpenSCAD sources.  It won't connect to loopback, private, or link local addresses, so
+// the webhook can't be used to reach things on our own network.
+var regenClient = &http.Client{
+	Timeout: time.Minute,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 30 * time.Second,
+			Control: func(network string, address string, c syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				if !regenPublicIP(net.ParseIP(host)) {
+					return fmt.Errorf("Connecting to '%s' isn't allowed", host)
+				}
+				return nil
+			},
+		}).DialContext,
+	},
+}
+
+// The address ranges OpenSCAD sources can't be fetched from
+var regenBlockedNets = func() (nets []*net.IPNet) {
+	for _, c := range []string{"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16",
+		"172.16.0.0/12", "192.168.0.0/16", "::1/128", "fc00::/7", "fe80::/10"} {
+		_, n, _ := net.ParseCIDR(c)
+		nets = append(nets, n)
+	}
+	return
+}()
+
+// The request details recorded for versions committed by regeneration
+var regenRequest = &http.Request{
+	Header:     http.Header{"User-Agent": {"3DHub regeneration"}},
+	RemoteAddr: "127.0.0.1",
+}
+
+// Checks the token given to a regeneration webhook matches the project's one.
+func CheckRegenerationToken(hook RegenerationHookEntry, token string) bool {
+	return token != "" && subtle.ConstantTimeCompare([]byte(hook.Token), []byte(token)) == 1
+}
+
+// Queues a background job to regenerate a project.  The message is used for the commit, if there's a new version.
+func QueueRegeneration(id int64, message string) error {
+	_, err := QueueJob("regenerate", map[string]interface{}{"id": id, "message": message})
+	if err != nil {
+		Log.Errorf("Error when queuing the regeneration of project %d: %v", id, err)
+	}
+	return err
+}
+
+// Regenerates a project from its OpenSCAD source.  The payload holds the ID of the project and the commit message.
+func RegenerateJob(payload json.RawMessage) error {
+	var p struct {
+		ID      int64  `json:"id"`
+		Message string `json:"message"`
+	}
+	err := json.Unmarshal(payload, &p)
+	if err != nil {
+		return err
+	}
+	hook, err := RegenerationHook(p.ID)
+	if err != nil {
+		return err
+	}
+	commitID, err := regenerate(hook, p.Message)
+	StoreRegenerationResult(hook.ID, commitID, err)
+	return err
+}
+
+// Fetches, renders, and commits a new version of a project.  The returned commit ID is empty if the rendered model is
+// the same as the current one.
+func regenerate(hook RegenerationHookEntry, message string) (commitID string, err error) {
+	format := strings.ToLower(filepath.Ext(hook.FileName))
+	if !regenFormats[format] {
+		return "", fmt.Errorf("OpenSCAD can't produce '%s' files", format)
+	}
+
+	// Download the source
+	dir, err := ioutil.TempDir(Conf().DiskCache.Directory, "regenerate-")
+	if err != nil {
+		return
+	}
+	defer os.RemoveAll(dir)
+	resp, err := regenClient.Get(hook.SourceURL)
+	if err != nil {
+		Log.Warnf("Fetching the OpenSCAD source for project %d failed: %v", hook.ID, err)
+		return "", errors.New("Couldn't fetch the OpenSCAD source")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Fetching the OpenSCAD source failed with '%s'", resp.Status)
+	}
+	src, err := ioutil.ReadAll(io.LimitReader(resp.Body, regenMaxSourceSize+1))
+	if err != nil {
+		return
+	}
+	if len(src) > regenMaxSourceSize {
+		return "", fmt.Errorf("The OpenSCAD source is larger than the %d MB limit", regenMaxSourceSize>>20)
+	}
+	srcFile := filepath.Join(dir, "source.scad")
+	err = ioutil.WriteFile(srcFile, src, 0600)
+	if err != nil {
+		return
+	}
+
+	// Render it
+	outFile := filepath.Join(dir, "model"+format)
+	ctx, cancel := context.WithTimeout(context.Background(), regenRenderTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "openscad", "-o", outFile, srcFile).CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		return "", errors.New("OpenSCAD took too long to render the model")
+	}
+	if err != nil {
+		Log.Warnf("Rendering project %d with OpenSCAD failed: %v: %s", hook.ID, err, out)
+		return "", fmt.Errorf("OpenSCAD couldn't render the model: %s", strings.TrimSpace(string(out)))
+	}
+
+	// Don't add a version if nothing changed
+	f, err := os.Open(outFile)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return
+	}
+	sha := hex.EncodeToString(h.Sum(nil))
+	head, err := DefaultCommit(hook.Owner, hook.Folder, hook.FileName)
+	if err != nil {
+		return
+	}
+	commits, err := GetCommitList(hook.Owner, hook.Folder, hook.FileName)
+	if err != nil {
+		return
+	}
+	if c, ok := commits[head]; ok && len(c.Tree.Entries) > 0 && c.Tree.Entries[0].Sha256 == sha {
+		return "", nil
+	}
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return
+	}
+
+	if message == "" {
+		message = "Regenerated from " + hook.SourceURL
+	}
+	_, commitID, err = AddFile(regenRequest, hook.Owner, hook.Owner, hook.Folder, hook.FileName, false, "", head,
+		false, "", message, "", f, "openscad", time.Now(), time.Time{}, "", "", "", "", nil, "", false)
+	return
+}
+
+// Reports whether an address is one OpenSCAD sources can be fetched from.
+func regenPublicIP(ip net.IP) bool {
+	if ip == nil || ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, n := range regenBlockedNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// Checks the URL of an OpenSCAD source is one we can fetch.
+func ValidateRegenerationSource(source string) error {
+	u, err := url.Parse(source)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("The OpenSCAD source needs to be a http or https URL")
+	}
+	return nil
+}
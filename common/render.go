@@ -0,0 +1,182 @@
+package common
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Two versions of a project can be compared visually, using renders of each made from the same camera position.  The
+// camera is placed using the bounding box of both models together, so a part which has grown or moved shows up as
+// such, rather than each render being zoomed to fit its own model.  The renders are made by OpenSCAD, which needs to
+// be in the PATH.  On servers without a display it needs running under something like xvfb-run.
+
+const (
+	// How long the renders for a comparison are cached for.  The versions of a project never change, so this can be a
+	// long time
+	renderCompareCacheTime = 7 * 24 * 60 * 60
+
+	// The size (in pixels) of the renders
+	renderCompareHeight = 600
+	renderCompareWidth  = 800
+
+	// How long OpenSCAD is allowed to take for each render
+	renderCompareTimeout = 5 * time.Minute
+)
+
+// The renders for a comparison, as they're cached
+type renderPair struct {
+	A []byte
+	B []byte
+}
+
+// Returns PNG renders of two versions of a model, both made from the same camera position.  The buckets and IDs are
+// the Minio locations of each version, as returned by MinioLocation().
+func RenderComparison(bucketA string, idA string, bucketB string, idB string, fileName string) (a []byte, b []byte,
+	err error) {
+	// Use the cached renders if they're available
+	tempArr := md5.Sum([]byte(fmt.Sprintf("render-compare-%s%s-%s%s", bucketA, idA, bucketB, idB)))
+	cacheKey := hex.EncodeToString(tempArr[:])
+	var pair renderPair
+	ok, err := GetCachedData(cacheKey, &pair)
+	if err != nil {
+		Log.Warnf("Error retrieving cached render comparison: %v", err)
+	}
+	if ok && len(pair.A) > 0 && len(pair.B) > 0 {
+		return pair.A, pair.B, nil
+	}
+
+	// Convert both versions to binary STL, which gives OpenSCAD something it can import, and us something simple to
+	// work out the bounding box of
+	stlA, err := renderModelSTL(bucketA, idA, fileName)
+	if err != nil {
+		return
+	}
+	defer os.Remove(stlA)
+	stlB, err := renderModelSTL(bucketB, idB, fileName)
+	if err != nil {
+		return
+	}
+	defer os.Remove(stlB)
+	loA, hiA, err := stlBounds(stlA)
+	if err != nil {
+		return
+	}
+	loB, hiB, err := stlBounds(stlB)
+	if err != nil {
+		return
+	}
+
+	// Look at the middle of both models together from above and in front, far enough back for all of them to be in view
+	var centre, eye [3]float64
+	var radius float64
+	for i := range centre {
+		lo, hi := math.Min(loA[i], loB[i]), math.Max(hiA[i], hiB[i])
+		centre[i] = (lo + hi) / 2
+		radius += (hi - lo) * (hi - lo) / 4
+	}
+	radius = math.Sqrt(radius)
+	if radius == 0 {
+		return nil, nil, errors.New("The models are empty, so there's nothing to compare")
+	}
+	dir := [3]float64{1, -1, 0.8}
+	dirLen := math.Sqrt(dir[0]*dir[0] + dir[1]*dir[1] + dir[2]*dir[2])
+	for i := range eye {
+		eye[i] = centre[i] + dir[i]/dirLen*radius*3.5
+	}
+	camera := fmt.Sprintf("%g,%g,%g,%g,%g,%g", eye[0], eye[1], eye[2], centre[0], centre[1], centre[2])
+
+	if pair.A, err = renderSTL(stlA, camera); err != nil {
+		return
+	}
+	if pair.B, err = renderSTL(stlB, camera); err != nil {
+		return
+	}
+	err = CacheData(cacheKey, pair, renderCompareCacheTime)
+	if err != nil {
+		Log.Warnf("Error when caching render comparison: %v", err)
+	}
+	return pair.A, pair.B, nil
+}
+
+// Copies a version of a model from Minio and converts it to a binary STL.  Returns the name of the STL file, which
+// the caller needs to remove.
+func renderModelSTL(bucket string, id string, fileName string) (string, error) {
+	inFile, err := printModelFile(bucket, id, fileName)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(inFile)
+	return convertForPrint(inFile, "stl", 1)
+}
+
+// Renders an STL file to a PNG with OpenSCAD, from the given camera position.  The camera is in OpenSCAD's
+// "eye x,y,z,centre x,y,z" form.
+func renderSTL(stlFile string, camera string) ([]byte, error) {
+	dir, err := ioutil.TempDir(Conf().DiskCache.Directory, "3dhub-render-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	srcFile := filepath.Join(dir, "render.scad")
+	err = ioutil.WriteFile(srcFile, []byte(fmt.Sprintf("import(%q);\n", filepath.ToSlash(stlFile))), 0600)
+	if err != nil {
+		return nil, err
+	}
+	pngFile := filepath.Join(dir, "render.png")
+	ctx, cancel := context.WithTimeout(context.Background(), renderCompareTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "openscad", "-o", pngFile,
+		fmt.Sprintf("--imgsize=%d,%d", renderCompareWidth, renderCompareHeight), "--camera="+camera,
+		"--projection=p", "--colorscheme=Tomorrow", srcFile).CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, errors.New("OpenSCAD took too long to render the model")
+	}
+	if err != nil {
+		Log.Warnf("Rendering a model for comparison failed: %v: %s", err, out)
+		return nil, fmt.Errorf("OpenSCAD couldn't render the model: %s", strings.TrimSpace(string(out)))
+	}
+	return ioutil.ReadFile(pngFile)
+}
+
+// Returns the bounding box of the vertices in a binary STL file.
+func stlBounds(fileName string) (lo [3]float64, hi [3]float64, err error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return
+	}
+	if len(data) < 84 {
+		err = errors.New("The converted model is too short to be a binary STL file")
+		return
+	}
+	numTris := int(binary.LittleEndian.Uint32(data[80:84]))
+	if len(data) < 84+numTris*50 {
+		err = errors.New("The converted model is shorter than its triangle count says")
+		return
+	}
+	for i := range lo {
+		lo[i], hi[i] = math.Inf(1), math.Inf(-1)
+	}
+	for i := 0; i < numTris; i++ {
+		tri := data[84+i*50:]
+		for off := 12; off < 48; off += 4 {
+			v := float64(math.Float32frombits(binary.LittleEndian.Uint32(tri[off:])))
+			axis := (off - 12) / 4 % 3
+			lo[axis], hi[axis] = math.Min(lo[axis], v), math.Max(hi[axis], v)
+		}
+	}
+	if numTris == 0 {
+		lo, hi = [3]float64{}, [3]float64{}
+	}
+	return
+}
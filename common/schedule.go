@@ -0,0 +1,114 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Owners can upload a new project (or new version of a private one) to be made public at a later time, and can create
+// releases to be published later.  Until then the project stays private, and the release is kept out of the release
+// list.  The schedule loop hands each one to a background job once its time comes, which publishes it and tells the
+// people watching the project and the global webhooks, the same as if it had just happened.
+
+// How often the webui server checks for scheduled publications which are due
+const publishCheckInterval = time.Minute
+
+// Checks for scheduled publications whose time has come, and queues the jobs to publish them.  Only one of the webui
+// servers does the checking.
+func PublishLoop() {
+	for {
+		if HoldJobLock("scheduled-publish", publishCheckInterval) {
+			list, err := DuePublications()
+			if err == nil {
+				for _, p := range list {
+					_, err = QueueJob("scheduled_publish", p)
+					if err != nil {
+						Log.Errorf("Error when queuing the scheduled publication of '%s' for '%s%s%s': %v", p.Release,
+							p.Owner, p.Folder, p.FileName, err)
+					}
+				}
+			}
+		}
+		time.Sleep(publishCheckInterval)
+	}
+}
+
+// Publishes a project or release whose scheduled time has come.  The payload holds the ScheduledPublication.
+func PublishJob(payload json.RawMessage) error {
+	var p ScheduledPublication
+	err := json.Unmarshal(payload, &p)
+	if err != nil {
+		return err
+	}
+	projectURL := fmt.Sprintf("https://%s%s", Conf().Web.ServerName, ProjectPath(p.Owner, p.Folder, p.FileName))
+
+	// Make the project public
+	if p.Release == "" {
+		published, err := PublishProject(p.Owner, p.Folder, p.FileName)
+		if err != nil || !published {
+			return err
+		}
+		err = InvalidateCacheEntry(p.Owner, p.Owner, p.Folder, p.FileName, "") // Empty string indicates "for all versions"
+		if err != nil {
+			Log.Errorf("Error when invalidating memcache entries: %s", err.Error())
+		}
+		err = UpdateSearchIndex(p.Owner, p.Folder, p.FileName)
+		if err != nil {
+			Log.Errorf("Error when updating the search index: %s", err.Error())
+		}
+		Log.Infof("Project '%s%s%s' made public, as scheduled", p.Owner, p.Folder, p.FileName)
+		FireAdminWebhooks(WebhookScheduled, fmt.Sprintf("%s%s%s was made public, as scheduled", p.Owner, p.Folder,
+			p.FileName), map[string]string{"project": p.Owner + p.Folder + p.FileName, "url": projectURL,
+			"user": p.Owner})
+		return nil
+	}
+
+	// Add the release to the release list.  If the owner has since created a release of the same name, theirs wins
+	rels, err := GetReleases(p.Owner, p.Folder, p.FileName)
+	if err != nil {
+		return err
+	}
+	if _, ok := rels[p.Release]; ok {
+		Log.Warnf("Scheduled release '%s' of '%s%s%s' not published, as a release of that name already exists",
+			p.Release, p.Owner, p.Folder, p.FileName)
+		return nil
+	}
+	p.ReleaseInfo.Date = p.PublishAt
+	rels[p.Release] = p.ReleaseInfo
+	err = StoreReleases(p.Owner, p.Folder, p.FileName, rels)
+	if err != nil {
+		return err
+	}
+	err = InvalidateCacheEntry(p.Owner, p.Owner, p.Folder, p.FileName, "")
+	if err != nil {
+		Log.Errorf("Error when invalidating memcache entries: %s", err.Error())
+	}
+
+	// Tag the release on GitHub, if the project is mirrored there
+	err = QueueGitHubMirror(p.Owner, p.Folder, p.FileName)
+	if err != nil {
+		Log.Errorf("Error when queuing the GitHub mirror push for '%s%s%s': %v", p.Owner, p.Folder, p.FileName, err)
+	}
+
+	// Let the people watching the project know about the new release
+	err = NewEvent(EventDetails{
+		DBName:   p.FileName,
+		Folder:   p.Folder,
+		Owner:    p.Owner,
+		Title:    p.Release,
+		Type:     EVENT_NEW_RELEASE,
+		URL:      "/releases" + ProjectPath(p.Owner, p.Folder, p.FileName),
+		UserName: p.Owner,
+	})
+	if err != nil {
+		Log.Errorf("Error when creating a new event: %s", err.Error())
+	}
+	Log.Infof("Release '%s' of '%s%s%s' published, as scheduled", p.Release, p.Owner, p.Folder, p.FileName)
+	if pub, err := ProjectPublic(p.Owner, p.Folder, p.FileName); err == nil && pub {
+		FireAdminWebhooks(WebhookScheduled, fmt.Sprintf("Release %s of %s%s%s was published, as scheduled",
+			p.Release, p.Owner, p.Folder, p.FileName), map[string]string{"project": p.Owner + p.Folder + p.FileName,
+			"release": p.Release, "url": projectURL, "user": p.Owner})
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package common
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// The interface implemented by each of the search engine backends
+type SearchBackend interface {
+	// Drops (if present) and recreates the search index, ready for it to be repopulated
+	CreateIndex() error
+
+	// Adds a project to the search index, replacing any existing entry for it
+	IndexProject(doc SearchDocument) error
+
+	// Removes a project from the search index
+	RemoveProject(owner string, folder string, fileName string) error
+
+	// Returns a page of the projects matching the search filters, along with the total number of matches
+	Search(loggedInUser string, filters SearchFilters, offset int) ([]SearchResult, int, error)
+}
+
+var (
+	// The search engine in use
+	searchEngine SearchBackend = pgSearch{}
+
+	// Used for talking to the external search engines
+	searchClient = &http.Client{Timeout: 30 * time.Second}
+)
+
+// The default search backend, using the full text search in PostgreSQL.  The search index is kept up to date by
+// PostgreSQL itself, so there's nothing to do when projects change.
+type pgSearch struct{}
+
+func (pgSearch) CreateIndex() error {
+	return nil
+}
+
+func (pgSearch) IndexProject(doc SearchDocument) error {
+	return nil
+}
+
+func (pgSearch) RemoveProject(owner string, folder string, fileName string) error {
+	return nil
+}
+
+func (pgSearch) Search(loggedInUser string, filters SearchFilters, offset int) ([]SearchResult, int, error) {
+	return SearchProjects(loggedInUser, filters, offset)
+}
+
+// Sets up the search backend chosen in the configuration file
+func ConnectSearch() error {
+	index := Conf().Search.Index
+	if index == "" {
+		index = "3dhub"
+	}
+	backend := strings.ToLower(Conf().Search.Backend)
+	switch backend {
+	case "", "postgresql":
+		searchEngine = pgSearch{}
+		Log.Infof("Using PostgreSQL for search")
+		return nil
+	case "bleve":
+		searchEngine = bleveSearch{index: index, server: strings.TrimSuffix(Conf().Search.Server, "/")}
+	case "elasticsearch":
+		searchEngine = elasticSearch{index: index, server: strings.TrimSuffix(Conf().Search.Server, "/")}
+	default:
+		return fmt.Errorf("Unknown search backend: '%s'", Conf().Search.Backend)
+	}
+	if Conf().Search.Server == "" {
+		return fmt.Errorf("No server given for the %s search backend", backend)
+	}
+	Log.Infof("Using %s for search: %s, index '%s'", backend, Conf().Search.Server, index)
+	return nil
+}
+
+// Joins the highlighted fragments returned by a search engine, in the same way PostgreSQL's ts_headline() does
+func joinFragments(fragments []string) string {
+	return strings.Join(fragments, " ... ")
+}
+
+// Repopulates the search index from scratch, using the project details in PostgreSQL.  Returns the number of projects
+// added to the index.
+func RebuildSearchIndex() (int, error) {
+	err := searchEngine.CreateIndex()
+	if err != nil {
+		return 0, err
+	}
+	docs, err := SearchDocuments("", "", "")
+	if err != nil {
+		return 0, err
+	}
+	for i, doc := range docs {
+		err = searchEngine.IndexProject(doc)
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(docs), nil
+}
+
+// Returns a page of the projects matching the search filters, using the configured search backend
+func Search(loggedInUser string, filters SearchFilters, offset int) ([]SearchResult, int, error) {
+	return searchEngine.Search(loggedInUser, filters, offset)
+}
+
+// Returns the ID used for a project in the external search indexes.  This is hashed, so the ID is always safe to use
+// in a URL path no matter which characters the project name contains.
+func searchDocID(owner string, folder string, fileName string) string {
+	h := sha256.Sum256([]byte(strings.ToLower(owner) + folder + fileName))
+	return hex.EncodeToString(h[:])
+}
+
+// Sends a request to an external search engine, decoding the JSON response into result (if not nil).  A "not found"
+// response isn't treated as an error, as that's expected when removing things which aren't in the index.
+func searchRequest(method string, url string, body interface{}, result interface{}) error {
+	var reqBody bytes.Buffer
+	if body != nil {
+		err := json.NewEncoder(&reqBody).Encode(body)
+		if err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, url, &reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := searchClient.Do(req)
+	if err != nil {
+		Log.Errorf("Search engine request failed: %v", err)
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	if resp.StatusCode >= 300 {
+		Log.Errorf("Search engine returned status %d for %s %s: %s", resp.StatusCode, method, url, respBody)
+		return fmt.Errorf("Search engine returned status %d", resp.StatusCode)
+	}
+	if result != nil {
+		err = json.Unmarshal(respBody, result)
+	}
+	return err
+}
+
+// Updates the search index entry for a project, removing it from the index if the project no longer exists.  This
+// needs calling whenever a project is added, changed, or removed.
+func UpdateSearchIndex(owner string, folder string, fileName string) error {
+	// Nothing to do when using PostgreSQL for search, so skip looking up the project
+	if _, ok := searchEngine.(pgSearch); ok {
+		return nil
+	}
+	docs, err := SearchDocuments(owner, folder, fileName)
+	if err != nil {
+		return err
+	}
+	if len(docs) == 0 {
+		return searchEngine.RemoveProject(owner, folder, fileName)
+	}
+	return searchEngine.IndexProject(docs[0])
+}
@@ -0,0 +1,150 @@
+package common
+
+import (
+	"encoding/base32"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/jackc/pgx"
+)
+
+// How often expired web sessions are removed
+const webSessionCleanupInterval = time.Hour
+
+// Keeps the web sessions in PostgreSQL.  This is used when Memcached is optional, so people stay logged in while it's
+// down.  The cookie only holds the (signed) session ID, the same as the Memcached session store.
+type PGSessionStore struct {
+	Codecs  []securecookie.Codec
+	Options *sessions.Options
+}
+
+// Returns a session store which keeps the sessions in PostgreSQL.
+func NewPGSessionStore(keyPairs ...[]byte) *PGSessionStore {
+	return &PGSessionStore{
+		Codecs: securecookie.CodecsFromPairs(keyPairs...),
+		Options: &sessions.Options{
+			Path:   "/",
+			MaxAge: 86400 * 30,
+		},
+	}
+}
+
+// Removes the sessions which have expired.
+func PruneWebSessions() {
+	dbQuery := `
+		DELETE FROM web_sessions
+		WHERE expiry < now()`
+	commandTag, err := pdb.Exec(dbQuery)
+	if err != nil {
+		Log.Errorf("Removing expired web sessions failed: %v", err)
+		return
+	}
+	if commandTag.RowsAffected() > 0 {
+		Log.Infof("Removed %d expired web sessions", commandTag.RowsAffected())
+	}
+}
+
+// Returns a session for the given name after adding it to the registry.  Part of sessions.Store.
+func (s *PGSessionStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// Returns a session for the given name without adding it to the registry.  Part of sessions.Store.
+func (s *PGSessionStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	opts := *s.Options
+	session.Options = &opts
+	session.IsNew = true
+	c, err := r.Cookie(name)
+	if err != nil {
+		// No session cookie, so this is a new session
+		return session, nil
+	}
+	err = securecookie.DecodeMulti(name, c.Value, &session.ID, s.Codecs...)
+	if err != nil {
+		return session, err
+	}
+
+	// Load the session data, if it's still around
+	dbQuery := `
+		SELECT session_data
+		FROM web_sessions
+		WHERE session_id = $1
+			AND expiry > now()`
+	var data string
+	err = pdb.QueryRow(dbQuery, session.ID).Scan(&data)
+	if err == pgx.ErrNoRows {
+		return session, nil
+	}
+	if err != nil {
+		Log.Errorf("Error when retrieving web session: %v", err)
+		return session, err
+	}
+	err = securecookie.DecodeMulti(name, data, &session.Values, s.Codecs...)
+	if err != nil {
+		return session, err
+	}
+	session.IsNew = false
+	return session, nil
+}
+
+// Saves a session, and adds its cookie to the response.  Sessions with a negative MaxAge are removed.  Part of
+// sessions.Store.
+func (s *PGSessionStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge < 0 {
+		if session.ID != "" {
+			dbQuery := `
+				DELETE FROM web_sessions
+				WHERE session_id = $1`
+			_, err := pdb.Exec(dbQuery, session.ID)
+			if err != nil {
+				Log.Errorf("Error when removing web session: %v", err)
+				return err
+			}
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	if session.ID == "" {
+		session.ID = strings.TrimRight(base32.StdEncoding.EncodeToString(securecookie.GenerateRandomKey(32)), "=")
+	}
+	data, err := securecookie.EncodeMulti(session.Name(), session.Values, s.Codecs...)
+	if err != nil {
+		return err
+	}
+	maxAge := session.Options.MaxAge
+	if maxAge == 0 {
+		// The cookie lasts until the browser is closed, which could be any time
+		maxAge = s.Options.MaxAge
+	}
+	dbQuery := `
+		INSERT INTO web_sessions (session_id, session_data, expiry)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (session_id)
+			DO UPDATE SET session_data = excluded.session_data, expiry = excluded.expiry`
+	_, err = pdb.Exec(dbQuery, session.ID, data, time.Now().Add(time.Duration(maxAge)*time.Second))
+	if err != nil {
+		Log.Errorf("Error when saving web session: %v", err)
+		return err
+	}
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.Codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+// Removes the expired sessions every so often.  Only one of the webui servers does the cleaning up.
+func WebSessionCleanupLoop() {
+	for {
+		if HoldJobLock("web-session-cleanup", webSessionCleanupInterval) {
+			PruneWebSessions()
+		}
+		time.Sleep(webSessionCleanupInterval)
+	}
+}
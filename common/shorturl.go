@@ -0,0 +1,91 @@
+package common
+
+import (
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"strings"
+)
+
+// Projects (and specific versions of them) can be given short URLs like /s/abc123, for sharing in places where space
+// is tight.  They point at the project itself rather than its name, so they keep working if it's renamed or moved to
+// another user.  How often each has been followed is counted, and shown on the project's page.
+
+// The characters short URL codes are made from.  Ones which are easily mixed up (0/O, 1/l/I) are left out
+const shortURLChars = "23456789abcdefghijkmnopqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ"
+
+// The length of new short URL codes, and how many times a code is tried before moving to longer ones
+const (
+	shortURLAttempts = 5
+	shortURLLength   = 6
+	shortURLMaxLen   = 10
+)
+
+// Returns the short URL for a project, or a specific version of it when a commit ID is given, creating it if it doesn't
+// have one yet.  Codes are random, so if one is already taken another is tried, with longer codes used if that keeps
+// happening.
+func CreateShortURL(loggedInUser string, owner string, folder string, fileName string,
+	commitID string) (s ShortURLEntry, err error) {
+	for length := shortURLLength; length <= shortURLMaxLen; length++ {
+		for i := 0; i < shortURLAttempts; i++ {
+			// Someone else may have just created one for the same project version
+			var found bool
+			s, found, err = ProjectShortURL(owner, folder, fileName, commitID)
+			if err != nil || found {
+				s.URL = ShortURLLink(s.Code)
+				return
+			}
+
+			var code string
+			code, err = shortURLCode(length)
+			if err != nil {
+				return
+			}
+			var added bool
+			added, err = AddShortURL(code, owner, folder, fileName, commitID, loggedInUser)
+			if err != nil {
+				return
+			}
+			if added {
+				Log.Infof("User '%s' added short URL '%s' for '%s%s%s'", loggedInUser, code, owner, folder,
+					fileName)
+				s, _, err = ProjectShortURL(owner, folder, fileName, commitID)
+				s.URL = ShortURLLink(s.Code)
+				return
+			}
+		}
+	}
+	return s, errors.New("A free short URL couldn't be found.  Please try again")
+}
+
+// Returns a random short URL code of the given length.
+func shortURLCode(length int) (string, error) {
+	max := big.NewInt(int64(len(shortURLChars)))
+	code := make([]byte, length)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = shortURLChars[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// Returns the full link for a short URL code.
+func ShortURLLink(code string) string {
+	return "https://" + Conf().Web.ServerName + "/s/" + code
+}
+
+// Returns whether a string could be a short URL code, so obviously wrong ones aren't looked up.
+func ValidShortURLCode(code string) bool {
+	if len(code) < shortURLLength || len(code) > shortURLMaxLen {
+		return false
+	}
+	for _, c := range code {
+		if !strings.ContainsRune(shortURLChars, c) {
+			return false
+		}
+	}
+	return true
+}
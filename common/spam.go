@@ -0,0 +1,97 @@
+package common
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Used for talking to the external spam checking service
+var spamClient = &http.Client{Timeout: 10 * time.Second}
+
+// Matches the links in a piece of text
+var spamLinkRegex = regexp.MustCompile(`(?i)(https?://|www\.)\S+`)
+
+// Checks whether something written by a user looks like spam, returning the reason if so.  Only users whose accounts
+// are younger than the configured age are checked, as that's where nearly all spam comes from.  The kind of content
+// is passed to the external spam checking service (if one is configured), and should be one of Akismet's comment
+// types (eg "comment", or "forum-post").
+func CheckSpam(userName string, ipAddr string, userAgent string, kind string, content string) (spam bool,
+	reason string, err error) {
+	if !Conf().Spam.Enabled || strings.TrimSpace(content) == "" {
+		return
+	}
+	usr, err := User(userName)
+	if err != nil {
+		return
+	}
+	if Conf().Spam.NewAccountDays > 0 &&
+		time.Since(usr.DateJoined) > time.Duration(Conf().Spam.NewAccountDays)*24*time.Hour {
+		return
+	}
+
+	// Check for the easy to spot things first
+	lower := strings.ToLower(content)
+	for _, word := range Conf().Spam.BlockedWords {
+		if word != "" && strings.Contains(lower, strings.ToLower(word)) {
+			return true, fmt.Sprintf("Contains the blocked word '%s'", word), nil
+		}
+	}
+	maxLinks := Conf().Spam.MaxLinks
+	if links := len(spamLinkRegex.FindAllString(content, -1)); maxLinks > 0 && links > maxLinks {
+		return true, fmt.Sprintf("Contains %d links", links), nil
+	}
+	var letters, upper int
+	for _, r := range content {
+		if unicode.IsLetter(r) {
+			letters++
+			if unicode.IsUpper(r) {
+				upper++
+			}
+		}
+	}
+	if letters >= 20 && upper*10 > letters*7 {
+		return true, "Mostly written in capitals", nil
+	}
+
+	// Then ask the external service, if there is one
+	if Conf().Spam.AkismetKey == "" {
+		return
+	}
+	form := url.Values{
+		"api_key":              {Conf().Spam.AkismetKey},
+		"blog":                 {"https://" + Conf().Web.ServerName},
+		"comment_author":       {usr.Username},
+		"comment_author_email": {usr.Email},
+		"comment_content":      {content},
+		"comment_type":         {kind},
+		"user_agent":           {userAgent},
+		"user_ip":              {ipAddr},
+	}
+	resp, err := spamClient.PostForm(Conf().Spam.AkismetURL, form)
+	if err != nil {
+		Log.Warnf("Spam check for content from '%s' failed: %v", userName, err)
+		return false, "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return false, "", err
+	}
+	switch strings.TrimSpace(string(body)) {
+	case "true":
+		return true, "Flagged by the spam checking service", nil
+	case "false":
+		return false, "", nil
+	default:
+		err = fmt.Errorf("Unexpected response from the spam checking service: %s %s", resp.Status,
+			resp.Header.Get("X-akismet-debug-help"))
+		Log.Warnf("Spam check for content from '%s' failed: %v", userName, err)
+		return false, "", err
+	}
+}
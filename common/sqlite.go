@@ -2,23 +2,81 @@ package common
 
 import (
 	"encoding/base64"
+	"encoding/csv"
 	"errors"
 	"fmt"
-	"log"
+	"math"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 
 	sqlite "github.com/gwenn/gosqlite"
 )
 
+// The aggregate functions which can be used when grouping table data
+var AggregateFunctions = map[string]bool{"avg": true, "count": true, "max": true, "min": true, "sum": true}
+
+// The transforms which can be applied to a column when grouping table data, as SQLite expressions.  Text timestamps
+// can be turned into dates (or their month or year), and text holding numbers can be read as numbers.  Numbers can
+// also be put into buckets, using "bucket:<size>".
+var columnTransforms = map[string]string{
+	"date":   `date(%s)`,
+	"month":  `strftime('%%Y-%%m', %s)`,
+	"number": `CAST(%s AS REAL)`,
+	"year":   `strftime('%%Y', %s)`,
+}
+
+// Returns the name given to the aggregate column of grouped table data, eg "sum(price)".  Counts of the rows in each
+// group are just called "count".
+func AggregateColumnName(aggFunc string, aggCol string) string {
+	if aggCol == "" {
+		return aggFunc
+	}
+	return aggFunc + "(" + aggCol + ")"
+}
+
+// Returns the bucket size from a "bucket:<size>" column transform, formatted for use in a query.
+func bucketSize(transform string) (string, error) {
+	if !strings.HasPrefix(transform, "bucket:") {
+		return "", errors.New("Unknown column transform")
+	}
+	size, err := strconv.ParseFloat(strings.TrimPrefix(transform, "bucket:"), 64)
+	if err != nil || !(size > 0) || math.IsInf(size, 0) {
+		return "", errors.New("The bucket size needs to be a positive number")
+	}
+	return strconv.FormatFloat(size, 'g', -1, 64), nil
+}
+
+// Returns the SQLite expression for a column with a transform applied to it.  An empty transform leaves the column as
+// it is.
+func columnTransformExpr(col string, transform string) (string, error) {
+	colExpr := sqlite.Mprintf(`"%w"`, col)
+	if transform == "" {
+		return colExpr, nil
+	}
+	if f, ok := columnTransforms[transform]; ok {
+		return fmt.Sprintf(f, colExpr), nil
+	}
+	size, err := bucketSize(transform)
+	if err != nil {
+		return "", err
+	}
+
+	// Each value is rounded down to the start of its bucket.  SQLite (before 3.35) doesn't have floor(), so it's
+	// worked out from the truncated value, adjusting negative values which weren't already on a bucket boundary
+	num := fmt.Sprintf(`(CAST(%s AS REAL) / %s)`, colExpr, size)
+	return fmt.Sprintf(`((CAST(%[1]s AS INTEGER) - (%[1]s < 0 AND %[1]s <> CAST(%[1]s AS INTEGER))) * %[2]s)`, num,
+		size), nil
+}
+
 // Returns the number of rows in a SQLite table.
 func GetSQLiteRowCount(sdb *sqlite.Conn, dbTable string) (int, error) {
 	dbQuery := `SELECT count(*) FROM "` + dbTable + `"`
 	var rowCount int
 	err := sdb.OneValue(dbQuery, &rowCount)
 	if err != nil {
-		log.Printf("Error occurred when counting total rows for table '%s'.  Error: %s\n", dbTable, err)
+		Log.Errorf("Error occurred when counting total rows for table '%s'.  Error: %s", dbTable, err)
 		return 0, errors.New("Database query failure")
 	}
 	return rowCount, nil
@@ -29,6 +87,80 @@ func ReadSQLiteDB(sdb *sqlite.Conn, dbTable string, maxRows int, sortCol string,
 	return ReadSQLiteDBCols(sdb, dbTable, false, false, maxRows, sortCol, sortDir, rowOffset)
 }
 
+// Reads table data grouped by a column, with an aggregate function (eg count or sum) worked out for each group.  This
+// lets SQLite summarise the rows, rather than them all needing to be sent to the browser first.  The aggregate column
+// can be left empty for count, to count the rows in each group.  Either column can have a transform applied first
+// (see ValidateColumnTransform()), so timestamps stored as text can be grouped by day, month, or year.  The results are
+// sorted by the group column, unless sortCol is the name of the aggregate column in the results.
+func ReadSQLiteDBAggregate(sdb *sqlite.Conn, dbTable string, groupCol string, groupTransform string, aggFunc string,
+	aggCol string, aggTransform string, maxRows int, sortCol string, sortDir string, rowOffset int) (SQLiteRecordSet,
+	error) {
+	var dataRows SQLiteRecordSet
+	if _, ok := AggregateFunctions[aggFunc]; !ok {
+		return dataRows, fmt.Errorf("Unknown aggregate function '%s'", aggFunc)
+	}
+	if aggCol == "" && aggFunc != "count" {
+		return dataRows, fmt.Errorf("The %s aggregate function needs a column", aggFunc)
+	}
+	aggExpr := "*"
+	if aggCol != "" {
+		var err error
+		aggExpr, err = columnTransformExpr(aggCol, aggTransform)
+		if err != nil {
+			return dataRows, err
+		}
+	}
+	groupExpr, err := columnTransformExpr(groupCol, groupTransform)
+	if err != nil {
+		return dataRows, err
+	}
+	from := sqlite.Mprintf(` FROM "%w"`, dbTable) + ` GROUP BY 1`
+	dbQuery := `SELECT ` + groupExpr + sqlite.Mprintf(` AS "%w", `, groupCol) + aggFunc + `(` + aggExpr + `) AS "` +
+		strings.Replace(AggregateColumnName(aggFunc, aggCol), `"`, `""`, -1) + `"` + from
+
+	// Sort by either the group or the aggregate value
+	if sortCol != "" && sortCol == AggregateColumnName(aggFunc, aggCol) {
+		dbQuery += " ORDER BY 2"
+	} else {
+		dbQuery += " ORDER BY 1"
+	}
+	if sortDir == "DESC" {
+		dbQuery += " DESC"
+	}
+	if maxRows >= 0 {
+		dbQuery = fmt.Sprintf("%s LIMIT %d", dbQuery, maxRows)
+	}
+	if rowOffset >= 0 {
+		dbQuery = fmt.Sprintf("%s OFFSET %d", dbQuery, rowOffset)
+	}
+
+	stmt, err := sdb.Prepare(dbQuery)
+	if err != nil {
+		Log.Errorf("Error when preparing statement for database: %s", err)
+		return dataRows, errors.New("Error when reading data from the SQLite database")
+	}
+	defer stmt.Finalize()
+	dataRows.Tablename = dbTable
+	dataRows.ColNames = stmt.ColumnNames()
+	dataRows.ColCount = len(dataRows.ColNames)
+	err = scanRecordSet(stmt, &dataRows, false, false)
+	if err != nil {
+		return dataRows, err
+	}
+
+	// The total row count is the number of groups
+	err = sdb.OneValue(`SELECT count(*) FROM (SELECT `+groupExpr+from+`)`, &dataRows.TotalRows)
+	if err != nil {
+		Log.Errorf("Error occurred when counting groups for table '%s'.  Error: %s", dbTable, err)
+		return dataRows, errors.New("Database query failure")
+	}
+	dataRows.RowCount = dataRows.TotalRows
+	dataRows.SortCol = sortCol
+	dataRows.SortDir = sortDir
+	dataRows.Offset = rowOffset
+	return dataRows, nil
+}
+
 // Reads up to maxRows # of rows from a SQLite database.  Only returns the requested columns.
 func ReadSQLiteDBCols(sdb *sqlite.Conn, dbTable string, ignoreBinary bool, ignoreNull bool, maxRows int,
 	sortCol string, sortDir string, rowOffset int) (SQLiteRecordSet, error) {
@@ -93,7 +225,7 @@ func ReadSQLiteDBCols(sdb *sqlite.Conn, dbTable string, ignoreBinary bool, ignor
 			dbQuery += sqlite.Mprintf(" (`%s`)", sortCol)
 			err = sdb.Exec(dbQuery)
 			if err != nil {
-				log.Printf("Error occurred when creating index: %s\n", err.Error())
+				Log.Errorf("Error occurred when creating index: %s", err.Error())
 				return SQLiteRecordSet{}, err
 			}
 			sdb.Commit()
@@ -133,7 +265,7 @@ func ReadSQLiteDBCols(sdb *sqlite.Conn, dbTable string, ignoreBinary bool, ignor
 	// Use the sort column as needed
 	stmt, err = sdb.Prepare(dbQuery)
 	if err != nil {
-		log.Printf("Error when preparing statement for database: %s\n", err)
+		Log.Errorf("Error when preparing statement for database: %s", err)
 		return dataRows, errors.New("Error when reading data from the SQLite database")
 	}
 
@@ -142,87 +274,9 @@ func ReadSQLiteDBCols(sdb *sqlite.Conn, dbTable string, ignoreBinary bool, ignor
 	dataRows.ColCount = len(dataRows.ColNames)
 
 	// Process each row
-	fieldCount := -1
-	err = stmt.Select(func(s *sqlite.Stmt) error {
-
-		// Get the number of fields in the result
-		if fieldCount == -1 {
-			fieldCount = stmt.DataCount()
-		}
-
-		// Retrieve the data for each row
-		var row []DataValue
-		addRow := true
-		for i := 0; i < fieldCount; i++ {
-			// Retrieve the data type for the field
-			fieldType := stmt.ColumnType(i)
-
-			isNull := false
-			switch fieldType {
-			case sqlite.Integer:
-				var val int
-				val, isNull, err = s.ScanInt(i)
-				if err != nil {
-					log.Printf("Something went wrong with ScanInt(): %v\n", err)
-					break
-				}
-				if !isNull {
-					stringVal := fmt.Sprintf("%d", val)
-					row = append(row, DataValue{Name: dataRows.ColNames[i], Type: Integer,
-						Value: stringVal})
-				}
-			case sqlite.Float:
-				var val float64
-				val, isNull, err = s.ScanDouble(i)
-				if err != nil {
-					log.Printf("Something went wrong with ScanDouble(): %v\n", err)
-					break
-				}
-				if !isNull {
-					stringVal := strconv.FormatFloat(val, 'f', 4, 64)
-					row = append(row, DataValue{Name: dataRows.ColNames[i], Type: Float,
-						Value: stringVal})
-				}
-			case sqlite.Text:
-				var val string
-				val, isNull = s.ScanText(i)
-				if !isNull {
-					row = append(row, DataValue{Name: dataRows.ColNames[i], Type: Text,
-						Value: val})
-				}
-			case sqlite.Blob:
-				// BLOBs can be ignored (via flag to this function) for situations like the vis data
-				if !ignoreBinary {
-					_, isNull = s.ScanBlob(i)
-					if !isNull {
-						row = append(row, DataValue{Name: dataRows.ColNames[i], Type: Binary,
-							Value: "<i>BINARY DATA</i>"})
-					}
-				} else {
-					addRow = false
-				}
-			case sqlite.Null:
-				isNull = true
-			}
-			if isNull && !ignoreNull {
-				// NULLS can be ignored (via flag to this function) for situations like the vis data
-				row = append(row, DataValue{Name: dataRows.ColNames[i], Type: Null,
-					Value: "<i>NULL</i>"})
-			}
-			if isNull && ignoreNull {
-				addRow = false
-			}
-		}
-		if addRow == true {
-			dataRows.Records = append(dataRows.Records, row)
-			dataRows.RowCount++
-		}
-
-		return nil
-	})
+	err = scanRecordSet(stmt, &dataRows, ignoreBinary, ignoreNull)
 	if err != nil {
-		log.Printf("Error when retrieving select data from database: %s\n", err)
-		return dataRows, errors.New("Error when reading data from the SQLite database")
+		return dataRows, err
 	}
 	defer stmt.Finalize()
 
@@ -241,19 +295,18 @@ func ReadSQLiteDBCols(sdb *sqlite.Conn, dbTable string, ignoreBinary bool, ignor
 	return dataRows, nil
 }
 
-// This is a specialised variation of the ReadSQLiteDB() function, just for our CSV exporting code. It'll probably
+// This is a specialised variation of the ReadSQLiteDB() function, just for our Redash JSON exporting code. It'll probably
 // need to be merged with the above function at some point.
-func ReadSQLiteDBCSV(sdb *sqlite.Conn, dbTable string) ([][]string, error) {
+func ReadSQLiteDBRedash(sdb *sqlite.Conn, dbTable string) (dash RedashTableData, err error) {
 	// Retrieve all of the data from the selected database table
 	stmt, err := sdb.Prepare(`SELECT * FROM "` + dbTable + `"`)
 	if err != nil {
-		log.Printf("Error when preparing statement for database: %s\n", err)
-		return nil, err
+		Log.Errorf("Error when preparing statement for database: %s", err)
+		return RedashTableData{}, err
 	}
 
 	// Process each row
 	fieldCount := -1
-	var resultSet [][]string
 	err = stmt.Select(func(s *sqlite.Stmt) error {
 
 		// Get the number of fields in the result
@@ -261,9 +314,37 @@ func ReadSQLiteDBCSV(sdb *sqlite.Conn, dbTable string) ([][]string, error) {
 			fieldCount = stmt.DataCount()
 		}
 
+		// Retrieve the (table level) type declaration for the result fields
+		if dash.Columns == nil {
+			for i := 0; i < fieldCount; i++ {
+				var c RedashColumnMeta
+				c.Name = stmt.ColumnName(i)
+				c.FriendlyName = stmt.ColumnName(i)
+
+				// Map common SQLite data types to Redash JSON acceptable equivalent
+				t := strings.ToLower(stmt.ColumnDeclaredType(i))
+				switch t {
+				case "numeric":
+					c.Type = "float"
+				case "real":
+					c.Type = "float"
+				case "text":
+					c.Type = "string"
+				default:
+					c.Type = t
+				}
+
+				dash.Columns = append(dash.Columns, c)
+			}
+		}
+
 		// Retrieve the data for each row
-		var row []string
+		row := make(map[string]interface{})
 		for i := 0; i < fieldCount; i++ {
+
+			// Retrieve the name of the field
+			fieldName := stmt.ColumnName(i)
+
 			// Retrieve the data type for the field
 			fieldType := stmt.ColumnType(i)
 
@@ -273,67 +354,149 @@ func ReadSQLiteDBCSV(sdb *sqlite.Conn, dbTable string) ([][]string, error) {
 				var val int
 				val, isNull, err = s.ScanInt(i)
 				if err != nil {
-					log.Printf("Something went wrong with ScanInt(): %v\n", err)
+					Log.Errorf("Something went wrong with ScanInt(): %v", err)
 					break
 				}
 				if !isNull {
-					row = append(row, fmt.Sprintf("%d", val))
+					row[fieldName] = val
 				}
 			case sqlite.Float:
 				var val float64
 				val, isNull, err = s.ScanDouble(i)
 				if err != nil {
-					log.Printf("Something went wrong with ScanDouble(): %v\n", err)
+					Log.Errorf("Something went wrong with ScanDouble(): %v", err)
 					break
 				}
 				if !isNull {
-					row = append(row, strconv.FormatFloat(val, 'f', 4, 64))
+					row[fieldName] = val
 				}
 			case sqlite.Text:
 				var val string
 				val, isNull = s.ScanText(i)
 				if !isNull {
-					row = append(row, val)
+					row[fieldName] = val
 				}
 			case sqlite.Blob:
 				var val []byte
 				val, isNull = s.ScanBlob(i)
 				if !isNull {
 					// Base64 encode the value
-					row = append(row, base64.StdEncoding.EncodeToString(val))
+					row[fieldName] = base64.StdEncoding.EncodeToString(val)
 				}
 			case sqlite.Null:
 				isNull = true
 			}
-			if isNull {
-				row = append(row, "NULL")
-			}
 		}
-		resultSet = append(resultSet, row)
-
+		dash.Rows = append(dash.Rows, row)
 		return nil
 	})
 	if err != nil {
-		log.Printf("Error when reading data from database: %s\n", err)
-		return nil, err
+		Log.Errorf("Error when reading data from database: %s", err)
+		return RedashTableData{}, err
 	}
 	defer stmt.Finalize()
 
 	// Return the results
-	return resultSet, nil
+	return dash, nil
 }
 
-// This is a specialised variation of the ReadSQLiteDB() function, just for our Redash JSON exporting code. It'll probably
-// need to be merged with the above function at some point.
-func ReadSQLiteDBRedash(sdb *sqlite.Conn, dbTable string) (dash RedashTableData, err error) {
-	// Retrieve all of the data from the selected database table
-	stmt, err := sdb.Prepare(`SELECT * FROM "` + dbTable + `"`)
+// Returns the structure of the tables and views in a SQLite database: their columns, indexes, and foreign keys.
+func ReadSQLiteDBSchema(sdb *sqlite.Conn) (schema []SchemaTable, err error) {
+	schema = []SchemaTable{}
+	tables, err := sdb.Tables("")
 	if err != nil {
-		log.Printf("Error when preparing statement for database: %s\n", err)
-		return RedashTableData{}, err
+		Log.Errorf("Error retrieving table names: %v", err)
+		return nil, err
+	}
+	views, err := sdb.Views("")
+	if err != nil {
+		Log.Errorf("Error retrieving view names: %v", err)
+		return nil, err
 	}
+	sort.Strings(tables)
+	sort.Strings(views)
+
+	add := func(name, objType string) error {
+		t := SchemaTable{
+			Columns:     []SchemaColumn{},
+			ForeignKeys: []SchemaForeignKey{},
+			Indexes:     []SchemaIndex{},
+			Name:        name,
+			Type:        objType,
+		}
+		cols, err := sdb.Columns("", name)
+		if err != nil {
+			return err
+		}
+		for _, c := range cols {
+			t.Columns = append(t.Columns, SchemaColumn{
+				DataType:   c.DataType,
+				Default:    c.DfltValue,
+				Name:       c.Name,
+				NotNull:    c.NotNull,
+				PrimaryKey: c.Pk,
+			})
+		}
 
-	// Process each row
+		// Views don't have indexes or foreign keys
+		if objType == "view" {
+			schema = append(schema, t)
+			return nil
+		}
+		idxList, err := sdb.TableIndexes("", name)
+		if err != nil {
+			return err
+		}
+		for _, i := range idxList {
+			idxCols, err := sdb.IndexColumns("", i.Name)
+			if err != nil {
+				return err
+			}
+			idx := SchemaIndex{Columns: []string{}, Name: i.Name, Unique: i.Unique}
+			for _, c := range idxCols {
+				idx.Columns = append(idx.Columns, c.Name)
+			}
+			t.Indexes = append(t.Indexes, idx)
+		}
+		fks, err := sdb.ForeignKeys("", name)
+		if err != nil {
+			return err
+		}
+		var ids []int
+		for id := range fks {
+			ids = append(ids, id)
+		}
+		sort.Ints(ids)
+		for _, id := range ids {
+			fk := fks[id]
+			t.ForeignKeys = append(t.ForeignKeys, SchemaForeignKey{
+				Columns:    fk.From,
+				RefColumns: fk.To,
+				RefTable:   fk.Table,
+			})
+		}
+		schema = append(schema, t)
+		return nil
+	}
+	for _, name := range tables {
+		if err = add(name, "table"); err != nil {
+			Log.Errorf("Error retrieving the structure of table '%s': %v", name, err)
+			return nil, err
+		}
+	}
+	for _, name := range views {
+		if err = add(name, "view"); err != nil {
+			Log.Errorf("Error retrieving the structure of view '%s': %v", name, err)
+			return nil, err
+		}
+	}
+	return schema, nil
+}
+
+// Reads the rows returned by a statement into a record set.  BLOBs and NULLs can be skipped, for places that can't
+// make use of them.
+func scanRecordSet(stmt *sqlite.Stmt, rs *SQLiteRecordSet, ignoreBinary bool, ignoreNull bool) error {
+	var err error
 	fieldCount := -1
 	err = stmt.Select(func(s *sqlite.Stmt) error {
 
@@ -342,37 +505,10 @@ func ReadSQLiteDBRedash(sdb *sqlite.Conn, dbTable string) (dash RedashTableData,
 			fieldCount = stmt.DataCount()
 		}
 
-		// Retrieve the (table level) type declaration for the result fields
-		if dash.Columns == nil {
-			for i := 0; i < fieldCount; i++ {
-				var c RedashColumnMeta
-				c.Name = stmt.ColumnName(i)
-				c.FriendlyName = stmt.ColumnName(i)
-
-				// Map common SQLite data types to Redash JSON acceptable equivalent
-				t := strings.ToLower(stmt.ColumnDeclaredType(i))
-				switch t {
-				case "numeric":
-					c.Type = "float"
-				case "real":
-					c.Type = "float"
-				case "text":
-					c.Type = "string"
-				default:
-					c.Type = t
-				}
-
-				dash.Columns = append(dash.Columns, c)
-			}
-		}
-
 		// Retrieve the data for each row
-		row := make(map[string]interface{})
+		var row []DataValue
+		addRow := true
 		for i := 0; i < fieldCount; i++ {
-
-			// Retrieve the name of the field
-			fieldName := stmt.ColumnName(i)
-
 			// Retrieve the data type for the field
 			fieldType := stmt.ColumnType(i)
 
@@ -382,50 +518,68 @@ func ReadSQLiteDBRedash(sdb *sqlite.Conn, dbTable string) (dash RedashTableData,
 				var val int
 				val, isNull, err = s.ScanInt(i)
 				if err != nil {
-					log.Printf("Something went wrong with ScanInt(): %v\n", err)
+					Log.Errorf("Something went wrong with ScanInt(): %v", err)
 					break
 				}
 				if !isNull {
-					row[fieldName] = val
+					stringVal := fmt.Sprintf("%d", val)
+					row = append(row, DataValue{Name: rs.ColNames[i], Type: Integer,
+						Value: stringVal})
 				}
 			case sqlite.Float:
 				var val float64
 				val, isNull, err = s.ScanDouble(i)
 				if err != nil {
-					log.Printf("Something went wrong with ScanDouble(): %v\n", err)
+					Log.Errorf("Something went wrong with ScanDouble(): %v", err)
 					break
 				}
 				if !isNull {
-					row[fieldName] = val
+					stringVal := strconv.FormatFloat(val, 'f', 4, 64)
+					row = append(row, DataValue{Name: rs.ColNames[i], Type: Float,
+						Value: stringVal})
 				}
 			case sqlite.Text:
 				var val string
 				val, isNull = s.ScanText(i)
 				if !isNull {
-					row[fieldName] = val
+					row = append(row, DataValue{Name: rs.ColNames[i], Type: Text,
+						Value: val})
 				}
 			case sqlite.Blob:
-				var val []byte
-				val, isNull = s.ScanBlob(i)
-				if !isNull {
-					// Base64 encode the value
-					row[fieldName] = base64.StdEncoding.EncodeToString(val)
+				// BLOBs can be ignored (via flag to this function) for situations like the vis data
+				if !ignoreBinary {
+					_, isNull = s.ScanBlob(i)
+					if !isNull {
+						row = append(row, DataValue{Name: rs.ColNames[i], Type: Binary,
+							Value: "<i>BINARY DATA</i>"})
+					}
+				} else {
+					addRow = false
 				}
 			case sqlite.Null:
 				isNull = true
 			}
+			if isNull && !ignoreNull {
+				// NULLS can be ignored (via flag to this function) for situations like the vis data
+				row = append(row, DataValue{Name: rs.ColNames[i], Type: Null,
+					Value: "<i>NULL</i>"})
+			}
+			if isNull && ignoreNull {
+				addRow = false
+			}
 		}
-		dash.Rows = append(dash.Rows, row)
+		if addRow == true {
+			rs.Records = append(rs.Records, row)
+			rs.RowCount++
+		}
+
 		return nil
 	})
 	if err != nil {
-		log.Printf("Error when reading data from database: %s\n", err)
-		return RedashTableData{}, err
+		Log.Errorf("Error when retrieving select data from database: %s", err)
+		return errors.New("Error when reading data from the SQLite database")
 	}
-	defer stmt.Finalize()
-
-	// Return the results
-	return dash, nil
+	return nil
 }
 
 // Returns the list of tables and view in the SQLite database.
@@ -434,34 +588,34 @@ func Tables(sdb *sqlite.Conn, fileName string) ([]string, error) {
 	// Retrieve the list of tables in the database
 	tables, err := sdb.Tables("")
 	if err != nil {
-		log.Printf("Error retrieving table names: %v\n", err)
+		Log.Errorf("Error retrieving table names: %v", err)
 		if cerr, ok := err.(sqlite.ConnError); ok {
-			log.Printf("Error code: %v\n", cerr.Code())
-			log.Printf("Extended error code: %v\n", cerr.ExtendedCode())
-			log.Printf("Extended error message: %v\n", cerr.Error())
-			log.Printf("Extended error filename: %v\n", cerr.Filename())
+			Log.Errorf("Error code: %v", cerr.Code())
+			Log.Errorf("Extended error code: %v", cerr.ExtendedCode())
+			Log.Errorf("Extended error message: %v", cerr.Error())
+			Log.Errorf("Extended error filename: %v", cerr.Filename())
 		} else {
-			log.Printf("Expected a connection error, but got a '%v'\n", reflect.TypeOf(cerr))
+			Log.Errorf("Expected a connection error, but got a '%v'", reflect.TypeOf(cerr))
 		}
 		return nil, err
 	}
 	if len(tables) == 0 {
 		// No table names were returned, so abort
-		log.Printf("The database '%s' doesn't seem to have any tables. Aborting.", fileName)
+		Log.Warnf("The database '%s' doesn't seem to have any tables. Aborting.", fileName)
 		return nil, err
 	}
 
 	// Retrieve the list of views in the database
 	vw, err := sdb.Views("")
 	if err != nil {
-		log.Printf("Error retrieving view names: %v\n", err)
+		Log.Errorf("Error retrieving view names: %v", err)
 		if cerr, ok := err.(sqlite.ConnError); ok {
-			log.Printf("Error code: %v\n", cerr.Code())
-			log.Printf("Extended error code: %v\n", cerr.ExtendedCode())
-			log.Printf("Extended error message: %v\n", cerr.Error())
-			log.Printf("Extended error filename: %v\n", cerr.Filename())
+			Log.Errorf("Error code: %v", cerr.Code())
+			Log.Errorf("Extended error code: %v", cerr.ExtendedCode())
+			Log.Errorf("Extended error message: %v", cerr.Error())
+			Log.Errorf("Extended error filename: %v", cerr.Filename())
 		} else {
-			log.Printf("Expected a connection error, but got a '%v'\n", reflect.TypeOf(cerr))
+			Log.Errorf("Expected a connection error, but got a '%v'", reflect.TypeOf(cerr))
 		}
 		return nil, err
 	}
@@ -470,3 +624,103 @@ func Tables(sdb *sqlite.Conn, fileName string) ([]string, error) {
 	tables = append(tables, vw...)
 	return tables, nil
 }
+
+// Checks a column transform is one which can be applied when grouping table data.
+func ValidateColumnTransform(transform string) error {
+	if _, ok := columnTransforms[transform]; ok || transform == "" {
+		return nil
+	}
+	_, err := bucketSize(transform)
+	return err
+}
+
+// Writes the data in a SQLite table out as CSV, a row at a time.  The output is flushed every so often, so the whole
+// table is never held in memory no matter how big it is.  This is a specialised variation of the ReadSQLiteDB()
+// function, just for our CSV exporting code.
+func WriteSQLiteDBCSV(sdb *sqlite.Conn, dbTable string, csvFile *csv.Writer) error {
+	// Retrieve all of the data from the selected database table
+	stmt, err := sdb.Prepare(`SELECT * FROM "` + dbTable + `"`)
+	if err != nil {
+		Log.Errorf("Error when preparing statement for database: %s", err)
+		return err
+	}
+	defer stmt.Finalize()
+
+	// Process each row
+	fieldCount := -1
+	var row []string
+	var rowNum int
+	err = stmt.Select(func(s *sqlite.Stmt) error {
+
+		// Get the number of fields in the result
+		if fieldCount == -1 {
+			fieldCount = stmt.DataCount()
+			row = make([]string, fieldCount)
+		}
+
+		// Retrieve the data for each row
+		for i := 0; i < fieldCount; i++ {
+			// Retrieve the data type for the field
+			fieldType := stmt.ColumnType(i)
+
+			isNull := false
+			switch fieldType {
+			case sqlite.Integer:
+				var val int64
+				val, isNull, err = s.ScanInt64(i)
+				if err != nil {
+					Log.Errorf("Something went wrong with ScanInt64(): %v", err)
+					return err
+				}
+				if !isNull {
+					row[i] = strconv.FormatInt(val, 10)
+				}
+			case sqlite.Float:
+				var val float64
+				val, isNull, err = s.ScanDouble(i)
+				if err != nil {
+					Log.Errorf("Something went wrong with ScanDouble(): %v", err)
+					return err
+				}
+				if !isNull {
+					row[i] = strconv.FormatFloat(val, 'f', 4, 64)
+				}
+			case sqlite.Text:
+				var val string
+				val, isNull = s.ScanText(i)
+				if !isNull {
+					row[i] = val
+				}
+			case sqlite.Blob:
+				var val []byte
+				val, isNull = s.ScanBlob(i)
+				if !isNull {
+					// Base64 encode the value
+					row[i] = base64.StdEncoding.EncodeToString(val)
+				}
+			case sqlite.Null:
+				isNull = true
+			}
+			if isNull {
+				row[i] = "NULL"
+			}
+		}
+		if err = csvFile.Write(row); err != nil {
+			return err
+		}
+
+		// Send what we have so far every now and then, stopping if the client has gone away
+		rowNum++
+		if rowNum%1000 == 0 {
+			csvFile.Flush()
+			return csvFile.Error()
+		}
+		return nil
+	})
+	if err != nil {
+		Log.Errorf("Error when writing CSV data for table '%s': %s", dbTable, err)
+		return err
+	}
+	csvFile.Flush()
+	return csvFile.Error()
+}
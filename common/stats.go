@@ -0,0 +1,142 @@
+package common
+
+import (
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The most page views (and downloads) held in memory between flushes.  Anything past this is dropped, so a
+// PostgreSQL outage can't use up all the memory
+const maxPendingViews = 100000
+
+// A download of one version of a project waiting to be added to the download statistics
+type projectDownload struct {
+	date     string
+	fileName string
+	folder   string
+	owner    string
+	sha      string
+}
+
+// A project page view waiting to be added to the usage statistics
+type projectView struct {
+	date     string
+	fileName string
+	folder   string
+	owner    string
+	referrer string
+}
+
+// Page views and downloads recorded since the statistics were last flushed to PostgreSQL
+var (
+	pendingDownloads   []projectDownload
+	pendingDownloadsMu sync.Mutex
+	pendingViews       []projectView
+	pendingViewsMu     sync.Mutex
+)
+
+// Periodically adds the recorded page views and downloads to the daily usage statistics for each project in
+// PostgreSQL.  This way page requests don't need to wait for a database write.
+func FlushProjectStats() {
+	Log.Infof("Project statistics flushing loop started.  %d second refresh.", Conf().Memcache.ViewCountFlushDelay)
+
+	type projectDay struct {
+		date     string
+		fileName string
+		folder   string
+		owner    string
+	}
+	for {
+		time.Sleep(Conf().Memcache.ViewCountFlushDelay * time.Second)
+
+		// Take the views recorded so far, so new ones can be recorded while these are being stored
+		pendingViewsMu.Lock()
+		views := pendingViews
+		pendingViews = nil
+		pendingViewsMu.Unlock()
+		pendingDownloadsMu.Lock()
+		downloads := pendingDownloads
+		pendingDownloads = nil
+		pendingDownloadsMu.Unlock()
+
+		// Total up the views for each project and day, along with where they came from
+		counts := make(map[projectDay]map[string]int64)
+		for _, v := range views {
+			key := projectDay{date: v.date, fileName: v.fileName, folder: v.folder, owner: v.owner}
+			if counts[key] == nil {
+				counts[key] = make(map[string]int64)
+			}
+			counts[key][v.referrer]++
+		}
+		for key, referrers := range counts {
+			err := storeProjectViews(key.owner, key.folder, key.fileName, key.date, referrers)
+			if err != nil {
+				Log.Errorf("Storing usage statistics for '%s%s%s' failed: %v", key.owner, key.folder, key.fileName,
+					err)
+			}
+		}
+
+		// Total up the downloads of each version of the projects for the day
+		versions := make(map[projectDay]map[string]int64)
+		for _, d := range downloads {
+			key := projectDay{date: d.date, fileName: d.fileName, folder: d.folder, owner: d.owner}
+			if versions[key] == nil {
+				versions[key] = make(map[string]int64)
+			}
+			versions[key][d.sha]++
+		}
+		for key, shas := range versions {
+			err := storeProjectDownloads(key.owner, key.folder, key.fileName, key.date, shas)
+			if err != nil {
+				Log.Errorf("Storing download statistics for '%s%s%s' failed: %v", key.owner, key.folder,
+					key.fileName, err)
+			}
+		}
+	}
+}
+
+// Returns the site a referring URL is on, or an empty string if there isn't one (eg the address was typed in).  Only
+// the host name is kept, so people's search terms and the like aren't stored.
+func referrerSite(referrer string) string {
+	u, err := url.Parse(referrer)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	host := strings.ToLower(u.Hostname())
+	return strings.TrimPrefix(host, "www.")
+}
+
+// Records a download of a project, for its owner's per version download statistics.  The sha is the SHA256 of the
+// file downloaded.
+func RecordProjectDownload(owner string, folder string, fileName string, sha string) {
+	pendingDownloadsMu.Lock()
+	defer pendingDownloadsMu.Unlock()
+	if len(pendingDownloads) >= maxPendingViews {
+		return
+	}
+	pendingDownloads = append(pendingDownloads, projectDownload{
+		date:     time.Now().UTC().Format("2006-01-02"),
+		fileName: fileName,
+		folder:   folder,
+		owner:    owner,
+		sha:      sha,
+	})
+}
+
+// Records a view of a project page, for its owner's usage statistics.
+func RecordProjectView(owner string, folder string, fileName string, referrer string) {
+	pendingViewsMu.Lock()
+	defer pendingViewsMu.Unlock()
+	if len(pendingViews) >= maxPendingViews {
+		return
+	}
+	pendingViews = append(pendingViews, projectView{
+		date:     time.Now().UTC().Format("2006-01-02"),
+		fileName: fileName,
+		folder:   folder,
+		owner:    owner,
+		referrer: referrerSite(referrer),
+	})
+}
@@ -0,0 +1,249 @@
+package common
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go"
+)
+
+// Users can export everything stored about them (their profile, projects, discussions, comments, stars, activity,
+// and the files in their projects) from their preferences page.  The export is put together in the background as a
+// zip archive, kept in Minio until it expires, and the user is emailed when it's ready to download.
+
+const (
+	// How long a finished data export can be downloaded for
+	DataExportExpiry = 7 * 24 * time.Hour
+
+	// The Minio bucket data exports are kept in.  Database files use buckets named after the start of their sha256,
+	// so this can't clash with them
+	dataExportBucket = "data-exports"
+
+	// How often expired data exports are cleaned up
+	dataExportCleanupInterval = time.Hour
+
+	// How long users need to wait between requesting data exports
+	dataExportMinInterval = 24 * time.Hour
+)
+
+// Describes the layout of a data export, for the people reading it
+const dataExportReadme = `This archive holds everything 3DHub.io stores about the account '%s', as of %s.
+
+  profile.json      Your account details and preferences
+  projects.json     Your projects, including every commit, branch, tag, and release
+  discussions.json  The discussions and merge requests you started
+  comments.json     Your comments on discussions and merge requests
+  stars.json        The projects you've starred
+  watching.json     The projects you're watching
+  uploads.json      The files you've uploaded
+  downloads.json    The files you've downloaded while logged in
+  files/            The files in your projects, named by their sha256.  The commit trees in projects.json give the
+                    name of each file
+`
+
+// Runs a data export queued by QueueDataExport(), recording how it went and emailing the user once it's ready.  The
+// payload holds the ID of the export.
+func DataExportJob(payload json.RawMessage) error {
+	var p struct {
+		ID int64 `json:"id"`
+	}
+	err := json.Unmarshal(payload, &p)
+	if err != nil {
+		return err
+	}
+	exp, found, err := DataExport(p.ID)
+	if err != nil {
+		return err
+	}
+	if !found {
+		// The user has been removed since asking, so there's nothing to do
+		return nil
+	}
+	err = StartDataExport(p.ID)
+	if err != nil {
+		return err
+	}
+	objectName, size, exportErr := uploadDataExport(exp)
+	err = StoreDataExportResult(p.ID, objectName, size, exportErr, time.Now().Add(DataExportExpiry))
+	if err != nil {
+		return err
+	}
+	if exportErr != nil {
+		return exportErr
+	}
+
+	// The export is ready, so failing to send the email isn't counted as the export failing
+	err = EmailUser(exp.UserName, "3DHub.io: Your data export is ready",
+		fmt.Sprintf("The export of your 3DHub.io data you asked for is ready.  You can download it from "+
+			"https://%s/pref#takeout for the next %d days.", Conf().Web.ServerName, int(DataExportExpiry.Hours()/24)))
+	if err != nil {
+		Log.Warnf("Emailing '%s' about their data export failed: %v", exp.UserName, err)
+	}
+	return nil
+}
+
+// Removes expired data exports, along with the exports of users who have been removed.  Only one of the webui
+// servers does the cleaning up.
+func DataExportLoop() {
+	for {
+		if HoldJobLock("data_export_cleanup", dataExportCleanupInterval) {
+			list, err := ExpiredDataExports()
+			if err != nil {
+				Log.Errorf("Error when checking for expired data exports: %v", err)
+			}
+			for _, exp := range list {
+				if exp.ObjectName != "" {
+					err = minioClient.RemoveObject(dataExportBucket, exp.ObjectName)
+					if err != nil {
+						Log.Warnf("Removing expired data export '%s' from Minio failed: %v", exp.ObjectName, err)
+						continue
+					}
+				}
+				DeleteDataExport(exp.ID)
+			}
+		}
+		time.Sleep(dataExportCleanupInterval)
+	}
+}
+
+// Returns a handle to the archive of a finished data export.  The handle needs closing with MinioHandleClose().
+func DataExportObject(exp DataExportEntry) (*minio.Object, error) {
+	if exp.Status != "done" || exp.ObjectName == "" {
+		return nil, errors.New("That data export isn't ready")
+	}
+	return MinioHandle(dataExportBucket, exp.ObjectName)
+}
+
+// Queues an export of all of a user's data, returning its ID.  Users can only have one export being put together at
+// a time, and can only ask for one a day.
+func QueueDataExport(userName string) (id int64, err error) {
+	list, err := DataExports(userName)
+	if err != nil {
+		return
+	}
+	for _, exp := range list {
+		if exp.Status == "queued" || exp.Status == "running" {
+			return 0, errors.New("Your data export is already being put together")
+		}
+		if time.Since(exp.DateRequested) < dataExportMinInterval {
+			return 0, errors.New("You can only ask for one data export a day")
+		}
+	}
+	id, err = AddDataExport(userName)
+	if err != nil {
+		return
+	}
+	_, err = QueueJob("data_export", map[string]int64{"id": id})
+	return
+}
+
+// Writes a data export to a temporary file, then uploads it to Minio.  Returns the name of the uploaded archive, and
+// its size.
+func uploadDataExport(exp DataExportEntry) (objectName string, size int64, err error) {
+	tmp, err := ioutil.TempFile(Conf().DiskCache.Directory, "3dhub-export-")
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	numFiles, err := writeDataExport(tmp, exp.UserName)
+	if err != nil {
+		return
+	}
+	if err = tmp.Sync(); err != nil {
+		return
+	}
+
+	// If the bucket for data exports doesn't exist yet, create it
+	found, err := minioClient.BucketExists(dataExportBucket)
+	if err != nil {
+		Log.Errorf("Error when checking if Minio bucket '%s' already exists: %v", dataExportBucket, err)
+		return
+	}
+	if !found {
+		err = minioClient.MakeBucket(dataExportBucket, "us-east-1")
+		if err != nil {
+			Log.Errorf("Error creating Minio bucket '%v': %v", dataExportBucket, err)
+			return
+		}
+	}
+
+	objectName = fmt.Sprintf("%d-%s.zip", exp.ID, time.Now().UTC().Format("20060102-150405"))
+	size, err = minioClient.FPutObject(dataExportBucket, objectName, tmp.Name(),
+		minio.PutObjectOptions{ContentType: "application/zip"})
+	if err != nil {
+		err = fmt.Errorf("Uploading the data export failed: %v", err)
+		return
+	}
+	Log.Infof("Data export %d for '%s' uploaded to '%s', with %d files", exp.ID, exp.UserName, objectName,
+		numFiles)
+	return
+}
+
+// Writes a zip archive of everything stored about a user.  Returns the number of project files in it.
+func writeDataExport(w io.Writer, userName string) (numFiles int, err error) {
+	sections, files, err := UserExportData(userName)
+	if err != nil {
+		return
+	}
+	zw := zip.NewWriter(w)
+	now := time.Now().UTC()
+	addEntry := func(name string, data string) error {
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: now})
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(f, data)
+		return err
+	}
+	err = addEntry("README.txt", fmt.Sprintf(dataExportReadme, userName, now.Format(time.RFC1123)))
+	if err != nil {
+		return
+	}
+
+	// Add the sections in a fixed order, so archives are laid out the same each time
+	var names []string
+	for name := range sections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err = addEntry(name+".json", sections[name]); err != nil {
+			return
+		}
+	}
+
+	// Add the files in the user's projects
+	for _, sha := range files {
+		if len(sha) <= MinioFolderChars || strings.ContainsAny(sha, "/\\") {
+			Log.Warnf("Skipping database file with invalid sha256 '%s'", sha)
+			continue
+		}
+		var obj *minio.Object
+		obj, err = MinioHandle(sha[:MinioFolderChars], sha[MinioFolderChars:])
+		if err != nil {
+			return
+		}
+		var f io.Writer
+		f, err = zw.CreateHeader(&zip.FileHeader{Name: "files/" + sha, Method: zip.Deflate, Modified: now})
+		if err == nil {
+			_, err = io.Copy(f, obj)
+		}
+		MinioHandleClose(obj)
+		if err != nil {
+			err = fmt.Errorf("Adding file '%s' to the data export failed: %v", sha, err)
+			return
+		}
+		numFiles++
+	}
+	err = zw.Close()
+	return
+}
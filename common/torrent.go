@@ -0,0 +1,188 @@
+package common
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+)
+
+// Large public files can be shared using BitTorrent, so mirrors and the people downloading them can take some of the
+// load off the server.  The .torrent files are made when first asked for, then cached.  They list the download end
+// point as a web seed (BEP 19), so there's always somewhere to get the file from, even when there aren't any peers.
+
+const (
+	// How long a .torrent file is cached for.  The file it's for never changes, so this can be a long time
+	torrentCacheTime = 7 * 24 * 60 * 60
+
+	// The largest and smallest piece sizes used, and the number of pieces aimed for between them
+	torrentMaxPieceLength = 16 * 1024 * 1024
+	torrentMinPieceLength = 256 * 1024
+	torrentTargetPieces   = 1000
+)
+
+// A .torrent file, as it's cached
+type torrentData struct {
+	InfoHash string
+	Torrent  []byte
+}
+
+// Writes a value in BitTorrent's bencode format.  Only the types needed for .torrent files are handled.
+func bencode(b *bytes.Buffer, v interface{}) error {
+	switch val := v.(type) {
+	case int64:
+		fmt.Fprintf(b, "i%de", val)
+	case string:
+		fmt.Fprintf(b, "%d:%s", len(val), val)
+	case []interface{}:
+		b.WriteByte('l')
+		for _, item := range val {
+			if err := bencode(b, item); err != nil {
+				return err
+			}
+		}
+		b.WriteByte('e')
+	case map[string]interface{}:
+		// Dictionary keys need to be in sorted order
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b.WriteByte('d')
+		for _, k := range keys {
+			fmt.Fprintf(b, "%d:%s", len(k), k)
+			if err := bencode(b, val[k]); err != nil {
+				return err
+			}
+		}
+		b.WriteByte('e')
+	default:
+		return fmt.Errorf("Can't bencode a value of type %T", v)
+	}
+	return nil
+}
+
+// Returns the magnet link for a torrent.  Besides the info hash, it has the file name and size, the web seed, and
+// the trackers, so clients can start without fetching the .torrent file first.
+func MagnetLink(infoHash string, fileName string, size int64, webSeedURL string) string {
+	v := url.Values{}
+	v.Set("dn", fileName)
+	v.Set("ws", webSeedURL)
+	v.Set("xl", strconv.FormatInt(size, 10))
+	for _, tr := range Conf().Torrent.Trackers {
+		v.Add("tr", tr)
+	}
+	return "magnet:?xt=urn:btih:" + infoHash + "&" + v.Encode()
+}
+
+// Returns whether torrents are made for files of the given size.
+func TorrentAvailable(size int64) bool {
+	return Conf().Torrent.Enabled && size >= Conf().Torrent.MinSizeMB*1024*1024
+}
+
+// Returns the .torrent file for a file stored in Minio, along with its info hash (as hex).  The web seed URL is where
+// clients can download the file over HTTP, which needs to support range requests.
+func TorrentFile(sha string, fileName string, webSeedURL string) (torrent []byte, infoHash string, err error) {
+	// Use the cached .torrent file if it's available
+	tempArr := md5.Sum([]byte(fmt.Sprintf("torrent-%s-%s-%s-%v", sha, fileName, webSeedURL,
+		Conf().Torrent.Trackers)))
+	cacheKey := hex.EncodeToString(tempArr[:])
+	var cached torrentData
+	ok, err := GetCachedData(cacheKey, &cached)
+	if err != nil {
+		Log.Warnf("Error retrieving cached torrent: %v", err)
+	}
+	if ok && len(cached.Torrent) > 0 {
+		return cached.Torrent, cached.InfoHash, nil
+	}
+
+	if len(sha) <= MinioFolderChars {
+		return nil, "", fmt.Errorf("'%s' isn't the SHA256 of a stored file", sha)
+	}
+	obj, err := MinioHandle(sha[:MinioFolderChars], sha[MinioFolderChars:])
+	if err != nil {
+		return
+	}
+	defer MinioHandleClose(obj)
+	stat, err := obj.Stat()
+	if err != nil {
+		return
+	}
+	if stat.Size == 0 {
+		return nil, "", errors.New("Empty files can't be shared using BitTorrent")
+	}
+
+	// Hash each piece of the file
+	pieceLength := torrentPieceLength(stat.Size)
+	var pieces bytes.Buffer
+	buf := make([]byte, pieceLength)
+	for {
+		n, err := io.ReadFull(obj, buf)
+		if n > 0 {
+			sum := sha1.Sum(buf[:n])
+			pieces.Write(sum[:])
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			Log.Errorf("Reading file '%s' from Minio for a torrent failed: %v", sha, err)
+			return nil, "", err
+		}
+	}
+
+	info := map[string]interface{}{
+		"length":       stat.Size,
+		"name":         fileName,
+		"piece length": pieceLength,
+		"pieces":       pieces.String(),
+	}
+	var infoBuf bytes.Buffer
+	if err = bencode(&infoBuf, info); err != nil {
+		return
+	}
+	infoSum := sha1.Sum(infoBuf.Bytes())
+	infoHash = hex.EncodeToString(infoSum[:])
+
+	meta := map[string]interface{}{
+		"created by": "3DHub.io",
+		"info":       info,
+		"url-list":   []interface{}{webSeedURL},
+	}
+	if len(Conf().Torrent.Trackers) != 0 {
+		var trackers []interface{}
+		for _, tr := range Conf().Torrent.Trackers {
+			trackers = append(trackers, []interface{}{tr})
+		}
+		meta["announce"] = Conf().Torrent.Trackers[0]
+		meta["announce-list"] = trackers
+	}
+	var out bytes.Buffer
+	if err = bencode(&out, meta); err != nil {
+		return
+	}
+	torrent = out.Bytes()
+
+	err = CacheData(cacheKey, torrentData{InfoHash: infoHash, Torrent: torrent}, torrentCacheTime)
+	if err != nil {
+		Log.Warnf("Error when caching torrent: %v", err)
+	}
+	return torrent, infoHash, nil
+}
+
+// Returns the piece size to use for a file, aiming for around a thousand pieces.  Piece sizes need to be a power of
+// two.
+func torrentPieceLength(size int64) int64 {
+	l := int64(torrentMinPieceLength)
+	for l < torrentMaxPieceLength && size/l > torrentTargetPieces {
+		l *= 2
+	}
+	return l
+}
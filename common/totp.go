@@ -0,0 +1,135 @@
+package common
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Users can add an authenticator app (eg Google Authenticator, or Aegis) to their account.  The time based one time
+// passwords (TOTP, RFC 6238) it gives are used for confirming who they are before destructive operations, as an
+// alternative to logging in again.  The usual settings for authenticator apps are used: SHA1, 6 digits, and a new
+// code every 30 seconds.
+
+const (
+	// How long each TOTP code lasts
+	totpPeriod = 30
+
+	// How many wrong codes in a row lock a user's authenticator app, and for how long.  Otherwise anyone with a stolen
+	// session could try every code
+	totpMaxFailures = 5
+	totpLockout     = 15 * time.Minute
+)
+
+// Strips the padding from base32 encoded secrets, as authenticator apps don't expect it
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Checks a code from a user's authenticator app, and marks it as used.  Wrong codes are recorded in the audit log, along
+// with the address they came from.  After too many of them in a row no codes are accepted until the lockout has passed,
+// and locked is returned.
+func CheckUserTOTP(userName string, code string, client string) (ok bool, locked bool, err error) {
+	sealed, lastStep, locked, err := UserTOTP(userName)
+	if err != nil || locked {
+		return false, locked, err
+	}
+	secret := ""
+	if len(sealed) != 0 {
+		secret, err = openSecret(sealed)
+		if err != nil {
+			Log.Errorf("Decrypting the authenticator app secret of '%s' failed: %v", userName, err)
+			return false, false, errors.New("Your authenticator app details couldn't be read.  Please log in " +
+				"again instead")
+		}
+	}
+	if step, valid := ValidateTOTP(secret, code, lastStep); valid {
+		ok, err = UseTOTPStep(userName, step)
+		if err != nil || ok {
+			return
+		}
+	}
+	locked, err = RecordTOTPFailure(userName, totpMaxFailures, totpLockout)
+	if err != nil {
+		return
+	}
+	details := fmt.Sprintf("From %s", client)
+	if locked {
+		details += fmt.Sprintf(", and locked for %v", totpLockout)
+	}
+	err = AddAuditLogEntry(userName, "totpfailed", userName, details)
+	return false, locked, err
+}
+
+// Adds (or replaces) the authenticator app for a user.  The secret is stored encrypted, the same as the other secrets
+// users store with us.
+func EnableUserTOTP(userName string, secret string) error {
+	sealed, err := sealSecret(secret)
+	if err != nil {
+		Log.Errorf("Encrypting the authenticator app secret of '%s' failed: %v", userName, err)
+		return errors.New("The authenticator app details couldn't be stored")
+	}
+	return SetUserTOTP(userName, sealed)
+}
+
+// Returns a new random secret for a user's authenticator app, base32 encoded.
+func GenerateTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// Returns the code for a secret at the given time step.
+func totpCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	n := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", n%1000000), nil
+}
+
+// Returns the otpauth:// URI for adding a secret to an authenticator app.
+func TOTPURI(userName string, secret string) string {
+	issuer := strings.Split(Conf().Web.ServerName, ":")[0]
+	return fmt.Sprintf("otpauth://totp/%s:%s?secret=%s&issuer=%s&period=%d", url.PathEscape(issuer),
+		url.PathEscape(userName), secret, url.QueryEscape(issuer), totpPeriod)
+}
+
+// Checks a code from an authenticator app against a secret.  Codes from the time steps either side of the current one
+// are accepted too, to allow for clock drift.  Codes from the lastStep time step or before aren't accepted, so each
+// code can only be used once.  The time step of the code is returned when it's valid.
+func ValidateTOTP(secret string, code string, lastStep int64) (step int64, ok bool) {
+	code = strings.Replace(strings.TrimSpace(code), " ", "", -1)
+	if secret == "" || len(code) != 6 {
+		return 0, false
+	}
+	now := time.Now().Unix() / totpPeriod
+	for s := now - 1; s <= now+1; s++ {
+		if s <= lastStep {
+			continue
+		}
+		c, err := totpCode(secret, s)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(c), []byte(code)) == 1 {
+			return s, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,85 @@
+package common
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// The context key used for storing the ID of a request
+type requestIDKey struct{}
+
+// Request IDs given to us by a trusted reverse proxy are only used if they look sane
+var requestIDFormat = regexp.MustCompile(`^[a-zA-Z0-9._-]{1,64}$`)
+
+// Returns the ID of the request, or an empty string if it hasn't been given one.
+func RequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// Gives a request its ID, which is included in the logs and error pages so problems can be tracked down.  If a trusted
+// reverse proxy has already given the request an ID (in the X-Request-ID header), that one is used so the proxy and
+// server logs match up.  The returned request (a shallow copy of the original) is the one which should be used from
+// then on.
+func SetRequestID(w http.ResponseWriter, r *http.Request) *http.Request {
+	id := r.Header.Get("X-Request-ID")
+	if !isTrustedProxy(hostIP(r.RemoteAddr)) || !requestIDFormat.MatchString(id) {
+		b := make([]byte, 8)
+		if _, err := rand.Read(b); err == nil {
+			id = hex.EncodeToString(b)
+		} else {
+			// Unlikely, but a time based ID is still better than none
+			id = fmt.Sprintf("%x", time.Now().UnixNano())
+		}
+	}
+	w.Header().Set("X-Request-ID", id)
+	return r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
+}
+
+// Logs the time taken by a call to one of the backend services (PostgreSQL, Minio, Memcached), when tracing is turned
+// on in the configuration file.  Spans are logged at debug level, or as warnings when slower than the configured
+// threshold.  Call it with defer at the start of the function being traced.
+func traceSpan(service string, operation string, start time.Time) {
+	if !Conf().Trace.Enabled {
+		return
+	}
+	elapsed := time.Since(start)
+	entry := Log.WithFields(logrus.Fields{
+		"duration":  elapsed.String(),
+		"operation": operation,
+		"service":   service,
+	})
+	if Conf().Trace.SlowMS > 0 && elapsed >= time.Duration(Conf().Trace.SlowMS)*time.Millisecond {
+		entry.Warn("Slow span")
+		return
+	}
+	entry.Debug("Span")
+}
+
+// Passes the query timings logged by pgx through to traceSpan(), so PostgreSQL calls are traced along with the others.
+type pgxTracer struct{}
+
+func (pgxTracer) Debug(msg string, ctx ...interface{}) {}
+
+func (pgxTracer) Info(msg string, ctx ...interface{}) {
+	// pgx logs each query and exec with its time taken, in key/value pairs following the message
+	for i := 0; i+1 < len(ctx); i += 2 {
+		if d, ok := ctx[i+1].(time.Duration); ok && ctx[i] == "time" {
+			traceSpan("postgresql", msg, time.Now().Add(-d))
+			return
+		}
+	}
+}
+
+func (pgxTracer) Warn(msg string, ctx ...interface{}) {
+	Log.WithField("service", "postgresql").Warnf("%s %v", msg, ctx)
+}
+
+func (pgxTracer) Error(msg string, ctx ...interface{}) {}
@@ -21,6 +21,35 @@ const (
 	ALL_TIME                 = "all"
 )
 
+type SortOrder string
+
+const (
+	SORT_DOWNLOADS SortOrder = "downloads"
+	SORT_NEWEST    SortOrder = "newest"
+	SORT_STARS     SortOrder = "stars"
+	SORT_UPDATED   SortOrder = "updated"
+)
+
+// The colour theme the pages are shown with.  THEME_AUTO follows the light or dark setting of the visitor's system
+type Theme string
+
+const (
+	THEME_AUTO  Theme = "auto"
+	THEME_DARK  Theme = "dark"
+	THEME_LIGHT Theme = "light"
+)
+
+// How often a user is emailed about activity on the projects they watch.  EMAIL_INSTANT sends an email for each
+// event as it happens, while EMAIL_DAILY and EMAIL_WEEKLY collect them into a digest
+type EmailFrequency string
+
+const (
+	EMAIL_DAILY   EmailFrequency = "daily"
+	EMAIL_INSTANT EmailFrequency = "instant"
+	EMAIL_NEVER   EmailFrequency = "never"
+	EMAIL_WEEKLY  EmailFrequency = "weekly"
+)
+
 type ForkType int
 
 const (
@@ -45,18 +74,84 @@ const (
 // Number of rows to display by default on the database page
 const DefaultNumDisplayRows = 25
 
+// The number of entries included in the Atom feeds
+const FeedSize = 30
+
+// The most table rows which can be requested at once, when paging through a table
+const MaxNumDisplayRows = 500
+
+// The maximum depth of the category tree.  eg 2 allows "Gadgets/Enclosures", but not "Gadgets/Enclosures/Small"
+const MaxCategoryDepth = 2
+
+// The maximum number of tags a project can have
+const MaxProjectTags = 10
+
+// The maximum number of projects which can be looked up in a single batch metadata request
+const MaxBatchProjects = 100
+
 // The maximum file size accepted for upload (in MB)
 const MaxFileSize = 512
 
 // The maximum licence size accepted for upload (in MB)
 const MaxLicenceSize = 1
 
+// The fewest triangles a model needs before public models with the same number are treated as likely copies of it, when
+// warning about duplicate uploads.  Simple models (eg calibration cubes) often have the same number by chance
+const DuplicateMinTriangles = 1000
+
+// The number of possible duplicates listed when warning about an upload
+const DuplicateProjectsSize = 10
+
+// The number of public events returned per page by the events API
+const PublicEventsPageSize = 30
+
+// The number of related projects suggested for each project
+const RelatedProjectsSize = 6
+
+// The number of search results returned per page
+const SearchResultsPageSize = 20
+
 // The number of leading characters of a files' sha256 used as the Minio folder name
 // eg: When set to 6, then "34f4255a737156147fbd0a44323a895d18ade79d4db521564d1b0dbb8764cbbc"
 //        -> Minio folder: "34f425"
 //        -> Minio filename: "5a737156147fbd0a44323a895d18ade79d4db521564d1b0dbb8764cbbc"
 const MinioFolderChars = 6
 
+// The date formats users can choose between in their preferences.  The first one is the default
+var DateFormats = []DateFormat{
+	{ID: "medium", Name: "Jan 2, 2006, 3:04:05 PM", DateLayout: "Jan 2, 2006", TimeLayout: "Jan 2, 2006, 3:04:05 PM"},
+	{ID: "iso", Name: "2006-01-02 15:04:05", DateLayout: "2006-01-02", TimeLayout: "2006-01-02 15:04:05"},
+	{ID: "dmy", Name: "02/01/2006 15:04", DateLayout: "02/01/2006", TimeLayout: "02/01/2006 15:04"},
+	{ID: "mdy", Name: "01/02/2006 3:04 PM", DateLayout: "01/02/2006", TimeLayout: "01/02/2006 3:04 PM"},
+}
+
+// The kinds of activity users can be notified about, in the order they're shown on the preferences page
+var NotificationEvents = []NotificationEvent{
+	{Name: "New discussions", Type: EVENT_NEW_DISCUSSION},
+	{Name: "New merge requests", Type: EVENT_NEW_MERGE_REQUEST},
+	{Name: "New comments", Type: EVENT_NEW_COMMENT},
+	{Name: "New releases", Type: EVENT_NEW_RELEASE},
+	{Name: "New versions", Type: EVENT_NEW_VERSION},
+	{Name: "New questions", Type: EVENT_NEW_QUESTION},
+}
+
+// The services users can link to from their profile, and the name shown for each
+var SocialServices = []SocialService{
+	{"github", "GitHub"},
+	{"mastodon", "Mastodon"},
+	{"printables", "Printables"},
+	{"thingiverse", "Thingiverse"},
+	{"twitter", "Twitter"},
+	{"youtube", "YouTube"},
+}
+
+// The services users can ask for tips or donations through, when the tips feature is turned on
+var TipServices = []TipService{
+	{Hosts: []string{"ko-fi.com"}, ID: "kofi", Name: "Ko-fi"},
+	{Hosts: []string{"liberapay.com"}, ID: "liberapay", Name: "Liberapay"},
+	{Hosts: []string{"paypal.com", "paypal.me"}, ID: "paypal", Name: "PayPal"},
+}
+
 // ************************
 // Configuration file types
 
@@ -64,15 +159,30 @@ const MinioFolderChars = 6
 type TomlConfig struct {
 	Admin       AdminInfo
 	Auth0       Auth0Info
+	Backup      BackupInfo
+	Billing     BillingInfo
+	Cache       CacheInfo
 	DB4S        DB4SInfo
 	Environment EnvInfo
 	DiskCache   DiskCacheInfo
 	Event       EventProcessingInfo
+	Filter      FilterInfo
+	Import      ImportInfo
+	Jobs        JobsInfo
 	Licence     LicenceInfo
+	Log         LogInfo
 	Memcache    MemcacheInfo
 	Minio       MinioInfo
+	Moderation  ModerationInfo
 	Pg          PGInfo
+	Prewarm     PrewarmInfo
+	Print       PrintInfo
+	Quota       QuotaInfo
+	Search      SearchInfo
 	Sign        SigningInfo
+	Spam        SpamInfo
+	Torrent     TorrentInfo
+	Trace       TraceInfo
 	Web         WebInfo
 }
 
@@ -82,6 +192,7 @@ type AdminInfo struct {
 	CertificateKey string `toml:"certificate_key"`
 	HTTPS          bool
 	Server         string
+	Users          []string
 }
 
 // Auth0 connection parameters
@@ -91,6 +202,53 @@ type Auth0Info struct {
 	Domain       string
 }
 
+// Scheduled backups.  Every IntervalHours (0 turns them off) an encrypted backup is uploaded to Bucket on the S3
+// compatible Server, with the oldest ones beyond Keep removed (0 keeps them all).  Backups can't be restored without
+// the Passphrase, so it needs to be kept somewhere other than this server too
+type BackupInfo struct {
+	AccessKey     string `toml:"access_key"`
+	Bucket        string
+	HTTPS         bool
+	IntervalHours int `toml:"interval_hours"`
+	Keep          int
+	Passphrase    string
+	Prefix        string
+	Region        string
+	Secret        string
+	Server        string
+}
+
+// Plans for hosted deployments.  When billing is enabled, users on the free plan can only have FreePrivateProjects
+// private projects, and the paid plans raise that.  Users subscribe through a Stripe payment link (CheckoutURL), and
+// Stripe tells us about changes to their subscriptions through the /x/stripe webhook, signed with WebhookSecret
+type BillingInfo struct {
+	Enabled             bool
+	FreePrivateProjects int `toml:"free_private_projects"`
+	Plans               []BillingPlan
+	PortalURL           string `toml:"portal_url"`
+	WebhookSecret       string `toml:"webhook_secret"`
+}
+
+// A paid plan.  ID is stored for the users on it, so shouldn't be changed once in use.  StripePrice is the ID of the
+// Stripe price subscribed to for it
+type BillingPlan struct {
+	CheckoutURL     string `toml:"checkout_url"`
+	ID              string
+	Name            string
+	Price           string
+	PrivateProjects int    `toml:"private_projects"`
+	StripePrice     string `toml:"stripe_price"`
+}
+
+// Where the general data cache is kept
+type CacheInfo struct {
+	Backend   string
+	Database  int
+	KeyPrefix string `toml:"key_prefix"`
+	Password  string
+	Server    string
+}
+
 // Configuration info for the DB4S end point
 type DB4SInfo struct {
 	CAChain        string `toml:"ca_chain"`
@@ -117,15 +275,57 @@ type EventProcessingInfo struct {
 	EmailQueueProcessingDelay time.Duration `toml:"email_queue_processing_delay"`
 }
 
+// Word filter settings.  When enabled, usernames, public project names, and comments are checked against the words
+// here plus those added on the admin page.  Matches aren't rejected, they're put in front of a moderator instead
+type FilterInfo struct {
+	Enabled bool     `toml:"enabled"`
+	Words   []string `toml:"words"`
+}
+
+// Keys for importing things from other sites.  Importing from Thingiverse and MyMiniFactory URLs needs an API key for
+// the site, while Thingiverse export archives can be imported without one.  Public GitHub repositories can be imported
+// without a token too, though GitHub allows many more requests with one
+type ImportInfo struct {
+	GitHubToken      string `toml:"github_token"`
+	MyMiniFactoryKey string `toml:"myminifactory_key"`
+	ThingiverseToken string `toml:"thingiverse_token"`
+}
+
+// Background job settings.  Workers is the number of jobs run at once by each server, and Types holds the settings for
+// each type of job
+type JobsInfo struct {
+	Types   map[string]JobTypeInfo `toml:"types"`
+	Workers int                    `toml:"workers"`
+}
+
+// Settings for one type of background job.  Jobs with a higher priority are run first.  Failed jobs are retried up to
+// MaxAttempts times in total, waiting RetryDelay seconds (doubling each time) between attempts.  A non zero Workers
+// limits how many jobs of the type each server runs at once, so a flood of slow jobs can't hold up the others
+type JobTypeInfo struct {
+	MaxAttempts int           `toml:"max_attempts"`
+	Priority    int           `toml:"priority"`
+	RetryDelay  time.Duration `toml:"retry_delay"`
+	Workers     int           `toml:"workers"`
+}
+
 // Path to the licence files
 type LicenceInfo struct {
 	LicenceDir string `toml:"licence_dir"`
 }
 
+// Logging configuration.  Format is "text" (the default) or "json", and level is one of "debug", "info" (the
+// default), "warn", or "error"
+type LogInfo struct {
+	Format string
+	Level  string
+}
+
 // Memcached connection parameters
 type MemcacheInfo struct {
 	DefaultCacheTime    int           `toml:"default_cache_time"`
+	Optional            bool          `toml:"optional"`
 	Server              string        `toml:"server"`
+	Servers             []string      `toml:"servers"`
 	ViewCountFlushDelay time.Duration `toml:"view_count_flush_delay"`
 }
 
@@ -137,15 +337,68 @@ type MinioInfo struct {
 	Server    string
 }
 
-// PostgreSQL connection parameters
+// Moderation settings.  In strict mode, newly public projects aren't shown to anyone but their owner until a
+// moderator approves them
+type ModerationInfo struct {
+	Strict bool
+}
+
+// PostgreSQL connection parameters.  The timeouts are in seconds.  AcquireTimeout is how long to wait for a free
+// connection when they're all in use, IdleTimeout closes the connections when they haven't been used for that long,
+// and StatementTimeout cancels statements which take longer (zero means no limit for both).  Connections which can't
+// be made are retried up to Retries times (default 3, or -1 to turn that off)
 type PGInfo struct {
-	Database       string
-	NumConnections int `toml:"num_connections"`
-	Port           int
-	Password       string
-	Server         string
-	SSL            bool
-	Username       string
+	AcquireTimeout   time.Duration `toml:"acquire_timeout"`
+	Database         string
+	IdleTimeout      time.Duration `toml:"idle_timeout"`
+	NumConnections   int           `toml:"num_connections"`
+	Port             int
+	Password         string
+	Retries          int
+	Server           string
+	SSL              bool
+	StatementTimeout time.Duration `toml:"statement_timeout"`
+	Username         string
+}
+
+// Cache pre-warming settings.  Every IntervalMinutes (zero turns it off), each server fills the caches for the
+// Projects (default 20) most viewed public projects over the last Days (default 2) days, so a sudden rush of visitors
+// doesn't all miss the cache at once
+type PrewarmInfo struct {
+	Days            int `toml:"days"`
+	IntervalMinutes int `toml:"interval_minutes"`
+	Projects        int `toml:"projects"`
+}
+
+// The print services models can be sent to for a quote
+type PrintInfo struct {
+	Services []PrintService
+}
+
+// A print service models can be sent to.  ID is used in the requests for it, so shouldn't be changed once in use.  Type
+// is the API used for talking to it (default "generic"), and Format the file format it wants models in (stl, obj, or
+// 3mf, default stl).  For the generic API, QuoteField is the field of its JSON response holding the quote link
+// (default "quote_url")
+type PrintService struct {
+	APIKey     string `toml:"api_key"`
+	APIURL     string `toml:"api_url"`
+	Format     string
+	ID         string
+	Name       string
+	QuoteField string `toml:"quote_field"`
+	Type       string
+}
+
+// Upload quota for each user.  Zero means unlimited.
+type QuotaInfo struct {
+	DailyUploadMB int64 `toml:"daily_upload_mb"`
+}
+
+// Search engine configuration.  Backend is one of "postgresql" (the default), "bleve", or "elasticsearch"
+type SearchInfo struct {
+	Backend string
+	Index   string
+	Server  string
 }
 
 // Used for signing DB4S client certificates
@@ -156,15 +409,61 @@ type SigningInfo struct {
 	IntermediateKey  string `toml:"intermediate_key"`
 }
 
+// Spam checking settings.  New public projects and comments from accounts younger than NewAccountDays (or from any
+// account, if it's zero) are checked, and held for moderation if they look like spam.  Setting AkismetKey adds a check
+// using Akismet, or a compatible service at AkismetURL
+type SpamInfo struct {
+	AkismetKey     string   `toml:"akismet_key"`
+	AkismetURL     string   `toml:"akismet_url"`
+	BlockedWords   []string `toml:"blocked_words"`
+	Enabled        bool     `toml:"enabled"`
+	MaxLinks       int      `toml:"max_links"`
+	NewAccountDays int      `toml:"new_account_days"`
+}
+
+// BitTorrent settings.  When enabled, public files of at least MinSizeMB (default 100) can be downloaded using
+// torrents and magnet links, which have the download end point as a web seed.  The trackers are optional
+type TorrentInfo struct {
+	Enabled   bool     `toml:"enabled"`
+	MinSizeMB int64    `toml:"min_size_mb"`
+	Trackers  []string `toml:"trackers"`
+}
+
+// Tracing settings.  When enabled, the time taken by each PostgreSQL, Minio, and Memcached call is logged
+type TraceInfo struct {
+	Enabled bool `toml:"enabled"`
+	SlowMS  int  `toml:"slow_ms"`
+}
+
 type WebInfo struct {
-	BaseDir              string `toml:"base_dir"`
-	BindAddress          string `toml:"bind_address"`
-	Certificate          string `toml:"certificate"`
-	CertificateKey       string `toml:"certificate_key"`
-	RequestLog           string `toml:"request_log"`
-	ServerName           string `toml:"server_name"`
-	SessionStorePassword string `toml:"session_store_password"`
-	WebsiteName          string `toml:"website_name"`
+	AccessLogDays        int      `toml:"access_log_days"`
+	Autocert             bool     `toml:"autocert"`
+	AutocertCacheDir     string   `toml:"autocert_cache_dir"`
+	AutocertEmail        string   `toml:"autocert_email"`
+	AutocertHTTPAddress  string   `toml:"autocert_http_address"`
+	BaseDir              string   `toml:"base_dir"`
+	BindAddress          string   `toml:"bind_address"`
+	Certificate          string   `toml:"certificate"`
+	CertificateKey       string   `toml:"certificate_key"`
+	DevMode              bool     `toml:"dev_mode"`
+	DownloadRateKB       int      `toml:"download_rate_kb"`
+	DownloadTokenLimit   int      `toml:"download_token_limit"`
+	DownloadTokens       bool     `toml:"download_tokens"`
+	DownloadTokensPerIP  int      `toml:"download_tokens_per_ip"`
+	DownloadUserRateKB   int      `toml:"download_user_rate_kb"`
+	PlainHTTP            bool     `toml:"plain_http"`
+	RateLimit            int      `toml:"rate_limit"`
+	RequestLog           string   `toml:"request_log"`
+	RequestLogFormat     string   `toml:"request_log_format"`
+	RequestLogKeep       int      `toml:"request_log_keep"`
+	RequestLogMaxSizeMB  int64    `toml:"request_log_max_size_mb"`
+	RequestLogRotate     string   `toml:"request_log_rotate"`
+	RequestLogSyslog     string   `toml:"request_log_syslog"`
+	SecretsKey           string   `toml:"secrets_key"`
+	ServerName           string   `toml:"server_name"`
+	SessionStorePassword string   `toml:"session_store_password"`
+	TrustedProxies       []string `toml:"trusted_proxies"`
+	WebsiteName          string   `toml:"website_name"`
 }
 
 // End of configuration file types
@@ -184,18 +483,94 @@ type ActivityStats struct {
 	Viewed    []ActivityRow
 }
 
+// A user account, as shown in the admin user list
+type AdminUserEntry struct {
+	DateJoined  time.Time
+	DisplayName string
+	Email       string
+	NumProjects int
+	QuotaUsed   int64
+	Suspended   bool
+	UserName    string
+}
+
+// A global webhook added by a site admin, which is told about the events it's for happening anywhere on the site
+type AdminWebhookEntry struct {
+	AddedBy   string
+	DateAdded time.Time
+	Events    []string
+	ID        int64
+	LastError string
+	LastSent  time.Time
+	Secret    string
+	URL       string
+}
+
+// A kind of site wide event global webhooks can be told about
+type AdminWebhookEvent struct {
+	ID   string
+	Name string
+}
+
+// The result of the most recent re-analysis of a project's file.  A zero Date means it's not been re-analysed yet
+type AnalysisResult struct {
+	Date    time.Time
+	Problem string
+}
+
+type Announcement struct {
+	CreatedBy string
+	End       time.Time
+	ID        int64
+	Message   string
+	Start     time.Time
+}
+
+type AuditLogEntry struct {
+	Action    string
+	AdminUser string
+	Details   string
+	ID        int64
+	Target    string
+	Timestamp time.Time
+}
+
 type Auth0Set struct {
 	CallbackURL string
 	ClientID    string
 	Domain      string
 }
 
+// A run of the scheduled (or admin requested) backups, and how it went.  Runs which haven't finished yet have a zero
+// DateFinished
+type BackupRun struct {
+	DateFinished time.Time
+	DateQueued   time.Time
+	DateStarted  time.Time
+	Error        string
+	ID           int64
+	NumObjects   int
+	ObjectName   string
+	Size         int64
+	Success      bool
+}
+
 type BranchEntry struct {
 	Commit      string `json:"commit"`
 	CommitCount int    `json:"commit_count"`
 	Description string `json:"description"`
 }
 
+type Category struct {
+	Depth    int    `json:"depth"`
+	ID       int64  `json:"id"`
+	Name     string `json:"name"`
+	ParentID int64  `json:"parent_id"`
+	Path     string `json:"path"`
+	Slug     string `json:"slug"`
+	SlugPath string `json:"slug_path"`
+}
+
 type CommitData struct {
 	AuthorAvatar   string    `json:"author_avatar"`
 	AuthorEmail    string    `json:"author_email"`
@@ -220,6 +595,28 @@ type CommitEntry struct {
 	Tree           DBTree    `json:"tree"`
 }
 
+// A user's export of all of their data.  Status is one of "queued", "running", "done", or "failed".
+type DataExportEntry struct {
+	DateFinished  time.Time
+	DateRequested time.Time
+	Error         string
+	Expires       time.Time
+	ID            int64
+	ObjectName    string
+	Size          int64
+	Status        string
+	UserName      string
+}
+
+// A format for showing dates.  The front end formats dates itself, going by the ID, so the layouts here are only used
+// for dates formatted on the server
+type DateFormat struct {
+	DateLayout string
+	ID         string
+	Name       string // An example of a date in the format
+	TimeLayout string
+}
+
 type DataValue struct {
 	Name  string
 	Type  ValType
@@ -258,9 +655,11 @@ type DBTreeEntry struct {
 }
 
 type DBInfo struct {
+	Archived      bool
 	Branch        string
 	Branches      int
 	BranchList    []string
+	Category      Category
 	Commits       int
 	CommitID      string
 	Contributors  int
@@ -271,6 +670,7 @@ type DBInfo struct {
 	DefaultTable  string
 	Discussions   int
 	Downloads     int
+	Draft         bool
 	Folder        string
 	Forks         int
 	FullDesc      string
@@ -279,7 +679,9 @@ type DBInfo struct {
 	LicenceURL    string
 	MRs           int
 	OneLineDesc   string
+	ProjectTags   []string
 	Public        bool
+	Readme        string // Rendered from Markdown
 	RepoModified  time.Time
 	Releases      int
 	SHA256        string
@@ -292,6 +694,16 @@ type DBInfo struct {
 	Watchers      int
 }
 
+// An event on a watched project, waiting to be sent in a user's next email digest
+type DigestItem struct {
+	ID        int64
+	Project   string
+	Timestamp time.Time
+	Title     string
+	Type      EventType
+	URL       string
+}
+
 type DiscussionCommentType string
 
 const (
@@ -315,21 +727,75 @@ type DiscussionType int
 const (
 	DISCUSSION    DiscussionType = 0 // These are not iota, as it would be seriously bad for these numbers to change
 	MERGE_REQUEST                = 1
+	QUESTION                     = 2
 )
 
 type DiscussionEntry struct {
-	AvatarURL    string            `json:"avatar_url"`
-	Body         string            `json:"body"`
-	BodyRendered string            `json:"body_rendered"`
-	CommentCount int               `json:"comment_count"`
-	Creator      string            `json:"creator"`
-	DateCreated  time.Time         `json:"creation_date"`
-	ID           int               `json:"disc_id"`
-	LastModified time.Time         `json:"last_modified"`
-	MRDetails    MergeRequestEntry `json:"mr_details"`
-	Open         bool              `json:"open"`
-	Title        string            `json:"title"`
-	Type         DiscussionType    `json:"discussion_type"`
+	AcceptedAnswer int               `json:"accepted_answer"` // The comment ID of the accepted answer, for questions
+	AvatarURL      string            `json:"avatar_url"`
+	Body           string            `json:"body"`
+	BodyRendered   string            `json:"body_rendered"`
+	CommentCount   int               `json:"comment_count"`
+	Creator        string            `json:"creator"`
+	DateCreated    time.Time         `json:"creation_date"`
+	ID             int               `json:"disc_id"`
+	LastModified   time.Time         `json:"last_modified"`
+	MRDetails      MergeRequestEntry `json:"mr_details"`
+	Open           bool              `json:"open"`
+	Title          string            `json:"title"`
+	Type           DiscussionType    `json:"discussion_type"`
+}
+
+// The downloads of a project over a period, split into time buckets.  The buckets are the start dates (in UTC) of each
+// day, week, or month in the period, and the download counts for each file and version line up with them
+type DownloadStats struct {
+	Buckets  []time.Time            `json:"buckets"`
+	Days     int                    `json:"days"`
+	Files    []DownloadStatsFile    `json:"files"`
+	Interval string                 `json:"interval"`
+	Versions []DownloadStatsVersion `json:"versions"`
+}
+
+// The downloads of one file in a project.  Files no longer in any version of the project have an empty name
+type DownloadStatsFile struct {
+	Downloads []int64 `json:"downloads"`
+	Name      string  `json:"name"`
+	Sha256    string  `json:"sha256"`
+	Size      int64   `json:"size"`
+	Total     int64   `json:"total"`
+}
+
+// The downloads of one version (commit) of a project, along with the releases and tags pointing at it
+type DownloadStatsVersion struct {
+	CommitID  string    `json:"commit_id"`
+	Downloads []int64   `json:"downloads"`
+	Files     []string  `json:"files"`
+	Message   string    `json:"message"`
+	Releases  []string  `json:"releases"`
+	Tags      []string  `json:"tags"`
+	Timestamp time.Time `json:"timestamp"`
+	Total     int64     `json:"total"`
+}
+
+// A public project with the same file as one being uploaded, or when Identical is false a model with the same number
+// of triangles, so it's likely a copy
+type DuplicateProject struct {
+	DBName    string `json:"database_name"`
+	Identical bool   `json:"identical"`
+	Owner     string `json:"owner"`
+	URL       string `json:"url"`
+}
+
+// A change of email address waiting to be confirmed.  The address it's confirmed from is marked as confirmed, as are
+// addresses which can't receive email
+type EmailChange struct {
+	DateRequested time.Time
+	Expires       time.Time
+	NewConfirmed  bool
+	NewEmail      string
+	OldConfirmed  bool
+	OldEmail      string
+	UserName      string
 }
 
 type EventDetails struct {
@@ -353,8 +819,44 @@ const (
 	EVENT_NEW_MERGE_REQUEST           = 1
 	EVENT_NEW_COMMENT                 = 2
 	EVENT_NEW_RELEASE                 = 3
+	EVENT_NEW_VERSION                 = 4
+	EVENT_NEW_QUESTION                = 5
 )
 
+// A feature site admins can turn on or off, and who last changed it
+type FeatureFlag struct {
+	ChangedBy   string
+	DateChanged time.Time
+	Description string
+	Enabled     bool
+	ID          string
+	Name        string
+}
+
+type FeedEntry struct {
+	Author  string
+	Content string
+	ID      string
+	Title   string
+	Updated time.Time
+	URL     string
+}
+
+// A word or pattern checked for by the word filter
+type FilterWord struct {
+	AddedBy   string
+	DateAdded time.Time
+	Pattern   string
+}
+
+// A new user whose username matched the word filter, waiting for a moderator to look at it
+type FlaggedUsername struct {
+	DateFlagged time.Time
+	DateJoined  time.Time
+	Pattern     string
+	UserName    string
+}
+
 type ForkEntry struct {
 	DBName     string     `json:"database_name"`
 	Folder     string     `json:"database_folder"`
@@ -367,14 +869,167 @@ type ForkEntry struct {
 	Deleted    bool       `json:"deleted"`
 }
 
+// A GitHub repository (or a folder in one) whose 3D model files are imported as projects, and kept up to date with
+// it.  Schedule is one of "never", "hourly", or "daily"
+type GitHubImportEntry struct {
+	Branch        string
+	ID            int64
+	LastCommit    string
+	LastError     string
+	LastSynced    time.Time
+	Licence       string
+	Owner         string
+	Path          string
+	Public        bool
+	Repo          string
+	Schedule      string
+	WebhookSecret string
+}
+
+// A project created from a file in a GitHub repository, and the Git blob SHA of the file when it was last imported
+type GitHubImportFile struct {
+	BlobSHA  string
+	FileName string
+}
+
+// Where a project is mirrored to on GitHub.  PushedCommits maps the IDs of the commits pushed so far to the matching
+// GitHub commits, and PushedReleases maps the release names to the GitHub commits they were tagged on.  The token
+// is never shown once it's been saved
+type GitHubMirrorEntry struct {
+	Branch         string
+	LastCommit     string
+	LastError      string
+	LastPushed     time.Time
+	Path           string
+	PushedCommits  map[string]string
+	PushedReleases map[string]string
+	Repo           string
+	Token          string
+}
+
+// A file sent to a project through a guest upload link, waiting for the owner to approve or reject it
+type GuestUploadEntry struct {
+	DateUploaded time.Time
+	Email        string
+	ID           int64
+	Message      string
+	Name         string
+	Sha256       string
+	Size         int64
+}
+
+// A link letting people without an account send a file to a project, until it expires
+type GuestUploadLink struct {
+	DateCreated time.Time
+	Expiry      time.Time
+	ID          int64
+	Token       string
+}
+
+type HeldCommentEntry struct {
+	Body        string
+	Commenter   string
+	DateCreated time.Time
+	DBName      string
+	DiscID      int
+	DiscType    DiscussionType
+	Folder      string
+	ID          int64
+	Owner       string
+	Reason      string
+	Title       string
+}
+
+// An account which hasn't been used for a while, and has nothing on the site, so its username could be reclaimed.
+// LastSeen is zero when the user hasn't been seen since we started keeping track
+type InactiveAccount struct {
+	DateJoined time.Time
+	Email      string
+	LastSeen   time.Time
+	UserName   string
+}
+
+type IPRule struct {
+	Action      string
+	CreatedBy   string
+	DateCreated time.Time
+	ID          int64
+	Network     string
+	Reason      string
+}
+
+type JobEntry struct {
+	Attempts    int
+	DateCreated time.Time
+	DateUpdated time.Time
+	ID          int64
+	LastError   string
+	MaxAttempts int
+	Payload     string
+	Priority    int
+	Status      string
+	Type        string
+}
+
+type JobTypeCount struct {
+	Dead    int
+	Queued  int
+	Running int
+	Type    string
+}
+
 type LicenceEntry struct {
 	FileFormat string `json:"file_format"`
 	FullName   string `json:"full_name"`
 	Order      int    `json:"order"`
 	Sha256     string `json:"sha256"`
+	SPDX       string `json:"spdx_id,omitempty"`
 	URL        string `json:"url"`
 }
 
+// A problem found by a licence compliance report, where the licence of Target doesn't meet the terms of the licence
+// of Source (a project or file it was remixed from).  Warnings are for licences which couldn't be checked
+type LicenceProblem struct {
+	Reason  string
+	Source  string
+	Target  string
+	Warning bool
+}
+
+// The remix chain of a project, with the licence of everything in it and any problems found between them
+type LicenceReport struct {
+	Nodes    []LicenceReportNode
+	Problems []LicenceProblem
+}
+
+// A project (or file elsewhere) in the remix chain of a licence compliance report.  Private projects the viewer
+// can't see are listed without their details
+type LicenceReportNode struct {
+	Depth    int
+	External bool
+	Fork     bool
+	Hidden   bool
+	Licence  string
+	Name     string
+	URL      string
+}
+
+type LiveUpdate struct {
+	Data   interface{}    `json:"data"`
+	DBName string         `json:"database_name"`
+	Folder string         `json:"database_folder"`
+	Owner  string         `json:"database_owner"`
+	Type   LiveUpdateType `json:"update_type"`
+}
+
+type LiveUpdateType string
+
+const (
+	LIVE_COMMENT LiveUpdateType = "comment"
+	LIVE_STARS   LiveUpdateType = "stars"
+	LIVE_UPLOAD  LiveUpdateType = "upload"
+)
+
 type MergeRequestState int
 
 const (
@@ -397,6 +1052,8 @@ type MergeRequestEntry struct {
 type MetaInfo struct {
 	AvatarURL        string
 	Database         string
+	DateFormat       string
+	FeedURL          string
 	ForkDatabase     string
 	ForkDeleted      bool
 	ForkFolder       string
@@ -406,10 +1063,197 @@ type MetaInfo struct {
 	Owner            string
 	Protocol         string
 	Server           string
+	Theme            Theme
+	TimeZone         string
 	Title            string
 	WebsiteName      string
 }
 
+// The details of a project a mobile app needs for showing it, bundled together so they only take one request
+type MobileProject struct {
+	Archived       bool        `json:"archived"`
+	Category       string      `json:"category,omitempty"`
+	CommitID       string      `json:"commit_id"`
+	Description    string      `json:"description,omitempty"`
+	Downloads      int         `json:"downloads"`
+	Forks          int         `json:"forks"`
+	LastModified   time.Time   `json:"last_modified"`
+	Licence        string      `json:"licence"`
+	LicenceSPDX    string      `json:"licence_spdx"`
+	LicenceURL     string      `json:"licence_url,omitempty"`
+	Model          MobileModel `json:"model"`
+	Name           string      `json:"name"`
+	OneLineDesc    string      `json:"one_line_description,omitempty"`
+	Owner          string      `json:"owner"`
+	OwnerAvatarURL string      `json:"owner_avatar_url,omitempty"`
+	Public         bool        `json:"public"`
+	Starred        bool        `json:"starred"`
+	Stars          int         `json:"stars"`
+	Tags           []string    `json:"tags"`
+	URL            string      `json:"url"`
+	Watching       bool        `json:"watching"`
+}
+
+// The model file of a project, as given to mobile apps
+type MobileModel struct {
+	FileName      string `json:"file_name"`
+	Format        string `json:"format"`
+	SHA256        string `json:"sha256"`
+	Size          int64  `json:"size"`
+	TriangleCount int64  `json:"triangle_count"`
+	URL           string `json:"url"`
+}
+
+// The details embedded in a model file by whatever created it (eg an STL header, or 3MF metadata)
+type ModelMetadata struct {
+	Comment     string
+	Copyright   string
+	Creator     string
+	Description string
+	Software    string
+	Title       string
+}
+
+type ModerationEntry struct {
+	DateCreated time.Time
+	DBName      string
+	Folder      string
+	OneLineDesc string
+	Owner       string
+	Public      bool
+	Reports     []ModerationReport
+	Status      string
+}
+
+type ModerationReport struct {
+	Automatic    bool
+	DateReported time.Time
+	Reason       string
+	Reporter     string
+}
+
+// A kind of activity users can be notified about
+type NotificationEvent struct {
+	Name string
+	Type EventType
+}
+
+// Whether a user wants to hear about a kind of activity by email (including digests), and in the status updates
+// shown on the site
+type NotificationPref struct {
+	Email bool
+	InApp bool
+	Name  string
+	Type  EventType
+}
+
+// The checksums of a stored file, as lower case hex
+type ObjectChecksums struct {
+	BLAKE3 string `json:"blake3"`
+	MD5    string `json:"md5"`
+	SHA1   string `json:"sha1"`
+	SHA256 string `json:"sha256"`
+}
+
+// The OctoPrint instance a user has linked, for sending models to.  APIKey is encrypted
+type OctoPrintLink struct {
+	APIKey     []byte
+	DateLinked time.Time
+	LastUsed   time.Time
+	URL        string
+}
+
+// A request for a project, as shown in its owner's access log.  Kind is "download", "view", or "api", and the client
+// address has been anonymised
+type ProjectAccess struct {
+	Client    string
+	Kind      string
+	Method    string
+	Path      string
+	Status    int
+	Time      time.Time
+	UserAgent string
+}
+
+type ProjectDayStats struct {
+	Date      time.Time
+	Downloads int64
+	Stars     int64
+	Views     int64
+}
+
+// The downloads of one version of a project's file on a day
+type ProjectDownloadCount struct {
+	Date      time.Time
+	Downloads int64
+	Sha256    string
+}
+
+type ProjectMetadata struct {
+	Archived     bool      `json:"archived"`
+	CommitID     string    `json:"commit_id"`
+	Error        string    `json:"error,omitempty"`
+	LastModified time.Time `json:"last_modified"`
+	Licence      string    `json:"licence"`
+	LicenceSPDX  string    `json:"licence_spdx"`
+	LicenceURL   string    `json:"licence_url"`
+	Size         int64     `json:"size"`
+	Stars        int       `json:"stars"`
+}
+
+// Identifies a project, for background jobs working through a list of them
+type ProjectRef struct {
+	DBName string `json:"file_name"`
+	Folder string `json:"folder"`
+	Owner  string `json:"owner"`
+}
+
+// A template for new projects, which fills in their README, licence, category, and so on.  Instance templates are set
+// up by the site admins for everyone to use, while the others belong to the user who made them
+type ProjectTemplate struct {
+	Category      int64    `json:"category"`
+	CategoryPath  string   `json:"category_path"`
+	Instance      bool     `json:"instance"`
+	Licence       string   `json:"licence"`
+	Name          string   `json:"name"`
+	OneLineDesc   string   `json:"one_line_description"`
+	PrintSettings string   `json:"print_settings"`
+	Readme        string   `json:"readme"`
+	Tags          []string `json:"tags"`
+}
+
+// A request to move a project to another user, waiting for them to accept it.  ID is the ID of the project
+type ProjectTransfer struct {
+	DateRequested time.Time
+	FileName      string
+	Folder        string
+	ID            int64
+	Owner         string
+	Public        bool
+	To            string
+}
+
+// A translation of a project's descriptions and README into another language.  Empty fields haven't been translated,
+// so the project's own one is used in their place
+type ProjectTranslation struct {
+	FullDesc     string    `json:"full_description"`
+	Language     string    `json:"language"`
+	LastModified time.Time `json:"last_modified"`
+	OneLineDesc  string    `json:"one_line_description"`
+	Readme       string    `json:"readme"`
+}
+
+type PublicEvent struct {
+	Actor     string    `json:"actor"`
+	DBName    string    `json:"database_name"`
+	Detail    string    `json:"detail"`
+	ID        string    `json:"event_id"`
+	Owner     string    `json:"database_owner"`
+	Timestamp time.Time `json:"event_timestamp"`
+	Type      string    `json:"event_type"`
+	URL       string    `json:"event_url"`
+}
+
 // When SQLite data is prepared for sending to Redash (as JSON), the RedashColumnMeta and RedashTableData structures
 // are used to hold it
 type RedashColumnMeta struct {
@@ -423,15 +1267,175 @@ type RedashTableData struct {
 	Rows    []map[string]interface{} `json:"rows"`
 }
 
+type ReferrerCount struct {
+	Hits int64
+	Site string
+}
+
+// A project whose model is rendered from an OpenSCAD source file, with an inbound webhook (eg called from CI) that
+// renders it again and commits the result as a new version.  ID is the ID of the project.
+type RegenerationHookEntry struct {
+	FileName   string
+	Folder     string
+	ID         int64
+	LastCommit string
+	LastError  string
+	LastRun    time.Time
+	Owner      string
+	SourceURL  string
+	Token      string
+}
+
+type RelatedProject struct {
+	DBName      string   `json:"database_name"`
+	OneLineDesc string   `json:"description"`
+	Owner       string   `json:"owner"`
+	ProjectTags []string `json:"tags"`
+	Score       int      `json:"score"`
+	Stars       int      `json:"stars"`
+	URL         string   `json:"url"`
+}
+
 type ReleaseEntry struct {
 	Commit        string    `json:"commit"`
 	Date          time.Time `json:"date"`
 	Description   string    `json:"description"`
+	LicenceSPDX   string    `json:"licence_spdx,omitempty"`
 	ReleaserEmail string    `json:"email"`
 	ReleaserName  string    `json:"name"`
 	Size          int64     `json:"size"`
 }
 
+// A project here, or a file elsewhere, which a project declares it was remixed from.  The URL and Licence are only
+// for files elsewhere, as projects here use the licence of their latest version
+type RemixSource struct {
+	DateAdded time.Time
+	FileName  string
+	Folder    string
+	ID        int64
+	Licence   string
+	Owner     string
+	URL       string
+}
+
+// A username pattern reserved by a site admin.  Matches is the number of existing users whose names match it
+type ReservedUsername struct {
+	AddedBy   string
+	DateAdded time.Time
+	Matches   int
+	Pattern   string
+	Reason    string
+}
+
+// A project waiting to be made public, or a release waiting to be published, at a time chosen by the owner.  Release
+// is empty when it's the project itself
+type ScheduledPublication struct {
+	FileName    string       `json:"file_name"`
+	Folder      string       `json:"folder"`
+	Owner       string       `json:"owner"`
+	PublishAt   time.Time    `json:"publish_at"`
+	Release     string       `json:"release,omitempty"`
+	ReleaseInfo ReleaseEntry `json:"release_info"`
+}
+
+// A column of a table or view, as returned by the schema endpoint
+type SchemaColumn struct {
+	DataType   string `json:"type"`
+	Default    string `json:"default,omitempty"`
+	Name       string `json:"name"`
+	NotNull    bool   `json:"not_null"`
+	PrimaryKey int    `json:"primary_key,omitempty"` // Position in the primary key, starting from 1
+}
+
+type SchemaForeignKey struct {
+	Columns    []string `json:"columns"`
+	RefColumns []string `json:"ref_columns"`
+	RefTable   string   `json:"ref_table"`
+}
+
+type SchemaIndex struct {
+	Columns []string `json:"columns"`
+	Name    string   `json:"name"`
+	Unique  bool     `json:"unique"`
+}
+
+// The structure of a table or view in a SQLite database
+type SchemaTable struct {
+	Columns     []SchemaColumn     `json:"columns"`
+	ForeignKeys []SchemaForeignKey `json:"foreign_keys"`
+	Indexes     []SchemaIndex      `json:"indexes"`
+	Name        string             `json:"name"`
+	Type        string             `json:"type"` // "table" or "view"
+}
+
+// A single project, in the form it's handed to an external search engine
+type SearchDocument struct {
+	Category      string    `json:"category"`
+	DateCreated   time.Time `json:"date_created"`
+	DBName        string    `json:"db_name"`
+	Downloads     int       `json:"downloads"`
+	Folder        string    `json:"folder"`
+	FullDesc      string    `json:"full_description"`
+	LastModified  time.Time `json:"last_modified"`
+	Licence       string    `json:"licence"`
+	LicenceURL    string    `json:"licence_url"`
+	OneLineDesc   string    `json:"one_line_description"`
+	Owner         string    `json:"owner"`
+	ProjectTags   []string  `json:"project_tags"`
+	Public        bool      `json:"public"`
+	Stars         int       `json:"stars"`
+	TriangleCount int64     `json:"triangle_count"`
+}
+
+type SearchFilters struct {
+	Category string // Slug path of the category, eg "gadgets/enclosures".  Includes sub-categories.
+	Format   string // File extension, eg "stl"
+	Licence  string
+	MinTris  int64
+	Owner    string
+	Sort     SortOrder // Empty means best matches first
+	Stars    int
+	StarsOp  string // One of "=", ">", ">=", "<", "<=".  Empty when not filtering on stars.
+	Tag      string
+	Text     string // The search text, with any qualifiers removed
+}
+
+type SearchResult struct {
+	DBName            string    `json:"database_name"`
+	DescHighlight     string    `json:"description_highlight"`
+	FullDescHighlight string    `json:"full_description_highlight"`
+	LastModified      time.Time `json:"last_modified"`
+	Licence           string    `json:"licence"`
+	LicenceURL        string    `json:"licence_url"`
+	OneLineDesc       string    `json:"description"`
+	Owner             string    `json:"owner"`
+	ProjectTags       []string  `json:"tags"`
+	Rank              float32   `json:"rank"`
+	Stars             int       `json:"stars"`
+	URL               string    `json:"url"`
+}
+
+// A short URL for a project, or a specific version of it when CommitID is set.  Clicks counts the number of times
+// it's been followed
+type ShortURLEntry struct {
+	Clicks      int64     `json:"clicks"`
+	Code        string    `json:"code"`
+	CommitID    string    `json:"commit_id,omitempty"`
+	CreatedBy   string    `json:"created_by,omitempty"`
+	DateCreated time.Time `json:"date_created"`
+	FileName    string    `json:"-"`
+	Folder      string    `json:"-"`
+	LastClick   time.Time `json:"last_click"`
+	Owner       string    `json:"-"`
+	URL         string    `json:"url"`
+}
+
+// A service users can link to from their profile
+type SocialService struct {
+	ID   string
+	Name string
+}
+
 type SQLiteDBinfo struct {
 	Info     DBInfo
 	MaxRows  int
@@ -440,15 +1444,20 @@ type SQLiteDBinfo struct {
 }
 
 type SQLiteRecordSet struct {
-	ColCount  int
-	ColNames  []string
-	Offset    int
-	Records   []DataRow
-	RowCount  int
-	SortCol   string
-	SortDir   string
-	Tablename string
-	TotalRows int
+	ColCount   int
+	ColNames   []string
+	HasNext    bool
+	HasPrev    bool
+	Limit      int
+	NextOffset int
+	Offset     int
+	PrevOffset int
+	Records    []DataRow
+	RowCount   int
+	SortCol    string
+	SortDir    string
+	Tablename  string
+	TotalRows  int
 }
 
 type StatusUpdateEntry struct {
@@ -465,6 +1474,29 @@ type TagEntry struct {
 	TaggerName  string    `json:"name"`
 }
 
+// A tip or donation link, as shown on profile and project pages
+type TipLink struct {
+	Name string
+	URL  string
+}
+
+// A service users can be sent tips or donations through, and the hosts its links can point at
+type TipService struct {
+	Hosts []string
+	ID    string
+	Name  string
+}
+
+// An inactive account whose user has been told its username will be reclaimed, unless they use the site before the
+// grace period ends
+type UsernameReclamation struct {
+	DateNotified time.Time
+	ReclaimAfter time.Time
+	Reason       string
+	RequestedBy  string
+	UserName     string
+}
+
 type UploadRow struct {
 	DBName     string    `json:"dbname"`
 	Owner      string    `json:"owner"`
@@ -491,6 +1523,29 @@ type UserDetails struct {
 	Email       string
 	Password    string
 	PHash       []byte
+	Profile     UserProfile
 	PVerify     string
 	Username    string
 }
+
+// The optional details a user can add to their public profile
+type UserProfile struct {
+	Bio         string
+	Location    string
+	SocialLinks map[string]string // Service ID -> URL.  The services are those in SocialServices
+	TipLinks    map[string]string // Service ID -> URL.  The services are those in TipServices
+	Website     string
+}
+
+// The page a project's wiki starts at
+const WikiHomePage = "Home"
+
+// One revision of a page in a project's wiki.  Page lists and histories leave the Body empty
+type WikiRevision struct {
+	Author   string
+	Body     string
+	Date     time.Time
+	PageName string
+	Revision int
+	Summary  string
+}
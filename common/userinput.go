@@ -4,11 +4,11 @@ package common
 import (
 	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // Extracts a database name from GET or POST/PUT data.
@@ -26,9 +26,10 @@ func GetDatabase(r *http.Request, allowGet bool) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	fileName = NormaliseName(fileName)
 	err = ValidateFileName(fileName)
 	if err != nil {
-		log.Printf("Validation failed for database name '%s': %s", fileName, err)
+		Log.Errorf("Validation failed for database name '%s': %s", fileName, err)
 		return "", errors.New("Invalid database name")
 	}
 	return fileName, nil
@@ -56,7 +57,7 @@ func GetFolder(r *http.Request, allowGet bool) (string, error) {
 	}
 	err = ValidateFolder(folder)
 	if err != nil {
-		log.Printf("Validation failed for folder: '%s': %s", folder, err)
+		Log.Errorf("Validation failed for folder: '%s': %s", folder, err)
 		return "", err
 	}
 
@@ -83,6 +84,19 @@ func GetFormBranch(r *http.Request) (string, error) {
 	return b, nil
 }
 
+// Return the requested category ID, from form data.  Returns 0 if no category was given.
+func GetFormCategory(r *http.Request) (int64, error) {
+	c := r.FormValue("category")
+	if c == "" {
+		return 0, nil
+	}
+	catID, err := strconv.ParseInt(c, 10, 64)
+	if err != nil || catID < 0 {
+		return 0, errors.New("Invalid category")
+	}
+	return catID, nil
+}
+
 // Return the requested database commit, from form data.
 func GetFormCommit(r *http.Request) (string, error) {
 	// If no commit was given in the input, returns an empty string
@@ -97,6 +111,53 @@ func GetFormCommit(r *http.Request) (string, error) {
 	return c, nil
 }
 
+// Returns the date format chosen in a form.  The default format is used if none was given.
+func GetFormDateFormat(r *http.Request) (string, error) {
+	f := r.PostFormValue("dateformat")
+	if f == "" {
+		return DateFormats[0].ID, nil
+	}
+	for _, d := range DateFormats {
+		if d.ID == f {
+			return f, nil
+		}
+	}
+	return "", errors.New("Invalid date format")
+}
+
+// Returns the time bucket size and number of days (if any) requested for download statistics.  Defaults to daily
+// buckets for the last 30 days.
+func GetFormDownloadStatsPeriod(r *http.Request) (interval string, days int, err error) {
+	interval, days = "day", 30
+	switch i := r.FormValue("interval"); i {
+	case "":
+	case "day", "week", "month":
+		interval = i
+	default:
+		return "", 0, errors.New("Invalid interval.  It needs to be one of day, week, or month")
+	}
+	if d := r.FormValue("days"); d != "" {
+		days, err = strconv.Atoi(d)
+		if err != nil || days < 1 || days > 730 {
+			return "", 0, errors.New("Invalid number of days.  It needs to be between 1 and 730")
+		}
+	}
+	return interval, days, nil
+}
+
+// Returns how often the user wants activity emails, as chosen in a form.  Emails are sent straight away if nothing was
+// chosen.
+func GetFormEmailFrequency(r *http.Request) (EmailFrequency, error) {
+	f := EmailFrequency(r.PostFormValue("emailfrequency"))
+	switch f {
+	case "":
+		return EMAIL_INSTANT, nil
+	case EMAIL_DAILY, EMAIL_INSTANT, EMAIL_NEVER, EMAIL_WEEKLY:
+		return f, nil
+	}
+	return "", errors.New("Invalid email frequency")
+}
+
 // Returns the licence name (if any) present in the form data
 func GetFormLicence(r *http.Request) (licenceName string, err error) {
 	// If no licence name given, return an empty string
@@ -108,7 +169,7 @@ func GetFormLicence(r *http.Request) (licenceName string, err error) {
 	// Validate the licence name
 	err = ValidateLicence(l)
 	if err != nil {
-		log.Printf("Validation failed for licence: '%s': %s", l, err)
+		Log.Errorf("Validation failed for licence: '%s': %s", l, err)
 		return "", err
 	}
 	licenceName = l
@@ -116,6 +177,44 @@ func GetFormLicence(r *http.Request) (licenceName string, err error) {
 	return licenceName, nil
 }
 
+// Returns which kinds of activity the user wants to hear about, and how, from the checkboxes in a form.
+func GetFormNotificationPrefs(r *http.Request) (prefs []NotificationPref) {
+	for _, e := range NotificationEvents {
+		prefs = append(prefs, NotificationPref{
+			Email: r.PostFormValue(fmt.Sprintf("notifyemail%d", e.Type)) == "true",
+			InApp: r.PostFormValue(fmt.Sprintf("notifyinapp%d", e.Type)) == "true",
+			Name:  e.Name,
+			Type:  e.Type,
+		})
+	}
+	return
+}
+
+// Returns the (optional) time something should be published at, from the "publishat" form field.  The page fills it
+// in as an RFC 3339 timestamp, though a plain date and time (eg from a datetime-local input) is taken to be in the
+// user's time zone, or UTC if they haven't chosen one.  It needs to be in the future, and less than a year away.
+func GetFormPublishAt(r *http.Request, timeZone string) (publishAt time.Time, err error) {
+	p := r.PostFormValue("publishat")
+	if p == "" {
+		return
+	}
+	publishAt, err = time.Parse(time.RFC3339, p)
+	if err != nil {
+		loc := time.UTC
+		if l, err := time.LoadLocation(timeZone); timeZone != "" && err == nil {
+			loc = l
+		}
+		publishAt, err = time.ParseInLocation("2006-01-02T15:04", p, loc)
+		if err != nil {
+			return time.Time{}, errors.New("Invalid publishing time")
+		}
+	}
+	if !publishAt.After(time.Now()) || publishAt.After(time.Now().AddDate(1, 0, 0)) {
+		return time.Time{}, errors.New("The publishing time needs to be in the future, and less than a year away")
+	}
+	return publishAt, nil
+}
+
 // Returns the source URL (if any) present in the form data
 func GetFormSourceURL(r *http.Request) (sourceURL string, err error) {
 	// Validate the source URL
@@ -131,6 +230,67 @@ func GetFormSourceURL(r *http.Request) (sourceURL string, err error) {
 	return sourceURL, err
 }
 
+// Returns the (validated) list of project tags from POST data.  The tags are given as a comma separated list, and are
+// converted to lower case with any duplicates removed.
+func GetFormProjectTags(r *http.Request) (tags []string, err error) {
+	seen := make(map[string]bool)
+	for _, t := range strings.Split(r.PostFormValue("tags"), ",") {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == "" || seen[t] {
+			continue
+		}
+		err = ValidateProjectTag(t)
+		if err != nil {
+			Log.Errorf("Validation failed for project tag '%s': %s", t, err)
+			return nil, errors.New("Invalid tag.  Tags can only contain lower case letters, numbers, and dashes")
+		}
+		seen[t] = true
+		tags = append(tags, t)
+	}
+	if len(tags) > MaxProjectTags {
+		return nil, fmt.Errorf("Too many tags.  A project can have at most %d", MaxProjectTags)
+	}
+	return tags, nil
+}
+
+// Returns the (validated) project template from POST data.  Everything besides the name is optional.  The licence
+// isn't checked to exist.
+func GetFormProjectTemplate(r *http.Request) (t ProjectTemplate, err error) {
+	t.Name = strings.TrimSpace(r.PostFormValue("templatename"))
+	err = ValidateTemplateName(t.Name)
+	if err != nil {
+		return t, errors.New("Template names can be up to 60 letters, numbers, spaces, and simple punctuation")
+	}
+	t.OneLineDesc = r.PostFormValue("onelinedesc")
+	if t.OneLineDesc != "" {
+		if err = ValidateOneLineDescription(t.OneLineDesc); err != nil {
+			return t, errors.New("Invalid one line description")
+		}
+	}
+	t.Readme = r.PostFormValue("readme")
+	if t.Readme != "" {
+		if err = ValidateReadme(t.Readme); err != nil {
+			return t, errors.New("Invalid README")
+		}
+	}
+	t.PrintSettings = r.PostFormValue("printsettings")
+	if t.PrintSettings != "" {
+		if err = ValidateReadme(t.PrintSettings); err != nil {
+			return t, errors.New("Invalid print settings")
+		}
+	}
+	t.Licence, err = GetFormLicence(r)
+	if err != nil {
+		return t, errors.New("Invalid licence")
+	}
+	t.Category, err = GetFormCategory(r)
+	if err != nil {
+		return
+	}
+	t.Tags, err = GetFormProjectTags(r)
+	return
+}
+
 // Return the requested release name, from get or post data.
 func GetFormRelease(r *http.Request) (release string, err error) {
 	// If no release was given in the input, returns an empty string
@@ -151,6 +311,50 @@ func GetFormRelease(r *http.Request) (release string, err error) {
 	return c, nil
 }
 
+// Returns the (validated) project a project was remixed from, from POST data.  It can be given as "owner/project" or a
+// link to the project on this server, for projects here, or as a link plus its licence for ones elsewhere.  Projects
+// here aren't checked to exist.
+func GetFormRemixSource(r *http.Request) (src RemixSource, err error) {
+	source := strings.TrimSpace(r.PostFormValue("source"))
+	l := strings.ToLower(source)
+	if strings.HasPrefix(l, "http://") || strings.HasPrefix(l, "https://") {
+		u, err := url.Parse(source)
+		if err != nil {
+			return RemixSource{}, errors.New("Invalid link")
+		}
+		if !strings.EqualFold(u.Host, Conf().Web.ServerName) {
+			// A project elsewhere, so its licence needs to be given too
+			if err = Validate.Var(source, "url,max=255"); err != nil {
+				return RemixSource{}, errors.New("Invalid link")
+			}
+			src.URL = source
+			src.Licence = r.PostFormValue("licence")
+			for _, lic := range RemixLicences {
+				if lic == src.Licence {
+					return src, nil
+				}
+			}
+			return RemixSource{}, errors.New("Unknown licence")
+		}
+		source = u.Path
+	}
+
+	// A project here
+	parts := strings.Split(strings.Trim(source, "/"), "/")
+	if len(parts) != 2 {
+		return RemixSource{}, errors.New("Projects here need to be given as owner/project")
+	}
+	if err = ValidateUser(parts[0]); err != nil {
+		return RemixSource{}, errors.New("Invalid owner name")
+	}
+	parts[1] = NormaliseName(parts[1])
+	if err = ValidateFileName(parts[1]); err != nil {
+		return RemixSource{}, errors.New("Invalid project name")
+	}
+	src.Owner, src.Folder, src.FileName = parts[0], "/", parts[1]
+	return src, nil
+}
+
 // Return the requested tag name, from get or post data.
 func GetFormTag(r *http.Request) (tag string, err error) {
 	// If no tag was given in the input, returns an empty string
@@ -171,6 +375,34 @@ func GetFormTag(r *http.Request) (tag string, err error) {
 	return c, nil
 }
 
+// Returns the colour theme chosen in a form.  The automatic theme is used if none was given.
+func GetFormTheme(r *http.Request) (Theme, error) {
+	t := Theme(r.PostFormValue("theme"))
+	switch t {
+	case "":
+		return THEME_AUTO, nil
+	case THEME_AUTO, THEME_DARK, THEME_LIGHT:
+		return t, nil
+	}
+	return "", errors.New("Invalid theme")
+}
+
+// Returns the timezone chosen in a form, as an IANA timezone name (eg "Europe/Berlin").  An empty string means the
+// timezone of the user's browser is used.
+func GetFormTimeZone(r *http.Request) (string, error) {
+	tz := strings.TrimSpace(r.PostFormValue("timezone"))
+	if tz == "" {
+		return "", nil
+	}
+	if len(tz) > 64 {
+		return "", errors.New("Timezone name is too long")
+	}
+	if _, err := time.LoadLocation(tz); err != nil {
+		return "", errors.New("Unknown timezone")
+	}
+	return tz, nil
+}
+
 // Return the username, database, and commit (if any) present in the form data.
 func GetFormUDC(r *http.Request) (string, string, string, error) {
 	// Extract the username
@@ -194,6 +426,85 @@ func GetFormUDC(r *http.Request) (string, string, string, error) {
 	return userName, fileName, commitID, nil
 }
 
+// Returns the (validated) public profile details from POST data.  Links need to be http or https URLs, as they're
+// shown on the profile page.
+func GetFormUserProfile(r *http.Request) (profile UserProfile, err error) {
+	profile.Bio = strings.TrimSpace(r.PostFormValue("bio"))
+	profile.Location = strings.TrimSpace(r.PostFormValue("location"))
+	profile.Website = strings.TrimSpace(r.PostFormValue("website"))
+	if err = Validate.Var(profile.Bio, "max=500"); err != nil {
+		return UserProfile{}, errors.New("Validation failed for bio field")
+	}
+	if err = Validate.Var(profile.Location, "max=80"); err != nil {
+		return UserProfile{}, errors.New("Validation failed for location field")
+	}
+	checkURL := func(u string) error {
+		if u == "" {
+			return nil
+		}
+		l := strings.ToLower(u)
+		if !strings.HasPrefix(l, "http://") && !strings.HasPrefix(l, "https://") {
+			return errors.New("Not an http or https URL")
+		}
+		return Validate.Var(u, "url,max=255")
+	}
+	if err = checkURL(profile.Website); err != nil {
+		return UserProfile{}, errors.New("Validation failed for website field")
+	}
+	profile.SocialLinks = make(map[string]string)
+	for _, s := range SocialServices {
+		u := strings.TrimSpace(r.PostFormValue("social_" + s.ID))
+		if err = checkURL(u); err != nil {
+			return UserProfile{}, fmt.Errorf("Validation failed for %s link", s.Name)
+		}
+		if u != "" {
+			profile.SocialLinks[s.ID] = u
+		}
+	}
+
+	// Tip links need to point at the service they're for
+	profile.TipLinks = make(map[string]string)
+	for _, t := range TipServices {
+		u := strings.TrimSpace(r.PostFormValue("tip_" + t.ID))
+		if u == "" {
+			continue
+		}
+		if err = checkURL(u); err != nil || !tipURLAllowed(t, u) {
+			return UserProfile{}, fmt.Errorf("Validation failed for %s link", t.Name)
+		}
+		profile.TipLinks[t.ID] = u
+	}
+	return profile, nil
+}
+
+// Returns whether a tip link points at one of the hosts for its service (or a subdomain of one), over https.
+func tipURLAllowed(t TipService, link string) bool {
+	u, err := url.Parse(link)
+	if err != nil || strings.ToLower(u.Scheme) != "https" {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, h := range t.Hosts {
+		if host == h || strings.HasSuffix(host, "."+h) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the wiki page name present in the form data.  When none is given, the wiki's home page is used.
+func GetFormWikiPage(r *http.Request) (pageName string, err error) {
+	pageName = strings.TrimSpace(r.FormValue("page"))
+	if pageName == "" {
+		return WikiHomePage, nil
+	}
+	err = ValidateWikiPageName(pageName)
+	if err != nil {
+		return "", errors.New("Invalid wiki page name")
+	}
+	return
+}
+
 // Returns the requested database owner and database name.
 func GetOD(ignore_leading int, r *http.Request) (string, string, error) {
 	// Split the request URL into path components
@@ -201,11 +512,11 @@ func GetOD(ignore_leading int, r *http.Request) (string, string, error) {
 
 	// Check that at least an owner/database combination was requested
 	if len(pathStrings) < (3 + ignore_leading) {
-		log.Printf("Something wrong with the requested URL: %v\n", r.URL.Path)
+		Log.Warnf("Something wrong with the requested URL: %v", r.URL.Path)
 		return "", "", errors.New("Invalid URL")
 	}
 	owner := pathStrings[1+ignore_leading]
-	fileName := pathStrings[2+ignore_leading]
+	fileName := NormaliseName(pathStrings[2+ignore_leading])
 
 	// Validate the user supplied owner and database name
 	err := ValidateUserFilename(owner, fileName)
@@ -216,7 +527,7 @@ func GetOD(ignore_leading int, r *http.Request) (string, string, error) {
 			return "", "", errors.New("Invalid owner or database name")
 		}
 
-		log.Printf("Validation failed for owner or database name. Owner '%s', DB name '%s': %s",
+		Log.Errorf("Validation failed for owner or database name. Owner '%s', DB name '%s': %s",
 			owner, fileName, err)
 		return "", "", errors.New("Invalid owner or database name")
 	}
@@ -295,13 +606,154 @@ func GetPub(r *http.Request) (bool, error) {
 	}
 	pub, err := strconv.ParseBool(val)
 	if err != nil {
-		log.Printf("Error when converting public value to boolean: %v\n", err)
+		Log.Errorf("Error when converting public value to boolean: %v", err)
 		return false, err
 	}
 
 	return pub, nil
 }
 
+// Returns the search text, filters (project tag, category, licence, sort order, and any qualifiers in the search
+// text), and requested page number (if any) for a search.  The search text is returned as given, with the qualifiers removed
+// version of it in filters.Text.
+func GetSearchQuery(r *http.Request) (query string, filters SearchFilters, page int, err error) {
+	query = strings.TrimSpace(r.FormValue("q"))
+	err = ValidateSearchQuery(query)
+	if err != nil {
+		Log.Errorf("Validation failed for search query: %s", err)
+		return "", SearchFilters{}, 0, errors.New("Invalid search query")
+	}
+
+	// If a tag to filter on was given, validate it
+	filters.Tag = strings.ToLower(r.FormValue("tag"))
+	if filters.Tag != "" {
+		err = ValidateProjectTag(filters.Tag)
+		if err != nil {
+			Log.Errorf("Validation failed for project tag: %s", err)
+			return "", SearchFilters{}, 0, errors.New("Invalid tag")
+		}
+	}
+
+	// If a category to filter on was given, validate it
+	filters.Category = strings.Trim(strings.ToLower(r.FormValue("category")), "/")
+	if filters.Category != "" {
+		err = ValidateCategoryPath(filters.Category)
+		if err != nil {
+			Log.Errorf("Validation failed for category: %s", err)
+			return "", SearchFilters{}, 0, errors.New("Invalid category")
+		}
+	}
+
+	// If a licence to filter on was given, validate it
+	filters.Licence = r.FormValue("licence")
+	if filters.Licence != "" {
+		err = ValidateLicence(filters.Licence)
+		if err != nil {
+			Log.Errorf("Validation failed for licence: %s", err)
+			return "", SearchFilters{}, 0, errors.New("Invalid licence")
+		}
+	}
+
+	// If a sort order was given, validate it
+	filters.Sort, err = GetSortOrder(r)
+	if err != nil {
+		return "", SearchFilters{}, 0, err
+	}
+
+	// Extract any qualifiers (eg "user:justinclift stars:>10") from the search text
+	err = parseSearchQualifiers(query, &filters)
+	if err != nil {
+		return "", SearchFilters{}, 0, err
+	}
+
+	// Default to the first page of results
+	page = 1
+	if p := r.FormValue("page"); p != "" {
+		page, err = strconv.Atoi(p)
+		if err != nil || page < 1 {
+			return "", SearchFilters{}, 0, errors.New("Invalid page number")
+		}
+	}
+	return query, filters, page, nil
+}
+
+// Moves the supported qualifiers (user:, tag:, category:, licence: (or license:), format:, mintris:, stars:) out of the
+// search text and into the search filters.  Anything else is left as search text.
+func parseSearchQualifiers(query string, filters *SearchFilters) error {
+	var text []string
+	for _, word := range strings.Fields(query) {
+		i := strings.Index(word, ":")
+		if i < 1 || i == len(word)-1 {
+			text = append(text, word)
+			continue
+		}
+		key, val := strings.ToLower(word[:i]), word[i+1:]
+		switch key {
+		case "category":
+			filters.Category = strings.Trim(strings.ToLower(val), "/")
+			if ValidateCategoryPath(filters.Category) != nil {
+				return errors.New("Invalid category")
+			}
+		case "format":
+			filters.Format = strings.TrimPrefix(strings.ToLower(val), ".")
+			if Validate.Var(filters.Format, "required,alphanum,max=10") != nil {
+				return errors.New("Invalid format")
+			}
+		case "licence", "license":
+			if ValidateLicence(val) != nil {
+				return errors.New("Invalid licence")
+			}
+			filters.Licence = val
+		case "mintris":
+			n, err := strconv.ParseInt(val, 10, 64)
+			if err != nil || n < 0 {
+				return errors.New("Invalid minimum triangle count")
+			}
+			filters.MinTris = n
+		case "stars":
+			op := "="
+			for _, o := range []string{">=", "<=", ">", "<", "="} {
+				if strings.HasPrefix(val, o) {
+					op = o
+					val = strings.TrimPrefix(val, o)
+					break
+				}
+			}
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 0 {
+				return errors.New("Invalid star count")
+			}
+			filters.Stars = n
+			filters.StarsOp = op
+		case "tag":
+			filters.Tag = strings.ToLower(val)
+			if ValidateProjectTag(filters.Tag) != nil {
+				return errors.New("Invalid tag")
+			}
+		case "user":
+			if ValidateUser(val) != nil {
+				return errors.New("Invalid user name")
+			}
+			filters.Owner = val
+		default:
+			// Not a qualifier we know about, so treat it as normal search text
+			text = append(text, word)
+		}
+	}
+	filters.Text = strings.Join(text, " ")
+	return nil
+}
+
+// Return the requested sort order for a list of projects.  Returns an empty string if no sort order was given.
+func GetSortOrder(r *http.Request) (SortOrder, error) {
+	s := SortOrder(r.FormValue("sort"))
+	switch s {
+	case "", SORT_DOWNLOADS, SORT_NEWEST, SORT_STARS, SORT_UPDATED:
+		return s, nil
+	}
+	return "", errors.New("Invalid sort order")
+}
+
 // Returns the requested table name (if any).
 func GetTable(r *http.Request) (string, error) {
 	var requestedTable string
@@ -315,7 +767,7 @@ func GetTable(r *http.Request) (string, error) {
 			// If the failed table name is "{{ db.Tablename }}", don't bother logging it.  It's just a
 			// search bot picking up the AngularJS string then doing a request with it
 			if requestedTable != "{{ db.Tablename }}" {
-				log.Printf("Validation failed for table name: '%s': %s", requestedTable, err)
+				Log.Errorf("Validation failed for table name: '%s': %s", requestedTable, err)
 			}
 			return "", errors.New("Invalid table name")
 		}
@@ -370,7 +822,7 @@ func GetUsername(r *http.Request, allowGet bool) (string, error) {
 	}
 	err = ValidateUser(userName)
 	if err != nil {
-		log.Printf("Validation failed for username: %s", err)
+		Log.Errorf("Validation failed for username: %s", err)
 		return "", err
 	}
 
@@ -10,7 +10,6 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"math/rand"
 	"net/http"
 	"os"
@@ -19,6 +18,8 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	sqlite "github.com/gwenn/gosqlite"
 )
 
 // The main function which handles file upload processing for both the webUI and DB4S end points
@@ -26,12 +27,21 @@ func AddFile(r *http.Request, loggedInUser string, owner string, folder string,
 	createBranch bool, branchName string, commitID string, public bool, licenceName string, commitMsg string,
 	sourceURL string, newDB io.Reader, serverSw string, lastModified time.Time, commitTime time.Time,
 	authorName string, authorEmail string, committerName string, committerEmail string, otherParents []string,
-	fileSha string) (numBytes int64, newCommitID string, err error) {
+	fileSha string, scrubMeta bool) (numBytes int64, newCommitID string, err error) {
+
+	// Archived projects are read-only
+	archived, err := ProjectArchived(owner, folder, fileName)
+	if err != nil {
+		return 0, "", err
+	}
+	if archived {
+		return 0, "", errors.New("This project is archived, so new versions can't be added to it")
+	}
 
 	// Create a temporary file to store the uploaded file in
-	tempFile, err := ioutil.TempFile(Conf.DiskCache.Directory, "upload-")
+	tempFile, err := ioutil.TempFile(Conf().DiskCache.Directory, "upload-")
 	if err != nil {
-		log.Printf("Error creating temporary file. User: '%s', Database: '%s%s%s', Filename: '%s', Error: %v\n",
+		Log.Errorf("Error creating temporary file. User: '%s', Database: '%s%s%s', Filename: '%s', Error: %v",
 			loggedInUser, owner, folder, fileName, tempFile.Name(), err)
 		return 0, "", err
 	}
@@ -45,24 +55,69 @@ func AddFile(r *http.Request, loggedInUser string, owner string, folder string,
 	buf := make([]byte, bufSize)
 	numBytes, err = io.CopyBuffer(tempFile, newDB, buf)
 	if err != nil {
-		log.Printf("Error when writing the uploaded file to a temp file. User: '%s', File: '%s%s%s' "+
-			"Error: %v\n", loggedInUser, owner, folder, fileName, err)
+		Log.Errorf("Error when writing the uploaded file to a temp file. User: '%s', File: '%s%s%s' "+
+			"Error: %v", loggedInUser, owner, folder, fileName, err)
 		return 0, "", err
 	}
 
-	// Sanity check the uploaded file
-	ok, err := SanityCheck3DModel(tempFileName)
+	// Sanity check the uploaded file.  SQLite databases (eg ones created from an uploaded CSV file) are accepted as
+	// well as 3D models
+	var entryType DBTreeEntryType = THREE_D_MODEL
+	var meta ModelMetadata
+	var numTris int64
+	var scrubbed bool
+	isDB, err := SanityCheckDatabase(tempFileName)
 	if err != nil {
 		return 0, "", err
 	}
-	if !ok {
-		return 0, "", errors.New("Uploaded file doesn't appear to be a 3D model")
+	if isDB {
+		entryType = DATABASE
+	} else {
+		var ok bool
+		ok, numTris, err = SanityCheck3DModel(tempFileName)
+		if err != nil {
+			return 0, "", err
+		}
+		if !ok {
+			return 0, "", errors.New("Uploaded file doesn't appear to be a 3D model")
+		}
+
+		// Remove the details identifying the uploader from the model, if they've asked for that
+		if scrubMeta {
+			scrubbed, err = ScrubModelMetadata(tempFileName, fileName)
+			if err != nil {
+				return 0, "", err
+			}
+			if scrubbed {
+				var fi os.FileInfo
+				fi, err = os.Stat(tempFileName)
+				if err != nil {
+					return 0, "", err
+				}
+				numBytes = fi.Size()
+			}
+		}
+		meta = ModelFileMetadata(tempFileName, fileName)
+	}
+
+	// Make sure the upload fits within the project owner's daily quota.  It's charged now so two uploads at once can't
+	// both squeeze in, and given back if the file doesn't end up being stored
+	err = UseUploadQuota(owner, numBytes)
+	if err != nil {
+		return 0, "", err
 	}
+	stored := false
+	quotaBytes := numBytes
+	defer func() {
+		if err != nil && !stored {
+			RefundUploadQuota(owner, quotaBytes)
+		}
+	}()
 
 	// Return to the start of the temporary file
 	newOff, err := tempFile.Seek(0, 0)
 	if err != nil {
-		log.Printf("Seeking on the temporary file failed: %v\n", err.Error())
+		Log.Errorf("Seeking on the temporary file failed: %v", err.Error())
 		return 0, "", err
 	}
 	if newOff != 0 {
@@ -79,8 +134,9 @@ func AddFile(r *http.Request, loggedInUser string, owner string, folder string,
 	}
 	sha := hex.EncodeToString(s.Sum(nil))
 
-	// If we were given a SHA256 for the file, make sure it matches our calculated one
-	if fileSha != "" && fileSha != sha {
+	// If we were given a SHA256 for the file, make sure it matches our calculated one.  It's for the file as it was
+	// sent, so it can't be checked once metadata has been removed
+	if fileSha != "" && fileSha != sha && !scrubbed {
 		return 0, "",
 			fmt.Errorf("SHA256 given (%s) for uploaded file doesn't match the calculated value (%s)", fileSha, sha)
 	}
@@ -93,6 +149,13 @@ func AddFile(r *http.Request, loggedInUser string, owner string, folder string,
 	if err != err {
 		return 0, "", err
 	}
+
+	// New private projects count towards the plan the user is on
+	if !exists && !public {
+		if err = CheckPrivateQuota(loggedInUser); err != nil {
+			return 0, "", err
+		}
+	}
 	if exists {
 		// Load the existing branchHeads for the project
 		branches, err = GetBranches(loggedInUser, folder, fileName)
@@ -121,7 +184,7 @@ func AddFile(r *http.Request, loggedInUser string, owner string, folder string,
 
 	// Create a dbTree entry for the individual file
 	var e DBTreeEntry
-	e.EntryType = THREE_D_MODEL
+	e.EntryType = entryType
 	e.Name = fileName
 	e.Sha256 = sha
 	e.LastModified = lastModified.UTC()
@@ -300,7 +363,7 @@ func AddFile(r *http.Request, loggedInUser string, owner string, folder string,
 			if !ok {
 				m := fmt.Sprintf("Error when counting commits in branch '%s' of project '%s%s%s'\n", branchName,
 					loggedInUser, folder, fileName)
-				log.Print(m)
+				Log.Error(m)
 				return 0, "", errors.New(m)
 			}
 		}
@@ -309,7 +372,7 @@ func AddFile(r *http.Request, loggedInUser string, owner string, folder string,
 	// Return to the start of the temporary file again
 	newOff, err = tempFile.Seek(0, 0)
 	if err != nil {
-		log.Printf("Seeking on the temporary file (2nd time) failed: %v\n", err.Error())
+		Log.Errorf("Seeking on the temporary file (2nd time) failed: %v", err.Error())
 		return 0, "", err
 	}
 	if newOff != 0 {
@@ -326,6 +389,20 @@ func AddFile(r *http.Request, loggedInUser string, owner string, folder string,
 	if err != nil {
 		return 0, "", err
 	}
+	stored = true
+
+	// Fill in the description and README of new projects from the details embedded in their model
+	if !exists && entryType == THREE_D_MODEL {
+		prefillProjectDetails(loggedInUser, folder, fileName, meta)
+	}
+
+	// Record the triangle count of the model, if it's now the head of the default branch
+	if !exists || branchName == defBranch {
+		err = StoreTriangleCount(owner, folder, fileName, numTris)
+		if err != nil {
+			return 0, "", err
+		}
+	}
 
 	// If the file already existed, update it's contributor count
 	if exists {
@@ -369,7 +446,7 @@ func AddFile(r *http.Request, loggedInUser string, owner string, folder string,
 	err = InvalidateCacheEntry(loggedInUser, loggedInUser, "/", fileName, "") // Empty string indicates "for all versions"
 	if err != nil {
 		// Something went wrong when invalidating memcached entries for the file
-		log.Printf("Error when invalidating memcache entries: %s\n", err.Error())
+		Log.Errorf("Error when invalidating memcache entries: %s", err.Error())
 		return 0, "", err
 	}
 
@@ -377,14 +454,130 @@ func AddFile(r *http.Request, loggedInUser string, owner string, folder string,
 	err = InvalidateCacheEntry(loggedInUser, loggedInUser, folder, fileName, c.ID) // And empty string indicates "for all commits"
 	if err != nil {
 		// Something went wrong when invalidating memcached entries for any previous file
-		log.Printf("Error when invalidating memcache entries: %s\n", err.Error())
+		Log.Errorf("Error when invalidating memcache entries: %s", err.Error())
 		return 0, "", err
 	}
 
+	// Let the people watching the project know about the new version
+	if exists {
+		title := strings.TrimSpace(strings.SplitN(commitMsg, "\n", 2)[0])
+		if title == "" {
+			title = "New version"
+		}
+		err = NewEvent(EventDetails{
+			DBName:   fileName,
+			Folder:   folder,
+			Owner:    loggedInUser,
+			Title:    title,
+			Type:     EVENT_NEW_VERSION,
+			URL:      fmt.Sprintf("/%s%s%s?commit=%s", loggedInUser, folder, fileName, c.ID),
+			UserName: loggedInUser,
+		})
+		if err != nil {
+			Log.Errorf("Error when creating a new event: %s", err.Error())
+		}
+	}
+
+	// Tell the site admins' global webhooks about uploads anyone can see
+	if pub, err := ProjectPublic(loggedInUser, folder, fileName); err == nil && pub {
+		kind := "a new project"
+		if exists {
+			kind = "a new version of"
+		}
+		FireAdminWebhooks(WebhookPublicUpload, fmt.Sprintf("%s uploaded %s %s%s%s", loggedInUser, kind, loggedInUser,
+			folder, fileName), map[string]string{
+			"commit":  c.ID,
+			"message": commitMsg,
+			"project": loggedInUser + folder + fileName,
+			"url":     fmt.Sprintf("https://%s/%s%s%s?commit=%s", Conf().Web.ServerName, loggedInUser, folder, fileName, c.ID),
+			"user":    loggedInUser,
+		})
+	}
+
+	// Push the new version to GitHub, if the project is mirrored there
+	err = QueueGitHubMirror(loggedInUser, folder, fileName)
+	if err != nil {
+		Log.Errorf("Error when queuing the GitHub mirror push for '%s%s%s': %v", loggedInUser, folder, fileName, err)
+	}
+
 	// File successfully uploaded
 	return numBytes, c.ID, nil
 }
 
+// Records a change to the details of a project (eg its description) as a commit on the default branch.  The commit
+// has the same file tree as the one before it, so the project history shows when the details were changed, and by
+// whom.
+func AddMetadataCommit(loggedInUser string, owner string, folder string, fileName string, message string) error {
+	branchName, err := GetDefaultBranchName(owner, folder, fileName)
+	if err != nil {
+		return err
+	}
+	branches, err := GetBranches(owner, folder, fileName)
+	if err != nil {
+		return err
+	}
+	head, ok := branches[branchName]
+	if !ok {
+		return fmt.Errorf("Default branch '%s' not found", branchName)
+	}
+	commitList, err := GetCommitList(owner, folder, fileName)
+	if err != nil {
+		return err
+	}
+	parent, ok := commitList[head.Commit]
+	if !ok {
+		return fmt.Errorf("Head commit of branch '%s' not found", branchName)
+	}
+	usr, err := User(loggedInUser)
+	if err != nil {
+		return err
+	}
+
+	c := CommitEntry{
+		AuthorEmail: usr.Email,
+		AuthorName:  usr.DisplayName,
+		Message:     message,
+		Parent:      head.Commit,
+		Timestamp:   time.Now().UTC(),
+		Tree:        parent.Tree,
+	}
+	c.ID = CreateCommitID(c)
+	commitList[c.ID] = c
+	head.Commit = c.ID
+	head.CommitCount++
+	branches[branchName] = head
+	err = StoreCommits(owner, folder, fileName, commitList)
+	if err != nil {
+		return err
+	}
+	return StoreBranches(owner, folder, fileName, branches)
+}
+
+// Returns whether a user has an email address we can send to.  Users with the placeholder username@server email
+// address don't, as it can't receive email.
+func CanEmailUser(usr UserDetails) bool {
+	serverName := strings.Split(Conf().Web.ServerName, ":")[0]
+	return usr.Email != "" && strings.ToLower(usr.Email) != strings.ToLower(usr.Username+"@"+serverName)
+}
+
+// Returns the URL slug for a category name.  eg "Art & Sculptures" -> "art-sculptures"
+func CategorySlug(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, c := range strings.ToLower(name) {
+		if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') {
+			b.WriteRune(c)
+			dash = false
+			continue
+		}
+		if !dash && b.Len() > 0 {
+			b.WriteRune('-')
+			dash = true
+		}
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
+
 // Returns the licence used by the database in a given commit
 func CommitLicenceSHA(owner string, folder string, fileName string, commitID string) (licenceSHA string, err error) {
 	commits, err := GetCommitList(owner, folder, fileName)
@@ -398,6 +591,12 @@ func CommitLicenceSHA(owner string, folder string, fileName string, commitID str
 	return c.Tree.Entries[0].LicenceSHA, nil
 }
 
+// Returns a strong ETag value for a response body, based on the sha256 of its contents.
+func ContentETag(data []byte) string {
+	s := sha256.Sum256(data)
+	return fmt.Sprintf(`"%s"`, hex.EncodeToString(s[:]))
+}
+
 // Generate a stable SHA256 for a commit.
 func CreateCommitID(c CommitEntry) string {
 	var b bytes.Buffer
@@ -442,6 +641,21 @@ func CreateDBTreeID(entries []DBTreeEntry) string {
 	return hex.EncodeToString(s[:])
 }
 
+// Returns the commit message for a change to a project's descriptions, or an empty string if neither changed.
+func DescriptionChangeMessage(oldOneLineDesc string, newOneLineDesc string, oldFullDesc string,
+	newFullDesc string) string {
+	oneLine, full := oldOneLineDesc != newOneLineDesc, oldFullDesc != newFullDesc
+	switch {
+	case oneLine && full:
+		return "Updated the one line and full descriptions."
+	case oneLine:
+		return "Updated the one line description."
+	case full:
+		return "Updated the full description."
+	}
+	return ""
+}
+
 // Safely removes the commit history for a branch, from the head of the branch back to (but not including) the
 // specified commit.  The new branch head will be at the commit ID specified
 func DeleteBranchHistory(owner string, folder string, fileName string, branchName string, commitID string) (isolatedTags []string, isolatedRels []string, err error) {
@@ -489,7 +703,7 @@ func DeleteBranchHistory(owner string, folder string, fileName string, branchNam
 		if !ok {
 			err = fmt.Errorf("Broken commit history encountered for branch '%s' in '%s%s%s', when looking for "+
 				"commit '%s'\n", branchName, owner, folder, fileName, c.Parent)
-			log.Printf(err.Error())
+			Log.Errorf(err.Error())
 			return
 		}
 		if c.ID == commitID {
@@ -553,7 +767,7 @@ func DeleteBranchHistory(owner string, folder string, fileName string, branchNam
 			if !ok {
 				err = fmt.Errorf("Broken commit history encountered when checking for isolated tags while "+
 					"deleting commits in branch '%s' of database '%s%s%s'\n", branchName, owner, folder, fileName)
-				log.Print(err.Error()) // Broken commit history is pretty serious, so we log it for admin investigation
+				Log.Error(err.Error()) // Broken commit history is pretty serious, so we log it for admin investigation
 				return
 			}
 			for tName, tEntry := range commitTags {
@@ -570,7 +784,7 @@ func DeleteBranchHistory(owner string, folder string, fileName string, branchNam
 					err = fmt.Errorf("Broken commit history encountered when checking for isolated tags "+
 						"while deleting commits in branch '%s' of database '%s%s%s'\n", branchName, owner, folder,
 						fileName)
-					log.Print(err.Error()) // Broken commit history is pretty serious, so we log it for admin investigation
+					Log.Error(err.Error()) // Broken commit history is pretty serious, so we log it for admin investigation
 					return
 				}
 				for tName, tEntry := range commitTags {
@@ -607,7 +821,7 @@ func DeleteBranchHistory(owner string, folder string, fileName string, branchNam
 				err = fmt.Errorf("Broken commit history encountered when checking for isolated releases "+
 					"while deleting commits in branch '%s' of database '%s%s%s'\n", branchName, owner, folder,
 					fileName)
-				log.Print(err.Error()) // Broken commit history is pretty serious, so we log it for admin investigation
+				Log.Error(err.Error()) // Broken commit history is pretty serious, so we log it for admin investigation
 				return
 			}
 			for rName, rEntry := range commitRels {
@@ -624,7 +838,7 @@ func DeleteBranchHistory(owner string, folder string, fileName string, branchNam
 					err = fmt.Errorf("Broken commit history encountered when checking for isolated releases "+
 						"while deleting commits in branch '%s' of database '%s%s%s'\n", branchName, owner, folder,
 						fileName)
-					log.Print(err.Error()) // Broken commit history is pretty serious, so we log it for admin investigation
+					Log.Error(err.Error()) // Broken commit history is pretty serious, so we log it for admin investigation
 					return
 				}
 				for rName, rEntry := range commitRels {
@@ -669,7 +883,7 @@ func DeleteBranchHistory(owner string, folder string, fileName string, branchNam
 			if !ok {
 				err = fmt.Errorf("Broken commit history encountered when checking for commits to remove in "+
 					"branch '%s' of database '%s%s%s'\n", branchName, owner, folder, fileName)
-				log.Print(err.Error()) // Broken commit history is pretty serious, so we log it for admin investigation
+				Log.Error(err.Error()) // Broken commit history is pretty serious, so we log it for admin investigation
 				return
 			}
 			if c.ID == delCommit {
@@ -681,7 +895,7 @@ func DeleteBranchHistory(owner string, folder string, fileName string, branchNam
 				if !ok {
 					err = fmt.Errorf("Broken commit history encountered when checking for commits to remove "+
 						"in branch '%s' of database '%s%s%s'\n", branchName, owner, folder, fileName)
-					log.Print(err.Error()) // Broken commit history is pretty serious, so we log it for admin investigation
+					Log.Error(err.Error()) // Broken commit history is pretty serious, so we log it for admin investigation
 					return
 				}
 				if c.ID == delCommit {
@@ -699,7 +913,7 @@ func DeleteBranchHistory(owner string, folder string, fileName string, branchNam
 		commitCount++
 		c, ok = commitList[c.Parent]
 		if !ok {
-			log.Printf("Error when counting # of commits while rewriting branch '%s' of database '%s%s%s'\n",
+			Log.Errorf("Error when counting # of commits while rewriting branch '%s' of database '%s%s%s'",
 				branchName, owner, folder, fileName)
 			err = fmt.Errorf("Error when counting commits during branch history rewrite")
 			return
@@ -727,6 +941,34 @@ func DeleteBranchHistory(owner string, folder string, fileName string, branchNam
 	return
 }
 
+// Queues an email to a user.  Users without an email address we can send to are skipped.
+func EmailUser(userName string, subject string, body string) error {
+	usr, err := User(userName)
+	if err != nil {
+		return err
+	}
+	if !CanEmailUser(usr) {
+		return nil
+	}
+	return QueueEmail(usr.Email, subject, body)
+}
+
+// Formats a date on the server, using one of the date formats users can choose from.  Unknown formats fall back to the
+// default one.
+func FormatDate(t time.Time, format string, withTime bool) string {
+	f := DateFormats[0]
+	for _, d := range DateFormats {
+		if d.ID == format {
+			f = d
+			break
+		}
+	}
+	if withTime {
+		return t.Format(f.TimeLayout)
+	}
+	return t.Format(f.DateLayout)
+}
+
 // Determines the common ancestor commit (if any) between a source and destination branch.  Returns the commit ID of
 // the ancestor and a slice of the commits between them.  If no common ancestor exists, the returned ancestorID will be
 // an empty string. Created for use by our Merge Request functions.
@@ -809,6 +1051,19 @@ func GetCurrentFunctionName() (FuncName string) {
 	return
 }
 
+// Returns true if the given user is one of the site administrators listed in the config file
+func IsAdmin(userName string) bool {
+	if userName == "" {
+		return false
+	}
+	for _, u := range Conf().Admin.Users {
+		if strings.ToLower(u) == strings.ToLower(userName) {
+			return true
+		}
+	}
+	return false
+}
+
 // Checks if a given commit ID is in the history of the given branch
 func IsCommitInBranchHistory(owner string, folder string, fileName string, branchName string, commitID string) (bool, error) {
 	// Get the commit list for the database
@@ -842,8 +1097,8 @@ func IsCommitInBranchHistory(owner string, folder string, fileName string, branc
 	for c.Parent != "" {
 		c, ok = commitList[c.Parent]
 		if !ok {
-			log.Printf("Broken commit history encountered for branch '%s' in '%s%s%s', when looking for "+
-				"commit '%s'\n", branchName, owner, folder, fileName, c.Parent)
+			Log.Errorf("Broken commit history encountered for branch '%s' in '%s%s%s', when looking for "+
+				"commit '%s'", branchName, owner, folder, fileName, c.Parent)
 			return false, fmt.Errorf("Broken commit history encountered for branch '%s' when looking up "+
 				"commit details", branchName)
 		}
@@ -911,6 +1166,48 @@ func nextChild(loggedInUser string, rawListPtr *[]ForkEntry, outputListPtr *[]Fo
 	return outputList, forkTrail, false
 }
 
+// Sets the ETag and Last-Modified headers for a response, then compares them against any If-None-Match or
+// If-Modified-Since headers sent by the client.  If the client already has the current version, a "304 Not Modified"
+// response is sent and true is returned, in which case the caller shouldn't write anything further.
+// Use an empty string for eTag, or a zero time for lastModified, if the value isn't known.  ETags are compared using
+// the weak comparison, so they still match when compression has turned them into weak ones.
+func NotModified(w http.ResponseWriter, r *http.Request, eTag string, lastModified time.Time) bool {
+	if eTag != "" {
+		w.Header().Set("ETag", eTag)
+	}
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	// Only GET and HEAD requests are conditional
+	if r.Method != "GET" && r.Method != "HEAD" {
+		return false
+	}
+
+	// If-None-Match takes precedence over If-Modified-Since, as per RFC 7232 section 6
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if eTag == "" {
+			return false
+		}
+		for _, t := range strings.Split(inm, ",") {
+			t = strings.TrimSpace(t)
+			if t == "*" || strings.TrimPrefix(t, "W/") == strings.TrimPrefix(eTag, "W/") {
+				w.WriteHeader(http.StatusNotModified)
+				return true
+			}
+		}
+		return false
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && !lastModified.IsZero() {
+		t, err := http.ParseTime(ims)
+		if err == nil && !lastModified.Truncate(time.Second).After(t) {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
 // Generate a random string
 func RandomString(length int) string {
 	rand.Seed(time.Now().UnixNano())
@@ -923,8 +1220,8 @@ func RandomString(length int) string {
 	return string(randomString)
 }
 
-// Performs basic sanity checks of an uploaded 3D model file.
-func SanityCheck3DModel(fileName string) (ok bool, err error) {
+// Performs basic sanity checks of an uploaded 3D model file, also returning the number of faces it contains.
+func SanityCheck3DModel(fileName string) (ok bool, numFaces int64, err error) {
 	// For now, we validate the model file by running assimp manually instead of using the ASSIMP Go bindings.  This is
 	// because the Go bindings can crash in native Assimp (C++) code if it doesn't like the model file, which we'd have
 	// to handle.  Hopefully (!) calling out like this works well enough, and doesn't lead to bad problems.
@@ -949,6 +1246,13 @@ func SanityCheck3DModel(fileName string) (ok bool, err error) {
 			}
 			numNodes, err = strconv.Atoi(nodesLn[1])
 		}
+		if strings.HasPrefix(line, "Faces:") {
+			// Extract the # of faces (triangles, once Assimp has triangulated the model)
+			facesLn := strings.Fields(line)
+			if len(facesLn) == 2 {
+				numFaces, _ = strconv.ParseInt(facesLn[1], 10, 64)
+			}
+		}
 	}
 	if numNodes != 0 {
 		// Assimp found 3D model nodes in the file, so this file passes validation
@@ -957,6 +1261,35 @@ func SanityCheck3DModel(fileName string) (ok bool, err error) {
 	return
 }
 
+// Checks whether a file is a SQLite database, and if so that it isn't corrupt.
+func SanityCheckDatabase(fileName string) (isDB bool, err error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return false, err
+	}
+	header := make([]byte, 16)
+	_, err = io.ReadFull(f, header)
+	f.Close()
+	if err != nil || string(header) != "SQLite format 3\x00" {
+		// Too short, or not a SQLite database
+		return false, nil
+	}
+
+	sdb, err := sqlite.Open(fileName, sqlite.OpenReadOnly)
+	if err != nil {
+		Log.Errorf("Couldn't open uploaded database: %s", err)
+		return true, errors.New("The uploaded database couldn't be opened")
+	}
+	defer sdb.Close()
+	var result string
+	err = sdb.OneValue("PRAGMA quick_check", &result)
+	if err != nil || result != "ok" {
+		Log.Warnf("Uploaded database failed its integrity check: %v %s", err, result)
+		return true, errors.New("The uploaded database failed its integrity check")
+	}
+	return true, nil
+}
+
 // Checks if a status update for the user exists for a given discussion or MR, and if so then removes it
 func StatusUpdateCheck(owner string, folder string, fileName string, thisID int, userName string) (numStatusUpdates int, err error) {
 	var lst map[string][]StatusUpdateEntry
@@ -989,7 +1322,7 @@ func StatusUpdateCheck(owner string, folder string, fileName string, thisID int,
 				}
 				err = SetUserStatusUpdates(userName, numStatusUpdates)
 				if err != nil {
-					log.Printf("Error when updating user status updates # in memcached: %v", err)
+					Log.Errorf("Error when updating user status updates # in memcached: %v", err)
 					return
 				}
 				return
@@ -2,42 +2,71 @@ package common
 
 import (
 	"fmt"
+	"path"
 	"regexp"
 	"strings"
+	"sync"
+	"time"
 
 	valid "gopkg.in/go-playground/validator.v9"
 )
 
 var (
 	regexBraTagName      = regexp.MustCompile(`^[a-z,A-Z,0-9,\^,\.,\-,\_,\/,\(,\),\:,\&,\ )]+$`)
-	regexDBName          = regexp.MustCompile(`^[a-z,A-Z,0-9,\.,\-,\_,\(,\),\+,\ ]+$`)
+	regexCategoryName    = regexp.MustCompile(`^[a-z,A-Z,0-9,\.,\-,\,,\',\&,\(,\),\ ]+$`)
+	regexCategoryPath    = regexp.MustCompile(`^[a-z0-9][a-z0-9\-]*(/[a-z0-9][a-z0-9\-]*)*$`)
 	regexDiscussTitle    = regexp.MustCompile(`^[a-z,A-Z,0-9,\^,\.,\-,\_,\/,\(,\),\',\!,\@,\#,\&,\$,\+,\:,\;,\?,\ )]+$`)
 	regexDisplayName     = regexp.MustCompile(`^[a-z,A-Z,0-9,\.,\-,\,,\',\ ]+$`)
 	regexFieldName       = regexp.MustCompile(`^[a-z,A-Z,0-9,\^,\.,\-,\_,\/,\(,\),\ )]+$`)
 	regexFolder          = regexp.MustCompile(`^[a-z,A-Z,0-9,\.,\-,\_,\/]+$`)
+	regexLanguageTag     = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})*$`)
 	regexLicence         = regexp.MustCompile(`^[a-z,A-Z,0-9,\.,\-,\_,\(,\),\ ]+$`)
 	regexLicenceFullName = regexp.MustCompile(`^[a-z,A-Z,0-9,\.,\-,\_,\(,\),\ ]+$`)
 	regexMarkDownSource  = regexp.MustCompile(`^[a-z,A-Z,0-9` + ",`," + `‘,’,“,”,\.,\-,\_,\/,\(,\),\[,\],\\,\!,\#,\',\",\@,\$,\*,\%,\^,\&,\+,\=,\:,\;,\<,\>,\,,\?,\~,\|,\ ,\012,\015]+$`)
+	regexProjectTag      = regexp.MustCompile(`^[a-z0-9][a-z0-9\-]*$`)
 	regexPGTable         = regexp.MustCompile(`^[a-z,A-Z,0-9,\.,\-,\_,\(,\),\ ]+$`)
+	regexReservedName    = regexp.MustCompile(`^[a-zA-Z0-9\.\-\_\*\?]+$`)
+	regexSPDXID          = regexp.MustCompile(`^[a-zA-Z0-9\.\-]+\+?$`)
 	regexUsername        = regexp.MustCompile(`^[a-z,A-Z,0-9,\.,\-,\_]+$`)
 
 	// For input validation
 	Validate *valid.Validate
 )
 
+// The usernames which are always reserved, as they're used by the site's own pages (eg /about) and accounts.  Site
+// admins can reserve others (including patterns, eg "acme*") from the admin pages, which are kept in PostgreSQL.
+var BuiltinReservedUsernames = []string{"about", "account", "accounts", "admin", "administrator", "blog",
+	"categories", "category", "ceo", "compare", "dbhub", "default", "demo", "download", "feeds", "forks", "legal",
+	"login", "logout", "mail", "news", "pref", "printer", "public", "questions", "reference", "register", "root", "s",
+	"sales", "search", "star", "stars", "system", "table", "tagged", "unsubscribe", "upload", "uploaddata", "v1", "vis",
+	"watchers", "wiki"}
+
+// The reserved username patterns added by site admins, and when they were last looked up
+var (
+	reservedPatterns        []string
+	reservedPatternsChecked time.Time
+	reservedPatternsMu      sync.Mutex
+)
+
 func init() {
 	// Load validation code
 	Validate = valid.New()
 	Validate.RegisterValidation("branchortagname", checkBranchOrTagName)
+	Validate.RegisterValidation("categoryname", checkCategoryName)
+	Validate.RegisterValidation("categorypath", checkCategoryPath)
 	Validate.RegisterValidation("discussiontitle", checkDiscussTitle)
 	Validate.RegisterValidation("displayname", checkDisplayName)
 	Validate.RegisterValidation("fieldname", checkFieldName)
 	Validate.RegisterValidation("filename", checkFileName)
 	Validate.RegisterValidation("folder", checkFolder)
+	Validate.RegisterValidation("languagetag", checkLanguageTag)
 	Validate.RegisterValidation("licence", checkLicence)
 	Validate.RegisterValidation("licencefullname", checkLicenceFullName)
 	Validate.RegisterValidation("markdownsource", checkMarkDownSource)
 	Validate.RegisterValidation("pgtable", checkPGTableName)
+	Validate.RegisterValidation("projecttag", checkProjectTag)
+	Validate.RegisterValidation("reservedname", checkReservedName)
+	Validate.RegisterValidation("spdxid", checkSPDXID)
 	Validate.RegisterValidation("username", checkUsername)
 }
 
@@ -48,6 +77,18 @@ func checkBranchOrTagName(fl valid.FieldLevel) bool {
 	return regexBraTagName.MatchString(fl.Field().String())
 }
 
+// Custom validation function for category names.
+// At the moment it just allows alphanumeric and ".-,'&() " chars
+func checkCategoryName(fl valid.FieldLevel) bool {
+	return regexCategoryName.MatchString(fl.Field().String())
+}
+
+// Custom validation function for category slug paths.
+// At the moment it just allows "/" separated project tag style slugs, eg "gadgets/enclosures"
+func checkCategoryPath(fl valid.FieldLevel) bool {
+	return regexCategoryPath.MatchString(fl.Field().String())
+}
+
 // Custom validation function for discussion titles.
 // At the moment it just allows alpha and "^.-_/()'!@#&$+:;? " chars
 func checkDiscussTitle(fl valid.FieldLevel) bool {
@@ -68,10 +109,9 @@ func checkFieldName(fl valid.FieldLevel) bool {
 }
 
 // Custom validation function for file names.
-// At the moment it just allows alphanumeric and ".-_()+ " chars, though it should probably be extended to cover any
-// valid file name
+// It allows letters and digits from any script, along with ".-_()+ " chars.  See validFileName() for the details
 func checkFileName(fl valid.FieldLevel) bool {
-	return regexDBName.MatchString(fl.Field().String())
+	return validFileName(fl.Field().String())
 }
 
 // Custom validation function for folder names.
@@ -80,6 +120,12 @@ func checkFolder(fl valid.FieldLevel) bool {
 	return regexFolder.MatchString(fl.Field().String())
 }
 
+// Custom validation function for language tags.
+// At the moment it just allows lower case BCP 47 style tags, eg "de" or "pt-br"
+func checkLanguageTag(fl valid.FieldLevel) bool {
+	return regexLanguageTag.MatchString(fl.Field().String())
+}
+
 // Custom validation function for licence (ID) names.
 // At the moment it allows alphanumeric and ".-_() " chars.  Will probably need more characters added.
 func checkLicence(fl valid.FieldLevel) bool {
@@ -104,20 +150,41 @@ func checkPGTableName(fl valid.FieldLevel) bool {
 	return regexPGTable.MatchString(fl.Field().String())
 }
 
+// Custom validation function for project tags.
+// At the moment it just allows lower case alphanumeric and "-" chars, with the first character not being "-".
+func checkProjectTag(fl valid.FieldLevel) bool {
+	return regexProjectTag.MatchString(fl.Field().String())
+}
+
+// Custom validation function for reserved username patterns.
+// At the moment it just allows the characters usernames can have, plus the "*" and "?" wildcards.
+func checkReservedName(fl valid.FieldLevel) bool {
+	return regexReservedName.MatchString(fl.Field().String())
+}
+
+// Custom validation function for SPDX licence identifiers.
+// It allows the characters SPDX uses in identifiers (alphanumeric and ".-"), with an optional "+" on the end for "or
+// later versions".  Licence expressions (eg "MIT OR Apache-2.0") aren't allowed.
+func checkSPDXID(fl valid.FieldLevel) bool {
+	return regexSPDXID.MatchString(fl.Field().String())
+}
+
 // Custom validation function for Usernames.
 // At the moment it just allows alphanumeric and ".-_" chars (may need to be expanded out at some point).
 func checkUsername(fl valid.FieldLevel) bool {
 	return regexUsername.MatchString(fl.Field().String())
 }
 
-// Checks a username against the list of reserved ones.
+// Checks a username against the list of reserved ones, and the patterns reserved by site admins.
 func ReservedUsernamesCheck(userName string) error {
-	reserved := []string{"about", "account", "accounts", "admin", "administrator", "blog", "ceo", "compare", "dbhub",
-		"default", "demo", "download", "forks", "legal", "login", "logout", "mail", "news", "pref", "printer", "public",
-		"reference", "register", "root", "sales", "star", "stars", "system", "table", "upload", "uploaddata", "vis",
-		"watchers"}
-	for _, word := range reserved {
-		if strings.ToLower(userName) == strings.ToLower(word) {
+	name := strings.ToLower(userName)
+	for _, word := range BuiltinReservedUsernames {
+		if name == word {
+			return fmt.Errorf("That username is not available: %s\n", userName)
+		}
+	}
+	for _, pattern := range reservedUsernamePatterns() {
+		if ReservedUsernameMatch(pattern, name) {
 			return fmt.Errorf("That username is not available: %s\n", userName)
 		}
 	}
@@ -125,6 +192,39 @@ func ReservedUsernamesCheck(userName string) error {
 	return nil
 }
 
+// Returns whether a username matches a reserved username pattern.  Both are compared in lower case, with "*" in the
+// pattern matching any run of characters, and "?" any single character.
+func ReservedUsernameMatch(pattern string, userName string) bool {
+	ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(userName))
+	return ok
+}
+
+// Returns the reserved username patterns added by site admins.  They're only looked up once a minute (or straight
+// after an admin changes them), so checking a username doesn't need a database query each time.
+func reservedUsernamePatterns() []string {
+	reservedPatternsMu.Lock()
+	defer reservedPatternsMu.Unlock()
+	if time.Since(reservedPatternsChecked) > time.Minute {
+		list, err := ReservedUsernames()
+		if err == nil {
+			reservedPatterns = nil
+			for _, r := range list {
+				reservedPatterns = append(reservedPatterns, r.Pattern)
+			}
+		}
+		reservedPatternsChecked = time.Now()
+	}
+	return reservedPatterns
+}
+
+// Makes changes to the reserved username patterns apply straight away, rather than waiting for the cached list to
+// expire.
+func ResetReservedUsernames() {
+	reservedPatternsMu.Lock()
+	reservedPatternsChecked = time.Time{}
+	reservedPatternsMu.Unlock()
+}
+
 // Validate the provided branch, release, or tag name.
 func ValidateBranchName(fieldName string) error {
 	err := Validate.Var(fieldName, "branchortagname,min=1,max=32") // 32 seems a reasonable first guess
@@ -135,6 +235,26 @@ func ValidateBranchName(fieldName string) error {
 	return nil
 }
 
+// Validate the provided category name.
+func ValidateCategoryName(name string) error {
+	err := Validate.Var(name, "required,categoryname,max=50") // 50 seems a reasonable first guess
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Validate the provided category slug path.
+func ValidateCategoryPath(path string) error {
+	err := Validate.Var(path, "required,categorypath,max=200")
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 // Validate the provided commit ID.
 func ValidateCommitID(fieldName string) error {
 	err := Validate.Var(fieldName, "hexadecimal,min=64,max=64") // Always 64 alphanumeric characters
@@ -195,6 +315,26 @@ func ValidateFolder(folder string) error {
 	return nil
 }
 
+// Validate the provided full description of a project.
+func ValidateFullDescription(desc string) error {
+	err := Validate.Var(desc, "markdownsource,max=8192") // 8192 seems reasonable.  Maybe too long?
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Validate the provided language tag.
+func ValidateLanguageTag(tag string) error {
+	err := Validate.Var(tag, "required,languagetag,max=35") // 35 covers all of the tags in common use
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 // Validate the provided licence name (ID).
 func ValidateLicence(licence string) error {
 	err := Validate.Var(licence, "licence,min=1,max=13") // 13 is the length of our longest licence name (thus far)
@@ -225,6 +365,16 @@ func ValidateMarkdown(fieldName string) error {
 	return nil
 }
 
+// Validate the provided one line description of a project.
+func ValidateOneLineDescription(desc string) error {
+	err := Validate.Var(desc, "markdownsource,max=120")
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 // Validate the provided PostgreSQL table name.
 func ValidatePGTable(table string) error {
 	// TODO: Improve this to work with all valid SQLite identifiers
@@ -250,6 +400,66 @@ func ValidateDiscussionTitle(fieldName string) error {
 	return nil
 }
 
+// Validate the provided project tag.
+func ValidateProjectTag(tag string) error {
+	err := Validate.Var(tag, "required,projecttag,max=32") // 32 seems a reasonable first guess
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Validate the provided project README.
+func ValidateReadme(readme string) error {
+	err := Validate.Var(readme, "markdownsource,max=65536") // READMEs can be a lot longer than descriptions
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Validate the provided reserved username pattern.
+func ValidateReservedUsername(pattern string) error {
+	err := Validate.Var(pattern, "required,reservedname,max=63")
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Validate the provided search text.
+func ValidateSearchQuery(query string) error {
+	err := Validate.Var(query, "max=200") // 200 seems a reasonable first guess
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Validate the provided SPDX licence identifier.
+func ValidateSPDXID(spdxID string) error {
+	err := Validate.Var(spdxID, "spdxid,min=1,max=64")
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Validate the provided project template name.  These use the same characters as category names.
+func ValidateTemplateName(name string) error {
+	err := Validate.Var(name, "required,categoryname,max=60") // 60 seems a reasonable first guess
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 // Validate the provided username.
 func ValidateUser(user string) error {
 	err := Validate.Var(user, "required,username,min=2,max=63")
@@ -304,3 +514,13 @@ func ValidateUserFilename(user string, fn string) error {
 
 	return nil
 }
+
+// Validate the provided wiki page name.  These use the same characters as branch and tag names.
+func ValidateWikiPageName(name string) error {
+	err := Validate.Var(name, "required,branchortagname,max=80") // 80 seems a reasonable first guess
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
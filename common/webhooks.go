@@ -0,0 +1,166 @@
+package common
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// Site admins can add global webhooks, which are told about things happening anywhere on the site (eg new users
+// registering, or new public uploads), for passing on to moderation channels in Slack, Matrix, and the like.  Each
+// call is a JSON POST whose "text" field holds a summary, which Slack incoming webhooks and the Matrix hookshot bridge
+// show as is.  Calls are signed with the webhook's secret, and are sent as background jobs so failed ones get retried.
+
+// The site wide events global webhooks can be told about
+const (
+	WebhookProjectReported = "project_reported"
+	WebhookPublicUpload    = "public_upload"
+	WebhookScheduled       = "scheduled_publish"
+	WebhookTest            = "test"
+	WebhookUserRegistered  = "user_registered"
+)
+
+// The events admins can choose between when adding a global webhook
+var AdminWebhookEvents = []AdminWebhookEvent{
+	{ID: WebhookUserRegistered, Name: "New users registering"},
+	{ID: WebhookPublicUpload, Name: "New public uploads"},
+	{ID: WebhookProjectReported, Name: "Projects being reported"},
+	{ID: WebhookScheduled, Name: "Scheduled projects and releases being published"},
+}
+
+// The client used for calling global webhooks.  Admins choose where they point, so they can be on our own network
+var webhookClient = &http.Client{Timeout: 30 * time.Second}
+
+// The body sent to a global webhook
+type adminWebhookPayload struct {
+	Details   map[string]string `json:"details,omitempty"`
+	Event     string            `json:"event"`
+	Server    string            `json:"server"`
+	Text      string            `json:"text"`
+	Timestamp time.Time         `json:"timestamp"`
+}
+
+// Calls a global webhook, queued by FireAdminWebhooks() or TestAdminWebhook().  The payload holds the ID of the
+// webhook, and the body to send it.
+func AdminWebhookJob(payload json.RawMessage) error {
+	var p struct {
+		Body adminWebhookPayload `json:"body"`
+		ID   int64               `json:"id"`
+	}
+	err := json.Unmarshal(payload, &p)
+	if err != nil {
+		return err
+	}
+	hook, found, err := AdminWebhook(p.ID)
+	if err != nil {
+		return err
+	}
+	if !found {
+		// The webhook has been removed since the call was queued
+		return nil
+	}
+	body, err := json.Marshal(p.Body)
+	if err != nil {
+		return err
+	}
+	hookErr := callAdminWebhook(hook, p.Body.Event, body)
+	err = StoreAdminWebhookResult(hook.ID, hookErr)
+	if err != nil {
+		return err
+	}
+	return hookErr
+}
+
+// POSTs the body for an event to a global webhook.  It's signed with the webhook's secret, using an HMAC-SHA256 of the
+// body in the X-3DHub-Signature-256 header (the same format GitHub uses).
+func callAdminWebhook(hook AdminWebhookEntry, event string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	mac := hmac.New(sha256.New, []byte(hook.Secret))
+	mac.Write(body)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "3DHub.io-Webhook")
+	req.Header.Set("X-3DHub-Event", event)
+	req.Header.Set("X-3DHub-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("Calling the webhook failed: %v", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("The webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Tells the global webhooks for an event that it's happened.  Text is a summary for showing in chat channels, and the
+// details are passed along for anything wanting to process the event itself.  Failing to queue the calls is only
+// logged, as it shouldn't stop whatever caused the event.
+func FireAdminWebhooks(event string, text string, details map[string]string) {
+	hooks, err := AdminWebhooks(event)
+	if err != nil {
+		return
+	}
+	for _, hook := range hooks {
+		queueAdminWebhook(hook.ID, event, text, details)
+	}
+}
+
+// Returns whether the given ID is one of the events global webhooks can be told about.
+func KnownAdminWebhookEvent(id string) bool {
+	for _, e := range AdminWebhookEvents {
+		if e.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns a new random secret for signing the calls to a global webhook.
+func NewAdminWebhookSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Queues a call to a global webhook.
+func queueAdminWebhook(id int64, event string, text string, details map[string]string) {
+	body := adminWebhookPayload{
+		Details:   details,
+		Event:     event,
+		Server:    Conf().Web.ServerName,
+		Text:      text,
+		Timestamp: time.Now().UTC(),
+	}
+	_, err := QueueJob("admin_webhook", map[string]interface{}{"body": body, "id": id})
+	if err != nil {
+		Log.Errorf("Queuing a call to global webhook %d for '%s' failed: %v", id, event, err)
+	}
+}
+
+// Sends a test event to a global webhook, so admins can check it's working.
+func TestAdminWebhook(id int64) error {
+	_, found, err := AdminWebhook(id)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return errors.New("That webhook doesn't exist")
+	}
+	queueAdminWebhook(id, WebhookTest, fmt.Sprintf("Test message from %s", Conf().Web.ServerName), nil)
+	return nil
+}
@@ -30,10 +30,262 @@ COMMENT ON EXTENSION plpgsql IS 'PL/pgSQL procedural language';
 
 SET search_path = public, pg_catalog;
 
+--
+-- Name: project_tags_text(text[]); Type: FUNCTION; Schema: public; Owner: -
+--
+
+CREATE FUNCTION project_tags_text(text[]) RETURNS text
+    LANGUAGE sql IMMUTABLE
+    AS $_$SELECT array_to_string($1, ' ')$_$;
+
+
 SET default_tablespace = '';
 
 SET default_with_oids = false;
 
+--
+-- Name: admin_audit_log; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE admin_audit_log (
+    log_id bigint NOT NULL,
+    event_timestamp timestamp with time zone DEFAULT now() NOT NULL,
+    admin_user text NOT NULL,
+    action text NOT NULL,
+    target text NOT NULL,
+    details text
+);
+
+
+--
+-- Name: admin_audit_log_log_id_seq; Type: SEQUENCE; Schema: public; Owner: -
+--
+
+CREATE SEQUENCE admin_audit_log_log_id_seq
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+--
+-- Name: admin_audit_log_log_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: -
+--
+
+ALTER SEQUENCE admin_audit_log_log_id_seq OWNED BY admin_audit_log.log_id;
+
+
+--
+-- Name: admin_webhooks; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE admin_webhooks (
+    hook_id bigint NOT NULL,
+    url text NOT NULL,
+    events text[] NOT NULL,
+    secret text NOT NULL,
+    added_by text NOT NULL,
+    date_added timestamp with time zone DEFAULT now() NOT NULL,
+    last_sent timestamp with time zone,
+    last_error text
+);
+
+
+--
+-- Name: admin_webhooks_hook_id_seq; Type: SEQUENCE; Schema: public; Owner: -
+--
+
+CREATE SEQUENCE admin_webhooks_hook_id_seq
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+--
+-- Name: admin_webhooks_hook_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: -
+--
+
+ALTER SEQUENCE admin_webhooks_hook_id_seq OWNED BY admin_webhooks.hook_id;
+
+
+--
+-- Name: announcements; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE announcements (
+    announcement_id bigint NOT NULL,
+    message text NOT NULL,
+    start_time timestamp with time zone DEFAULT now() NOT NULL,
+    end_time timestamp with time zone,
+    created_by text NOT NULL,
+    date_created timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
+--
+-- Name: announcements_announcement_id_seq; Type: SEQUENCE; Schema: public; Owner: -
+--
+
+CREATE SEQUENCE announcements_announcement_id_seq
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+--
+-- Name: announcements_announcement_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: -
+--
+
+ALTER SEQUENCE announcements_announcement_id_seq OWNED BY announcements.announcement_id;
+
+
+--
+-- Name: background_jobs; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE background_jobs (
+    job_id bigint NOT NULL,
+    job_type text NOT NULL,
+    payload jsonb NOT NULL,
+    priority integer DEFAULT 0 NOT NULL,
+    status text DEFAULT 'queued'::text NOT NULL,
+    attempts integer DEFAULT 0 NOT NULL,
+    max_attempts integer DEFAULT 1 NOT NULL,
+    last_error text,
+    locked_by text,
+    run_after timestamp with time zone DEFAULT now() NOT NULL,
+    date_created timestamp with time zone DEFAULT now() NOT NULL,
+    date_updated timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
+--
+-- Name: background_jobs_job_id_seq; Type: SEQUENCE; Schema: public; Owner: -
+--
+
+CREATE SEQUENCE background_jobs_job_id_seq
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+--
+-- Name: background_jobs_job_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: -
+--
+
+ALTER SEQUENCE background_jobs_job_id_seq OWNED BY background_jobs.job_id;
+
+
+--
+-- Name: backup_runs; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE backup_runs (
+    run_id bigint NOT NULL,
+    date_queued timestamp with time zone DEFAULT now() NOT NULL,
+    date_started timestamp with time zone,
+    date_finished timestamp with time zone,
+    success boolean DEFAULT false NOT NULL,
+    object_name text,
+    size bigint,
+    num_objects integer,
+    last_error text
+);
+
+
+--
+-- Name: backup_runs_run_id_seq; Type: SEQUENCE; Schema: public; Owner: -
+--
+
+CREATE SEQUENCE backup_runs_run_id_seq
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+--
+-- Name: backup_runs_run_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: -
+--
+
+ALTER SEQUENCE backup_runs_run_id_seq OWNED BY backup_runs.run_id;
+
+
+--
+-- Name: categories; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE categories (
+    cat_id bigint NOT NULL,
+    parent_id bigint,
+    cat_name text NOT NULL,
+    slug text NOT NULL
+);
+
+
+--
+-- Name: categories_cat_id_seq; Type: SEQUENCE; Schema: public; Owner: -
+--
+
+CREATE SEQUENCE categories_cat_id_seq
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+--
+-- Name: categories_cat_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: -
+--
+
+ALTER SEQUENCE categories_cat_id_seq OWNED BY categories.cat_id;
+
+
+--
+-- Name: data_exports; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE data_exports (
+    export_id bigint NOT NULL,
+    user_id bigint,
+    status text DEFAULT 'queued'::text NOT NULL,
+    date_requested timestamp with time zone DEFAULT now() NOT NULL,
+    date_finished timestamp with time zone,
+    expires timestamp with time zone,
+    object_name text,
+    size bigint,
+    last_error text
+);
+
+
+--
+-- Name: data_exports_export_id_seq; Type: SEQUENCE; Schema: public; Owner: -
+--
+
+CREATE SEQUENCE data_exports_export_id_seq
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+--
+-- Name: data_exports_export_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: -
+--
+
+ALTER SEQUENCE data_exports_export_id_seq OWNED BY data_exports.export_id;
+
+
 --
 -- Name: database_downloads; Type: TABLE; Schema: public; Owner: -
 --
@@ -94,7 +346,8 @@ CREATE TABLE database_licences (
     display_order integer,
     lic_id integer NOT NULL,
     full_name text,
-    file_format text DEFAULT 'text'::text NOT NULL
+    file_format text DEFAULT 'text'::text NOT NULL,
+    spdx_id text
 );
 
 
@@ -163,6 +416,41 @@ CREATE SEQUENCE database_uploads_up_id_seq
 ALTER SEQUENCE database_uploads_up_id_seq OWNED BY database_uploads.up_id;
 
 
+--
+-- Name: digest_items; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE digest_items (
+    item_id bigint NOT NULL,
+    user_id bigint NOT NULL,
+    db_id bigint NOT NULL,
+    event_type integer NOT NULL,
+    project text NOT NULL,
+    title text NOT NULL,
+    url text NOT NULL,
+    event_timestamp timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
+--
+-- Name: digest_items_item_id_seq; Type: SEQUENCE; Schema: public; Owner: -
+--
+
+CREATE SEQUENCE digest_items_item_id_seq
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+--
+-- Name: digest_items_item_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: -
+--
+
+ALTER SEQUENCE digest_items_item_id_seq OWNED BY digest_items.item_id;
+
+
 --
 -- Name: discussion_comments; Type: TABLE; Schema: public; Owner: -
 --
@@ -217,7 +505,8 @@ CREATE TABLE discussions (
     mr_source_db_branch text,
     mr_destination_branch text,
     mr_state integer DEFAULT 0 NOT NULL,
-    mr_commits jsonb
+    mr_commits jsonb,
+    accepted_answer bigint
 );
 
 
@@ -228,6 +517,13 @@ CREATE TABLE discussions (
 COMMENT ON COLUMN discussions.mr_source_db_id IS 'Only used by Merge Requests, not standard discussions';
 
 
+--
+-- Name: COLUMN discussions.accepted_answer; Type: COMMENT; Schema: public; Owner: -
+--
+
+COMMENT ON COLUMN discussions.accepted_answer IS 'Only used by questions, not standard discussions';
+
+
 --
 -- Name: discussions_disc_id_seq; Type: SEQUENCE; Schema: public; Owner: -
 --
@@ -247,6 +543,20 @@ CREATE SEQUENCE discussions_disc_id_seq
 ALTER SEQUENCE discussions_disc_id_seq OWNED BY discussions.internal_id;
 
 
+--
+-- Name: email_changes; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE email_changes (
+    user_id bigint NOT NULL,
+    new_email text NOT NULL,
+    old_token text,
+    new_token text,
+    date_requested timestamp with time zone DEFAULT now() NOT NULL,
+    expires timestamp with time zone NOT NULL
+);
+
+
 --
 -- Name: email_queue; Type: TABLE; Schema: public; Owner: -
 --
@@ -314,519 +624,1997 @@ ALTER SEQUENCE events_event_id_seq OWNED BY events.event_id;
 
 
 --
--- Name: sqlite_databases; Type: TABLE; Schema: public; Owner: -
+-- Name: feature_flags; Type: TABLE; Schema: public; Owner: -
 --
 
-CREATE TABLE sqlite_databases (
+CREATE TABLE feature_flags (
+    name text NOT NULL,
+    enabled boolean DEFAULT false NOT NULL,
+    changed_by text NOT NULL,
+    date_changed timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
+--
+-- Name: filter_words; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE filter_words (
+    pattern text NOT NULL,
+    added_by text NOT NULL,
+    date_added timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
+--
+-- Name: flagged_usernames; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE flagged_usernames (
     user_id bigint NOT NULL,
+    pattern text NOT NULL,
+    date_flagged timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
+--
+-- Name: github_import_files; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE github_import_files (
+    import_id bigint NOT NULL,
     db_id bigint NOT NULL,
-    folder text NOT NULL,
-    db_name text NOT NULL,
+    repo_path text NOT NULL,
+    blob_sha text NOT NULL
+);
+
+
+--
+-- Name: github_imports; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE github_imports (
+    import_id bigint NOT NULL,
+    user_id bigint NOT NULL,
+    repo text NOT NULL,
+    branch text NOT NULL,
+    path text DEFAULT ''::text NOT NULL,
+    licence text NOT NULL,
     public boolean DEFAULT false NOT NULL,
-    date_created timestamp with time zone DEFAULT now() NOT NULL,
-    last_modified timestamp with time zone DEFAULT now() NOT NULL,
-    watchers bigint DEFAULT 0 NOT NULL,
-    stars bigint DEFAULT 0 NOT NULL,
-    forks bigint DEFAULT 0 NOT NULL,
-    discussions bigint DEFAULT 0 NOT NULL,
-    merge_requests bigint DEFAULT 0 NOT NULL,
-    branches bigint DEFAULT 1 NOT NULL,
-    contributors bigint DEFAULT 1 NOT NULL,
-    one_line_description text,
-    full_description text,
-    root_database bigint,
-    forked_from bigint,
-    default_table text,
-    source_url text,
-    commit_list jsonb,
-    branch_heads jsonb,
-    tag_list jsonb,
-    default_branch text,
-    is_deleted boolean DEFAULT false NOT NULL,
-    tags integer DEFAULT 0 NOT NULL,
-    release_list jsonb,
-    release_count integer DEFAULT 0 NOT NULL,
-    download_count bigint DEFAULT 0,
-    page_views bigint DEFAULT 0
+    schedule text DEFAULT 'never'::text NOT NULL,
+    webhook_secret text NOT NULL,
+    last_commit text,
+    last_error text,
+    last_queued timestamp with time zone,
+    last_synced timestamp with time zone,
+    date_created timestamp with time zone DEFAULT now() NOT NULL
 );
 
 
 --
--- Name: sqlite_databases_db_id_seq; Type: SEQUENCE; Schema: public; Owner: -
+-- Name: github_imports_import_id_seq; Type: SEQUENCE; Schema: public; Owner: -
+--
+
+CREATE SEQUENCE github_imports_import_id_seq
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+--
+-- Name: github_imports_import_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: -
+--
+
+ALTER SEQUENCE github_imports_import_id_seq OWNED BY github_imports.import_id;
+
+
+--
+-- Name: github_mirrors; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE github_mirrors (
+    db_id bigint NOT NULL,
+    repo text NOT NULL,
+    branch text NOT NULL,
+    path text NOT NULL,
+    token bytea NOT NULL,
+    pushed_commits jsonb DEFAULT '{}'::jsonb NOT NULL,
+    pushed_releases jsonb DEFAULT '{}'::jsonb NOT NULL,
+    last_commit text,
+    last_error text,
+    last_pushed timestamp with time zone,
+    date_created timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
+--
+-- Name: guest_upload_links; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE guest_upload_links (
+    link_id bigint NOT NULL,
+    db_id bigint NOT NULL,
+    token text NOT NULL,
+    expiry_date timestamp with time zone NOT NULL,
+    date_created timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
+--
+-- Name: guest_upload_links_link_id_seq; Type: SEQUENCE; Schema: public; Owner: -
+--
+
+CREATE SEQUENCE guest_upload_links_link_id_seq
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+--
+-- Name: guest_upload_links_link_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: -
+--
+
+ALTER SEQUENCE guest_upload_links_link_id_seq OWNED BY guest_upload_links.link_id;
+
+
+--
+-- Name: guest_uploads; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE guest_uploads (
+    upload_id bigint NOT NULL,
+    db_id bigint NOT NULL,
+    uploader_name text NOT NULL,
+    uploader_email text,
+    message text,
+    sha256 text NOT NULL,
+    size bigint NOT NULL,
+    date_uploaded timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
+--
+-- Name: guest_uploads_upload_id_seq; Type: SEQUENCE; Schema: public; Owner: -
+--
+
+CREATE SEQUENCE guest_uploads_upload_id_seq
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+--
+-- Name: guest_uploads_upload_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: -
+--
+
+ALTER SEQUENCE guest_uploads_upload_id_seq OWNED BY guest_uploads.upload_id;
+
+
+--
+-- Name: held_comments; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE held_comments (
+    held_id bigint NOT NULL,
+    db_id bigint NOT NULL,
+    disc_id integer NOT NULL,
+    commenter bigint NOT NULL,
+    body text NOT NULL,
+    reason text NOT NULL,
+    date_created timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
+--
+-- Name: held_comments_held_id_seq; Type: SEQUENCE; Schema: public; Owner: -
+--
+
+CREATE SEQUENCE held_comments_held_id_seq
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+--
+-- Name: held_comments_held_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: -
+--
+
+ALTER SEQUENCE held_comments_held_id_seq OWNED BY held_comments.held_id;
+
+
+--
+-- Name: ip_rules; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE ip_rules (
+    rule_id bigint NOT NULL,
+    network text NOT NULL,
+    action text NOT NULL,
+    reason text,
+    created_by text NOT NULL,
+    date_created timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
+--
+-- Name: ip_rules_rule_id_seq; Type: SEQUENCE; Schema: public; Owner: -
+--
+
+CREATE SEQUENCE ip_rules_rule_id_seq
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+--
+-- Name: ip_rules_rule_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: -
+--
+
+ALTER SEQUENCE ip_rules_rule_id_seq OWNED BY ip_rules.rule_id;
+
+
+--
+-- Name: notification_prefs; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE notification_prefs (
+    user_id bigint NOT NULL,
+    event_type integer NOT NULL,
+    email boolean DEFAULT true NOT NULL,
+    in_app boolean DEFAULT true NOT NULL
+);
+
+
+--
+-- Name: object_checksums; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE object_checksums (
+    db_sha256 text NOT NULL,
+    md5 text NOT NULL,
+    sha1 text NOT NULL,
+    blake3 text NOT NULL,
+    date_computed timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
+--
+-- Name: octoprint_links; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE octoprint_links (
+    user_id bigint NOT NULL,
+    url text NOT NULL,
+    api_key bytea NOT NULL,
+    date_linked timestamp with time zone DEFAULT now() NOT NULL,
+    last_used timestamp with time zone
+);
+
+
+--
+-- Name: project_access_log; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE project_access_log (
+    db_id bigint NOT NULL,
+    access_time timestamp with time zone NOT NULL,
+    kind text NOT NULL,
+    method text NOT NULL,
+    path text NOT NULL,
+    status integer NOT NULL,
+    client text NOT NULL,
+    user_agent text
+);
+
+
+--
+-- Name: project_daily_downloads; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE project_daily_downloads (
+    db_id bigint NOT NULL,
+    stat_date date NOT NULL,
+    db_sha256 text NOT NULL,
+    downloads bigint DEFAULT 0 NOT NULL
+);
+
+
+--
+-- Name: project_daily_views; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE project_daily_views (
+    db_id bigint NOT NULL,
+    stat_date date NOT NULL,
+    views bigint DEFAULT 0 NOT NULL
+);
+
+
+--
+-- Name: project_redirects; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE project_redirects (
+    user_id bigint NOT NULL,
+    folder text NOT NULL,
+    db_name text NOT NULL,
+    db_id bigint NOT NULL,
+    date_created timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
+--
+-- Name: project_referrers; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE project_referrers (
+    db_id bigint NOT NULL,
+    stat_date date NOT NULL,
+    referrer text NOT NULL,
+    hits bigint DEFAULT 0 NOT NULL
+);
+
+
+--
+-- Name: project_remix_sources; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE project_remix_sources (
+    source_id bigint NOT NULL,
+    db_id bigint NOT NULL,
+    source_db_id bigint,
+    source_url text,
+    source_licence text,
+    date_added timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
+--
+-- Name: project_remix_sources_source_id_seq; Type: SEQUENCE; Schema: public; Owner: -
+--
+
+CREATE SEQUENCE project_remix_sources_source_id_seq
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+--
+-- Name: project_remix_sources_source_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: -
+--
+
+ALTER SEQUENCE project_remix_sources_source_id_seq OWNED BY project_remix_sources.source_id;
+
+
+--
+-- Name: project_reports; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE project_reports (
+    report_id bigint NOT NULL,
+    db_id bigint NOT NULL,
+    reporter_id bigint,
+    reason text NOT NULL,
+    date_reported timestamp with time zone DEFAULT now() NOT NULL,
+    resolved boolean DEFAULT false NOT NULL,
+    automatic boolean DEFAULT false NOT NULL
+);
+
+
+--
+-- Name: project_reports_report_id_seq; Type: SEQUENCE; Schema: public; Owner: -
+--
+
+CREATE SEQUENCE project_reports_report_id_seq
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+--
+-- Name: project_reports_report_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: -
+--
+
+ALTER SEQUENCE project_reports_report_id_seq OWNED BY project_reports.report_id;
+
+
+--
+-- Name: project_templates; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE project_templates (
+    user_id bigint NOT NULL,
+    template_name text NOT NULL,
+    one_line_description text,
+    readme text,
+    licence text,
+    category_id bigint,
+    project_tags text[] DEFAULT '{}'::text[] NOT NULL,
+    print_settings text,
+    date_created timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
+--
+-- Name: project_transfers; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE project_transfers (
+    db_id bigint NOT NULL,
+    to_user_id bigint NOT NULL,
+    date_requested timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
+--
+-- Name: project_translations; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE project_translations (
+    db_id bigint NOT NULL,
+    language text NOT NULL,
+    one_line_description text,
+    full_description text,
+    readme text,
+    last_modified timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
+--
+-- Name: project_wiki_revisions; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE project_wiki_revisions (
+    db_id bigint NOT NULL,
+    page_name text NOT NULL,
+    revision integer NOT NULL,
+    body text NOT NULL,
+    edit_summary text,
+    user_id bigint,
+    date_created timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
+--
+-- Name: regeneration_hooks; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE regeneration_hooks (
+    db_id bigint NOT NULL,
+    source_url text NOT NULL,
+    token text NOT NULL,
+    last_commit text,
+    last_error text,
+    last_run timestamp with time zone,
+    date_created timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
+--
+-- Name: reserved_usernames; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE reserved_usernames (
+    pattern text NOT NULL,
+    reason text,
+    added_by text NOT NULL,
+    date_added timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
+--
+-- Name: scheduled_publications; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE scheduled_publications (
+    db_id bigint NOT NULL,
+    release_name text DEFAULT ''::text NOT NULL,
+    release_info jsonb,
+    publish_at timestamp with time zone NOT NULL,
+    date_scheduled timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
+--
+-- Name: short_urls; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE short_urls (
+    code text NOT NULL,
+    db_id bigint NOT NULL,
+    commit_id text DEFAULT ''::text NOT NULL,
+    created_by bigint,
+    date_created timestamp with time zone DEFAULT now() NOT NULL,
+    clicks bigint DEFAULT 0 NOT NULL,
+    last_click timestamp with time zone
+);
+
+
+--
+-- Name: sqlite_databases; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE sqlite_databases (
+    user_id bigint NOT NULL,
+    db_id bigint NOT NULL,
+    folder text NOT NULL,
+    db_name text NOT NULL,
+    public boolean DEFAULT false NOT NULL,
+    date_created timestamp with time zone DEFAULT now() NOT NULL,
+    last_modified timestamp with time zone DEFAULT now() NOT NULL,
+    watchers bigint DEFAULT 0 NOT NULL,
+    stars bigint DEFAULT 0 NOT NULL,
+    forks bigint DEFAULT 0 NOT NULL,
+    discussions bigint DEFAULT 0 NOT NULL,
+    merge_requests bigint DEFAULT 0 NOT NULL,
+    branches bigint DEFAULT 1 NOT NULL,
+    contributors bigint DEFAULT 1 NOT NULL,
+    one_line_description text,
+    full_description text,
+    root_database bigint,
+    forked_from bigint,
+    default_table text,
+    source_url text,
+    commit_list jsonb,
+    branch_heads jsonb,
+    tag_list jsonb,
+    default_branch text,
+    is_deleted boolean DEFAULT false NOT NULL,
+    tags integer DEFAULT 0 NOT NULL,
+    release_list jsonb,
+    release_count integer DEFAULT 0 NOT NULL,
+    download_count bigint DEFAULT 0,
+    page_views bigint DEFAULT 0,
+    project_tags text[] DEFAULT '{}'::text[] NOT NULL,
+    category_id bigint,
+    triangle_count bigint DEFAULT 0 NOT NULL,
+    moderation_status text DEFAULT 'pending'::text NOT NULL,
+    readme text,
+    archived boolean DEFAULT false NOT NULL,
+    is_draft boolean DEFAULT false NOT NULL,
+    analysed_at timestamp with time zone,
+    analysis_problem text
+);
+
+
+--
+-- Name: sqlite_databases_db_id_seq; Type: SEQUENCE; Schema: public; Owner: -
+--
+
+CREATE SEQUENCE sqlite_databases_db_id_seq
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+--
+-- Name: sqlite_databases_db_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: -
+--
+
+ALTER SEQUENCE sqlite_databases_db_id_seq OWNED BY sqlite_databases.db_id;
+
+
+--
+-- Name: users; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE users (
+    user_id bigint NOT NULL,
+    user_name text NOT NULL,
+    auth0_id text NOT NULL,
+    email text,
+    date_joined timestamp with time zone DEFAULT now() NOT NULL,
+    client_cert bytea NOT NULL,
+    password_hash text NOT NULL,
+    pref_max_rows integer DEFAULT 10 NOT NULL,
+    pref_locale text,
+    pref_theme text,
+    pref_timezone text,
+    pref_date_format text,
+    pref_email_frequency text,
+    digest_sent_at timestamp with time zone,
+    unsubscribe_token text,
+    watchers bigint DEFAULT 0 NOT NULL,
+    default_licence integer,
+    display_name text,
+    avatar_url text,
+    status_updates jsonb,
+    suspended boolean DEFAULT false NOT NULL,
+    sessions_revoked_at timestamp with time zone,
+    totp_secret bytea,
+    totp_last_step bigint DEFAULT 0 NOT NULL,
+    totp_failures integer DEFAULT 0 NOT NULL,
+    totp_locked_until timestamp with time zone,
+    plan text DEFAULT 'free'::text NOT NULL,
+    stripe_customer text,
+    stripe_subscription text,
+    quota_bytes_used bigint DEFAULT 0 NOT NULL,
+    quota_period_start timestamp with time zone DEFAULT now() NOT NULL,
+    bio text,
+    location text,
+    website text,
+    social_links jsonb,
+    tip_links jsonb,
+    last_seen timestamp with time zone,
+    auth0_email text
+);
+
+
+--
+-- Name: username_reclamations; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE username_reclamations (
+    user_id bigint NOT NULL,
+    requested_by text NOT NULL,
+    reason text,
+    date_notified timestamp with time zone DEFAULT now() NOT NULL,
+    reclaim_after timestamp with time zone NOT NULL
+);
+
+
+--
+-- Name: users_user_id_seq; Type: SEQUENCE; Schema: public; Owner: -
+--
+
+CREATE SEQUENCE users_user_id_seq
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+--
+-- Name: users_user_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: -
+--
+
+ALTER SEQUENCE users_user_id_seq OWNED BY users.user_id;
+
+
+--
+-- Name: watchers; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE watchers (
+    db_id bigint NOT NULL,
+    user_id bigint NOT NULL,
+    date_watched timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
+--
+-- Name: web_sessions; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE web_sessions (
+    session_id text NOT NULL,
+    session_data text NOT NULL,
+    expiry timestamp with time zone NOT NULL
+);
+
+
+--
+-- Name: admin_audit_log log_id; Type: DEFAULT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY admin_audit_log ALTER COLUMN log_id SET DEFAULT nextval('admin_audit_log_log_id_seq'::regclass);
+
+
+--
+-- Name: admin_webhooks hook_id; Type: DEFAULT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY admin_webhooks ALTER COLUMN hook_id SET DEFAULT nextval('admin_webhooks_hook_id_seq'::regclass);
+
+
+--
+-- Name: announcements announcement_id; Type: DEFAULT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY announcements ALTER COLUMN announcement_id SET DEFAULT nextval('announcements_announcement_id_seq'::regclass);
+
+
+--
+-- Name: background_jobs job_id; Type: DEFAULT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY background_jobs ALTER COLUMN job_id SET DEFAULT nextval('background_jobs_job_id_seq'::regclass);
+
+
+--
+-- Name: backup_runs run_id; Type: DEFAULT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY backup_runs ALTER COLUMN run_id SET DEFAULT nextval('backup_runs_run_id_seq'::regclass);
+
+
+--
+-- Name: categories cat_id; Type: DEFAULT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY categories ALTER COLUMN cat_id SET DEFAULT nextval('categories_cat_id_seq'::regclass);
+
+
+--
+-- Name: data_exports export_id; Type: DEFAULT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY data_exports ALTER COLUMN export_id SET DEFAULT nextval('data_exports_export_id_seq'::regclass);
+
+
+--
+-- Name: database_downloads dl_id; Type: DEFAULT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY database_downloads ALTER COLUMN dl_id SET DEFAULT nextval('database_downloads_dl_id_seq'::regclass);
+
+
+--
+-- Name: database_licences lic_id; Type: DEFAULT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY database_licences ALTER COLUMN lic_id SET DEFAULT nextval('database_licences_lic_id_seq'::regclass);
+
+
+--
+-- Name: database_uploads up_id; Type: DEFAULT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY database_uploads ALTER COLUMN up_id SET DEFAULT nextval('database_uploads_up_id_seq'::regclass);
+
+
+--
+-- Name: digest_items item_id; Type: DEFAULT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY digest_items ALTER COLUMN item_id SET DEFAULT nextval('digest_items_item_id_seq'::regclass);
+
+
+--
+-- Name: discussion_comments com_id; Type: DEFAULT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY discussion_comments ALTER COLUMN com_id SET DEFAULT nextval('discussion_comments_com_id_seq'::regclass);
+
+
+--
+-- Name: discussions internal_id; Type: DEFAULT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY discussions ALTER COLUMN internal_id SET DEFAULT nextval('discussions_disc_id_seq'::regclass);
+
+
+--
+-- Name: email_queue email_id; Type: DEFAULT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY email_queue ALTER COLUMN email_id SET DEFAULT nextval('email_queue_email_id_seq'::regclass);
+
+
+--
+-- Name: events event_id; Type: DEFAULT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY events ALTER COLUMN event_id SET DEFAULT nextval('events_event_id_seq'::regclass);
+
+
+--
+-- Name: github_imports import_id; Type: DEFAULT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY github_imports ALTER COLUMN import_id SET DEFAULT nextval('github_imports_import_id_seq'::regclass);
+
+
+--
+-- Name: guest_upload_links link_id; Type: DEFAULT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY guest_upload_links ALTER COLUMN link_id SET DEFAULT nextval('guest_upload_links_link_id_seq'::regclass);
+
+
+--
+-- Name: guest_uploads upload_id; Type: DEFAULT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY guest_uploads ALTER COLUMN upload_id SET DEFAULT nextval('guest_uploads_upload_id_seq'::regclass);
+
+
+--
+-- Name: held_comments held_id; Type: DEFAULT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY held_comments ALTER COLUMN held_id SET DEFAULT nextval('held_comments_held_id_seq'::regclass);
+
+
+--
+-- Name: ip_rules rule_id; Type: DEFAULT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY ip_rules ALTER COLUMN rule_id SET DEFAULT nextval('ip_rules_rule_id_seq'::regclass);
+
+
+--
+-- Name: project_remix_sources source_id; Type: DEFAULT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY project_remix_sources ALTER COLUMN source_id SET DEFAULT nextval('project_remix_sources_source_id_seq'::regclass);
+
+
+--
+-- Name: project_reports report_id; Type: DEFAULT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY project_reports ALTER COLUMN report_id SET DEFAULT nextval('project_reports_report_id_seq'::regclass);
+
+
+--
+-- Name: sqlite_databases db_id; Type: DEFAULT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY sqlite_databases ALTER COLUMN db_id SET DEFAULT nextval('sqlite_databases_db_id_seq'::regclass);
+
+
+--
+-- Name: users user_id; Type: DEFAULT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY users ALTER COLUMN user_id SET DEFAULT nextval('users_user_id_seq'::regclass);
+
+
+--
+-- Name: admin_audit_log admin_audit_log_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY admin_audit_log
+    ADD CONSTRAINT admin_audit_log_pkey PRIMARY KEY (log_id);
+
+
+--
+-- Name: admin_webhooks admin_webhooks_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY admin_webhooks
+    ADD CONSTRAINT admin_webhooks_pkey PRIMARY KEY (hook_id);
+
+
+--
+-- Name: announcements announcements_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY announcements
+    ADD CONSTRAINT announcements_pkey PRIMARY KEY (announcement_id);
+
+
+--
+-- Name: background_jobs background_jobs_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY background_jobs
+    ADD CONSTRAINT background_jobs_pkey PRIMARY KEY (job_id);
+
+
+--
+-- Name: backup_runs backup_runs_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY backup_runs
+    ADD CONSTRAINT backup_runs_pkey PRIMARY KEY (run_id);
+
+
+--
+-- Name: categories categories_parent_id_slug_key; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY categories
+    ADD CONSTRAINT categories_parent_id_slug_key UNIQUE (parent_id, slug);
+
+
+--
+-- Name: categories categories_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY categories
+    ADD CONSTRAINT categories_pkey PRIMARY KEY (cat_id);
+
+
+--
+-- Name: data_exports data_exports_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY data_exports
+    ADD CONSTRAINT data_exports_pkey PRIMARY KEY (export_id);
+
+
+--
+-- Name: database_downloads database_downloads_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY database_downloads
+    ADD CONSTRAINT database_downloads_pkey PRIMARY KEY (dl_id);
+
+
+--
+-- Name: database_files database_files_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY database_files
+    ADD CONSTRAINT database_files_pkey PRIMARY KEY (db_sha256);
+
+
+--
+-- Name: database_licences database_licences_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY database_licences
+    ADD CONSTRAINT database_licences_pkey PRIMARY KEY (user_id, friendly_name);
+
+
+--
+-- Name: database_stars database_stars_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY database_stars
+    ADD CONSTRAINT database_stars_pkey PRIMARY KEY (db_id, user_id);
+
+
+--
+-- Name: database_uploads database_uploads_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY database_uploads
+    ADD CONSTRAINT database_uploads_pkey PRIMARY KEY (up_id);
+
+
+--
+-- Name: digest_items digest_items_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY digest_items
+    ADD CONSTRAINT digest_items_pkey PRIMARY KEY (item_id);
+
+
+--
+-- Name: discussion_comments discussion_comments_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY discussion_comments
+    ADD CONSTRAINT discussion_comments_pkey PRIMARY KEY (com_id);
+
+
+--
+-- Name: discussions discussions_db_id_disc_id_unique; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY discussions
+    ADD CONSTRAINT discussions_db_id_disc_id_unique UNIQUE (db_id, disc_id);
+
+
+--
+-- Name: discussions discussions_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY discussions
+    ADD CONSTRAINT discussions_pkey PRIMARY KEY (internal_id);
+
+
+--
+-- Name: email_changes email_changes_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY email_changes
+    ADD CONSTRAINT email_changes_pkey PRIMARY KEY (user_id);
+
+
+--
+-- Name: email_queue email_queue_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY email_queue
+    ADD CONSTRAINT email_queue_pkey PRIMARY KEY (email_id);
+
+
+--
+-- Name: events events_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY events
+    ADD CONSTRAINT events_pkey PRIMARY KEY (event_id);
+
+
+--
+-- Name: feature_flags feature_flags_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY feature_flags
+    ADD CONSTRAINT feature_flags_pkey PRIMARY KEY (name);
+
+
+--
+-- Name: filter_words filter_words_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY filter_words
+    ADD CONSTRAINT filter_words_pkey PRIMARY KEY (pattern);
+
+
+--
+-- Name: flagged_usernames flagged_usernames_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY flagged_usernames
+    ADD CONSTRAINT flagged_usernames_pkey PRIMARY KEY (user_id);
+
+
+--
+-- Name: github_import_files github_import_files_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY github_import_files
+    ADD CONSTRAINT github_import_files_pkey PRIMARY KEY (import_id, repo_path);
+
+
+--
+-- Name: github_imports github_imports_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY github_imports
+    ADD CONSTRAINT github_imports_pkey PRIMARY KEY (import_id);
+
+
+--
+-- Name: github_mirrors github_mirrors_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY github_mirrors
+    ADD CONSTRAINT github_mirrors_pkey PRIMARY KEY (db_id);
+
+
+--
+-- Name: guest_upload_links guest_upload_links_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY guest_upload_links
+    ADD CONSTRAINT guest_upload_links_pkey PRIMARY KEY (link_id);
+
+
+--
+-- Name: guest_upload_links guest_upload_links_token_key; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY guest_upload_links
+    ADD CONSTRAINT guest_upload_links_token_key UNIQUE (token);
+
+
+--
+-- Name: guest_uploads guest_uploads_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY guest_uploads
+    ADD CONSTRAINT guest_uploads_pkey PRIMARY KEY (upload_id);
+
+
+--
+-- Name: held_comments held_comments_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY held_comments
+    ADD CONSTRAINT held_comments_pkey PRIMARY KEY (held_id);
+
+
+--
+-- Name: ip_rules ip_rules_network_key; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY ip_rules
+    ADD CONSTRAINT ip_rules_network_key UNIQUE (network);
+
+
+--
+-- Name: ip_rules ip_rules_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY ip_rules
+    ADD CONSTRAINT ip_rules_pkey PRIMARY KEY (rule_id);
+
+
+--
+-- Name: notification_prefs notification_prefs_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY notification_prefs
+    ADD CONSTRAINT notification_prefs_pkey PRIMARY KEY (user_id, event_type);
+
+
+--
+-- Name: object_checksums object_checksums_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY object_checksums
+    ADD CONSTRAINT object_checksums_pkey PRIMARY KEY (db_sha256);
+
+
+--
+-- Name: octoprint_links octoprint_links_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY octoprint_links
+    ADD CONSTRAINT octoprint_links_pkey PRIMARY KEY (user_id);
+
+
+--
+-- Name: project_daily_downloads project_daily_downloads_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY project_daily_downloads
+    ADD CONSTRAINT project_daily_downloads_pkey PRIMARY KEY (db_id, stat_date, db_sha256);
+
+
+--
+-- Name: project_daily_views project_daily_views_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY project_daily_views
+    ADD CONSTRAINT project_daily_views_pkey PRIMARY KEY (db_id, stat_date);
+
+
+--
+-- Name: project_redirects project_redirects_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY project_redirects
+    ADD CONSTRAINT project_redirects_pkey PRIMARY KEY (user_id, folder, db_name);
+
+
+--
+-- Name: project_referrers project_referrers_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY project_referrers
+    ADD CONSTRAINT project_referrers_pkey PRIMARY KEY (db_id, stat_date, referrer);
+
+
+--
+-- Name: project_remix_sources project_remix_sources_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY project_remix_sources
+    ADD CONSTRAINT project_remix_sources_pkey PRIMARY KEY (source_id);
+
+
+--
+-- Name: project_reports project_reports_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY project_reports
+    ADD CONSTRAINT project_reports_pkey PRIMARY KEY (report_id);
+
+
+--
+-- Name: project_templates project_templates_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY project_templates
+    ADD CONSTRAINT project_templates_pkey PRIMARY KEY (user_id, template_name);
+
+
+--
+-- Name: project_transfers project_transfers_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY project_transfers
+    ADD CONSTRAINT project_transfers_pkey PRIMARY KEY (db_id);
+
+
+--
+-- Name: project_translations project_translations_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY project_translations
+    ADD CONSTRAINT project_translations_pkey PRIMARY KEY (db_id, language);
+
+
+--
+-- Name: project_wiki_revisions project_wiki_revisions_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY project_wiki_revisions
+    ADD CONSTRAINT project_wiki_revisions_pkey PRIMARY KEY (db_id, page_name, revision);
+
+
+--
+-- Name: regeneration_hooks regeneration_hooks_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY regeneration_hooks
+    ADD CONSTRAINT regeneration_hooks_pkey PRIMARY KEY (db_id);
+
+
+--
+-- Name: reserved_usernames reserved_usernames_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY reserved_usernames
+    ADD CONSTRAINT reserved_usernames_pkey PRIMARY KEY (pattern);
+
+
+--
+-- Name: scheduled_publications scheduled_publications_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY scheduled_publications
+    ADD CONSTRAINT scheduled_publications_pkey PRIMARY KEY (db_id, release_name);
+
+
+--
+-- Name: short_urls short_urls_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY short_urls
+    ADD CONSTRAINT short_urls_pkey PRIMARY KEY (code);
+
+
+--
+-- Name: sqlite_databases sqlite_databases_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY sqlite_databases
+    ADD CONSTRAINT sqlite_databases_pkey PRIMARY KEY (db_id);
+
+
+--
+-- Name: sqlite_databases sqlite_databases_user_id_folder_db_name_key; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY sqlite_databases
+    ADD CONSTRAINT sqlite_databases_user_id_folder_db_name_key UNIQUE (user_id, folder, db_name);
+
+
+--
+-- Name: users users_auth0_id_key; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY users
+    ADD CONSTRAINT users_auth0_id_key UNIQUE (auth0_id);
+
+
+--
+-- Name: username_reclamations username_reclamations_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY username_reclamations
+    ADD CONSTRAINT username_reclamations_pkey PRIMARY KEY (user_id);
+
+
+--
+-- Name: users users_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY users
+    ADD CONSTRAINT users_pkey PRIMARY KEY (user_id);
+
+
+--
+-- Name: users users_user_name_key; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY users
+    ADD CONSTRAINT users_user_name_key UNIQUE (user_name);
+
+
+--
+-- Name: watchers watchers_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY watchers
+    ADD CONSTRAINT watchers_pkey PRIMARY KEY (db_id, user_id);
+
+
+--
+-- Name: web_sessions web_sessions_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY web_sessions
+    ADD CONSTRAINT web_sessions_pkey PRIMARY KEY (session_id);
+
+
+--
+-- Name: admin_audit_log_event_timestamp_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX admin_audit_log_event_timestamp_idx ON admin_audit_log USING btree (event_timestamp DESC);
+
+
+--
+-- Name: announcements_end_time_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX announcements_end_time_idx ON announcements USING btree (end_time);
+
+
+--
+-- Name: background_jobs_status_priority_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX background_jobs_status_priority_idx ON background_jobs USING btree (status, priority DESC, job_id);
+
+
+--
+-- Name: categories_parent_id_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX categories_parent_id_idx ON categories USING btree (parent_id);
+
+
+--
+-- Name: categories_top_level_slug_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE UNIQUE INDEX categories_top_level_slug_idx ON categories USING btree (slug) WHERE (parent_id IS NULL);
+
+
+--
+-- Name: database_downloads_db_id_download_date_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX database_downloads_db_id_download_date_idx ON database_downloads USING btree (db_id, download_date);
+
+
+--
+-- Name: database_licences_lic_id_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX database_licences_lic_id_idx ON database_licences USING btree (lic_id);
+
+
+--
+-- Name: database_licences_lic_sha256_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX database_licences_lic_sha256_idx ON database_licences USING btree (lic_sha256);
+
+
+--
+-- Name: database_licences_user_id_friendly_name_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX database_licences_user_id_friendly_name_idx ON database_licences USING btree (user_id, friendly_name);
+
+
+--
+-- Name: database_uploads_db_sha256_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX database_uploads_db_sha256_idx ON database_uploads USING btree (db_sha256);
+
+
+--
+-- Name: discussions_discussion_type_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX discussions_discussion_type_idx ON discussions USING btree (discussion_type);
+
+
+--
+-- Name: events_event_id_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX events_event_id_idx ON events USING btree (event_id);
+
+
+--
+-- Name: fki_database_downloads_db_id_fkey; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX fki_database_downloads_db_id_fkey ON database_downloads USING btree (db_id);
+
+
+--
+-- Name: fki_database_downloads_user_id_fkey; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX fki_database_downloads_user_id_fkey ON database_downloads USING btree (user_id);
+
+
+--
+-- Name: fki_database_uploads_db_id_fkey; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX fki_database_uploads_db_id_fkey ON database_uploads USING btree (db_id);
+
+
+--
+-- Name: fki_database_uploads_user_id_fkey; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX fki_database_uploads_user_id_fkey ON database_uploads USING btree (user_id);
+
+
+--
+-- Name: fki_discussion_comments_db_id_fkey; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX fki_discussion_comments_db_id_fkey ON discussion_comments USING btree (db_id);
+
+
+--
+-- Name: fki_discussions_source_db_id_fkey; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX fki_discussions_source_db_id_fkey ON discussions USING btree (mr_source_db_id);
+
+
+--
+-- Name: project_access_log_db_id_access_time_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX project_access_log_db_id_access_time_idx ON project_access_log USING btree (db_id, access_time DESC);
+
+
+--
+-- Name: project_remix_sources_db_id_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX project_remix_sources_db_id_idx ON project_remix_sources USING btree (db_id);
+
+
+--
+-- Name: project_reports_db_id_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX project_reports_db_id_idx ON project_reports USING btree (db_id) WHERE (resolved = false);
+
+
+--
+-- Name: scheduled_publications_publish_at_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX scheduled_publications_publish_at_idx ON scheduled_publications USING btree (publish_at);
+
+
+--
+-- Name: short_urls_db_id_commit_id_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE UNIQUE INDEX short_urls_db_id_commit_id_idx ON short_urls USING btree (db_id, commit_id);
+
+
+--
+-- Name: sqlite_databases_category_id_idx; Type: INDEX; Schema: public; Owner: -
 --
 
-CREATE SEQUENCE sqlite_databases_db_id_seq
-    START WITH 1
-    INCREMENT BY 1
-    NO MINVALUE
-    NO MAXVALUE
-    CACHE 1;
+CREATE INDEX sqlite_databases_category_id_idx ON sqlite_databases USING btree (category_id);
 
 
 --
--- Name: sqlite_databases_db_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: -
+-- Name: sqlite_databases_date_created_idx; Type: INDEX; Schema: public; Owner: -
 --
 
-ALTER SEQUENCE sqlite_databases_db_id_seq OWNED BY sqlite_databases.db_id;
+CREATE INDEX sqlite_databases_date_created_idx ON sqlite_databases USING btree (date_created DESC);
 
 
 --
--- Name: users; Type: TABLE; Schema: public; Owner: -
+-- Name: sqlite_databases_download_count_idx; Type: INDEX; Schema: public; Owner: -
 --
 
-CREATE TABLE users (
-    user_id bigint NOT NULL,
-    user_name text NOT NULL,
-    auth0_id text NOT NULL,
-    email text,
-    date_joined timestamp with time zone DEFAULT now() NOT NULL,
-    client_cert bytea NOT NULL,
-    password_hash text NOT NULL,
-    pref_max_rows integer DEFAULT 10 NOT NULL,
-    watchers bigint DEFAULT 0 NOT NULL,
-    default_licence integer,
-    display_name text,
-    avatar_url text,
-    status_updates jsonb
-);
+CREATE INDEX sqlite_databases_download_count_idx ON sqlite_databases USING btree (download_count DESC NULLS LAST, last_modified DESC);
 
 
 --
--- Name: users_user_id_seq; Type: SEQUENCE; Schema: public; Owner: -
+-- Name: sqlite_databases_last_modified_idx; Type: INDEX; Schema: public; Owner: -
 --
 
-CREATE SEQUENCE users_user_id_seq
-    START WITH 1
-    INCREMENT BY 1
-    NO MINVALUE
-    NO MAXVALUE
-    CACHE 1;
+CREATE INDEX sqlite_databases_last_modified_idx ON sqlite_databases USING btree (last_modified DESC);
 
 
 --
--- Name: users_user_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: -
+-- Name: sqlite_databases_moderation_status_idx; Type: INDEX; Schema: public; Owner: -
 --
 
-ALTER SEQUENCE users_user_id_seq OWNED BY users.user_id;
+CREATE INDEX sqlite_databases_moderation_status_idx ON sqlite_databases USING btree (moderation_status) WHERE (moderation_status <> 'approved'::text);
 
 
 --
--- Name: watchers; Type: TABLE; Schema: public; Owner: -
+-- Name: sqlite_databases_project_tags_idx; Type: INDEX; Schema: public; Owner: -
 --
 
-CREATE TABLE watchers (
-    db_id bigint NOT NULL,
-    user_id bigint NOT NULL,
-    date_watched timestamp with time zone DEFAULT now() NOT NULL
-);
+CREATE INDEX sqlite_databases_project_tags_idx ON sqlite_databases USING gin (project_tags);
 
 
 --
--- Name: database_downloads dl_id; Type: DEFAULT; Schema: public; Owner: -
+-- Name: sqlite_databases_search_idx; Type: INDEX; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY database_downloads ALTER COLUMN dl_id SET DEFAULT nextval('database_downloads_dl_id_seq'::regclass);
+CREATE INDEX sqlite_databases_search_idx ON sqlite_databases USING gin (((((setweight(to_tsvector('simple'::regconfig, regexp_replace(db_name, '[._-]'::text, ' '::text, 'g'::text)), 'A'::"char") || setweight(to_tsvector('english'::regconfig, COALESCE(one_line_description, ''::text)), 'B'::"char")) || setweight(to_tsvector('english'::regconfig, COALESCE(full_description, ''::text)), 'C'::"char")) || setweight(to_tsvector('english'::regconfig, project_tags_text(project_tags)), 'B'::"char"))));
 
 
 --
--- Name: database_licences lic_id; Type: DEFAULT; Schema: public; Owner: -
+-- Name: sqlite_databases_stars_idx; Type: INDEX; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY database_licences ALTER COLUMN lic_id SET DEFAULT nextval('database_licences_lic_id_seq'::regclass);
+CREATE INDEX sqlite_databases_stars_idx ON sqlite_databases USING btree (stars DESC, last_modified DESC);
 
 
 --
--- Name: database_uploads up_id; Type: DEFAULT; Schema: public; Owner: -
+-- Name: users_lower_user_name_idx; Type: INDEX; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY database_uploads ALTER COLUMN up_id SET DEFAULT nextval('database_uploads_up_id_seq'::regclass);
+CREATE INDEX users_lower_user_name_idx ON users USING btree (lower(user_name));
 
 
 --
--- Name: discussion_comments com_id; Type: DEFAULT; Schema: public; Owner: -
+-- Name: users_stripe_customer_idx; Type: INDEX; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY discussion_comments ALTER COLUMN com_id SET DEFAULT nextval('discussion_comments_com_id_seq'::regclass);
+CREATE INDEX users_stripe_customer_idx ON users USING btree (stripe_customer);
 
 
 --
--- Name: discussions internal_id; Type: DEFAULT; Schema: public; Owner: -
+-- Name: users_user_id_idx; Type: INDEX; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY discussions ALTER COLUMN internal_id SET DEFAULT nextval('discussions_disc_id_seq'::regclass);
+CREATE INDEX users_user_id_idx ON users USING btree (user_id);
 
 
 --
--- Name: email_queue email_id; Type: DEFAULT; Schema: public; Owner: -
+-- Name: users_user_name_idx; Type: INDEX; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY email_queue ALTER COLUMN email_id SET DEFAULT nextval('email_queue_email_id_seq'::regclass);
+CREATE INDEX users_user_name_idx ON users USING btree (user_name);
 
 
 --
--- Name: events event_id; Type: DEFAULT; Schema: public; Owner: -
+-- Name: watchers_db_id_idx; Type: INDEX; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY events ALTER COLUMN event_id SET DEFAULT nextval('events_event_id_seq'::regclass);
+CREATE INDEX watchers_db_id_idx ON watchers USING btree (db_id);
 
 
 --
--- Name: sqlite_databases db_id; Type: DEFAULT; Schema: public; Owner: -
+-- Name: web_sessions_expiry_idx; Type: INDEX; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY sqlite_databases ALTER COLUMN db_id SET DEFAULT nextval('sqlite_databases_db_id_seq'::regclass);
+CREATE INDEX web_sessions_expiry_idx ON web_sessions USING btree (expiry);
 
 
 --
--- Name: users user_id; Type: DEFAULT; Schema: public; Owner: -
+-- Name: categories categories_parent_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY users ALTER COLUMN user_id SET DEFAULT nextval('users_user_id_seq'::regclass);
+ALTER TABLE ONLY categories
+    ADD CONSTRAINT categories_parent_id_fkey FOREIGN KEY (parent_id) REFERENCES categories(cat_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: database_downloads database_downloads_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+-- Name: data_exports data_exports_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY data_exports
+    ADD CONSTRAINT data_exports_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(user_id) ON UPDATE CASCADE ON DELETE SET NULL;
+
+
+--
+-- Name: database_downloads database_downloads_db_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
 ALTER TABLE ONLY database_downloads
-    ADD CONSTRAINT database_downloads_pkey PRIMARY KEY (dl_id);
+    ADD CONSTRAINT database_downloads_db_id_fkey FOREIGN KEY (db_id) REFERENCES sqlite_databases(db_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: database_files database_files_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+-- Name: database_downloads database_downloads_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY database_files
-    ADD CONSTRAINT database_files_pkey PRIMARY KEY (db_sha256);
+ALTER TABLE ONLY database_downloads
+    ADD CONSTRAINT database_downloads_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(user_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: database_licences database_licences_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+-- Name: database_licences database_licences_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
 ALTER TABLE ONLY database_licences
-    ADD CONSTRAINT database_licences_pkey PRIMARY KEY (user_id, friendly_name);
+    ADD CONSTRAINT database_licences_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(user_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: database_stars database_stars_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+-- Name: database_stars database_stars_db_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
 ALTER TABLE ONLY database_stars
-    ADD CONSTRAINT database_stars_pkey PRIMARY KEY (db_id, user_id);
+    ADD CONSTRAINT database_stars_db_id_fkey FOREIGN KEY (db_id) REFERENCES sqlite_databases(db_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: database_uploads database_uploads_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+-- Name: database_stars database_stars_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY database_stars
+    ADD CONSTRAINT database_stars_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(user_id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+
+--
+-- Name: database_uploads database_uploads_db_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
 ALTER TABLE ONLY database_uploads
-    ADD CONSTRAINT database_uploads_pkey PRIMARY KEY (up_id);
+    ADD CONSTRAINT database_uploads_db_id_fkey FOREIGN KEY (db_id) REFERENCES sqlite_databases(db_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: discussion_comments discussion_comments_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+-- Name: database_uploads database_uploads_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY database_uploads
+    ADD CONSTRAINT database_uploads_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(user_id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+
+--
+-- Name: digest_items digest_items_db_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY digest_items
+    ADD CONSTRAINT digest_items_db_id_fkey FOREIGN KEY (db_id) REFERENCES sqlite_databases(db_id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+
+--
+-- Name: digest_items digest_items_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY digest_items
+    ADD CONSTRAINT digest_items_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(user_id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+
+--
+-- Name: discussion_comments discussion_comments_commenter_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
 ALTER TABLE ONLY discussion_comments
-    ADD CONSTRAINT discussion_comments_pkey PRIMARY KEY (com_id);
+    ADD CONSTRAINT discussion_comments_commenter_fkey FOREIGN KEY (commenter) REFERENCES users(user_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: discussions discussions_db_id_disc_id_unique; Type: CONSTRAINT; Schema: public; Owner: -
+-- Name: discussion_comments discussion_comments_db_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY discussion_comments
+    ADD CONSTRAINT discussion_comments_db_id_fkey FOREIGN KEY (db_id) REFERENCES sqlite_databases(db_id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+
+--
+-- Name: discussion_comments discussion_comments_disc_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY discussion_comments
+    ADD CONSTRAINT discussion_comments_disc_id_fkey FOREIGN KEY (disc_id) REFERENCES discussions(internal_id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+
+--
+-- Name: discussions discussions_accepted_answer_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
 ALTER TABLE ONLY discussions
-    ADD CONSTRAINT discussions_db_id_disc_id_unique UNIQUE (db_id, disc_id);
+    ADD CONSTRAINT discussions_accepted_answer_fkey FOREIGN KEY (accepted_answer) REFERENCES discussion_comments(com_id) ON UPDATE CASCADE ON DELETE SET NULL;
 
 
 --
--- Name: discussions discussions_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+-- Name: discussions discussions_db_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
 ALTER TABLE ONLY discussions
-    ADD CONSTRAINT discussions_pkey PRIMARY KEY (internal_id);
+    ADD CONSTRAINT discussions_db_id_fkey FOREIGN KEY (db_id) REFERENCES sqlite_databases(db_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: email_queue email_queue_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+-- Name: discussions discussions_mr_source_db_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY email_queue
-    ADD CONSTRAINT email_queue_pkey PRIMARY KEY (email_id);
+ALTER TABLE ONLY discussions
+    ADD CONSTRAINT discussions_mr_source_db_id_fkey FOREIGN KEY (mr_source_db_id) REFERENCES sqlite_databases(db_id) ON UPDATE SET NULL ON DELETE SET NULL;
 
 
 --
--- Name: events events_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+-- Name: discussions discussions_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY events
-    ADD CONSTRAINT events_pkey PRIMARY KEY (event_id);
+ALTER TABLE ONLY discussions
+    ADD CONSTRAINT discussions_user_id_fkey FOREIGN KEY (creator) REFERENCES users(user_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: sqlite_databases sqlite_databases_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+-- Name: email_changes email_changes_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY sqlite_databases
-    ADD CONSTRAINT sqlite_databases_pkey PRIMARY KEY (db_id);
+ALTER TABLE ONLY email_changes
+    ADD CONSTRAINT email_changes_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(user_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: sqlite_databases sqlite_databases_user_id_folder_db_name_key; Type: CONSTRAINT; Schema: public; Owner: -
+-- Name: events events_db_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY sqlite_databases
-    ADD CONSTRAINT sqlite_databases_user_id_folder_db_name_key UNIQUE (user_id, folder, db_name);
+ALTER TABLE ONLY events
+    ADD CONSTRAINT events_db_id_fkey FOREIGN KEY (db_id) REFERENCES sqlite_databases(db_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: users users_auth0_id_key; Type: CONSTRAINT; Schema: public; Owner: -
+-- Name: flagged_usernames flagged_usernames_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY users
-    ADD CONSTRAINT users_auth0_id_key UNIQUE (auth0_id);
+ALTER TABLE ONLY flagged_usernames
+    ADD CONSTRAINT flagged_usernames_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(user_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: users users_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+-- Name: github_import_files github_import_files_db_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY users
-    ADD CONSTRAINT users_pkey PRIMARY KEY (user_id);
+ALTER TABLE ONLY github_import_files
+    ADD CONSTRAINT github_import_files_db_id_fkey FOREIGN KEY (db_id) REFERENCES sqlite_databases(db_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: users users_user_name_key; Type: CONSTRAINT; Schema: public; Owner: -
+-- Name: github_import_files github_import_files_import_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY users
-    ADD CONSTRAINT users_user_name_key UNIQUE (user_name);
+ALTER TABLE ONLY github_import_files
+    ADD CONSTRAINT github_import_files_import_id_fkey FOREIGN KEY (import_id) REFERENCES github_imports(import_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: watchers watchers_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+-- Name: github_imports github_imports_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY watchers
-    ADD CONSTRAINT watchers_pkey PRIMARY KEY (db_id, user_id);
+ALTER TABLE ONLY github_imports
+    ADD CONSTRAINT github_imports_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(user_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: database_licences_lic_id_idx; Type: INDEX; Schema: public; Owner: -
+-- Name: github_mirrors github_mirrors_db_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-CREATE INDEX database_licences_lic_id_idx ON database_licences USING btree (lic_id);
+ALTER TABLE ONLY github_mirrors
+    ADD CONSTRAINT github_mirrors_db_id_fkey FOREIGN KEY (db_id) REFERENCES sqlite_databases(db_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: database_licences_lic_sha256_idx; Type: INDEX; Schema: public; Owner: -
+-- Name: guest_upload_links guest_upload_links_db_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-CREATE INDEX database_licences_lic_sha256_idx ON database_licences USING btree (lic_sha256);
+ALTER TABLE ONLY guest_upload_links
+    ADD CONSTRAINT guest_upload_links_db_id_fkey FOREIGN KEY (db_id) REFERENCES sqlite_databases(db_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: database_licences_user_id_friendly_name_idx; Type: INDEX; Schema: public; Owner: -
+-- Name: guest_uploads guest_uploads_db_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-CREATE INDEX database_licences_user_id_friendly_name_idx ON database_licences USING btree (user_id, friendly_name);
+ALTER TABLE ONLY guest_uploads
+    ADD CONSTRAINT guest_uploads_db_id_fkey FOREIGN KEY (db_id) REFERENCES sqlite_databases(db_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: discussions_discussion_type_idx; Type: INDEX; Schema: public; Owner: -
+-- Name: held_comments held_comments_commenter_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-CREATE INDEX discussions_discussion_type_idx ON discussions USING btree (discussion_type);
+ALTER TABLE ONLY held_comments
+    ADD CONSTRAINT held_comments_commenter_fkey FOREIGN KEY (commenter) REFERENCES users(user_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: events_event_id_idx; Type: INDEX; Schema: public; Owner: -
+-- Name: held_comments held_comments_db_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-CREATE INDEX events_event_id_idx ON events USING btree (event_id);
+ALTER TABLE ONLY held_comments
+    ADD CONSTRAINT held_comments_db_id_fkey FOREIGN KEY (db_id) REFERENCES sqlite_databases(db_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: fki_database_downloads_db_id_fkey; Type: INDEX; Schema: public; Owner: -
+-- Name: notification_prefs notification_prefs_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-CREATE INDEX fki_database_downloads_db_id_fkey ON database_downloads USING btree (db_id);
+ALTER TABLE ONLY notification_prefs
+    ADD CONSTRAINT notification_prefs_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(user_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: fki_database_downloads_user_id_fkey; Type: INDEX; Schema: public; Owner: -
+-- Name: octoprint_links octoprint_links_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-CREATE INDEX fki_database_downloads_user_id_fkey ON database_downloads USING btree (user_id);
+ALTER TABLE ONLY octoprint_links
+    ADD CONSTRAINT octoprint_links_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(user_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: fki_database_uploads_db_id_fkey; Type: INDEX; Schema: public; Owner: -
+-- Name: project_access_log project_access_log_db_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-CREATE INDEX fki_database_uploads_db_id_fkey ON database_uploads USING btree (db_id);
+ALTER TABLE ONLY project_access_log
+    ADD CONSTRAINT project_access_log_db_id_fkey FOREIGN KEY (db_id) REFERENCES sqlite_databases(db_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: fki_database_uploads_user_id_fkey; Type: INDEX; Schema: public; Owner: -
+-- Name: project_daily_downloads project_daily_downloads_db_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-CREATE INDEX fki_database_uploads_user_id_fkey ON database_uploads USING btree (user_id);
+ALTER TABLE ONLY project_daily_downloads
+    ADD CONSTRAINT project_daily_downloads_db_id_fkey FOREIGN KEY (db_id) REFERENCES sqlite_databases(db_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: fki_discussion_comments_db_id_fkey; Type: INDEX; Schema: public; Owner: -
+-- Name: project_daily_views project_daily_views_db_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-CREATE INDEX fki_discussion_comments_db_id_fkey ON discussion_comments USING btree (db_id);
+ALTER TABLE ONLY project_daily_views
+    ADD CONSTRAINT project_daily_views_db_id_fkey FOREIGN KEY (db_id) REFERENCES sqlite_databases(db_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: fki_discussions_source_db_id_fkey; Type: INDEX; Schema: public; Owner: -
+-- Name: project_redirects project_redirects_db_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-CREATE INDEX fki_discussions_source_db_id_fkey ON discussions USING btree (mr_source_db_id);
+ALTER TABLE ONLY project_redirects
+    ADD CONSTRAINT project_redirects_db_id_fkey FOREIGN KEY (db_id) REFERENCES sqlite_databases(db_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: users_lower_user_name_idx; Type: INDEX; Schema: public; Owner: -
+-- Name: project_redirects project_redirects_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-CREATE INDEX users_lower_user_name_idx ON users USING btree (lower(user_name));
+ALTER TABLE ONLY project_redirects
+    ADD CONSTRAINT project_redirects_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(user_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: users_user_id_idx; Type: INDEX; Schema: public; Owner: -
+-- Name: project_referrers project_referrers_db_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-CREATE INDEX users_user_id_idx ON users USING btree (user_id);
+ALTER TABLE ONLY project_referrers
+    ADD CONSTRAINT project_referrers_db_id_fkey FOREIGN KEY (db_id) REFERENCES sqlite_databases(db_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: users_user_name_idx; Type: INDEX; Schema: public; Owner: -
+-- Name: project_remix_sources project_remix_sources_db_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-CREATE INDEX users_user_name_idx ON users USING btree (user_name);
+ALTER TABLE ONLY project_remix_sources
+    ADD CONSTRAINT project_remix_sources_db_id_fkey FOREIGN KEY (db_id) REFERENCES sqlite_databases(db_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: watchers_db_id_idx; Type: INDEX; Schema: public; Owner: -
+-- Name: project_remix_sources project_remix_sources_source_db_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-CREATE INDEX watchers_db_id_idx ON watchers USING btree (db_id);
+ALTER TABLE ONLY project_remix_sources
+    ADD CONSTRAINT project_remix_sources_source_db_id_fkey FOREIGN KEY (source_db_id) REFERENCES sqlite_databases(db_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: database_downloads database_downloads_db_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+-- Name: project_reports project_reports_db_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY database_downloads
-    ADD CONSTRAINT database_downloads_db_id_fkey FOREIGN KEY (db_id) REFERENCES sqlite_databases(db_id) ON UPDATE CASCADE ON DELETE CASCADE;
+ALTER TABLE ONLY project_reports
+    ADD CONSTRAINT project_reports_db_id_fkey FOREIGN KEY (db_id) REFERENCES sqlite_databases(db_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: database_downloads database_downloads_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+-- Name: project_reports project_reports_reporter_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY database_downloads
-    ADD CONSTRAINT database_downloads_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(user_id) ON UPDATE CASCADE ON DELETE CASCADE;
+ALTER TABLE ONLY project_reports
+    ADD CONSTRAINT project_reports_reporter_id_fkey FOREIGN KEY (reporter_id) REFERENCES users(user_id) ON UPDATE CASCADE ON DELETE SET NULL;
 
 
 --
--- Name: database_licences database_licences_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+-- Name: project_templates project_templates_category_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY database_licences
-    ADD CONSTRAINT database_licences_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(user_id) ON UPDATE CASCADE ON DELETE CASCADE;
+ALTER TABLE ONLY project_templates
+    ADD CONSTRAINT project_templates_category_id_fkey FOREIGN KEY (category_id) REFERENCES categories(cat_id) ON UPDATE CASCADE ON DELETE SET NULL;
 
 
 --
--- Name: database_stars database_stars_db_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+-- Name: project_templates project_templates_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY database_stars
-    ADD CONSTRAINT database_stars_db_id_fkey FOREIGN KEY (db_id) REFERENCES sqlite_databases(db_id) ON UPDATE CASCADE ON DELETE CASCADE;
+ALTER TABLE ONLY project_templates
+    ADD CONSTRAINT project_templates_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(user_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: database_stars database_stars_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+-- Name: project_transfers project_transfers_db_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY database_stars
-    ADD CONSTRAINT database_stars_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(user_id) ON UPDATE CASCADE ON DELETE CASCADE;
+ALTER TABLE ONLY project_transfers
+    ADD CONSTRAINT project_transfers_db_id_fkey FOREIGN KEY (db_id) REFERENCES sqlite_databases(db_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: database_uploads database_uploads_db_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+-- Name: project_transfers project_transfers_to_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY database_uploads
-    ADD CONSTRAINT database_uploads_db_id_fkey FOREIGN KEY (db_id) REFERENCES sqlite_databases(db_id) ON UPDATE CASCADE ON DELETE CASCADE;
+ALTER TABLE ONLY project_transfers
+    ADD CONSTRAINT project_transfers_to_user_id_fkey FOREIGN KEY (to_user_id) REFERENCES users(user_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: database_uploads database_uploads_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+-- Name: project_translations project_translations_db_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY database_uploads
-    ADD CONSTRAINT database_uploads_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(user_id) ON UPDATE CASCADE ON DELETE CASCADE;
+ALTER TABLE ONLY project_translations
+    ADD CONSTRAINT project_translations_db_id_fkey FOREIGN KEY (db_id) REFERENCES sqlite_databases(db_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: discussion_comments discussion_comments_commenter_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+-- Name: project_wiki_revisions project_wiki_revisions_db_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY discussion_comments
-    ADD CONSTRAINT discussion_comments_commenter_fkey FOREIGN KEY (commenter) REFERENCES users(user_id) ON UPDATE CASCADE ON DELETE CASCADE;
+ALTER TABLE ONLY project_wiki_revisions
+    ADD CONSTRAINT project_wiki_revisions_db_id_fkey FOREIGN KEY (db_id) REFERENCES sqlite_databases(db_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: discussion_comments discussion_comments_db_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+-- Name: project_wiki_revisions project_wiki_revisions_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY discussion_comments
-    ADD CONSTRAINT discussion_comments_db_id_fkey FOREIGN KEY (db_id) REFERENCES sqlite_databases(db_id) ON UPDATE CASCADE ON DELETE CASCADE;
+ALTER TABLE ONLY project_wiki_revisions
+    ADD CONSTRAINT project_wiki_revisions_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(user_id) ON UPDATE CASCADE ON DELETE SET NULL;
 
 
 --
--- Name: discussion_comments discussion_comments_disc_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+-- Name: regeneration_hooks regeneration_hooks_db_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY discussion_comments
-    ADD CONSTRAINT discussion_comments_disc_id_fkey FOREIGN KEY (disc_id) REFERENCES discussions(internal_id) ON UPDATE CASCADE ON DELETE CASCADE;
+ALTER TABLE ONLY regeneration_hooks
+    ADD CONSTRAINT regeneration_hooks_db_id_fkey FOREIGN KEY (db_id) REFERENCES sqlite_databases(db_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: discussions discussions_db_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+-- Name: scheduled_publications scheduled_publications_db_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY discussions
-    ADD CONSTRAINT discussions_db_id_fkey FOREIGN KEY (db_id) REFERENCES sqlite_databases(db_id) ON UPDATE CASCADE ON DELETE CASCADE;
+ALTER TABLE ONLY scheduled_publications
+    ADD CONSTRAINT scheduled_publications_db_id_fkey FOREIGN KEY (db_id) REFERENCES sqlite_databases(db_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: discussions discussions_mr_source_db_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+-- Name: short_urls short_urls_created_by_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY discussions
-    ADD CONSTRAINT discussions_mr_source_db_id_fkey FOREIGN KEY (mr_source_db_id) REFERENCES sqlite_databases(db_id) ON UPDATE SET NULL ON DELETE SET NULL;
+ALTER TABLE ONLY short_urls
+    ADD CONSTRAINT short_urls_created_by_fkey FOREIGN KEY (created_by) REFERENCES users(user_id) ON UPDATE CASCADE ON DELETE SET NULL;
 
 
 --
--- Name: discussions discussions_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+-- Name: short_urls short_urls_db_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY discussions
-    ADD CONSTRAINT discussions_user_id_fkey FOREIGN KEY (creator) REFERENCES users(user_id) ON UPDATE CASCADE ON DELETE CASCADE;
+ALTER TABLE ONLY short_urls
+    ADD CONSTRAINT short_urls_db_id_fkey FOREIGN KEY (db_id) REFERENCES sqlite_databases(db_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
 --
--- Name: events events_db_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+-- Name: sqlite_databases sqlite_databases_category_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
-ALTER TABLE ONLY events
-    ADD CONSTRAINT events_db_id_fkey FOREIGN KEY (db_id) REFERENCES sqlite_databases(db_id) ON UPDATE CASCADE ON DELETE CASCADE;
+ALTER TABLE ONLY sqlite_databases
+    ADD CONSTRAINT sqlite_databases_category_id_fkey FOREIGN KEY (category_id) REFERENCES categories(cat_id) ON UPDATE CASCADE ON DELETE SET NULL;
 
 
 --
@@ -837,6 +2625,14 @@ ALTER TABLE ONLY sqlite_databases
     ADD CONSTRAINT sqlite_databases_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(user_id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
+--
+-- Name: username_reclamations username_reclamations_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY username_reclamations
+    ADD CONSTRAINT username_reclamations_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(user_id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+
 --
 -- Name: watchers watchers_db_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
@@ -10,7 +10,6 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"net/url"
 	"os"
@@ -51,31 +50,40 @@ func main() {
 	// Read server configuration
 	var err error
 	if err = com.ReadConfig(); err != nil {
-		log.Fatalf("Configuration file problem\n\n%v", err)
+		com.Log.Fatalf("Configuration file problem\n\n%v", err)
 	}
 
+	// Reload the configuration file when sent a SIGHUP
+	com.ReloadConfigOnSignal()
+
 	// Set the temp dir environment variable
-	err = os.Setenv("TMPDIR", com.Conf.DiskCache.Directory)
+	err = os.Setenv("TMPDIR", com.Conf().DiskCache.Directory)
 	if err != nil {
-		log.Fatalf("Setting temp directory environment variable failed: '%s'\n", err.Error())
+		com.Log.Fatalf("Setting temp directory environment variable failed: '%s'", err.Error())
 	}
 
 	// Connect to Minio server
 	err = com.ConnectMinio()
 	if err != nil {
-		log.Fatalf(err.Error())
+		com.Log.Fatalf(err.Error())
 	}
 
 	// Connect to PostgreSQL server
 	err = com.ConnectPostgreSQL()
 	if err != nil {
-		log.Fatalf(err.Error())
+		com.Log.Fatalf(err.Error())
+	}
+
+	// Set up the search backend
+	err = com.ConnectSearch()
+	if err != nil {
+		com.Log.Fatalf(err.Error())
 	}
 
 	// Connect to the Memcached server
 	err = com.ConnectCache()
 	if err != nil {
-		log.Fatalf(err.Error())
+		com.Log.Fatalf(err.Error())
 	}
 
 	// Add the default user to the system
@@ -89,12 +97,15 @@ func main() {
 	// Add the default licences to PostgreSQL
 	err = com.AddDefaultLicences()
 	if err != nil {
-		log.Fatalf(err.Error())
+		com.Log.Fatalf(err.Error())
 	}
 
+	// Start the project access log flushing routine in the background
+	go com.FlushProjectAccessLog()
+
 	// Load our self signed CA chain
 	ourCAPool = x509.NewCertPool()
-	certFile, err := ioutil.ReadFile(com.Conf.DB4S.CAChain)
+	certFile, err := ioutil.ReadFile(com.Conf().DB4S.CAChain)
 	if err != nil {
 		fmt.Printf("Error opening Certificate Authority chain file: %v\n", err)
 		return
@@ -109,11 +120,13 @@ func main() {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", rootHandler)
 	mux.HandleFunc("/branch/list", branchListHandler)
+	mux.HandleFunc("/downloadstats/get", downloadStatsGetHandler)
 	mux.HandleFunc("/licence/add", licenceAddHandler)
 	mux.HandleFunc("/licence/get", licenceGetHandler)
 	mux.HandleFunc("/licence/list", licenceListHandler)
 	mux.HandleFunc("/licence/remove", licenceRemoveHandler)
 	mux.HandleFunc("/metadata/get", metadataGetHandler)
+	mux.HandleFunc("/v1/", putHandler)
 
 	// Load our self signed CA Cert chain, request client certificates, and set TLS1.2 as minimum
 	newTLSConfig := &tls.Config{
@@ -124,22 +137,22 @@ func main() {
 		RootCAs:                  ourCAPool,
 	}
 	newServer := &http.Server{
-		Addr:         ":" + fmt.Sprint(com.Conf.DB4S.Port),
+		Addr:         ":" + fmt.Sprint(com.Conf().DB4S.Port),
 		Handler:      mux,
 		TLSConfig:    newTLSConfig,
 		TLSNextProto: make(map[string]func(*http.Server, *tls.Conn, http.Handler), 0),
 	}
 
 	// Generate the formatted server string
-	if com.Conf.DB4S.Port == 443 {
-		server = fmt.Sprintf("https://%s", com.Conf.DB4S.Server)
+	if com.Conf().DB4S.Port == 443 {
+		server = fmt.Sprintf("https://%s", com.Conf().DB4S.Server)
 	} else {
-		server = fmt.Sprintf("https://%s:%d", com.Conf.DB4S.Server, com.Conf.DB4S.Port)
+		server = fmt.Sprintf("https://%s:%d", com.Conf().DB4S.Server, com.Conf().DB4S.Port)
 	}
 
 	// Start server
-	log.Printf("Starting DB4S end point on %s\n", server)
-	log.Fatal(newServer.ListenAndServeTLS(com.Conf.DB4S.Certificate, com.Conf.DB4S.CertificateKey))
+	com.Log.Infof("Starting DB4S end point on %s", server)
+	com.Log.Fatal(newServer.ListenAndServeTLS(com.Conf().DB4S.Certificate, com.Conf().DB4S.CertificateKey))
 }
 
 // Returns the list of branches for a database
@@ -194,7 +207,7 @@ func branchListHandler(w http.ResponseWriter, r *http.Request) {
 	jsonList, err := json.MarshalIndent(info, "", "  ")
 	if err != nil {
 		errMsg := fmt.Sprintf("Error when JSON marshalling the branch list: %v\n", err)
-		log.Print(errMsg)
+		com.Log.Error(errMsg)
 		http.Error(w, errMsg, http.StatusBadRequest)
 		return
 	}
@@ -202,6 +215,60 @@ func branchListHandler(w http.ResponseWriter, r *http.Request) {
 	return
 }
 
+// Returns the downloads of each version and file of a database over time, for the database's owner
+func downloadStatsGetHandler(w http.ResponseWriter, r *http.Request) {
+	// Extract the account name and associated server from the validated client certificate
+	userAcc, _, err := extractUserAndServer(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Extract and validate the form variables
+	owner, folder, fileName, err := com.GetUFD(r, true)
+	if err != nil {
+		http.Error(w, "Missing or incorrect data supplied", http.StatusBadRequest)
+		return
+	}
+	interval, days, err := com.GetFormDownloadStatsPeriod(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The download statistics are only for the database's owner
+	if strings.ToLower(owner) != strings.ToLower(userAcc) {
+		http.Error(w, "Download statistics are only available to the database owner", http.StatusForbidden)
+		return
+	}
+	exists, err := com.CheckFileExists(userAcc, owner, folder, fileName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, fmt.Sprintf("Database '%s%s%s' doesn't exist", owner, folder, fileName),
+			http.StatusNotFound)
+		return
+	}
+
+	// Return the statistics as JSON
+	stats, err := com.ProjectDownloadStats(owner, folder, fileName, interval, days)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	jsonList, err := json.MarshalIndent(stats, "", "  ")
+	if err != nil {
+		errMsg := fmt.Sprintf("Error when JSON marshalling the download statistics: %v\n", err)
+		com.Log.Error(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonList)
+}
+
 func extractUserAndServer(w http.ResponseWriter, r *http.Request) (userAcc string, certServer string, err error) {
 
 	// Extract the account name and associated server from the validated client certificate
@@ -225,7 +292,7 @@ func extractUserAndServer(w http.ResponseWriter, r *http.Request) (userAcc strin
 	}
 
 	// Verify the running server matches the one in the certificate
-	runningServer := com.Conf.DB4S.Server
+	runningServer := com.Conf().DB4S.Server
 	if certServer != runningServer {
 		err = fmt.Errorf("Server name in certificate '%s' doesn't match running server '%s'\n", certServer,
 			runningServer)
@@ -276,7 +343,7 @@ func generateDefaultList(pageName string, userAcc string) (defaultList []byte, e
 		// Use json.MarshalIndent() for nicer looking output
 		defaultList, err = json.MarshalIndent(linkRows, "", "  ")
 		if err != nil {
-			log.Printf("%s: Error when JSON marshalling the default list: %v\n", pageName, err)
+			com.Log.Errorf("%s: Error when JSON marshalling the default list: %v", pageName, err)
 			return nil, errors.Wrap(err, fmt.Sprintf("%s: Error when JSON marshalling the default list",
 				pageName))
 		}
@@ -334,7 +401,7 @@ func getHandler(w http.ResponseWriter, r *http.Request, userAcc string) {
 
 	// Use easily understandable variable names
 	owner := pathStrings[1]
-	fileName := pathStrings[2]
+	fileName := com.NormaliseName(pathStrings[2])
 
 	// TODO: Add support for folders
 	folder := "/"
@@ -443,7 +510,7 @@ func licenceAddHandler(w http.ResponseWriter, r *http.Request) {
 
 	// The "public" user isn't allowed to make changes
 	if userAcc == "public" {
-		log.Printf("User from '%s' attempted to add a licence using the public certificate", r.RemoteAddr)
+		com.Log.Warnf("User from '%s' attempted to add a licence using the public certificate", r.RemoteAddr)
 		http.Error(w, "You're using the 'public' certificate, which isn't allowed to make changes on the server",
 			http.StatusUnauthorized)
 		return
@@ -454,8 +521,8 @@ func licenceAddHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w,
 			fmt.Sprintf("Licence file is too large. Maximum licence upload size is %d MB, yours is %d MB",
 				com.MaxLicenceSize, r.ContentLength/1024/1024), http.StatusBadRequest)
-		log.Println(fmt.Sprintf("'%s' attempted to upload an oversized licence %d MB in size.  Limit is %d MB\n",
-			userAcc, r.ContentLength/1024/1024, com.MaxLicenceSize))
+		com.Log.Warnf("'%s' attempted to upload an oversized licence %d MB in size.  Limit is %d MB",
+			userAcc, r.ContentLength/1024/1024, com.MaxLicenceSize)
 		return
 	}
 
@@ -467,7 +534,7 @@ func licenceAddHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	err = com.ValidateLicence(l)
 	if err != nil {
-		log.Printf("Validation failed for licence ID: '%s': %s", l, err)
+		com.Log.Errorf("Validation failed for licence ID: '%s': %s", l, err)
 		http.Error(w, "Validation of licence ID failed", http.StatusBadRequest)
 		return
 	}
@@ -478,13 +545,25 @@ func licenceAddHandler(w http.ResponseWriter, r *http.Request) {
 	if z := r.FormValue("licence_name"); z != "" {
 		err = com.ValidateLicenceFullName(z)
 		if err != nil {
-			log.Printf("Validation failed for licence full name: '%s': %s", z, err)
+			com.Log.Errorf("Validation failed for licence full name: '%s': %s", z, err)
 			http.Error(w, "Validation of licence full name failed", http.StatusBadRequest)
 			return
 		}
 		licName = z
 	}
 
+	// If an (optional) SPDX identifier for the licence was provided, then validate it
+	var spdxID string
+	if z := r.FormValue("spdx_id"); z != "" {
+		err = com.ValidateSPDXID(z)
+		if err != nil {
+			com.Log.Errorf("Validation failed for SPDX identifier: '%s': %s", z, err)
+			http.Error(w, "Validation of SPDX identifier failed", http.StatusBadRequest)
+			return
+		}
+		spdxID = z
+	}
+
 	// The display order parameter is required
 	do := r.FormValue("display_order")
 	dispOrder, err := strconv.Atoi(do)
@@ -539,7 +618,7 @@ func licenceAddHandler(w http.ResponseWriter, r *http.Request) {
 	// Grab the uploaded file and form variables
 	tempFile, _, err := r.FormFile("file1")
 	if err != nil {
-		log.Printf("Uploading licence failed: %v\n", err)
+		com.Log.Errorf("Uploading licence failed: %v", err)
 		http.Error(w, fmt.Sprintf("Something went wrong when extracting the licence text: '%s'", err.Error()),
 			http.StatusBadRequest)
 		return
@@ -569,7 +648,7 @@ func licenceAddHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Save the licence in the database
-	err = com.StoreLicence(userAcc, licID, licText.Bytes(), sourceURL, dispOrder, licName, fileFormat)
+	err = com.StoreLicence(userAcc, licID, licText.Bytes(), sourceURL, dispOrder, licName, fileFormat, spdxID)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Something went wrong when storing the new licence file: '%s'", err.Error()),
 			http.StatusInternalServerError)
@@ -581,7 +660,7 @@ func licenceAddHandler(w http.ResponseWriter, r *http.Request) {
 	_, _ = fmt.Fprintf(w, "Success")
 
 	// Log the new license addition
-	log.Printf("New licence '%s' added to the server by user '%v'\n", licID, userAcc)
+	com.Log.Infof("New licence '%s' added to the server by user '%v'", licID, userAcc)
 	return
 }
 
@@ -604,7 +683,7 @@ func licenceGetHandler(w http.ResponseWriter, r *http.Request) {
 	// Validate the licence name
 	err = com.ValidateLicence(l)
 	if err != nil {
-		log.Printf("Validation failed for licence name: '%s': %s", l, err)
+		com.Log.Errorf("Validation failed for licence name: '%s': %s", l, err)
 		http.Error(w, "Validation of licence name failed", http.StatusBadRequest)
 		return
 	}
@@ -642,13 +721,13 @@ func licenceGetHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", mimeType)
 	bytesWritten, err := fmt.Fprint(w, lic)
 	if err != nil {
-		log.Printf("Error returning licence file: %v\n", err)
+		com.Log.Errorf("Error returning licence file: %v", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	// Log the transfer
-	log.Printf("Licence '%s' downloaded by user '%v', %d bytes\n", licenceName, userAcc, bytesWritten)
+	com.Log.Infof("Licence '%s' downloaded by user '%v', %d bytes", licenceName, userAcc, bytesWritten)
 	return
 }
 
@@ -666,7 +745,7 @@ func licenceListHandler(w http.ResponseWriter, r *http.Request) {
 	jsonLicList, err := json.MarshalIndent(licList, "", "  ")
 	if err != nil {
 		errMsg := fmt.Sprintf("Error when JSON marshalling the licence list: %v\n", err)
-		log.Print(errMsg)
+		com.Log.Error(errMsg)
 		http.Error(w, errMsg, http.StatusBadRequest)
 		return
 	}
@@ -685,7 +764,7 @@ func licenceRemoveHandler(w http.ResponseWriter, r *http.Request) {
 
 	// The "public" user isn't allowed to make changes
 	if userAcc == "public" {
-		log.Printf("User from '%s' attempted to remove a licence using the public certificate", r.RemoteAddr)
+		com.Log.Warnf("User from '%s' attempted to remove a licence using the public certificate", r.RemoteAddr)
 		http.Error(w, "You're using the 'public' certificate, which isn't allowed to make changes on the server",
 			http.StatusUnauthorized)
 		return
@@ -701,7 +780,7 @@ func licenceRemoveHandler(w http.ResponseWriter, r *http.Request) {
 	// Validate the licence name
 	err = com.ValidateLicence(l)
 	if err != nil {
-		log.Printf("Validation failed for licence name: '%s': %s", l, err)
+		com.Log.Errorf("Validation failed for licence name: '%s': %s", l, err)
 		http.Error(w, "Validation of licence name failed", http.StatusBadRequest)
 		return
 	}
@@ -730,7 +809,7 @@ func licenceRemoveHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "Success")
 
 	// Log the transfer
-	log.Printf("Licence '%s' removed by user '%v'\n", licenceName, userAcc)
+	com.Log.Infof("Licence '%s' removed by user '%v'", licenceName, userAcc)
 	return
 }
 
@@ -797,14 +876,23 @@ func metadataGetHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Check if the database has been archived (made read-only)
+	archived, err := com.ProjectArchived(owner, folder, fileName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	// Return the list as JSON
 	info := struct {
+		Archived  bool                        `json:"archived"`
 		Branches  map[string]com.BranchEntry  `json:"branches"`
 		Commits   map[string]com.CommitEntry  `json:"commits"`
 		DefBranch string                      `json:"default_branch"`
 		Releases  map[string]com.ReleaseEntry `json:"releases"`
 		Tags      map[string]com.TagEntry     `json:"tags"`
 	}{
+		Archived:  archived,
 		Branches:  branchList,
 		Commits:   commitList,
 		DefBranch: defBranch,
@@ -814,7 +902,7 @@ func metadataGetHandler(w http.ResponseWriter, r *http.Request) {
 	jsonList, err := json.MarshalIndent(info, "", "  ")
 	if err != nil {
 		errMsg := fmt.Sprintf("Error when JSON marshalling the branch list: %v\n", err)
-		log.Print(errMsg)
+		com.Log.Error(errMsg)
 		http.Error(w, errMsg, http.StatusBadRequest)
 		return
 	}
@@ -844,7 +932,7 @@ func postHandler(w http.ResponseWriter, r *http.Request, userAcc string) {
 
 	// The "public" user isn't allowed to make changes
 	if userAcc == "public" {
-		log.Printf("User from '%s' attempted to add a database using the public certificate", r.RemoteAddr)
+		com.Log.Warnf("User from '%s' attempted to add a database using the public certificate", r.RemoteAddr)
 		http.Error(w, "You're using the 'public' certificate, which isn't allowed to make changes on the server",
 			http.StatusUnauthorized)
 		return
@@ -873,15 +961,15 @@ func postHandler(w http.ResponseWriter, r *http.Request, userAcc string) {
 		http.Error(w,
 			fmt.Sprintf("Database is too large. Maximum database upload size is %d MB, yours is %d MB",
 				com.MaxFileSize, r.ContentLength/1024/1024), http.StatusBadRequest)
-		log.Println(fmt.Sprintf("'%s' attempted to upload an oversized database %d MB in size.  Limit is %d MB\n",
-			userAcc, r.ContentLength/1024/1024, com.MaxFileSize))
+		com.Log.Warnf("'%s' attempted to upload an oversized database %d MB in size.  Limit is %d MB",
+			userAcc, r.ContentLength/1024/1024, com.MaxFileSize)
 		return
 	}
 
 	// Grab the uploaded file and form variables
 	tempFile, handler, err := r.FormFile("file")
 	if err != nil && err.Error() != "http: no such file" {
-		log.Printf("%s: Uploading file failed: %v\n", pageName, err)
+		com.Log.Errorf("%s: Uploading file failed: %v", pageName, err)
 		http.Error(w, fmt.Sprintf("Something went wrong when grabbing the file data: '%s'", err.Error()), http.StatusBadRequest)
 		return
 	}
@@ -890,7 +978,7 @@ func postHandler(w http.ResponseWriter, r *http.Request, userAcc string) {
 		// bug in one of the libraries it uses
 		tempFile, handler, err = r.FormFile("file1")
 		if err != nil {
-			log.Printf("%s: Uploading file failed: %v\n", pageName, err)
+			com.Log.Errorf("%s: Uploading file failed: %v", pageName, err)
 			http.Error(w, fmt.Sprintf("Something went wrong when grabbing the file data: '%s'", err.Error()), http.StatusBadRequest)
 			return
 		}
@@ -898,7 +986,7 @@ func postHandler(w http.ResponseWriter, r *http.Request, userAcc string) {
 	defer tempFile.Close()
 
 	// Validate the database name
-	targetDB := handler.Filename
+	targetDB := com.NormaliseName(handler.Filename)
 	err = com.ValidateFileName(targetDB)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -931,6 +1019,17 @@ func postHandler(w http.ResponseWriter, r *http.Request, userAcc string) {
 		}
 	}
 
+	// If the client asked for identifying metadata to be removed from the model, validate it
+	scrubMeta := false
+	if z := r.FormValue("scrubmetadata"); z != "" {
+		scrubMeta, err = strconv.ParseBool(z)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error when converting scrub metadata '%s' value to boolean: %v\n", z, err),
+				http.StatusBadRequest)
+			return
+		}
+	}
+
 	// If a licence name was provided then use it, else default to "Not specified"
 	licenceName := "Not specified"
 	if z := r.FormValue("licence"); z != "" {
@@ -1097,139 +1196,280 @@ func postHandler(w http.ResponseWriter, r *http.Request, userAcc string) {
 
 	// Verify the user is uploading to a location they have write access for
 	if strings.ToLower(targetUser) != strings.ToLower(userAcc) {
-		log.Printf("%s: Attempt by '%s' to write to unauthorised location: %v\n", pageName, userAcc,
+		com.Log.Warnf("%s: Attempt by '%s' to write to unauthorised location: %v", pageName, userAcc,
 			r.URL.Path)
 		http.Error(w, fmt.Sprintf("Error code 401: You don't have write permission for '%s'",
 			r.URL.Path), http.StatusForbidden)
 		return
 	}
 
-	// Check if the database exists already
-	exists, err := com.CheckFileExists(userAcc, targetUser, targetFolder, targetDB)
+	// Work out which branch the upload goes onto, doing collision and fork detection for existing databases
+	createBranch, branchName, status, err := uploadBranch(targetUser, targetFolder, targetDB, branchName, commit,
+		force)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	// Sanity check the uploaded database, and if ok then add it to the system
+	numBytes, commitID, err := com.AddFile(r, userAcc, targetUser, targetFolder, targetDB, createBranch,
+		branchName, commit, public, licenceName, commitMsg, sourceURL, tempFile, "db4s", lastMod,
+		commitTime, authorName, authorEmail, committerName, committerEmail, otherParents, dbSHA256, scrubMeta)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	if !exists && branchName == "" {
-		// If the database doesn't already exist, and no branch name was provided, then default to master
-		branchName = "master"
+
+	// Update the search index
+	err = com.UpdateSearchIndex(targetUser, targetFolder, targetDB)
+	if err != nil {
+		com.Log.Errorf("Error when updating the search index: %s", err.Error())
 	}
 
-	// If the database already exists, we need to do collision detection, check for forking, and check for force pushes
-	createBranch := false
-	if !exists {
-		createBranch = true
-	} else {
-		if commit == "" {
-			http.Error(w, "No commit ID was provided.  You probably need to upgrade your client before trying this "+
-				"again.", http.StatusUpgradeRequired)
+	// Log the successful database upload
+	com.Log.Infof("Database uploaded: '%s%s%s', bytes: %v", userAcc, targetFolder, targetDB, numBytes)
+
+	// Construct message data for returning to sender
+	u := server + filepath.Join("/", targetUser, targetFolder, targetDB)
+	u += fmt.Sprintf(`?branch=%s&commit=%s`, branchName, commitID)
+	m := map[string]string{"commit_id": commitID, "url": u}
+
+	// Convert to JSON
+	var msg bytes.Buffer
+	enc := json.NewEncoder(&msg)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(m); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Send return message back to the client
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintf(w, msg.String())
+}
+
+// putHandler receives raw (non multipart) file uploads, which is much easier to use from curl and CI scripts than
+// the form based uploads.  The file contents are the request body, with the other upload details passed as headers.
+// Projects currently hold a single file, so when a file name is given in the URL it needs to match the project name.
+// To simulate an upload, the following curl command can be used:
+//
+//   $ curl -kE ~/my.cert.pem -D headers.out -T someupload.stl -H "X-Branch: master" \
+//       -H "X-Commit-Message: stuff" -H "X-Licence: CC0" -H "X-Public: true" \
+//       https://db4s.dbhub.io:5550/v1/someuser/someupload.stl
+//
+// Subsequent uploads to the same project will need to include an additional "X-Commit" header, with the value of the
+// commit ID last known to the client.
+//
+// The other supported headers are X-Force, X-Last-Modified, and X-Source-URL.  They work the same way as their
+// equivalent form fields in postHandler.
+func putHandler(w http.ResponseWriter, r *http.Request) {
+	pageName := "PUT request handler"
+
+	// Extract the account name and associated server from the validated client certificate
+	userAcc, _, err := extractUserAndServer(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.Method != "PUT" {
+		http.Error(w, fmt.Sprintf("Unknown request type: %v\n", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Set the maximum accepted file size for uploading
+	r.Body = http.MaxBytesReader(w, r.Body, com.MaxFileSize*1024*1024)
+
+	// The "public" user isn't allowed to make changes
+	if userAcc == "public" {
+		com.Log.Warnf("User from '%s' attempted to add a file using the public certificate", r.RemoteAddr)
+		http.Error(w, "You're using the 'public' certificate, which isn't allowed to make changes on the server",
+			http.StatusUnauthorized)
+		return
+	}
+
+	// Split the request URL into path components.  eg: /v1/someuser/someproject[/somefile]
+	pathStrings := strings.Split(strings.TrimSuffix(r.URL.Path, "/"), "/")
+	if len(pathStrings) < 4 || len(pathStrings) > 5 {
+		http.Error(w, "Invalid URL.  It should be /v1/owner/project/file", http.StatusBadRequest)
+		return
+	}
+	targetUser := pathStrings[2]
+	targetDB := com.NormaliseName(pathStrings[3])
+	if len(pathStrings) == 5 && com.NormaliseName(pathStrings[4]) != targetDB {
+		http.Error(w, "The file name needs to match the project name", http.StatusBadRequest)
+		return
+	}
+	err = com.ValidateUserFilename(targetUser, targetDB)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// TODO: Add support for folders
+	targetFolder := "/"
+
+	// Check whether the uploaded file is too large
+	if r.ContentLength > (com.MaxFileSize * 1024 * 1024) {
+		http.Error(w,
+			fmt.Sprintf("File is too large. Maximum upload size is %d MB, yours is %d MB",
+				com.MaxFileSize, r.ContentLength/1024/1024), http.StatusBadRequest)
+		com.Log.Warnf("'%s' attempted to upload an oversized file %d MB in size.  Limit is %d MB",
+			userAcc, r.ContentLength/1024/1024, com.MaxFileSize)
+		return
+	}
+
+	// If a branch name was provided then validate it
+	var branchName string
+	if z := r.Header.Get("X-Branch"); z != "" {
+		err = com.ValidateBranchName(z)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid branch name value: '%v'", z), http.StatusBadRequest)
 			return
 		}
+		branchName = z
+	}
+
+	// If a commit ID was provided then validate it
+	var commit string
+	if z := r.Header.Get("X-Commit"); z != "" {
+		err = com.ValidateCommitID(z)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid commit ID value: '%v'", z), http.StatusBadRequest)
+			return
+		}
+		commit = z
+	}
+
+	// If a commit message was provided then use it
+	var commitMsg string
+	if z := r.Header.Get("X-Commit-Message"); z != "" {
+		err = com.ValidateMarkdown(z)
+		if err != nil {
+			http.Error(w, "Validation failed for the commit message", http.StatusBadRequest)
+			return
+		}
+		commitMsg = z
+	}
+
+	// If the client sent a force header, validate it
+	force := false
+	if z := r.Header.Get("X-Force"); z != "" {
+		force, err = strconv.ParseBool(z)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error when converting force '%s' value to boolean: %v\n", z, err),
+				http.StatusBadRequest)
+			return
+		}
+	}
+
+	// If the client asked for identifying metadata to be removed from the model, validate it
+	scrubMeta := false
+	if z := r.Header.Get("X-Scrub-Metadata"); z != "" {
+		scrubMeta, err = strconv.ParseBool(z)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error when converting scrub metadata '%s' value to boolean: %v\n", z, err),
+				http.StatusBadRequest)
+			return
+		}
+	}
 
-		// Retrieve the branch list for the database
-		branchList, err := com.GetBranches(targetUser, targetFolder, targetDB)
+	// If the last modified timestamp for the file was provided, then validate it
+	lastMod := time.Now().UTC()
+	if z := r.Header.Get("X-Last-Modified"); z != "" {
+		lastMod, err = time.Parse(time.RFC3339, z)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid last modified value: '%v'", z), http.StatusBadRequest)
+			return
+		}
+		lastMod = lastMod.UTC()
+	}
+
+	// If a licence name was provided then use it, else default to "Not specified"
+	licenceName := "Not specified"
+	if z := r.Header.Get("X-Licence"); z != "" {
+		err = com.ValidateLicence(z)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Validation failed for licence name value: '%s': %s", z, err),
+				http.StatusBadRequest)
+			return
+		}
+
+		// Make sure the licence is one that's known to us
+		licenceList, err := com.GetLicences(userAcc)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		_, ok := licenceList[z]
+		if !ok {
+			http.Error(w, fmt.Sprintf("Unknown licence: '%s'", z), http.StatusBadRequest)
+			return
+		}
+		licenceName = z
+	}
 
-		// If a branch name was given, check if it's a branch we know about
-		knownBranch := false
-		var brDetails com.BranchEntry
-		if branchName != "" {
-			brDetails, knownBranch = branchList[branchName]
+	// If a public/private setting was provided then use it
+	var public bool
+	if z := r.Header.Get("X-Public"); z != "" {
+		public, err = strconv.ParseBool(z)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error when converting public value to boolean: %v\n", err),
+				http.StatusBadRequest)
+			return
 		}
+	}
 
-		// * Fork detection piece *
-		if !knownBranch {
-			// An unknown branch name was given, so this is a fork.
-			createBranch = true
-
-			// Make sure the given commit ID is in the commit history.  If it's not, we error out
-			found := false
-			for branch := range branchList {
-				// Loop through the branches, checking if the commit ID is in any of them
-				a, err := com.IsCommitInBranchHistory(targetUser, targetFolder, targetDB, branch, commit)
-				if err != nil {
-					http.Error(w, err.Error(), http.StatusInternalServerError)
-					return
-				}
-				if a {
-					found = true
-				}
-			}
-			if !found {
-				// The commit wasn't found in the history of any branch
-				http.Error(w, fmt.Sprintf("Unknown commit ID: '%s'", commit), http.StatusNotFound)
-				return
-			}
-		} else {
-			// * Collision detection piece *
-
-			// Check if the provided commit ID is the latest head commit for the branch.  If it is, then things
-			// are in order and this new upload should be a new commit on the branch.
-			if brDetails.Commit != commit {
-				// * The provided commit doesn't match the HEAD commit for the specified branch *
-
-				// Check if the provided commit is present in the history for the branch.  If it is, then the
-				// database being pushed is out of date compared to the HEAD commit.  We'll need to abort
-				// (with a suitable warning message), unless the force flag was passed + set to true
-				found, err := com.IsCommitInBranchHistory(targetUser, targetFolder, targetDB, branchName, commit)
-				if err != nil {
-					http.Error(w, err.Error(), http.StatusInternalServerError)
-					return
-				}
-
-				if !found {
-					// The provided commit ID isn't in the commit history for the branch, so there's something
-					// wrong.  We need to error out and let the client know
-					http.Error(w, fmt.Sprintf("Commit ID '%s' isn't in the commit history of branch '%s'",
-						commit, branchName), http.StatusNotFound)
-					return
-				}
-
-				// * To get here, this push is a collision *
-
-				// The commit ID provided was found in the branch history but isn't the latest (HEAD) commit for
-				// the branch.  Unless the "force" flag was provided by the client (and set to true), we error out to
-				// notify the client of the collision.  It probably just means the database has been updated on the
-				// server (eg through the webUI) but the user is still using an older version and needs to update
-
-				if !force {
-					http.Error(w, fmt.Sprintf("Outdated commit '%s' provided.  You're probably using an "+
-						"old version of the database", commit), http.StatusConflict)
-					return
-				}
-
-				// * To get here, the client has told us to rewrite the commit history for a branch, given us the
-				//   required info, and provided the "force" flag set to true.  So, we drop through here and get
-				//   it done *
-
-			} else {
-				// The provided commit ID matched the branch head, so things are in order.  We drop through and
-				// create a new commit
-			}
+	// If a source URL was provided then use it
+	var sourceURL string
+	if z := r.Header.Get("X-Source-URL"); z != "" {
+		err = com.Validate.Var(z, "url,min=5,max=255") // 255 seems like a reasonable first guess
+		if err != nil {
+			http.Error(w, "Validation failed for source URL value", http.StatusBadRequest)
+			return
 		}
+		sourceURL = z
 	}
 
-	// Sanity check the uploaded database, and if ok then add it to the system
+	// Verify the user is uploading to a location they have write access for
+	if strings.ToLower(targetUser) != strings.ToLower(userAcc) {
+		com.Log.Warnf("%s: Attempt by '%s' to write to unauthorised location: %v", pageName, userAcc,
+			r.URL.Path)
+		http.Error(w, fmt.Sprintf("Error code 401: You don't have write permission for '%s'",
+			r.URL.Path), http.StatusForbidden)
+		return
+	}
+
+	// Work out which branch the upload goes onto, doing collision and fork detection for existing projects
+	createBranch, branchName, status, err := uploadBranch(targetUser, targetFolder, targetDB, branchName, commit,
+		force)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	// Sanity check the uploaded file, and if ok then add it to the system
 	numBytes, commitID, err := com.AddFile(r, userAcc, targetUser, targetFolder, targetDB, createBranch,
-		branchName, commit, public, licenceName, commitMsg, sourceURL, tempFile, "db4s", lastMod,
-		commitTime, authorName, authorEmail, committerName, committerEmail, otherParents, dbSHA256)
+		branchName, commit, public, licenceName, commitMsg, sourceURL, r.Body, "api", lastMod, time.Time{}, "", "",
+		"", "", nil, "", scrubMeta)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// Log the successful database upload
-	log.Printf("Database uploaded: '%s%s%s', bytes: %v\n", userAcc, targetFolder, targetDB, numBytes)
+	// Update the search index
+	err = com.UpdateSearchIndex(targetUser, targetFolder, targetDB)
+	if err != nil {
+		com.Log.Errorf("Error when updating the search index: %s", err.Error())
+	}
 
-	// Construct message data for returning to sender
+	// Log the successful upload
+	com.Log.Infof("%s: File uploaded: '%s%s%s', bytes: %v", pageName, userAcc, targetFolder, targetDB, numBytes)
+
+	// Let the client know the new commit ID, and where to find it
 	u := server + filepath.Join("/", targetUser, targetFolder, targetDB)
 	u += fmt.Sprintf(`?branch=%s&commit=%s`, branchName, commitID)
 	m := map[string]string{"commit_id": commitID, "url": u}
-
-	// Convert to JSON
 	var msg bytes.Buffer
 	enc := json.NewEncoder(&msg)
 	enc.SetEscapeHTML(false)
@@ -1238,10 +1478,8 @@ func postHandler(w http.ResponseWriter, r *http.Request, userAcc string) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-
-	// Send return message back to the client
 	w.WriteHeader(http.StatusCreated)
-	fmt.Fprintf(w, msg.String())
+	fmt.Fprint(w, msg.String())
 }
 
 // Returns a file requested by the client.  An example curl command to simulate the request is:
@@ -1270,7 +1508,7 @@ func retrieveDatabase(w http.ResponseWriter, r *http.Request, pageName string, u
 	defer func() {
 		err := com.MinioHandleClose(userDB)
 		if err != nil {
-			log.Printf("%s: Error closing object handle: %v\n", pageName, err)
+			com.Log.Errorf("%s: Error closing object handle: %v", pageName, err)
 		}
 	}()
 
@@ -1304,24 +1542,35 @@ func retrieveDatabase(w http.ResponseWriter, r *http.Request, pageName string, u
 	w.Header().Set("Content-Type", "application/x-sqlite3")
 	w.Header().Set("Branch", branchName)
 	w.Header().Set("Commit-ID", commit)
+	com.ChecksumHeaders(w.Header(), com.KnownChecksums(bucket+id))
 	bytesWritten, err := io.Copy(w, userDB)
 	if err != nil {
-		log.Printf("%s: Error returning DB file: %v\n", pageName, err)
+		com.Log.Errorf("%s: Error returning DB file: %v", pageName, err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// If downloaded by someone other than the owner, increment the download count for the database
+	// If downloaded by someone other than the owner, increment the download count for the database and add it to
+	// the owner's access log
 	if strings.ToLower(userAcc) != strings.ToLower(owner) {
 		err = com.IncrementDownloadCount(owner, folder, fileName)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		com.RecordProjectAccess(owner, folder, fileName, com.ProjectAccess{
+			Client:    r.RemoteAddr,
+			Kind:      "api",
+			Method:    r.Method,
+			Path:      r.URL.Path,
+			Status:    http.StatusOK,
+			Time:      time.Now(),
+			UserAgent: userAgent,
+		})
 	}
 
 	// Log the transfer
-	log.Printf("'%s%s%s' downloaded by user '%v', %v bytes", owner, folder, fileName, userAcc, bytesWritten)
+	com.Log.Infof("'%s%s%s' downloaded by user '%v', %v bytes", owner, folder, fileName, userAcc, bytesWritten)
 	return nil
 }
 
@@ -1343,12 +1592,115 @@ func rootHandler(w http.ResponseWriter, r *http.Request) {
 	case "POST":
 		postHandler(w, r, userAcc)
 	default:
-		log.Printf("%s: Unknown request method received from '%v\n", pageName, userAcc)
+		com.Log.Errorf("%s: Unknown request method received from '%v", pageName, userAcc)
 		http.Error(w, fmt.Sprintf("Unknown request type: %v\n", reqType), http.StatusBadRequest)
 	}
 	return
 }
 
+// Works out which branch an upload should be committed to.  For databases which already exist this also does the
+// collision and fork detection, returning a suitable http status code when the upload can't go ahead.
+func uploadBranch(owner string, folder string, fileName string, branchName string, commit string,
+	force bool) (createBranch bool, newBranchName string, status int, err error) {
+	// Check if the database exists already
+	exists, err := com.CheckFileExists(owner, owner, folder, fileName)
+	if err != nil {
+		return false, "", http.StatusInternalServerError, err
+	}
+	if !exists {
+		// If the database doesn't already exist, and no branch name was provided, then default to master
+		if branchName == "" {
+			branchName = "master"
+		}
+		return true, branchName, http.StatusOK, nil
+	}
+
+	// The database already exists, so we need to do collision detection, check for forking, and check for force pushes
+	if commit == "" {
+		return false, "", http.StatusUpgradeRequired, errors.New("No commit ID was provided.  You probably need " +
+			"to upgrade your client before trying this again.")
+	}
+
+	// Retrieve the branch list for the database
+	branchList, err := com.GetBranches(owner, folder, fileName)
+	if err != nil {
+		return false, "", http.StatusInternalServerError, err
+	}
+
+	// If a branch name was given, check if it's a branch we know about
+	knownBranch := false
+	var brDetails com.BranchEntry
+	if branchName != "" {
+		brDetails, knownBranch = branchList[branchName]
+	}
+
+	// * Fork detection piece *
+	if !knownBranch {
+		// An unknown branch name was given, so this is a fork.
+		createBranch = true
+
+		// Make sure the given commit ID is in the commit history.  If it's not, we error out
+		found := false
+		for branch := range branchList {
+			// Loop through the branches, checking if the commit ID is in any of them
+			a, err := com.IsCommitInBranchHistory(owner, folder, fileName, branch, commit)
+			if err != nil {
+				return false, "", http.StatusInternalServerError, err
+			}
+			if a {
+				found = true
+			}
+		}
+		if !found {
+			// The commit wasn't found in the history of any branch
+			return false, "", http.StatusNotFound, fmt.Errorf("Unknown commit ID: '%s'", commit)
+		}
+		return createBranch, branchName, http.StatusOK, nil
+	}
+
+	// * Collision detection piece *
+
+	// Check if the provided commit ID is the latest head commit for the branch.  If it is, then things are in order and
+	// this new upload should be a new commit on the branch.
+	if brDetails.Commit != commit {
+		// * The provided commit doesn't match the HEAD commit for the specified branch *
+
+		// Check if the provided commit is present in the history for the branch.  If it is, then the database being
+		// pushed is out of date compared to the HEAD commit.  We'll need to abort (with a suitable warning message),
+		// unless the force flag was passed + set to true
+		found, err := com.IsCommitInBranchHistory(owner, folder, fileName, branchName, commit)
+		if err != nil {
+			return false, "", http.StatusInternalServerError, err
+		}
+
+		if !found {
+			// The provided commit ID isn't in the commit history for the branch, so there's something wrong.  We need
+			// to error out and let the client know
+			return false, "", http.StatusNotFound, fmt.Errorf("Commit ID '%s' isn't in the commit history of "+
+				"branch '%s'", commit, branchName)
+		}
+
+		// * To get here, this push is a collision *
+
+		// The commit ID provided was found in the branch history but isn't the latest (HEAD) commit for the branch.
+		// Unless the "force" flag was provided by the client (and set to true), we error out to notify the client of
+		// the collision.  It probably just means the database has been updated on the server (eg through the webUI)
+		// but the user is still using an older version and needs to update
+
+		if !force {
+			return false, "", http.StatusConflict, fmt.Errorf("Outdated commit '%s' provided.  You're probably "+
+				"using an old version of the database", commit)
+		}
+
+		// * To get here, the client has told us to rewrite the commit history for a branch, given us the required
+		//   info, and provided the "force" flag set to true.  So, we drop through here and get it done *
+	}
+
+	// The provided commit ID matched the branch head (or a force push was requested), so things are in order.  We
+	// drop through and create a new commit
+	return false, branchName, http.StatusOK, nil
+}
+
 // Returns the list of databases available to the user.  To simulate, the following curl command can be used:
 //
 //   $ curl -kE ~/my.cert.pem -D headers.out -G https://db4s.dbhub.io:5550/someuser
@@ -1384,7 +1736,7 @@ func userDatabaseList(pageName string, userAcc string, user string) (dbList []by
 	}
 
 	// Retrieve the database list
-	pubDBs, err := com.UserDBs(user, pubSetting)
+	pubDBs, err := com.UserDBs(user, pubSetting, com.SORT_UPDATED)
 	if err != nil {
 		return nil, err
 	}
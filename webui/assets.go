@@ -0,0 +1,231 @@
+package main
+
+//go:generate go run assets_generate.go
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	com "github.com/justinclift/3dhub.io/common"
+)
+
+// The templates and static files, when they've been built into the binary.  To do that, run "go generate" in the
+// webui directory and then build with "-tags embed".  Otherwise they're read from the webui directory under the
+// base_dir in the configuration file.
+var (
+	embeddedAssets     map[string][]byte
+	embeddedAssetsTime time.Time
+)
+
+// The fingerprinted URL of each static file, and the file each of those URLs is for.  The fingerprint is part of a
+// hash of the file contents, so the URL changes whenever the file does and browsers can cache them for as long as
+// they like.
+var (
+	assetURLs          = make(map[string]string)
+	fingerprintedFiles = make(map[string]string)
+)
+
+// The static files, by the URL they're served from.  The file names are relative to the webui directory
+var staticFiles = map[string]string{
+	// CSS
+	"/css/bootstrap-3.3.7.min.css":            "css/bootstrap-3.3.7.min.css",
+	"/css/bootstrap.min.css.map":              "css/bootstrap-3.3.7.min.css.map",
+	"/css/font-awesome-4.7.0.min.css":         "css/font-awesome-4.7.0.min.css",
+	"/css/local.css":                          "css/local.css",
+	"/css/angular-bootstrap-lightbox.min.css": "css/angular-bootstrap-lightbox.min.css",
+
+	// Fonts
+	"/css/FontAwesome.otf":           "fonts/FontAwesome-4.7.0.otf",
+	"/css/fontawesome-webfont.eot":   "fonts/fontawesome-webfont-4.7.0.eot",
+	"/css/fontawesome-webfont.svg":   "fonts/fontawesome-webfont-4.7.0.svg",
+	"/css/fontawesome-webfont.ttf":   "fonts/fontawesome-webfont-4.7.0.ttf",
+	"/css/fontawesome-webfont.woff":  "fonts/fontawesome-webfont-4.7.0.woff",
+	"/css/fontawesome-webfont.woff2": "fonts/fontawesome-webfont-4.7.0.woff2",
+
+	// Javascript
+	"/js/angular-1.7.8.min.js":              "js/angular-1.7.8.min.js",
+	"/js/angular.min.js.map":                "js/angular-1.7.8.min.js.map",
+	"/js/angular-sanitize-1.7.8.min.js":     "js/angular-sanitize-1.7.8.min.js",
+	"/js/angular-sanitize.min.js.map":       "js/angular-sanitize-1.7.8.min.js.map",
+	"/js/angular-bootstrap-lightbox.min.js": "js/angular-bootstrap-lightbox.min.js",
+	"/js/local.js":                          "js/local.js",
+	"/js/lock-11.14.1.min.js":               "js/lock-11.14.1.min.js",
+	"/js/lock.min.js.map":                   "js/lock-11.14.1.min.js.map",
+	"/js/ui-bootstrap-tpls-2.5.0.min.js":    "js/ui-bootstrap-tpls-2.5.0.min.js",
+
+	// Other static files
+	"/images/auth0.svg":         "images/auth0.svg",
+	"/images/sqlitebrowser.svg": "images/sqlitebrowser.svg",
+	"/favicon.ico":              "favicon.ico",
+	"/robots.txt":               "robots.txt",
+
+	// Landing page images
+	"/images/db4s_screenshot1.png":              "images/db4s_screenshot1.png",
+	"/images/db4s_screenshot1-50px.png":         "images/db4s_screenshot1-50px.png",
+	"/images/db4s_screenshot2.png":              "images/db4s_screenshot2.png",
+	"/images/db4s_screenshot2-50px.png":         "images/db4s_screenshot2-50px.png",
+	"/images/db4s_screenshot3.png":              "images/db4s_screenshot3.png",
+	"/images/db4s_screenshot3-50px.png":         "images/db4s_screenshot3-50px.png",
+	"/images/db4s_screenshot4.png":              "images/db4s_screenshot4.png",
+	"/images/db4s_screenshot4-50px.png":         "images/db4s_screenshot4-50px.png",
+	"/images/pub_priv1.png":                     "images/pub_priv1.png",
+	"/images/pub_priv1-50px.png":                "images/pub_priv1-50px.png",
+	"/images/watch1.png":                        "images/watch1.png",
+	"/images/watch1-46px.png":                   "images/watch1-46px.png",
+	"/images/discussions1.png":                  "images/discussions1.png",
+	"/images/discussions1-50px.png":             "images/discussions1-50px.png",
+	"/images/discussions2.png":                  "images/discussions2.png",
+	"/images/discussions2-50px.png":             "images/discussions2-50px.png",
+	"/images/discussions3.png":                  "images/discussions3.png",
+	"/images/discussions3-50px.png":             "images/discussions3-50px.png",
+	"/images/version_control_history1.png":      "images/version_control_history1.png",
+	"/images/version_control_history1-50px.png": "images/version_control_history1-50px.png",
+	"/images/version_control_history2.png":      "images/version_control_history2.png",
+	"/images/version_control_history2-50px.png": "images/version_control_history2-50px.png",
+	"/images/merge1.png":                        "images/merge1.png",
+	"/images/merge1-50px.png":                   "images/merge1-50px.png",
+	"/images/merge2.png":                        "images/merge2.png",
+	"/images/merge2-50px.png":                   "images/merge2-50px.png",
+	"/images/merge3.png":                        "images/merge3.png",
+	"/images/merge3-50px.png":                   "images/merge3-50px.png",
+	"/images/merge4.png":                        "images/merge4.png",
+	"/images/merge4-50px.png":                   "images/merge4-50px.png",
+	"/images/redash1.png":                       "images/redash1.png",
+	"/images/redash1-50px.png":                  "images/redash1-50px.png",
+	"/images/redash2.png":                       "images/redash2.png",
+	"/images/redash2-50px.png":                  "images/redash2-50px.png",
+	"/images/redash3.png":                       "images/redash3.png",
+	"/images/redash3-50px.png":                  "images/redash3-50px.png",
+	"/images/redash4.png":                       "images/redash4.png",
+	"/images/redash4-50px.png":                  "images/redash4-50px.png",
+	"/images/dbhub-vis-720.mp4":                 "images/dbhub-vis-720.mp4",
+	"/images/dbhub-vis-720.webm":                "images/dbhub-vis-720.webm",
+}
+
+// Returns the fingerprinted URL of a static file, for use in the templates.  In development mode the files can change
+// while the server is running, so the plain URL is used instead.
+func assetURL(u string) string {
+	if fp, ok := assetURLs[u]; ok && !com.Conf().Web.DevMode {
+		return fp
+	}
+	return u
+}
+
+// Works out the fingerprinted URLs of the static files.  The fingerprint goes before the file extension, eg
+// /css/local.css becomes /css/local.0123456789.css.
+func fingerprintAssets() error {
+	for u, name := range staticFiles {
+		data, err := readAsset(name)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(data)
+		ext := path.Ext(u)
+		fp := strings.TrimSuffix(u, ext) + "." + hex.EncodeToString(sum[:5]) + ext
+		assetURLs[u] = fp
+		fingerprintedFiles[fp] = name
+	}
+	return nil
+}
+
+// Reads and parses the HTML templates, for showing pages in the given language.
+func loadTemplates(locale string) (*template.Template, error) {
+	t := template.New("templates").Delims("[[", "]]").Funcs(template.FuncMap{
+		"announcement": currentAnnouncement,
+		"asset":        assetURL,
+		"formatDate":   com.FormatDate,
+		"locale":       func() string { return locale },
+		"tr": func(msg string, args ...interface{}) string {
+			return translate(locale, msg, args...)
+		},
+	})
+	if embeddedAssets == nil || com.Conf().Web.DevMode {
+		return t.ParseGlob(filepath.Join(com.Conf().Web.BaseDir, "webui", "templates", "*.html"))
+	}
+
+	// Parse the built in templates in name order, the same as ParseGlob() would
+	var names []string
+	for name := range embeddedAssets {
+		if strings.HasPrefix(name, "templates/") && strings.HasSuffix(name, ".html") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		_, err := t.New(path.Base(name)).Parse(string(embeddedAssets[name]))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// Returns the contents of one of the static files.  The name is relative to the webui directory.
+func readAsset(name string) ([]byte, error) {
+	if embeddedAssets == nil || com.Conf().Web.DevMode {
+		return ioutil.ReadFile(filepath.Join(com.Conf().Web.BaseDir, "webui", filepath.FromSlash(name)))
+	}
+	data, ok := embeddedAssets[name]
+	if !ok {
+		return nil, fmt.Errorf("Static file '%s' isn't built in", name)
+	}
+	return data, nil
+}
+
+// Sends one of the static files to the client.  The name is relative to the webui directory, eg "css/local.css".
+func serveAsset(w http.ResponseWriter, r *http.Request, name string) {
+	if embeddedAssets == nil || com.Conf().Web.DevMode {
+		http.ServeFile(w, r, filepath.Join(com.Conf().Web.BaseDir, "webui", filepath.FromSlash(name)))
+		return
+	}
+	data, ok := embeddedAssets[name]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeContent(w, r, path.Base(name), embeddedAssetsTime, bytes.NewReader(data))
+}
+
+// Serves the static files.  Fingerprinted URLs never change content, so they can be cached for a year.  The plain
+// URLs are only cached briefly, so changes to the files get picked up.
+func staticHandler(w http.ResponseWriter, r *http.Request) {
+	if name, ok := fingerprintedFiles[r.URL.Path]; ok {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		serveAsset(w, r, name)
+		return
+	}
+	name, ok := staticFiles[r.URL.Path]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if !com.Conf().Web.DevMode {
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+	}
+	serveAsset(w, r, name)
+}
+
+// Returns the parsed HTML templates for a language.  In development mode they're re-read from disk each time, so changes to them
+// show up without restarting the server.  If the changed templates have a mistake in them, the ones from before are
+// used instead.
+func templates(locale string) *template.Template {
+	if !com.Conf().Web.DevMode {
+		return tmpl[locale]
+	}
+	t, err := loadTemplates(locale)
+	if err != nil {
+		com.Log.Errorf("Error when reloading the templates: %v", err)
+		return tmpl[locale]
+	}
+	return t
+}
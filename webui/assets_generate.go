@@ -0,0 +1,65 @@
+//go:build ignore
+// +build ignore
+
+// Generates assets_embedded.go, which builds the templates and static files into the webui binary.  Run it with
+// "go generate" from the webui directory, then build with "-tags embed".
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// The files and directories (relative to the webui directory) which are built in
+var assetPaths = []string{"css", "favicon.ico", "fonts", "images", "js", "locales", "robots.txt", "templates"}
+
+func main() {
+	files := make(map[string][]byte)
+	for _, p := range assetPaths {
+		err := filepath.Walk(p, func(name string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			data, err := ioutil.ReadFile(name)
+			if err != nil {
+				return err
+			}
+			files[filepath.ToSlash(name)] = data
+			return nil
+		})
+		if err != nil {
+			log.Fatalf("Error when reading the webui files: %v", err)
+		}
+	}
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	buf.WriteString("// Code generated by assets_generate.go. DO NOT EDIT.\n\n")
+	buf.WriteString("//go:build embed\n// +build embed\n\npackage main\n\nimport \"time\"\n\nfunc init() {\n")
+	fmt.Fprintf(&buf, "\tembeddedAssetsTime = time.Unix(%d, 0)\n", time.Now().Unix())
+	buf.WriteString("\tembeddedAssets = map[string][]byte{\n")
+	for _, name := range names {
+		fmt.Fprintf(&buf, "\t\t%q: []byte(%q),\n", name, files[name])
+	}
+	buf.WriteString("\t}\n}\n")
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatalf("Error when formatting the generated code: %v", err)
+	}
+	err = ioutil.WriteFile("assets_embedded.go", src, 0644)
+	if err != nil {
+		log.Fatalf("Error when writing assets_embedded.go: %v", err)
+	}
+	log.Printf("Built in %d files", len(names))
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Responses smaller than this aren't compressed, as it wouldn't save much and can even make them bigger
+const compressMinSize = 1024
+
+// The response types which are compressed.  Images, fonts, and the 3D model downloads are already compressed, so
+// there's no point doing it again
+var compressTypes = []string{"application/javascript", "application/json", "image/svg+xml", "text/"}
+
+// Reusable compressors, as they're fairly expensive to create
+var (
+	brotliWriters = sync.Pool{New: func() interface{} {
+		return brotli.NewWriterLevel(nil, 4)
+	}}
+	gzipWriters = sync.Pool{New: func() interface{} {
+		return gzip.NewWriter(nil)
+	}}
+)
+
+// Compresses a response using the best encoding the client supports.  The start of the response is held back until
+// there's enough of it to decide whether compressing is worthwhile.
+type compressWriter struct {
+	http.ResponseWriter
+	buf      []byte
+	decided  bool
+	encoder  io.WriteCloser
+	encoding string
+	status   int
+}
+
+// Compresses responses with brotli or gzip, for clients which support them.
+func compress(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		w.Header().Add("Vary", "Accept-Encoding")
+		if encoding == "" {
+			fn(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding}
+		defer cw.Close()
+		fn(cw, r)
+	}
+}
+
+// Returns whether responses with the given content type are worth compressing.
+func compressible(contentType string) bool {
+	for _, t := range compressTypes {
+		if strings.HasPrefix(contentType, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Picks the encoding to use from an Accept-Encoding request header, preferring brotli as it compresses better.
+// Returns an empty string if the client doesn't support either.
+func negotiateEncoding(header string) string {
+	var br, gz bool
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		accepted := true
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				q, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64)
+				accepted = err == nil && q > 0
+			}
+		}
+		switch name {
+		case "br":
+			br = accepted
+		case "gzip":
+			gz = accepted
+		}
+	}
+	switch {
+	case br:
+		return "br"
+	case gz:
+		return "gzip"
+	}
+	return ""
+}
+
+// Turns a strong ETag into a weak one.  The compressed and uncompressed versions of a response aren't byte for byte
+// the same, so they can't share a strong ETag, but they're equivalent so can share a weak one.
+func weakenETag(h http.Header) {
+	if eTag := h.Get("ETag"); eTag != "" && !strings.HasPrefix(eTag, "W/") {
+		h.Set("ETag", "W/"+eTag)
+	}
+}
+
+// Finishes the response, sending anything still held back.
+func (c *compressWriter) Close() error {
+	if !c.decided {
+		c.decide(false)
+	}
+	if c.encoder == nil {
+		return nil
+	}
+	err := c.encoder.Close()
+	switch e := c.encoder.(type) {
+	case *brotli.Writer:
+		brotliWriters.Put(e)
+	case *gzip.Writer:
+		gzipWriters.Put(e)
+	}
+	c.encoder = nil
+	return err
+}
+
+// Works out whether to compress the response, then sends the headers and whatever has been held back so far.  When
+// the handler is flushing part way through (eg a streamed download), the size of the response isn't known yet so
+// it's compressed regardless.
+func (c *compressWriter) decide(flushing bool) {
+	c.decided = true
+	h := c.Header()
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	if h.Get("Content-Type") == "" && len(c.buf) > 0 {
+		h.Set("Content-Type", http.DetectContentType(c.buf))
+	}
+	if h.Get("Content-Encoding") == "" && h.Get("Content-Range") == "" && c.status == http.StatusOK &&
+		compressible(h.Get("Content-Type")) && (flushing || len(c.buf) >= compressMinSize) {
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", c.encoding)
+		weakenETag(h)
+		switch c.encoding {
+		case "br":
+			bw := brotliWriters.Get().(*brotli.Writer)
+			bw.Reset(c.ResponseWriter)
+			c.encoder = bw
+		case "gzip":
+			gw := gzipWriters.Get().(*gzip.Writer)
+			gw.Reset(c.ResponseWriter)
+			c.encoder = gw
+		}
+	}
+	if c.status == http.StatusNotModified {
+		// The client will have been sent the weak ETag, if the response was compressed
+		weakenETag(h)
+	}
+	c.ResponseWriter.WriteHeader(c.status)
+	if len(c.buf) > 0 {
+		if c.encoder != nil {
+			c.encoder.Write(c.buf)
+		} else {
+			c.ResponseWriter.Write(c.buf)
+		}
+	}
+	c.buf = nil
+}
+
+// Sends what's been written so far to the client.
+func (c *compressWriter) Flush() {
+	if !c.decided {
+		c.decide(true)
+	}
+	switch e := c.encoder.(type) {
+	case *brotli.Writer:
+		e.Flush()
+	case *gzip.Writer:
+		e.Flush()
+	}
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (c *compressWriter) Write(p []byte) (int, error) {
+	if !c.decided {
+		c.buf = append(c.buf, p...)
+		if len(c.buf) < compressMinSize {
+			return len(p), nil
+		}
+		c.decide(false)
+		return len(p), nil
+	}
+	if c.encoder != nil {
+		return c.encoder.Write(p)
+	}
+	return c.ResponseWriter.Write(p)
+}
+
+func (c *compressWriter) WriteHeader(status int) {
+	if c.status == 0 {
+		c.status = status
+	}
+}
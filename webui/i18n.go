@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	com "github.com/justinclift/3dhub.io/common"
+	gfm "github.com/sqlitebrowser/github_flavored_markdown"
+)
+
+// The pages are translated using message catalogues, which are JSON files in the webui/locales directory named after
+// their language tag (eg "de.json").  Messages are looked up by their English text, so anything which hasn't been
+// translated yet is shown in English.  In the templates, "tr" translates a message (with optional Printf style
+// arguments), and "locale" gives the language tag of the page.
+
+// The language the templates and messages are written in
+const defaultLocale = "en"
+
+// A message catalogue for one language
+type catalogue struct {
+	Name     string            `json:"name"`
+	Messages map[string]string `json:"messages"`
+}
+
+// A language the pages can be shown in for the preferences page, or a project's descriptions for the language switcher
+type localeInfo struct {
+	Name string
+	Tag  string
+}
+
+// The message catalogues, by language tag
+var catalogues = map[string]catalogue{defaultLocale: {Name: "English"}}
+
+// Returns the languages the pages can be shown in, ordered by language tag.
+func availableLocales() (list []localeInfo) {
+	for tag, c := range catalogues {
+		list = append(list, localeInfo{Name: c.Name, Tag: tag})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Tag < list[j].Tag })
+	return
+}
+
+// Picks which translation of a project's descriptions and README to show, returning an empty string for the project's
+// own ones.  The language switcher on the project pages gives one in the "lang" argument, otherwise it's the visitor's
+// preferred language, if there's a translation for it.  Only their first choice is used, as the project's own
+// descriptions could well be in one of the others.
+func descriptionLanguage(r *http.Request, translations []com.ProjectTranslation) string {
+	has := func(tag string) bool {
+		for _, t := range translations {
+			if t.Language == tag {
+				return true
+			}
+		}
+		return false
+	}
+	if l := r.FormValue("lang"); l != "" {
+		l = strings.ToLower(l)
+		if has(l) {
+			return l
+		}
+		return ""
+	}
+
+	// Work out the visitor's first choice, from their preferences or what the browser asks for
+	pref := requestDetails(r).locale
+	if pref == "" {
+		bestQ := 0.0
+		for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+			tag, q := languageQuality(part)
+			if tag != "" && tag != "*" && q > bestQ {
+				pref, bestQ = tag, q
+			}
+		}
+	}
+
+	// Regional variants (eg "pt-br") fall back to the main language when there isn't a translation just for them
+	for pref != "" {
+		if has(pref) {
+			return pref
+		}
+		i := strings.LastIndex(pref, "-")
+		if i < 0 {
+			break
+		}
+		pref = pref[:i]
+	}
+	return ""
+}
+
+// Returns the display name of a language, for the language switcher.  Languages the pages have been translated into
+// use the name from their catalogue, while the others just show their tag.
+func languageName(tag string) string {
+	if c, ok := catalogues[tag]; ok && c.Name != "" {
+		return c.Name
+	}
+	return tag
+}
+
+// Splits one entry of an Accept-Language header into its (lower case) language tag and quality value.
+func languageQuality(part string) (tag string, q float64) {
+	fields := strings.Split(part, ";")
+	tag = strings.ToLower(strings.TrimSpace(fields[0]))
+	q = 1.0
+	for _, f := range fields[1:] {
+		f = strings.TrimSpace(f)
+		if strings.HasPrefix(f, "q=") {
+			if v, err := strconv.ParseFloat(f[2:], 64); err == nil {
+				q = v
+			}
+		}
+	}
+	return
+}
+
+// Reads the message catalogues.
+func loadCatalogues() error {
+	var names []string
+	if embeddedAssets == nil || com.Conf().Web.DevMode {
+		files, err := ioutil.ReadDir(filepath.Join(com.Conf().Web.BaseDir, "webui", "locales"))
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			names = append(names, "locales/"+f.Name())
+		}
+	} else {
+		for name := range embeddedAssets {
+			if strings.HasPrefix(name, "locales/") {
+				names = append(names, name)
+			}
+		}
+	}
+	for _, name := range names {
+		if path.Ext(name) != ".json" {
+			continue
+		}
+		data, err := readAsset(name)
+		if err != nil {
+			return err
+		}
+		var c catalogue
+		if err = json.Unmarshal(data, &c); err != nil {
+			return fmt.Errorf("Error when reading message catalogue '%s': %v", name, err)
+		}
+		catalogues[strings.ToLower(strings.TrimSuffix(path.Base(name), ".json"))] = c
+	}
+	return nil
+}
+
+// Picks the language to use from an Accept-Language header, going by the quality values the browser gave.  Regional
+// variants (eg "de-AT") fall back to the main language when there isn't a catalogue just for them.
+func negotiateLocale(header string) string {
+	best, bestQ := defaultLocale, 0.0
+	for _, part := range strings.Split(header, ",") {
+		tag, q := languageQuality(part)
+		if q <= bestQ {
+			continue
+		}
+		for tag != "" {
+			if _, ok := catalogues[tag]; ok {
+				best, bestQ = tag, q
+				break
+			}
+			i := strings.LastIndex(tag, "-")
+			if i < 0 {
+				break
+			}
+			tag = tag[:i]
+		}
+	}
+	return best
+}
+
+// Returns the language to show a page in.  Logged in users can choose one in their preferences, otherwise it's
+// worked out from what the browser asks for.
+func requestLocale(r *http.Request) string {
+	if l := requestDetails(r).locale; l != "" {
+		if _, ok := catalogues[l]; ok {
+			return l
+		}
+	}
+	return negotiateLocale(r.Header.Get("Accept-Language"))
+}
+
+// Translates a message into the given language.  Any arguments are filled in with fmt.Sprintf(), after translation.
+func translate(locale string, msg string, args ...interface{}) string {
+	if t := catalogues[locale].Messages[msg]; t != "" {
+		msg = t
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(msg, args...)
+	}
+	return msg
+}
+
+// Swaps a project's descriptions and README for their translation into the language the visitor wants, if there is
+// one.  Fields which haven't been translated are left as they are.  Returns the languages the project has been
+// translated into for the language switcher, along with the one being shown.
+func translateDescriptions(r *http.Request, owner string, folder string, fileName string, info *com.DBInfo) (
+	langs []localeInfo, lang string, err error) {
+	translations, err := com.ProjectTranslations(owner, folder, fileName)
+	if err != nil || len(translations) == 0 {
+		return
+	}
+	for _, t := range translations {
+		langs = append(langs, localeInfo{Name: languageName(t.Language), Tag: t.Language})
+	}
+	lang = descriptionLanguage(r, translations)
+	for _, t := range translations {
+		if t.Language != lang {
+			continue
+		}
+		if t.OneLineDesc != "" {
+			info.OneLineDesc = t.OneLineDesc
+		}
+		if t.FullDesc != "" {
+			info.FullDesc = string(gfm.Markdown([]byte(t.FullDesc)))
+		}
+		if t.Readme != "" {
+			info.Readme = string(gfm.Markdown([]byte(t.Readme)))
+		}
+	}
+	return
+}
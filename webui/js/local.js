@@ -1,3 +1,56 @@
+// The date format and timezone the user has chosen in their preferences.  The page header fills these in.  An empty
+// timezone means the browser's own one is used
+var datePrefs = {dateFormat: "medium", timeZone: ""};
+
+// Returns a date formatted using the user's date preferences.  The time of day is included unless withTime is false
+function formatDate(date1, withTime) {
+    if (date1 === undefined || date1 === null || date1 === "") {
+        return "";
+    }
+    var d1 = new Date(date1);
+    if (isNaN(d1.getTime())) {
+        return "";
+    }
+    withTime = (withTime !== false);
+
+    // Work out the parts of the date in the chosen timezone, falling back to the browser's one if it's not known
+    var opts = { year: "numeric", month: "2-digit", day: "2-digit", hour: "2-digit", minute: "2-digit",
+        second: "2-digit", hourCycle: "h23" };
+    if (datePrefs.timeZone !== "") {
+        opts.timeZone = datePrefs.timeZone;
+    }
+    var fmt;
+    try {
+        fmt = new Intl.DateTimeFormat("en-US", opts);
+    } catch (e) {
+        delete opts.timeZone;
+        fmt = new Intl.DateTimeFormat("en-US", opts);
+    }
+    var p = {};
+    fmt.formatToParts(d1).forEach(function(part) {
+        p[part.type] = part.value;
+    });
+    var hour = parseInt(p.hour, 10) % 24;
+    var h12 = (hour % 12 === 0) ? 12 : hour % 12;
+    var ampm = (hour < 12) ? "AM" : "PM";
+
+    switch (datePrefs.dateFormat) {
+        case "iso":
+            return p.year + "-" + p.month + "-" + p.day +
+                (withTime ? " " + p.hour + ":" + p.minute + ":" + p.second : "");
+        case "dmy":
+            return p.day + "/" + p.month + "/" + p.year + (withTime ? " " + p.hour + ":" + p.minute : "");
+        case "mdy":
+            return p.month + "/" + p.day + "/" + p.year + (withTime ? " " + h12 + ":" + p.minute + " " + ampm : "");
+    }
+    var months = ["Jan", "Feb", "Mar", "Apr", "May", "Jun", "Jul", "Aug", "Sep", "Oct", "Nov", "Dec"];
+    var str = months[parseInt(p.month, 10) - 1] + " " + parseInt(p.day, 10) + ", " + p.year;
+    if (withTime) {
+        str += ", " + h12 + ":" + p.minute + ":" + p.second + " " + ampm;
+    }
+    return str;
+}
+
 // Returns a string describing how long ago the given date was.  eg "3 seconds ago", "2 weeks ago", etc
 function getTimePeriod(date1, includeOn) {
     var d1 = new Date(date1);
@@ -24,8 +77,7 @@ function getTimePeriod(date1, includeOn) {
                     // If the time elapsed is more then 4 weeks ago, we return the date, nicely formatted
                     if (weeksElapsed > 4) {
                         var str = includeOn ? "on " : "";
-                        return str + d1.toLocaleTimeString(undefined, { year: "numeric", month: "short",
-                            day: "numeric", weekday: "short", hour: "2-digit", minute: "2-digit" });
+                        return str + formatDate(date1);
                     } else {
                         var p0 = (weeksElapsed === 1) ? "" : "s";
                         return weeksElapsed + " week" + p0 + " ago"
@@ -53,4 +105,12 @@ function getTimePeriod(date1, includeOn) {
             return secondsElapsed+" second" + p4 + " ago";
         }
     }
-}
\ No newline at end of file
+}
+
+// Adds a "localDate" filter for the Angular pages, which formats dates using the user's date preferences.  eg
+// {{ row.DateCreated | localDate }}, or {{ row.DateCreated | localDate : false }} to leave out the time of day
+if (typeof angular !== "undefined") {
+    angular.module("3DHubDates", []).filter("localDate", function() {
+        return formatDate;
+    });
+}
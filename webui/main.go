@@ -1,1488 +1,2760 @@
 package main
 
 import (
+	"crypto/rand"
 	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"html/template"
 	"io"
 	"io/ioutil"
-	"log"
+	"net"
 	"net/http"
+	"net/http/pprof"
 	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	gz "github.com/NYTimes/gziphandler"
 	"github.com/bradfitz/gomemcache/memcache"
 	gsm "github.com/bradleypeabody/gorilla-sessions-memcache"
+	"github.com/gorilla/sessions"
 	sqlite "github.com/gwenn/gosqlite"
 	com "github.com/justinclift/3dhub.io/common"
 	gfm "github.com/sqlitebrowser/github_flavored_markdown"
+	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/net/websocket"
 	"golang.org/x/oauth2"
 )
 
 var (
+	// The site-wide announcements, and when they were last retrieved from PostgreSQL
+	announcements        []com.Announcement
+	announcementsChecked time.Time
+	announcementMu       sync.Mutex
+
 	// Log file for incoming HTTPS requests
-	reqLog *os.File
+	reqLog *requestLogger
 
-	// Our parsed HTML templates
-	tmpl *template.Template
+	// Our parsed HTML templates, by language
+	tmpl map[string]*template.Template
 
 	// Session cookie storage
-	store *gsm.MemcacheStore
+	store sessions.Store
 )
 
-// auth0CallbackHandler is called at the end of the Auth0 authentication process, whether successful or not.
-// If the authentication process was successful:
-//  * if the user already has an account on our system then this function creates a login session for them.
-//  * if the user doesn't yet have an account on our system, they're bounced to the username selection page.
-// If the authentication process wasn't successful, an error message is displayed.
-func auth0CallbackHandler(w http.ResponseWriter, r *http.Request) {
-	// Auth0 login part, mostly copied from https://github.com/auth0-samples/auth0-golang-web-app (MIT License)
-	conf := &oauth2.Config{
-		ClientID:     com.Conf.Auth0.ClientID,
-		ClientSecret: com.Conf.Auth0.ClientSecret,
-		RedirectURL:  "https://" + com.Conf.Web.ServerName + "/x/callback",
-		Scopes:       []string{"openid", "profile"},
-		Endpoint: oauth2.Endpoint{
-			AuthURL:  "https://" + com.Conf.Auth0.Domain + "/authorize",
-			TokenURL: "https://" + com.Conf.Auth0.Domain + "/oauth/token",
-		},
-	}
-	code := r.URL.Query().Get("code")
-	if code == "" {
-		log.Printf("Login failure from '%v', probably due to blocked 3rd party cookies\n", r.RemoteAddr)
-		errorPage(w, r, http.StatusInternalServerError,
-			"Login failure.  Please allow 3rd party cookies from https://dbhub.eu.auth0.com then try again (it should then work).")
-		return
-	}
-	token, err := conf.Exchange(oauth2.NoContext, code)
-	if err != nil {
-		log.Printf("Login failure: %s\n", err.Error())
-		errorPage(w, r, http.StatusInternalServerError, "Login failed")
-		return
-	}
+// Adds a new category to the category tree.  Only available to site administrators.
+func adminAddCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
 
-	// Retrieve the user info (JSON format)
-	conn := conf.Client(oauth2.NoContext, token)
-	userInfo, err := conn.Get("https://" + com.Conf.Auth0.Domain + "/userinfo")
-	if err != nil {
-		errorPage(w, r, http.StatusInternalServerError, err.Error())
+	// Validate the new category name
+	name := strings.TrimSpace(r.PostFormValue("name"))
+	err := com.ValidateCategoryName(name)
+	if err != nil || com.CategorySlug(name) == "" {
+		errorPage(w, r, http.StatusBadRequest, "Invalid category name")
 		return
 	}
-	raw, err := ioutil.ReadAll(userInfo.Body)
-	defer userInfo.Body.Close()
+	parentID, err := strconv.ParseInt(r.PostFormValue("parent"), 10, 64)
 	if err != nil {
-		errorPage(w, r, http.StatusInternalServerError, err.Error())
-		return
-	}
-
-	// Convert the JSON into something usable
-	var profile map[string]interface{}
-	if err = json.Unmarshal(raw, &profile); err != nil {
-		errorPage(w, r, http.StatusInternalServerError, err.Error())
-		return
-	}
-
-	// Extract the basic user info we use
-	var auth0Conn, auth0ID, avatarURL, email, nickName string
-	em := profile["email"]
-	if em != nil {
-		email = em.(string)
-	}
-	au := profile["user_id"]
-	if au != nil {
-		auth0ID = au.(string)
-	}
-	if auth0ID == "" {
-		log.Printf("Auth0 callback error: Auth0 ID string was empty. Email: %s\n", email)
-		errorPage(w, r, http.StatusInternalServerError, "Error: Auth0 ID string was empty")
+		errorPage(w, r, http.StatusBadRequest, "Invalid parent category")
 		return
 	}
-	ni := profile["nickname"]
-	if ni != nil {
-		nickName = ni.(string)
-	}
 
-	// Determine if the user has a profile pic we can use
-	var i map[string]interface{}
-	if profile["identities"] != nil {
-		i = profile["identities"].([]interface{})[0].(map[string]interface{})
-	}
-	co, ok := i["connection"]
-	if ok {
-		auth0Conn = co.(string)
-	}
-	if auth0Conn != "Test2DB" { // The Auth0 fallback profile pic's seem pretty lousy, so avoid those
-		p, ok := profile["picture"]
-		if ok && p.(string) != "" {
-			avatarURL = p.(string)
+	// If a parent category was given, make sure it exists and there's room for another level below it
+	if parentID != 0 {
+		cats, err := com.Categories()
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, "Error when retrieving list of categories")
+			return
+		}
+		found := false
+		for _, c := range cats {
+			if c.ID == parentID {
+				found = true
+				if c.Depth+1 >= com.MaxCategoryDepth {
+					errorPage(w, r, http.StatusBadRequest, "The category tree can't be any deeper")
+					return
+				}
+			}
+		}
+		if !found {
+			errorPage(w, r, http.StatusBadRequest, "Unknown parent category")
+			return
 		}
 	}
 
-	// If the user has an unverified email address, tell them to verify it before proceeding
-	ve := profile["email_verified"]
-	if ve != nil && ve.(bool) != true {
-		// TODO: Create a nicer notice page for this, as errorPage() doesn't look friendly
-		errorPage(w, r, http.StatusUnauthorized, "Please check your email.  You need to verify your "+
-			"email address before logging in will work.")
+	// Add the category
+	err = com.AddCategory(parentID, name)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Adding the category failed.  Does it already exist?")
 		return
 	}
-
-	// Determine the 3DHub.io username matching the given Auth0 ID
-	userName, err := com.UserNameFromAuth0ID(auth0ID)
+	err = com.AddAuditLogEntry(loggedInUser, "addcategory", name, fmt.Sprintf("Parent category ID: %d", parentID))
 	if err != nil {
-		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		errorPage(w, r, http.StatusInternalServerError, "Recording the change in the audit log failed")
 		return
 	}
 
-	// If the user doesn't already exist, we need to create an account for them
-	if userName == "" {
-		if email != "" {
-			// Check if the email address is already in our system
-			exists, err := com.CheckEmailExists(email)
+	// Bounce back to the category admin page
+	http.Redirect(w, r, "/admin/categories", http.StatusSeeOther)
+}
+
+// Adds or removes a site-wide announcement, recording the change in the audit log.  The action is either "add" or
+// "delete".  Only available to site administrators.
+func adminAnnouncementHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
+
+	var details, target string
+	switch r.PostFormValue("action") {
+	case "add":
+		// Validate the message and the times to show it between.  The times are in UTC
+		msg := strings.TrimSpace(r.PostFormValue("message"))
+		if msg == "" || com.ValidateMarkdown(msg) != nil {
+			errorPage(w, r, http.StatusBadRequest, "Invalid announcement message")
+			return
+		}
+		start := time.Now()
+		var end time.Time
+		var err error
+		if s := r.PostFormValue("start"); s != "" {
+			start, err = time.ParseInLocation("2006-01-02T15:04", s, time.UTC)
 			if err != nil {
-				errorPage(w, r, http.StatusInternalServerError, "Email check failed.  Can't continue.")
+				errorPage(w, r, http.StatusBadRequest, "Invalid start time")
 				return
 			}
-			if exists {
-				errorPage(w, r, http.StatusConflict,
-					"Can't create new account: Your email address is already associated "+
-						"with a different account in our system.")
+		}
+		if e := r.PostFormValue("end"); e != "" {
+			end, err = time.ParseInLocation("2006-01-02T15:04", e, time.UTC)
+			if err != nil || !end.After(start) {
+				errorPage(w, r, http.StatusBadRequest, "Invalid end time")
 				return
 			}
 		}
-
-		// Create a special session cookie, purely for the registration page
-		sess, err := store.Get(r, "user-reg")
+		err = com.AddAnnouncement(loggedInUser, msg, start, end)
 		if err != nil {
-			if err == memcache.ErrCacheMiss {
-				// Seems like a stale session token, so delete the session and reload the page
-				sess.Options.MaxAge = -1
-				err = sess.Save(r, w)
-				if err != nil {
-					errorPage(w, r, http.StatusInternalServerError, err.Error())
-					return
-				}
-				http.Redirect(w, r, "/selectusername", http.StatusTemporaryRedirect)
-				return
-			}
-			errorPage(w, r, http.StatusBadRequest, err.Error())
+			errorPage(w, r, http.StatusInternalServerError, "Adding the announcement failed")
 			return
 		}
-		sess.Values["registrationinprogress"] = true
-		sess.Values["auth0id"] = auth0ID
-		sess.Values["avatar"] = avatarURL
-		sess.Values["email"] = email
-		sess.Values["nickname"] = nickName
-		err = sess.Save(r, w)
+		target = "announcement"
+		details = msg
+	case "delete":
+		id, err := strconv.ParseInt(r.PostFormValue("id"), 10, 64)
+		if err != nil || id < 1 {
+			errorPage(w, r, http.StatusBadRequest, "Invalid announcement ID")
+			return
+		}
+		err = com.DeleteAnnouncement(id)
 		if err != nil {
-			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			errorPage(w, r, http.StatusInternalServerError, "Removing the announcement failed")
 			return
 		}
-
-		// Bounce to a new page, for the user to select their preferred username
-		http.Redirect(w, r, "/selectusername", http.StatusSeeOther)
+		target = fmt.Sprintf("announcement %d", id)
+	default:
+		errorPage(w, r, http.StatusBadRequest, "Unknown action")
 		return
 	}
 
-	// If Auth0 provided a picture URL for the user, check if it's different to what we already have (eg it may have
-	// been updated)
-	if avatarURL != "" {
-		usr, err := com.User(userName)
-		if err != nil {
-			errorPage(w, r, http.StatusBadRequest, err.Error())
-			return
-		}
-		if usr.AvatarURL != avatarURL {
-			// The Auth0 provided pic URL is different to what we have already, so we update the database with the new
-			// value
-			err = com.UpdateAvatarURL(userName, avatarURL)
-			if err != nil {
-				errorPage(w, r, http.StatusBadRequest, err.Error())
-				return
-			}
-		}
+	// Show the change straight away, rather than waiting for the cached announcements to expire
+	announcementMu.Lock()
+	announcementsChecked = time.Time{}
+	announcementMu.Unlock()
+
+	// Record the action in the audit log
+	action := r.PostFormValue("action") + "announcement"
+	err := com.AddAuditLogEntry(loggedInUser, action, target, details)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "The announcement was changed, but recording it in the "+
+			"audit log failed")
+		return
 	}
 
-	// Create a session cookie for the user
-	sess, err := store.Get(r, "3dhub-user")
+	// Bounce back to the admin page
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// Queues a backup to be run straight away, rather than waiting for the next scheduled one.  Only available to site
+// administrators.
+func adminBackupHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
+
+	if com.Conf().Backup.Bucket == "" {
+		errorPage(w, r, http.StatusBadRequest, "No bucket has been set for backups in the configuration file")
+		return
+	}
+	id, err := com.QueueBackup()
 	if err != nil {
-		errorPage(w, r, http.StatusBadRequest, err.Error())
+		errorPage(w, r, http.StatusInternalServerError, "Queuing the backup failed")
 		return
 	}
-	sess.Values["UserName"] = userName
-	sess.Save(r, w)
+
+	// Record the action in the audit log
+	err = com.AddAuditLogEntry(loggedInUser, "runbackup", fmt.Sprintf("Backup run %d", id), "")
 	if err != nil {
-		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		errorPage(w, r, http.StatusInternalServerError, "The backup was queued, but recording it in the audit log "+
+			"failed")
 		return
 	}
 
-	// Login completed, so bounce to the users' profile page
-	http.Redirect(w, r, "/"+userName, http.StatusSeeOther)
+	// Bounce back to the admin page
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
 }
 
-// Returns a list of the branches present in a database
-func branchNamesHandler(w http.ResponseWriter, r *http.Request) {
-	// Retrieve session data (if any)
-	var loggedInUser string
-	var u interface{}
-	validSession := false
-	if com.Conf.Environment.Environment != "docker" {
-		sess, err := store.Get(r, "3dhub-user")
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
-		u = sess.Values["UserName"]
-	} else {
-		u = "default"
-	}
-	if u != nil {
-		loggedInUser = u.(string)
-		validSession = true
+// Serves the Go runtime profiles (CPU, heap, goroutines, etc) from net/http/pprof, for tracking down memory and CPU
+// problems on the production servers.  The per handler request metrics are available here too.  Only available to
+// site administrators.
+func adminDebugHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
+
+	// Hand the request to the matching pprof handler.  The profile index uses relative links, so works fine from here
+	name := strings.TrimPrefix(r.URL.Path, "/admin/debug/")
+	com.Log.Infof("Admin '%s' requested debugging profile '%s'", loggedInUser, name)
+	switch name {
+	case "":
+		pprof.Index(w, r)
+	case "cmdline":
+		pprof.Cmdline(w, r)
+	case "metrics":
+		writeMetrics(w)
+	case "profile":
+		pprof.Profile(w, r)
+	case "symbol":
+		pprof.Symbol(w, r)
+	case "trace":
+		pprof.Trace(w, r)
+	default:
+		pprof.Handler(name).ServeHTTP(w, r)
 	}
+}
 
-	// Ensure we have a valid logged in user
-	if validSession != true {
-		w.WriteHeader(http.StatusUnauthorized)
+// Removes a category (and its sub-categories) from the category tree.  Only available to site administrators.
+func adminDeleteCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
+
+	// Remove the category
+	catID, err := strconv.ParseInt(r.PostFormValue("id"), 10, 64)
+	if err != nil || catID < 1 {
+		errorPage(w, r, http.StatusBadRequest, "Invalid category ID")
 		return
 	}
-
-	// Extract the required form variables
-	usr, folder, fileName, err := com.GetUFD(r, true)
+	err = com.DeleteCategory(catID)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		errorPage(w, r, http.StatusInternalServerError, "Removing the category failed")
 		return
 	}
-	owner := strings.ToLower(usr)
-
-	// If any of the required values were empty, indicate failure
-	if owner == "" || folder == "" || fileName == "" {
-		w.WriteHeader(http.StatusBadRequest)
+	err = com.AddAuditLogEntry(loggedInUser, "deletecategory", fmt.Sprintf("%d", catID), "")
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Recording the change in the audit log failed")
 		return
 	}
 
-	// Make sure the database exists in the system
-	exists, err := com.CheckFileExists(loggedInUser, owner, folder, fileName)
-	if err != err {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, err.Error())
+	// Bounce back to the category admin page
+	http.Redirect(w, r, "/admin/categories", http.StatusSeeOther)
+}
+
+// Turns one of the optional features on or off.  Only available to site administrators.
+func adminFeatureHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
+
+	id := r.PostFormValue("feature")
+	if !com.KnownFeature(id) {
+		errorPage(w, r, http.StatusBadRequest, "Unknown feature")
 		return
 	}
-	if !exists {
-		log.Printf("%s: Validation failed for database name: %s", com.GetCurrentFunctionName(), err)
-		w.WriteHeader(http.StatusNotFound)
+	var enabled bool
+	switch r.PostFormValue("action") {
+	case "enable":
+		enabled = true
+	case "disable":
+	default:
+		errorPage(w, r, http.StatusBadRequest, "Unknown action")
 		return
 	}
-
-	// Retrieve the branch info for the database
-	branchList, err := com.GetBranches(owner, folder, fileName)
+	err := com.SetFeature(loggedInUser, id, enabled)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, err.Error())
+		errorPage(w, r, http.StatusInternalServerError, "Changing the feature failed")
 		return
 	}
-	defBranch, err := com.GetDefaultBranchName(owner, folder, fileName)
+	err = com.AddAuditLogEntry(loggedInUser, r.PostFormValue("action")+"feature", id, "")
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, err.Error())
+		errorPage(w, r, http.StatusInternalServerError, "Recording the change in the audit log failed")
 		return
 	}
 
-	// Prepare the branch list for sending
-	var b struct {
-		Branches      []string `json:"branches"`
-		DefaultBranch string   `json:"default_branch"`
+	// Bounce back to the admin page
+	http.Redirect(w, r, "/admin#features", http.StatusSeeOther)
+}
+
+// Adds a word (or pattern of words) to the word filter, or removes one.
+func adminFilterWordHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
+
+	pattern := strings.ToLower(strings.TrimSpace(r.PostFormValue("pattern")))
+	err := com.ValidateFilterWord(pattern)
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, err.Error())
+		return
 	}
-	for name := range branchList {
-		b.Branches = append(b.Branches, name)
+	action := r.PostFormValue("action")
+	switch action {
+	case "add":
+		err = com.AddFilterWord(loggedInUser, pattern)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, "Adding the word to the filter failed")
+			return
+		}
+	case "delete":
+		err = com.DeleteFilterWord(pattern)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, "Removing the word from the filter failed")
+			return
+		}
+	default:
+		errorPage(w, r, http.StatusBadRequest, "Unknown action")
+		return
 	}
-	b.DefaultBranch = defBranch
-	data, err := json.MarshalIndent(b, "", " ")
+
+	// Apply the change straight away, rather than waiting for the cached list to expire
+	com.ResetFilterWords()
+
+	// Record the action in the audit log
+	err = com.AddAuditLogEntry(loggedInUser, action+"filterword", pattern, "")
 	if err != nil {
-		log.Println(err)
+		errorPage(w, r, http.StatusInternalServerError, "The word filter was changed, but recording it in the "+
+			"audit log failed")
 		return
 	}
 
-	// Return the branch list
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprint(w, string(data))
+	// Bounce back to the admin page
+	http.Redirect(w, r, "/admin#filterwords", http.StatusSeeOther)
 }
 
-func createBranchHandler(w http.ResponseWriter, r *http.Request) {
-	// Retrieve session data (if any)
-	var loggedInUser string
-	var u interface{}
-	validSession := false
-	if com.Conf.Environment.Environment != "docker" {
-		sess, err := store.Get(r, "3dhub-user")
+// Deals with a username flagged by the word filter.  Approving it lets the user keep the name, while suspending it
+// stops the account being used until an admin unsuspends it.  Either way the name is taken off the list.
+func adminFlaggedNameHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
+
+	userName := r.PostFormValue("username")
+	err := com.ValidateUser(userName)
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, "Invalid user name")
+		return
+	}
+
+	action := r.PostFormValue("action")
+	switch action {
+	case "approve":
+	case "suspend":
+		if strings.ToLower(userName) == strings.ToLower(loggedInUser) {
+			errorPage(w, r, http.StatusBadRequest, "That account can't be changed from here")
+			return
+		}
+		err = com.SetUserSuspended(userName, true)
 		if err != nil {
-			errorPage(w, r, http.StatusBadRequest, err.Error())
+			errorPage(w, r, http.StatusInternalServerError, "Suspending the account failed")
 			return
 		}
-		u = sess.Values["UserName"]
-	} else {
-		u = "default"
-	}
-	if u != nil {
-		loggedInUser = u.(string)
-		validSession = true
+	default:
+		errorPage(w, r, http.StatusBadRequest, "Unknown action")
+		return
 	}
-
-	// Ensure we have a valid logged in user
-	if validSession != true {
-		errorPage(w, r, http.StatusUnauthorized, "You need to be logged in")
+	err = com.DeleteFlaggedUsername(userName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Removing the username from the moderation queue failed")
 		return
 	}
 
-	// Extract and validate the form variables
-	owner, fileName, commit, err := com.GetFormUDC(r)
+	// Record the action in the audit log
+	err = com.AddAuditLogEntry(loggedInUser, action+"username", userName, "Flagged by the word filter")
 	if err != nil {
-		errorPage(w, r, http.StatusBadRequest, "Missing or incorrect data supplied")
+		errorPage(w, r, http.StatusInternalServerError, "The action was carried out, but recording it in the "+
+			"audit log failed")
 		return
 	}
-	branchName, err := com.GetFormBranch(r)
+
+	// Bounce back to the moderation queue
+	http.Redirect(w, r, "/admin/moderation#usernames", http.StatusSeeOther)
+}
+
+// Approves or deletes a comment which was held for moderation by the spam check.  Approved comments are added to
+// their discussion as if they'd just been posted.
+func adminHeldCommentHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
+
+	id, err := strconv.ParseInt(r.PostFormValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		errorPage(w, r, http.StatusBadRequest, "Invalid comment ID")
+		return
+	}
+	c, err := com.HeldComment(id)
 	if err != nil {
-		errorPage(w, r, http.StatusBadRequest, "Missing or incorrect branch name")
+		errorPage(w, r, http.StatusNotFound, "Unknown comment")
 		return
 	}
-	bd := r.PostFormValue("branchdesc") // Optional
 
-	// If given, validate the branch description field
-	var branchDesc string
-	if bd != "" {
-		err = com.Validate.Var(bd, "markdownsource")
+	action := r.PostFormValue("action")
+	switch action {
+	case "approve":
+		err = com.StoreComment(c.Owner, c.Folder, c.DBName, c.Commenter, c.DiscID, c.Body, false,
+			com.CLOSED_WITHOUT_MERGE) // com.CLOSED_WITHOUT_MERGE is ignored, as the discussion isn't being closed
 		if err != nil {
-			errorPage(w, r, http.StatusBadRequest, "Invalid characters in branch description")
+			errorPage(w, r, http.StatusInternalServerError, "Adding the comment to its discussion failed")
 			return
 		}
-		branchDesc = bd
+		com.PublishLiveUpdate(c.Owner, c.Folder, c.DBName, com.LIVE_COMMENT, c.DiscID)
+	case "delete":
+	default:
+		errorPage(w, r, http.StatusBadRequest, "Unknown action")
+		return
 	}
-
-	// Check if the requested database exists
-	folder := "/"
-	exists, err := com.CheckFileExists(loggedInUser, owner, folder, fileName)
+	err = com.DeleteHeldComment(id)
 	if err != nil {
-		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		errorPage(w, r, http.StatusInternalServerError, "Removing the comment from the moderation queue failed")
 		return
 	}
-	if !exists {
-		errorPage(w, r, http.StatusNotFound, fmt.Sprintf("Database '%s%s%s' doesn't exist", owner, folder,
-			fileName))
+
+	// Record the action in the audit log
+	err = com.AddAuditLogEntry(loggedInUser, action+"comment",
+		fmt.Sprintf("%s%s%s discussion %d", c.Owner, c.Folder, c.DBName, c.DiscID),
+		fmt.Sprintf("Comment by %s: %s", c.Commenter, c.Body))
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "The action was carried out, but recording it in the "+
+			"audit log failed")
 		return
 	}
 
-	// Make sure the database owner matches the logged in user
-	if strings.ToLower(loggedInUser) != strings.ToLower(owner) {
-		errorPage(w, r, http.StatusUnauthorized, "You can't change databases you don't own")
+	// Bounce back to the moderation queue
+	http.Redirect(w, r, "/admin/moderation", http.StatusSeeOther)
+}
+
+// Adds or removes an entry on the IP address allow and block lists.
+func adminIPRuleHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
+
+	var details, target string
+	switch r.PostFormValue("action") {
+	case "add":
+		// Validate the network and what to do with requests from it
+		n, err := com.ParseNetwork(strings.TrimSpace(r.PostFormValue("network")))
+		if err != nil {
+			errorPage(w, r, http.StatusBadRequest, "Invalid IP address or network")
+			return
+		}
+		listType := r.PostFormValue("type")
+		if listType != "allow" && listType != "block" {
+			errorPage(w, r, http.StatusBadRequest, "Unknown IP rule type")
+			return
+		}
+		reason := strings.TrimSpace(r.PostFormValue("reason"))
+		if len(reason) > 1024 {
+			errorPage(w, r, http.StatusBadRequest, "The reason given is too long")
+			return
+		}
+		err = com.AddIPRule(loggedInUser, n.String(), listType, reason)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, "Adding the IP rule failed")
+			return
+		}
+		target = n.String()
+		details = fmt.Sprintf("%s: %s", listType, reason)
+	case "delete":
+		id, err := strconv.ParseInt(r.PostFormValue("id"), 10, 64)
+		if err != nil || id < 1 {
+			errorPage(w, r, http.StatusBadRequest, "Invalid IP rule ID")
+			return
+		}
+		err = com.DeleteIPRule(id)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, "Removing the IP rule failed")
+			return
+		}
+		target = fmt.Sprintf("IP rule %d", id)
+	default:
+		errorPage(w, r, http.StatusBadRequest, "Unknown action")
 		return
 	}
 
-	// Read the branch heads list from the database
-	branches, err := com.GetBranches(owner, folder, fileName)
+	// Apply the change straight away, rather than waiting for the cached lists to expire
+	ipRulesMu.Lock()
+	ipRulesChecked = time.Time{}
+	ipRulesMu.Unlock()
+
+	// Record the action in the audit log
+	action := r.PostFormValue("action") + "iprule"
+	err := com.AddAuditLogEntry(loggedInUser, action, target, details)
 	if err != nil {
-		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		errorPage(w, r, http.StatusInternalServerError, "The IP rules were changed, but recording it in the audit "+
+			"log failed")
 		return
 	}
 
-	// Make sure the branch name doesn't already exist
-	_, ok := branches[branchName]
-	if ok {
-		errorPage(w, r, http.StatusConflict, "A branch of that name already exists!")
+	// Bounce back to the admin page
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// Retries or removes a background job which has failed too many times to be retried automatically.
+func adminJobHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
+
+	id, err := strconv.ParseInt(r.PostFormValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		errorPage(w, r, http.StatusBadRequest, "Invalid background job ID")
+		return
+	}
+	action := r.PostFormValue("action")
+	switch action {
+	case "delete":
+		err = com.DeleteJob(id)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, "Removing the background job failed")
+			return
+		}
+	case "retry":
+		err = com.RetryJob(id)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, "Retrying the background job failed")
+			return
+		}
+	default:
+		errorPage(w, r, http.StatusBadRequest, "Unknown action")
 		return
 	}
 
-	// Count the number of commits in the new branch
-	commitList, err := com.GetCommitList(owner, folder, fileName)
+	// Record the action in the audit log
+	err = com.AddAuditLogEntry(loggedInUser, action+"job", fmt.Sprintf("Background job %d", id), "")
 	if err != nil {
-		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		errorPage(w, r, http.StatusInternalServerError, "The background job was changed, but recording it in the "+
+			"audit log failed")
 		return
 	}
-	c, ok := commitList[commit]
-	if !ok {
-		errorPage(w, r, http.StatusBadRequest, fmt.Sprint("The given commit ID doesn't exist"))
+
+	// Bounce back to the admin page
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// Carries out a moderation action (approve, hide, or delete) on a project in the moderation queue.
+func adminModerateHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
+
+	// Extract the project being moderated
+	owner, folder, fileName, err := com.GetUFD(r, false)
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, "Missing or incorrect data supplied")
 		return
 	}
-	commitCount := 1
-	for c.Parent != "" {
-		commitCount++
-		c, ok = commitList[c.Parent]
-		if !ok {
-			log.Printf("Error when counting commits in new branch '%s' of database '%s%s%s'\n", branchName,
-				owner, folder, fileName)
-			return
-		}
+	exists, err := com.CheckFileExists(owner, owner, folder, fileName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Database query failed")
+		return
+	}
+	if !exists {
+		errorPage(w, r, http.StatusNotFound, fmt.Sprintf("Unknown project: %s%s%s", owner, folder, fileName))
+		return
 	}
 
-	// Create the branch
-	newBranch := com.BranchEntry{
-		Commit:      commit,
-		CommitCount: commitCount,
-		Description: branchDesc,
+	// Clear the cached project details, so the change is seen straight away
+	err = com.InvalidateCacheEntry(owner, owner, folder, fileName, "") // Empty string indicates "for all versions"
+	if err != nil {
+		com.Log.Errorf("Error when invalidating memcache entries: %s", err.Error())
+	}
+
+	// Carry out the requested action
+	action := r.PostFormValue("action")
+	switch action {
+	case "approve":
+		err = com.SetModerationStatus(owner, folder, fileName, "approved")
+	case "hide":
+		err = com.SetModerationStatus(owner, folder, fileName, "hidden")
+	case "delete":
+		err = com.DeleteDatabase(owner, folder, fileName)
+	default:
+		errorPage(w, r, http.StatusBadRequest, "Unknown action")
+		return
 	}
-	branches[branchName] = newBranch
-	err = com.StoreBranches(owner, folder, fileName, branches)
 	if err != nil {
-		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		errorPage(w, r, http.StatusInternalServerError, fmt.Sprintf("The '%s' action failed: %v", action, err))
 		return
 	}
 
-	// Invalidate the memcache data for the database, so the new branch count gets picked up
-	err = com.InvalidateCacheEntry(loggedInUser, owner, folder, fileName, "") // Empty string indicates "for all versions"
+	// Update the search index
+	err = com.UpdateSearchIndex(owner, folder, fileName)
 	if err != nil {
-		// Something went wrong when invalidating memcached entries for the database
-		log.Printf("Error when invalidating memcache entries: %s\n", err.Error())
+		com.Log.Errorf("Error when updating the search index: %s", err.Error())
+	}
+
+	// Record the action in the audit log
+	err = com.AddAuditLogEntry(loggedInUser, action, fmt.Sprintf("%s%s%s", owner, folder, fileName), "")
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "The action was carried out, but recording it in the "+
+			"audit log failed")
 		return
 	}
+	com.Log.Infof("Admin '%s' carried out moderation action '%s' on project '%s%s%s'", loggedInUser, action, owner,
+		folder, fileName)
 
-	// Bounce to the branches page
-	http.Redirect(w, r, fmt.Sprintf("/branches/%s%s%s", loggedInUser, folder, fileName), http.StatusSeeOther)
+	// Bounce back to the moderation queue
+	http.Redirect(w, r, "/admin/moderation", http.StatusSeeOther)
 }
 
-// Receives incoming info for adding a comment to an existing discussion
-func createCommentHandler(w http.ResponseWriter, r *http.Request) {
-	// Retrieve session data (if any)
-	var loggedInUser string
-	var u interface{}
-	validSession := false
-	if com.Conf.Environment.Environment != "docker" {
-		sess, err := store.Get(r, "3dhub-user")
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
-		u = sess.Values["UserName"]
-	} else {
-		u = "default"
-	}
-	if u != nil {
-		loggedInUser = u.(string)
-		validSession = true
-	}
+// Saves or removes an instance project template, which everyone can create new projects from.
+func adminProjectTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
 
-	// Ensure we have a valid logged in user
-	if validSession != true {
-		w.WriteHeader(http.StatusUnauthorized)
-		fmt.Fprint(w, "You need to be logged in")
+	// Instance templates belong to the "default" user, the same as the built in licences
+	action, name, status, err := changeProjectTemplate(r, "default")
+	if err != nil {
+		errorPage(w, r, status, err.Error())
 		return
 	}
 
-	// Extract and validate the form variables
-	owner, folder, fileName, err := com.GetUFD(r, false)
+	// Record the action in the audit log
+	err = com.AddAuditLogEntry(loggedInUser, action+"projecttemplate", name, "")
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprint(w, "Missing or incorrect data supplied")
+		errorPage(w, r, http.StatusInternalServerError, "The project template was changed, but recording it in the "+
+			"audit log failed")
 		return
 	}
 
-	// Ensure a discussion ID was given
-	a := r.PostFormValue("discid")
-	if a == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprint(w, "Missing discussion id")
+	// Bounce back to the admin page
+	http.Redirect(w, r, "/admin#templates", http.StatusSeeOther)
+}
+
+// Queues a re-analysis of every project on the server, for after the checks run on uploads have been improved.  Only
+// available to site administrators.
+func adminReanalyseHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
+
+	n, err := com.QueueReanalysisAll()
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Queuing the re-analysis of the projects failed")
 		return
 	}
-	discID, err := strconv.Atoi(a)
+
+	// Record the action in the audit log
+	err = com.AddAuditLogEntry(loggedInUser, "reanalyse", fmt.Sprintf("%d projects", n), "")
 	if err != nil {
-		log.Printf("Error converting string '%s' to integer in function '%s': %s\n", a,
-			com.GetCurrentFunctionName(), err)
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprint(w, "Error when parsing discussion id value")
+		errorPage(w, r, http.StatusInternalServerError, "The re-analysis was queued, but recording it in the audit "+
+			"log failed")
 		return
 	}
 
-	// Check if the discussion should also be closed or reopened
-	discClose := false
-	c := r.PostFormValue("close")
-	if c == "true" {
-		discClose = true
-	}
+	// Bounce back to the admin page
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
 
-	// If comment text was provided, then validate it.  Note that if the flag for closing/reopening the discussion has
-	// been set, then comment text isn't required.  In all other situations it is
-	rawTxt := r.PostFormValue("comtext")
-	if rawTxt == "" && discClose == false {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprint(w, "Comment can't be empty!")
+// Starts, cancels, or completes the reclamation of an inactive account's username.  Only available to site
+// administrators.
+func adminReclaimHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
+
+	userName := r.PostFormValue("username")
+	err := com.ValidateUser(userName)
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, "Invalid user name")
 		return
 	}
-	var comText string
-	if discClose == false || (discClose == true && rawTxt != "") {
-		// Unescape and validate the comment text
-		t, err := url.QueryUnescape(rawTxt)
+	if strings.ToLower(userName) == strings.ToLower(loggedInUser) {
+		errorPage(w, r, http.StatusBadRequest, "That account can't be changed from here")
+		return
+	}
+
+	var details string
+	action := r.PostFormValue("action")
+	switch action {
+	case "notify":
+		years, err := strconv.Atoi(r.PostFormValue("years"))
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprint(w, "Error when unescaping comment field value")
+			errorPage(w, r, http.StatusBadRequest, "Invalid number of years")
 			return
 		}
-		err = com.Validate.Var(t, "markdownsource")
+		reason := strings.TrimSpace(r.PostFormValue("reason"))
+		if len(reason) > 1024 {
+			errorPage(w, r, http.StatusBadRequest, "The reason given is too long")
+			return
+		}
+		err = com.NotifyUsernameReclamation(loggedInUser, userName, reason, years)
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			fmt.Fprint(w, "Invalid characters in the new discussions' main text field")
+			errorPage(w, r, http.StatusBadRequest, err.Error())
 			return
 		}
-		comText = t
+		details = fmt.Sprintf("Unused for %d years: %s", years, reason)
+	case "cancel":
+		err = com.CancelUsernameReclamation(userName)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, "Cancelling the reclamation failed")
+			return
+		}
+	case "reclaim":
+		reclaimed, err := com.ReclaimUsername(loggedInUser, userName, fmt.Sprintf("Reclaimed from an inactive "+
+			"account on %s", time.Now().UTC().Format("2006-01-02")))
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, "Reclaiming the username failed")
+			return
+		}
+		if !reclaimed {
+			errorPage(w, r, http.StatusConflict, "That username can't be reclaimed yet.  Either the grace period "+
+				"hasn't ended, the user has used the site since being told, or the account now has something on "+
+				"the site")
+			return
+		}
+		details = "Account removed, and username reserved"
+	default:
+		errorPage(w, r, http.StatusBadRequest, "Unknown action")
+		return
 	}
 
-	// Check if the requested database exists
-	exists, err := com.CheckFileExists(loggedInUser, owner, folder, fileName)
+	// Record the action in the audit log
+	err = com.AddAuditLogEntry(loggedInUser, action+"reclaim", userName, details)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, err.Error())
+		errorPage(w, r, http.StatusInternalServerError, "The action was carried out, but recording it in the "+
+			"audit log failed")
 		return
 	}
-	if !exists {
-		w.WriteHeader(http.StatusNotFound)
-		fmt.Fprintf(w, "Database '%s%s%s' doesn't exist", owner, folder, fileName)
+	com.Log.Infof("Admin '%s' carried out reclaim action '%s' on user account '%s'", loggedInUser, action, userName)
+
+	// Bounce back to the admin page
+	http.Redirect(w, r, "/admin#inactive", http.StatusSeeOther)
+}
+
+// Reloads the server configuration file, for the admin page.
+func adminReloadConfigHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
+
+	// Reload the configuration
+	err := com.ReloadConfig()
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, fmt.Sprintf("Reloading the configuration failed: %v", err))
 		return
 	}
 
-	// Add the comment to PostgreSQL
-	err = com.StoreComment(owner, folder, fileName, loggedInUser, discID, comText, discClose,
-		com.CLOSED_WITHOUT_MERGE) // com.CLOSED_WITHOUT_MERGE is ignored for discussions.  It's only used for MRs
+	// Record the action in the audit log
+	err = com.AddAuditLogEntry(loggedInUser, "reloadconfig", "configuration", "")
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, err.Error())
+		errorPage(w, r, http.StatusInternalServerError, "The configuration was reloaded, but recording it in the "+
+			"audit log failed")
 		return
 	}
 
-	// Invalidate the memcache data for the database, so if the discussion counter for the database was changed it
-	// gets picked up
-	if discClose {
-		err = com.InvalidateCacheEntry(loggedInUser, owner, folder, fileName, "") // Empty string indicates "for all versions"
+	// Bounce back to the admin page
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// Reserves a username (or pattern of usernames), or removes a reservation.  Reserved usernames can't be chosen by new
+// users, though existing users with matching names keep them.
+func adminReservedNameHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
+
+	pattern := strings.ToLower(strings.TrimSpace(r.PostFormValue("pattern")))
+	err := com.ValidateReservedUsername(pattern)
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, "Invalid username pattern.  It can have the characters usernames "+
+			"can, plus '*' to match any characters and '?' to match a single character")
+		return
+	}
+	var details string
+	switch r.PostFormValue("action") {
+	case "add":
+		reason := strings.TrimSpace(r.PostFormValue("reason"))
+		if len(reason) > 1024 {
+			errorPage(w, r, http.StatusBadRequest, "The reason given is too long")
+			return
+		}
+		err = com.AddReservedUsername(loggedInUser, pattern, reason)
 		if err != nil {
-			// Something went wrong when invalidating memcached entries for the database
-			log.Printf("Error when invalidating memcache entries: %s\n", err.Error())
+			errorPage(w, r, http.StatusInternalServerError, "Reserving the username failed")
 			return
 		}
-	}
-
-	// Send a success message
-	w.WriteHeader(http.StatusOK)
-}
-
-// Receives incoming info from the "Create a new discussion" page, adds the discussion to PostgreSQL,
-// then bounces to the discussion page
-func createDiscussHandler(w http.ResponseWriter, r *http.Request) {
-	// Retrieve session data (if any)
-	var loggedInUser string
-	var u interface{}
-	validSession := false
-	if com.Conf.Environment.Environment != "docker" {
-		sess, err := store.Get(r, "3dhub-user")
+		details = reason
+	case "delete":
+		err = com.DeleteReservedUsername(pattern)
 		if err != nil {
-			errorPage(w, r, http.StatusBadRequest, err.Error())
+			errorPage(w, r, http.StatusInternalServerError, "Removing the reserved username failed")
 			return
 		}
-		u = sess.Values["UserName"]
-	} else {
-		u = "default"
-	}
-	if u != nil {
-		loggedInUser = u.(string)
-		validSession = true
+	default:
+		errorPage(w, r, http.StatusBadRequest, "Unknown action")
+		return
 	}
 
-	// Ensure we have a valid logged in user
-	if validSession != true {
-		errorPage(w, r, http.StatusUnauthorized, "You need to be logged in")
+	// Apply the change straight away, rather than waiting for the cached list to expire
+	com.ResetReservedUsernames()
+
+	// Record the action in the audit log
+	err = com.AddAuditLogEntry(loggedInUser, r.PostFormValue("action")+"reservedname", pattern, details)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "The reserved usernames were changed, but recording it in "+
+			"the audit log failed")
 		return
 	}
 
-	// Extract and validate the form variables
-	owner, folder, fileName, err := com.GetUFD(r, false)
+	// Bounce back to the admin page
+	http.Redirect(w, r, "/admin#reservednames", http.StatusSeeOther)
+}
+
+// Carries out an action on a user account, recording it in the audit log.  The action is one of "suspend",
+// "unsuspend", "resetquota", "logout", or "delete".  Only available to site administrators.
+func adminUserHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
+
+	// Validate the user account being changed
+	userName := r.PostFormValue("username")
+	err := com.ValidateUser(userName)
 	if err != nil {
-		errorPage(w, r, http.StatusBadRequest, "Missing or incorrect data supplied")
+		errorPage(w, r, http.StatusBadRequest, "Invalid user name")
 		return
 	}
-
-	// Validate the discussions' title
-	tl := r.PostFormValue("title")
-	err = com.ValidateDiscussionTitle(tl)
+	userExists, err := com.CheckUserExists(userName)
 	if err != nil {
-		errorPage(w, r, http.StatusBadRequest, "Invalid characters in the new discussions' title")
+		errorPage(w, r, http.StatusInternalServerError, "Database query failed")
+		return
+	}
+	if !userExists {
+		errorPage(w, r, http.StatusNotFound, fmt.Sprintf("Unknown user: %s", userName))
+		return
+	}
+	if strings.ToLower(userName) == strings.ToLower(loggedInUser) || strings.ToLower(userName) == "default" {
+		errorPage(w, r, http.StatusBadRequest, "That account can't be changed from here")
 		return
 	}
-	discTitle := tl
 
-	// Validate the discussions' text
-	txt := r.PostFormValue("disctxt")
-	if txt == "" {
-		errorPage(w, r, http.StatusBadRequest, "Discussion body can't be empty!")
-		return
-	}
-	err = com.Validate.Var(txt, "markdownsource")
-	if err != nil {
-		errorPage(w, r, http.StatusBadRequest, "Invalid characters in the new discussions' main text field")
+	// Carry out the requested action, recording it in the audit log
+	var details string
+	var dbs []com.DBInfo
+	action := r.PostFormValue("action")
+	switch action {
+	case "suspend", "unsuspend", "resetquota", "logout":
+	case "delete":
+		// Clear the cached details of the users' projects before removing them
+		dbs, err = com.UserDBs(userName, com.DB_BOTH, com.SORT_UPDATED)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, "Database query failed")
+			return
+		}
+		for _, db := range dbs {
+			err = com.InvalidateCacheEntry(userName, userName, db.Folder, db.Database, "")
+			if err != nil {
+				com.Log.Errorf("Error when invalidating memcache entries: %s", err.Error())
+			}
+		}
+		details = fmt.Sprintf("%d project(s) removed", len(dbs))
+	default:
+		errorPage(w, r, http.StatusBadRequest, "Unknown action")
 		return
 	}
-	discText := txt
-
-	// Check if the requested database exists
-	exists, err := com.CheckFileExists(loggedInUser, owner, folder, fileName)
+	err = com.AdminUserAction(loggedInUser, action, userName, details)
 	if err != nil {
-		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		errorPage(w, r, http.StatusInternalServerError, fmt.Sprintf("The '%s' action failed: %v", action, err))
 		return
 	}
-	if !exists {
-		errorPage(w, r, http.StatusNotFound, fmt.Sprintf("Database '%s%s%s' doesn't exist", owner, folder,
-			fileName))
-		return
+	for _, db := range dbs {
+		err = com.UpdateSearchIndex(userName, db.Folder, db.Database)
+		if err != nil {
+			com.Log.Errorf("Error when updating the search index: %s", err.Error())
+		}
 	}
+	com.Log.Infof("Admin '%s' carried out action '%s' on user account '%s'", loggedInUser, action, userName)
 
-	// Add the discussion detail to PostgreSQL
-	id, err := com.StoreDiscussion(owner, folder, fileName, loggedInUser, discTitle, discText, com.DISCUSSION,
-		com.MergeRequestEntry{})
-	if err != nil {
-		errorPage(w, r, http.StatusInternalServerError, err.Error())
-		return
-	}
+	// Bounce back to the admin page
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
 
-	// Generate an event about the new discussion
-	details := com.EventDetails{
-		DBName:   fileName,
-		DiscID:   id,
-		Folder:   folder,
-		Owner:    owner,
-		Title:    discTitle,
-		Type:     com.EVENT_NEW_DISCUSSION,
-		URL:      fmt.Sprintf("/discuss/%s%s%s?id=%d", owner, folder, fileName, id),
-		UserName: loggedInUser,
-	}
-	err = com.NewEvent(details)
-	if err != nil {
-		log.Printf("Error when creating a new event: %s\n", err.Error())
+// Adds, removes, or tests a global webhook, recording it in the audit log.  Only available to site administrators.
+func adminWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
+
+	var target, details string
+	action := r.PostFormValue("action")
+	switch action {
+	case "add":
+		hookURL := strings.TrimSpace(r.PostFormValue("url"))
+		u, err := url.Parse(hookURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || len(hookURL) > 1024 {
+			errorPage(w, r, http.StatusBadRequest, "Invalid webhook URL")
+			return
+		}
+		r.ParseForm()
+		var events []string
+		for _, e := range r.PostForm["events"] {
+			if !com.KnownAdminWebhookEvent(e) {
+				errorPage(w, r, http.StatusBadRequest, "Unknown event")
+				return
+			}
+			events = append(events, e)
+		}
+		if len(events) == 0 {
+			errorPage(w, r, http.StatusBadRequest, "Choose at least one event for the webhook")
+			return
+		}
+		secret, err := com.NewAdminWebhookSecret()
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, "Generating the webhook secret failed")
+			return
+		}
+		id, err := com.AddAdminWebhook(loggedInUser, hookURL, events, secret)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, "Adding the webhook failed")
+			return
+		}
+		target, details = strconv.FormatInt(id, 10), hookURL+" ("+strings.Join(events, ", ")+")"
+	case "delete", "test":
+		id, err := strconv.ParseInt(r.PostFormValue("id"), 10, 64)
+		if err != nil {
+			errorPage(w, r, http.StatusBadRequest, "Invalid webhook ID")
+			return
+		}
+		if action == "delete" {
+			err = com.DeleteAdminWebhook(id)
+		} else {
+			err = com.TestAdminWebhook(id)
+		}
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+		target = strconv.FormatInt(id, 10)
+	default:
+		errorPage(w, r, http.StatusBadRequest, "Unknown action")
 		return
 	}
 
-	// Invalidate the memcache data for the database, so the new discussion count gets picked up
-	err = com.InvalidateCacheEntry(loggedInUser, owner, folder, fileName, "") // Empty string indicates "for all versions"
+	// Record the action in the audit log
+	err := com.AddAuditLogEntry(loggedInUser, action+"webhook", target, details)
 	if err != nil {
-		// Something went wrong when invalidating memcached entries for the database
-		log.Printf("Error when invalidating memcache entries: %s\n", err.Error())
+		errorPage(w, r, http.StatusInternalServerError, "The webhook was changed, but recording it in the audit "+
+			"log failed")
 		return
 	}
 
-	// Bounce to the discussions page
-	http.Redirect(w, r, fmt.Sprintf("/discuss/%s%s%s?id=%d", owner, folder, fileName, id), http.StatusSeeOther)
+	// Bounce back to the admin page
+	http.Redirect(w, r, "/admin#webhooks", http.StatusSeeOther)
 }
 
-// Receives incoming requests from the merge request creation page, creating them if the info is correct
-func createMergeHandler(w http.ResponseWriter, r *http.Request) {
-	// Retrieve session data (if any)
-	var loggedInUser string
-	var u interface{}
-	validSession := false
-	if com.Conf.Environment.Environment != "docker" {
-		sess, err := store.Get(r, "3dhub-user")
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
-		u = sess.Values["UserName"]
-	} else {
-		u = "default"
-	}
-	if u != nil {
-		loggedInUser = u.(string)
-		validSession = true
-	}
+// Archives or unarchives a project, from its settings page.  Archived projects are read-only, so no new versions,
+// discussions, merge requests, or comments can be added to them.  Nothing is deleted.
+func archiveHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
 
 	// Ensure we have a valid logged in user
-	if validSession != true {
-		w.WriteHeader(http.StatusUnauthorized)
-		fmt.Fprint(w, "You need to be logged in")
+	if loggedInUser == "" {
+		errorPage(w, r, http.StatusUnauthorized, "You need to be logged in")
 		return
 	}
 
-	// Extract and validate the form variables
-	userName, err := com.GetUsername(r, false)
+	// Only the owner of a project can archive it
+	owner, folder, fileName, err := com.GetUFD(r, false)
 	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		fmt.Fprint(w, err.Error())
+		errorPage(w, r, http.StatusBadRequest, err.Error())
 		return
 	}
-	if userName == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprint(w, "Missing username in supplied fields")
+	if strings.ToLower(owner) != strings.ToLower(loggedInUser) {
+		errorPage(w, r, http.StatusForbidden, "Only the owner of a project can archive it")
 		return
 	}
-
-	// Retrieve source owner
-	o := r.PostFormValue("sourceowner")
-	srcOwner, err := url.QueryUnescape(o)
+	exists, err := com.CheckFileExists(loggedInUser, owner, folder, fileName)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, err.Error())
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
-	err = com.ValidateUser(srcOwner)
-	if err != nil {
-		log.Printf("Validation failed for username: '%s'- %s", srcOwner, err)
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprint(w, err.Error())
+	if !exists {
+		errorPage(w, r, http.StatusNotFound, "That project doesn't exist")
 		return
 	}
 
-	// Retrieve source folder
-	f := r.PostFormValue("sourcefolder")
-	srcFolder, err := url.QueryUnescape(f)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, err.Error())
+	var archive bool
+	switch r.PostFormValue("action") {
+	case "archive":
+		archive = true
+	case "unarchive":
+		archive = false
+	default:
+		errorPage(w, r, http.StatusBadRequest, "Unknown action")
 		return
 	}
-	err = com.ValidateFolder(srcFolder)
+	err = com.SetProjectArchived(owner, folder, fileName, archive)
 	if err != nil {
-		log.Printf("Validation failed for folder: '%s' - %s", srcFolder, err)
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprint(w, err.Error())
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	// Retrieve source database name
-	d := r.PostFormValue("sourcedbname")
-	srcDBName, err := url.QueryUnescape(d)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, err.Error())
-		return
-	}
-	err = com.ValidateFileName(srcDBName)
+	// Invalidate the old memcached entry for the project
+	err = com.InvalidateCacheEntry(loggedInUser, owner, folder, fileName, "")
 	if err != nil {
-		log.Printf("Validation failed for database name '%s': %s", srcDBName, err)
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprint(w, err.Error())
-		return
+		// Something went wrong when invalidating memcached entries for the project
+		com.Log.Infof("Error when invalidating memcache entries: %s", err.Error())
 	}
 
-	// Retrieve source branch name
-	a := r.PostFormValue("sourcebranch")
-	srcBranch, err := url.QueryUnescape(a)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, err.Error())
+	// Return to the project page
+	http.Redirect(w, r, com.ProjectPath(owner, folder, fileName), http.StatusSeeOther)
+}
+
+// auth0CallbackHandler is called at the end of the Auth0 authentication process, whether successful or not.
+// If the authentication process was successful:
+//  * if the user already has an account on our system then this function creates a login session for them.
+//  * if the user doesn't yet have an account on our system, they're bounced to the username selection page.
+// If the authentication process wasn't successful, an error message is displayed.
+func auth0CallbackHandler(w http.ResponseWriter, r *http.Request) {
+	// Auth0 login part, mostly copied from https://github.com/auth0-samples/auth0-golang-web-app (MIT License)
+	conf := auth0Config()
+	code := r.URL.Query().Get("code")
+	if code == "" {
+		com.Log.Errorf("Login failure from '%v', probably due to blocked 3rd party cookies", r.RemoteAddr)
+		errorPage(w, r, http.StatusInternalServerError,
+			"Login failure.  Please allow 3rd party cookies from https://dbhub.eu.auth0.com then try again (it should then work).")
 		return
 	}
-	err = com.ValidateBranchName(srcBranch)
+	token, err := conf.Exchange(oauth2.NoContext, code)
 	if err != nil {
-		log.Printf("Validation failed for branch name '%s': %s", srcBranch, err)
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprint(w, err.Error())
+		com.Log.Errorf("Login failure: %s", err.Error())
+		errorPage(w, r, http.StatusInternalServerError, "Login failed")
 		return
 	}
 
-	// Retrieve destination owner
-	o = r.PostFormValue("destowner")
-	destOwner, err := url.QueryUnescape(o)
+	// Retrieve the user info (JSON format)
+	conn := conf.Client(oauth2.NoContext, token)
+	userInfo, err := conn.Get("https://" + com.Conf().Auth0.Domain + "/userinfo")
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, err.Error())
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
-	err = com.ValidateUser(destOwner)
+	raw, err := ioutil.ReadAll(userInfo.Body)
+	defer userInfo.Body.Close()
 	if err != nil {
-		log.Printf("Validation failed for username: '%s'- %s", destOwner, err)
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprint(w, err.Error())
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	// Retrieve destination folder
-	f = r.PostFormValue("destfolder")
-	destFolder, err := url.QueryUnescape(f)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, err.Error())
+	// Convert the JSON into something usable
+	var profile map[string]interface{}
+	if err = json.Unmarshal(raw, &profile); err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
-	err = com.ValidateFolder(destFolder)
-	if err != nil {
-		log.Printf("Validation failed for folder: '%s' - %s", destFolder, err)
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprint(w, err.Error())
+
+	// Extract the basic user info we use
+	var auth0Conn, auth0ID, avatarURL, email, nickName string
+	em := profile["email"]
+	if em != nil {
+		email = em.(string)
+	}
+	au := profile["user_id"]
+	if au != nil {
+		auth0ID = au.(string)
+	}
+	if auth0ID == "" {
+		com.Log.Errorf("Auth0 callback error: Auth0 ID string was empty. Email: %s", email)
+		errorPage(w, r, http.StatusInternalServerError, "Error: Auth0 ID string was empty")
 		return
 	}
+	ni := profile["nickname"]
+	if ni != nil {
+		nickName = ni.(string)
+	}
 
-	// Retrieve destination database name
-	d = r.PostFormValue("destdbname")
-	destDBName, err := url.QueryUnescape(d)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, err.Error())
-		return
+	// Determine if the user has a profile pic we can use
+	var i map[string]interface{}
+	if profile["identities"] != nil {
+		i = profile["identities"].([]interface{})[0].(map[string]interface{})
 	}
-	err = com.ValidateFileName(destDBName)
-	if err != nil {
-		log.Printf("Validation failed for database name '%s': %s", destDBName, err)
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprint(w, err.Error())
-		return
+	co, ok := i["connection"]
+	if ok {
+		auth0Conn = co.(string)
+	}
+	if auth0Conn != "Test2DB" { // The Auth0 fallback profile pic's seem pretty lousy, so avoid those
+		p, ok := profile["picture"]
+		if ok && p.(string) != "" {
+			avatarURL = p.(string)
+		}
 	}
 
-	// Retrieve destination branch name
-	a = r.PostFormValue("destbranch")
-	destBranch, err := url.QueryUnescape(a)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, err.Error())
+	// If the user has an unverified email address, tell them to verify it before proceeding
+	ve := profile["email_verified"]
+	if ve != nil && ve.(bool) != true {
+		// TODO: Create a nicer notice page for this, as errorPage() doesn't look friendly
+		errorPage(w, r, http.StatusUnauthorized, "Please check your email.  You need to verify your "+
+			"email address before logging in will work.")
 		return
 	}
-	err = com.ValidateBranchName(destBranch)
+
+	// Determine the 3DHub.io username matching the given Auth0 ID
+	userName, err := com.UserNameFromAuth0ID(auth0ID)
 	if err != nil {
-		log.Printf("Validation failed for branch name '%s': %s", destBranch, err)
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprint(w, err.Error())
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	// Validate the MR title
-	tl := r.PostFormValue("title")
-	if tl == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprint(w, "Title can't be blank")
-		return
-	}
-	title, err := url.QueryUnescape(tl)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, err.Error())
+	// If the user doesn't already exist, we need to create an account for them
+	if userName == "" {
+		if email != "" {
+			// Check if the email address is already in our system
+			exists, err := com.CheckEmailExists(email)
+			if err != nil {
+				errorPage(w, r, http.StatusInternalServerError, "Email check failed.  Can't continue.")
+				return
+			}
+			if exists {
+				errorPage(w, r, http.StatusConflict,
+					"Can't create new account: Your email address is already associated "+
+						"with a different account in our system.")
+				return
+			}
+		}
+
+		// Create a special session cookie, purely for the registration page
+		sess, err := store.Get(r, "user-reg")
+		if err != nil {
+			if err == memcache.ErrCacheMiss {
+				// Seems like a stale session token, so delete the session and reload the page
+				sess.Options.MaxAge = -1
+				err = sess.Save(r, w)
+				if err != nil {
+					errorPage(w, r, http.StatusInternalServerError, err.Error())
+					return
+				}
+				http.Redirect(w, r, "/selectusername", http.StatusTemporaryRedirect)
+				return
+			}
+			errorPage(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		sess.Values["registrationinprogress"] = true
+		sess.Values["auth0id"] = auth0ID
+		sess.Values["avatar"] = avatarURL
+		sess.Values["email"] = email
+		sess.Values["nickname"] = nickName
+		err = sess.Save(r, w)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		// Bounce to a new page, for the user to select their preferred username
+		http.Redirect(w, r, "/selectusername", http.StatusSeeOther)
 		return
 	}
-	err = com.ValidateDiscussionTitle(title)
+
+	// Suspended accounts can't log in
+	suspended, _, err := com.UserAccountStatus(userName)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprint(w, "Invalid characters in the merge request title")
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
-
-	// Validate the MR description
-	t := r.PostFormValue("desc")
-	if t == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprint(w, "Merge request description can't be empty")
+	if suspended {
+		errorPage(w, r, http.StatusForbidden, "This account has been suspended")
 		return
 	}
-	descrip, err := url.QueryUnescape(t)
+
+	// If Auth0 provided a picture URL for the user, check if it's different to what we already have (eg it may have
+	// been updated)
+	if avatarURL != "" {
+		usr, err := com.User(userName)
+		if err != nil {
+			errorPage(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		if usr.AvatarURL != avatarURL {
+			// The Auth0 provided pic URL is different to what we have already, so we update the database with the new
+			// value
+			err = com.UpdateAvatarURL(userName, avatarURL)
+			if err != nil {
+				errorPage(w, r, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+	}
+
+	// Note the user is still around, so their account isn't treated as inactive
+	recordActivity(userName)
+
+	// Keep our copy of the user's email address up to date, if they've changed it at Auth0
+	if email != "" {
+		changed, err := com.SyncAuth0Email(userName, email)
+		if err == nil && changed {
+			com.Log.Infof("Email address for user '%s' changed to '%s', to match Auth0", userName, email)
+		}
+	}
+
+	// Create a session cookie for the user.  Logging in confirms who they are, so destructive operations are allowed
+	// for a little while
+	sess, err := store.Get(r, "3dhub-user")
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, err.Error())
+		errorPage(w, r, http.StatusBadRequest, err.Error())
 		return
 	}
-	err = com.Validate.Var(title, "markdownsource")
+
+	// If this was the user logging in again from the step up page, return them to where they were
+	returnTo := "/" + userName
+	if state, ok := sess.Values["StepUpState"].(string); ok && state != "" && state == r.URL.Query().Get("state") {
+		if u, ok := sess.Values["UserName"].(string); ok && strings.ToLower(u) == strings.ToLower(userName) {
+			if ret, ok := sess.Values["StepUpReturn"].(string); ok && ret != "" {
+				returnTo = ret
+			}
+		}
+	}
+	delete(sess.Values, "StepUpState")
+	delete(sess.Values, "StepUpReturn")
+
+	sess.Values["UserName"] = userName
+	sess.Values["LoginTime"] = time.Now().Unix()
+	sess.Values["LastSeen"] = time.Now().Unix()
+	sess.Values["ElevatedUntil"] = time.Now().Add(stepUpWindow).Unix()
+	sess.Values["Locale"] = com.PrefUserLocale(userName)
+	sess.Values["Theme"] = string(com.PrefUserTheme(userName))
+	sess.Values["TimeZone"], sess.Values["DateFormat"] = com.PrefUserDates(userName)
+	sess.Save(r, w)
 	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Login completed, so bounce to the users' profile page (or back to the page they were confirming for)
+	http.Redirect(w, r, returnTo, http.StatusSeeOther)
+}
+
+// Returns the OAuth2 details for logging in with Auth0.
+func auth0Config() *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     com.Conf().Auth0.ClientID,
+		ClientSecret: com.Conf().Auth0.ClientSecret,
+		RedirectURL:  "https://" + com.Conf().Web.ServerName + "/x/callback",
+		Scopes:       []string{"openid", "profile"},
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  "https://" + com.Conf().Auth0.Domain + "/authorize",
+			TokenURL: "https://" + com.Conf().Auth0.Domain + "/oauth/token",
+		},
+	}
+}
+
+// Returns a list of the branches present in a database
+func branchNamesHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
+
+	// Ensure we have a valid logged in user
+	if loggedInUser == "" {
 		w.WriteHeader(http.StatusUnauthorized)
-		fmt.Fprint(w, "Invalid characters in the description field")
 		return
 	}
 
-	// Make sure none of the required fields is empty
-	if srcOwner == "" || srcFolder == "" || srcDBName == "" || srcBranch == "" || destOwner == "" || destFolder ==
-		"" || destDBName == "" || destBranch == "" || title == "" || descrip == "" {
+	// Extract the required form variables
+	usr, folder, fileName, err := com.GetUFD(r, true)
+	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprint(w, "Some of the (required) supplied fields are empty")
 		return
 	}
+	owner := strings.ToLower(usr)
 
-	// Check the databases exist
-	srcExists, err := com.CheckFileExists(loggedInUser, srcOwner, srcFolder, srcDBName)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, err.Error())
+	// If any of the required values were empty, indicate failure
+	if owner == "" || folder == "" || fileName == "" {
+		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	destExists, err := com.CheckFileExists(loggedInUser, destOwner, destFolder, destDBName)
-	if err != nil {
+
+	// Make sure the database exists in the system
+	exists, err := com.CheckFileExists(loggedInUser, owner, folder, fileName)
+	if err != err {
 		w.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprint(w, err.Error())
 		return
 	}
-	if !srcExists || !destExists {
+	if !exists {
+		com.Log.Errorf("%s: Validation failed for database name: %s", com.GetCurrentFunctionName(), err)
 		w.WriteHeader(http.StatusNotFound)
-		fmt.Fprint(w, "Invalid database.  One of the source or destination databases doesn't exist")
 		return
 	}
 
-	// Get the details of the commits for the MR
-	mrDetails := com.MergeRequestEntry{
-		DestBranch:   destBranch,
-		SourceBranch: srcBranch,
-		SourceDBName: srcDBName,
-		SourceFolder: srcFolder,
-		SourceOwner:  srcOwner,
-	}
-	var ancestorID string
-	ancestorID, mrDetails.Commits, err, _ = com.GetCommonAncestorCommits(srcOwner, srcFolder, srcDBName, srcBranch,
-		destOwner, destFolder, destDBName, destBranch)
+	// Retrieve the branch info for the database
+	branchList, err := com.GetBranches(owner, folder, fileName)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprint(w, err.Error())
 		return
 	}
-
-	// Make sure the source branch will cleanly apply to the destination.  eg the destination branch hasn't received
-	// additional commits since the source was forked
-	if ancestorID == "" {
-		w.WriteHeader(http.StatusConflict)
-		fmt.Fprint(w, "Source branch is not a direct descendent of the destination branch.  Cannot merge.")
-		return
-	}
-
-	// Create the merge request in PostgreSQL
-	var x struct {
-		ID int `json:"mr_id"`
-	}
-	x.ID, err = com.StoreDiscussion(destOwner, destFolder, destDBName, loggedInUser, title, descrip, com.MERGE_REQUEST,
-		mrDetails)
+	defBranch, err := com.GetDefaultBranchName(owner, folder, fileName)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprint(w, err.Error())
 		return
 	}
 
-	// Generate an event about the new merge request
-	details := com.EventDetails{
-		DBName:   destDBName,
-		DiscID:   x.ID,
-		Folder:   destFolder,
-		Owner:    destOwner,
-		Title:    title,
-		Type:     com.EVENT_NEW_MERGE_REQUEST,
-		URL:      fmt.Sprintf("/merge/%s%s%s?id=%d", destOwner, destFolder, destDBName, x.ID),
-		UserName: loggedInUser,
+	// Prepare the branch list for sending
+	var b struct {
+		Branches      []string `json:"branches"`
+		DefaultBranch string   `json:"default_branch"`
 	}
-	err = com.NewEvent(details)
-	if err != nil {
-		log.Printf("Error when creating a new event: %s\n", err.Error())
-		return
+	for name := range branchList {
+		b.Branches = append(b.Branches, name)
 	}
-
-	// Invalidate the memcache data for the destination database, so the new MR count gets picked up
-	err = com.InvalidateCacheEntry(loggedInUser, destOwner, destFolder, destDBName, "") // Empty string indicates "for all versions"
+	b.DefaultBranch = defBranch
+	data, err := json.MarshalIndent(b, "", " ")
 	if err != nil {
-		// Something went wrong when invalidating memcached entries for the database
-		log.Printf("Error when invalidating memcache entries: %s\n", err.Error())
+		com.Log.Error(err)
 		return
 	}
 
-	// Indicate success to the caller, and return the ID # of the new merge request
-	y, err := json.MarshalIndent(x, "", " ")
-	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, err.Error())
+	// If the client already has this branch list, there's no need to send it again
+	if com.NotModified(w, r, com.ContentETag(data), time.Time{}) {
 		return
 	}
+
+	// Return the branch list
 	w.WriteHeader(http.StatusOK)
-	fmt.Fprint(w, string(y))
+	fmt.Fprint(w, string(data))
 }
 
-func createTagHandler(w http.ResponseWriter, r *http.Request) {
-	// Retrieve session data (if any)
-	var loggedInUser string
-	var u interface{}
-	validSession := false
-	if com.Conf.Environment.Environment != "docker" {
-		sess, err := store.Get(r, "3dhub-user")
-		if err != nil {
-			errorPage(w, r, http.StatusBadRequest, err.Error())
-			return
-		}
-		u = sess.Values["UserName"]
-	} else {
-		u = "default"
+// Cancels the logged in user's change of email address, from their preferences page.
+func cancelEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
+
+	// Ensure we have a valid logged in user
+	if loggedInUser == "" {
+		errorPage(w, r, http.StatusUnauthorized, "You need to be logged in")
+		return
 	}
-	if u != nil {
-		loggedInUser = u.(string)
-		validSession = true
+	err := com.CancelEmailChange(loggedInUser)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Cancelling the email address change failed")
+		return
 	}
+	http.Redirect(w, r, "/pref#email", http.StatusSeeOther)
+}
+
+// Cancels the scheduled publishing of a project or release.  Releases are dropped, while projects stay private.
+func cancelScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
 
 	// Ensure we have a valid logged in user
-	if validSession != true {
+	if loggedInUser == "" {
 		errorPage(w, r, http.StatusUnauthorized, "You need to be logged in")
 		return
 	}
 
-	// Extract and validate the form variables
-	owner, fileName, commit, err := com.GetFormUDC(r)
+	// Extract the required form variables
+	usr, folder, fileName, err := com.GetUFD(r, false)
 	if err != nil {
-		errorPage(w, r, http.StatusBadRequest, "Missing or incorrect data supplied")
+		errorPage(w, r, http.StatusBadRequest, err.Error())
 		return
 	}
-	tagName, err := com.GetFormTag(r)
+	owner := strings.ToLower(usr)
+	relName, err := com.GetFormRelease(r)
 	if err != nil {
-		errorPage(w, r, http.StatusBadRequest, "Missing or incorrect tag name")
+		errorPage(w, r, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	// If given, validate the tag description field
-	td := r.PostFormValue("tagdesc") // Optional
-	var tagDesc string
-	if td != "" {
-		err = com.Validate.Var(td, "markdownsource")
-		if err != nil {
-			errorPage(w, r, http.StatusBadRequest, "Invalid characters in tag description")
-			return
-		}
-		tagDesc = td
-	}
-
-	// Validate the tag type field
-	tagType := r.PostFormValue("tagtype")
-	if tagType != "tag" && tagType != "release" {
-		errorPage(w, r, http.StatusBadRequest, "Unknown tag type")
+	// Only the owner can change when their projects are published
+	if owner != strings.ToLower(loggedInUser) {
+		errorPage(w, r, http.StatusForbidden, "You can only change the schedule of your own projects")
 		return
 	}
-
-	// Check if the requested database exists
-	folder := "/"
-	exists, err := com.CheckFileExists(loggedInUser, owner, folder, fileName)
+	err = com.DeleteScheduledPublication(loggedInUser, folder, fileName, relName)
 	if err != nil {
-		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		errorPage(w, r, http.StatusInternalServerError, "Cancelling the scheduled publishing failed")
 		return
 	}
-	if !exists {
-		errorPage(w, r, http.StatusNotFound, fmt.Sprintf("Database '%s%s%s' doesn't exist", owner, folder,
-			fileName))
+	if relName != "" {
+		http.Redirect(w, r, "/releases"+com.ProjectPath(loggedInUser, folder, fileName), http.StatusSeeOther)
 		return
 	}
+	http.Redirect(w, r, "/settings"+com.ProjectPath(loggedInUser, folder, fileName), http.StatusSeeOther)
+}
 
-	// Make sure the database owner matches the logged in user
-	if strings.ToLower(loggedInUser) != strings.ToLower(owner) {
-		errorPage(w, r, http.StatusUnauthorized, "You can't change databases you don't own")
+// Saves or removes one of a user's project templates, from the POST data of a form.  Returns the action taken and the
+// name of the template, or the HTTP status code and error to show.
+func changeProjectTemplate(r *http.Request, userName string) (action string, name string, status int, err error) {
+	action = r.PostFormValue("action")
+	switch action {
+	case "delete":
+		name = r.PostFormValue("templatename")
+		err = com.ValidateTemplateName(name)
+		if err != nil {
+			return action, name, http.StatusBadRequest, fmt.Errorf("Invalid template name")
+		}
+		err = com.DeleteProjectTemplate(userName, name)
+		if err != nil {
+			return action, name, http.StatusBadRequest, err
+		}
+	case "save":
+		t, err := com.GetFormProjectTemplate(r)
+		if err != nil {
+			return action, t.Name, http.StatusBadRequest, err
+		}
+		if t.Licence != "" {
+			exists, err := com.CheckLicenceExists(userName, t.Licence)
+			if err != nil {
+				return action, t.Name, http.StatusInternalServerError, err
+			}
+			if !exists {
+				return action, t.Name, http.StatusBadRequest, fmt.Errorf("Unknown licence")
+			}
+		}
+		if t.Category != 0 {
+			cats, err := com.Categories()
+			if err != nil {
+				return action, t.Name, http.StatusInternalServerError, err
+			}
+			found := false
+			for _, c := range cats {
+				if c.ID == t.Category {
+					found = true
+				}
+			}
+			if !found {
+				return action, t.Name, http.StatusBadRequest, fmt.Errorf("Unknown category")
+			}
+		}
+		err = com.StoreProjectTemplate(userName, t)
+		if err != nil {
+			return action, t.Name, http.StatusInternalServerError, fmt.Errorf("Saving the project template failed")
+		}
+		name = t.Name
+	default:
+		return action, name, http.StatusBadRequest, fmt.Errorf("Unknown action")
+	}
+	return action, name, http.StatusOK, nil
+}
+
+// Returns the CHECKSUMS file for a release of a project, or for a commit if no release is given.  It holds the MD5,
+// SHA1, SHA256, and BLAKE3 checksums of the file, in the format "sha256sum -c" and friends can check.
+func checksumsHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
+
+	// Extract the owner and database name
+	// TODO: Add folder support
+	owner, fileName, err := com.GetOD(2, r) // 2 = Ignore "/x/checksums/" at the start of the URL
+	if err != nil || owner == "" || fileName == "" {
+		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
+	folder := "/"
 
-	// Retrieve the user details
-	usr, err := com.User(loggedInUser)
+	// Make sure the project exists in the system, and the user has access to it
+	exists, err := com.CheckFileExists(loggedInUser, owner, folder, fileName)
 	if err != nil {
-		errorPage(w, r, http.StatusInternalServerError, "An error occurred when retrieving user details")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		return
 	}
 
-	// Create a new tag or release as appropriate
-	if tagType == "release" {
-		// * It's a release *
-
-		// Read the releases list from the database
-		rels, err := com.GetReleases(owner, folder, fileName)
+	// Work out which commit is wanted.  Releases are looked up by name, and commits need to be in the project
+	commitID := r.FormValue("commit")
+	if relName := r.FormValue("release"); relName != "" {
+		releases, err := com.GetReleases(owner, folder, fileName)
 		if err != nil {
-			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-
-		// Ensure the release doesn't already exist
-		if _, ok := rels[tagName]; ok {
-			errorPage(w, r, http.StatusConflict, "A release of that name already exists!")
+		rel, ok := releases[relName]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, "Unknown release")
 			return
 		}
-
-		// Retrieve the size of the database for this release
-		var tmp com.SQLiteDBinfo
-		err = com.DBDetails(&tmp, loggedInUser, owner, folder, fileName, commit)
-		if err != nil {
-			errorPage(w, r, http.StatusInternalServerError, err.Error())
+		commitID = rel.Commit
+	} else if commitID != "" {
+		if com.ValidateCommitID(commitID) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, "Invalid commit ID")
 			return
 		}
-		size := tmp.Info.DBEntry.Size
-
-		// Create the release
-		newRel := com.ReleaseEntry{
-			Commit:        commit,
-			Date:          time.Now(),
-			Description:   tagDesc,
-			ReleaserEmail: usr.Email,
-			ReleaserName:  usr.DisplayName,
-			Size:          size,
-		}
-		rels[tagName] = newRel
-
-		// Store it in PostgreSQL
-		err = com.StoreReleases(owner, folder, fileName, rels)
+		commits, err := com.GetCommitList(owner, folder, fileName)
 		if err != nil {
-			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-
-		// Invalidate the memcache data for the database
-		err = com.InvalidateCacheEntry(loggedInUser, owner, folder, fileName, "") // Empty string indicates "for all versions"
-		if err != nil {
-			// Something went wrong when invalidating memcached entries for the database
-			log.Printf("Error when invalidating memcache entries: %s\n", err.Error())
+		if _, ok := commits[commitID]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, "Unknown commit")
 			return
 		}
-
-		// Bounce to the releases page
-		http.Redirect(w, r, fmt.Sprintf("/releases/%s%s%s", loggedInUser, folder, fileName), http.StatusSeeOther)
-		return
 	}
 
-	// * It's a tag *
-
-	// Read the tags list from the database
-	tags, err := com.GetTags(owner, folder, fileName)
+	bucket, id, lastModified, err := com.MinioLocation(owner, folder, fileName, commitID, loggedInUser)
 	if err != nil {
-		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-
-	// Make sure the tag doesn't already exist
-	if _, ok := tags[tagName]; ok {
-		errorPage(w, r, http.StatusConflict, "A tag of that name already exists!")
+	if com.NotModified(w, r, fmt.Sprintf(`"checksums-%s"`, bucket+id), lastModified) {
+		return
+	}
+	sums, err := com.ObjectChecksumsFor(bucket + id)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	w.Header().Set("Content-Disposition", `attachment; filename="CHECKSUMS"`)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(com.ChecksumsFile([]string{fileName}, []com.ObjectChecksums{sums}))
+}
 
-	// Create the tag
-	newTag := com.TagEntry{
-		Commit:      commit,
-		Date:        time.Now(),
-		Description: tagDesc,
-		TaggerEmail: usr.Email,
-		TaggerName:  usr.DisplayName,
+func createBranchHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
+
+	// Ensure we have a valid logged in user
+	if loggedInUser == "" {
+		errorPage(w, r, http.StatusUnauthorized, "You need to be logged in")
+		return
 	}
-	tags[tagName] = newTag
 
-	// Store it in PostgreSQL
-	err = com.StoreTags(owner, folder, fileName, tags)
+	// Extract and validate the form variables
+	owner, fileName, commit, err := com.GetFormUDC(r)
 	if err != nil {
-		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		errorPage(w, r, http.StatusBadRequest, "Missing or incorrect data supplied")
 		return
 	}
-
-	// Invalidate the memcache data for the database, so the new tag count gets picked up
-	err = com.InvalidateCacheEntry(loggedInUser, owner, folder, fileName, "") // Empty string indicates "for all versions"
+	branchName, err := com.GetFormBranch(r)
 	if err != nil {
-		// Something went wrong when invalidating memcached entries for the database
-		log.Printf("Error when invalidating memcache entries: %s\n", err.Error())
+		errorPage(w, r, http.StatusBadRequest, "Missing or incorrect branch name")
 		return
 	}
+	bd := r.PostFormValue("branchdesc") // Optional
 
-	// Bounce to the tags page
-	http.Redirect(w, r, fmt.Sprintf("/tags/%s%s%s", loggedInUser, folder, fileName), http.StatusSeeOther)
-}
+	// If given, validate the branch description field
+	var branchDesc string
+	if bd != "" {
+		err = com.Validate.Var(bd, "markdownsource")
+		if err != nil {
+			errorPage(w, r, http.StatusBadRequest, "Invalid characters in branch description")
+			return
+		}
+		branchDesc = bd
+	}
 
-func createUserHandler(w http.ResponseWriter, r *http.Request) {
-	// Make sure this user creation session is valid
-	sess, err := store.Get(r, "user-reg")
+	// Check if the requested database exists
+	folder := "/"
+	exists, err := com.CheckFileExists(loggedInUser, owner, folder, fileName)
 	if err != nil {
-		errorPage(w, r, http.StatusBadRequest, err.Error())
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
-	validRegSession := false
-	va := sess.Values["registrationinprogress"]
-	if va == nil {
-		// This isn't a valid username selection session, so abort
-		errorPage(w, r, http.StatusBadRequest, "Invalid user creation session")
+	if !exists {
+		errorPage(w, r, http.StatusNotFound, fmt.Sprintf("Database '%s%s%s' doesn't exist", owner, folder,
+			fileName))
 		return
 	}
-	validRegSession = va.(bool)
-	if validRegSession != true {
-		// This isn't a valid username selection session, so abort
-		errorPage(w, r, http.StatusBadRequest, "Invalid user creation session")
+
+	// Make sure the database owner matches the logged in user
+	if strings.ToLower(loggedInUser) != strings.ToLower(owner) {
+		errorPage(w, r, http.StatusUnauthorized, "You can't change databases you don't own")
 		return
 	}
 
-	// Retrieve the registration data
-	var auth0ID, avatarURL, email, displayName string
-	au := sess.Values["auth0id"]
-	if au != nil {
-		auth0ID = au.(string)
-	} else {
-		errorPage(w, r, http.StatusBadRequest, "Invalid user creation id")
+	// Read the branch heads list from the database
+	branches, err := com.GetBranches(owner, folder, fileName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
-	av, ok := sess.Values["avatar"]
+
+	// Make sure the branch name doesn't already exist
+	_, ok := branches[branchName]
 	if ok {
-		avatarURL = av.(string)
-	}
-	em := sess.Values["email"]
-	if em != nil {
-		email = em.(string)
-	} else {
-		errorPage(w, r, http.StatusBadRequest, "Invalid user creation email")
+		errorPage(w, r, http.StatusConflict, "A branch of that name already exists!")
 		return
 	}
 
-	// Gather submitted form data (if any)
-	err = r.ParseForm()
+	// Count the number of commits in the new branch
+	commitList, err := com.GetCommitList(owner, folder, fileName)
 	if err != nil {
-		log.Printf("Error when parsing user creation data: %s\n", err)
-		errorPage(w, r, http.StatusBadRequest, "Error when parsing user creation data")
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
-	userName := r.PostFormValue("username")
-
-	// Ensure username was given
-	if userName == "" {
-		// No, so render the username selection page
-		selectUserNamePage(w, r)
+	c, ok := commitList[commit]
+	if !ok {
+		errorPage(w, r, http.StatusBadRequest, fmt.Sprint("The given commit ID doesn't exist"))
 		return
 	}
-
-	// Validate the user supplied username
-	err = com.ValidateUser(userName)
-	if err != nil {
-		log.Printf("Username failed validation: %s", err)
-
-		// Note : gorilla/sessions uses MaxAge < 0 to mean "delete this session"
-		sess.Options.MaxAge = -1
-		err = sess.Save(r, w)
-		if err != nil {
-			errorPage(w, r, http.StatusInternalServerError, err.Error()+" Username failed validation")
+	commitCount := 1
+	for c.Parent != "" {
+		commitCount++
+		c, ok = commitList[c.Parent]
+		if !ok {
+			com.Log.Errorf("Error when counting commits in new branch '%s' of database '%s%s%s'", branchName,
+				owner, folder, fileName)
 			return
 		}
+	}
 
-		// Alert the user to the validation problem
-		errorPage(w, r, http.StatusInternalServerError, "Username failed validation")
+	// Create the branch
+	newBranch := com.BranchEntry{
+		Commit:      commit,
+		CommitCount: commitCount,
+		Description: branchDesc,
+	}
+	branches[branchName] = newBranch
+	err = com.StoreBranches(owner, folder, fileName, branches)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	// Ensure the username isn't a reserved one
-	err = com.ReservedUsernamesCheck(userName)
+	// Invalidate the memcache data for the database, so the new branch count gets picked up
+	err = com.InvalidateCacheEntry(loggedInUser, owner, folder, fileName, "") // Empty string indicates "for all versions"
 	if err != nil {
-		log.Println(err)
+		// Something went wrong when invalidating memcached entries for the database
+		com.Log.Errorf("Error when invalidating memcache entries: %s", err.Error())
+		return
+	}
 
-		// Note : gorilla/sessions uses MaxAge < 0 to mean "delete this session"
-		sess.Options.MaxAge = -1
-		err2 := sess.Save(r, w)
-		if err2 != nil {
-			// Try to display both errors
-			errorPage(w, r, http.StatusInternalServerError, err2.Error()+" "+err.Error())
-			return
-		}
+	// Bounce to the branches page
+	http.Redirect(w, r, "/branches"+com.ProjectPath(loggedInUser, folder, fileName), http.StatusSeeOther)
+}
 
-		// Alert the user to the ReservedUsernamesCheck() failure
-		errorPage(w, r, http.StatusBadRequest, err.Error())
+// Receives incoming info for adding a comment to an existing discussion
+func createCommentHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
+
+	// Ensure we have a valid logged in user
+	if loggedInUser == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, "You need to be logged in")
 		return
 	}
 
-	// Check if the username is already in our system
-	exists, err := com.CheckUserExists(userName)
+	// Extract and validate the form variables
+	owner, folder, fileName, err := com.GetUFD(r, false)
 	if err != nil {
-		// Note : gorilla/sessions uses MaxAge < 0 to mean "delete this session"
-		sess.Options.MaxAge = -1
-		err = sess.Save(r, w)
-		if err != nil {
-			errorPage(w, r, http.StatusInternalServerError, err.Error()+" Username check failed")
-			return
-		}
-
-		// Alert the username check failure
-		errorPage(w, r, http.StatusInternalServerError, "Username check failed")
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Missing or incorrect data supplied")
 		return
 	}
-	if exists {
-		// Note : gorilla/sessions uses MaxAge < 0 to mean "delete this session"
-		sess.Options.MaxAge = -1
-		err = sess.Save(r, w)
-		if err != nil {
-			errorPage(w, r, http.StatusInternalServerError, err.Error()+" That username is already taken")
-			return
-		}
 
-		// Let the user know their desired username is not available
-		errorPage(w, r, http.StatusConflict, "That username is already taken")
+	// Ensure a discussion ID was given
+	a := r.PostFormValue("discid")
+	if a == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Missing discussion id")
+		return
+	}
+	discID, err := strconv.Atoi(a)
+	if err != nil {
+		com.Log.Errorf("Error converting string '%s' to integer in function '%s': %s", a,
+			com.GetCurrentFunctionName(), err)
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Error when parsing discussion id value")
 		return
 	}
 
-	// If present, validate the users' full name
-	if displayName != "" {
-		err = com.Validate.Var(displayName, "required,displayname,min=1,max=80")
-		if err != nil {
-			log.Printf("Display name value failed validation: %s\n", err)
-			errorPage(w, r, http.StatusBadRequest, "Error when parsing full name value")
-			return
-		}
+	// Check if the discussion should also be closed or reopened
+	discClose := false
+	c := r.PostFormValue("close")
+	if c == "true" {
+		discClose = true
 	}
 
-	// If present, validate the email address
-	if email != "" {
-		err = com.Validate.Var(email, "email")
+	// If comment text was provided, then validate it.  Note that if the flag for closing/reopening the discussion has
+	// been set, then comment text isn't required.  In all other situations it is
+	rawTxt := r.PostFormValue("comtext")
+	if rawTxt == "" && discClose == false {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Comment can't be empty!")
+		return
+	}
+	var comText string
+	if discClose == false || (discClose == true && rawTxt != "") {
+		// Unescape and validate the comment text
+		t, err := url.QueryUnescape(rawTxt)
 		if err != nil {
-			// Check for the special case of username@server, which may fail standard email validation checks
-			// eg username@localhost, won't validate as an email address, but should be accepted anyway
-			serverName := strings.Split(com.Conf.Web.ServerName, ":")
-			em := fmt.Sprintf("%s@%s", userName, serverName[0])
-			if email != em {
-				log.Printf("Email value failed validation: %s\n", err)
-				errorPage(w, r, http.StatusBadRequest, "Error when parsing email value")
-				return
-			}
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, "Error when unescaping comment field value")
+			return
 		}
-	}
-
-	// Add the user to the system
-	// NOTE: We generate a random password here (for now).  We may remove the password field itself from the
-	// database at some point, depending on whether we continue to support local database users
-	err = com.AddUser(auth0ID, userName, com.RandomString(32), email, displayName, avatarURL)
-	if err != nil {
-		// Note : gorilla/sessions uses MaxAge < 0 to mean "delete this session"
-		sess.Options.MaxAge = -1
-		err = sess.Save(r, w)
+		err = com.Validate.Var(t, "markdownsource")
 		if err != nil {
-			errorPage(w, r, http.StatusInternalServerError, err.Error()+" Something went wrong during user creation")
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, "Invalid characters in the new discussions' main text field")
 			return
 		}
-
-		// Alert the user to the problem
-		errorPage(w, r, http.StatusInternalServerError, "Something went wrong during user creation")
-		return
-	}
-
-	// Remove the temporary username selection session data
-	sess.Options.MaxAge = -1
-	err = sess.Save(r, w)
-	if err != nil {
-		errorPage(w, r, http.StatusInternalServerError, err.Error())
-		return
+		comText = t
 	}
 
-	// Create normal session cookie for the user
-	sess, err = store.Get(r, "3dhub-user")
+	// Check if the requested database exists
+	exists, err := com.CheckFileExists(loggedInUser, owner, folder, fileName)
 	if err != nil {
-		errorPage(w, r, http.StatusBadRequest, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
 		return
 	}
-	sess.Values["UserName"] = userName
-	sess.Save(r, w)
-	if err != nil {
-		errorPage(w, r, http.StatusInternalServerError, err.Error())
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Database '%s%s%s' doesn't exist", owner, folder, fileName)
 		return
 	}
 
-	// User creation completed, so bounce to the user profile page
-	http.Redirect(w, r, "/"+userName, http.StatusSeeOther)
-}
-
-// This is called from the username selection page, to check if a name is available.
-func checkNameHandler(w http.ResponseWriter, r *http.Request) {
-	// Retrieve the potential username from the URL
-	userName := r.FormValue("name")
-
-	// Validate the user supplied username
-	err := com.ValidateUser(userName)
+	// Archived projects are read-only
+	archived, err := com.ProjectArchived(owner, folder, fileName)
 	if err != nil {
-		fmt.Fprint(w, "n")
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
 		return
 	}
-
-	// Ensure the username isn't a reserved one
-	err = com.ReservedUsernamesCheck(userName)
-	if err != nil {
-		fmt.Fprint(w, "n")
+	if archived {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, "This project is archived, so it's read-only")
 		return
 	}
 
-	// Check if the username is already in our system
-	exists, err := com.CheckUserExists(userName)
-	if err != nil {
-		fmt.Fprint(w, "n")
-		return
-	}
-	if exists {
-		fmt.Fprint(w, "n")
-		return
+	// Comments which match the word filter or look like spam are held for moderation, rather than being added to the
+	// discussion
+	var held bool
+	if comText != "" {
+		var reason string
+		if pattern, matched := com.FilterText(comText); matched {
+			reason = fmt.Sprintf("Word filter: matched '%s'", pattern)
+		} else if spam, why, _ := com.CheckSpam(loggedInUser, clientIP(r), r.Header.Get("User-Agent"), "comment",
+			comText); spam {
+			reason = "Automatic spam check: " + why
+		}
+		if reason != "" {
+			err = com.HoldComment(owner, folder, fileName, loggedInUser, discID, comText, reason)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				fmt.Fprint(w, err.Error())
+				return
+			}
+			held = true
+			comText = ""
+		}
 	}
 
-	// The username is available
-	fmt.Fprint(w, "y")
-	return
-}
-
-// This function deletes a branch.
-func deleteBranchHandler(w http.ResponseWriter, r *http.Request) {
-	pageName := "Delete Branch handler"
+	// Add the comment to PostgreSQL
+	if comText != "" || discClose {
+		err = com.StoreComment(owner, folder, fileName, loggedInUser, discID, comText, discClose,
+			com.CLOSED_WITHOUT_MERGE) // com.CLOSED_WITHOUT_MERGE is ignored for discussions.  It's only used for MRs
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, err.Error())
+			return
+		}
+		com.PublishLiveUpdate(owner, folder, fileName, com.LIVE_COMMENT, discID)
+	}
 
-	// Retrieve session data (if any)
-	var loggedInUser string
-	var u interface{}
-	validSession := false
-	if com.Conf.Environment.Environment != "docker" {
-		sess, err := store.Get(r, "3dhub-user")
+	// Invalidate the memcache data for the database, so if the discussion counter for the database was changed it
+	// gets picked up
+	if discClose {
+		err = com.InvalidateCacheEntry(loggedInUser, owner, folder, fileName, "") // Empty string indicates "for all versions"
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
+			// Something went wrong when invalidating memcached entries for the database
+			com.Log.Errorf("Error when invalidating memcache entries: %s", err.Error())
 			return
 		}
-		u = sess.Values["UserName"]
-	} else {
-		u = "default"
 	}
-	if u != nil {
-		loggedInUser = u.(string)
-		validSession = true
+
+	// Send a success message, letting the commenter know if their comment needs approving first
+	if held {
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprint(w, "Your comment will show up once a moderator has approved it")
+		return
 	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// Receives incoming info from the "Create a new discussion" page, adds the discussion to PostgreSQL,
+// then bounces to the discussion page
+func createDiscussHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
 
 	// Ensure we have a valid logged in user
-	if validSession != true {
-		w.WriteHeader(http.StatusUnauthorized)
+	if loggedInUser == "" {
+		errorPage(w, r, http.StatusUnauthorized, "You need to be logged in")
 		return
 	}
 
-	// Extract the required form variables
-	usr, folder, fileName, err := com.GetUFD(r, false)
+	// Extract and validate the form variables
+	owner, folder, fileName, err := com.GetUFD(r, false)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		errorPage(w, r, http.StatusBadRequest, "Missing or incorrect data supplied")
 		return
 	}
-	owner := strings.ToLower(usr)
 
-	// Check if a branch name was requested
-	branchName, err := com.GetFormBranch(r)
+	// Validate the discussions' title
+	tl := r.PostFormValue("title")
+	err = com.ValidateDiscussionTitle(tl)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		errorPage(w, r, http.StatusBadRequest, "Invalid characters in the new discussions' title")
 		return
 	}
+	discTitle := tl
 
-	// If any of the required values were empty, indicate failure
-	if branchName == "" || folder == "" || fileName == "" || owner == "" {
-		w.WriteHeader(http.StatusBadRequest)
+	// Validate the discussions' text
+	txt := r.PostFormValue("disctxt")
+	if txt == "" {
+		errorPage(w, r, http.StatusBadRequest, "Discussion body can't be empty!")
 		return
 	}
+	err = com.Validate.Var(txt, "markdownsource")
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, "Invalid characters in the new discussions' main text field")
+		return
+	}
+	discText := txt
 
-	// Make sure the database exists in the system
+	// Check if the requested database exists
 	exists, err := com.CheckFileExists(loggedInUser, owner, folder, fileName)
-	if err != err {
-		w.WriteHeader(http.StatusInternalServerError)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
 	if !exists {
-		log.Printf("%s: Validation failed for database to delete: %s", pageName, err)
-		w.WriteHeader(http.StatusNotFound)
+		errorPage(w, r, http.StatusNotFound, fmt.Sprintf("Database '%s%s%s' doesn't exist", owner, folder,
+			fileName))
 		return
 	}
 
-	// Make sure the database is owned by the logged in user. eg prevent changes to other people's databases
-	if strings.ToLower(owner) != strings.ToLower(loggedInUser) {
-		w.WriteHeader(http.StatusBadRequest)
+	// Archived projects are read-only
+	archived, err := com.ProjectArchived(owner, folder, fileName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
-
-	// Load the existing branchHeads for the database
-	branchList, err := com.GetBranches(loggedInUser, folder, fileName)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+	if archived {
+		errorPage(w, r, http.StatusForbidden, "This project is archived, so it's read-only")
 		return
 	}
 
-	// Make sure the given branch exists
-	branch, ok := branchList[branchName]
-	if !ok {
-		w.WriteHeader(http.StatusBadRequest)
+	// Add the discussion detail to PostgreSQL
+	id, err := com.StoreDiscussion(owner, folder, fileName, loggedInUser, discTitle, discText, com.DISCUSSION,
+		com.MergeRequestEntry{})
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	// Make sure the branch being deleted isn't the default one
-	defBranch, err := com.GetDefaultBranchName(owner, folder, fileName)
+	// Generate an event about the new discussion
+	details := com.EventDetails{
+		DBName:   fileName,
+		DiscID:   id,
+		Folder:   folder,
+		Owner:    owner,
+		Title:    discTitle,
+		Type:     com.EVENT_NEW_DISCUSSION,
+		URL:      fmt.Sprintf("/discuss/%s%s%s?id=%d", owner, folder, fileName, id),
+		UserName: loggedInUser,
+	}
+	err = com.NewEvent(details)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		com.Log.Errorf("Error when creating a new event: %s", err.Error())
 		return
 	}
-	if defBranch == branchName {
-		w.WriteHeader(http.StatusConflict)
+
+	// Invalidate the memcache data for the database, so the new discussion count gets picked up
+	err = com.InvalidateCacheEntry(loggedInUser, owner, folder, fileName, "") // Empty string indicates "for all versions"
+	if err != nil {
+		// Something went wrong when invalidating memcached entries for the database
+		com.Log.Errorf("Error when invalidating memcache entries: %s", err.Error())
+		return
+	}
+
+	// Bounce to the discussions page
+	http.Redirect(w, r, fmt.Sprintf("/discuss%s?id=%d", com.ProjectPath(owner, folder, fileName), id),
+		http.StatusSeeOther)
+}
+
+// Receives incoming requests from the merge request creation page, creating them if the info is correct
+func createMergeHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
+
+	// Ensure we have a valid logged in user
+	if loggedInUser == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, "You need to be logged in")
+		return
+	}
+
+	// Extract and validate the form variables
+	userName, err := com.GetUsername(r, false)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	if userName == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Missing username in supplied fields")
+		return
+	}
+
+	// Retrieve source owner
+	o := r.PostFormValue("sourceowner")
+	srcOwner, err := url.QueryUnescape(o)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	err = com.ValidateUser(srcOwner)
+	if err != nil {
+		com.Log.Errorf("Validation failed for username: '%s'- %s", srcOwner, err)
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+
+	// Retrieve source folder
+	f := r.PostFormValue("sourcefolder")
+	srcFolder, err := url.QueryUnescape(f)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	err = com.ValidateFolder(srcFolder)
+	if err != nil {
+		com.Log.Errorf("Validation failed for folder: '%s' - %s", srcFolder, err)
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+
+	// Retrieve source database name
+	d := r.PostFormValue("sourcedbname")
+	srcDBName, err := url.QueryUnescape(d)
+	srcDBName = com.NormaliseName(srcDBName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	err = com.ValidateFileName(srcDBName)
+	if err != nil {
+		com.Log.Errorf("Validation failed for database name '%s': %s", srcDBName, err)
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+
+	// Retrieve source branch name
+	a := r.PostFormValue("sourcebranch")
+	srcBranch, err := url.QueryUnescape(a)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	err = com.ValidateBranchName(srcBranch)
+	if err != nil {
+		com.Log.Errorf("Validation failed for branch name '%s': %s", srcBranch, err)
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+
+	// Retrieve destination owner
+	o = r.PostFormValue("destowner")
+	destOwner, err := url.QueryUnescape(o)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	err = com.ValidateUser(destOwner)
+	if err != nil {
+		com.Log.Errorf("Validation failed for username: '%s'- %s", destOwner, err)
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+
+	// Retrieve destination folder
+	f = r.PostFormValue("destfolder")
+	destFolder, err := url.QueryUnescape(f)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	err = com.ValidateFolder(destFolder)
+	if err != nil {
+		com.Log.Errorf("Validation failed for folder: '%s' - %s", destFolder, err)
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+
+	// Retrieve destination database name
+	d = r.PostFormValue("destdbname")
+	destDBName, err := url.QueryUnescape(d)
+	destDBName = com.NormaliseName(destDBName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	err = com.ValidateFileName(destDBName)
+	if err != nil {
+		com.Log.Errorf("Validation failed for database name '%s': %s", destDBName, err)
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+
+	// Retrieve destination branch name
+	a = r.PostFormValue("destbranch")
+	destBranch, err := url.QueryUnescape(a)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	err = com.ValidateBranchName(destBranch)
+	if err != nil {
+		com.Log.Errorf("Validation failed for branch name '%s': %s", destBranch, err)
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+
+	// Validate the MR title
+	tl := r.PostFormValue("title")
+	if tl == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Title can't be blank")
+		return
+	}
+	title, err := url.QueryUnescape(tl)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	err = com.ValidateDiscussionTitle(title)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Invalid characters in the merge request title")
+		return
+	}
+
+	// Validate the MR description
+	t := r.PostFormValue("desc")
+	if t == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Merge request description can't be empty")
+		return
+	}
+	descrip, err := url.QueryUnescape(t)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	err = com.Validate.Var(title, "markdownsource")
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, "Invalid characters in the description field")
+		return
+	}
+
+	// Make sure none of the required fields is empty
+	if srcOwner == "" || srcFolder == "" || srcDBName == "" || srcBranch == "" || destOwner == "" || destFolder ==
+		"" || destDBName == "" || destBranch == "" || title == "" || descrip == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Some of the (required) supplied fields are empty")
+		return
+	}
+
+	// Check the databases exist
+	srcExists, err := com.CheckFileExists(loggedInUser, srcOwner, srcFolder, srcDBName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	destExists, err := com.CheckFileExists(loggedInUser, destOwner, destFolder, destDBName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	if !srcExists || !destExists {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, "Invalid database.  One of the source or destination databases doesn't exist")
+		return
+	}
+
+	// Archived projects are read-only
+	archived, err := com.ProjectArchived(destOwner, destFolder, destDBName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	if archived {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, "This project is archived, so it's read-only")
+		return
+	}
+
+	// Get the details of the commits for the MR
+	mrDetails := com.MergeRequestEntry{
+		DestBranch:   destBranch,
+		SourceBranch: srcBranch,
+		SourceDBName: srcDBName,
+		SourceFolder: srcFolder,
+		SourceOwner:  srcOwner,
+	}
+	var ancestorID string
+	ancestorID, mrDetails.Commits, err, _ = com.GetCommonAncestorCommits(srcOwner, srcFolder, srcDBName, srcBranch,
+		destOwner, destFolder, destDBName, destBranch)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+
+	// Make sure the source branch will cleanly apply to the destination.  eg the destination branch hasn't received
+	// additional commits since the source was forked
+	if ancestorID == "" {
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprint(w, "Source branch is not a direct descendent of the destination branch.  Cannot merge.")
+		return
+	}
+
+	// Create the merge request in PostgreSQL
+	var x struct {
+		ID int `json:"mr_id"`
+	}
+	x.ID, err = com.StoreDiscussion(destOwner, destFolder, destDBName, loggedInUser, title, descrip, com.MERGE_REQUEST,
+		mrDetails)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+
+	// Generate an event about the new merge request
+	details := com.EventDetails{
+		DBName:   destDBName,
+		DiscID:   x.ID,
+		Folder:   destFolder,
+		Owner:    destOwner,
+		Title:    title,
+		Type:     com.EVENT_NEW_MERGE_REQUEST,
+		URL:      fmt.Sprintf("/merge/%s%s%s?id=%d", destOwner, destFolder, destDBName, x.ID),
+		UserName: loggedInUser,
+	}
+	err = com.NewEvent(details)
+	if err != nil {
+		com.Log.Errorf("Error when creating a new event: %s", err.Error())
+		return
+	}
+
+	// Invalidate the memcache data for the destination database, so the new MR count gets picked up
+	err = com.InvalidateCacheEntry(loggedInUser, destOwner, destFolder, destDBName, "") // Empty string indicates "for all versions"
+	if err != nil {
+		// Something went wrong when invalidating memcached entries for the database
+		com.Log.Errorf("Error when invalidating memcache entries: %s", err.Error())
+		return
+	}
+
+	// Indicate success to the caller, and return the ID # of the new merge request
+	y, err := json.MarshalIndent(x, "", " ")
+	if err != nil {
+		com.Log.Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, string(y))
+}
+
+func createTagHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
+
+	// Ensure we have a valid logged in user
+	if loggedInUser == "" {
+		errorPage(w, r, http.StatusUnauthorized, "You need to be logged in")
+		return
+	}
+
+	// Extract and validate the form variables
+	owner, fileName, commit, err := com.GetFormUDC(r)
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, "Missing or incorrect data supplied")
+		return
+	}
+	tagName, err := com.GetFormTag(r)
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, "Missing or incorrect tag name")
+		return
+	}
+
+	// If given, validate the tag description field
+	td := r.PostFormValue("tagdesc") // Optional
+	var tagDesc string
+	if td != "" {
+		err = com.Validate.Var(td, "markdownsource")
+		if err != nil {
+			errorPage(w, r, http.StatusBadRequest, "Invalid characters in tag description")
+			return
+		}
+		tagDesc = td
+	}
+
+	// Validate the tag type field
+	tagType := r.PostFormValue("tagtype")
+	if tagType != "tag" && tagType != "release" {
+		errorPage(w, r, http.StatusBadRequest, "Unknown tag type")
+		return
+	}
+
+	// Releases can be published at a later time, instead of straight away
+	_, timeZone := requestDatePrefs(r)
+	publishAt, err := com.GetFormPublishAt(r, timeZone)
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !publishAt.IsZero() && tagType != "release" {
+		errorPage(w, r, http.StatusBadRequest, "Only releases can be published at a later time")
+		return
+	}
+
+	// Check if the requested database exists
+	folder := "/"
+	exists, err := com.CheckFileExists(loggedInUser, owner, folder, fileName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !exists {
+		errorPage(w, r, http.StatusNotFound, fmt.Sprintf("Database '%s%s%s' doesn't exist", owner, folder,
+			fileName))
+		return
+	}
+
+	// Make sure the database owner matches the logged in user
+	if strings.ToLower(loggedInUser) != strings.ToLower(owner) {
+		errorPage(w, r, http.StatusUnauthorized, "You can't change databases you don't own")
+		return
+	}
+
+	// Retrieve the user details
+	usr, err := com.User(loggedInUser)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "An error occurred when retrieving user details")
+	}
+
+	// Create a new tag or release as appropriate
+	if tagType == "release" {
+		// * It's a release *
+
+		// Read the releases list from the database
+		rels, err := com.GetReleases(owner, folder, fileName)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		// Ensure the release doesn't already exist
+		if _, ok := rels[tagName]; ok {
+			errorPage(w, r, http.StatusConflict, "A release of that name already exists!")
+			return
+		}
+
+		// Retrieve the size of the database for this release
+		var tmp com.SQLiteDBinfo
+		err = com.DBDetails(&tmp, loggedInUser, owner, folder, fileName, commit)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+		size := tmp.Info.DBEntry.Size
+
+		// Record the SPDX identifier of the release's licence, for automated compliance tools
+		spdxID, err := com.GetLicenceSPDXFromSha256(owner, tmp.Info.DBEntry.LicenceSHA)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		// Create the release
+		newRel := com.ReleaseEntry{
+			Commit:        commit,
+			Date:          time.Now(),
+			Description:   tagDesc,
+			LicenceSPDX:   spdxID,
+			ReleaserEmail: usr.Email,
+			ReleaserName:  usr.DisplayName,
+			Size:          size,
+		}
+
+		// If it's to be published later, it's kept out of the release list until then
+		if !publishAt.IsZero() {
+			err = com.SchedulePublication(owner, folder, fileName, tagName, newRel, publishAt)
+			if err != nil {
+				errorPage(w, r, http.StatusInternalServerError, err.Error())
+				return
+			}
+			http.Redirect(w, r, "/releases"+com.ProjectPath(loggedInUser, folder, fileName), http.StatusSeeOther)
+			return
+		}
+		rels[tagName] = newRel
+
+		// Store it in PostgreSQL
+		err = com.StoreReleases(owner, folder, fileName, rels)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		// Tag the release on GitHub, if the project is mirrored there
+		err = com.QueueGitHubMirror(owner, folder, fileName)
+		if err != nil {
+			com.Log.Errorf("Error when queuing the GitHub mirror push for '%s%s%s': %v", owner, folder, fileName, err)
+		}
+
+		// Let the people watching the project know about the new release
+		err = com.NewEvent(com.EventDetails{
+			DBName:   fileName,
+			Folder:   folder,
+			Owner:    owner,
+			Title:    tagName,
+			Type:     com.EVENT_NEW_RELEASE,
+			URL:      fmt.Sprintf("/releases/%s%s%s", owner, folder, fileName),
+			UserName: loggedInUser,
+		})
+		if err != nil {
+			com.Log.Errorf("Error when creating a new event: %s", err.Error())
+		}
+
+		// Invalidate the memcache data for the database
+		err = com.InvalidateCacheEntry(loggedInUser, owner, folder, fileName, "") // Empty string indicates "for all versions"
+		if err != nil {
+			// Something went wrong when invalidating memcached entries for the database
+			com.Log.Errorf("Error when invalidating memcache entries: %s", err.Error())
+			return
+		}
+
+		// Bounce to the releases page
+		http.Redirect(w, r, "/releases"+com.ProjectPath(loggedInUser, folder, fileName), http.StatusSeeOther)
+		return
+	}
+
+	// * It's a tag *
+
+	// Read the tags list from the database
+	tags, err := com.GetTags(owner, folder, fileName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Make sure the tag doesn't already exist
+	if _, ok := tags[tagName]; ok {
+		errorPage(w, r, http.StatusConflict, "A tag of that name already exists!")
+		return
+	}
+
+	// Create the tag
+	newTag := com.TagEntry{
+		Commit:      commit,
+		Date:        time.Now(),
+		Description: tagDesc,
+		TaggerEmail: usr.Email,
+		TaggerName:  usr.DisplayName,
+	}
+	tags[tagName] = newTag
+
+	// Store it in PostgreSQL
+	err = com.StoreTags(owner, folder, fileName, tags)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Invalidate the memcache data for the database, so the new tag count gets picked up
+	err = com.InvalidateCacheEntry(loggedInUser, owner, folder, fileName, "") // Empty string indicates "for all versions"
+	if err != nil {
+		// Something went wrong when invalidating memcached entries for the database
+		com.Log.Errorf("Error when invalidating memcache entries: %s", err.Error())
+		return
+	}
+
+	// Bounce to the tags page
+	http.Redirect(w, r, "/tags"+com.ProjectPath(loggedInUser, folder, fileName), http.StatusSeeOther)
+}
+
+func createUserHandler(w http.ResponseWriter, r *http.Request) {
+	// Make sure this user creation session is valid
+	sess, err := store.Get(r, "user-reg")
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	validRegSession := false
+	va := sess.Values["registrationinprogress"]
+	if va == nil {
+		// This isn't a valid username selection session, so abort
+		errorPage(w, r, http.StatusBadRequest, "Invalid user creation session")
+		return
+	}
+	validRegSession = va.(bool)
+	if validRegSession != true {
+		// This isn't a valid username selection session, so abort
+		errorPage(w, r, http.StatusBadRequest, "Invalid user creation session")
+		return
+	}
+
+	// Retrieve the registration data
+	var auth0ID, avatarURL, email, displayName string
+	au := sess.Values["auth0id"]
+	if au != nil {
+		auth0ID = au.(string)
+	} else {
+		errorPage(w, r, http.StatusBadRequest, "Invalid user creation id")
+		return
+	}
+	av, ok := sess.Values["avatar"]
+	if ok {
+		avatarURL = av.(string)
+	}
+	em := sess.Values["email"]
+	if em != nil {
+		email = em.(string)
+	} else {
+		errorPage(w, r, http.StatusBadRequest, "Invalid user creation email")
+		return
+	}
+
+	// Gather submitted form data (if any)
+	err = r.ParseForm()
+	if err != nil {
+		com.Log.Errorf("Error when parsing user creation data: %s", err)
+		errorPage(w, r, http.StatusBadRequest, "Error when parsing user creation data")
+		return
+	}
+	userName := r.PostFormValue("username")
+
+	// Ensure username was given
+	if userName == "" {
+		// No, so render the username selection page
+		selectUserNamePage(w, r)
+		return
+	}
+
+	// Validate the user supplied username
+	err = com.ValidateUser(userName)
+	if err != nil {
+		com.Log.Errorf("Username failed validation: %s", err)
+
+		// Note : gorilla/sessions uses MaxAge < 0 to mean "delete this session"
+		sess.Options.MaxAge = -1
+		err = sess.Save(r, w)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error()+" Username failed validation")
+			return
+		}
+
+		// Alert the user to the validation problem
+		errorPage(w, r, http.StatusInternalServerError, "Username failed validation")
+		return
+	}
+
+	// Ensure the username isn't a reserved one
+	err = com.ReservedUsernamesCheck(userName)
+	if err != nil {
+		com.Log.Error(err)
+
+		// Note : gorilla/sessions uses MaxAge < 0 to mean "delete this session"
+		sess.Options.MaxAge = -1
+		err2 := sess.Save(r, w)
+		if err2 != nil {
+			// Try to display both errors
+			errorPage(w, r, http.StatusInternalServerError, err2.Error()+" "+err.Error())
+			return
+		}
+
+		// Alert the user to the ReservedUsernamesCheck() failure
+		errorPage(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Check if the username is already in our system
+	exists, err := com.CheckUserExists(userName)
+	if err != nil {
+		// Note : gorilla/sessions uses MaxAge < 0 to mean "delete this session"
+		sess.Options.MaxAge = -1
+		err = sess.Save(r, w)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error()+" Username check failed")
+			return
+		}
+
+		// Alert the username check failure
+		errorPage(w, r, http.StatusInternalServerError, "Username check failed")
+		return
+	}
+	if exists {
+		// Note : gorilla/sessions uses MaxAge < 0 to mean "delete this session"
+		sess.Options.MaxAge = -1
+		err = sess.Save(r, w)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error()+" That username is already taken")
+			return
+		}
+
+		// Let the user know their desired username is not available
+		errorPage(w, r, http.StatusConflict, "That username is already taken")
+		return
+	}
+
+	// If present, validate the users' full name
+	if displayName != "" {
+		err = com.Validate.Var(displayName, "required,displayname,min=1,max=80")
+		if err != nil {
+			com.Log.Errorf("Display name value failed validation: %s", err)
+			errorPage(w, r, http.StatusBadRequest, "Error when parsing full name value")
+			return
+		}
+	}
+
+	// If present, validate the email address
+	if email != "" {
+		err = com.Validate.Var(email, "email")
+		if err != nil {
+			// Check for the special case of username@server, which may fail standard email validation checks
+			// eg username@localhost, won't validate as an email address, but should be accepted anyway
+			serverName := strings.Split(com.Conf().Web.ServerName, ":")
+			em := fmt.Sprintf("%s@%s", userName, serverName[0])
+			if email != em {
+				com.Log.Errorf("Email value failed validation: %s", err)
+				errorPage(w, r, http.StatusBadRequest, "Error when parsing email value")
+				return
+			}
+		}
+	}
+
+	// Add the user to the system
+	// NOTE: We generate a random password here (for now).  We may remove the password field itself from the
+	// database at some point, depending on whether we continue to support local database users
+	err = com.AddUser(auth0ID, userName, com.RandomString(32), email, displayName, avatarURL)
+	if err != nil {
+		// Note : gorilla/sessions uses MaxAge < 0 to mean "delete this session"
+		sess.Options.MaxAge = -1
+		err = sess.Save(r, w)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error()+" Something went wrong during user creation")
+			return
+		}
+
+		// Alert the user to the problem
+		errorPage(w, r, http.StatusInternalServerError, "Something went wrong during user creation")
+		return
+	}
+	com.FireAdminWebhooks(com.WebhookUserRegistered, fmt.Sprintf("New user registered: %s", userName),
+		map[string]string{"display_name": displayName, "url": "https://" + com.Conf().Web.ServerName + "/" + userName,
+			"user": userName})
+
+	// Usernames matching the word filter are still allowed, but are put in front of a moderator
+	if pattern, matched := com.FilterName(userName); matched {
+		err = com.FlagUsername(userName, pattern)
+		if err == nil {
+			com.Log.Infof("Username '%s' flagged for moderation by the word filter: matched '%s'", userName, pattern)
+		}
+	}
+
+	// Remove the temporary username selection session data
+	sess.Options.MaxAge = -1
+	err = sess.Save(r, w)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Create normal session cookie for the user
+	sess, err = store.Get(r, "3dhub-user")
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	sess.Values["UserName"] = userName
+	sess.Values["LoginTime"] = time.Now().Unix()
+	sess.Values["LastSeen"] = time.Now().Unix()
+	sess.Values["ElevatedUntil"] = time.Now().Add(stepUpWindow).Unix()
+	sess.Values["Locale"] = com.PrefUserLocale(userName)
+	sess.Values["Theme"] = string(com.PrefUserTheme(userName))
+	sess.Values["TimeZone"], sess.Values["DateFormat"] = com.PrefUserDates(userName)
+	sess.Save(r, w)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// User creation completed, so bounce to the user profile page
+	http.Redirect(w, r, "/"+userName, http.StatusSeeOther)
+}
+
+// This is called from the username selection page, to check if a name is available.
+func checkNameHandler(w http.ResponseWriter, r *http.Request) {
+	// Retrieve the potential username from the URL
+	userName := r.FormValue("name")
+
+	// Validate the user supplied username
+	err := com.ValidateUser(userName)
+	if err != nil {
+		fmt.Fprint(w, "n")
+		return
+	}
+
+	// Ensure the username isn't a reserved one
+	err = com.ReservedUsernamesCheck(userName)
+	if err != nil {
+		fmt.Fprint(w, "n")
+		return
+	}
+
+	// Check if the username is already in our system
+	exists, err := com.CheckUserExists(userName)
+	if err != nil {
+		fmt.Fprint(w, "n")
+		return
+	}
+	if exists {
+		fmt.Fprint(w, "n")
+		return
+	}
+
+	// The username is available
+	fmt.Fprint(w, "y")
+	return
+}
+
+// Checks the details of a public project for spam, and its name against the word filter, hiding the project until a
+// moderator has looked at it if they look suspicious.  Failures are only logged, as they shouldn't stop people saving
+// their work.
+func checkProjectSpam(r *http.Request, owner string, folder string, fileName string, content string) {
+	var reason string
+	if pattern, matched := com.FilterName(fileName); matched {
+		reason = fmt.Sprintf("Word filter: the name matched '%s'", pattern)
+	} else {
+		spam, why, err := com.CheckSpam(owner, clientIP(r), r.Header.Get("User-Agent"), "forum-post", content)
+		if err != nil || !spam {
+			return
+		}
+		reason = "Automatic spam check: " + why
+	}
+	err := com.HoldProject(owner, folder, fileName, reason)
+	if err != nil {
+		return
+	}
+	err = com.InvalidateCacheEntry(owner, owner, folder, fileName, "") // Empty string indicates "for all versions"
+	if err != nil {
+		com.Log.Errorf("Error when invalidating memcache entries: %s", err.Error())
+	}
+	com.Log.Infof("Project '%s%s%s' held for moderation: %s", owner, folder, fileName, reason)
+	com.FireAdminWebhooks(com.WebhookProjectReported, fmt.Sprintf("Project %s%s%s was held for moderation: %s",
+		owner, folder, fileName, reason), map[string]string{"project": owner + folder + fileName, "reason": reason,
+		"url": "https://" + com.Conf().Web.ServerName + "/" + owner + folder + fileName})
+}
+
+// Returns the site-wide announcement to show at the top of each page (if any), rendered from Markdown.  Templates call
+// this as "announcement".  The list of announcements is only looked up once a minute (or straight after an admin
+// changes it), so pages don't each need a database query.
+func currentAnnouncement() template.HTML {
+	announcementMu.Lock()
+	defer announcementMu.Unlock()
+	now := time.Now()
+	if now.Sub(announcementsChecked) > time.Minute {
+		list, err := com.Announcements()
+		if err == nil {
+			announcements = list
+		}
+		announcementsChecked = now
+	}
+
+	// When more than one announcement is showing, the most recently started one wins
+	var msg string
+	for _, a := range announcements {
+		if !a.Start.After(now) && (a.End.IsZero() || a.End.After(now)) {
+			msg = a.Message
+		}
+	}
+	if msg == "" {
+		return ""
+	}
+	return template.HTML(gfm.Markdown([]byte(msg)))
+}
+
+// This function deletes a branch.
+func deleteBranchHandler(w http.ResponseWriter, r *http.Request) {
+	pageName := "Delete Branch handler"
+
+	loggedInUser := sessionUser(r)
+
+	// Ensure we have a valid logged in user
+	if loggedInUser == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// Extract the required form variables
+	usr, folder, fileName, err := com.GetUFD(r, false)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	owner := strings.ToLower(usr)
+
+	// Check if a branch name was requested
+	branchName, err := com.GetFormBranch(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// If any of the required values were empty, indicate failure
+	if branchName == "" || folder == "" || fileName == "" || owner == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Make sure the database exists in the system
+	exists, err := com.CheckFileExists(loggedInUser, owner, folder, fileName)
+	if err != err {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		com.Log.Errorf("%s: Validation failed for database to delete: %s", pageName, err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// Make sure the database is owned by the logged in user. eg prevent changes to other people's databases
+	if strings.ToLower(owner) != strings.ToLower(loggedInUser) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Load the existing branchHeads for the database
+	branchList, err := com.GetBranches(loggedInUser, folder, fileName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Make sure the given branch exists
+	branch, ok := branchList[branchName]
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Make sure the branch being deleted isn't the default one
+	defBranch, err := com.GetDefaultBranchName(owner, folder, fileName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if defBranch == branchName {
+		w.WriteHeader(http.StatusConflict)
 		return
 	}
 
@@ -1490,2473 +2762,4680 @@ func deleteBranchHandler(w http.ResponseWriter, r *http.Request) {
 	// is a tag or release on a commit which is only in this branch, deleting the branch would leave the tag or
 	// release in place with no way to reach it
 
-	// Get the commit list for the database
-	commitList, err := com.GetCommitList(owner, folder, fileName)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+	// Get the commit list for the database
+	commitList, err := com.GetCommitList(owner, folder, fileName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Get the tag list for the database
+	tags, err := com.GetTags(owner, folder, fileName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Get the release list for the database
+	rels, err := com.GetReleases(owner, folder, fileName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// If the database has tags, walk the commit history for the branch checking if any of the tags are on commits in
+	// this branch
+	branchTags := make(map[string]string)
+	if len(tags) > 0 {
+		// Walk the commit history for the branch checking if any of the tags are on commits in this branch
+		c, ok := commitList[branch.Commit]
+		if !ok {
+			com.Log.Errorf("Error when checking for isolated tags while deleting branch '%s' of database "+
+				"'%s%s%s'", branchName, owner, folder, fileName)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		for tName, tEntry := range tags {
+			// Scan through the tags, checking if any of them are for this commit
+			if tEntry.Commit == c.ID {
+				// It's a match, so add this tag to the list of tags on this branch
+				branchTags[tName] = c.ID
+			}
+		}
+		for c.Parent != "" {
+			c, ok = commitList[c.Parent]
+			if !ok {
+				com.Log.Errorf("Error when checking for isolated tags while deleting branch '%s' of database "+
+					"'%s%s%s'", branchName, owner, folder, fileName)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			for tName, tEntry := range tags {
+				// Scan through the tags, checking if any of them are for this commit
+				if tEntry.Commit == c.ID {
+					// It's a match, so add this tag to the list of tags on this branch
+					branchTags[tName] = c.ID
+				}
+			}
+		}
+
+		// For any tags on commits in this branch, check if they're also on other branches
+		if len(branchTags) > 0 {
+			for bName, bEntry := range branchList {
+				if bName == branchName {
+					// We're only checking "other branches"
+					continue
+				}
+
+				// If there are no tags left to check, we might as well stop further looping
+				if len(branchTags) == 0 {
+					break
+				}
+
+				c := commitList[bEntry.Commit]
+				for tName, tCommit := range branchTags {
+					if c.ID == tCommit {
+						// This commit matches a tag, so remove the tag from the list
+						delete(branchTags, tName)
+					}
+				}
+				for c.Parent != "" {
+					c, ok = commitList[c.Parent]
+					if !ok {
+						com.Log.Errorf("Error when checking for isolated tags while deleting branch '%s' of "+
+							"database '%s%s%s'", branchName, owner, folder, fileName)
+						w.WriteHeader(http.StatusInternalServerError)
+						return
+					}
+					for tName, tCommit := range branchTags {
+						if c.ID == tCommit {
+							// This commit matches a tag, so remove the tag from the list
+							delete(branchTags, tName)
+						}
+					}
+				}
+			}
+		}
+
+		// If there are any tags left over which aren't on other branches, abort this branch deletion and tell the user
+		if len(branchTags) > 0 {
+			var conflictedTags string
+			for tName := range branchTags {
+				if conflictedTags == "" {
+					conflictedTags = tName
+				} else {
+					conflictedTags += ", " + tName
+				}
+			}
+
+			w.WriteHeader(http.StatusConflict)
+			if len(branchTags) > 1 {
+				w.Write([]byte(fmt.Sprintf("You need to delete the tags '%s' before you can delete this branch",
+					conflictedTags)))
+			} else {
+				w.Write([]byte(fmt.Sprintf("You need to delete the tag '%s' before you can delete this branch",
+					conflictedTags)))
+			}
+			return
+		}
+	}
+
+	// If the database has releases, walk the commit history for the branch checking if any of the releases are on
+	// commits in this branch
+	branchRels := make(map[string]string)
+	if len(rels) > 0 {
+		// Walk the commit history for the branch checking if any of the releases are on commits in this branch
+		c, ok := commitList[branch.Commit]
+		if !ok {
+			com.Log.Errorf("Error when checking for isolated releases while deleting branch '%s' of database "+
+				"'%s%s%s'", branchName, owner, folder, fileName)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		for rName, rEntry := range rels {
+			// Scan through the releases, checking if any of them are for this commit
+			if rEntry.Commit == c.ID {
+				// It's a match, so add this release to the list of releases on this branch
+				branchRels[rName] = c.ID
+			}
+		}
+		for c.Parent != "" {
+			c, ok = commitList[c.Parent]
+			if !ok {
+				com.Log.Errorf("Error when checking for isolated releases while deleting branch '%s' of database "+
+					"'%s%s%s'", branchName, owner, folder, fileName)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			for rName, rEntry := range rels {
+				// Scan through the releases, checking if any of them are for this commit
+				if rEntry.Commit == c.ID {
+					// It's a match, so add this release to the list of releases on this branch
+					branchRels[rName] = c.ID
+				}
+			}
+		}
+
+		// For any releases on commits in this branch, check if they're also on other branches
+		if len(branchRels) > 0 {
+			for bName, bEntry := range branchList {
+				if bName == branchName {
+					// We're only checking "other branches"
+					continue
+				}
+
+				// If there are no releases left to check, we might as well stop further looping
+				if len(branchRels) == 0 {
+					break
+				}
+
+				c := commitList[bEntry.Commit]
+				for rName, rCommit := range branchRels {
+					if c.ID == rCommit {
+						// This commit matches a release, so remove the release from the list
+						delete(branchRels, rName)
+					}
+				}
+				for c.Parent != "" {
+					c, ok = commitList[c.Parent]
+					if !ok {
+						com.Log.Errorf("Error when checking for isolated releases while deleting branch '%s' of "+
+							"database '%s%s%s'", branchName, owner, folder, fileName)
+						w.WriteHeader(http.StatusInternalServerError)
+						return
+					}
+					for rName, rCommit := range branchRels {
+						if c.ID == rCommit {
+							// This commit matches a release, so remove the release from the list
+							delete(branchRels, rName)
+						}
+					}
+				}
+			}
+		}
+
+		// If there are any releases left over which aren't on other branches, abort this branch deletion and tell the user
+		if len(branchRels) > 0 {
+			var conflictedRels string
+			for rName := range branchRels {
+				if conflictedRels == "" {
+					conflictedRels = rName
+				} else {
+					conflictedRels += ", " + rName
+				}
+			}
+
+			w.WriteHeader(http.StatusConflict)
+			if len(branchRels) > 1 {
+				w.Write([]byte(fmt.Sprintf("You need to delete the releases '%s' before you can delete this branch",
+					conflictedRels)))
+			} else {
+				w.Write([]byte(fmt.Sprintf("You need to delete the release '%s' before you can delete this branch",
+					conflictedRels)))
+			}
+			return
+		}
+	}
+
+	// Make a list of commits in this branch
+	lst := map[string]bool{}
+	c, ok := commitList[branch.Commit]
+	if !ok {
+		com.Log.Errorf("Error when creating commit list while deleting branch '%s' of database '%s%s%s'",
+			branchName, owner, folder, fileName)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	lst[c.ID] = true
+	for c.Parent != "" {
+		c, ok = commitList[c.Parent]
+		if !ok {
+			com.Log.Errorf("Error when creating commit list while deleting branch '%s' of database '%s%s%s'",
+				branchName, owner, folder, fileName)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		lst[c.ID] = true
+	}
+
+	// For each commit, determine if it's only on this branch, and will need to be deleted after the branch
+	for bName, bEntry := range branchList {
+		if bName == branchName {
+			// We only run this comparison from "other branches", not the branch we're deleting
+			continue
+		}
+
+		// If there are no commits left to check, we might as well stop further looping
+		if len(lst) == 0 {
+			break
+		}
+
+		c, ok = commitList[bEntry.Commit]
+		if !ok {
+			err = fmt.Errorf("Broken commit history encountered when checking for commits while deleting "+
+				"branch '%s' of database '%s%s%s'\n", branchName, owner, folder, fileName)
+			com.Log.Error(err.Error()) // Broken commit history is pretty serious, so we log it for admin investigation
+			return
+		}
+		for delCommit := range lst {
+			if c.ID == delCommit {
+				// The commit is also on another branch, so we *must not* delete the commit afterwards
+				delete(lst, c.ID)
+			}
+		}
+		for c.Parent != "" {
+			c, ok = commitList[c.Parent]
+			if !ok {
+				err = fmt.Errorf("Broken commit history encountered when checking for commits while "+
+					"deleting branch '%s' of database '%s%s%s'\n", branchName, owner, folder, fileName)
+				com.Log.Error(err.Error()) // Broken commit history is pretty serious, so we log it for admin investigation
+				return
+			}
+			for delCommit := range lst {
+				if c.ID == delCommit {
+					// The commit is also on another branch, so we *must not* delete the commit afterwards
+					delete(lst, c.ID)
+				}
+			}
+		}
+	}
+
+	// Delete the branch
+	delete(branchList, branchName)
+	err = com.StoreBranches(owner, folder, fileName, branchList)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Delete the left over commits
+	// TODO: We may want to consider clearing any memcache entries for the deleted commits too
+	for cid := range lst {
+		delete(commitList, cid)
+	}
+	err = com.StoreCommits(owner, folder, fileName, commitList)
+	if err != nil {
+		com.Log.Errorf("Error when updating commit list while deleting branch '%s' of database '%s%s%s': %s",
+			branchName, owner, folder, fileName, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Invalidate the memcache data for the database, so the new branch count gets picked up
+	err = com.InvalidateCacheEntry(loggedInUser, owner, folder, fileName, "") // Empty string indicates "for all versions"
+	if err != nil {
+		// Something went wrong when invalidating memcached entries for the database
+		com.Log.Errorf("Error when invalidating memcache entries: %s", err.Error())
+		return
+	}
+
+	// Update succeeded
+	w.WriteHeader(http.StatusOK)
+}
+
+// This function deletes a given comment from a discussion.
+func deleteCommentHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
+
+	// Ensure we have a valid logged in user
+	if loggedInUser == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, "You need to be logged in")
+		return
+	}
+
+	// Extract and validate the form variables
+	owner, folder, fileName, err := com.GetUFD(r, false)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Missing or incorrect data supplied")
+		return
+	}
+
+	// Ensure a discussion ID was given
+	a := r.PostFormValue("discid")
+	if a == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Missing discussion id")
+		return
+	}
+	discID, err := strconv.Atoi(a)
+	if err != nil {
+		com.Log.Errorf("Error converting string '%s' to integer in function '%s': %s", a,
+			com.GetCurrentFunctionName(), err)
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Error when parsing discussion id value")
+		return
+	}
+
+	// Ensure a comment ID was given
+	a = r.PostFormValue("comid")
+	if a == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Missing comment id")
+		return
+	}
+	comID, err := strconv.Atoi(a)
+	if err != nil {
+		com.Log.Errorf("Error converting string '%s' to integer in function '%s': %s", a,
+			com.GetCurrentFunctionName(), err)
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Error when parsing comment id value")
+		return
+	}
+
+	// Check if the requested database exists
+	exists, err := com.CheckFileExists(loggedInUser, owner, folder, fileName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Database '%s%s%s' doesn't exist", owner, folder, fileName)
+		return
+	}
+
+	// Archived projects are read-only
+	archived, err := com.ProjectArchived(owner, folder, fileName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	if archived {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, "This project is archived, so it's read-only")
+		return
+	}
+
+	// Check if the logged in user is allowed to delete the requested comment
+	deleteAllowed := false
+	if strings.ToLower(owner) == strings.ToLower(loggedInUser) {
+		// The database owner can delete any discussion comment on their databases
+		deleteAllowed = true
+	} else {
+		// Retrieve the details for the requested comment, so we can check if the logged in user is the comment creator
+		rq, err := com.DiscussionComments(owner, folder, fileName, discID, comID)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, err.Error())
+			return
+		}
+		if strings.ToLower(rq[0].Commenter) == strings.ToLower(loggedInUser) {
+			deleteAllowed = true
+		}
+	}
+
+	// If the logged in user isn't allowed to delete the requested comment, then reject the request
+	if !deleteAllowed {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// Delete the comment from PostgreSQL
+	err = com.DeleteComment(owner, folder, fileName, discID, comID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// This function deletes the latest commit from a given branch.
+func deleteCommitHandler(w http.ResponseWriter, r *http.Request) {
+	pageName := "Delete commit handler"
+
+	loggedInUser := sessionUser(r)
+
+	// Ensure we have a valid logged in user
+	if loggedInUser == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// Extract the required form variables
+	usr, folder, fileName, err := com.GetUFD(r, false)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	owner := strings.ToLower(usr)
+
+	// Validate the supplied commit ID
+	commit, err := com.GetFormCommit(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Validate the supplied branch name
+	branchName, err := com.GetFormBranch(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// If any of the required values were empty, indicate failure
+	if branchName == "" || folder == "" || fileName == "" || owner == "" || commit == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Make sure the database exists in the system
+	exists, err := com.CheckFileExists(loggedInUser, owner, folder, fileName)
+	if err != err {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		com.Log.Errorf("%s: Validation failed for database name: %s", pageName, err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// Make sure the database is owned by the logged in user. eg prevent changes to other people's databases
+	if strings.ToLower(owner) != strings.ToLower(loggedInUser) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Load the existing branchHeads for the database
+	branches, err := com.GetBranches(loggedInUser, folder, fileName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Make sure the given branch exists
+	b, ok := branches[branchName]
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Unknown branch name"))
+		return
+	}
+
+	// Check that the given commit matches the head commit of the branch
+	if b.Commit != commit {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("Only the most recent commit for a branch can be deleted"))
+		return
+	}
+
+	// Determine the commit ID we'll be rewinding to
+	commitList, err := com.GetCommitList(owner, folder, fileName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	c, ok := commitList[commit]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Requested commit not found"))
+		return
+	}
+	if c.Parent == "" {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("Requested commit has no ancestors.  Not going to delete it"))
+		return
+	}
+	prevCommit := c.Parent
+
+	// If we're working on the default branch, check if the default table is present in the prior commit's version
+	// of the database.  If it's not, we need to clear the default table value
+	defBranch, err := com.GetDefaultBranchName(owner, folder, fileName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if branchName == defBranch {
+		// * Retrieve the list of tables present in the prior commit *
+		bkt, id, _, err := com.MinioLocation(owner, folder, fileName, prevCommit, loggedInUser)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		// Get a handle from Minio for the SQLite database object
+		sdb, err := com.OpenMinioObject(bkt, id)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		// Automatically close the SQLite database when this function finishes
+		defer func() {
+			sdb.Close()
+		}()
+
+		// Retrieve the list of tables in the database
+		sTbls, err := com.Tables(sdb, fmt.Sprintf("%s%s%s", owner, folder, fileName))
+		defer sdb.Close()
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		// Retrieve the default table name for the database
+		defTbl, err := com.GetDefaultTableName(owner, folder, fileName)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		defFound := false
+		for _, j := range sTbls {
+			if j == defTbl {
+				defFound = true
+			}
+		}
+		if !defFound {
+			// The default table is present in the previous commit, so we clear the default table value
+			err = com.StoreDefaultTableName(owner, folder, fileName, "")
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+
+	// Delete the commit
+	iTags, iRels, err := com.DeleteBranchHistory(owner, folder, fileName, branchName, prevCommit)
+	if err != nil {
+		if (len(iTags) > 0) || (len(iRels) > 0) {
+			msg := fmt.Sprintln("You need to delete the following tags and releases before the commit can be " +
+				"deleted:")
+			var rList, tList string
+			if len(iTags) > 0 {
+				// Would-be-isolated tags were identified.  Warn the user.
+				msg += "  TAGS: "
+				for _, tName := range iTags {
+					if tList == "" {
+						msg += fmt.Sprintf("'%s'", tName)
+					} else {
+						msg += fmt.Sprintf(", '%s'", tName)
+					}
+				}
+			}
+			if len(iRels) > 0 {
+				// Would-be-isolated releases were identified.  Warn the user.
+				msg += "  RELEASES: "
+				for _, rName := range iRels {
+					if rList == "" {
+						msg += fmt.Sprintf("'%s'", rName)
+					} else {
+						msg += fmt.Sprintf(", '%s'", rName)
+					}
+				}
+			}
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(msg))
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Commit deletion failed, internal server error"))
+		return
+	}
+
+	// Invalidate the memcache data for the database, so the new branch count gets picked up
+	err = com.InvalidateCacheEntry(loggedInUser, owner, folder, fileName, "") // Empty string indicates "for all versions"
+	if err != nil {
+		// Something went wrong when invalidating memcached entries for the database
+		com.Log.Errorf("Error when invalidating memcache entries: %s", err.Error())
+		return
+	}
+
+	// Update succeeded
+	w.WriteHeader(http.StatusOK)
+}
+
+// This function deletes a database.
+func deleteDatabaseHandler(w http.ResponseWriter, r *http.Request) {
+	pageName := "Delete Database handler"
+
+	loggedInUser := sessionUser(r)
+
+	// Ensure we have a valid logged in user
+	if loggedInUser == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, "You need to be logged in")
+		return
+	}
+
+	// Extract the required form variables
+	usr, folder, fileName, err := com.GetUFD(r, false)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Validation failed for owner or database name")
+		return
+	}
+	owner := strings.ToLower(usr)
+
+	// If any of the required values were empty, indicate failure
+	if folder == "" || fileName == "" || owner == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Owner, folder, or database name values() missing")
+		return
+	}
+
+	// Make sure the database exists in the system
+	exists, err := com.CheckFileExists(loggedInUser, owner, folder, fileName)
+	if err != err {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, "Internal server error")
+		return
+	}
+	if !exists {
+		com.Log.Errorf("%s: Missing database for '%s%s%s' when attempting deletion", pageName, owner, folder,
+			fileName)
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, "Internal server error")
+		return
+	}
+
+	// Make sure the database is owned by the logged in user. eg prevent changes to other people's databases
+	if strings.ToLower(owner) != strings.ToLower(loggedInUser) {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, "You don't have permission to delete that database")
+		return
+	}
+
+	// The confirm deletion page has the user confirm who they are, but that may have expired since it was loaded
+	if !elevated(r) {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, "Please confirm it's you first, by reloading this page")
+		return
+	}
+
+	// Invalidate the memcache data for the database, so the new branch count gets picked up
+	// Note - on one hand this is a race condition, as new cache data could get into memcache between this invalidation
+	// call and the delete.  On the other hand, once it's deleted the invalidation function would itself fail due to
+	// needing the database to be present so it can look up the commit list.  At least doing the invalidation here lets
+	// us clear stale data (hopefully) for the vast majority of the time
+	err = com.InvalidateCacheEntry(loggedInUser, owner, folder, fileName, "") // Empty string indicates "for all versions"
+	if err != nil {
+		// Something went wrong when invalidating memcached entries for the database
+		com.Log.Errorf("Error when invalidating memcache entries: %s", err.Error())
+		return
+	}
+
+	// Delete the database
+	err = com.DeleteDatabase(owner, folder, fileName)
+	if err != err {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, "Internal server error")
+		return
+	}
+
+	// Update the search index
+	err = com.UpdateSearchIndex(owner, folder, fileName)
+	if err != nil {
+		com.Log.Errorf("Error when updating the search index: %s", err.Error())
+	}
+
+	// Update succeeded
+	w.WriteHeader(http.StatusOK)
+}
+
+// This function deletes a release.
+func deleteReleaseHandler(w http.ResponseWriter, r *http.Request) {
+	pageName := "Delete Release handler"
+
+	loggedInUser := sessionUser(r)
+
+	// Ensure we have a valid logged in user
+	if loggedInUser == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// Extract the required form variables
+	usr, folder, fileName, err := com.GetUFD(r, false)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	owner := strings.ToLower(usr)
+
+	// Ensure a release name was supplied
+	relName, err := com.GetFormRelease(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// If any of the required values were empty, indicate failure
+	if relName == "" || folder == "" || fileName == "" || owner == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Make sure the database exists in the system
+	exists, err := com.CheckFileExists(loggedInUser, owner, folder, fileName)
+	if err != err {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		com.Log.Errorf("%s: Validation failed for database name: %s", pageName, err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// Make sure the database is owned by the logged in user. eg prevent changes to other people's databases
+	if strings.ToLower(owner) != strings.ToLower(loggedInUser) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Load the existing releases for the database
+	releases, err := com.GetReleases(loggedInUser, folder, fileName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Make sure the given tag exists
+	if _, ok := releases[relName]; !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Delete the release
+	delete(releases, relName)
+	err = com.StoreReleases(owner, folder, fileName, releases)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Invalidate the memcache data for the database, so the new release count gets picked up
+	err = com.InvalidateCacheEntry(loggedInUser, owner, folder, fileName, "") // Empty string indicates "for all versions"
+	if err != nil {
+		// Something went wrong when invalidating memcached entries for the database
+		com.Log.Errorf("Error when invalidating memcache entries: %s", err.Error())
+		return
+	}
+
+	// Update succeeded
+	w.WriteHeader(http.StatusOK)
+}
+
+// This function deletes a tag.
+func deleteTagHandler(w http.ResponseWriter, r *http.Request) {
+	pageName := "Delete Tag handler"
+
+	loggedInUser := sessionUser(r)
+
+	// Ensure we have a valid logged in user
+	if loggedInUser == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// Extract the required form variables
+	usr, folder, fileName, err := com.GetUFD(r, false)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	owner := strings.ToLower(usr)
+
+	// Ensure a tag name was supplied
+	tagName, err := com.GetFormTag(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// If any of the required values were empty, indicate failure
+	if tagName == "" || folder == "" || fileName == "" || owner == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Make sure the database exists in the system
+	exists, err := com.CheckFileExists(loggedInUser, owner, folder, fileName)
+	if err != err {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		com.Log.Errorf("%s: Validation failed for database name: %s", pageName, err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// Make sure the database is owned by the logged in user. eg prevent changes to other people's databases
+	if strings.ToLower(owner) != strings.ToLower(loggedInUser) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Load the existing tags for the database
+	tags, err := com.GetTags(loggedInUser, folder, fileName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Make sure the given tag exists
+	if _, ok := tags[tagName]; !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Delete the tag
+	delete(tags, tagName)
+	err = com.StoreTags(owner, folder, fileName, tags)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Invalidate the memcache data for the database, so the new tag count gets picked up
+	err = com.InvalidateCacheEntry(loggedInUser, owner, folder, fileName, "") // Empty string indicates "for all versions"
+	if err != nil {
+		// Something went wrong when invalidating memcached entries for the database
+		com.Log.Errorf("Error when invalidating memcache entries: %s", err.Error())
+		return
+	}
+
+	// Update succeeded
+	w.WriteHeader(http.StatusOK)
+}
+
+// Returns the one line and full descriptions of a project as JSON, in their raw Markdown form.  Any translations of
+// them (and of the README) are included too.
+func descriptionHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
+
+	// Extract the owner and database name
+	// TODO: Add folder support
+	owner, fileName, err := com.GetOD(2, r) // 2 = Ignore "/x/description/" at the start of the URL
+	if err != nil || owner == "" || fileName == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	folder := "/"
+
+	// Make sure the database exists in the system, and the user has access to it
+	exists, err := com.CheckFileExists(loggedInUser, owner, folder, fileName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var desc struct {
+		FullDesc     string                   `json:"full_description"`
+		OneLineDesc  string                   `json:"one_line_description"`
+		Translations []com.ProjectTranslation `json:"translations"`
+	}
+	desc.OneLineDesc, desc.FullDesc, err = com.ProjectDescriptions(owner, folder, fileName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	desc.Translations, err = com.ProjectTranslations(owner, folder, fileName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if desc.Translations == nil {
+		desc.Translations = []com.ProjectTranslation{}
+	}
+	data, err := json.MarshalIndent(desc, "", " ")
+	if err != nil {
+		com.Log.Errorf("Error when JSON marshalling project descriptions: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if com.NotModified(w, r, com.ContentETag(data), time.Time{}) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, "%s", data)
+}
+
+// Returns the list of commits that are different between a source and destination database/branch
+func diffCommitListHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
+
+	// Retrieve source owner
+	o := r.PostFormValue("sourceowner")
+	srcOwner, err := url.QueryUnescape(o)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	err = com.ValidateUser(srcOwner)
+	if err != nil {
+		com.Log.Errorf("Validation failed for username: '%s'- %s", srcOwner, err)
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+
+	// Retrieve source folder
+	f := r.PostFormValue("sourcefolder")
+	srcFolder, err := url.QueryUnescape(f)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	err = com.ValidateFolder(srcFolder)
+	if err != nil {
+		com.Log.Errorf("Validation failed for folder: '%s' - %s", srcFolder, err)
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+
+	// Retrieve source database name
+	d := r.PostFormValue("sourcedbname")
+	srcDBName, err := url.QueryUnescape(d)
+	srcDBName = com.NormaliseName(srcDBName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	err = com.ValidateFileName(srcDBName)
+	if err != nil {
+		com.Log.Errorf("Validation failed for database name '%s': %s", srcDBName, err)
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+
+	// Retrieve source branch name
+	a := r.PostFormValue("sourcebranch")
+	srcBranch, err := url.QueryUnescape(a)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	err = com.ValidateBranchName(srcBranch)
+	if err != nil {
+		com.Log.Errorf("Validation failed for branch name '%s': %s", srcBranch, err)
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+
+	// Retrieve destination owner
+	o = r.PostFormValue("destowner")
+	destOwner, err := url.QueryUnescape(o)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	err = com.ValidateUser(destOwner)
+	if err != nil {
+		com.Log.Errorf("Validation failed for username: '%s'- %s", destOwner, err)
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+
+	// Retrieve destination folder
+	f = r.PostFormValue("destfolder")
+	destFolder, err := url.QueryUnescape(f)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	err = com.ValidateFolder(destFolder)
+	if err != nil {
+		com.Log.Errorf("Validation failed for folder: '%s' - %s", destFolder, err)
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+
+	// Retrieve destination database name
+	d = r.PostFormValue("destdbname")
+	destDBName, err := url.QueryUnescape(d)
+	destDBName = com.NormaliseName(destDBName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	err = com.ValidateFileName(destDBName)
+	if err != nil {
+		com.Log.Errorf("Validation failed for database name '%s': %s", destDBName, err)
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+
+	// Retrieve destination branch name
+	a = r.PostFormValue("destbranch")
+	destBranch, err := url.QueryUnescape(a)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	err = com.ValidateBranchName(destBranch)
+	if err != nil {
+		com.Log.Errorf("Validation failed for branch name '%s': %s", destBranch, err)
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+
+	// Make sure none of the required fields is empty
+	if srcOwner == "" || srcFolder == "" || srcDBName == "" || srcBranch == "" || destOwner == "" || destFolder ==
+		"" || destDBName == "" || destBranch == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Some of the (required) supplied fields are empty")
+		return
+	}
+
+	// Check the databases exist
+	srcExists, err := com.CheckFileExists(loggedInUser, srcOwner, srcFolder, srcDBName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	destExists, err := com.CheckFileExists(loggedInUser, destOwner, destFolder, destDBName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	if !srcExists || !destExists {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, "Invalid database.  One of the source or destination databases doesn't exist")
+		return
+	}
+
+	// Get the commit list diff
+	ancestorID, cList, err, errType := com.GetCommonAncestorCommits(srcOwner, srcFolder, srcDBName, srcBranch, destOwner,
+		destFolder, destDBName, destBranch)
+	if err != nil && errType != http.StatusBadRequest {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+
+	// Make sure the source branch will cleanly apply to the destination.  eg the destination branch hasn't received
+	// additional commits since the source was forked
+	if ancestorID == "" {
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprint(w, "Source branch is not a direct descendent of the destination branch.  Cannot create commit "+
+			"list diff.")
+		return
+	}
+
+	// * Retrieve the current licence for the destination branch *
+
+	// Retrieve the commit ID for the destination branch
+	destBranchList, err := com.GetBranches(destOwner, destFolder, destDBName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	b, ok := destBranchList[destBranch]
+	if !ok {
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, err.Error())
+			return
+		}
+	}
+	destCommitID := b.Commit
+
+	// Retrieve the current licence for the destination branch, using the commit ID
+	commitList, err := com.GetCommitList(destOwner, destFolder, destDBName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	destCommit, ok := commitList[destCommitID]
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, "Destination commit ID not found in commit list.")
+		return
+	}
+	destLicenceSHA := destCommit.Tree.Entries[0].LicenceSHA
+
+	// Convert the commit entries into something we can display in a commit list
+	var x struct {
+		CommitList []com.CommitData `json:"commit_list"`
+	}
+	for _, j := range cList {
+		var c com.CommitData
+		c.AuthorEmail = j.AuthorEmail
+		c.AuthorName = j.AuthorName
+		c.ID = j.ID
+		c.Message = j.Message
+		c.Timestamp = j.Timestamp
+		c.AuthorUsername, c.AuthorAvatar, err = com.GetUsernameFromEmail(j.AuthorEmail)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if c.AuthorAvatar != "" {
+			c.AuthorAvatar += "&s=18"
+		}
+
+		// Check for licence changes
+		commitLicSHA := j.Tree.Entries[0].LicenceSHA
+		if commitLicSHA != destLicenceSHA {
+			lName, _, err := com.GetLicenceInfoFromSha256(srcOwner, commitLicSHA)
+			if err != nil {
+				errorPage(w, r, http.StatusInternalServerError, err.Error())
+				return
+			}
+			c.LicenceChange = fmt.Sprintf("This commit includes a licence change to '%s'", lName)
+		}
+		x.CommitList = append(x.CommitList, c)
+	}
+
+	// Return the commit list
+	y, err := json.MarshalIndent(x, "", " ")
+	if err != nil {
+		com.Log.Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, string(y))
+}
+
+// Sends the data in a database table to the user.  It's CSV by default, or one of the com.ExportFormats when asked
+// for with the format parameter.
+func downloadTableHandler(w http.ResponseWriter, r *http.Request) {
+	pageName := "Download table"
+
+	// Extract the username, database, table, and commit ID requested
+	// NOTE - The commit ID is optional.  Without it, we just pick the latest commit from the (for now) default branch
+	// TODO: Add support for passing in a specific branch, to get the latest commit for that instead
+	owner, fileName, dbTable, commitID, err := com.GetODTC(2, r) // 2 = Ignore "/x/download/" at the start of the URL
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Abort if the table name was missing
+	if dbTable == "" {
+		com.Log.Errorf("%s: Missing table name", pageName)
+		errorPage(w, r, http.StatusBadRequest, "Missing table name")
+		return
+	}
+
+	// Check the requested format is one we know
+	format := strings.ToLower(r.FormValue("format"))
+	exportFormat, ok := com.ExportFormats[format]
+	if format != "" && format != "csv" && !ok {
+		errorPage(w, r, http.StatusBadRequest, "Unknown export format")
+		return
+	}
+
+	loggedInUser := sessionUser(r)
+
+	// Verify the given database exists and is ok to be downloaded (and get the Minio bucket + id while at it)
+	bucket, id, _, err := com.MinioLocation(owner, "/", fileName, commitID, loggedInUser)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Ensure the database being requested isn't overly large
+	var tmp com.SQLiteDBinfo
+	err = com.DBDetails(&tmp, loggedInUser, owner, "/", fileName, commitID)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	size := tmp.Info.DBEntry.Size
+	if size >= 100000000 {
+		errorPage(w, r, http.StatusBadRequest, "Table export not allowed for this database due to size restrictions.")
+		return
+	}
+
+	// Get a handle from Minio for the database object
+	sdb, err := com.OpenMinioObject(bucket, id)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Database query failed")
+		return
+	}
+
+	// Automatically close the SQLite database when this function finishes
+	defer func() {
+		sdb.Close()
+	}()
+
+	// Make sure the table exists before starting the download, as there's no way to report errors afterwards
+	tables, err := com.Tables(sdb, fileName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Error reading table data from the database")
+		return
+	}
+	found := false
+	for _, t := range tables {
+		if t == dbTable {
+			found = true
+			break
+		}
+	}
+	if !found {
+		errorPage(w, r, http.StatusNotFound, "Table not found in the database")
+		return
+	}
+
+	// Stream the table data to the user in the requested format.  Once the first data has been sent the status code
+	// can't be changed, so errors from there on are only logged
+	if ok {
+		if format == "xlsx" {
+			rows, err := com.GetSQLiteRowCount(sdb, dbTable)
+			if err != nil {
+				errorPage(w, r, http.StatusInternalServerError, "Error reading table data from the database")
+				return
+			}
+			if rows >= com.MaxXLSXRows {
+				errorPage(w, r, http.StatusBadRequest, "The table has too many rows for an XLSX spreadsheet")
+				return
+			}
+		}
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, dbTable,
+			exportFormat.Extension))
+		w.Header().Set("Content-Type", exportFormat.ContentType)
+		err = exportFormat.Write(sdb, dbTable, w)
+		if err != nil {
+			com.Log.Errorf("%s: Error when generating %s: %v", pageName, format, err)
+		}
+		return
+	}
+
+	// Was a user agent part of the request?
+	var userAgent string
+	if ua, ok := r.Header["User-Agent"]; ok {
+		userAgent = strings.ToLower(ua[0])
+	}
+
+	// Check if the request came from a Windows based device.  If it did, it'll need CRLF line endings
+	win := strings.Contains(userAgent, "windows")
+
+	// Stream the table data to the user as CSV
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.csv"`, dbTable))
+	w.Header().Set("Content-Type", "text/csv")
+	csvFile := csv.NewWriter(w)
+	csvFile.UseCRLF = win
+	err = com.WriteSQLiteDBCSV(sdb, dbTable, csvFile)
+	if err != nil {
+		com.Log.Errorf("%s: Error when generating CSV: %v", pageName, err)
+		return
+	}
+}
+
+func downloadHandler(w http.ResponseWriter, r *http.Request) {
+	pageName := "Download Handler"
+
+	// NOTE - The commit ID is optional.  Without it, we just pick the latest commit from the (for now) default branch
+	// TODO: Add support for passing in a specific branch, to get the latest commit for that instead
+	owner, fileName, commitID, err := com.GetODC(2, r) // 2 = Ignore "/x/download/" at the start of the URL
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	folder := "/"
+
+	loggedInUser := sessionUser(r)
+
+	// Verify the given database exists and is ok to be downloaded (and get the Minio bucket + id while at it)
+	bucket, id, lastModified, err := com.MinioLocation(owner, folder, fileName, commitID, loggedInUser)
+	if err != nil {
+		// Downloads of projects which have moved (eg been transferred to another user) redirect to the new location
+		if u, ok := movedProjectURL(loggedInUser, owner, folder, fileName); ok {
+			if r.URL.RawQuery != "" {
+				u += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, "/x/download"+u, http.StatusMovedPermanently)
+			return
+		}
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// If the client already has this version of the file, let it know instead of sending it again.  The bucket + id
+	// combination is the sha256 of the file contents, so it works well as an ETag
+	if com.NotModified(w, r, fmt.Sprintf(`"%s"`, bucket+id), lastModified) {
+		return
+	}
+
+	// Get a handle from Minio for the database object
+	userDB, err := com.MinioHandle(bucket, id)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Close the object handle when this function finishes
+	defer func() {
+		com.MinioHandleClose(userDB)
+	}()
+
+	// Get the file details
+	stat, err := userDB.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Web seeds for torrents (and resumed downloads) ask for part of the file.  A single range is supported, and any
+	// other Range header is ignored, sending the whole file
+	start, length, partial := parseByteRange(r.Header.Get("Range"), stat.Size)
+	if partial {
+		_, err = userDB.Seek(start, io.SeekStart)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	// Was a user agent part of the request?
+	var userAgent string
+	if ua, ok := r.Header["User-Agent"]; ok {
+		userAgent = ua[0]
+	}
+
+	// Make a record of the download.  Requests for later parts of the file are part of a download already recorded
+	if start == 0 {
+		err = com.LogDownload(owner, folder, fileName, loggedInUser, r.RemoteAddr, "webui", userAgent,
+			time.Now(), bucket+id)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	// Send the database to the user
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("Content-Disposition", com.AttachmentDisposition(fileName))
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", length))
+	w.Header().Set("Content-Type", "application/x-sqlite3")
+	com.ChecksumHeaders(w.Header(), com.KnownChecksums(bucket+id))
+	if partial {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, stat.Size))
+		w.WriteHeader(http.StatusPartialContent)
+	}
+
+	// Limit the bandwidth used, so bulk downloaders can't saturate the server's uplink.  Anonymous downloads share
+	// the cap for their IP address
+	throttleKey := loggedInUser
+	if throttleKey == "" {
+		throttleKey = "ip:" + clientIP(r)
+	}
+	tw, done := throttleDownload(w, throttleKey)
+	defer done()
+	bytesWritten, err := io.CopyN(tw, userDB, length)
+	if err != nil {
+		com.Log.Errorf("%s: Error returning DB file: %v", pageName, err)
+		fmt.Fprintf(w, "%s: Error returning DB file: %v\n", pageName, err)
+		return
+	}
+
+	// If downloaded by someone other than the owner, increment the download count for the database
+	if start == 0 && strings.ToLower(loggedInUser) != strings.ToLower(owner) {
+		err = com.IncrementDownloadCount(owner, folder, fileName)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	// Log the number of bytes written
+	com.Log.Infof("%s: '%s/%s' downloaded. %d bytes", pageName, owner, fileName, bytesWritten)
+}
+
+func downloadRedashJSONHandler(w http.ResponseWriter, r *http.Request) {
+	pageName := "Download Redash JSON"
+
+	// Extract the username, database, table, and commit ID requested
+	// NOTE - The commit ID is optional.  Without it, we just pick the latest commit from the (for now) default branch
+	// TODO: Add support for passing in a specific branch, to get the latest commit for that instead
+	owner, fileName, dbTable, commitID, err := com.GetODTC(2, r) // 2 = Ignore "/x/download/" at the start of the URL
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Abort if the table name was missing
+	if dbTable == "" {
+		com.Log.Errorf("%s: Missing table name", pageName)
+		errorPage(w, r, http.StatusBadRequest, "Missing table name")
+		return
+	}
+
+	loggedInUser := sessionUser(r)
+
+	// Verify the given database exists and is ok to be downloaded (and get the Minio bucket + id while at it)
+	bucket, id, _, err := com.MinioLocation(owner, "/", fileName, commitID, loggedInUser)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Ensure the database being requested isn't overly large
+	var tmp com.SQLiteDBinfo
+	err = com.DBDetails(&tmp, loggedInUser, owner, "/", fileName, commitID)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	size := tmp.Info.DBEntry.Size
+	if size >= 100000000 {
+		errorPage(w, r, http.StatusBadRequest, "Redash JSON export not allowed for this database due to size "+
+			"restrictions.")
+		return
+	}
+
+	// Get a handle from Minio for the database object
+	sdb, err := com.OpenMinioObject(bucket, id)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Database query failed")
+		return
+	}
+
+	// Automatically close the SQLite database when this function finishes
+	defer func() {
+		sdb.Close()
+	}()
+
+	// Read the table data from the database object
+	resultSet, err := com.ReadSQLiteDBRedash(sdb, dbTable)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Error reading table data from the database")
+		return
+	}
+
+	// Convert the sqlite table data to JSON
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.json"`, dbTable))
+	w.Header().Set("Content-Type", "application/json")
+	j, err := json.MarshalIndent(resultSet, "", " ")
+	if err != nil {
+		com.Log.Error(err)
+		return
+	}
+
+	// If the client already has this data, there's no need to send it again
+	if com.NotModified(w, r, com.ContentETag(j), time.Time{}) {
+		return
+	}
+
+	// Send the JSON to the user
+	w.WriteHeader(http.StatusOK)
+	_, err = fmt.Fprint(w, string(j))
+	if err != nil {
+		com.Log.Errorf("%s: Error when generating JSON: %v", pageName, err)
+		errorPage(w, r, http.StatusInternalServerError, "Error when generating JSON")
+		return
+	}
+}
+
+// Returns the downloads of each version and file of a project over time as JSON, for the project's owner.
+func downloadStatsHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
+	if loggedInUser == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// Extract the owner and database name
+	// TODO: Add folder support
+	owner, fileName, err := com.GetOD(2, r) // 2 = Ignore "/x/downloadstats/" at the start of the URL
+	if err != nil || owner == "" || fileName == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	folder := "/"
+	interval, days, err := com.GetFormDownloadStatsPeriod(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+
+	// The download statistics are only for the project's owner
+	if strings.ToLower(owner) != strings.ToLower(loggedInUser) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	exists, err := com.CheckFileExists(loggedInUser, owner, folder, fileName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	stats, err := com.ProjectDownloadStats(owner, folder, fileName, interval, days)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	data, err := json.MarshalIndent(stats, "", " ")
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, string(data))
+}
+
+// Returns the public projects which already have a model being uploaded, so the upload page can warn about it before
+// the upload goes ahead.  The SHA256 of the file is worked out by the browser, along with the number of triangles in
+// it for binary STL files.
+func duplicatesHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
+
+	// Ensure we have a valid logged in user
+	if loggedInUser == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// Validate the SHA256 and (optional) triangle count
+	sha := strings.ToLower(r.FormValue("sha"))
+	if com.Validate.Var(sha, "hexadecimal,min=64,max=64") != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var numTris int64
+	if t := r.FormValue("tris"); t != "" {
+		var err error
+		numTris, err = strconv.ParseInt(t, 10, 64)
+		if err != nil || numTris < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	list, err := com.DuplicateProjects(loggedInUser, sha, numTris)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if list == nil {
+		list = []com.DuplicateProject{}
+	}
+	data, err := json.MarshalIndent(list, "", " ")
+	if err != nil {
+		com.Log.Error(err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, string(data))
+}
+
+// Returns a page of recent public events (uploads, forks, releases) as JSON, so third parties can build bots and
+// aggregators.  Older pages are retrieved by passing the "next" and "next_id" values from a response back as the
+// "before" and "before_id" arguments.
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+	// If a starting point was given, only return events older than it.  Events sharing its timestamp are told apart by
+	// their ID
+	before := time.Now()
+	if b := r.FormValue("before"); b != "" {
+		var err error
+		before, err = time.Parse(time.RFC3339Nano, b)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, "Invalid 'before' timestamp")
+			return
+		}
+	}
+
+	beforeID := r.FormValue("before_id")
+	if len(beforeID) > 1024 {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Invalid 'before_id' value")
 		return
 	}
 
-	// Get the tag list for the database
-	tags, err := com.GetTags(owner, folder, fileName)
+	// Retrieve the requested page of events
+	evList, err := com.PublicEvents(before, beforeID, com.PublicEventsPageSize)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-
-	// Get the release list for the database
-	rels, err := com.GetReleases(owner, folder, fileName)
+	var e struct {
+		Events []com.PublicEvent `json:"events"`
+		Next   string            `json:"next,omitempty"`
+		NextID string            `json:"next_id,omitempty"`
+	}
+	e.Events = evList
+	if len(evList) == com.PublicEventsPageSize {
+		last := evList[len(evList)-1]
+		e.Next, e.NextID = last.Timestamp.Format(time.RFC3339Nano), last.ID
+	}
+	data, err := json.MarshalIndent(e, "", " ")
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		com.Log.Error(err)
 		return
 	}
 
-	// If the database has tags, walk the commit history for the branch checking if any of the tags are on commits in
-	// this branch
-	branchTags := make(map[string]string)
-	if len(tags) > 0 {
-		// Walk the commit history for the branch checking if any of the tags are on commits in this branch
-		c, ok := commitList[branch.Commit]
-		if !ok {
-			log.Printf("Error when checking for isolated tags while deleting branch '%s' of database "+
-				"'%s%s%s'\n", branchName, owner, folder, fileName)
+	// If the client already has this page of events, there's no need to send it again
+	if com.NotModified(w, r, com.ContentETag(data), time.Time{}) {
+		return
+	}
+
+	// Return the event list
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, string(data))
+}
+
+// Returns an Atom feed of recent activity.  The available feeds are:
+//   /feeds/recent                       - Recent uploads to all public projects
+//   /feeds/user/<user>                  - Recent uploads to a user's public projects
+//   /feeds/tagged/<tag>                 - Recent uploads to public projects with the given tag
+//   /feeds/releases/<owner>/<project>   - Releases of a public project
+func feedHandler(w http.ResponseWriter, r *http.Request) {
+	var entries []com.FeedEntry
+	var sitePath, title string
+	var err error
+	args := strings.Split(strings.TrimPrefix(r.URL.Path, "/feeds/"), "/")
+	switch {
+	case len(args) == 1 && args[0] == "recent":
+		entries, err = com.RecentUploads("", "", com.FeedSize)
+		sitePath = "/"
+		title = fmt.Sprintf("%s - Recent uploads", com.Conf().Web.WebsiteName)
+	case len(args) == 2 && args[0] == "user":
+		if com.ValidateUser(args[1]) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		entries, err = com.RecentUploads(args[1], "", com.FeedSize)
+		sitePath = "/" + args[1]
+		title = fmt.Sprintf("%s - Uploads by %s", com.Conf().Web.WebsiteName, args[1])
+	case len(args) == 2 && args[0] == "tagged":
+		tag := strings.ToLower(args[1])
+		if com.ValidateProjectTag(tag) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		entries, err = com.RecentUploads("", tag, com.FeedSize)
+		sitePath = "/tagged/" + tag
+		title = fmt.Sprintf("%s - Projects tagged '%s'", com.Conf().Web.WebsiteName, tag)
+	case len(args) == 3 && args[0] == "releases":
+		owner, fileName := args[1], com.NormaliseName(args[2])
+		folder := "/"
+		if com.ValidateUserFilename(owner, fileName) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		// Feeds are read anonymously, so only public projects have them
+		exists, err := com.CheckFileExists("", owner, folder, fileName)
+		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		for tName, tEntry := range tags {
-			// Scan through the tags, checking if any of them are for this commit
-			if tEntry.Commit == c.ID {
-				// It's a match, so add this tag to the list of tags on this branch
-				branchTags[tName] = c.ID
-			}
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
 		}
-		for c.Parent != "" {
-			c, ok = commitList[c.Parent]
-			if !ok {
-				log.Printf("Error when checking for isolated tags while deleting branch '%s' of database "+
-					"'%s%s%s'\n", branchName, owner, folder, fileName)
-				w.WriteHeader(http.StatusInternalServerError)
-				return
-			}
-			for tName, tEntry := range tags {
-				// Scan through the tags, checking if any of them are for this commit
-				if tEntry.Commit == c.ID {
-					// It's a match, so add this tag to the list of tags on this branch
-					branchTags[tName] = c.ID
-				}
-			}
+		releases, err := com.GetReleases(owner, folder, fileName)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		sitePath = fmt.Sprintf("/releases/%s%s%s", owner, folder, fileName)
+		title = fmt.Sprintf("%s - Releases of %s%s%s", com.Conf().Web.WebsiteName, owner, folder, fileName)
+		for name, rel := range releases {
+			entries = append(entries, com.FeedEntry{
+				Author:  rel.ReleaserName,
+				Content: rel.Description,
+				ID:      fmt.Sprintf("%s#%s", sitePath, url.PathEscape(name)),
+				Title:   fmt.Sprintf("%s%s%s: %s", owner, folder, fileName, name),
+				Updated: rel.Date,
+				URL:     sitePath,
+			})
+		}
+
+		// Newest releases first
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].Updated.After(entries[j].Updated)
+		})
+		if len(entries) > com.FeedSize {
+			entries = entries[:com.FeedSize]
 		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 
-		// For any tags on commits in this branch, check if they're also on other branches
-		if len(branchTags) > 0 {
-			for bName, bEntry := range branchList {
-				if bName == branchName {
-					// We're only checking "other branches"
-					continue
-				}
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	err = com.WriteAtomFeed(w, title, r.URL.Path, sitePath, entries)
+	if err != nil {
+		com.Log.Errorf("Error when writing Atom feed '%s': %v", r.URL.Path, err)
+	}
+}
 
-				// If there are no tags left to check, we might as well stop further looping
-				if len(branchTags) == 0 {
-					break
-				}
+// Forks a database for the logged in user.
+func forkDBHandler(w http.ResponseWriter, r *http.Request) {
+	// Retrieve username, database name, and commit ID
+	owner, fileName, commitID, err := com.GetODC(2, r) // 2 = Ignore "/x/forkdb/" at the start of the URL
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	folder := "/"
 
-				c := commitList[bEntry.Commit]
-				for tName, tCommit := range branchTags {
-					if c.ID == tCommit {
-						// This commit matches a tag, so remove the tag from the list
-						delete(branchTags, tName)
-					}
-				}
-				for c.Parent != "" {
-					c, ok = commitList[c.Parent]
-					if !ok {
-						log.Printf("Error when checking for isolated tags while deleting branch '%s' of "+
-							"database '%s%s%s'\n", branchName, owner, folder, fileName)
-						w.WriteHeader(http.StatusInternalServerError)
-						return
-					}
-					for tName, tCommit := range branchTags {
-						if c.ID == tCommit {
-							// This commit matches a tag, so remove the tag from the list
-							delete(branchTags, tName)
-						}
-					}
-				}
-			}
-		}
+	// Make sure a database commit ID was given
+	if commitID == "" {
+		errorPage(w, r, http.StatusBadRequest, "No database commit ID given")
+		return
+	}
 
-		// If there are any tags left over which aren't on other branches, abort this branch deletion and tell the user
-		if len(branchTags) > 0 {
-			var conflictedTags string
-			for tName := range branchTags {
-				if conflictedTags == "" {
-					conflictedTags = tName
-				} else {
-					conflictedTags += ", " + tName
-				}
-			}
+	loggedInUser := sessionUser(r)
 
-			w.WriteHeader(http.StatusConflict)
-			if len(branchTags) > 1 {
-				w.Write([]byte(fmt.Sprintf("You need to delete the tags '%s' before you can delete this branch",
-					conflictedTags)))
-			} else {
-				w.Write([]byte(fmt.Sprintf("You need to delete the tag '%s' before you can delete this branch",
-					conflictedTags)))
-			}
+	// Ensure we have a valid logged in user
+	if loggedInUser == "" {
+		// No logged in username, so nothing to update
+		errorPage(w, r, http.StatusBadRequest, "To fork a database, you need to be logged in")
+		return
+	}
+
+	// Check the user has access to the specific version of the source database requested
+	allowed, err := com.CheckFileExists(loggedInUser, owner, folder, fileName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !allowed {
+		errorPage(w, r, http.StatusNotFound, "You don't have access to the requested database")
+		return
+	}
+
+	// Make sure the source and destination owners are different
+	if strings.ToLower(loggedInUser) == strings.ToLower(owner) {
+		errorPage(w, r, http.StatusBadRequest, "Forking your own database in-place doesn't make sense")
+		return
+	}
+
+	// Make sure the user doesn't have a database of the same name already
+	// Note the use of "loggedInUser" for the 2nd parameter in this call, unlike using "owner" in the call above
+	exists, err := com.CheckFileExists(loggedInUser, loggedInUser, folder, fileName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if exists {
+		// Database of the same name already exists
+		errorPage(w, r, http.StatusNotFound, "You already have a database of this name")
+		return
+	}
+
+	// Forks of private projects are private too, so count towards the plan the user is on
+	public, err := com.ProjectPublic(owner, folder, fileName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !public {
+		if err = com.CheckPrivateQuota(loggedInUser); err != nil {
+			errorPage(w, r, http.StatusForbidden, err.Error())
 			return
 		}
 	}
 
-	// If the database has releases, walk the commit history for the branch checking if any of the releases are on
-	// commits in this branch
-	branchRels := make(map[string]string)
-	if len(rels) > 0 {
-		// Walk the commit history for the branch checking if any of the releases are on commits in this branch
-		c, ok := commitList[branch.Commit]
-		if !ok {
-			log.Printf("Error when checking for isolated releases while deleting branch '%s' of database "+
-				"'%s%s%s'\n", branchName, owner, folder, fileName)
-			w.WriteHeader(http.StatusInternalServerError)
+	// Add the forked database info to PostgreSQL
+	_, err = com.ForkDatabase(owner, folder, fileName, loggedInUser)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Add the user to the watch list for the forked database
+	if !exists {
+		err = com.ToggleProjectWatch(loggedInUser, loggedInUser, folder, fileName)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
 			return
 		}
-		for rName, rEntry := range rels {
-			// Scan through the releases, checking if any of them are for this commit
-			if rEntry.Commit == c.ID {
-				// It's a match, so add this release to the list of releases on this branch
-				branchRels[rName] = c.ID
-			}
-		}
-		for c.Parent != "" {
-			c, ok = commitList[c.Parent]
-			if !ok {
-				log.Printf("Error when checking for isolated releases while deleting branch '%s' of database "+
-					"'%s%s%s'\n", branchName, owner, folder, fileName)
-				w.WriteHeader(http.StatusInternalServerError)
-				return
-			}
-			for rName, rEntry := range rels {
-				// Scan through the releases, checking if any of them are for this commit
-				if rEntry.Commit == c.ID {
-					// It's a match, so add this release to the list of releases on this branch
-					branchRels[rName] = c.ID
-				}
-			}
-		}
+	}
+
+	// Invalidate the old memcached entry for the database
+	err = com.InvalidateCacheEntry(loggedInUser, owner, folder, fileName, "") // Empty string indicates "for all versions"
+	if err != nil {
+		// Something went wrong when invalidating memcached entries for the database
+		com.Log.Errorf("Error when invalidating memcache entries: %s", err.Error())
+		return
+	}
+
+	// Log the database fork
+	com.Log.Infof("Database '%s%s%s' forked to user '%s'", owner, folder, fileName, loggedInUser)
+
+	// Update the search index
+	err = com.UpdateSearchIndex(loggedInUser, folder, fileName)
+	if err != nil {
+		com.Log.Errorf("Error when updating the search index: %s", err.Error())
+	}
+
+	// Bounce to the page of the forked database
+	http.Redirect(w, r, com.ProjectPath(loggedInUser, folder, fileName), http.StatusSeeOther)
+}
+
+// Generates a client certificate for the user and gives it to the browser.
+func generateCertHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
+
+	// Ensure we have a valid logged in user
+	if loggedInUser == "" {
+		// No logged in user, so error out
+		errorPage(w, r, http.StatusBadRequest, "Not logged in")
+		return
+	}
+
+	// The certificate gives full access to the user's account, so they need to have recently confirmed who they are
+	if needStepUp(w, r, "/"+url.PathEscape(loggedInUser)) {
+		return
+	}
+
+	// Generate a new certificate
+	newCert, err := com.GenerateClientCert(loggedInUser)
+	if err != nil {
+		com.Log.Errorf("Error generating client certificate for user '%s': %s!", loggedInUser, err)
+		errorPage(w, r, http.StatusInternalServerError, "Error generating client certificate")
+		return
+	}
 
-		// For any releases on commits in this branch, check if they're also on other branches
-		if len(branchRels) > 0 {
-			for bName, bEntry := range branchList {
-				if bName == branchName {
-					// We're only checking "other branches"
-					continue
-				}
+	// Send the client certificate to the user
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.cert.pem"`, loggedInUser))
+	// Note, don't use "application/x-x509-user-cert", otherwise the browser may try to install it!
+	// Useful reference info: https://pki-tutorial.readthedocs.io/en/latest/mime.html
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	w.Write(newCert)
+	return
+}
 
-				// If there are no releases left to check, we might as well stop further looping
-				if len(branchRels) == 0 {
-					break
-				}
+// Imports a thing from Thingiverse or MyMiniFactory, given either its URL or a Thingiverse export archive.  Each of
+// its model files becomes a project, with a README crediting the creator of the thing, and the licence it's under.
+func importHandler(w http.ResponseWriter, r *http.Request) {
+	pageName := "Import handler"
 
-				c := commitList[bEntry.Commit]
-				for rName, rCommit := range branchRels {
-					if c.ID == rCommit {
-						// This commit matches a release, so remove the release from the list
-						delete(branchRels, rName)
-					}
-				}
-				for c.Parent != "" {
-					c, ok = commitList[c.Parent]
-					if !ok {
-						log.Printf("Error when checking for isolated releases while deleting branch '%s' of "+
-							"database '%s%s%s'\n", branchName, owner, folder, fileName)
-						w.WriteHeader(http.StatusInternalServerError)
-						return
-					}
-					for rName, rCommit := range branchRels {
-						if c.ID == rCommit {
-							// This commit matches a release, so remove the release from the list
-							delete(branchRels, rName)
-						}
-					}
-				}
-			}
-		}
+	// Set the maximum accepted file size for uploading
+	r.Body = http.MaxBytesReader(w, r.Body, com.MaxFileSize*1024*1024)
 
-		// If there are any releases left over which aren't on other branches, abort this branch deletion and tell the user
-		if len(branchRels) > 0 {
-			var conflictedRels string
-			for rName := range branchRels {
-				if conflictedRels == "" {
-					conflictedRels = rName
-				} else {
-					conflictedRels += ", " + rName
-				}
-			}
+	loggedInUser := sessionUser(r)
 
-			w.WriteHeader(http.StatusConflict)
-			if len(branchRels) > 1 {
-				w.Write([]byte(fmt.Sprintf("You need to delete the releases '%s' before you can delete this branch",
-					conflictedRels)))
-			} else {
-				w.Write([]byte(fmt.Sprintf("You need to delete the release '%s' before you can delete this branch",
-					conflictedRels)))
-			}
-			return
-		}
+	// Ensure we have a valid logged in user
+	if loggedInUser == "" {
+		errorPage(w, r, http.StatusUnauthorized, "You need to be logged in")
+		return
 	}
 
-	// Make a list of commits in this branch
-	lst := map[string]bool{}
-	c, ok := commitList[branch.Commit]
-	if !ok {
-		log.Printf("Error when creating commit list while deleting branch '%s' of database '%s%s%s'\n",
-			branchName, owner, folder, fileName)
-		w.WriteHeader(http.StatusInternalServerError)
+	// Prepare the form data
+	r.ParseMultipartForm(32 << 20)
+	if err := r.ParseForm(); err != nil {
+		com.Log.Errorf("%s: ParseForm() error: %v", pageName, err)
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
-	lst[c.ID] = true
-	for c.Parent != "" {
-		c, ok = commitList[c.Parent]
-		if !ok {
-			log.Printf("Error when creating commit list while deleting branch '%s' of database '%s%s%s'\n",
-				branchName, owner, folder, fileName)
-			w.WriteHeader(http.StatusInternalServerError)
+
+	// Grab and validate the supplied "public" form field
+	public, err := com.GetPub(r)
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, "Public value incorrect")
+		return
+	}
+
+	// Retrieve the details of the thing, from either the uploaded archive or its URL
+	var thing com.ImportedThing
+	if archive, handler, err := r.FormFile("archive"); err == nil {
+		defer archive.Close()
+		thing, err = com.ReadImportArchive(archive, handler.Size)
+		if err != nil {
+			errorPage(w, r, http.StatusBadRequest, err.Error())
 			return
 		}
-		lst[c.ID] = true
+	} else {
+		thingURL := r.PostFormValue("url")
+		if thingURL == "" {
+			errorPage(w, r, http.StatusBadRequest, "Please give the URL of the thing to import, or upload its "+
+				"export archive")
+			return
+		}
+		thing, err = com.FetchImportURL(thingURL)
+		if err != nil {
+			errorPage(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if len(thing.Files) == 0 {
+		errorPage(w, r, http.StatusBadRequest, fmt.Sprintf("No 3D model files were found for '%s'", thing.Title))
+		return
 	}
 
-	// For each commit, determine if it's only on this branch, and will need to be deleted after the branch
-	for bName, bEntry := range branchList {
-		if bName == branchName {
-			// We only run this comparison from "other branches", not the branch we're deleting
+	// Only the source URL from the other site is kept if it's valid, as it's shown on the project pages
+	sourceURL := thing.SourceURL
+	if com.Validate.Var(sourceURL, "url,min=5,max=255") != nil {
+		sourceURL = ""
+	}
+	oneLineDesc := []rune(thing.Title)
+	if len(oneLineDesc) > 120 {
+		oneLineDesc = append(oneLineDesc[:119], '…')
+	}
+	readme := thing.Readme()
+	if com.ValidateReadme(readme) != nil {
+		thing.Description = ""
+		readme = thing.Readme()
+	}
+	commitMsg := fmt.Sprintf("Imported from %s", thing.Site)
+
+	// Add each of the model files as a project.  Ones which can't be added are skipped, so long as some of the
+	// others can be
+	folder := "/"
+	var imported []string
+	var problems []string
+	for _, f := range thing.Files {
+		fileName := com.NormaliseName(f.Name)
+		if err = com.ValidateFileName(fileName); err != nil {
+			problems = append(problems, fmt.Sprintf("'%s' isn't a valid file name", fileName))
 			continue
 		}
-
-		// If there are no commits left to check, we might as well stop further looping
-		if len(lst) == 0 {
-			break
+		exists, err := com.CheckFileExists(loggedInUser, loggedInUser, folder, fileName)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if exists {
+			problems = append(problems, fmt.Sprintf("You already have a project called '%s'", fileName))
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
 		}
+		_, _, err = com.AddFile(r, loggedInUser, loggedInUser, folder, fileName, false, "", "", public,
+			thing.Licence, commitMsg, sourceURL, rc, "webui", time.Now(), time.Time{}, "", "", "", "", nil, "", false)
+		rc.Close()
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("'%s' couldn't be added: %s", fileName, err))
+			continue
+		}
+		imported = append(imported, fileName)
 
-		c, ok = commitList[bEntry.Commit]
-		if !ok {
-			err = fmt.Errorf("Broken commit history encountered when checking for commits while deleting "+
-				"branch '%s' of database '%s%s%s'\n", branchName, owner, folder, fileName)
-			log.Print(err.Error()) // Broken commit history is pretty serious, so we log it for admin investigation
-			return
+		// Add the description and README crediting the creator
+		err = com.StoreProjectDescriptions(loggedInUser, folder, fileName, string(oneLineDesc), "")
+		if err != nil {
+			com.Log.Errorf("%s: Error when storing the description for '%s%s%s': %v", pageName, loggedInUser,
+				folder, fileName, err)
 		}
-		for delCommit := range lst {
-			if c.ID == delCommit {
-				// The commit is also on another branch, so we *must not* delete the commit afterwards
-				delete(lst, c.ID)
-			}
+		err = com.StoreProjectReadme(loggedInUser, folder, fileName, readme)
+		if err != nil {
+			com.Log.Errorf("%s: Error when storing the README for '%s%s%s': %v", pageName, loggedInUser, folder,
+				fileName, err)
 		}
-		for c.Parent != "" {
-			c, ok = commitList[c.Parent]
-			if !ok {
-				err = fmt.Errorf("Broken commit history encountered when checking for commits while "+
-					"deleting branch '%s' of database '%s%s%s'\n", branchName, owner, folder, fileName)
-				log.Print(err.Error()) // Broken commit history is pretty serious, so we log it for admin investigation
-				return
-			}
-			for delCommit := range lst {
-				if c.ID == delCommit {
-					// The commit is also on another branch, so we *must not* delete the commit afterwards
-					delete(lst, c.ID)
-				}
-			}
+		err = com.InvalidateCacheEntry(loggedInUser, loggedInUser, folder, fileName, "")
+		if err != nil {
+			com.Log.Errorf("Error when invalidating memcache entries: %s", err.Error())
+		}
+
+		// Check the details of public projects for spam
+		if public {
+			checkProjectSpam(r, loggedInUser, folder, fileName, fileName+"\n"+readme)
 		}
+
+		// Update the search index
+		err = com.UpdateSearchIndex(loggedInUser, folder, fileName)
+		if err != nil {
+			com.Log.Errorf("Error when updating the search index: %s", err.Error())
+		}
+	}
+	if len(imported) == 0 {
+		errorPage(w, r, http.StatusBadRequest, fmt.Sprintf("Nothing could be imported from '%s'. %s", thing.Title,
+			strings.Join(problems, ". ")))
+		return
+	}
+	com.Log.Infof("%s: Username: '%s', imported '%s' from %s as: %s", pageName, loggedInUser, thing.SourceURL,
+		thing.Site, strings.Join(imported, ", "))
+	if len(problems) != 0 {
+		com.Log.Infof("%s: Files skipped when importing '%s': %s", pageName, thing.SourceURL,
+			strings.Join(problems, ". "))
 	}
 
-	// Delete the branch
-	delete(branchList, branchName)
-	err = com.StoreBranches(owner, folder, fileName, branchList)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+	// Bounce the user to the new project, or to their own page if several were created
+	if len(imported) == 1 {
+		http.Redirect(w, r, com.ProjectPath(loggedInUser, "/", imported[0]), http.StatusSeeOther)
 		return
 	}
+	http.Redirect(w, r, "/"+loggedInUser, http.StatusSeeOther)
+}
 
-	// Delete the left over commits
-	// TODO: We may want to consider clearing any memcache entries for the deleted commits too
-	for cid := range lst {
-		delete(commitList, cid)
+// Receives the webhook requests GitHub sends when something is pushed to a repository, queuing a sync of the GitHub
+// import the webhook was set up for.  The requests are signed using the secret for the import.
+func githubHookHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/x/githubhook/"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
 	}
-	err = com.StoreCommits(owner, folder, fileName, commitList)
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 25<<20))
 	if err != nil {
-		log.Printf("Error when updating commit list while deleting branch '%s' of database '%s%s%s': %s\n",
-			branchName, owner, folder, fileName, err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	imp, err := com.GitHubImport(id)
+	if err != nil || !com.CheckGitHubSignature(imp.WebhookSecret, body, r.Header.Get("X-Hub-Signature-256")) {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, "Unknown import, or the signature doesn't match")
 		return
 	}
 
-	// Invalidate the memcache data for the database, so the new branch count gets picked up
-	err = com.InvalidateCacheEntry(loggedInUser, owner, folder, fileName, "") // Empty string indicates "for all versions"
+	// Only pushes to the branch being imported need a sync
+	if r.Header.Get("X-GitHub-Event") != "push" {
+		fmt.Fprint(w, "ok")
+		return
+	}
+	var push struct {
+		Ref string `json:"ref"`
+	}
+	err = json.Unmarshal(body, &push)
 	if err != nil {
-		// Something went wrong when invalidating memcached entries for the database
-		log.Printf("Error when invalidating memcache entries: %s\n", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "The webhook needs to use the application/json content type")
 		return
 	}
-
-	// Update succeeded
-	w.WriteHeader(http.StatusOK)
-}
-
-// This function deletes a given comment from a discussion.
-func deleteCommentHandler(w http.ResponseWriter, r *http.Request) {
-	// Retrieve session data (if any)
-	var loggedInUser string
-	var u interface{}
-	validSession := false
-	if com.Conf.Environment.Environment != "docker" {
-		sess, err := store.Get(r, "3dhub-user")
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
-		u = sess.Values["UserName"]
-	} else {
-		u = "default"
+	if push.Ref != "refs/heads/"+imp.Branch {
+		fmt.Fprint(w, "Ignored, as it's not for the branch being imported")
+		return
 	}
-	if u != nil {
-		loggedInUser = u.(string)
-		validSession = true
+	err = com.QueueGitHubSync(id)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
 	}
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprint(w, "Sync queued")
+}
+
+// Imports the 3D model files from a GitHub repository (or a folder in one) as projects.  The first sync is done
+// straight away, so any problems can be shown.
+func githubImportHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
 
 	// Ensure we have a valid logged in user
-	if validSession != true {
-		w.WriteHeader(http.StatusUnauthorized)
-		fmt.Fprint(w, "You need to be logged in")
+	if loggedInUser == "" {
+		errorPage(w, r, http.StatusUnauthorized, "You need to be logged in")
 		return
 	}
 
-	// Extract and validate the form variables
-	owner, folder, fileName, err := com.GetUFD(r, false)
+	// Validate the form data
+	public, err := com.GetPub(r)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprint(w, "Missing or incorrect data supplied")
+		errorPage(w, r, http.StatusBadRequest, "Public value incorrect")
+		return
+	}
+	licence, err := com.GetFormLicence(r)
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, "Validation failed for licence value")
+		return
+	}
+	schedule := r.PostFormValue("schedule")
+	switch schedule {
+	case "never", "hourly", "daily":
+	default:
+		errorPage(w, r, http.StatusBadRequest, "Unknown sync schedule")
+		return
+	}
+	repo, branch, folder, err := com.ParseGitHubURL(r.PostFormValue("url"))
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	defBranch, private, err := com.GitHubDefaultBranch(repo, "")
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if private {
+		errorPage(w, r, http.StatusBadRequest, "Only public GitHub repositories can be imported")
 		return
 	}
+	if branch == "" {
+		branch = defBranch
+	}
 
-	// Ensure a discussion ID was given
-	a := r.PostFormValue("discid")
-	if a == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprint(w, "Missing discussion id")
+	// Add the import, then do the first sync
+	secret := make([]byte, 20)
+	if _, err = rand.Read(secret); err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	id, err := com.StoreGitHubImport(com.GitHubImportEntry{Branch: branch, Licence: licence, Owner: loggedInUser,
+		Path: folder, Public: public, Repo: repo, Schedule: schedule, WebhookSecret: hex.EncodeToString(secret)})
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	created, problems, err := com.SyncGitHubImport(id)
+	if err == nil && len(created) == 0 {
+		err = fmt.Errorf("No 3D model files could be imported from '%s'. %s", repo, strings.Join(problems, ". "))
+	}
+	if err != nil {
+		com.DeleteGitHubImport(id)
+		errorPage(w, r, http.StatusBadRequest, err.Error())
 		return
 	}
-	discID, err := strconv.Atoi(a)
-	if err != nil {
-		log.Printf("Error converting string '%s' to integer in function '%s': %s\n", a,
-			com.GetCurrentFunctionName(), err)
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprint(w, "Error when parsing discussion id value")
+
+	// Check the details of public projects for spam
+	if public {
+		for _, fileName := range created {
+			checkProjectSpam(r, loggedInUser, "/", fileName, fileName)
+		}
+	}
+
+	// Bounce the user to the settings page of the new project (which has the webhook details), or to their own page
+	// if several were created
+	if len(created) == 1 {
+		http.Redirect(w, r, fmt.Sprintf("/settings/%s/%s", loggedInUser, created[0]), http.StatusSeeOther)
 		return
 	}
+	http.Redirect(w, r, "/"+loggedInUser, http.StatusSeeOther)
+}
 
-	// Ensure a comment ID was given
-	a = r.PostFormValue("comid")
-	if a == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprint(w, "Missing comment id")
+// Sets up, pushes, or removes the mirroring of a project to a GitHub repository, from its settings page.
+func githubMirrorHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
+
+	// Ensure we have a valid logged in user
+	if loggedInUser == "" {
+		errorPage(w, r, http.StatusUnauthorized, "You need to be logged in")
 		return
 	}
-	comID, err := strconv.Atoi(a)
+
+	// Only the owner of a project can mirror it
+	owner, folder, fileName, err := com.GetUFD(r, false)
 	if err != nil {
-		log.Printf("Error converting string '%s' to integer in function '%s': %s\n", a,
-			com.GetCurrentFunctionName(), err)
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprint(w, "Error when parsing comment id value")
+		errorPage(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if strings.ToLower(owner) != strings.ToLower(loggedInUser) {
+		errorPage(w, r, http.StatusForbidden, "Only the owner of a project can mirror it to GitHub")
 		return
 	}
-
-	// Check if the requested database exists
 	exists, err := com.CheckFileExists(loggedInUser, owner, folder, fileName)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, err.Error())
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
 	if !exists {
-		w.WriteHeader(http.StatusNotFound)
-		fmt.Fprintf(w, "Database '%s%s%s' doesn't exist", owner, folder, fileName)
+		errorPage(w, r, http.StatusNotFound, "That project doesn't exist")
 		return
 	}
 
-	// Check if the logged in user is allowed to delete the requested comment
-	deleteAllowed := false
-	if strings.ToLower(owner) == strings.ToLower(loggedInUser) {
-		// The database owner can delete any discussion comment on their databases
-		deleteAllowed = true
-	} else {
-		// Retrieve the details for the requested comment, so we can check if the logged in user is the comment creator
-		rq, err := com.DiscussionComments(owner, folder, fileName, discID, comID)
+	// Stopping the mirroring needs the user to have recently confirmed who they are
+	if r.PostFormValue("action") == "remove" && needStepUp(w, r, fmt.Sprintf("/settings/%s%s%s", owner, folder,
+		fileName)) {
+		return
+	}
+
+	switch r.PostFormValue("action") {
+	case "push":
+		err = com.QueueGitHubMirror(owner, folder, fileName)
+	case "remove":
+		err = com.DeleteGitHubMirror(owner, folder, fileName)
+	case "save":
+		var repo, branch string
+		repo, branch, _, err = com.ParseGitHubURL(r.PostFormValue("repo"))
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprint(w, err.Error())
+			errorPage(w, r, http.StatusBadRequest, err.Error())
 			return
 		}
-		if strings.ToLower(rq[0].Commenter) == strings.ToLower(loggedInUser) {
-			deleteAllowed = true
+		if b := r.PostFormValue("branch"); b != "" {
+			branch = b
+		}
+		repoPath := strings.Trim(r.PostFormValue("path"), "/")
+		if repoPath == "" {
+			repoPath = fileName
+		}
+		if strings.Contains("/"+repoPath+"/", "/../") || strings.Contains(repoPath, "//") {
+			errorPage(w, r, http.StatusBadRequest, "Invalid path for the file in the repository")
+			return
 		}
-	}
 
-	// If the logged in user isn't allowed to delete the requested comment, then reject the request
-	if !deleteAllowed {
-		w.WriteHeader(http.StatusUnauthorized)
+		// A token is needed the first time, and it needs to be able to read the repository
+		token := strings.TrimSpace(r.PostFormValue("token"))
+		checkToken := token
+		if checkToken == "" {
+			m, found, err := com.GitHubMirror(owner, folder, fileName)
+			if err != nil {
+				errorPage(w, r, http.StatusInternalServerError, err.Error())
+				return
+			}
+			if !found {
+				errorPage(w, r, http.StatusBadRequest, "An access token for the GitHub repository is needed")
+				return
+			}
+			checkToken = m.Token
+		}
+		var defBranch string
+		defBranch, _, err = com.GitHubDefaultBranch(repo, checkToken)
+		if err != nil {
+			errorPage(w, r, http.StatusBadRequest, fmt.Sprintf("Couldn't access the GitHub repository: %s", err))
+			return
+		}
+		if branch == "" {
+			branch = defBranch
+		}
+		err = com.StoreGitHubMirror(owner, folder, fileName, repo, branch, repoPath, token)
+		if err == nil {
+			err = com.QueueGitHubMirror(owner, folder, fileName)
+		}
+	default:
+		errorPage(w, r, http.StatusBadRequest, "Unknown action")
 		return
 	}
-
-	// Delete the comment from PostgreSQL
-	err = com.DeleteComment(owner, folder, fileName, discID, comID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, err.Error())
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
-	w.WriteHeader(http.StatusOK)
-}
 
-// This function deletes the latest commit from a given branch.
-func deleteCommitHandler(w http.ResponseWriter, r *http.Request) {
-	pageName := "Delete commit handler"
+	// Return to the settings page
+	http.Redirect(w, r, "/settings"+com.ProjectPath(owner, folder, fileName), http.StatusSeeOther)
+}
 
-	// Retrieve session data (if any)
-	var loggedInUser string
-	var u interface{}
-	validSession := false
-	if com.Conf.Environment.Environment != "docker" {
-		sess, err := store.Get(r, "3dhub-user")
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
-		u = sess.Values["UserName"]
-	} else {
-		u = "default"
-	}
-	if u != nil {
-		loggedInUser = u.(string)
-		validSession = true
-	}
+// Syncs a GitHub import straight away, or stops it from being synced, from the settings page of one of its projects.
+func githubSyncHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
 
 	// Ensure we have a valid logged in user
-	if validSession != true {
-		w.WriteHeader(http.StatusUnauthorized)
+	if loggedInUser == "" {
+		errorPage(w, r, http.StatusUnauthorized, "You need to be logged in")
 		return
 	}
 
-	// Extract the required form variables
-	usr, folder, fileName, err := com.GetUFD(r, false)
+	// Look up the import, making sure it's for the logged in user
+	id, err := strconv.ParseInt(r.PostFormValue("id"), 10, 64)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		errorPage(w, r, http.StatusBadRequest, "Unknown GitHub import")
 		return
 	}
-	owner := strings.ToLower(usr)
-
-	// Validate the supplied commit ID
-	commit, err := com.GetFormCommit(r)
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+	imp, err := com.GitHubImport(id)
+	if err != nil || strings.ToLower(imp.Owner) != strings.ToLower(loggedInUser) {
+		errorPage(w, r, http.StatusBadRequest, "Unknown GitHub import")
 		return
 	}
 
-	// Validate the supplied branch name
-	branchName, err := com.GetFormBranch(r)
+	switch r.PostFormValue("action") {
+	case "stop":
+		err = com.DeleteGitHubImport(id)
+	case "sync":
+		err = com.QueueGitHubSync(id)
+	default:
+		errorPage(w, r, http.StatusBadRequest, "Unknown action")
+		return
+	}
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	// If any of the required values were empty, indicate failure
-	if branchName == "" || folder == "" || fileName == "" || owner == "" || commit == "" {
-		w.WriteHeader(http.StatusBadRequest)
+	// Return to the settings page
+	http.Redirect(w, r, "/settings/"+strings.TrimPrefix(r.PostFormValue("project"), "/"), http.StatusSeeOther)
+}
+
+// Sends the owner of a project a file waiting for their approval, so they can check it first.
+func guestDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
+
+	// Ensure we have a valid logged in user
+	if loggedInUser == "" {
+		errorPage(w, r, http.StatusUnauthorized, "You need to be logged in")
 		return
 	}
 
-	// Make sure the database exists in the system
-	exists, err := com.CheckFileExists(loggedInUser, owner, folder, fileName)
-	if err != err {
-		w.WriteHeader(http.StatusInternalServerError)
+	// Only the owner of the project can download the files waiting for approval
+	owner, folder, fileName, err := com.GetUFD(r, true)
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, err.Error())
 		return
 	}
-	if !exists {
-		log.Printf("%s: Validation failed for database name: %s", pageName, err)
-		w.WriteHeader(http.StatusNotFound)
+	if strings.ToLower(owner) != strings.ToLower(loggedInUser) {
+		errorPage(w, r, http.StatusForbidden, "Only the owner of a project can download its guest uploads")
 		return
 	}
-
-	// Make sure the database is owned by the logged in user. eg prevent changes to other people's databases
-	if strings.ToLower(owner) != strings.ToLower(loggedInUser) {
-		w.WriteHeader(http.StatusBadRequest)
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, "Invalid upload ID")
 		return
 	}
-
-	// Load the existing branchHeads for the database
-	branches, err := com.GetBranches(loggedInUser, folder, fileName)
+	u, err := com.GuestUpload(owner, folder, fileName, id)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		errorPage(w, r, http.StatusNotFound, err.Error())
 		return
 	}
-
-	// Make sure the given branch exists
-	b, ok := branches[branchName]
-	if !ok {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("Unknown branch name"))
+	obj, err := com.MinioHandle(u.Sha256[:com.MinioFolderChars], u.Sha256[com.MinioFolderChars:])
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
+	defer com.MinioHandleClose(obj)
 
-	// Check that the given commit matches the head commit of the branch
-	if b.Commit != commit {
-		w.WriteHeader(http.StatusConflict)
-		w.Write([]byte("Only the most recent commit for a branch can be deleted"))
-		return
+	w.Header().Set("Content-Disposition", com.AttachmentDisposition(fileName))
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", u.Size))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if _, err = io.Copy(w, obj); err != nil {
+		com.Log.Errorf("Error returning guest upload %d for '%s%s%s': %v", id, owner, folder, fileName, err)
 	}
+}
 
-	// Determine the commit ID we'll be rewinding to
-	commitList, err := com.GetCommitList(owner, folder, fileName)
+// Receives a file sent through a guest upload link, and lets the owner of the project know it's waiting for them.
+func guestUploadHandler(w http.ResponseWriter, r *http.Request) {
+	// Set the maximum accepted file size for uploading
+	r.Body = http.MaxBytesReader(w, r.Body, com.MaxFileSize*1024*1024+1<<20)
+	r.ParseMultipartForm(32 << 20)
+
+	token := r.PostFormValue("token")
+	owner, folder, fileName, found, err := com.GuestUploadLinkProject(token)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-	c, ok := commitList[commit]
-	if !ok {
-		w.WriteHeader(http.StatusNotFound)
-		w.Write([]byte("Requested commit not found"))
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
-	if c.Parent == "" {
-		w.WriteHeader(http.StatusConflict)
-		w.Write([]byte("Requested commit has no ancestors.  Not going to delete it"))
+	if !found {
+		errorPage(w, r, http.StatusNotFound, "That upload link doesn't exist, or has expired")
 		return
 	}
-	prevCommit := c.Parent
 
-	// If we're working on the default branch, check if the default table is present in the prior commit's version
-	// of the database.  If it's not, we need to clear the default table value
-	defBranch, err := com.GetDefaultBranchName(owner, folder, fileName)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+	// Validate the details given by the guest
+	name := strings.TrimSpace(r.PostFormValue("name"))
+	if name == "" || com.ValidateDisplayName(name) != nil {
+		errorPage(w, r, http.StatusBadRequest, "Please go back and give a valid name")
 		return
 	}
-	if branchName == defBranch {
-		// * Retrieve the list of tables present in the prior commit *
-		bkt, id, _, err := com.MinioLocation(owner, folder, fileName, prevCommit, loggedInUser)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-
-		// Get a handle from Minio for the SQLite database object
-		sdb, err := com.OpenMinioObject(bkt, id)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-
-		// Automatically close the SQLite database when this function finishes
-		defer func() {
-			sdb.Close()
-		}()
-
-		// Retrieve the list of tables in the database
-		sTbls, err := com.Tables(sdb, fmt.Sprintf("%s%s%s", owner, folder, fileName))
-		defer sdb.Close()
-		if err != nil {
-			errorPage(w, r, http.StatusInternalServerError, err.Error())
-			return
-		}
-
-		// Retrieve the default table name for the database
-		defTbl, err := com.GetDefaultTableName(owner, folder, fileName)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-		defFound := false
-		for _, j := range sTbls {
-			if j == defTbl {
-				defFound = true
-			}
-		}
-		if !defFound {
-			// The default table is present in the previous commit, so we clear the default table value
-			err = com.StoreDefaultTableName(owner, folder, fileName, "")
-			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
-				return
-			}
-		}
-	}
-
-	// Delete the commit
-	iTags, iRels, err := com.DeleteBranchHistory(owner, folder, fileName, branchName, prevCommit)
-	if err != nil {
-		if (len(iTags) > 0) || (len(iRels) > 0) {
-			msg := fmt.Sprintln("You need to delete the following tags and releases before the commit can be " +
-				"deleted:")
-			var rList, tList string
-			if len(iTags) > 0 {
-				// Would-be-isolated tags were identified.  Warn the user.
-				msg += "  TAGS: "
-				for _, tName := range iTags {
-					if tList == "" {
-						msg += fmt.Sprintf("'%s'", tName)
-					} else {
-						msg += fmt.Sprintf(", '%s'", tName)
-					}
-				}
-			}
-			if len(iRels) > 0 {
-				// Would-be-isolated releases were identified.  Warn the user.
-				msg += "  RELEASES: "
-				for _, rName := range iRels {
-					if rList == "" {
-						msg += fmt.Sprintf("'%s'", rName)
-					} else {
-						msg += fmt.Sprintf(", '%s'", rName)
-					}
-				}
-			}
-			w.WriteHeader(http.StatusConflict)
-			w.Write([]byte(msg))
-			return
-		}
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte("Commit deletion failed, internal server error"))
+	email := strings.TrimSpace(r.PostFormValue("email"))
+	if email != "" && com.ValidateEmail(email) != nil {
+		errorPage(w, r, http.StatusBadRequest, "Please go back and give a valid email address, or none")
 		return
 	}
+	message := strings.TrimSpace(r.PostFormValue("message"))
+	if message != "" && com.ValidateMarkdown(message) != nil {
+		errorPage(w, r, http.StatusBadRequest, "Validation failed for the message")
+		return
+	}
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, "File missing from upload data?")
+		return
+	}
+	defer file.Close()
 
-	// Invalidate the memcache data for the database, so the new branch count gets picked up
-	err = com.InvalidateCacheEntry(loggedInUser, owner, folder, fileName, "") // Empty string indicates "for all versions"
+	size, err := com.AddGuestUpload(token, clientIP(r), name, email, message, file)
 	if err != nil {
-		// Something went wrong when invalidating memcached entries for the database
-		log.Printf("Error when invalidating memcache entries: %s\n", err.Error())
+		errorPage(w, r, http.StatusBadRequest, err.Error())
 		return
 	}
+	com.Log.Infof("Guest upload of %d bytes to '%s%s%s' from %s", size, owner, folder, fileName, clientIP(r))
 
-	// Update succeeded
-	w.WriteHeader(http.StatusOK)
+	// Let the owner know
+	com.EmailUser(owner, fmt.Sprintf("3DHub.io: A file has been sent to %s%s%s", owner, folder, fileName),
+		fmt.Sprintf("%s has sent a file to your project '%s%s%s' using a guest upload link.  To approve or reject it, "+
+			"visit https://%s/settings/%s%s%s#guestuploads", name, owner, folder, fileName, com.Conf().Web.ServerName,
+			owner, folder, fileName))
+	http.Redirect(w, r, "/guestupload/"+url.PathEscape(token)+"?sent=1", http.StatusSeeOther)
 }
 
-// This function deletes a database.
-func deleteDatabaseHandler(w http.ResponseWriter, r *http.Request) {
-	pageName := "Delete Database handler"
-
-	// Retrieve session data (if any)
-	var loggedInUser string
-	var u interface{}
-	validSession := false
-	if com.Conf.Environment.Environment != "docker" {
-		sess, err := store.Get(r, "3dhub-user")
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
-		u = sess.Values["UserName"]
-	} else {
-		u = "default"
-	}
-	if u != nil {
-		loggedInUser = u.(string)
-		validSession = true
-	}
+// Creates or revokes guest upload links for a project, and approves or rejects the files sent through them, from
+// the project's settings page.
+func guestUploadsHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
 
 	// Ensure we have a valid logged in user
-	if validSession != true {
-		w.WriteHeader(http.StatusUnauthorized)
-		fmt.Fprint(w, "You need to be logged in")
+	if loggedInUser == "" {
+		errorPage(w, r, http.StatusUnauthorized, "You need to be logged in")
 		return
 	}
 
-	// Extract the required form variables
-	usr, folder, fileName, err := com.GetUFD(r, false)
+	// Only the owner of a project can manage its guest uploads
+	owner, folder, fileName, err := com.GetUFD(r, false)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprint(w, "Validation failed for owner or database name")
+		errorPage(w, r, http.StatusBadRequest, err.Error())
 		return
 	}
-	owner := strings.ToLower(usr)
-
-	// If any of the required values were empty, indicate failure
-	if folder == "" || fileName == "" || owner == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprint(w, "Owner, folder, or database name values() missing")
+	if strings.ToLower(owner) != strings.ToLower(loggedInUser) {
+		errorPage(w, r, http.StatusForbidden, "Only the owner of a project can manage its guest uploads")
 		return
 	}
-
-	// Make sure the database exists in the system
 	exists, err := com.CheckFileExists(loggedInUser, owner, folder, fileName)
-	if err != err {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, "Internal server error")
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
 	if !exists {
-		log.Printf("%s: Missing database for '%s%s%s' when attempting deletion\n", pageName, owner, folder,
-			fileName)
-		w.WriteHeader(http.StatusNotFound)
-		fmt.Fprint(w, "Internal server error")
+		errorPage(w, r, http.StatusNotFound, "That project doesn't exist")
 		return
 	}
 
-	// Make sure the database is owned by the logged in user. eg prevent changes to other people's databases
-	if strings.ToLower(owner) != strings.ToLower(loggedInUser) {
-		w.WriteHeader(http.StatusUnauthorized)
-		fmt.Fprint(w, "You don't have permission to delete that database")
+	action := r.PostFormValue("action")
+	var id int64
+	if action != "newlink" {
+		id, err = strconv.ParseInt(r.PostFormValue("id"), 10, 64)
+		if err != nil {
+			errorPage(w, r, http.StatusBadRequest, "Invalid ID")
+			return
+		}
+	}
+	switch action {
+	case "approve":
+		_, err = com.ApproveGuestUpload(r, owner, folder, fileName, id)
+		if err != nil {
+			errorPage(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		// Invalidate the memcache data for the project, so the new version shows up
+		err = com.InvalidateCacheEntry(loggedInUser, owner, folder, fileName, "")
+		if err != nil {
+			com.Log.Errorf("Error when invalidating memcache entries: %s", err.Error())
+		}
+	case "newlink":
+		days, err := strconv.Atoi(r.PostFormValue("days"))
+		if err != nil || days < 1 || days > 30 {
+			errorPage(w, r, http.StatusBadRequest, "Links can last between 1 and 30 days")
+			return
+		}
+		b := make([]byte, 16)
+		if _, err = rand.Read(b); err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+		err = com.StoreGuestUploadLink(owner, folder, fileName, hex.EncodeToString(b),
+			time.Now().Add(time.Duration(days)*24*time.Hour))
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+	case "reject":
+		err = com.DeleteGuestUpload(owner, folder, fileName, id)
+	case "revoke":
+		err = com.DeleteGuestUploadLink(owner, folder, fileName, id)
+	default:
+		errorPage(w, r, http.StatusBadRequest, "Unknown action")
+		return
+	}
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	// Invalidate the memcache data for the database, so the new branch count gets picked up
-	// Note - on one hand this is a race condition, as new cache data could get into memcache between this invalidation
-	// call and the delete.  On the other hand, once it's deleted the invalidation function would itself fail due to
-	// needing the database to be present so it can look up the commit list.  At least doing the invalidation here lets
-	// us clear stale data (hopefully) for the vast majority of the time
-	err = com.InvalidateCacheEntry(loggedInUser, owner, folder, fileName, "") // Empty string indicates "for all versions"
+	// Return to the settings page
+	http.Redirect(w, r, "/settings"+com.ProjectPath(owner, folder, fileName)+"#guestuploads", http.StatusSeeOther)
+}
+
+// Removes the logged in users session information.
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	// Remove session info
+	sess, err := store.Get(r, "3dhub-user")
 	if err != nil {
-		// Something went wrong when invalidating memcached entries for the database
-		log.Printf("Error when invalidating memcache entries: %s\n", err.Error())
+		errorPage(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	// Note : gorilla/sessions uses MaxAge < 0 to mean "delete this session"
+	sess.Options.MaxAge = -1
+	err = sess.Save(r, w)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	// Delete the database
-	err = com.DeleteDatabase(owner, folder, fileName)
-	if err != err {
+	// Bounce to the front page
+	// TODO: This should probably reload the existing page instead
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// Returns the licences available to the logged in user (or the default ones for anonymous users) as JSON, with
+// their SPDX identifiers, for automated licence compliance tools.  They're listed in their display order.
+func licencesHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
+
+	lics, err := com.GetLicences(loggedInUser)
+	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, "Internal server error")
+		return
+	}
+	type licence struct {
+		ID string `json:"id"`
+		com.LicenceEntry
+	}
+	list := []licence{}
+	for name, l := range lics {
+		list = append(list, licence{ID: name, LicenceEntry: l})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Order < list[j].Order
+	})
+	data, err := json.MarshalIndent(list, "", " ")
+	if err != nil {
+		com.Log.Error(err)
 		return
 	}
 
-	// Update succeeded
+	// If the client already has this list, there's no need to send it again
+	if com.NotModified(w, r, com.ContentETag(data), time.Time{}) {
+		return
+	}
+
+	// Return the licence list
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, string(data))
 }
 
-// This function deletes a release.
-func deleteReleaseHandler(w http.ResponseWriter, r *http.Request) {
-	pageName := "Delete Release handler"
-
-	// Retrieve session data (if any)
-	var loggedInUser string
-	var u interface{}
-	validSession := false
-	if com.Conf.Environment.Environment != "docker" {
-		sess, err := store.Get(r, "3dhub-user")
+// Serves the web interface using TLS certificates obtained (and renewed) automatically from Let's Encrypt, instead of
+// certificate files given in the configuration.  A plain HTTP listener is started too, for answering the ACME HTTP-01
+// challenges.  Other requests to that listener are redirected to HTTPS.
+func listenAutocert(handler http.Handler) error {
+	// Only request certificates for our own server name
+	host := com.Conf().Web.ServerName
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	m := &autocert.Manager{
+		Cache:      autocert.DirCache(com.Conf().Web.AutocertCacheDir),
+		Email:      com.Conf().Web.AutocertEmail,
+		HostPolicy: autocert.HostWhitelist(host),
+		Prompt:     autocert.AcceptTOS,
+	}
+
+	// Start the challenge listener
+	go func() {
+		com.Log.Infof("ACME challenge listener starting on %s", com.Conf().Web.AutocertHTTPAddress)
+		err := http.ListenAndServe(com.Conf().Web.AutocertHTTPAddress, m.HTTPHandler(nil))
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			return
+			com.Log.Errorf("ACME challenge listener failed: %v", err)
 		}
-		u = sess.Values["UserName"]
-	} else {
-		u = "default"
+	}()
+
+	server := &http.Server{
+		Addr:      com.Conf().Web.BindAddress,
+		Handler:   handler,
+		TLSConfig: m.TLSConfig(),
 	}
-	if u != nil {
-		loggedInUser = u.(string)
-		validSession = true
+	return server.ListenAndServeTLS("", "")
+}
+
+func main() {
+	// Parse the command line flags
+	rebuildSearch := flag.Bool("rebuild-search-index", false, "Rebuild the search index from scratch, then exit")
+	flag.Parse()
+
+	// Read server configuration
+	var err error
+	if err = com.ReadConfig(); err != nil {
+		com.Log.Fatalf("Configuration file problem\n\n%v", err)
 	}
 
-	// Ensure we have a valid logged in user
-	if validSession != true {
-		w.WriteHeader(http.StatusUnauthorized)
-		return
+	// Reload the configuration file when sent a SIGHUP
+	com.ReloadConfigOnSignal()
+
+	// Set the temp dir environment variable
+	err = os.Setenv("TMPDIR", com.Conf().DiskCache.Directory)
+	if err != nil {
+		com.Log.Fatalf("Setting temp directory environment variable failed: '%s'", err.Error())
 	}
 
-	// Extract the required form variables
-	usr, folder, fileName, err := com.GetUFD(r, false)
+	// Open the request log for writing
+	reqLog, err = openRequestLog()
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		return
+		com.Log.Fatalf("Error when opening request log: %s", err)
 	}
-	owner := strings.ToLower(usr)
+	defer reqLog.Close()
 
-	// Ensure a release name was supplied
-	relName, err := com.GetFormRelease(r)
+	// Fingerprint the static files, then parse our template files (which refer to the fingerprinted URLs) for each of
+	// the languages there's a message catalogue for
+	err = fingerprintAssets()
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		return
+		com.Log.Fatalf("Error when reading the static files: %v", err)
+	}
+	err = loadCatalogues()
+	if err != nil {
+		com.Log.Fatalf("Error when reading the message catalogues: %v", err)
+	}
+	tmpl = make(map[string]*template.Template)
+	for locale := range catalogues {
+		tmpl[locale] = template.Must(loadTemplates(locale))
 	}
 
-	// If any of the required values were empty, indicate failure
-	if relName == "" || folder == "" || fileName == "" || owner == "" {
-		w.WriteHeader(http.StatusBadRequest)
+	// Connect to Minio server
+	err = com.ConnectMinio()
+	if err != nil {
+		com.Log.Fatalf(err.Error())
+	}
+
+	// Connect to PostgreSQL server
+	err = com.ConnectPostgreSQL()
+	if err != nil {
+		com.Log.Fatalf(err.Error())
+	}
+
+	// Set up the search backend
+	err = com.ConnectSearch()
+	if err != nil {
+		com.Log.Fatalf(err.Error())
+	}
+
+	// If requested, rebuild the search index then exit.  This is needed when first switching to an external search
+	// engine, and if its index ever gets out of sync with the projects in PostgreSQL
+	if *rebuildSearch {
+		numProjects, err := com.RebuildSearchIndex()
+		if err != nil {
+			com.Log.Fatalf("Rebuilding the search index failed after %d projects: %v", numProjects, err)
+		}
+		com.Log.Infof("Search index rebuilt, %d projects indexed", numProjects)
 		return
 	}
 
-	// Make sure the database exists in the system
-	exists, err := com.CheckFileExists(loggedInUser, owner, folder, fileName)
-	if err != err {
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-	if !exists {
-		log.Printf("%s: Validation failed for database name: %s", pageName, err)
-		w.WriteHeader(http.StatusNotFound)
+	// Add the default user to the system
+	// Note - we don't check for an error here on purpose.  If we were to fail on an error, then subsequent runs after
+	// the first would barf with PG errors about trying to insert multiple "default" users violating unique
+	// constraints.  It would be solvable by creating a special purpose PL/pgSQL function just for this one use case...
+	// or we could just ignore failures here. ;)
+	com.AddDefaultUser()
+
+	// Add the default licences to PostgreSQL
+	err = com.AddDefaultLicences()
+	if err != nil {
+		com.Log.Fatalf(err.Error())
+	}
+
+	// Connect to the Memcached server
+	err = com.ConnectCache()
+	if err != nil {
+		com.Log.Fatalf(err.Error())
+	}
+
+	// Setup session storage.  When Memcached is optional the sessions are kept in PostgreSQL instead, so people don't
+	// get logged out whenever it's unavailable
+	if com.Conf().Memcache.Optional {
+		store = com.NewPGSessionStore([]byte(com.Conf().Web.SessionStorePassword))
+		go com.WebSessionCleanupLoop()
+	} else {
+		store = gsm.NewMemcacheStore(com.MemcacheHandle(), "dbhub_", []byte(com.Conf().Web.SessionStorePassword))
+	}
+
+	// Start the view count flushing routine in the background
+	go com.FlushViewCount()
+
+	// Start the project statistics flushing routine in the background
+	go com.FlushProjectStats()
+
+	// Start the project access log flushing routine in the background
+	go com.FlushProjectAccessLog()
+
+	// Start the status update processing goroutine in the background (will likely need moving into a separate daemon)
+	go com.StatusUpdatesLoop()
+
+	// Start the email sending goroutine in the background
+	go com.SendEmails()
+
+	// Start receiving the live updates published by the other webui instances
+	go com.ListenLiveUpdates()
+
+	// Start the background job workers, and the loops queuing the scheduled syncs of GitHub imports, backups, and
+	// email digests, cleaning up expired data exports, and pre-warming the caches for popular projects
+	com.RegisterJobType("admin_webhook", com.AdminWebhookJob)
+	com.RegisterJobType("backup", com.BackupJob)
+	com.RegisterJobType("data_export", com.DataExportJob)
+	com.RegisterJobType("email_digest", com.DigestJob)
+	com.RegisterJobType("github_mirror", com.GitHubMirrorJob)
+	com.RegisterJobType("github_sync", com.GitHubSyncJob)
+	com.RegisterJobType("reanalyse", com.ReanalyseJob)
+	com.RegisterJobType("regenerate", com.RegenerateJob)
+	com.RegisterJobType("scheduled_publish", com.PublishJob)
+	go com.RunJobWorkers()
+	go com.GitHubSyncLoop()
+	go com.BackupLoop()
+	go com.DigestLoop()
+	go com.DataExportLoop()
+	go com.PublishLoop()
+	go com.PrewarmLoop()
+
+	// The middleware every page and API call goes through.  Compression is added separately, as the WebSocket and
+	// profiling handlers need direct access to the connection.  Static files only need logging and compression
+	chain := []middleware{logRequest, checkIPRules, recordMetrics, loadSession, limitRate, checkOrigin}
+	rt := newRouter(http.DefaultServeMux, append(chain, compress)...)
+	raw := rt.with(chain...)
+	static := rt.with(logRequest, checkIPRules, compress)
+
+	// Our pages
+	rt.get("/", mainHandler)
+	rt.get("/about", aboutPage)
+	rt.get("/accesslog/", accessLogPage)
+	rt.get("/admin", adminPage, requireAdmin)
+	rt.get("/admin/categories", adminCategoriesPage, requireAdmin)
+	raw.get("/admin/debug/", adminDebugHandler, requireAdmin)
+	rt.get("/admin/moderation", adminModerationPage, requireAdmin)
+	rt.get("/branches/", branchesPage)
+	rt.get("/categories", categoriesPage)
+	rt.get("/category/", searchPage)
+	rt.get("/commits/", commitsPage)
+	rt.get("/compare/", comparePage)
+	rt.get("/confirmdelete/", confirmDeletePage)
+	rt.get("/confirmemail", confirmEmailPage)
+	rt.post("/confirmemail", confirmEmailPage)
+	rt.get("/contributors/", contributorsPage)
+	rt.get("/createbranch/", createBranchPage)
+	rt.get("/creatediscuss/", createDiscussionPage)
+	rt.get("/createtag/", createTagPage)
+	rt.get("/discuss/", discussPage)
+	rt.get("/feeds/", feedHandler)
+	rt.get("/forks/", forksPage)
+	rt.get("/guestupload/", guestUploadPage)
+	rt.get("/import", importPage)
+	rt.get("/licences/", licenceReportPage)
+	rt.post("/logout", logoutHandler)
+	rt.get("/merge/", mergePage)
+	rt.get("/pref", prefHandler)
+	rt.post("/pref", prefHandler)
+	rt.post("/register", createUserHandler)
+	rt.get("/questions/", questionsPage)
+	rt.get("/releases/", releasesPage)
+	rt.get("/rendercompare/", renderComparePage)
+	rt.get("/s/", shortURLHandler)
+	rt.get("/search", searchPage)
+	rt.get("/selectusername", selectUserNamePage)
+	rt.get("/settings/", settingsPage)
+	rt.get("/stars/", starsPage)
+	rt.get("/stats/", statsPage)
+	rt.get("/stepup", stepUpPage)
+	rt.get("/tagged/", searchPage)
+	rt.get("/tags/", tagsPage)
+	rt.get("/unsubscribe", unsubscribePage)
+	rt.post("/unsubscribe", unsubscribePage)
+	rt.get("/updates/", updatesPage)
+	rt.get("/upload/", uploadPage)
+	rt.get("/watchers/", watchersPage)
+	rt.get("/wiki/", wikiPage)
+	rt.post("/x/admin/addcategory", adminAddCategoryHandler, requireAdmin)
+	rt.post("/x/admin/announcement", adminAnnouncementHandler, requireAdmin)
+	rt.post("/x/admin/backup", adminBackupHandler, requireAdmin)
+	rt.post("/x/admin/deletecategory", adminDeleteCategoryHandler, requireAdmin)
+	rt.post("/x/admin/feature", adminFeatureHandler, requireAdmin)
+	rt.post("/x/admin/filterword", adminFilterWordHandler, requireAdmin)
+	rt.post("/x/admin/flaggedname", adminFlaggedNameHandler, requireAdmin)
+	rt.post("/x/admin/heldcomment", adminHeldCommentHandler, requireAdmin)
+	rt.post("/x/admin/iprule", adminIPRuleHandler, requireAdmin)
+	rt.post("/x/admin/job", adminJobHandler, requireAdmin)
+	rt.post("/x/admin/moderate", adminModerateHandler, requireAdmin)
+	rt.post("/x/admin/projecttemplate", adminProjectTemplateHandler, requireAdmin)
+	rt.post("/x/admin/reanalyse", adminReanalyseHandler, requireAdmin)
+	rt.post("/x/admin/reclaim", adminReclaimHandler, requireAdmin)
+	rt.post("/x/admin/reloadconfig", adminReloadConfigHandler, requireAdmin)
+	rt.post("/x/admin/reservedname", adminReservedNameHandler, requireAdmin)
+	rt.post("/x/admin/user", adminUserHandler, requireAdmin)
+	rt.post("/x/admin/webhook", adminWebhookHandler, requireAdmin)
+	rt.post("/x/archive", archiveHandler)
+	rt.get("/x/branchnames", branchNamesHandler)
+	rt.get("/x/callback", auth0CallbackHandler)
+	rt.post("/x/cancelemailchange", cancelEmailChangeHandler)
+	rt.post("/x/cancelschedule", cancelScheduleHandler)
+	rt.get("/x/checkname", checkNameHandler)
+	rt.get("/x/checksums/", checksumsHandler)
+	rt.post("/x/createbranch", createBranchHandler)
+	rt.post("/x/createcomment/", createCommentHandler)
+	rt.post("/x/creatediscuss", createDiscussHandler)
+	rt.post("/x/createmerge/", createMergeHandler)
+	rt.post("/x/createtag", createTagHandler)
+	rt.post("/x/deletebranch/", deleteBranchHandler)
+	rt.post("/x/deletecomment/", deleteCommentHandler)
+	rt.post("/x/deletecommit/", deleteCommitHandler)
+	rt.post("/x/deletedatabase/", deleteDatabaseHandler)
+	rt.post("/x/deleterelease/", deleteReleaseHandler)
+	rt.post("/x/deletetag/", deleteTagHandler)
+	rt.get("/x/description/", descriptionHandler)
+	rt.post("/x/diffcommitlist/", diffCommitListHandler)
+	rt.get("/x/download/", downloadHandler, requireDownloadToken)
+	rt.get("/x/downloadcsv/", downloadTableHandler, requireDownloadToken) // The original URL for table downloads, from when only CSV was available
+	rt.get("/x/downloadredashjson/", downloadRedashJSONHandler, requireDownloadToken)
+	rt.get("/x/downloadstats/", downloadStatsHandler)
+	rt.get("/x/downloadtable/", downloadTableHandler, requireDownloadToken)
+	rt.get("/x/duplicates", duplicatesHandler)
+	rt.get("/x/events", eventsHandler)
+	rt.post("/x/forkdb/", forkDBHandler)
+	rt.post("/x/gencert", generateCertHandler)
+	rt.post("/x/githubhook/", githubHookHandler)
+	rt.post("/x/githubimport", githubImportHandler)
+	rt.post("/x/githubmirror", githubMirrorHandler)
+	rt.post("/x/githubsync", githubSyncHandler)
+	rt.get("/x/guestdownload", guestDownloadHandler)
+	rt.post("/x/guestupload", guestUploadHandler)
+	rt.post("/x/guestuploads", guestUploadsHandler)
+	rt.post("/x/import", importHandler)
+	rt.get("/x/licences", licencesHandler)
+	rt.post("/x/markdownpreview/", markdownPreview)
+	rt.post("/x/mergerequest/", mergeRequestHandler)
+	rt.post("/x/metadata", metadataHandler)
+	rt.get("/x/mobile/", mobileProjectHandler)
+	rt.post("/x/octoprint", octoPrintHandler)
+	rt.post("/x/printquote/", printQuoteHandler)
+	rt.post("/x/projecttemplate", projectTemplateHandler)
+	rt.post("/x/publishdraft", publishDraftHandler)
+	rt.get("/x/qr/", qrCodeHandler)
+	rt.post("/x/question", questionHandler)
+	rt.post("/x/reanalyse", reanalyseHandler)
+	rt.get("/x/reauth", reauthHandler)
+	rt.get("/x/readme/", readmeHandler)
+	rt.post("/x/regenerate/", regenerateHookHandler)
+	rt.post("/x/regeneration", regenerationHandler)
+	rt.get("/x/related/", relatedHandler)
+	rt.post("/x/remixsources", remixSourcesHandler)
+	rt.get("/x/rendercompare/", renderCompareHandler)
+	rt.post("/x/reportproject/", reportProjectHandler)
+	rt.post("/x/savesettings", saveSettingsHandler)
+	rt.get("/x/schema/", schemaHandler)
+	rt.post("/x/scrubmetadata", scrubMetadataHandler)
+	rt.get("/x/search", searchHandler)
+	rt.post("/x/sendtooctoprint/", sendToOctoPrintHandler)
+	rt.post("/x/setdefaultbranch/", setDefaultBranchHandler)
+	rt.post("/x/settags/", setTagsHandler)
+	rt.post("/x/settheme", setThemeHandler)
+	rt.get("/x/shorturl/", shortURLsHandler)
+	rt.post("/x/shorturl/", shortURLsHandler)
+	rt.post("/x/star/", starToggleHandler)
+	rt.post("/x/stepup", stepUpHandler)
+	rt.post("/x/stripe", stripeHookHandler)
+	rt.get("/x/table/", tableViewHandler)
+	rt.post("/x/tablenames/", tableNamesHandler)
+	rt.get("/x/takeout", takeoutHandler)
+	rt.post("/x/takeout", takeoutHandler)
+	rt.get("/x/torrent/", torrentHandler)
+	rt.post("/x/totp", totpHandler)
+	rt.post("/x/transfer", transferHandler)
+	rt.post("/x/translation", translationHandler)
+	rt.post("/x/updatebranch/", updateBranchHandler)
+	rt.post("/x/updatecomment/", updateCommentHandler)
+	rt.post("/x/updatedescription/", updateDescriptionHandler)
+	rt.post("/x/updatediscuss/", updateDiscussHandler)
+	rt.post("/x/updaterelease/", updateReleaseHandler)
+	rt.post("/x/updatetag/", updateTagHandler)
+	rt.post("/x/uploaddata/", uploadFileHandler)
+	rt.post("/x/watch/", watchToggleHandler)
+	rt.post("/x/wiki", wikiHandler)
+
+	// Live updates.  These aren't gzip wrapped, as the WebSocket connection needs to take over the underlying socket
+	raw.get("/ws/", wsHandler)
+
+	// Static files, from both their plain and fingerprinted URLs
+	for u := range staticFiles {
+		static.get(u, staticHandler)
+	}
+	for u := range fingerprintedFiles {
+		static.get(u, staticHandler)
+	}
+
+	// Importing net/http/pprof also adds its profiling handlers to the default mux at /debug/pprof/, without any access
+	// control.  Those are kept out of reach, as the profiles are for site administrators only (at /admin/debug/)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/debug/pprof") {
+			http.NotFound(w, r)
+			return
+		}
+		http.DefaultServeMux.ServeHTTP(w, r)
+	})
+
+	// Start webUI server
+	com.Log.Infof("%s server starting on https://%s", com.Conf().Web.WebsiteName, com.Conf().Web.ServerName)
+	switch {
+	case com.Conf().Web.Autocert:
+		err = listenAutocert(handler)
+	case com.Conf().Web.PlainHTTP:
+		// Plain HTTP, for running behind a reverse proxy which takes care of TLS
+		err = http.ListenAndServe(com.Conf().Web.BindAddress, handler)
+	default:
+		err = http.ListenAndServeTLS(com.Conf().Web.BindAddress, com.Conf().Web.Certificate,
+			com.Conf().Web.CertificateKey, handler)
+	}
+
+	// Shut down nicely
+	com.DisconnectPostgreSQL()
+
+	if err != nil {
+		com.Log.Fatal(err)
+	}
+}
+
+func mainHandler(w http.ResponseWriter, r *http.Request) {
+	// Split the request URL into path components
+	pathStrings := strings.Split(r.URL.Path, "/")
+
+	// numPieces will be 2 if the request was for the root directory (https://server/), or if
+	// the request included only a single path component (https://server/someuser) and no trailing slash
+	var fileName, userName string
+	numPieces := len(pathStrings)
+	switch numPieces {
+	case 2:
+		userName = pathStrings[1]
+		// Check if the request was for the root directory
+		if pathStrings[1] == "" {
+			// Yep, root directory request
+			frontPage(w, r)
+			return
+		}
+
+		// The request was for a user page
+		userPage(w, r, userName)
+		return
+	case 3:
+		userName = pathStrings[1]
+		fileName = com.NormaliseName(pathStrings[2])
+
+		// This catches the case where a "/" is on the end of a user page URL
+		if fileName == "" {
+			// The request was for a user page
+			userPage(w, r, userName)
+			return
+		}
+	default:
+		// TODO: Add support for folders and sub folders
+		// TODO  eg: Allow for projects which have multiple files
+		// TODO    * /user/collectionFoo/database1
+		// TODO    * /user/collectionFoo/database2
+		// TODO    * /user/collectionBar/database1
+		// TODO    * /user/collectionBar/database2
+
+		// We haven't yet added support for folders and subfolders, so bounce back to the /user/file page
+		http.Redirect(w, r, com.ProjectPath(pathStrings[1], "/", pathStrings[2]), http.StatusTemporaryRedirect)
 		return
 	}
 
-	// Make sure the database is owned by the logged in user. eg prevent changes to other people's databases
-	if strings.ToLower(owner) != strings.ToLower(loggedInUser) {
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
+	userName = pathStrings[1]
+	fileName = com.NormaliseName(pathStrings[2])
 
-	// Load the existing releases for the database
-	releases, err := com.GetReleases(loggedInUser, folder, fileName)
+	// Validate the user supplied user and file/project name
+	err := com.ValidateUserFilename(userName, fileName)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		errorPage(w, r, http.StatusBadRequest, "Invalid user or project name")
 		return
 	}
 
-	// Make sure the given tag exists
-	if _, ok := releases[relName]; !ok {
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
+	// TODO: Add support for folders and sub-folders in request paths
+	folder := "/"
 
-	// Delete the release
-	delete(releases, relName)
-	err = com.StoreReleases(owner, folder, fileName, releases)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
+	// A specific project was requested
+	contentPage(w, r, userName, folder, fileName)
+}
 
-	// Invalidate the memcache data for the database, so the new release count gets picked up
-	err = com.InvalidateCacheEntry(loggedInUser, owner, folder, fileName, "") // Empty string indicates "for all versions"
+// Returns HTML rendered content from a given markdown string, for the settings page README preview tab.
+func markdownPreview(w http.ResponseWriter, r *http.Request) {
+	// Extract and unescape the markdown text form value
+	a := r.PostFormValue("mkdown")
+	mkDown, err := url.QueryUnescape(a)
 	if err != nil {
-		// Something went wrong when invalidating memcached entries for the database
-		log.Printf("Error when invalidating memcache entries: %s\n", err.Error())
+		fmt.Fprint(w, "Something went wrong when unescaping provided value")
 		return
 	}
 
-	// Update succeeded
-	w.WriteHeader(http.StatusOK)
-}
-
-// This function deletes a tag.
-func deleteTagHandler(w http.ResponseWriter, r *http.Request) {
-	pageName := "Delete Tag handler"
-
-	// Retrieve session data (if any)
-	var loggedInUser string
-	var u interface{}
-	validSession := false
-	if com.Conf.Environment.Environment != "docker" {
-		sess, err := store.Get(r, "3dhub-user")
+	// Validate the markdown source provided, just to be safe
+	var renderedText []byte
+	if mkDown != "" {
+		err := com.Validate.Var(mkDown, "markdownsource")
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, "Invalid characters in Markdown")
 			return
 		}
-		u = sess.Values["UserName"]
-	} else {
-		u = "default"
-	}
-	if u != nil {
-		loggedInUser = u.(string)
-		validSession = true
+		renderedText = gfm.Markdown([]byte(mkDown))
 	}
 
+	// Send the rendered version back to the caller
+	fmt.Fprint(w, string(renderedText))
+}
+
+// Handler which does merging to MR's.  Called from the MR details page
+func mergeRequestHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
+
 	// Ensure we have a valid logged in user
-	if validSession != true {
+	if loggedInUser == "" {
 		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, "You need to be logged in")
 		return
 	}
 
-	// Extract the required form variables
-	usr, folder, fileName, err := com.GetUFD(r, false)
+	// Extract and validate the form variables
+	owner, folder, fileName, err := com.GetUFD(r, false)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Missing or incorrect data supplied")
 		return
 	}
-	owner := strings.ToLower(usr)
 
-	// Ensure a tag name was supplied
-	tagName, err := com.GetFormTag(r)
-	if err != nil {
+	// Ensure an MR id was given
+	a := r.PostFormValue("mrid")
+	if a == "" {
 		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Missing merge request id")
 		return
 	}
-
-	// If any of the required values were empty, indicate failure
-	if tagName == "" || folder == "" || fileName == "" || owner == "" {
+	mrID, err := strconv.Atoi(a)
+	if err != nil {
+		com.Log.Errorf("Error converting string '%s' to integer in function '%s': %s", a,
+			com.GetCurrentFunctionName(), err)
 		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Error when parsing merge request id value")
 		return
 	}
 
-	// Make sure the database exists in the system
+	// Check if the requested database exists
 	exists, err := com.CheckFileExists(loggedInUser, owner, folder, fileName)
-	if err != err {
+	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
 		return
 	}
 	if !exists {
-		log.Printf("%s: Validation failed for database name: %s", pageName, err)
 		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Database '%s%s%s' doesn't exist", owner, folder, fileName)
 		return
 	}
 
-	// Make sure the database is owned by the logged in user. eg prevent changes to other people's databases
-	if strings.ToLower(owner) != strings.ToLower(loggedInUser) {
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
-
-	// Load the existing tags for the database
-	tags, err := com.GetTags(loggedInUser, folder, fileName)
+	// Archived projects are read-only
+	archived, err := com.ProjectArchived(owner, folder, fileName)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
 		return
 	}
-
-	// Make sure the given tag exists
-	if _, ok := tags[tagName]; !ok {
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
-
-	// Delete the tag
-	delete(tags, tagName)
-	err = com.StoreTags(owner, folder, fileName, tags)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+	if archived {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, "This project is archived, so it's read-only")
 		return
 	}
 
-	// Invalidate the memcache data for the database, so the new tag count gets picked up
-	err = com.InvalidateCacheEntry(loggedInUser, owner, folder, fileName, "") // Empty string indicates "for all versions"
-	if err != nil {
-		// Something went wrong when invalidating memcached entries for the database
-		log.Printf("Error when invalidating memcache entries: %s\n", err.Error())
+	// Ensure the request is coming from the database owner
+	if strings.ToLower(owner) != strings.ToLower(loggedInUser) {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, "Only the database owner can merge in merge requests")
 		return
 	}
 
-	// Update succeeded
-	w.WriteHeader(http.StatusOK)
-}
-
-// Returns the list of commits that are different between a source and destination database/branch
-func diffCommitListHandler(w http.ResponseWriter, r *http.Request) {
-	// Retrieve session data (if any)
-	var loggedInUser string
-	var u interface{}
-	if com.Conf.Environment.Environment != "docker" {
-		sess, err := store.Get(r, "3dhub-user")
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
-		u = sess.Values["UserName"]
-	} else {
-		u = "default"
-	}
-	if u != nil {
-		loggedInUser = u.(string)
-	}
-
-	// Retrieve source owner
-	o := r.PostFormValue("sourceowner")
-	srcOwner, err := url.QueryUnescape(o)
+	// Retrieve the names of the source & destination databases and branches
+	disc, err := com.Discussions(owner, folder, fileName, com.MERGE_REQUEST, mrID)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprint(w, err.Error())
 		return
 	}
-	err = com.ValidateUser(srcOwner)
-	if err != nil {
-		log.Printf("Validation failed for username: '%s'- %s", srcOwner, err)
+	branchName := disc[0].MRDetails.DestBranch
+	commitDiffList := disc[0].MRDetails.Commits
+	srcOwner := disc[0].MRDetails.SourceOwner
+	srcFolder := disc[0].MRDetails.SourceFolder
+	srcDBName := disc[0].MRDetails.SourceDBName
+	srcBranchName := disc[0].MRDetails.SourceBranch
+
+	// Ensure the merge request isn't closed
+	if !disc[0].Open {
 		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprint(w, err.Error())
+		fmt.Fprint(w, "Cannot merge a closed merge request")
 		return
 	}
 
-	// Retrieve source folder
-	f := r.PostFormValue("sourcefolder")
-	srcFolder, err := url.QueryUnescape(f)
+	// Get the details of the head commit for the destination database branch
+	branchList, err := com.GetBranches(owner, folder, fileName) // Destination branch list
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprint(w, err.Error())
 		return
 	}
-	err = com.ValidateFolder(srcFolder)
+	branchDetails, ok := branchList[branchName]
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, "Could not retrieve details for the destination branch")
+		return
+	}
+	destCommitID := branchDetails.Commit
+	destCommitList, err := com.GetCommitList(owner, folder, fileName)
 	if err != nil {
-		log.Printf("Validation failed for folder: '%s' - %s", srcFolder, err)
-		w.WriteHeader(http.StatusBadRequest)
+		w.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprint(w, err.Error())
 		return
 	}
 
-	// Retrieve source database name
-	d := r.PostFormValue("sourcedbname")
-	srcDBName, err := url.QueryUnescape(d)
-	if err != nil {
+	// Check if the MR commits will still apply cleanly to the destination branch
+	finalCommit := commitDiffList[len(commitDiffList)-1]
+	if finalCommit.Parent != destCommitID {
 		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, err.Error())
+		fmt.Fprint(w, fmt.Errorf("Destination branch has changed. Merge cannot proceed."))
 		return
 	}
-	err = com.ValidateFileName(srcDBName)
+
+	// * The required details have been collected, and sanity checks completed, so merge the MR *
+
+	// Add the source commits directly to the destination commit list
+	for _, j := range commitDiffList {
+		destCommitList[j.ID] = j
+	}
+
+	// Retrieve details for the logged in user
+	usr, err := com.User(loggedInUser)
 	if err != nil {
-		log.Printf("Validation failed for database name '%s': %s", srcDBName, err)
-		w.WriteHeader(http.StatusBadRequest)
+		w.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprint(w, err.Error())
 		return
 	}
 
-	// Retrieve source branch name
-	a := r.PostFormValue("sourcebranch")
-	srcBranch, err := url.QueryUnescape(a)
+	// Create a merge commit, using the details of the source commit (this gets us a correctly filled in DB tree
+	// structure easily)
+	mrg := commitDiffList[0]
+	mrg.AuthorEmail = usr.Email
+	mrg.AuthorName = usr.DisplayName
+	mrg.Message = fmt.Sprintf("Merge branch '%s' of '%s%s%s' into '%s'", srcBranchName, srcOwner, srcFolder,
+		srcDBName, branchName)
+	mrg.Parent = commitDiffList[0].ID
+	mrg.OtherParents = append(mrg.OtherParents, destCommitID)
+	mrg.Timestamp = time.Now().UTC()
+	mrg.ID = com.CreateCommitID(mrg)
+
+	// Add the new commit to the destination db commit list, and update the branch list with it
+	destCommitList[mrg.ID] = mrg
+	b := com.BranchEntry{
+		Commit:      mrg.ID,
+		CommitCount: branchDetails.CommitCount + len(commitDiffList) + 1,
+		Description: branchDetails.Description,
+	}
+	branchList[branchName] = b
+	err = com.StoreCommits(owner, folder, fileName, destCommitList)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprint(w, err.Error())
 		return
 	}
-	err = com.ValidateBranchName(srcBranch)
+	err = com.StoreBranches(owner, folder, fileName, branchList)
 	if err != nil {
-		log.Printf("Validation failed for branch name '%s': %s", srcBranch, err)
-		w.WriteHeader(http.StatusBadRequest)
+		w.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprint(w, err.Error())
 		return
 	}
 
-	// Retrieve destination owner
-	o = r.PostFormValue("destowner")
-	destOwner, err := url.QueryUnescape(o)
+	// Change the status of the MR to closed, and indicate it was successfully merged
+	err = com.StoreComment(owner, folder, fileName, loggedInUser, mrID, "", true,
+		com.CLOSED_WITH_MERGE)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprint(w, err.Error())
 		return
 	}
-	err = com.ValidateUser(destOwner)
+
+	// Invalidate the memcached entries for the destination database case
+	err = com.InvalidateCacheEntry(loggedInUser, owner, folder, fileName, "") // Empty string indicates "for all versions"
 	if err != nil {
-		log.Printf("Validation failed for username: '%s'- %s", destOwner, err)
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprint(w, err.Error())
+		// Something went wrong when invalidating memcached entries for the database
+		com.Log.Errorf("Error when invalidating memcache entries: %s", err.Error())
 		return
 	}
 
-	// Retrieve destination folder
-	f = r.PostFormValue("destfolder")
-	destFolder, err := url.QueryUnescape(f)
+	// Send a success message back to the caller
+	w.WriteHeader(http.StatusOK)
+}
+
+// Returns metadata (latest commit, size, stars, licence) for a list of projects in one go, for use by dashboards and
+// mirroring tools.  The projects are given in the request body, as a JSON array of "owner/project" strings.
+func metadataHandler(w http.ResponseWriter, r *http.Request) {
+	pageName := "Batch metadata handler"
+
+	loggedInUser := sessionUser(r)
+
+	// Decode the list of requested projects
+	r.Body = http.MaxBytesReader(w, r.Body, 1024*1024) // 1MB is far more than needed for the maximum batch size
+	var projects []string
+	err := json.NewDecoder(r.Body).Decode(&projects)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Couldn't decode the list of projects")
 		return
 	}
-	err = com.ValidateFolder(destFolder)
-	if err != nil {
-		log.Printf("Validation failed for folder: '%s' - %s", destFolder, err)
+	if len(projects) > com.MaxBatchProjects {
 		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprint(w, err.Error())
+		fmt.Fprintf(w, "No more than %d projects can be requested at once", com.MaxBatchProjects)
 		return
 	}
 
-	// Retrieve destination database name
-	d = r.PostFormValue("destdbname")
-	destDBName, err := url.QueryUnescape(d)
+	// Look up the metadata for each project.  Problems with individual projects are reported in their own entry,
+	// so one bad project name doesn't fail the whole batch
+	// TODO: Add folder support
+	results := make(map[string]com.ProjectMetadata)
+	for _, p := range projects {
+		var m com.ProjectMetadata
+		s := strings.SplitN(p, "/", 2)
+		if len(s) == 2 {
+			s[1] = com.NormaliseName(s[1])
+		}
+		if len(s) != 2 || com.ValidateUserFilename(s[0], s[1]) != nil {
+			m.Error = "Invalid owner or project name"
+			results[p] = m
+			continue
+		}
+		owner, fileName := s[0], s[1]
+
+		// Retrieve the details for the head commit of the default branch.  This will only succeed if the project
+		// exists and the user is allowed to see it
+		var db com.SQLiteDBinfo
+		err = com.DBDetails(&db, loggedInUser, owner, "/", fileName, "")
+		if err != nil {
+			m.Error = "Project not found"
+			results[p] = m
+			continue
+		}
+		m.Archived = db.Info.Archived
+		m.CommitID = db.Info.CommitID
+		m.LastModified = db.Info.DBEntry.LastModified
+		m.Size = db.Info.DBEntry.Size
+		m.Stars = db.Info.Stars
+
+		// If an sha256 was in the licence field, retrieve it's friendly name and url
+		if db.Info.DBEntry.LicenceSHA != "" {
+			m.Licence, m.LicenceURL, err = com.GetLicenceInfoFromSha256(owner, db.Info.DBEntry.LicenceSHA)
+			if err != nil {
+				com.Log.Errorf("%s: Error retrieving licence info for '%s': %v", pageName, p, err)
+				m.Error = "Couldn't retrieve licence details"
+			}
+		} else {
+			m.Licence = "Not specified"
+		}
+		m.LicenceSPDX, err = com.GetLicenceSPDXFromSha256(owner, db.Info.DBEntry.LicenceSHA)
+		if err != nil {
+			m.Error = "Couldn't retrieve licence details"
+		}
+		results[p] = m
+	}
+
+	// Return the results
+	data, err := json.MarshalIndent(results, "", " ")
 	if err != nil {
+		com.Log.Error(err)
 		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, err.Error())
 		return
 	}
-	err = com.ValidateFileName(destDBName)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, string(data))
+}
+
+// Returns everything a mobile app needs for showing a project (its details, licence, model file, and the user's star
+// and watch status) in one compact response, so the app doesn't need several round trips.  The head commit of the
+// default branch is used, unless a commit is given.
+func mobileProjectHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
+
+	// Extract the owner and database name
+	// TODO: Add folder support
+	owner, fileName, err := com.GetOD(2, r) // 2 = Ignore "/x/mobile/" at the start of the URL
+	if err != nil || owner == "" || fileName == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	folder := "/"
+	commitID, err := com.GetFormCommit(r)
 	if err != nil {
-		log.Printf("Validation failed for database name '%s': %s", destDBName, err)
 		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprint(w, err.Error())
 		return
 	}
 
-	// Retrieve destination branch name
-	a = r.PostFormValue("destbranch")
-	destBranch, err := url.QueryUnescape(a)
+	// Make sure the project exists in the system, and the user has access to it
+	exists, err := com.CheckFileExists(loggedInUser, owner, folder, fileName)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, err.Error())
 		return
 	}
-	err = com.ValidateBranchName(destBranch)
-	if err != nil {
-		log.Printf("Validation failed for branch name '%s': %s", destBranch, err)
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprint(w, err.Error())
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
 		return
 	}
-
-	// Make sure none of the required fields is empty
-	if srcOwner == "" || srcFolder == "" || srcDBName == "" || srcBranch == "" || destOwner == "" || destFolder ==
-		"" || destDBName == "" || destBranch == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprint(w, "Some of the (required) supplied fields are empty")
-		return
+	if commitID == "" {
+		commitID, err = com.DefaultCommit(owner, folder, fileName)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	} else {
+		commitList, err := com.GetCommitList(owner, folder, fileName)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if _, ok := commitList[commitID]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
 	}
 
-	// Check the databases exist
-	srcExists, err := com.CheckFileExists(loggedInUser, srcOwner, srcFolder, srcDBName)
+	// Retrieve the project details
+	var db com.SQLiteDBinfo
+	err = com.DBDetails(&db, loggedInUser, owner, folder, fileName, commitID)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, err.Error())
 		return
 	}
-	destExists, err := com.CheckFileExists(loggedInUser, destOwner, destFolder, destDBName)
+	p := com.MobileProject{
+		Archived:     db.Info.Archived,
+		Category:     db.Info.Category.Path,
+		CommitID:     db.Info.CommitID,
+		Description:  db.Info.FullDesc,
+		Downloads:    db.Info.Downloads,
+		Forks:        db.Info.Forks,
+		LastModified: db.Info.DBEntry.LastModified,
+		Name:         fileName,
+		OneLineDesc:  db.Info.OneLineDesc,
+		Owner:        owner,
+		Public:       db.Info.Public && !db.Info.Draft,
+		Stars:        db.Info.Stars,
+		Tags:         db.Info.ProjectTags,
+		URL:          fmt.Sprintf("https://%s/%s%s%s", com.Conf().Web.ServerName, owner, folder, fileName),
+	}
+	if p.Tags == nil {
+		p.Tags = []string{}
+	}
+	p.Model = com.MobileModel{
+		FileName: db.Info.DBEntry.Name,
+		Format:   strings.TrimPrefix(strings.ToLower(filepath.Ext(db.Info.DBEntry.Name)), "."),
+		SHA256:   db.Info.DBEntry.Sha256,
+		Size:     db.Info.DBEntry.Size,
+		URL: fmt.Sprintf("https://%s/x/download/%s/%s?commit=%s", com.Conf().Web.ServerName, owner, fileName,
+			commitID),
+	}
+	p.Model.TriangleCount, err = com.ProjectTriangleCount(owner, folder, fileName)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, err.Error())
-		return
-	}
-	if !srcExists || !destExists {
-		w.WriteHeader(http.StatusNotFound)
-		fmt.Fprint(w, "Invalid database.  One of the source or destination databases doesn't exist")
 		return
 	}
 
-	// Get the commit list diff
-	ancestorID, cList, err, errType := com.GetCommonAncestorCommits(srcOwner, srcFolder, srcDBName, srcBranch, destOwner,
-		destFolder, destDBName, destBranch)
-	if err != nil && errType != http.StatusBadRequest {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, err.Error())
-		return
+	// If an sha256 was in the licence field, retrieve it's friendly name and url
+	if db.Info.DBEntry.LicenceSHA != "" {
+		p.Licence, p.LicenceURL, err = com.GetLicenceInfoFromSha256(owner, db.Info.DBEntry.LicenceSHA)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	} else {
+		p.Licence = "Not specified"
 	}
-
-	// Make sure the source branch will cleanly apply to the destination.  eg the destination branch hasn't received
-	// additional commits since the source was forked
-	if ancestorID == "" {
-		w.WriteHeader(http.StatusConflict)
-		fmt.Fprint(w, "Source branch is not a direct descendent of the destination branch.  Cannot create commit "+
-			"list diff.")
+	p.LicenceSPDX, err = com.GetLicenceSPDXFromSha256(owner, db.Info.DBEntry.LicenceSHA)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	// * Retrieve the current licence for the destination branch *
-
-	// Retrieve the commit ID for the destination branch
-	destBranchList, err := com.GetBranches(destOwner, destFolder, destDBName)
+	// Add the owner's avatar, and whether the logged in user has starred or is watching the project
+	usr, err := com.User(owner)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, err.Error())
 		return
 	}
-	b, ok := destBranchList[destBranch]
-	if !ok {
+	p.OwnerAvatarURL = usr.AvatarURL
+	if loggedInUser != "" {
+		p.Starred, err = com.CheckDBStarred(loggedInUser, owner, folder, fileName)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		p.Watching, err = com.CheckDBWatched(loggedInUser, owner, folder, fileName)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprint(w, err.Error())
 			return
 		}
 	}
-	destCommitID := b.Commit
 
-	// Retrieve the current licence for the destination branch, using the commit ID
-	commitList, err := com.GetCommitList(destOwner, destFolder, destDBName)
+	// Mobile connections are often slow, so the response isn't indented
+	data, err := json.Marshal(p)
 	if err != nil {
+		com.Log.Errorf("Error when JSON marshalling mobile project details: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, err.Error())
 		return
 	}
-	destCommit, ok := commitList[destCommitID]
-	if !ok {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, "Destination commit ID not found in commit list.")
+	if com.NotModified(w, r, com.ContentETag(data), time.Time{}) {
 		return
 	}
-	destLicenceSHA := destCommit.Tree.Entries[0].LicenceSHA
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, "%s", data)
+}
 
-	// Convert the commit entries into something we can display in a commit list
-	var x struct {
-		CommitList []com.CommitData `json:"commit_list"`
+// Returns the new location of a project which used to be at the given one (eg before being transferred to another
+// user).  Locations the user can't view aren't returned, so the new home of private projects isn't given away.
+func movedProjectURL(loggedInUser string, owner string, folder string, fileName string) (string, bool) {
+	newOwner, newFolder, newName, found, err := com.ProjectRedirect(owner, folder, fileName)
+	if err != nil || !found {
+		return "", false
 	}
-	for _, j := range cList {
-		var c com.CommitData
-		c.AuthorEmail = j.AuthorEmail
-		c.AuthorName = j.AuthorName
-		c.ID = j.ID
-		c.Message = j.Message
-		c.Timestamp = j.Timestamp
-		c.AuthorUsername, c.AuthorAvatar, err = com.GetUsernameFromEmail(j.AuthorEmail)
+	visible, err := com.CheckFileExists(loggedInUser, newOwner, newFolder, newName)
+	if err != nil || !visible {
+		return "", false
+	}
+	return com.ProjectPath(newOwner, newFolder, newName), true
+}
+
+// Links or unlinks the logged in user's OctoPrint instance, from their preferences page.
+func octoPrintHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
+
+	// Ensure we have a valid logged in user
+	if loggedInUser == "" {
+		errorPage(w, r, http.StatusUnauthorized, "You need to be logged in")
+		return
+	}
+
+	var err error
+	switch r.PostFormValue("action") {
+	case "link":
+		err = com.LinkOctoPrint(loggedInUser, r.PostFormValue("url"), r.PostFormValue("apikey"))
 		if err != nil {
-			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			errorPage(w, r, http.StatusBadRequest, err.Error())
 			return
 		}
-		if c.AuthorAvatar != "" {
-			c.AuthorAvatar += "&s=18"
-		}
-
-		// Check for licence changes
-		commitLicSHA := j.Tree.Entries[0].LicenceSHA
-		if commitLicSHA != destLicenceSHA {
-			lName, _, err := com.GetLicenceInfoFromSha256(srcOwner, commitLicSHA)
-			if err != nil {
-				errorPage(w, r, http.StatusInternalServerError, err.Error())
-				return
-			}
-			c.LicenceChange = fmt.Sprintf("This commit includes a licence change to '%s'", lName)
+	case "unlink":
+		err = com.DeleteOctoPrintLink(loggedInUser)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, "Database query failed")
+			return
 		}
-		x.CommitList = append(x.CommitList, c)
-	}
-
-	// Return the commit list
-	y, err := json.MarshalIndent(x, "", " ")
-	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, err.Error())
+	default:
+		errorPage(w, r, http.StatusBadRequest, "Unknown action")
 		return
 	}
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprint(w, string(y))
+	http.Redirect(w, r, "/pref#octoprint", http.StatusSeeOther)
 }
 
-func downloadCSVHandler(w http.ResponseWriter, r *http.Request) {
-	pageName := "Download CSV"
+// Parses a Range header asking for a single range of bytes, eg "bytes=0-1023" or "bytes=-500".  Returns the start and
+// length of the range, and whether it's one which can be sent.  For anything else (including no header), the whole
+// file is returned as the range.
+func parseByteRange(header string, size int64) (start int64, length int64, ok bool) {
+	spec := strings.TrimSpace(strings.TrimPrefix(header, "bytes="))
+	if header == spec || strings.Contains(spec, ",") {
+		return 0, size, false
+	}
+	parts := strings.SplitN(spec, "-", 2)
+	if len(parts) != 2 {
+		return 0, size, false
+	}
+	first, errFirst := strconv.ParseInt(strings.TrimSpace(parts[0]), 10, 64)
+	last, errLast := strconv.ParseInt(strings.TrimSpace(parts[1]), 10, 64)
+	switch {
+	case parts[0] == "" && errLast == nil && last > 0 && size > 0:
+		// The last part of the file
+		if last > size {
+			last = size
+		}
+		return size - last, last, true
+	case errFirst != nil || first < 0 || first >= size:
+		return 0, size, false
+	case parts[1] == "":
+		return first, size - first, true
+	case errLast != nil || last < first:
+		return 0, size, false
+	}
+	if last >= size {
+		last = size - 1
+	}
+	return first, last - first + 1, true
+}
 
-	// Extract the username, database, table, and commit ID requested
-	// NOTE - The commit ID is optional.  Without it, we just pick the latest commit from the (for now) default branch
-	// TODO: Add support for passing in a specific branch, to get the latest commit for that instead
-	owner, fileName, dbTable, commitID, err := com.GetODTC(2, r) // 2 = Ignore "/x/download/" at the start of the URL
-	if err != nil {
-		errorPage(w, r, http.StatusBadRequest, err.Error())
+// This handles incoming requests for the preferences page by logged in users.
+func prefHandler(w http.ResponseWriter, r *http.Request) {
+	pageName := "Preferences handler"
+
+	loggedInUser := sessionUser(r)
+
+	// Ensure we have a valid logged in user
+	if loggedInUser == "" {
+		errorPage(w, r, http.StatusUnauthorized, "You need to be logged in")
 		return
 	}
 
-	// Abort if the table name was missing
-	if dbTable == "" {
-		log.Printf("%s: Missing table name\n", pageName)
-		errorPage(w, r, http.StatusBadRequest, "Missing table name")
+	// Gather submitted form data (if any)
+	maxRows := r.PostFormValue("maxrows")
+	displayName := r.PostFormValue("fullname")
+	email := r.PostFormValue("email")
+	locale := r.PostFormValue("locale")
+
+	// If no form data was submitted, display the preferences page form
+	if maxRows == "" {
+		prefPage(w, r, fmt.Sprintf("%s", loggedInUser))
 		return
 	}
 
-	// Retrieve session data (if any)
-	var loggedInUser string
-	var u interface{}
-	if com.Conf.Environment.Environment != "docker" {
-		sess, err := store.Get(r, "3dhub-user")
-		if err != nil {
-			errorPage(w, r, http.StatusBadRequest, err.Error())
-			return
-		}
-		u = sess.Values["UserName"]
-	} else {
-		u = "default"
+	// Basic sanity check
+	if displayName == "" {
+		errorPage(w, r, http.StatusBadRequest, "Full name can't be blank!")
+		return
 	}
-	if u != nil {
-		loggedInUser = u.(string)
+	if email == "" {
+		errorPage(w, r, http.StatusBadRequest, "Email address can't be blank!")
+		return
 	}
 
-	// Verify the given database exists and is ok to be downloaded (and get the Minio bucket + id while at it)
-	bucket, id, _, err := com.MinioLocation(owner, "/", fileName, commitID, loggedInUser)
+	// Validate submitted form data
+	err := com.Validate.Var(maxRows, "required,numeric,min=1,max=500")
 	if err != nil {
-		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		com.Log.Errorf("%s: Maximum rows value failed validation: %s", pageName, err)
+		errorPage(w, r, http.StatusBadRequest, "Error when parsing maximum rows preference value")
 		return
 	}
-
-	// Ensure the database being requested isn't overly large
-	var tmp com.SQLiteDBinfo
-	err = com.DBDetails(&tmp, loggedInUser, owner, "/", fileName, commitID)
+	maxRowsNum, err := strconv.Atoi(maxRows)
 	if err != nil {
-		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		com.Log.Errorf("%s: Error converting string '%v' to integer: %s", pageName, maxRows, err)
+		errorPage(w, r, http.StatusBadRequest, "Error when parsing preference data")
 		return
 	}
-	size := tmp.Info.DBEntry.Size
-	if size >= 100000000 {
-		errorPage(w, r, http.StatusBadRequest, "CSV export not allowed for this database due to size restrictions.")
+	err = com.ValidateDisplayName(displayName)
+	if err != nil {
+		com.Log.Errorf("%s: Display name '%s' failed validation: %s", pageName, displayName, err)
+		errorPage(w, r, http.StatusBadRequest, "Error when parsing full name value")
 		return
 	}
+	err = com.Validate.Var(email, "required,email")
+	if err != nil {
+		// Check for the special case of username@server, which may fail standard email validation checks
+		// eg username@localhost, won't validate as an email address, but should be accepted anyway
+		serverName := strings.Split(com.Conf().Web.ServerName, ":")
+		em := fmt.Sprintf("%s@%s", loggedInUser, serverName[0])
+		if email != em {
+			com.Log.Errorf("%s: Email value failed validation: %s", pageName, err)
+			errorPage(w, r, http.StatusBadRequest, "Error when parsing email value")
+			return
+		}
+	}
 
-	// Get a handle from Minio for the database object
-	sdb, err := com.OpenMinioObject(bucket, id)
+	// An empty language means it's worked out from what the browser asks for
+	if _, ok := catalogues[locale]; locale != "" && !ok {
+		errorPage(w, r, http.StatusBadRequest, "Unknown language")
+		return
+	}
+	theme, err := com.GetFormTheme(r)
 	if err != nil {
-		errorPage(w, r, http.StatusInternalServerError, "Database query failed")
+		errorPage(w, r, http.StatusBadRequest, err.Error())
 		return
 	}
-
-	// Automatically close the SQLite database when this function finishes
-	defer func() {
-		sdb.Close()
-	}()
-
-	// Read the table data from the database object
-	resultSet, err := com.ReadSQLiteDBCSV(sdb, dbTable)
+	timeZone, err := com.GetFormTimeZone(r)
 	if err != nil {
-		errorPage(w, r, http.StatusInternalServerError, "Error reading table data from the database")
+		errorPage(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	dateFormat, err := com.GetFormDateFormat(r)
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, err.Error())
 		return
 	}
-
-	// Was a user agent part of the request?
-	var userAgent string
-	if ua, ok := r.Header["User-Agent"]; ok {
-		userAgent = strings.ToLower(ua[0])
+	emailFreq, err := com.GetFormEmailFrequency(r)
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, err.Error())
+		return
 	}
+	notifyPrefs := com.GetFormNotificationPrefs(r)
 
-	// Check if the request came from a Windows based device.  If it did, it'll need CRLF line endings
-	win := strings.Contains(userAgent, "windows")
-
-	// Convert resultSet into CSV and send to the user
-	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.csv"`, dbTable))
-	w.Header().Set("Content-Type", "text/csv")
-	csvFile := csv.NewWriter(w)
-	csvFile.UseCRLF = win
-	err = csvFile.WriteAll(resultSet)
+	// Make sure the email address isn't already assigned to a different user
+	a, _, err := com.GetUsernameFromEmail(email)
 	if err != nil {
-		log.Printf("%s: Error when generating CSV: %v\n", pageName, err)
-		errorPage(w, r, http.StatusInternalServerError, "Error when generating CSV")
+		errorPage(w, r, http.StatusInternalServerError, "Error when checking email address")
+		return
+	}
+	if a != "" && strings.ToLower(a) != strings.ToLower(loggedInUser) {
+		errorPage(w, r, http.StatusBadRequest, "That email address is already associated with a different user")
 		return
 	}
-}
-
-func downloadHandler(w http.ResponseWriter, r *http.Request) {
-	pageName := "Download Handler"
 
-	// NOTE - The commit ID is optional.  Without it, we just pick the latest commit from the (for now) default branch
-	// TODO: Add support for passing in a specific branch, to get the latest commit for that instead
-	owner, fileName, commitID, err := com.GetODC(2, r) // 2 = Ignore "/x/download/" at the start of the URL
+	// Validate the public profile details
+	profile, err := com.GetFormUserProfile(r)
 	if err != nil {
 		errorPage(w, r, http.StatusBadRequest, err.Error())
 		return
 	}
-	folder := "/"
 
-	// Retrieve session data (if any)
-	var loggedInUser string
-	var u interface{}
-	if com.Conf.Environment.Environment != "docker" {
-		sess, err := store.Get(r, "3dhub-user")
+	// The tip links aren't on the form while the tips feature is off, so keep the ones already stored
+	if !com.FeatureEnabled(com.FeatureTips) {
+		profile.TipLinks, err = com.UserTipLinks(loggedInUser)
 		if err != nil {
-			errorPage(w, r, http.StatusBadRequest, err.Error())
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
 			return
 		}
-		u = sess.Values["UserName"]
-	} else {
-		u = "default"
-	}
-	if u != nil {
-		loggedInUser = u.(string)
 	}
 
-	// Verify the given database exists and is ok to be downloaded (and get the Minio bucket + id while at it)
-	bucket, id, _, err := com.MinioLocation(owner, folder, fileName, commitID, loggedInUser)
+	// TODO: Store previous email addresses in a database table that associates them with the username.  This will be
+	// TODO  needed so looking up an old email finds the correct username.  For example when looking through historical
+	// TODO  commit data
+
+	// A different email address needs confirming before it's used, so the current one is kept until then
+	usr, err := com.User(loggedInUser)
 	if err != nil {
-		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		errorPage(w, r, http.StatusInternalServerError, "Database query failed")
 		return
 	}
+	newEmail := email
+	if strings.ToLower(email) != strings.ToLower(usr.Email) {
+		email = usr.Email
+	} else {
+		newEmail = ""
+	}
 
-	// Get a handle from Minio for the database object
-	userDB, err := com.MinioHandle(bucket, id)
+	// Update the preference data in the database
+	err = com.SetUserPreferences(loggedInUser, maxRowsNum, displayName, email, locale, timeZone, dateFormat)
 	if err != nil {
-		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		errorPage(w, r, http.StatusInternalServerError, "Error when updating preferences")
 		return
 	}
-
-	// Close the object handle when this function finishes
-	defer func() {
-		com.MinioHandleClose(userDB)
-	}()
-
-	// Get the file details
-	stat, err := userDB.Stat()
+	err = com.SetUserProfile(loggedInUser, profile)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		errorPage(w, r, http.StatusInternalServerError, "Error when updating profile")
 		return
 	}
 
-	// Was a user agent part of the request?
-	var userAgent string
-	if ua, ok := r.Header["User-Agent"]; ok {
-		userAgent = ua[0]
+	err = com.SetUserTheme(loggedInUser, theme)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Error when updating theme")
+		return
 	}
-
-	// Make a record of the download
-	err = com.LogDownload(owner, folder, fileName, loggedInUser, r.RemoteAddr, "webui", userAgent,
-		time.Now(), bucket+id)
+	err = com.SetUserEmailFrequency(loggedInUser, emailFreq)
 	if err != nil {
-		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		errorPage(w, r, http.StatusInternalServerError, "Error when updating email preferences")
 		return
 	}
-
-	// Send the database to the user
-	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, fileName))
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", stat.Size))
-	w.Header().Set("Content-Type", "application/x-sqlite3")
-	bytesWritten, err := io.Copy(w, userDB)
+	err = com.SetNotificationPrefs(loggedInUser, notifyPrefs)
 	if err != nil {
-		log.Printf("%s: Error returning DB file: %v\n", pageName, err)
-		fmt.Fprintf(w, "%s: Error returning DB file: %v\n", pageName, err)
+		errorPage(w, r, http.StatusInternalServerError, "Error when updating notification preferences")
 		return
 	}
 
-	// If downloaded by someone other than the owner, increment the download count for the database
-	if strings.ToLower(loggedInUser) != strings.ToLower(owner) {
-		err = com.IncrementDownloadCount(owner, folder, fileName)
+	// Use the new language, theme, and date preferences straight away, rather than from the next login
+	sess, err := store.Get(r, "3dhub-user")
+	if err == nil {
+		sess.Values["DateFormat"] = dateFormat
+		sess.Values["Locale"] = locale
+		sess.Values["Theme"] = string(theme)
+		sess.Values["TimeZone"] = timeZone
+		sess.Save(r, w)
+	}
+
+	// Start changing the email address, if a different one was given
+	if newEmail != "" {
+		pending, err := com.RequestEmailChange(loggedInUser, newEmail)
 		if err != nil {
-			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			errorPage(w, r, http.StatusInternalServerError, "Error when changing the email address")
+			return
+		}
+		if pending {
+			// Show the user the change is waiting to be confirmed
+			http.Redirect(w, r, "/pref#email", http.StatusSeeOther)
 			return
 		}
 	}
 
-	// Log the number of bytes written
-	log.Printf("%s: '%s/%s' downloaded. %d bytes", pageName, owner, fileName, bytesWritten)
+	// Bounce to the user home page
+	http.Redirect(w, r, "/"+loggedInUser, http.StatusSeeOther)
 }
 
-func downloadRedashJSONHandler(w http.ResponseWriter, r *http.Request) {
-	pageName := "Download Redash JSON"
+// Sends the model of a project to a print service, returning the link to the service's quote for printing it.  The
+// service, the scale (as a percentage), and optionally the commit are given in the form data.
+func printQuoteHandler(w http.ResponseWriter, r *http.Request) {
+	pageName := "Print quote handler"
 
-	// Extract the username, database, table, and commit ID requested
-	// NOTE - The commit ID is optional.  Without it, we just pick the latest commit from the (for now) default branch
-	// TODO: Add support for passing in a specific branch, to get the latest commit for that instead
-	owner, fileName, dbTable, commitID, err := com.GetODTC(2, r) // 2 = Ignore "/x/download/" at the start of the URL
-	if err != nil {
-		errorPage(w, r, http.StatusBadRequest, err.Error())
+	// Converting models takes a while, so only logged in users can send them
+	loggedInUser := sessionUser(r)
+	if loggedInUser == "" {
+		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
 
-	// Abort if the table name was missing
-	if dbTable == "" {
-		log.Printf("%s: Missing table name\n", pageName)
-		errorPage(w, r, http.StatusBadRequest, "Missing table name")
+	// Extract the owner and database name
+	// TODO: Add folder support
+	owner, fileName, err := com.GetOD(2, r) // 2 = Ignore "/x/printquote/" at the start of the URL
+	if err != nil || owner == "" || fileName == "" {
+		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-
-	// Retrieve session data (if any)
-	var loggedInUser string
-	var u interface{}
-	if com.Conf.Environment.Environment != "docker" {
-		sess, err := store.Get(r, "3dhub-user")
-		if err != nil {
-			errorPage(w, r, http.StatusBadRequest, err.Error())
-			return
-		}
-		u = sess.Values["UserName"]
-	} else {
-		u = "default"
-	}
-	if u != nil {
-		loggedInUser = u.(string)
-	}
-
-	// Verify the given database exists and is ok to be downloaded (and get the Minio bucket + id while at it)
-	bucket, id, _, err := com.MinioLocation(owner, "/", fileName, commitID, loggedInUser)
+	folder := "/"
+	commitID, err := com.GetFormCommit(r)
 	if err != nil {
-		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, err.Error())
 		return
 	}
-
-	// Ensure the database being requested isn't overly large
-	var tmp com.SQLiteDBinfo
-	err = com.DBDetails(&tmp, loggedInUser, owner, "/", fileName, commitID)
-	if err != nil {
-		errorPage(w, r, http.StatusInternalServerError, err.Error())
+	svc, found := com.PrintServiceByID(r.PostFormValue("service"))
+	if !found {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Unknown print service")
 		return
 	}
-	size := tmp.Info.DBEntry.Size
-	if size >= 100000000 {
-		errorPage(w, r, http.StatusBadRequest, "Redash JSON export not allowed for this database due to size "+
-			"restrictions.")
+	scale, err := strconv.Atoi(r.PostFormValue("scale"))
+	if err != nil || scale < com.PrintScaleMin || scale > com.PrintScaleMax {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "The scale needs to be a percentage between %d and %d", com.PrintScaleMin,
+			com.PrintScaleMax)
 		return
 	}
 
-	// Get a handle from Minio for the database object
-	sdb, err := com.OpenMinioObject(bucket, id)
+	// Make sure the project exists, and the user has access to it
+	exists, err := com.CheckFileExists(loggedInUser, owner, folder, fileName)
 	if err != nil {
-		errorPage(w, r, http.StatusInternalServerError, "Database query failed")
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-
-	// Automatically close the SQLite database when this function finishes
-	defer func() {
-		sdb.Close()
-	}()
-
-	// Read the table data from the database object
-	resultSet, err := com.ReadSQLiteDBRedash(sdb, dbTable)
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	bucket, id, _, err := com.MinioLocation(owner, folder, fileName, commitID, loggedInUser)
 	if err != nil {
-		errorPage(w, r, http.StatusInternalServerError, "Error reading table data from the database")
+		w.WriteHeader(http.StatusNotFound)
 		return
 	}
 
-	// Convert the sqlite table data to JSON
-	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.json"`, dbTable))
-	w.Header().Set("Content-Type", "application/json")
-	j, err := json.MarshalIndent(resultSet, "", " ")
+	quoteURL, err := com.SendToPrintService(svc, bucket, id, fileName, scale)
 	if err != nil {
-		log.Println(err)
+		com.Log.Warnf("%s: sending '%s%s%s' to '%s' failed: %v", pageName, owner, folder, fileName, svc.ID, err)
+		w.WriteHeader(http.StatusBadGateway)
+		fmt.Fprint(w, err.Error())
 		return
 	}
-
-	// Send the JSON to the user
-	w.WriteHeader(http.StatusOK)
-	_, err = fmt.Fprint(w, string(j))
+	data, err := json.MarshalIndent(map[string]string{"url": quoteURL}, "", " ")
 	if err != nil {
-		log.Printf("%s: Error when generating JSON: %v\n", pageName, err)
-		errorPage(w, r, http.StatusInternalServerError, "Error when generating JSON")
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, string(data))
 }
 
-// Forks a database for the logged in user.
-func forkDBHandler(w http.ResponseWriter, r *http.Request) {
-	// Retrieve username, database name, and commit ID
-	owner, fileName, commitID, err := com.GetODC(2, r) // 2 = Ignore "/x/forkdb/" at the start of the URL
-	if err != nil {
-		errorPage(w, r, http.StatusBadRequest, err.Error())
+// Saves or removes one of the logged in user's project templates, from their preferences page.
+func projectTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
+
+	// Ensure we have a valid logged in user
+	if loggedInUser == "" {
+		errorPage(w, r, http.StatusUnauthorized, "You need to be logged in")
 		return
 	}
-	folder := "/"
-
-	// Make sure a database commit ID was given
-	if commitID == "" {
-		errorPage(w, r, http.StatusBadRequest, "No database commit ID given")
+	_, _, status, err := changeProjectTemplate(r, loggedInUser)
+	if err != nil {
+		errorPage(w, r, status, err.Error())
 		return
 	}
+	http.Redirect(w, r, "/pref#templates", http.StatusSeeOther)
+}
 
-	// Retrieve session data (if any)
-	var loggedInUser string
-	var u interface{}
-	validSession := false
-	if com.Conf.Environment.Environment != "docker" {
-		sess, err := store.Get(r, "3dhub-user")
-		if err != nil {
-			errorPage(w, r, http.StatusBadRequest, err.Error())
-			return
-		}
-		u = sess.Values["UserName"]
-	} else {
-		u = "default"
-	}
-	if u != nil {
-		loggedInUser = u.(string)
-		validSession = true
-	}
+// Publishes a draft project, from its project page.  From then on the project's public/private setting applies, so
+// public projects can be seen by everyone.
+func publishDraftHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
 
 	// Ensure we have a valid logged in user
-	if validSession != true {
-		// No logged in username, so nothing to update
-		errorPage(w, r, http.StatusBadRequest, "To fork a database, you need to be logged in")
+	if loggedInUser == "" {
+		errorPage(w, r, http.StatusUnauthorized, "You need to be logged in")
 		return
 	}
 
-	// Check the user has access to the specific version of the source database requested
-	allowed, err := com.CheckFileExists(loggedInUser, owner, folder, fileName)
+	// Only the owner of a project can publish it
+	owner, folder, fileName, err := com.GetUFD(r, false)
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if strings.ToLower(owner) != strings.ToLower(loggedInUser) {
+		errorPage(w, r, http.StatusForbidden, "Only the owner of a project can publish it")
+		return
+	}
+	published, err := com.PublishDraft(owner, folder, fileName)
 	if err != nil {
 		errorPage(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
-	if !allowed {
-		errorPage(w, r, http.StatusNotFound, "You don't have access to the requested database")
+	if !published {
+		errorPage(w, r, http.StatusBadRequest, "That project isn't a draft")
 		return
 	}
 
-	// Make sure the source and destination owners are different
-	if strings.ToLower(loggedInUser) == strings.ToLower(owner) {
-		errorPage(w, r, http.StatusBadRequest, "Forking your own database in-place doesn't make sense")
-		return
+	// Invalidate the old memcached entry for the project
+	err = com.InvalidateCacheEntry(loggedInUser, owner, folder, fileName, "")
+	if err != nil {
+		com.Log.Errorf("Error when invalidating memcache entries: %s", err.Error())
 	}
 
-	// Make sure the user doesn't have a database of the same name already
-	// Note the use of "loggedInUser" for the 2nd parameter in this call, unlike using "owner" in the call above
-	exists, err := com.CheckFileExists(loggedInUser, loggedInUser, folder, fileName)
+	// Public projects are now visible to everyone, so check their details for spam
+	public, err := com.ProjectPublic(owner, folder, fileName)
+	if err == nil && public {
+		oneLineDesc, fullDesc, err := com.ProjectDescriptions(owner, folder, fileName)
+		if err == nil {
+			readme, _ := com.ProjectReadme(owner, folder, fileName)
+			checkProjectSpam(r, owner, folder, fileName, strings.Join([]string{fileName, oneLineDesc, fullDesc,
+				readme}, "\n"))
+		}
+	}
+
+	// Update the search index
+	err = com.UpdateSearchIndex(owner, folder, fileName)
 	if err != nil {
-		errorPage(w, r, http.StatusInternalServerError, err.Error())
-		return
+		com.Log.Errorf("Error when updating the search index: %s", err.Error())
 	}
-	if exists {
-		// Database of the same name already exists
-		errorPage(w, r, http.StatusNotFound, "You already have a database of this name")
+	com.Log.Infof("Draft project '%s%s%s' published by its owner", owner, folder, fileName)
+
+	// Return to the project page
+	http.Redirect(w, r, com.ProjectPath(loggedInUser, folder, fileName), http.StatusSeeOther)
+}
+
+// Returns a QR code linking to a project, for printed documentation and the like.  The format (png or svg) and size
+// (the width of a png in pixels) are optional, and giving a release links to that release instead of the latest
+// version.
+func qrCodeHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
+
+	// Extract the owner and database name
+	// TODO: Add folder support
+	owner, fileName, err := com.GetOD(2, r) // 2 = Ignore "/x/qr/" at the start of the URL
+	if err != nil || owner == "" || fileName == "" {
+		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-
-	// Add the forked database info to PostgreSQL
-	_, err = com.ForkDatabase(owner, folder, fileName, loggedInUser)
+	folder := "/"
+	releaseName, err := com.GetFormRelease(r)
 	if err != nil {
-		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, err.Error())
 		return
 	}
-
-	// Add the user to the watch list for the forked database
-	if !exists {
-		err = com.ToggleProjectWatch(loggedInUser, loggedInUser, folder, fileName)
+	format := r.FormValue("format")
+	if format == "" {
+		format = "png"
+	}
+	size := com.QRCodeDefaultSize
+	if s := r.FormValue("size"); s != "" {
+		size, err = strconv.Atoi(s)
 		if err != nil {
-			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, "Invalid size")
 			return
 		}
 	}
 
-	// Invalidate the old memcached entry for the database
-	err = com.InvalidateCacheEntry(loggedInUser, owner, folder, fileName, "") // Empty string indicates "for all versions"
+	// Make sure the project exists in the system, and the user has access to it
+	exists, err := com.CheckFileExists(loggedInUser, owner, folder, fileName)
 	if err != nil {
-		// Something went wrong when invalidating memcached entries for the database
-		log.Printf("Error when invalidating memcache entries: %s\n", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
 		return
 	}
 
-	// Log the database fork
-	log.Printf("Database '%s%s%s' forked to user '%s'\n", owner, folder, fileName, loggedInUser)
-
-	// Bounce to the page of the forked database
-	http.Redirect(w, r, fmt.Sprintf("/%s%s%s", loggedInUser, folder, fileName), http.StatusSeeOther)
-}
-
-// Generates a client certificate for the user and gives it to the browser.
-func generateCertHandler(w http.ResponseWriter, r *http.Request) {
-	// Retrieve session data (if any)
-	var loggedInUser string
-	var u interface{}
-	validSession := false
-	if com.Conf.Environment.Environment != "docker" {
-		sess, err := store.Get(r, "3dhub-user")
+	// Use the owner's own capitalisation of their username, so the links all look the same
+	usr, err := com.User(owner)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	projectURL := fmt.Sprintf("https://%s/%s%s%s", com.Conf().Web.ServerName, usr.Username, folder, fileName)
+	if releaseName != "" {
+		releases, err := com.GetReleases(owner, folder, fileName)
 		if err != nil {
-			errorPage(w, r, http.StatusBadRequest, err.Error())
+			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		u = sess.Values["UserName"]
-	} else {
-		u = "default"
+		if _, ok := releases[releaseName]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, "Unknown release")
+			return
+		}
+		projectURL += "?release=" + url.QueryEscape(releaseName)
+	}
+
+	data, contentType, err := com.QRCode(projectURL, format, size)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, err.Error())
+		return
 	}
-	if u != nil {
-		loggedInUser = u.(string)
-		validSession = true
+	if com.NotModified(w, r, com.ContentETag(data), time.Time{}) {
+		return
 	}
+	w.Header().Set("Content-Type", contentType)
+	w.Write(data)
+}
+
+// Handles the changes people make in a project's Q&A section.  The "action" form value is one of "ask" (a new
+// question), "answer", "accept" (mark an answer as the accepted one, or remove the mark when no answer is given), or
+// "close" (close or reopen a question).
+func questionHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
 
 	// Ensure we have a valid logged in user
-	if validSession != true {
-		// No logged in user, so error out
-		errorPage(w, r, http.StatusBadRequest, "Not logged in")
+	if loggedInUser == "" {
+		errorPage(w, r, http.StatusUnauthorized, "You need to be logged in")
 		return
 	}
 
-	// Generate a new certificate
-	newCert, err := com.GenerateClientCert(loggedInUser)
+	// Extract and validate the form variables
+	owner, folder, fileName, err := com.GetUFD(r, false)
 	if err != nil {
-		log.Printf("Error generating client certificate for user '%s': %s!\n", loggedInUser, err)
-		errorPage(w, r, http.StatusInternalServerError, "Error generating client certificate")
+		errorPage(w, r, http.StatusBadRequest, "Missing or incorrect data supplied")
 		return
 	}
+	action := r.PostFormValue("action")
+	var discID int
+	if action != "ask" {
+		discID, err = strconv.Atoi(r.PostFormValue("discid"))
+		if err != nil || discID < 1 {
+			errorPage(w, r, http.StatusBadRequest, "Missing or invalid question id")
+			return
+		}
+	}
 
-	// Send the client certificate to the user
-	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.cert.pem"`, loggedInUser))
-	// Note, don't use "application/x-x509-user-cert", otherwise the browser may try to install it!
-	// Useful reference info: https://pki-tutorial.readthedocs.io/en/latest/mime.html
-	w.Header().Set("Content-Type", "application/x-pem-file")
-	w.Write(newCert)
-	return
-}
-
-// Removes the logged in users session information.
-func logoutHandler(w http.ResponseWriter, r *http.Request) {
-	// Remove session info
-	sess, err := store.Get(r, "3dhub-user")
+	// Check if the requested database exists
+	exists, err := com.CheckFileExists(loggedInUser, owner, folder, fileName)
 	if err != nil {
-		errorPage(w, r, http.StatusBadRequest, err.Error())
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
-	// Note : gorilla/sessions uses MaxAge < 0 to mean "delete this session"
-	sess.Options.MaxAge = -1
-	err = sess.Save(r, w)
+	if !exists {
+		errorPage(w, r, http.StatusNotFound, fmt.Sprintf("Database '%s%s%s' doesn't exist", owner, folder,
+			fileName))
+		return
+	}
+
+	// Archived projects are read-only
+	archived, err := com.ProjectArchived(owner, folder, fileName)
 	if err != nil {
 		errorPage(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
+	if archived {
+		errorPage(w, r, http.StatusForbidden, "This project is archived, so it's read-only")
+		return
+	}
 
-	// Bounce to the front page
-	// TODO: This should probably reload the existing page instead
-	http.Redirect(w, r, "/", http.StatusSeeOther)
-}
+	// Everything other than asking a new question is about an existing one
+	var question com.DiscussionEntry
+	if action != "ask" {
+		list, err := com.Discussions(owner, folder, fileName, com.QUESTION, discID)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if len(list) == 0 {
+			errorPage(w, r, http.StatusNotFound, "Unknown question ID")
+			return
+		}
+		question = list[0]
+	}
+	questionPath := "/questions" + com.ProjectPath(owner, folder, fileName)
 
-// Wrapper function to log incoming https requests.
-func logReq(fn http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		// Check if user is logged in
-		var loggedInUser string
-		sess, err := store.Get(r, "3dhub-user")
+	switch action {
+	case "ask":
+		title := r.PostFormValue("title")
+		err = com.ValidateDiscussionTitle(title)
+		if err != nil || title == "" {
+			errorPage(w, r, http.StatusBadRequest, "Missing title, or invalid characters in it")
+			return
+		}
+		txt := r.PostFormValue("body")
+		if txt == "" {
+			errorPage(w, r, http.StatusBadRequest, "The question can't be empty")
+			return
+		}
+		err = com.Validate.Var(txt, "markdownsource")
 		if err != nil {
-			if err == memcache.ErrCacheMiss {
-				// If the memcache session token is stale (eg memcached has been restarted), delete the session
-				// TODO: This should probably look for the session token in persistent storage (eg PG) instead, so
-				// TODO  restarts of memcached don't nuke everyone's saved sessions
+			errorPage(w, r, http.StatusBadRequest, "Invalid characters in the question")
+			return
+		}
+		discID, err = com.StoreDiscussion(owner, folder, fileName, loggedInUser, title, txt, com.QUESTION,
+			com.MergeRequestEntry{})
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
 
-				// Delete the session
-				// Note : gorilla/sessions uses MaxAge < 0 to mean "delete this session"
-				sess.Options.MaxAge = -1
-				err = sess.Save(r, w)
-				if err != nil {
-					errorPage(w, r, http.StatusInternalServerError, err.Error())
-					return
-				}
+		// Let the project owner (and anyone watching the project) know about the new question
+		details := com.EventDetails{
+			DBName:   fileName,
+			DiscID:   discID,
+			Folder:   folder,
+			Owner:    owner,
+			Title:    title,
+			Type:     com.EVENT_NEW_QUESTION,
+			URL:      fmt.Sprintf("%s?id=%d", questionPath, discID),
+			UserName: loggedInUser,
+		}
+		err = com.NewEvent(details)
+		if err != nil {
+			com.Log.Errorf("Error when creating a new event: %s", err.Error())
+		}
 
-				// Reload the page
-				http.Redirect(w, r, fmt.Sprintf("%s", r.URL), http.StatusTemporaryRedirect)
-			} else {
-				errorPage(w, r, http.StatusBadRequest, err.Error())
+	case "answer":
+		if !question.Open {
+			errorPage(w, r, http.StatusBadRequest, "This question is closed")
+			return
+		}
+		txt := r.PostFormValue("body")
+		if txt == "" {
+			errorPage(w, r, http.StatusBadRequest, "The answer can't be empty")
+			return
+		}
+		err = com.Validate.Var(txt, "markdownsource")
+		if err != nil {
+			errorPage(w, r, http.StatusBadRequest, "Invalid characters in the answer")
+			return
+		}
+
+		// Answers which match the word filter or look like spam are held for moderation, the same as comments
+		var reason string
+		if pattern, matched := com.FilterText(txt); matched {
+			reason = fmt.Sprintf("Word filter: matched '%s'", pattern)
+		} else if spam, why, _ := com.CheckSpam(loggedInUser, clientIP(r), r.Header.Get("User-Agent"), "comment",
+			txt); spam {
+			reason = "Automatic spam check: " + why
+		}
+		if reason != "" {
+			err = com.HoldComment(owner, folder, fileName, loggedInUser, discID, txt, reason)
+			if err != nil {
+				errorPage(w, r, http.StatusInternalServerError, err.Error())
 				return
 			}
+			http.Redirect(w, r, fmt.Sprintf("%s?id=%d&held=1", questionPath, discID), http.StatusSeeOther)
+			return
 		}
-		u := sess.Values["UserName"]
-		if u != nil {
-			loggedInUser = u.(string)
-		} else {
-			loggedInUser = "-"
+		err = com.StoreComment(owner, folder, fileName, loggedInUser, discID, txt, false,
+			com.CLOSED_WITHOUT_MERGE) // com.CLOSED_WITHOUT_MERGE is ignored for questions.  It's only used for MRs
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
 		}
 
-		if com.Conf.Environment.Environment == "docker" {
-			loggedInUser = "default"
+	case "accept":
+		// Answers can be accepted by the person who asked the question, or the project owner
+		if strings.ToLower(loggedInUser) != strings.ToLower(owner) &&
+			strings.ToLower(loggedInUser) != strings.ToLower(question.Creator) {
+			errorPage(w, r, http.StatusForbidden, "Only the person who asked the question or the project owner "+
+				"can accept an answer")
+			return
+		}
+		var comID int
+		if c := r.PostFormValue("comid"); c != "" {
+			comID, err = strconv.Atoi(c)
+			if err != nil || comID < 1 {
+				errorPage(w, r, http.StatusBadRequest, "Invalid answer id")
+				return
+			}
+		}
+		err = com.AcceptAnswer(owner, folder, fileName, discID, comID)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, "Couldn't accept that answer")
+			return
 		}
 
-		// Write request details to the request log
-		fmt.Fprintf(reqLog, "%v - %s [%s] \"%s %s %s\" \"-\" \"-\" \"%s\" \"%s\"\n", r.RemoteAddr,
-			loggedInUser, time.Now().Format(time.RFC3339Nano), r.Method, r.URL, r.Proto,
-			r.Referer(), r.Header.Get("User-Agent"))
+	case "close":
+		// StoreComment() makes sure only the person who asked the question or the project owner can do this
+		err = com.StoreComment(owner, folder, fileName, loggedInUser, discID, "", true, com.CLOSED_WITHOUT_MERGE)
+		if err != nil {
+			errorPage(w, r, http.StatusForbidden, err.Error())
+			return
+		}
 
-		// Call the original function
-		fn(w, r)
+	default:
+		errorPage(w, r, http.StatusBadRequest, "Unknown action")
+		return
 	}
+
+	// Bounce to the question
+	http.Redirect(w, r, fmt.Sprintf("%s?id=%d", questionPath, discID), http.StatusSeeOther)
 }
 
-func main() {
-	// Read server configuration
-	var err error
-	if err = com.ReadConfig(); err != nil {
-		log.Fatalf("Configuration file problem\n\n%v", err)
+// Returns the README for a project as raw Markdown, for API clients that want to render it themselves.  A language tag
+// can be given in the "lang" argument to get the README's translation into that language.
+func readmeHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
+
+	// Extract the owner and database name
+	// TODO: Add folder support
+	owner, fileName, err := com.GetOD(2, r) // 2 = Ignore "/x/readme/" at the start of the URL
+	if err != nil || owner == "" || fileName == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
 	}
+	folder := "/"
 
-	// Set the temp dir environment variable
-	err = os.Setenv("TMPDIR", com.Conf.DiskCache.Directory)
+	// Make sure the database exists in the system, and the user has access to it
+	exists, err := com.CheckFileExists(loggedInUser, owner, folder, fileName)
 	if err != nil {
-		log.Fatalf("Setting temp directory environment variable failed: '%s'\n", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		return
 	}
 
-	// Open the request log for writing
-	reqLog, err = os.OpenFile(com.Conf.Web.RequestLog, os.O_CREATE|os.O_APPEND|os.O_WRONLY|os.O_SYNC, 0750)
-	if err != nil {
-		log.Fatalf("Error when opening request log: %s\n", err)
+	// If a language was asked for, return the translation of the README into it instead
+	var readme string
+	if lang := strings.ToLower(r.FormValue("lang")); lang != "" {
+		translations, err := com.ProjectTranslations(owner, folder, fileName)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		for _, t := range translations {
+			if t.Language == lang {
+				readme = t.Readme
+			}
+		}
+	} else {
+		readme, err = com.ProjectReadme(owner, folder, fileName)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
 	}
-	defer reqLog.Close()
-	log.Printf("Request log opened: %s\n", com.Conf.Web.RequestLog)
+	if readme == "" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if com.NotModified(w, r, com.ContentETag([]byte(readme)), time.Time{}) {
+		return
+	}
+	w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+	fmt.Fprint(w, readme)
+}
 
-	// Parse our template files
-	tmpl = template.Must(template.New("templates").Delims("[[", "]]").ParseGlob(
-		filepath.Join(com.Conf.Web.BaseDir, "webui", "templates", "*.html")))
+// Queues a re-analysis of a project's file, which re-runs the upload checks on it and updates its triangle count.
+// Only available to the owner of the project.
+func reanalyseHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
 
-	// Connect to Minio server
-	err = com.ConnectMinio()
-	if err != nil {
-		log.Fatalf(err.Error())
+	// Ensure we have a valid logged in user
+	if loggedInUser == "" {
+		errorPage(w, r, http.StatusUnauthorized, "You need to be logged in")
+		return
 	}
 
-	// Connect to PostgreSQL server
-	err = com.ConnectPostgreSQL()
+	owner, folder, fileName, err := com.GetUFD(r, false)
 	if err != nil {
-		log.Fatalf(err.Error())
+		errorPage(w, r, http.StatusBadRequest, err.Error())
+		return
 	}
-
-	// Add the default user to the system
-	// Note - we don't check for an error here on purpose.  If we were to fail on an error, then subsequent runs after
-	// the first would barf with PG errors about trying to insert multiple "default" users violating unique
-	// constraints.  It would be solvable by creating a special purpose PL/pgSQL function just for this one use case...
-	// or we could just ignore failures here. ;)
-	com.AddDefaultUser()
-
-	// Add the default licences to PostgreSQL
-	err = com.AddDefaultLicences()
-	if err != nil {
-		log.Fatalf(err.Error())
+	if strings.ToLower(owner) != strings.ToLower(loggedInUser) {
+		errorPage(w, r, http.StatusForbidden, "Only the owner of a project can have it re-analysed")
+		return
 	}
-
-	// Connect to the Memcached server
-	err = com.ConnectCache()
+	exists, err := com.CheckFileExists(loggedInUser, owner, folder, fileName)
 	if err != nil {
-		log.Fatalf(err.Error())
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !exists {
+		errorPage(w, r, http.StatusNotFound, "That project doesn't exist")
+		return
 	}
 
-	// Setup session storage
-	store = gsm.NewMemcacheStore(com.MemcacheHandle(), "dbhub_", []byte(com.Conf.Web.SessionStorePassword))
-
-	// Start the view count flushing routine in the background
-	go com.FlushViewCount()
-
-	// Start the status update processing goroutine in the background (will likely need moving into a separate daemon)
-	go com.StatusUpdatesLoop()
-
-	// Start the email sending goroutine in the background
-	go com.SendEmails()
-
-	// Our pages
-	http.Handle("/", gz.GzipHandler(logReq(mainHandler)))
-	http.Handle("/about", gz.GzipHandler(logReq(aboutPage)))
-	http.Handle("/branches/", gz.GzipHandler(logReq(branchesPage)))
-	http.Handle("/commits/", gz.GzipHandler(logReq(commitsPage)))
-	http.Handle("/compare/", gz.GzipHandler(logReq(comparePage)))
-	http.Handle("/confirmdelete/", gz.GzipHandler(logReq(confirmDeletePage)))
-	http.Handle("/contributors/", gz.GzipHandler(logReq(contributorsPage)))
-	http.Handle("/createbranch/", gz.GzipHandler(logReq(createBranchPage)))
-	http.Handle("/creatediscuss/", gz.GzipHandler(logReq(createDiscussionPage)))
-	http.Handle("/createtag/", gz.GzipHandler(logReq(createTagPage)))
-	http.Handle("/discuss/", gz.GzipHandler(logReq(discussPage)))
-	http.Handle("/forks/", gz.GzipHandler(logReq(forksPage)))
-	http.Handle("/logout", gz.GzipHandler(logReq(logoutHandler)))
-	http.Handle("/merge/", gz.GzipHandler(logReq(mergePage)))
-	http.Handle("/pref", gz.GzipHandler(logReq(prefHandler)))
-	http.Handle("/register", gz.GzipHandler(logReq(createUserHandler)))
-	http.Handle("/releases/", gz.GzipHandler(logReq(releasesPage)))
-	http.Handle("/selectusername", gz.GzipHandler(logReq(selectUserNamePage)))
-	http.Handle("/settings/", gz.GzipHandler(logReq(settingsPage)))
-	http.Handle("/stars/", gz.GzipHandler(logReq(starsPage)))
-	http.Handle("/tags/", gz.GzipHandler(logReq(tagsPage)))
-	http.Handle("/updates/", gz.GzipHandler(logReq(updatesPage)))
-	http.Handle("/upload/", gz.GzipHandler(logReq(uploadPage)))
-	http.Handle("/watchers/", gz.GzipHandler(logReq(watchersPage)))
-	http.Handle("/x/branchnames", gz.GzipHandler(logReq(branchNamesHandler)))
-	http.Handle("/x/callback", gz.GzipHandler(logReq(auth0CallbackHandler)))
-	http.Handle("/x/checkname", gz.GzipHandler(logReq(checkNameHandler)))
-	http.Handle("/x/createbranch", gz.GzipHandler(logReq(createBranchHandler)))
-	http.Handle("/x/createcomment/", gz.GzipHandler(logReq(createCommentHandler)))
-	http.Handle("/x/creatediscuss", gz.GzipHandler(logReq(createDiscussHandler)))
-	http.Handle("/x/createmerge/", gz.GzipHandler(logReq(createMergeHandler)))
-	http.Handle("/x/createtag", gz.GzipHandler(logReq(createTagHandler)))
-	http.Handle("/x/deletebranch/", gz.GzipHandler(logReq(deleteBranchHandler)))
-	http.Handle("/x/deletecomment/", gz.GzipHandler(logReq(deleteCommentHandler)))
-	http.Handle("/x/deletecommit/", gz.GzipHandler(logReq(deleteCommitHandler)))
-	http.Handle("/x/deletedatabase/", gz.GzipHandler(logReq(deleteDatabaseHandler)))
-	http.Handle("/x/deleterelease/", gz.GzipHandler(logReq(deleteReleaseHandler)))
-	http.Handle("/x/deletetag/", gz.GzipHandler(logReq(deleteTagHandler)))
-	http.Handle("/x/diffcommitlist/", gz.GzipHandler(logReq(diffCommitListHandler)))
-	http.Handle("/x/download/", gz.GzipHandler(logReq(downloadHandler)))
-	http.Handle("/x/downloadcsv/", gz.GzipHandler(logReq(downloadCSVHandler)))
-	http.Handle("/x/downloadredashjson/", gz.GzipHandler(logReq(downloadRedashJSONHandler)))
-	http.Handle("/x/forkdb/", gz.GzipHandler(logReq(forkDBHandler)))
-	http.Handle("/x/gencert", gz.GzipHandler(logReq(generateCertHandler)))
-	http.Handle("/x/markdownpreview/", gz.GzipHandler(logReq(markdownPreview)))
-	http.Handle("/x/mergerequest/", gz.GzipHandler(logReq(mergeRequestHandler)))
-	http.Handle("/x/savesettings", gz.GzipHandler(logReq(saveSettingsHandler)))
-	http.Handle("/x/setdefaultbranch/", gz.GzipHandler(logReq(setDefaultBranchHandler)))
-	http.Handle("/x/star/", gz.GzipHandler(logReq(starToggleHandler)))
-	http.Handle("/x/table/", gz.GzipHandler(logReq(tableViewHandler)))
-	http.Handle("/x/tablenames/", gz.GzipHandler(logReq(tableNamesHandler)))
-	http.Handle("/x/updatebranch/", gz.GzipHandler(logReq(updateBranchHandler)))
-	http.Handle("/x/updatecomment/", gz.GzipHandler(logReq(updateCommentHandler)))
-	http.Handle("/x/updatediscuss/", gz.GzipHandler(logReq(updateDiscussHandler)))
-	http.Handle("/x/updaterelease/", gz.GzipHandler(logReq(updateReleaseHandler)))
-	http.Handle("/x/updatetag/", gz.GzipHandler(logReq(updateTagHandler)))
-	http.Handle("/x/uploaddata/", gz.GzipHandler(logReq(uploadFileHandler)))
-	http.Handle("/x/watch/", gz.GzipHandler(logReq(watchToggleHandler)))
-
-	// CSS
-	http.Handle("/css/bootstrap-3.3.7.min.css", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "css", "bootstrap-3.3.7.min.css"))
-	})))
-	http.Handle("/css/bootstrap.min.css.map", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "css", "bootstrap-3.3.7.min.css.map"))
-	})))
-	http.Handle("/css/font-awesome-4.7.0.min.css", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "css", "font-awesome-4.7.0.min.css"))
-	})))
-	http.Handle("/css/local.css", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "css", "local.css"))
-	})))
-	http.Handle("/css/angular-bootstrap-lightbox.min.css", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "css", "angular-bootstrap-lightbox.min.css"))
-	})))
-
-	// Fonts
-	http.Handle("/css/FontAwesome.otf", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "fonts", "FontAwesome-4.7.0.otf"))
-	})))
-	http.Handle("/css/fontawesome-webfont.eot", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "fonts", "fontawesome-webfont-4.7.0.eot"))
-	})))
-	http.Handle("/css/fontawesome-webfont.svg", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "fonts", "fontawesome-webfont-4.7.0.svg"))
-	})))
-	http.Handle("/css/fontawesome-webfont.ttf", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "fonts", "fontawesome-webfont-4.7.0.ttf"))
-	})))
-	http.Handle("/css/fontawesome-webfont.woff", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "fonts", "fontawesome-webfont-4.7.0.woff"))
-	})))
-	http.Handle("/css/fontawesome-webfont.woff2", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "fonts", "fontawesome-webfont-4.7.0.woff2"))
-	})))
-
-	// Javascript
-	http.Handle("/js/angular-1.7.8.min.js", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "js", "angular-1.7.8.min.js"))
-	})))
-	http.Handle("/js/angular.min.js.map", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "js", "angular-1.7.8.min.js.map"))
-	})))
-	http.Handle("/js/angular-sanitize-1.7.8.min.js", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "js", "angular-sanitize-1.7.8.min.js"))
-	})))
-	http.Handle("/js/angular-sanitize.min.js.map", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "js", "angular-sanitize-1.7.8.min.js.map"))
-	})))
-	http.Handle("/js/angular-bootstrap-lightbox.min.js", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "js", "angular-bootstrap-lightbox.min.js"))
-	})))
-	http.Handle("/js/local.js", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "js", "local.js"))
-	})))
-	http.Handle("/js/lock-11.14.1.min.js", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "js", "lock-11.14.1.min.js"))
-	})))
-	http.Handle("/js/lock.min.js.map", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "js", "lock-11.14.1.min.js.map"))
-	})))
-	http.Handle("/js/ui-bootstrap-tpls-2.5.0.min.js", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "js", "ui-bootstrap-tpls-2.5.0.min.js"))
-	})))
-
-	// Other static files
-	http.Handle("/images/auth0.svg", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "images", "auth0.svg"))
-	})))
-	http.Handle("/images/sqlitebrowser.svg", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "images", "sqlitebrowser.svg"))
-	})))
-	http.Handle("/favicon.ico", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "favicon.ico"))
-	})))
-	http.Handle("/robots.txt", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "robots.txt"))
-	})))
-
-	// Landing page images
-	http.Handle("/images/db4s_screenshot1.png", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "images", "db4s_screenshot1.png"))
-	})))
-	http.Handle("/images/db4s_screenshot1-50px.png", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "images", "db4s_screenshot1-50px.png"))
-	})))
-	http.Handle("/images/db4s_screenshot2.png", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "images", "db4s_screenshot2.png"))
-	})))
-	http.Handle("/images/db4s_screenshot2-50px.png", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "images", "db4s_screenshot2-50px.png"))
-	})))
-	http.Handle("/images/db4s_screenshot3.png", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "images", "db4s_screenshot3.png"))
-	})))
-	http.Handle("/images/db4s_screenshot3-50px.png", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "images", "db4s_screenshot3-50px.png"))
-	})))
-	http.Handle("/images/db4s_screenshot4.png", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "images", "db4s_screenshot4.png"))
-	})))
-	http.Handle("/images/db4s_screenshot4-50px.png", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "images", "db4s_screenshot4-50px.png"))
-	})))
-	http.Handle("/images/pub_priv1.png", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "images", "pub_priv1.png"))
-	})))
-	http.Handle("/images/pub_priv1-50px.png", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "images", "pub_priv1-50px.png"))
-	})))
-	http.Handle("/images/watch1.png", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "images", "watch1.png"))
-	})))
-	http.Handle("/images/watch1-46px.png", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "images", "watch1-46px.png"))
-	})))
-	http.Handle("/images/discussions1.png", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "images", "discussions1.png"))
-	})))
-	http.Handle("/images/discussions1-50px.png", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "images", "discussions1-50px.png"))
-	})))
-	http.Handle("/images/discussions2.png", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "images", "discussions2.png"))
-	})))
-	http.Handle("/images/discussions2-50px.png", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "images", "discussions2-50px.png"))
-	})))
-	http.Handle("/images/discussions3.png", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "images", "discussions3.png"))
-	})))
-	http.Handle("/images/discussions3-50px.png", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "images", "discussions3-50px.png"))
-	})))
-	http.Handle("/images/version_control_history1.png", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "images", "version_control_history1.png"))
-	})))
-	http.Handle("/images/version_control_history1-50px.png", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "images", "version_control_history1-50px.png"))
-	})))
-	http.Handle("/images/version_control_history2.png", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "images", "version_control_history2.png"))
-	})))
-	http.Handle("/images/version_control_history2-50px.png", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "images", "version_control_history2-50px.png"))
-	})))
-	http.Handle("/images/merge1.png", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "images", "merge1.png"))
-	})))
-	http.Handle("/images/merge1-50px.png", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "images", "merge1-50px.png"))
-	})))
-	http.Handle("/images/merge2.png", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "images", "merge2.png"))
-	})))
-	http.Handle("/images/merge2-50px.png", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "images", "merge2-50px.png"))
-	})))
-	http.Handle("/images/merge3.png", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "images", "merge3.png"))
-	})))
-	http.Handle("/images/merge3-50px.png", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "images", "merge3-50px.png"))
-	})))
-	http.Handle("/images/merge4.png", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "images", "merge4.png"))
-	})))
-	http.Handle("/images/merge4-50px.png", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "images", "merge4-50px.png"))
-	})))
-	http.Handle("/images/redash1.png", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "images", "redash1.png"))
-	})))
-	http.Handle("/images/redash1-50px.png", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "images", "redash1-50px.png"))
-	})))
-	http.Handle("/images/redash2.png", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "images", "redash2.png"))
-	})))
-	http.Handle("/images/redash2-50px.png", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "images", "redash2-50px.png"))
-	})))
-	http.Handle("/images/redash3.png", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "images", "redash3.png"))
-	})))
-	http.Handle("/images/redash3-50px.png", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "images", "redash3-50px.png"))
-	})))
-	http.Handle("/images/redash4.png", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "images", "redash4.png"))
-	})))
-	http.Handle("/images/redash4-50px.png", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "images", "redash4-50px.png"))
-	})))
-	http.Handle("/images/dbhub-vis-720.mp4", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "images", "dbhub-vis-720.mp4"))
-	})))
-	http.Handle("/images/dbhub-vis-720.webm", gz.GzipHandler(logReq(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(com.Conf.Web.BaseDir, "webui", "images", "dbhub-vis-720.webm"))
-	})))
-
-	// Start webUI server
-	log.Printf("%s server starting on https://%s\n", com.Conf.Web.WebsiteName, com.Conf.Web.ServerName)
-	err = http.ListenAndServeTLS(com.Conf.Web.BindAddress, com.Conf.Web.Certificate, com.Conf.Web.CertificateKey, nil)
-
-	// Shut down nicely
-	com.DisconnectPostgreSQL()
-
+	err = com.QueueReanalysis(loggedInUser, folder, fileName)
 	if err != nil {
-		log.Fatal(err)
+		errorPage(w, r, http.StatusInternalServerError, "Queuing the re-analysis failed")
+		return
 	}
-}
 
-func mainHandler(w http.ResponseWriter, r *http.Request) {
-	// Split the request URL into path components
-	pathStrings := strings.Split(r.URL.Path, "/")
+	// Bounce back to the settings page
+	http.Redirect(w, r, "/settings"+com.ProjectPath(loggedInUser, folder, fileName)+"#reanalyse", http.StatusSeeOther)
+}
 
-	// numPieces will be 2 if the request was for the root directory (https://server/), or if
-	// the request included only a single path component (https://server/someuser) and no trailing slash
-	var fileName, userName string
-	numPieces := len(pathStrings)
-	switch numPieces {
-	case 2:
-		userName = pathStrings[1]
-		// Check if the request was for the root directory
-		if pathStrings[1] == "" {
-			// Yep, root directory request
-			frontPage(w, r)
-			return
-		}
+// Sends the user to Auth0 to log in again, from the step up page.  Once they've logged in, the Auth0 callback returns
+// them to the page they were confirming who they are for.
+func reauthHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
 
-		// The request was for a user page
-		userPage(w, r, userName)
+	// Ensure we have a valid logged in user
+	if loggedInUser == "" {
+		errorPage(w, r, http.StatusUnauthorized, "You need to be logged in")
 		return
-	case 3:
-		userName = pathStrings[1]
-		fileName = pathStrings[2]
-
-		// This catches the case where a "/" is on the end of a user page URL
-		if fileName == "" {
-			// The request was for a user page
-			userPage(w, r, userName)
-			return
-		}
-	default:
-		// TODO: Add support for folders and sub folders
-		// TODO  eg: Allow for projects which have multiple files
-		// TODO    * /user/collectionFoo/database1
-		// TODO    * /user/collectionFoo/database2
-		// TODO    * /user/collectionBar/database1
-		// TODO    * /user/collectionBar/database2
+	}
 
-		// We haven't yet added support for folders and subfolders, so bounce back to the /user/file page
-		http.Redirect(w, r, fmt.Sprintf("/%s/%s", pathStrings[1], pathStrings[2]), http.StatusTemporaryRedirect)
+	// Remember where to return to, along with a random state value which Auth0 passes back to the callback
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
-
-	userName = pathStrings[1]
-	fileName = pathStrings[2]
-
-	// Validate the user supplied user and file/project name
-	err := com.ValidateUserFilename(userName, fileName)
+	state := hex.EncodeToString(b)
+	sess, err := store.Get(r, "3dhub-user")
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	sess.Values["StepUpState"] = state
+	sess.Values["StepUpReturn"] = stepUpReturn(r)
+	err = sess.Save(r, w)
 	if err != nil {
-		errorPage(w, r, http.StatusBadRequest, "Invalid user or project name")
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	// TODO: Add support for folders and sub-folders in request paths
-	folder := "/"
-
-	// A specific project was requested
-	contentPage(w, r, userName, folder, fileName)
+	// Ask Auth0 to have the user log in again, even though they already have a session there
+	http.Redirect(w, r, auth0Config().AuthCodeURL(state, oauth2.SetAuthURLParam("prompt", "login"),
+		oauth2.SetAuthURLParam("max_age", "0")), http.StatusSeeOther)
 }
 
-// Returns HTML rendered content from a given markdown string, for the settings page README preview tab.
-func markdownPreview(w http.ResponseWriter, r *http.Request) {
-	// Extract and unescape the markdown text form value
-	a := r.PostFormValue("mkdown")
-	mkDown, err := url.QueryUnescape(a)
+// Queues the regeneration of a project from its OpenSCAD source.  This is the inbound webhook, usually called from CI
+// after the source has been updated.  The token is given in an "Authorization: Bearer" header or a "token" form field.
+func regenerateHookHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/x/regenerate/"), 10, 64)
 	if err != nil {
-		fmt.Fprint(w, "Something went wrong when unescaping provided value")
+		w.WriteHeader(http.StatusNotFound)
 		return
 	}
-
-	// Validate the markdown source provided, just to be safe
-	var renderedText []byte
-	if mkDown != "" {
-		err := com.Validate.Var(mkDown, "markdownsource")
-		if err != nil {
-			fmt.Fprint(w, "Invalid characters in Markdown")
-			return
-		}
-		renderedText = gfm.Markdown([]byte(mkDown))
+	token := r.PostFormValue("token")
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
 	}
-
-	// Send the rendered version back to the caller
-	fmt.Fprint(w, string(renderedText))
-}
-
-// Handler which does merging to MR's.  Called from the MR details page
-func mergeRequestHandler(w http.ResponseWriter, r *http.Request) {
-	// Retrieve session data (if any)
-	var loggedInUser string
-	var u interface{}
-	validSession := false
-	if com.Conf.Environment.Environment != "docker" {
-		sess, err := store.Get(r, "3dhub-user")
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
-		u = sess.Values["UserName"]
-	} else {
-		u = "default"
+	hook, err := com.RegenerationHook(id)
+	if err != nil || !com.CheckRegenerationToken(hook, token) {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, "Unknown project, or the token doesn't match")
+		return
 	}
-	if u != nil {
-		loggedInUser = u.(string)
-		validSession = true
+	err = com.QueueRegeneration(id, strings.TrimSpace(r.PostFormValue("message")))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
 	}
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprint(w, "Regeneration queued")
+}
+
+// Sets up, runs, or removes the regeneration of a project from an OpenSCAD source, from its settings page.
+func regenerationHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
 
 	// Ensure we have a valid logged in user
-	if validSession != true {
-		w.WriteHeader(http.StatusUnauthorized)
-		fmt.Fprint(w, "You need to be logged in")
+	if loggedInUser == "" {
+		errorPage(w, r, http.StatusUnauthorized, "You need to be logged in")
 		return
 	}
 
-	// Extract and validate the form variables
+	// Only the owner of a project can set up its regeneration
 	owner, folder, fileName, err := com.GetUFD(r, false)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprint(w, "Missing or incorrect data supplied")
-		return
-	}
-
-	// Ensure an MR id was given
-	a := r.PostFormValue("mrid")
-	if a == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprint(w, "Missing merge request id")
+		errorPage(w, r, http.StatusBadRequest, err.Error())
 		return
 	}
-	mrID, err := strconv.Atoi(a)
-	if err != nil {
-		log.Printf("Error converting string '%s' to integer in function '%s': %s\n", a,
-			com.GetCurrentFunctionName(), err)
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprint(w, "Error when parsing merge request id value")
+	if strings.ToLower(owner) != strings.ToLower(loggedInUser) {
+		errorPage(w, r, http.StatusForbidden, "Only the owner of a project can set up its regeneration")
 		return
 	}
-
-	// Check if the requested database exists
 	exists, err := com.CheckFileExists(loggedInUser, owner, folder, fileName)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, err.Error())
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
 	if !exists {
-		w.WriteHeader(http.StatusNotFound)
-		fmt.Fprintf(w, "Database '%s%s%s' doesn't exist", owner, folder, fileName)
+		errorPage(w, r, http.StatusNotFound, "That project doesn't exist")
+		return
+	}
+	hook, found, err := com.ProjectRegenerationHook(owner, folder, fileName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	// Ensure the request is coming from the database owner
-	if strings.ToLower(owner) != strings.ToLower(loggedInUser) {
-		w.WriteHeader(http.StatusUnauthorized)
-		fmt.Fprint(w, "Only the database owner can merge in merge requests")
+	action := r.PostFormValue("action")
+	if action != "save" && !found {
+		errorPage(w, r, http.StatusBadRequest, "The project doesn't have regeneration set up")
+		return
+	}
+	// Replacing the token or removing the webhook breaks whatever calls it, so the user needs to have recently
+	// confirmed who they are
+	if (action == "newtoken" || action == "remove") &&
+		needStepUp(w, r, fmt.Sprintf("/settings/%s%s%s", owner, folder, fileName)) {
 		return
 	}
+	switch action {
+	case "newtoken", "save":
+		source := hook.SourceURL
+		if action == "save" {
+			source = strings.TrimSpace(r.PostFormValue("source"))
+			err = com.ValidateRegenerationSource(source)
+			if err != nil {
+				errorPage(w, r, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
 
-	// Retrieve the names of the source & destination databases and branches
-	disc, err := com.Discussions(owner, folder, fileName, com.MERGE_REQUEST, mrID)
+		// A new token is made the first time, and when asked for
+		token := ""
+		if action == "newtoken" || !found {
+			b := make([]byte, 20)
+			if _, err = rand.Read(b); err != nil {
+				errorPage(w, r, http.StatusInternalServerError, err.Error())
+				return
+			}
+			token = hex.EncodeToString(b)
+		}
+		err = com.StoreRegenerationHook(owner, folder, fileName, source, token)
+	case "remove":
+		err = com.DeleteRegenerationHook(owner, folder, fileName)
+	case "run":
+		err = com.QueueRegeneration(hook.ID, "")
+	default:
+		errorPage(w, r, http.StatusBadRequest, "Unknown action")
+		return
+	}
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, err.Error())
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
-	branchName := disc[0].MRDetails.DestBranch
-	commitDiffList := disc[0].MRDetails.Commits
-	srcOwner := disc[0].MRDetails.SourceOwner
-	srcFolder := disc[0].MRDetails.SourceFolder
-	srcDBName := disc[0].MRDetails.SourceDBName
-	srcBranchName := disc[0].MRDetails.SourceBranch
 
-	// Ensure the merge request isn't closed
-	if !disc[0].Open {
+	// Return to the settings page
+	http.Redirect(w, r, "/settings"+com.ProjectPath(owner, folder, fileName), http.StatusSeeOther)
+}
+
+// Returns the list of projects related to a given one, as JSON.  Used by the front end to render related model
+// suggestions on project pages.
+func relatedHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
+
+	// Extract the owner and database name
+	// TODO: Add folder support
+	owner, fileName, err := com.GetOD(2, r) // 2 = Ignore "/x/related/" at the start of the URL
+	if err != nil || owner == "" || fileName == "" {
 		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprint(w, "Cannot merge a closed merge request")
 		return
 	}
+	folder := "/"
 
-	// Get the details of the head commit for the destination database branch
-	branchList, err := com.GetBranches(owner, folder, fileName) // Destination branch list
+	// Make sure the database exists in the system, and the user has access to it
+	exists, err := com.CheckFileExists(loggedInUser, owner, folder, fileName)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, err.Error())
 		return
 	}
-	branchDetails, ok := branchList[branchName]
-	if !ok {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, "Could not retrieve details for the destination branch")
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
 		return
 	}
-	destCommitID := branchDetails.Commit
-	destCommitList, err := com.GetCommitList(owner, folder, fileName)
+
+	// Retrieve the related projects
+	list, err := com.RelatedProjects(loggedInUser, owner, folder, fileName)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, err.Error())
+		return
+	}
+	if list == nil {
+		list = []com.RelatedProject{}
+	}
+	data, err := json.MarshalIndent(list, "", " ")
+	if err != nil {
+		com.Log.Error(err)
 		return
 	}
 
-	// Check if the MR commits will still apply cleanly to the destination branch
-	finalCommit := commitDiffList[len(commitDiffList)-1]
-	if finalCommit.Parent != destCommitID {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, fmt.Errorf("Destination branch has changed. Merge cannot proceed."))
+	// If the client already has this list, there's no need to send it again
+	if com.NotModified(w, r, com.ContentETag(data), time.Time{}) {
 		return
 	}
 
-	// * The required details have been collected, and sanity checks completed, so merge the MR *
+	// Return the related projects
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, string(data))
+}
 
-	// Add the source commits directly to the destination commit list
-	for _, j := range commitDiffList {
-		destCommitList[j.ID] = j
-	}
+// Adds or removes the projects a project declares it was remixed from.  Only the owner of the project can change them.
+func remixSourcesHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
 
-	// Retrieve details for the logged in user
-	usr, err := com.User(loggedInUser)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, err.Error())
+	// Ensure we have a valid logged in user
+	if loggedInUser == "" {
+		errorPage(w, r, http.StatusUnauthorized, "You need to be logged in")
 		return
 	}
 
-	// Create a merge commit, using the details of the source commit (this gets us a correctly filled in DB tree
-	// structure easily)
-	mrg := commitDiffList[0]
-	mrg.AuthorEmail = usr.Email
-	mrg.AuthorName = usr.DisplayName
-	mrg.Message = fmt.Sprintf("Merge branch '%s' of '%s%s%s' into '%s'", srcBranchName, srcOwner, srcFolder,
-		srcDBName, branchName)
-	mrg.Parent = commitDiffList[0].ID
-	mrg.OtherParents = append(mrg.OtherParents, destCommitID)
-	mrg.Timestamp = time.Now().UTC()
-	mrg.ID = com.CreateCommitID(mrg)
-
-	// Add the new commit to the destination db commit list, and update the branch list with it
-	destCommitList[mrg.ID] = mrg
-	b := com.BranchEntry{
-		Commit:      mrg.ID,
-		CommitCount: branchDetails.CommitCount + len(commitDiffList) + 1,
-		Description: branchDetails.Description,
-	}
-	branchList[branchName] = b
-	err = com.StoreCommits(owner, folder, fileName, destCommitList)
+	// Only the owner of a project can change its remix sources
+	owner, folder, fileName, err := com.GetUFD(r, false)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, err.Error())
+		errorPage(w, r, http.StatusBadRequest, err.Error())
 		return
 	}
-	err = com.StoreBranches(owner, folder, fileName, branchList)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, err.Error())
+	if strings.ToLower(owner) != strings.ToLower(loggedInUser) {
+		errorPage(w, r, http.StatusForbidden, "Only the owner of a project can change what it was remixed from")
 		return
 	}
-
-	// Change the status of the MR to closed, and indicate it was successfully merged
-	err = com.StoreComment(owner, folder, fileName, loggedInUser, mrID, "", true,
-		com.CLOSED_WITH_MERGE)
+	exists, err := com.CheckFileExists(loggedInUser, owner, folder, fileName)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, err.Error())
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
-
-	// Invalidate the memcached entries for the destination database case
-	err = com.InvalidateCacheEntry(loggedInUser, owner, folder, fileName, "") // Empty string indicates "for all versions"
-	if err != nil {
-		// Something went wrong when invalidating memcached entries for the database
-		log.Printf("Error when invalidating memcache entries: %s\n", err.Error())
+	if !exists {
+		errorPage(w, r, http.StatusNotFound, "That project doesn't exist")
 		return
 	}
 
-	// Send a success message back to the caller
-	w.WriteHeader(http.StatusOK)
-}
-
-// This handles incoming requests for the preferences page by logged in users.
-func prefHandler(w http.ResponseWriter, r *http.Request) {
-	pageName := "Preferences handler"
+	switch r.PostFormValue("action") {
+	case "add":
+		src, err := com.GetFormRemixSource(r)
+		if err != nil {
+			errorPage(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
 
-	// Retrieve session data (if any)
-	var loggedInUser string
-	var u interface{}
-	validSession := false
-	if com.Conf.Environment.Environment != "docker" {
-		sess, err := store.Get(r, "3dhub-user")
+		// Projects here need to be ones the owner can see, and not the project itself
+		if src.URL == "" {
+			if strings.ToLower(src.Owner) == strings.ToLower(owner) && src.FileName == fileName {
+				errorPage(w, r, http.StatusBadRequest, "A project can't be remixed from itself")
+				return
+			}
+			found, err := com.CheckFileExists(loggedInUser, src.Owner, src.Folder, src.FileName)
+			if err != nil {
+				errorPage(w, r, http.StatusInternalServerError, err.Error())
+				return
+			}
+			if !found {
+				errorPage(w, r, http.StatusNotFound, "That project doesn't exist")
+				return
+			}
+		}
+		err = com.AddRemixSource(owner, folder, fileName, src, com.MaxRemixSources)
 		if err != nil {
 			errorPage(w, r, http.StatusBadRequest, err.Error())
 			return
 		}
-		u = sess.Values["UserName"]
-	} else {
-		u = "default"
-	}
-	if u != nil {
-		loggedInUser = u.(string)
-		validSession = true
+	case "remove":
+		id, err := strconv.ParseInt(r.PostFormValue("id"), 10, 64)
+		if err != nil {
+			errorPage(w, r, http.StatusBadRequest, "Invalid ID")
+			return
+		}
+		err = com.DeleteRemixSource(owner, folder, fileName, id)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+	default:
+		errorPage(w, r, http.StatusBadRequest, "Unknown action")
+		return
 	}
 
-	// Ensure we have a valid logged in user
-	if validSession != true {
-		errorPage(w, r, http.StatusUnauthorized, "You need to be logged in")
+	// Return to the settings page
+	http.Redirect(w, r, "/settings"+com.ProjectPath(owner, folder, fileName)+"#remix", http.StatusSeeOther)
+}
+
+// Returns the render of one side of a visual comparison between two versions of a project.  Both sides are rendered
+// from the same camera position, so the images can be laid over each other.
+func renderCompareHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
+
+	// Extract the owner and database name
+	// TODO: Add folder support
+	owner, fileName, err := com.GetOD(2, r) // 2 = Ignore "/x/rendercompare/" at the start of the URL
+	if err != nil || owner == "" || fileName == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	folder := "/"
+	commitA, commitB := r.FormValue("a"), r.FormValue("b")
+	if com.ValidateCommitID(commitA) != nil || com.ValidateCommitID(commitB) != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Two valid commit IDs are needed")
+		return
+	}
+	side := r.FormValue("side")
+	if side != "a" && side != "b" {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Unknown side of the comparison")
 		return
 	}
 
-	// Gather submitted form data (if any)
-	maxRows := r.PostFormValue("maxrows")
-	displayName := r.PostFormValue("fullname")
-	email := r.PostFormValue("email")
-
-	// If no form data was submitted, display the preferences page form
-	if maxRows == "" {
-		prefPage(w, r, fmt.Sprintf("%s", loggedInUser))
+	// Make sure the project exists in the system, and the user has access to it
+	exists, err := com.CheckFileExists(loggedInUser, owner, folder, fileName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
 		return
 	}
 
-	// Basic sanity check
-	if displayName == "" {
-		errorPage(w, r, http.StatusBadRequest, "Full name can't be blank!")
+	// Make sure both commits are in the project
+	commits, err := com.GetCommitList(owner, folder, fileName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	if email == "" {
-		errorPage(w, r, http.StatusBadRequest, "Email address can't be blank!")
+	_, okA := commits[commitA]
+	_, okB := commits[commitB]
+	if !okA || !okB {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, "Unknown commit")
 		return
 	}
 
-	// Validate submitted form data
-	err := com.Validate.Var(maxRows, "required,numeric,min=1,max=500")
+	bucketA, idA, _, err := com.MinioLocation(owner, folder, fileName, commitA, loggedInUser)
 	if err != nil {
-		log.Printf("%s: Maximum rows value failed validation: %s\n", pageName, err)
-		errorPage(w, r, http.StatusBadRequest, "Error when parsing maximum rows preference value")
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	maxRowsNum, err := strconv.Atoi(maxRows)
+	bucketB, idB, _, err := com.MinioLocation(owner, folder, fileName, commitB, loggedInUser)
 	if err != nil {
-		log.Printf("%s: Error converting string '%v' to integer: %s\n", pageName, maxRows, err)
-		errorPage(w, r, http.StatusBadRequest, "Error when parsing preference data")
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	err = com.ValidateDisplayName(displayName)
+	renderA, renderB, err := com.RenderComparison(bucketA, idA, bucketB, idB, fileName)
 	if err != nil {
-		log.Printf("%s: Display name '%s' failed validation: %s\n", pageName, displayName, err)
-		errorPage(w, r, http.StatusBadRequest, "Error when parsing full name value")
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
 		return
 	}
-	err = com.Validate.Var(email, "required,email")
-	if err != nil {
-		// Check for the special case of username@server, which may fail standard email validation checks
-		// eg username@localhost, won't validate as an email address, but should be accepted anyway
-		serverName := strings.Split(com.Conf.Web.ServerName, ":")
-		em := fmt.Sprintf("%s@%s", loggedInUser, serverName[0])
-		if email != em {
-			log.Printf("%s: Email value failed validation: %s\n", pageName, err)
-			errorPage(w, r, http.StatusBadRequest, "Error when parsing email value")
-			return
-		}
+	data := renderA
+	if side == "b" {
+		data = renderB
+	}
+	if com.NotModified(w, r, com.ContentETag(data), time.Time{}) {
+		return
 	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(data)
+}
 
-	// Make sure the email address isn't already assigned to a different user
-	a, _, err := com.GetUsernameFromEmail(email)
+// Records a report from a logged in user about a project, which adds the project to the moderation queue.
+func reportProjectHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
+
+	// Ensure we have a valid logged in user
+	if loggedInUser == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// Extract the required form variables
+	owner, folder, fileName, err := com.GetUFD(r, false)
 	if err != nil {
-		errorPage(w, r, http.StatusInternalServerError, "Error when checking email address")
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Validation failed for owner or database name")
 		return
 	}
-	if a != "" && strings.ToLower(a) != strings.ToLower(loggedInUser) {
-		errorPage(w, r, http.StatusBadRequest, "That email address is already associated with a different user")
+	reason := strings.TrimSpace(r.PostFormValue("reason"))
+	if reason == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "A reason for the report is needed")
+		return
+	}
+	err = com.ValidateMarkdown(reason)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Invalid characters in the reason, or it's too long")
 		return
 	}
 
-	// TODO: Store previous email addresses in a database table that associates them with the username.  This will be
-	// TODO  needed so looking up an old email finds the correct username.  For example when looking through historical
-	// TODO  commit data
-
-	// Update the preference data in the database
-	err = com.SetUserPreferences(loggedInUser, maxRowsNum, displayName, email)
+	// Make sure the project exists, and is visible to the user
+	exists, err := com.CheckFileExists(loggedInUser, owner, folder, fileName)
 	if err != nil {
-		errorPage(w, r, http.StatusInternalServerError, "Error when updating preferences")
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, "Internal server error")
+		return
+	}
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, "Unknown project")
 		return
 	}
 
-	// Bounce to the user home page
-	http.Redirect(w, r, "/"+loggedInUser, http.StatusSeeOther)
+	// Record the report
+	err = com.AddProjectReport(loggedInUser, owner, folder, fileName, reason)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, "Internal server error")
+		return
+	}
+	com.Log.Infof("User '%s' reported project '%s%s%s'", loggedInUser, owner, folder, fileName)
+	com.FireAdminWebhooks(com.WebhookProjectReported, fmt.Sprintf("%s reported project %s%s%s: %s", loggedInUser,
+		owner, folder, fileName, reason), map[string]string{"project": owner + folder + fileName, "reason": reason,
+		"reporter": loggedInUser, "url": "https://" + com.Conf().Web.ServerName + "/" + owner + folder + fileName})
 }
 
-// Handler for the Database Settings page
-func saveSettingsHandler(w http.ResponseWriter, r *http.Request) {
-	// Retrieve session data (if any)
-	var loggedInUser string
-	var u interface{}
-	validSession := false
-	if com.Conf.Environment.Environment != "docker" {
-		sess, err := store.Get(r, "3dhub-user")
-		if err != nil {
-			errorPage(w, r, http.StatusBadRequest, err.Error())
-			return
+// Works out which project (if any) a request is for, so it can be included in the request logging.  Most pages give
+// the owner and project name in the URL path, after the path their handler is registered at.  The form handlers give
+// them as form fields instead.
+func requestProject(r *http.Request) (owner string, project string) {
+	_, pattern := http.DefaultServeMux.Handler(r)
+	if strings.HasSuffix(pattern, "/") {
+		parts := strings.SplitN(strings.Trim(strings.TrimPrefix(r.URL.Path, pattern), "/"), "/", 3)
+		owner = parts[0]
+		if len(parts) > 1 {
+			project = parts[1]
 		}
-		u = sess.Values["UserName"]
-	} else {
-		u = "default"
 	}
-	if u != nil {
-		loggedInUser = u.(string)
-		validSession = true
+	if owner == "" {
+		owner = r.Form.Get("username")
+		project = r.Form.Get("dbname")
 	}
+	return
+}
+
+// Handler for the Database Settings page
+func saveSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
 
 	// Ensure we have a valid logged in user
-	if validSession != true {
+	if loggedInUser == "" {
 		errorPage(w, r, http.StatusUnauthorized, "You need to be logged in")
 		return
 	}
@@ -3989,8 +7468,9 @@ func saveSettingsHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Extract the form variables
 	oneLineDesc := r.PostFormValue("onelinedesc")
-	newName := r.PostFormValue("newname")
+	newName := com.NormaliseName(r.PostFormValue("newname"))
 	fullDesc := r.PostFormValue("fulldesc")
+	readme := r.PostFormValue("readme")
 	defTable := r.PostFormValue("defaulttable") // TODO: Update the default table to be "per branch"
 	licences := r.PostFormValue("licences")
 
@@ -4027,16 +7507,38 @@ func saveSettingsHandler(w http.ResponseWriter, r *http.Request) {
 	// Grab and validate the supplied "public" form field
 	public, err := com.GetPub(r)
 	if err != nil {
-		log.Printf("Error when converting public value to boolean: %v\n", err)
+		com.Log.Errorf("Error when converting public value to boolean: %v", err)
 		errorPage(w, r, http.StatusBadRequest, "Public value incorrect")
 		return
 	}
 
+	// Making a public project private counts towards the plan the user is on
+	if !public {
+		wasPublic, err := com.ProjectPublic(owner, folder, fileName)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if wasPublic {
+			if err = com.CheckPrivateQuota(loggedInUser); err != nil {
+				errorPage(w, r, http.StatusForbidden, err.Error())
+				return
+			}
+		}
+	}
+
+	// Grab and validate the supplied category
+	catID, err := com.GetFormCategory(r)
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
 	// If set, validate the new database name
 	if newName != fileName {
 		err := com.ValidateFileName(newName)
 		if err != nil {
-			log.Printf("Validation failed for new database name '%s': %s", newName, err)
+			com.Log.Errorf("Validation failed for new database name '%s': %s", newName, err)
 			errorPage(w, r, http.StatusBadRequest, "New database name failed validation")
 			return
 		}
@@ -4044,9 +7546,9 @@ func saveSettingsHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Validate characters and length of the one line description
 	if oneLineDesc != "" {
-		err = com.Validate.Var(oneLineDesc, "markdownsource,max=120")
+		err = com.ValidateOneLineDescription(oneLineDesc)
 		if err != nil {
-			log.Printf("One line description '%s' failed validation", oneLineDesc)
+			com.Log.Errorf("One line description '%s' failed validation", oneLineDesc)
 			errorPage(w, r, http.StatusBadRequest, "One line description failed validation")
 			return
 		}
@@ -4054,19 +7556,28 @@ func saveSettingsHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Validate the full description
 	if fullDesc != "" {
-		err = com.Validate.Var(fullDesc, "markdownsource,max=8192") // 8192 seems reasonable.  Maybe too long?
+		err = com.ValidateFullDescription(fullDesc)
 		if err != nil {
-			log.Printf("Full description '%s' failed validation", fullDesc)
+			com.Log.Errorf("Full description '%s' failed validation", fullDesc)
 			errorPage(w, r, http.StatusBadRequest, "Full description failed validation")
 			return
 		}
 	}
 
+	// Validate the README
+	if readme != "" {
+		err = com.ValidateReadme(readme)
+		if err != nil {
+			errorPage(w, r, http.StatusBadRequest, "README failed validation")
+			return
+		}
+	}
+
 	// Validate the name of the default table
 	err = com.ValidatePGTable(defTable)
 	if err != nil {
 		// Validation failed
-		log.Printf("Validation failed for name of default table '%s': %s", defTable, err)
+		com.Log.Errorf("Validation failed for name of default table '%s': %s", defTable, err)
 		errorPage(w, r, http.StatusBadRequest, "Validation failed for name of default table")
 		return
 	}
@@ -4123,7 +7634,7 @@ func saveSettingsHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		if tablePresent == false {
 			// The requested table doesn't exist in the database
-			log.Printf("Requested table '%s' not present in database '%s%s%s'\n",
+			com.Log.Warnf("Requested table '%s' not present in database '%s%s%s'",
 				defTable, owner, folder, fileName)
 			errorPage(w, r, http.StatusBadRequest, "Requested table not present")
 			return
@@ -4251,59 +7762,315 @@ func saveSettingsHandler(w http.ResponseWriter, r *http.Request) {
 		oneLineDesc = ""
 	}
 
-	// Same thing, but for the full length description
-	if fullDesc == "No full description" {
-		fullDesc = ""
+	// Same thing, but for the full length description
+	if fullDesc == "No full description" {
+		fullDesc = ""
+	}
+
+	// Save the category and README.  This is done before SaveDBSettings() so its cache invalidation picks up the
+	// changes too
+	err = com.StoreProjectCategory(owner, folder, fileName, catID)
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, "Unknown category")
+		return
+	}
+	err = com.StoreProjectReadme(owner, folder, fileName, readme)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Save settings
+	oldOneLineDesc, oldFullDesc, err := com.ProjectDescriptions(owner, folder, fileName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	err = com.SaveDBSettings(owner, folder, fileName, oneLineDesc, fullDesc, defTable, public, sourceURL, defBranch)
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Record any change to the descriptions in the project history
+	if msg := com.DescriptionChangeMessage(oldOneLineDesc, oneLineDesc, oldFullDesc, fullDesc); msg != "" {
+		err = com.AddMetadataCommit(loggedInUser, owner, folder, fileName, msg)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	// If the new database name is different from the old one, perform the rename
+	// Note - It's useful to do this *after* the SaveDBSettings() call, so the cache invalidation code at the
+	// end of that function gets run and we don't have to repeat it here
+	// TODO: We'll probably need to add support for renaming folders somehow too
+	if newName != "" && newName != fileName {
+		err = com.RenameDatabase(owner, folder, fileName, newName)
+		if err != nil {
+			errorPage(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		// Remove the old name from the search index
+		err = com.UpdateSearchIndex(owner, folder, fileName)
+		if err != nil {
+			com.Log.Errorf("Error when updating the search index: %s", err.Error())
+		}
+	}
+
+	// Check the details of public projects for spam
+	if public {
+		checkProjectSpam(r, owner, folder, newName, strings.Join([]string{newName, oneLineDesc, fullDesc, readme,
+			sourceURL}, "\n"))
+	}
+
+	// Update the search index
+	err = com.UpdateSearchIndex(owner, folder, newName)
+	if err != nil {
+		com.Log.Errorf("Error when updating the search index: %s", err.Error())
+	}
+
+	// Settings saved, so bounce back to the database page
+	http.Redirect(w, r, com.ProjectPath(loggedInUser, folder, newName), http.StatusSeeOther)
+}
+
+// Returns the structure of a database (its tables and views, with their columns, indexes, and foreign keys) as JSON.
+// This lets the structure be shown without the database needing to be downloaded.
+func schemaHandler(w http.ResponseWriter, r *http.Request) {
+	pageName := "Schema handler"
+
+	// TODO: Add folder support
+	owner, fileName, commitID, err := com.GetODC(2, r) // 2 = Ignore "/x/schema/" at the start of the URL
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	folder := "/"
+	loggedInUser := sessionUser(r)
+
+	// Check if the user has access to the requested database
+	bucket, id, _, err := com.MinioLocation(owner, folder, fileName, commitID, loggedInUser)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if id == "" {
+		// The requested database wasn't found
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// The Minio ID changes whenever the database file does, so it's used in the cache key rather than the commit
+	cacheKey := com.MetadataCacheKey("schema", loggedInUser, owner, folder, fileName, id)
+	var schema []com.SchemaTable
+	ok, err := com.GetCachedData(cacheKey, &schema)
+	if err != nil {
+		com.Log.Errorf("%s: Error retrieving schema from cache: %v", pageName, err)
+	}
+	if !ok {
+		sdb, err := com.OpenMinioObject(bucket, id)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		defer sdb.Close()
+		schema, err = com.ReadSQLiteDBSchema(sdb)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		err = com.CacheData(cacheKey, schema, com.Conf().Memcache.DefaultCacheTime)
+		if err != nil {
+			com.Log.Errorf("%s: Error when caching schema for '%s%s%s': %v", pageName, owner, folder, fileName,
+				err)
+		}
+	}
+
+	jsonResponse, err := json.MarshalIndent(schema, "", " ")
+	if err != nil {
+		com.Log.Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if com.NotModified(w, r, com.ContentETag(jsonResponse), time.Time{}) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, "%s", jsonResponse)
+}
+
+// Removes identifying metadata from the model of a project, from its settings page.  The result is added as a new
+// version.
+func scrubMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
+
+	// Ensure we have a valid logged in user
+	if loggedInUser == "" {
+		errorPage(w, r, http.StatusUnauthorized, "You need to be logged in")
+		return
+	}
+
+	// Only the owner of a project can change its model
+	owner, folder, fileName, err := com.GetUFD(r, false)
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if strings.ToLower(owner) != strings.ToLower(loggedInUser) {
+		errorPage(w, r, http.StatusForbidden, "Only the owner of a project can remove metadata from it")
+		return
+	}
+	exists, err := com.CheckFileExists(loggedInUser, owner, folder, fileName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !exists {
+		errorPage(w, r, http.StatusNotFound, "That project doesn't exist")
+		return
+	}
+
+	commitID, err := com.ScrubProjectMetadata(r, loggedInUser, folder, fileName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if commitID == "" {
+		errorPage(w, r, http.StatusBadRequest, "No identifying metadata was found in the model")
+		return
+	}
+
+	// Update the search index
+	err = com.UpdateSearchIndex(loggedInUser, folder, fileName)
+	if err != nil {
+		com.Log.Errorf("Error when updating the search index: %s", err.Error())
+	}
+
+	// Bounce the user to the new version
+	http.Redirect(w, r, fmt.Sprintf("%s?commit=%s", com.ProjectPath(loggedInUser, folder, fileName), commitID),
+		http.StatusSeeOther)
+}
+
+// Returns a page of search results as JSON.  The search text is given in the "q" argument, an (optional) project tag
+// to filter on in "tag", an (optional) category slug path in "category", an (optional) licence name in "licence", an
+// (optional) sort order in "sort", and the (optional) page number in "page".  Leaving out the search text and giving
+// just a filter or sort order lists all projects matching it.  The search text can also include qualifiers (eg
+// "user:justinclift format:stl stars:>10"), which are turned into filters.
+func searchHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
+
+	// Retrieve the search text and page number
+	query, filters, page, err := com.GetSearchQuery(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+
+	// Run the search
+	var s struct {
+		Category string             `json:"category,omitempty"`
+		Licence  string             `json:"licence,omitempty"`
+		Page     int                `json:"page"`
+		Query    string             `json:"query"`
+		Results  []com.SearchResult `json:"results"`
+		Sort     com.SortOrder      `json:"sort,omitempty"`
+		Tag      string             `json:"tag,omitempty"`
+		Total    int                `json:"total"`
+	}
+	s.Category = filters.Category
+	s.Licence = filters.Licence
+	s.Page = page
+	s.Query = query
+	s.Sort = filters.Sort
+	s.Tag = filters.Tag
+	if query != "" || filters.Tag != "" || filters.Category != "" || filters.Licence != "" || filters.Sort != "" {
+		s.Results, s.Total, err = com.Search(loggedInUser, filters, (page-1)*com.SearchResultsPageSize)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+	data, err := json.MarshalIndent(s, "", " ")
+	if err != nil {
+		com.Log.Error(err)
+		return
+	}
+
+	// Return the search results
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, string(data))
+}
+
+// Sends the model of a project to the OctoPrint instance the logged in user has linked.  The commit can optionally be
+// given in the form data.
+func sendToOctoPrintHandler(w http.ResponseWriter, r *http.Request) {
+	pageName := "Send to OctoPrint handler"
+
+	loggedInUser := sessionUser(r)
+	if loggedInUser == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
 	}
 
-	// Save settings
-	err = com.SaveDBSettings(owner, folder, fileName, oneLineDesc, fullDesc, defTable, public, sourceURL, defBranch)
+	// Extract the owner and database name
+	// TODO: Add folder support
+	owner, fileName, err := com.GetOD(2, r) // 2 = Ignore "/x/sendtooctoprint/" at the start of the URL
+	if err != nil || owner == "" || fileName == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	folder := "/"
+	commitID, err := com.GetFormCommit(r)
 	if err != nil {
-		errorPage(w, r, http.StatusBadRequest, err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, err.Error())
 		return
 	}
 
-	// If the new database name is different from the old one, perform the rename
-	// Note - It's useful to do this *after* the SaveDBSettings() call, so the cache invalidation code at the
-	// end of that function gets run and we don't have to repeat it here
-	// TODO: We'll probably need to add support for renaming folders somehow too
-	if newName != "" && newName != fileName {
-		err = com.RenameDatabase(owner, folder, fileName, newName)
-		if err != nil {
-			errorPage(w, r, http.StatusBadRequest, err.Error())
-			return
-		}
+	// Make sure the project exists, and the user has access to it
+	exists, err := com.CheckFileExists(loggedInUser, owner, folder, fileName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	bucket, id, _, err := com.MinioLocation(owner, folder, fileName, commitID, loggedInUser)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
 	}
 
-	// Settings saved, so bounce back to the database page
-	http.Redirect(w, r, fmt.Sprintf("/%s%s%s", loggedInUser, folder, newName), http.StatusSeeOther)
+	name, err := com.SendToOctoPrint(loggedInUser, bucket, id, fileName)
+	if err != nil {
+		com.Log.Warnf("%s: sending '%s%s%s' for '%s' failed: %v", pageName, owner, folder, fileName, loggedInUser,
+			err)
+		w.WriteHeader(http.StatusBadGateway)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	data, err := json.MarshalIndent(map[string]string{"name": name}, "", " ")
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, string(data))
 }
 
 // This function sets a branch as the default for a given database.
 func setDefaultBranchHandler(w http.ResponseWriter, r *http.Request) {
 	pageName := "Set default branch handler"
 
-	// Retrieve session data (if any)
-	var loggedInUser string
-	var u interface{}
-	validSession := false
-	if com.Conf.Environment.Environment != "docker" {
-		sess, err := store.Get(r, "3dhub-user")
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
-		u = sess.Values["UserName"]
-	} else {
-		u = "default"
-	}
-	if u != nil {
-		loggedInUser = u.(string)
-		validSession = true
-	}
+	loggedInUser := sessionUser(r)
 
 	// Ensure we have a valid logged in user
-	if validSession != true {
+	if loggedInUser == "" {
 		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
@@ -4336,7 +8103,7 @@ func setDefaultBranchHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if !exists {
-		log.Printf("%s: Validation failed for database name: %s", pageName, err)
+		com.Log.Errorf("%s: Validation failed for database name: %s", pageName, err)
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
@@ -4371,7 +8138,7 @@ func setDefaultBranchHandler(w http.ResponseWriter, r *http.Request) {
 	err = com.InvalidateCacheEntry(loggedInUser, owner, folder, fileName, "") // Empty string indicates "for all versions"
 	if err != nil {
 		// Something went wrong when invalidating memcached entries for the database
-		log.Printf("Error when invalidating memcache entries: %s\n", err.Error())
+		com.Log.Errorf("Error when invalidating memcache entries: %s", err.Error())
 		return
 	}
 
@@ -4379,90 +8146,383 @@ func setDefaultBranchHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
-// Handles JSON requests from the front end to toggle a database's star.
-func starToggleHandler(w http.ResponseWriter, r *http.Request) {
-	// Extract the user and database name
+// Records in the user's session that they've just confirmed who they are, which allows destructive operations for a
+// little while.
+func setElevated(w http.ResponseWriter, r *http.Request) error {
+	sess, err := store.Get(r, "3dhub-user")
+	if err != nil {
+		return err
+	}
+	sess.Values["ElevatedUntil"] = time.Now().Add(stepUpWindow).Unix()
+	return sess.Save(r, w)
+}
+
+// Receives a comma separated list of tags for a project from the front end, and saves it.
+func setTagsHandler(w http.ResponseWriter, r *http.Request) {
+	pageName := "Set project tags handler"
+
+	loggedInUser := sessionUser(r)
+
+	// Ensure we have a valid logged in user
+	if loggedInUser == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// Extract the owner and database name
 	// TODO: Add folder support
-	owner, fileName, err := com.GetOD(2, r) // 2 = Ignore "/x/star/" at the start of the URL
+	owner, fileName, err := com.GetOD(2, r) // 2 = Ignore "/x/settags/" at the start of the URL
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	folder := "/"
+
+	// Make sure the database is owned by the logged in user. eg prevent changes to other people's databases
+	if strings.ToLower(owner) != strings.ToLower(loggedInUser) {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, "You can only change the tags for your own projects")
+		return
+	}
+
+	// Make sure the database exists in the system
+	exists, err := com.CheckFileExists(loggedInUser, owner, folder, fileName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// Validate the new tag list
+	tags, err := com.GetFormProjectTags(r)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+
+	// Save the new tags
+	err = com.StoreProjectTags(owner, folder, fileName, tags)
+	if err != nil {
+		com.Log.Errorf("%s: %v", pageName, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Invalidate the memcache data for the database, so the new tags get picked up
+	err = com.InvalidateCacheEntry(loggedInUser, owner, folder, fileName, "") // Empty string indicates "for all versions"
+	if err != nil {
+		// Something went wrong when invalidating memcached entries for the database
+		com.Log.Errorf("Error when invalidating memcache entries: %s", err.Error())
+		return
+	}
+
+	// Update the search index
+	err = com.UpdateSearchIndex(owner, folder, fileName)
+	if err != nil {
+		com.Log.Errorf("Error when updating the search index: %s", err.Error())
+	}
+
+	// Return the cleaned up tag list, so the front end can display it
+	data, err := json.Marshal(tags)
+	if err != nil {
+		com.Log.Error(err)
 		return
 	}
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, string(data))
+}
 
-	// Retrieve session data (if any)
-	var loggedInUser string
-	var u interface{}
-	validSession := false
-	if com.Conf.Environment.Environment != "docker" {
+// Changes the colour theme the pages are shown with.  It's saved with the preferences of logged in users, and kept in
+// a cookie for everyone (so it sticks after logging out too).  Afterwards the visitor is sent back to the page they
+// were on.
+func setThemeHandler(w http.ResponseWriter, r *http.Request) {
+	theme, err := com.GetFormTheme(r)
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if loggedInUser := sessionUser(r); loggedInUser != "" {
+		err = com.SetUserTheme(loggedInUser, theme)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, "Error when updating theme")
+			return
+		}
 		sess, err := store.Get(r, "3dhub-user")
+		if err == nil {
+			sess.Values["Theme"] = string(theme)
+			sess.Save(r, w)
+		}
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     "theme",
+		Value:    string(theme),
+		Path:     "/",
+		MaxAge:   365 * 24 * 60 * 60,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	// Only send people back to pages on this site
+	dest := "/"
+	if u, err := url.Parse(r.Referer()); err == nil && u.Host == r.Host && u.Path != "" {
+		dest = u.RequestURI()
+	}
+	http.Redirect(w, r, dest, http.StatusSeeOther)
+}
+
+// Sends people following a short URL to the project (or version of it) it points to.
+func shortURLHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
+
+	code := strings.TrimPrefix(r.URL.Path, "/s/")
+	if !com.ValidShortURLCode(code) {
+		errorPage(w, r, http.StatusNotFound, "That short URL doesn't exist")
+		return
+	}
+	s, found, err := com.ShortURL(code)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Database query failed")
+		return
+	}
+
+	// Private projects are only shown to people with access, and they shouldn't find out it exists otherwise
+	if found {
+		found, err = com.CheckFileExists(loggedInUser, s.Owner, s.Folder, s.FileName)
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	if !found {
+		errorPage(w, r, http.StatusNotFound, "That short URL doesn't exist")
+		return
+	}
+
+	com.RecordShortURLClick(code)
+	dest := fmt.Sprintf("/%s%s%s", s.Owner, s.Folder, s.FileName)
+	if s.CommitID != "" {
+		dest += "?commit=" + s.CommitID
+	}
+	http.Redirect(w, r, dest, http.StatusFound)
+}
+
+// Creates (with a POST) or returns the short URL for a project, or the version of it given in the form data.  The
+// owner of the project can retrieve all of its short URLs with a GET, along with how often each has been followed.
+func shortURLsHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
+	if loggedInUser == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// Extract the owner and database name
+	// TODO: Add folder support
+	owner, fileName, err := com.GetOD(2, r) // 2 = Ignore "/x/shorturl/" at the start of the URL
+	if err != nil || owner == "" || fileName == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	folder := "/"
+	commitID, err := com.GetFormCommit(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+
+	// Make sure the project exists in the system, and the user has access to it
+	exists, err := com.CheckFileExists(loggedInUser, owner, folder, fileName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var result interface{}
+	if r.Method == http.MethodPost {
+		// Short URLs for a specific version need it to be one of the project's
+		if commitID != "" {
+			commitList, err := com.GetCommitList(owner, folder, fileName)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			if _, ok := commitList[commitID]; !ok {
+				w.WriteHeader(http.StatusNotFound)
+				fmt.Fprint(w, "Unknown commit")
+				return
+			}
+		}
+		s, err := com.CreateShortURL(loggedInUser, owner, folder, fileName, commitID)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, err.Error())
 			return
 		}
-		u = sess.Values["UserName"]
+		result = s
 	} else {
-		u = "default"
+		// The click statistics are only for the project's owner
+		if strings.ToLower(owner) != strings.ToLower(loggedInUser) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		list, err := com.ShortURLs(owner, folder, fileName)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		for i := range list {
+			list[i].URL = com.ShortURLLink(list[i].Code)
+		}
+		if list == nil {
+			list = []com.ShortURLEntry{}
+		}
+		result = list
+	}
+	data, err := json.MarshalIndent(result, "", " ")
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
 	}
-	if u != nil {
-		loggedInUser = u.(string)
-		validSession = true
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, string(data))
+}
+
+// Handles JSON requests from the front end to toggle a database's star.
+func starToggleHandler(w http.ResponseWriter, r *http.Request) {
+	// Extract the user and database name
+	// TODO: Add folder support
+	owner, fileName, err := com.GetOD(2, r) // 2 = Ignore "/x/star/" at the start of the URL
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
 	}
 
+	loggedInUser := sessionUser(r)
+
 	// Ensure we have a valid logged in user
-	if validSession != true {
+	if loggedInUser == "" {
 		// No logged in username, so nothing to update
 		// TODO: We should probably use a http status code instead of using -1
 		fmt.Fprint(w, "-1") // -1 tells the front end not to update the displayed star count
 		return
 	}
-
-	// Toggle on or off the starring of a database by a user
-	err = com.ToggleDBStar(loggedInUser, owner, "/", fileName)
+
+	// Toggle on or off the starring of a database by a user
+	err = com.ToggleDBStar(loggedInUser, owner, "/", fileName)
+	if err != nil {
+		fmt.Fprint(w, "-1") // -1 tells the front end not to update the displayed star count
+		return
+	}
+
+	// Update the search index, as the star count is used for sorting the results.  This is done straight away, so
+	// it's not skipped if clearing the cache fails
+	err = com.UpdateSearchIndex(owner, "/", fileName)
+	if err != nil {
+		com.Log.Errorf("Error when updating the search index: %s", err.Error())
+	}
+
+	// Invalidate the old memcached entry for the database
+	err = com.InvalidateCacheEntry(loggedInUser, owner, "/", fileName, "") // Empty string indicates "for all versions"
+	if err != nil {
+		// Something went wrong when invalidating memcached entries for the database
+		com.Log.Errorf("Error when invalidating memcache entries: %s", err.Error())
+		return
+	}
+
+	// Return the updated star count
+	newStarCount, err := com.DBStars(owner, "/", fileName)
+	if err != nil {
+		fmt.Fprint(w, "-1") // -1 tells the front end not to update the displayed star count
+		return
+	}
+	com.PublishLiveUpdate(owner, "/", fileName, com.LIVE_STARS, newStarCount)
+	fmt.Fprint(w, newStarCount)
+}
+
+// Confirms who the user is using a code from their authenticator app, from the step up page.  Destructive operations
+// are then allowed for a little while.
+func stepUpHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
+
+	// Ensure we have a valid logged in user
+	if loggedInUser == "" {
+		errorPage(w, r, http.StatusUnauthorized, "You need to be logged in")
+		return
+	}
+
+	ok, locked, err := com.CheckUserTOTP(loggedInUser, r.PostFormValue("code"), clientIP(r))
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if locked {
+		errorPage(w, r, http.StatusTooManyRequests, "Too many wrong codes have been given, so your authenticator "+
+			"app can't be used for a while.  Please try again later")
+		return
+	}
+	if !ok {
+		com.Log.Warnf("Incorrect authenticator app code given by '%s', from %s", loggedInUser, clientIP(r))
+		errorPage(w, r, http.StatusForbidden, "That code isn't right.  Please go back and try again with a new "+
+			"code from your authenticator app")
+		return
+	}
+	err = setElevated(w, r)
 	if err != nil {
-		fmt.Fprint(w, "-1") // -1 tells the front end not to update the displayed star count
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
+	http.Redirect(w, r, stepUpReturn(r), http.StatusSeeOther)
+}
 
-	// Invalidate the old memcached entry for the database
-	err = com.InvalidateCacheEntry(loggedInUser, owner, "/", fileName, "") // Empty string indicates "for all versions"
+// Returns the page to send the user back to after the step up page.  Only pages on this site are allowed, given as a
+// plain path and query string using just the characters URLs are allowed to have there.
+func stepUpReturn(r *http.Request) string {
+	const pathChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-._~%!$&'()*+,;=:@/?"
+	ret := r.FormValue("return")
+	u, err := url.Parse(ret)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.User != nil || u.Opaque != "" ||
+		!strings.HasPrefix(ret, "/") || strings.HasPrefix(ret, "//") || strings.TrimLeft(ret, pathChars) != "" {
+		return "/" + sessionUser(r)
+	}
+	return ret
+}
+
+// Receives the webhook requests Stripe sends when users subscribe to (or change or cancel) a paid plan.  The requests
+// are signed using the webhook secret from the configuration file.
+func stripeHookHandler(w http.ResponseWriter, r *http.Request) {
+	if !com.Conf().Billing.Enabled {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1<<20))
 	if err != nil {
-		// Something went wrong when invalidating memcached entries for the database
-		log.Printf("Error when invalidating memcache entries: %s\n", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-
-	// Return the updated star count
-	newStarCount, err := com.DBStars(owner, "/", fileName)
+	err = com.HandleStripeEvent(body, r.Header.Get("Stripe-Signature"))
 	if err != nil {
-		fmt.Fprint(w, "-1") // -1 tells the front end not to update the displayed star count
+		com.Log.Warnf("Error handling a Stripe webhook request: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, err.Error())
 		return
 	}
-	fmt.Fprint(w, newStarCount)
+	fmt.Fprint(w, "ok")
 }
 
 // Returns the table and view names present in a specific database commit
 func tableNamesHandler(w http.ResponseWriter, r *http.Request) {
-	// Retrieve session data (if any)
-	var loggedInUser string
-	var u interface{}
-	validSession := false
-	if com.Conf.Environment.Environment != "docker" {
-		sess, err := store.Get(r, "3dhub-user")
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
-		u = sess.Values["UserName"]
-	} else {
-		u = "default"
-	}
-	if u != nil {
-		loggedInUser = u.(string)
-		validSession = true
-	}
+	loggedInUser := sessionUser(r)
 
 	// Ensure we have a valid logged in user
-	if validSession != true {
+	if loggedInUser == "" {
 		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
@@ -4495,7 +8555,7 @@ func tableNamesHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if !exists {
-		log.Printf("%s: Validation failed for database name: %s", com.GetCurrentFunctionName(), err)
+		com.Log.Errorf("%s: Validation failed for database name: %s", com.GetCurrentFunctionName(), err)
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
@@ -4606,7 +8666,7 @@ func tableNamesHandler(w http.ResponseWriter, r *http.Request) {
 	// JSON encode the result
 	data, err := json.MarshalIndent(d, "", " ")
 	if err != nil {
-		log.Println(err)
+		com.Log.Error(err)
 		return
 	}
 
@@ -4656,7 +8716,7 @@ func tableViewHandler(w http.ResponseWriter, r *http.Request) {
 		// careful than usual
 		err = com.ValidateFieldName(sortCol)
 		if err != nil {
-			log.Printf("Validation failed on requested sort field name '%v': %v\n", sortCol,
+			com.Log.Errorf("Validation failed on requested sort field name '%v': %v", sortCol,
 				err.Error())
 			w.WriteHeader(http.StatusBadRequest)
 			return
@@ -4671,23 +8731,39 @@ func tableViewHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Retrieve session data (if any)
-	var loggedInUser string
-	var u interface{}
-	if com.Conf.Environment.Environment != "docker" {
-		sess, err := store.Get(r, "3dhub-user")
-		if err != nil {
+	// The rows can be grouped by a column, with an aggregate function (count, sum, avg, min, or max) applied to
+	// another column for each group.  Either column can be transformed first, eg to group text timestamps by date
+	groupCol := r.FormValue("group")
+	groupTransform := r.FormValue("grouptransform")
+	aggFunc := r.FormValue("agg")
+	aggCol := r.FormValue("aggcol")
+	aggTransform := r.FormValue("aggtransform")
+	if groupCol != "" {
+		if aggFunc == "" {
+			aggFunc = "count"
+		}
+		if !com.AggregateFunctions[aggFunc] || (aggCol == "" && aggFunc != "count") {
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
-		u = sess.Values["UserName"]
-	} else {
-		u = "default"
-	}
-	if u != nil {
-		loggedInUser = u.(string)
+		if com.ValidateColumnTransform(groupTransform) != nil || com.ValidateColumnTransform(aggTransform) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		for _, c := range []string{groupCol, aggCol} {
+			if c == "" {
+				continue
+			}
+			if err = com.ValidateFieldName(c); err != nil {
+				com.Log.Errorf("Validation failed on requested group or aggregate field name '%v': %v", c, err)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
 	}
 
+	loggedInUser := sessionUser(r)
+
 	// Check if the user has access to the requested database
 	bucket, id, _, err := com.MinioLocation(owner, folder, fileName, commitID, loggedInUser)
 	if err != nil {
@@ -4698,14 +8774,24 @@ func tableViewHandler(w http.ResponseWriter, r *http.Request) {
 	// Sanity check
 	if id == "" {
 		// The requested database wasn't found
-		log.Printf("%s: Requested database not found. Owner: '%s%s%s'", pageName, owner, folder, fileName)
+		com.Log.Errorf("%s: Requested database not found. Owner: '%s%s%s'", pageName, owner, folder, fileName)
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
 
-	// Determine the number of rows to display
+	// Determine the number of rows to display.  A specific number can be asked for, otherwise it's the user's
+	// preference
 	var maxRows int
-	if loggedInUser != "" {
+	if limitStr := r.FormValue("limit"); limitStr != "" {
+		maxRows, err = strconv.Atoi(limitStr)
+		if err != nil || maxRows < 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if maxRows > com.MaxNumDisplayRows {
+			maxRows = com.MaxNumDisplayRows
+		}
+	} else if loggedInUser != "" {
 		// Retrieve the user preference data
 		maxRows = com.PrefUserMaxRows(loggedInUser)
 	} else {
@@ -4714,14 +8800,15 @@ func tableViewHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// If the data is available from memcached, use that instead of reading from the SQLite database itself
-	dataCacheKey := com.TableRowsCacheKey(fmt.Sprintf("tablejson/%s/%s/%d", sortCol, sortDir, rowOffset),
+	dataCacheKey := com.TableRowsCacheKey(fmt.Sprintf("tablejson/%s/%s/%d/%s/%s/%s/%s/%s", sortCol, sortDir,
+		rowOffset, groupCol, groupTransform, aggFunc, aggCol, aggTransform),
 		loggedInUser, owner, folder, fileName, commitID, requestedTable, maxRows)
 
 	// If a cached version of the page data exists, use it
 	var dataRows com.SQLiteRecordSet
 	ok, err := com.GetCachedData(dataCacheKey, &dataRows)
 	if err != nil {
-		log.Printf("%s: Error retrieving table data from cache: %v\n", pageName, err)
+		com.Log.Errorf("%s: Error retrieving table data from cache: %v", pageName, err)
 	}
 	if !ok {
 		// * Data wasn't in cache, so we gather it from the SQLite database *
@@ -4750,132 +8837,586 @@ func tableViewHandler(w http.ResponseWriter, r *http.Request) {
 					time.Sleep(3 * time.Second)
 					tables, err = sdb.Tables("")
 					if err != nil {
-						log.Printf("Error retrieving table names: %s", err)
+						com.Log.Errorf("Error retrieving table names: %s", err)
 						return
 					}
 				} else {
-					log.Printf("Error retrieving table names: %s", err)
+					com.Log.Errorf("Error retrieving table names: %s", err)
 					return
 				}
 			} else {
-				log.Printf("Error retrieving table names: %s", err)
+				com.Log.Errorf("Error retrieving table names: %s", err)
 				return
 			}
 		}
 		if len(tables) == 0 {
 			// No table names were returned, so abort
-			log.Printf("The database '%s' doesn't seem to have any tables. Aborting.", fileName)
+			com.Log.Warnf("The database '%s' doesn't seem to have any tables. Aborting.", fileName)
 			return
 		}
 		vw, err := sdb.Views("")
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		tables = append(tables, vw...)
+
+		// If a specific table was requested, check it exists
+		if requestedTable != "" {
+			tablePresent := false
+			for _, tableName := range tables {
+				if requestedTable == tableName {
+					tablePresent = true
+				}
+			}
+			if tablePresent == false {
+				// The requested table doesn't exist
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+
+		// If no specific table was requested, use the first one
+		if requestedTable == "" {
+			requestedTable = tables[0]
+		}
+
+		// If a sort, group, or aggregate column was requested, verify it exists
+		if sortCol != "" || groupCol != "" {
+			colList, err := sdb.Columns("", requestedTable)
+			if err != nil {
+				com.Log.Errorf("Error when reading column names for table '%s': %v", requestedTable,
+					err.Error())
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			colExists := make(map[string]bool)
+			for _, j := range colList {
+				colExists[j.Name] = true
+			}
+			if groupCol != "" && (!colExists[groupCol] || (aggCol != "" && !colExists[aggCol])) {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if !colExists[sortCol] && (groupCol == "" || sortCol != com.AggregateColumnName(aggFunc, aggCol)) {
+				// The requested sort column doesn't exist, so we fall back to no sorting
+				sortCol = ""
+			}
+		}
+
+		// Read the data from the database
+		if groupCol != "" {
+			dataRows, err = com.ReadSQLiteDBAggregate(sdb, requestedTable, groupCol, groupTransform, aggFunc, aggCol,
+				aggTransform, maxRows, sortCol, sortDir, rowOffset)
+		} else {
+			dataRows, err = com.ReadSQLiteDB(sdb, requestedTable, maxRows, sortCol, sortDir, rowOffset)
+		}
+		if err != nil {
+			// Some kind of error when reading the database data
+			com.Log.Errorf("Error occurred when reading table data for '%s%s%s', commit '%s': %s", owner,
+				folder, fileName, commitID, err.Error())
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		// Count the total number of rows in the requested table.  For grouped data it's the number of groups, which
+		// has already been worked out
+		if groupCol == "" {
+			dataRows.TotalRows, err = com.GetSQLiteRowCount(sdb, requestedTable)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		}
+
+		// Cache the data in memcache
+		err = com.CacheData(dataCacheKey, dataRows, com.Conf().Memcache.DefaultCacheTime)
+		if err != nil {
+			com.Log.Errorf("%s: Error when caching table data for '%s%s%s': %v", pageName, owner, folder,
+				fileName, err)
+		}
+	}
+
+	// Add the details needed for paging through the table
+	dataRows.Limit = maxRows
+	dataRows.HasPrev = rowOffset > 0
+	dataRows.PrevOffset = rowOffset - maxRows
+	if dataRows.PrevOffset < 0 {
+		dataRows.PrevOffset = 0
+	}
+	dataRows.HasNext = rowOffset+maxRows < dataRows.TotalRows
+	if dataRows.HasNext {
+		dataRows.NextOffset = rowOffset + maxRows
+	}
+
+	// Format the output.  Use json.MarshalIndent() for nicer looking output
+	jsonResponse, err := json.MarshalIndent(dataRows, "", " ")
+	if err != nil {
+		com.Log.Error(err)
+		return
+	}
+
+	// If the client already has this data, there's no need to send it again
+	if com.NotModified(w, r, com.ContentETag(jsonResponse), time.Time{}) {
+		return
+	}
+
+	//w.Header().Set("Access-Control-Allow-Origin", "*")
+	fmt.Fprintf(w, "%s", jsonResponse)
+}
+
+// Asks for an export of all of the logged in user's data (POST), or downloads a finished one (GET).
+func takeoutHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
+
+	// Ensure we have a valid logged in user
+	if loggedInUser == "" {
+		errorPage(w, r, http.StatusUnauthorized, "You need to be logged in")
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		_, err := com.QueueDataExport(loggedInUser)
+		if err != nil {
+			errorPage(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		http.Redirect(w, r, "/pref#takeout", http.StatusSeeOther)
+		return
+	}
+
+	// Only the user an export is for can download it
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, "Invalid data export ID")
+		return
+	}
+	exp, found, err := com.DataExport(id)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Database query failed")
+		return
+	}
+	if !found || strings.ToLower(exp.UserName) != strings.ToLower(loggedInUser) ||
+		(!exp.Expires.IsZero() && time.Now().After(exp.Expires)) {
+		errorPage(w, r, http.StatusNotFound, "That data export doesn't exist, or has expired")
+		return
+	}
+	obj, err := com.DataExportObject(exp)
+	if err != nil {
+		errorPage(w, r, http.StatusNotFound, err.Error())
+		return
+	}
+	defer com.MinioHandleClose(obj)
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="3dhub-export-%s-%s.zip"`,
+		exp.UserName, exp.DateFinished.UTC().Format("2006-01-02")))
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", exp.Size))
+	w.Header().Set("Content-Type", "application/zip")
+	if _, err = io.Copy(w, obj); err != nil {
+		com.Log.Errorf("Error returning data export %d for '%s': %v", id, loggedInUser, err)
+	}
+}
+
+// Returns the .torrent file for a large public project, or redirects to its magnet link when format=magnet is given.
+// The torrent is web seeded from the download end point, for the same commit.
+func torrentHandler(w http.ResponseWriter, r *http.Request) {
+	// Extract the owner and database name
+	// TODO: Add folder support
+	owner, fileName, commitID, err := com.GetODC(2, r) // 2 = Ignore "/x/torrent/" at the start of the URL
+	if err != nil || owner == "" || fileName == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	folder := "/"
+
+	// Torrents can be shared with anyone, so they're only made for public projects
+	exists, err := com.CheckFileExists("", owner, folder, fileName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// Use a fixed commit, so the web seed keeps serving the file the torrent is for
+	if commitID == "" {
+		commitID, err = com.DefaultCommit(owner, folder, fileName)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+	commits, err := com.GetCommitList(owner, folder, fileName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	c, ok := commits[commitID]
+	if !ok || len(c.Tree.Entries) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, "Unknown commit")
+		return
+	}
+	size := c.Tree.Entries[0].Size
+	if !com.TorrentAvailable(size) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, "Torrents aren't available for this file")
+		return
+	}
+	bucket, id, lastModified, err := com.MinioLocation(owner, folder, fileName, commitID, "")
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	webSeed := fmt.Sprintf("https://%s/x/download/%s%s%s?commit=%s", com.Conf().Web.ServerName,
+		url.PathEscape(owner), folder, url.PathEscape(fileName), commitID)
+	torrent, infoHash, err := com.TorrentFile(bucket+id, fileName, webSeed)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	if r.FormValue("format") == "magnet" {
+		http.Redirect(w, r, com.MagnetLink(infoHash, fileName, size, webSeed), http.StatusFound)
+		return
+	}
+	if com.NotModified(w, r, fmt.Sprintf(`"torrent-%s"`, infoHash), lastModified) {
+		return
+	}
+	w.Header().Set("Content-Disposition", com.AttachmentDisposition(fileName+".torrent"))
+	w.Header().Set("Content-Type", "application/x-bittorrent")
+	w.Write(torrent)
+}
+
+// Adds or removes the authenticator app for the logged in user, from their preferences page.  A new secret is kept in
+// their session until they've given a code from it, so apps which weren't set up correctly don't get saved.
+func totpHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
+
+	// Ensure we have a valid logged in user
+	if loggedInUser == "" {
+		errorPage(w, r, http.StatusUnauthorized, "You need to be logged in")
+		return
+	}
+
+	sess, err := store.Get(r, "3dhub-user")
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	switch r.PostFormValue("action") {
+	case "cancel":
+		delete(sess.Values, "PendingTOTP")
+	case "disable":
+		// Removing the app needs a code from it
+		ok, locked, err := com.CheckUserTOTP(loggedInUser, r.PostFormValue("code"), clientIP(r))
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if locked {
+			errorPage(w, r, http.StatusTooManyRequests, "Too many wrong codes have been given, so your authenticator "+
+				"app can't be used for a while.  Please try again later")
+			return
+		}
+		if !ok {
+			errorPage(w, r, http.StatusForbidden, "That code isn't right.  Please go back and try again")
+			return
+		}
+		err = com.SetUserTOTP(loggedInUser, nil)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+	case "enable":
+		secret, _ := sess.Values["PendingTOTP"].(string)
+		if _, ok := com.ValidateTOTP(secret, r.PostFormValue("code"), 0); !ok {
+			errorPage(w, r, http.StatusForbidden, "That code isn't right.  Please check the time on your device "+
+				"is correct, then go back and try again")
+			return
+		}
+		err = com.EnableUserTOTP(loggedInUser, secret)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+		delete(sess.Values, "PendingTOTP")
+	case "setup":
+		// Otherwise anyone with the session could add their own app, and use it to confirm they're the user
+		if needStepUp(w, r, "/pref#totp") {
+			return
+		}
+		secret, err := com.GenerateTOTPSecret()
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+		sess.Values["PendingTOTP"] = secret
+	default:
+		errorPage(w, r, http.StatusBadRequest, "Unknown action")
+		return
+	}
+	err = sess.Save(r, w)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Return to the preferences page
+	http.Redirect(w, r, "/pref#totp", http.StatusSeeOther)
+}
+
+// Handles the transfer of projects between users.  The owner of a project requests (or cancels) its transfer from the
+// project's settings page, then the user it's going to accepts or declines it from their preferences page.
+func transferHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
+
+	// Ensure we have a valid logged in user
+	if loggedInUser == "" {
+		errorPage(w, r, http.StatusUnauthorized, "You need to be logged in")
+		return
+	}
+
+	action := r.PostFormValue("action")
+	switch action {
+	case "accept", "decline":
+		id, err := strconv.ParseInt(r.PostFormValue("id"), 10, 64)
+		if err != nil {
+			errorPage(w, r, http.StatusBadRequest, "Unknown project transfer")
 			return
 		}
-		tables = append(tables, vw...)
-
-		// If a specific table was requested, check it exists
-		if requestedTable != "" {
-			tablePresent := false
-			for _, tableName := range tables {
-				if requestedTable == tableName {
-					tablePresent = true
-				}
-			}
-			if tablePresent == false {
-				// The requested table doesn't exist
-				w.WriteHeader(http.StatusBadRequest)
+		if action == "decline" {
+			err = com.DeclineProjectTransfer(loggedInUser, id)
+			if err != nil {
+				errorPage(w, r, http.StatusInternalServerError, err.Error())
 				return
 			}
+			http.Redirect(w, r, "/pref", http.StatusSeeOther)
+			return
 		}
 
-		// If no specific table was requested, use the first one
-		if requestedTable == "" {
-			requestedTable = tables[0]
+		// Clear the cached details of the project at its old location, as they can't be found once it's moved
+		transfers, err := com.IncomingTransfers(loggedInUser)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
 		}
-
-		// If a sort column was requested, verify it exists
-		if sortCol != "" {
-			colList, err := sdb.Columns("", requestedTable)
-			if err != nil {
-				log.Printf("Error when reading column names for table '%s': %v\n", requestedTable,
-					err.Error())
-				w.WriteHeader(http.StatusInternalServerError)
-				return
+		for _, t := range transfers {
+			if t.ID != id {
+				continue
 			}
-			colExists := false
-			for _, j := range colList {
-				if j.Name == sortCol {
-					colExists = true
+
+			// Private projects count towards the plan of the user taking them
+			if !t.Public {
+				if err = com.CheckPrivateQuota(loggedInUser); err != nil {
+					errorPage(w, r, http.StatusForbidden, err.Error())
+					return
 				}
 			}
-			if colExists == false {
-				// The requested sort column doesn't exist, so we fall back to no sorting
-				sortCol = ""
+			if err = com.InvalidateCacheEntry(loggedInUser, t.Owner, t.Folder, t.FileName, ""); err != nil {
+				com.Log.Infof("Error when invalidating memcache entries for '%s%s%s': %v", t.Owner, t.Folder,
+					t.FileName, err)
 			}
 		}
-
-		// Read the data from the database
-		dataRows, err = com.ReadSQLiteDB(sdb, requestedTable, maxRows, sortCol, sortDir, rowOffset)
+		oldOwner, folder, fileName, err := com.AcceptProjectTransfer(loggedInUser, id)
 		if err != nil {
-			// Some kind of error when reading the database data
-			log.Printf("Error occurred when reading table data for '%s%s%s', commit '%s': %s\n", owner,
-				folder, fileName, commitID, err.Error())
-			w.WriteHeader(http.StatusInternalServerError)
+			errorPage(w, r, http.StatusBadRequest, err.Error())
 			return
 		}
 
-		// Count the total number of rows in the requested table
-		dataRows.TotalRows, err = com.GetSQLiteRowCount(sdb, requestedTable)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			return
+		// Update the search index for both locations
+		if err = com.UpdateSearchIndex(oldOwner, folder, fileName); err != nil {
+			com.Log.Errorf("Error when updating the search index: %s", err.Error())
+		}
+		if err = com.UpdateSearchIndex(loggedInUser, folder, fileName); err != nil {
+			com.Log.Errorf("Error when updating the search index: %s", err.Error())
 		}
 
-		// Cache the data in memcache
-		err = com.CacheData(dataCacheKey, dataRows, com.Conf.Memcache.DefaultCacheTime)
+		// Let the old owner know
+		com.EmailUser(oldOwner, fmt.Sprintf("3DHub.io: %s%s%s has been transferred", oldOwner, folder, fileName),
+			fmt.Sprintf("%s accepted the transfer of your project '%s%s%s'.  It's now at https://%s/%s%s%s",
+				loggedInUser, oldOwner, folder, fileName, com.Conf().Web.ServerName, loggedInUser, folder, fileName))
+		http.Redirect(w, r, com.ProjectPath(loggedInUser, folder, fileName), http.StatusSeeOther)
+		return
+	case "cancel", "request":
+	default:
+		errorPage(w, r, http.StatusBadRequest, "Unknown action")
+		return
+	}
+
+	// Only the owner of a project can transfer it
+	owner, folder, fileName, err := com.GetUFD(r, false)
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if strings.ToLower(owner) != strings.ToLower(loggedInUser) {
+		errorPage(w, r, http.StatusForbidden, "Only the owner of a project can transfer it")
+		return
+	}
+	exists, err := com.CheckFileExists(loggedInUser, owner, folder, fileName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !exists {
+		errorPage(w, r, http.StatusNotFound, "That project doesn't exist")
+		return
+	}
+
+	if action == "cancel" {
+		err = com.CancelProjectTransfer(owner, folder, fileName)
 		if err != nil {
-			log.Printf("%s: Error when caching table data for '%s%s%s': %v\n", pageName, owner, folder,
-				fileName, err)
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
 		}
+		http.Redirect(w, r, "/settings"+com.ProjectPath(owner, folder, fileName), http.StatusSeeOther)
+		return
 	}
 
-	// Format the output.  Use json.MarshalIndent() for nicer looking output
-	jsonResponse, err := json.MarshalIndent(dataRows, "", " ")
+	// Giving a project away can't be undone, so the user needs to have recently confirmed who they are
+	if needStepUp(w, r, fmt.Sprintf("/settings/%s%s%s", owner, folder, fileName)) {
+		return
+	}
+
+	// Check the user it's going to
+	newOwner := strings.TrimSpace(r.PostFormValue("newowner"))
+	err = com.ValidateUser(newOwner)
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, "Invalid username")
+		return
+	}
+	if strings.ToLower(newOwner) == strings.ToLower(owner) {
+		errorPage(w, r, http.StatusBadRequest, "You already own this project")
+		return
+	}
+	userExists, err := com.CheckUserExists(newOwner)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !userExists {
+		errorPage(w, r, http.StatusBadRequest, fmt.Sprintf("There's no user called '%s'", newOwner))
+		return
+	}
+	clash, err := com.CheckFileExists(newOwner, newOwner, folder, fileName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if clash {
+		errorPage(w, r, http.StatusBadRequest, fmt.Sprintf("'%s' already has a project called '%s'", newOwner,
+			fileName))
+		return
+	}
+	err = com.RequestProjectTransfer(owner, folder, fileName, newOwner)
 	if err != nil {
-		log.Println(err)
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	//w.Header().Set("Access-Control-Allow-Origin", "*")
-	fmt.Fprintf(w, "%s", jsonResponse)
+	// Let the new owner know
+	com.EmailUser(newOwner, fmt.Sprintf("3DHub.io: %s would like to transfer %s%s%s to you", owner, owner, folder,
+		fileName), fmt.Sprintf("%s would like to transfer their project '%s%s%s' to you.  To accept or decline it, "+
+		"visit https://%s/pref#transfers", owner, owner, folder, fileName, com.Conf().Web.ServerName))
+	http.Redirect(w, r, "/settings"+com.ProjectPath(owner, folder, fileName), http.StatusSeeOther)
 }
 
-// This function processes branch rename and description updates.
-func updateBranchHandler(w http.ResponseWriter, r *http.Request) {
-	pageName := "Update Branch handler"
+// Saves or removes a translation of a project's descriptions and README.  The language is given as a tag (eg "de"),
+// which is matched against the visitor's preferred language on the project pages.  Only available to the owner of
+// the project.
+func translationHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
 
-	// Retrieve session data (if any)
-	var loggedInUser string
-	var u interface{}
-	validSession := false
-	if com.Conf.Environment.Environment != "docker" {
-		sess, err := store.Get(r, "3dhub-user")
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
+	// Ensure we have a valid logged in user
+	if loggedInUser == "" {
+		errorPage(w, r, http.StatusUnauthorized, "You need to be logged in")
+		return
+	}
+
+	owner, folder, fileName, err := com.GetUFD(r, false)
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if strings.ToLower(owner) != strings.ToLower(loggedInUser) {
+		errorPage(w, r, http.StatusForbidden, "Only the owner of a project can change its translations")
+		return
+	}
+	exists, err := com.CheckFileExists(loggedInUser, owner, folder, fileName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !exists {
+		errorPage(w, r, http.StatusNotFound, "That project doesn't exist")
+		return
+	}
+	language := strings.ToLower(strings.TrimSpace(r.PostFormValue("language")))
+	err = com.ValidateLanguageTag(language)
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, "The language needs to be a language tag, eg 'de' or 'pt-br'")
+		return
+	}
+
+	switch r.PostFormValue("action") {
+	case "delete":
+		err = com.DeleteProjectTranslation(loggedInUser, folder, fileName, language)
+	case "save":
+		t := com.ProjectTranslation{
+			FullDesc:    r.PostFormValue("fulldesc"),
+			Language:    language,
+			OneLineDesc: r.PostFormValue("onelinedesc"),
+			Readme:      r.PostFormValue("readme"),
+		}
+		if t.OneLineDesc == "" && t.FullDesc == "" && t.Readme == "" {
+			errorPage(w, r, http.StatusBadRequest, "Nothing to save, as the translation is empty")
 			return
 		}
-		u = sess.Values["UserName"]
-	} else {
-		u = "default"
+		if t.OneLineDesc != "" && com.ValidateOneLineDescription(t.OneLineDesc) != nil {
+			errorPage(w, r, http.StatusBadRequest, "One line description failed validation")
+			return
+		}
+		if t.FullDesc != "" && com.ValidateFullDescription(t.FullDesc) != nil {
+			errorPage(w, r, http.StatusBadRequest, "Full description failed validation")
+			return
+		}
+		if t.Readme != "" && com.ValidateReadme(t.Readme) != nil {
+			errorPage(w, r, http.StatusBadRequest, "README failed validation")
+			return
+		}
+		err = com.StoreProjectTranslation(loggedInUser, folder, fileName, t)
+	default:
+		errorPage(w, r, http.StatusBadRequest, "Unknown action")
+		return
 	}
-	if u != nil {
-		loggedInUser = u.(string)
-		validSession = true
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Invalidate the memcache data for the project, so the change shows up straight away
+	err = com.InvalidateCacheEntry(loggedInUser, owner, folder, fileName, "")
+	if err != nil {
+		com.Log.Errorf("Error when invalidating memcache entries: %s", err.Error())
 	}
 
+	// Bounce back to the settings page
+	http.Redirect(w, r, "/settings"+com.ProjectPath(loggedInUser, folder, fileName)+"#translations",
+		http.StatusSeeOther)
+}
+
+// This function processes branch rename and description updates.
+func updateBranchHandler(w http.ResponseWriter, r *http.Request) {
+	pageName := "Update Branch handler"
+
+	loggedInUser := sessionUser(r)
+
 	// Ensure we have a valid logged in user
-	if validSession != true {
+	if loggedInUser == "" {
 		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
@@ -4939,7 +9480,7 @@ func updateBranchHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if !exists {
-		log.Printf("%s: Validation failed for database name: %s", pageName, err)
+		com.Log.Errorf("%s: Validation failed for database name: %s", pageName, err)
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
@@ -4997,7 +9538,7 @@ func updateBranchHandler(w http.ResponseWriter, r *http.Request) {
 	err = com.InvalidateCacheEntry(loggedInUser, owner, folder, fileName, "") // Empty string indicates "for all versions"
 	if err != nil {
 		// Something went wrong when invalidating memcached entries for the database
-		log.Printf("Error when invalidating memcache entries: %s\n", err.Error())
+		com.Log.Errorf("Error when invalidating memcache entries: %s", err.Error())
 		return
 	}
 
@@ -5009,27 +9550,10 @@ func updateBranchHandler(w http.ResponseWriter, r *http.Request) {
 func updateCommentHandler(w http.ResponseWriter, r *http.Request) {
 	pageName := "Update Comment handler"
 
-	// Retrieve session data (if any)
-	var loggedInUser string
-	var u interface{}
-	validSession := false
-	if com.Conf.Environment.Environment != "docker" {
-		sess, err := store.Get(r, "3dhub-user")
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
-		u = sess.Values["UserName"]
-	} else {
-		u = "default"
-	}
-	if u != nil {
-		loggedInUser = u.(string)
-		validSession = true
-	}
+	loggedInUser := sessionUser(r)
 
 	// Ensure we have a valid logged in user
-	if validSession != true {
+	if loggedInUser == "" {
 		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
@@ -5051,7 +9575,7 @@ func updateCommentHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	discID, err := strconv.Atoi(a)
 	if err != nil {
-		log.Printf("Error converting string '%s' to integer in function '%s': %s\n", a,
+		com.Log.Errorf("Error converting string '%s' to integer in function '%s': %s", a,
 			com.GetCurrentFunctionName(), err)
 		w.WriteHeader(http.StatusBadRequest)
 		fmt.Fprint(w, "Error when parsing discussion id value")
@@ -5067,7 +9591,7 @@ func updateCommentHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	comID, err := strconv.Atoi(b)
 	if err != nil {
-		log.Printf("Error converting string '%s' to integer in function '%s': %s\n", a,
+		com.Log.Errorf("Error converting string '%s' to integer in function '%s': %s", a,
 			com.GetCurrentFunctionName(), err)
 		w.WriteHeader(http.StatusBadRequest)
 		fmt.Fprint(w, "Error when parsing comment id value")
@@ -5091,61 +9615,161 @@ func updateCommentHandler(w http.ResponseWriter, r *http.Request) {
 		newTxt = nt
 	}
 
-	// If any of the required values were empty, indicate failure
-	if owner == "" || folder == "" || fileName == "" || discID == 0 || comID == 0 || newTxt == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprint(w, "Required values missing!")
-		return
+	// If any of the required values were empty, indicate failure
+	if owner == "" || folder == "" || fileName == "" || discID == 0 || comID == 0 || newTxt == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Required values missing!")
+		return
+	}
+
+	// Make sure the database exists in the system
+	exists, err := com.CheckFileExists(loggedInUser, owner, folder, fileName)
+	if err != err {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		com.Log.Errorf("%s: Validation failed for database name: %s", pageName, err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// Archived projects are read-only
+	archived, err := com.ProjectArchived(owner, folder, fileName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	if archived {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, "This project is archived, so it's read-only")
+		return
+	}
+
+	// Update the discussion text
+	err = com.UpdateComment(owner, folder, fileName, loggedInUser, discID, comID, newTxt)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+
+	// Update succeeded
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, string(gfm.Markdown([]byte(newTxt))))
+}
+
+// Changes the one line and/or full description of a project, without needing to send the rest of its settings.  Only
+// the descriptions given in the request are changed, and any change is recorded as a commit on the default branch.
+// Responds with the descriptions as they are afterwards, as JSON.
+func updateDescriptionHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
+
+	// Ensure we have a valid logged in user
+	if loggedInUser == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// Extract the required form variables
+	usr, folder, fileName, err := com.GetUFD(r, false)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	owner := strings.ToLower(usr)
+	if folder == "" {
+		folder = "/"
+	}
+
+	// Only the project owner can change its descriptions
+	if strings.ToLower(loggedInUser) != owner {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	exists, err := com.CheckFileExists(loggedInUser, owner, folder, fileName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// Start with the existing descriptions, then apply the ones given
+	oldOneLineDesc, oldFullDesc, err := com.ProjectDescriptions(owner, folder, fileName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	oneLineDesc, fullDesc := oldOneLineDesc, oldFullDesc
+	if d, ok := r.PostForm["onelinedesc"]; ok {
+		oneLineDesc = d[0]
+		if oneLineDesc != "" && com.ValidateOneLineDescription(oneLineDesc) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, "One line description failed validation")
+			return
+		}
+	}
+	if d, ok := r.PostForm["fulldesc"]; ok {
+		fullDesc = d[0]
+		if fullDesc != "" && com.ValidateFullDescription(fullDesc) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, "Full description failed validation")
+			return
+		}
+	}
+
+	// Save the new descriptions, and record the change in the project history
+	if msg := com.DescriptionChangeMessage(oldOneLineDesc, oneLineDesc, oldFullDesc, fullDesc); msg != "" {
+		err = com.StoreProjectDescriptions(owner, folder, fileName, oneLineDesc, fullDesc)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		err = com.AddMetadataCommit(loggedInUser, owner, folder, fileName, msg)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		// Check the details of public projects for spam
+		var details com.SQLiteDBinfo
+		if com.DBDetails(&details, loggedInUser, owner, folder, fileName, "") == nil && details.Info.Public &&
+			!details.Info.Draft {
+			checkProjectSpam(r, owner, folder, fileName, strings.Join([]string{fileName, oneLineDesc, fullDesc}, "\n"))
+		}
+
+		// Update the search index
+		err = com.UpdateSearchIndex(owner, folder, fileName)
+		if err != nil {
+			com.Log.Errorf("Error when updating the search index: %s", err.Error())
+		}
 	}
 
-	// Make sure the database exists in the system
-	exists, err := com.CheckFileExists(loggedInUser, owner, folder, fileName)
-	if err != err {
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-	if !exists {
-		log.Printf("%s: Validation failed for database name: %s", pageName, err)
-		w.WriteHeader(http.StatusNotFound)
-		return
+	var desc struct {
+		FullDesc    string `json:"full_description"`
+		OneLineDesc string `json:"one_line_description"`
 	}
-
-	// Update the discussion text
-	err = com.UpdateComment(owner, folder, fileName, loggedInUser, discID, comID, newTxt)
+	desc.OneLineDesc, desc.FullDesc = oneLineDesc, fullDesc
+	data, err := json.MarshalIndent(desc, "", " ")
 	if err != nil {
+		com.Log.Errorf("Error when JSON marshalling project descriptions: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprint(w, err.Error())
 		return
 	}
-
-	// Update succeeded
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprint(w, string(gfm.Markdown([]byte(newTxt))))
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, "%s", data)
 }
 
 // This function processes discussion title and body text updates.
 func updateDiscussHandler(w http.ResponseWriter, r *http.Request) {
-	// Retrieve session data (if any)
-	var loggedInUser string
-	var u interface{}
-	validSession := false
-	if com.Conf.Environment.Environment != "docker" {
-		sess, err := store.Get(r, "3dhub-user")
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
-		u = sess.Values["UserName"]
-	} else {
-		u = "default"
-	}
-	if u != nil {
-		loggedInUser = u.(string)
-		validSession = true
-	}
+	loggedInUser := sessionUser(r)
 
 	// Ensure we have a valid logged in user
-	if validSession != true {
+	if loggedInUser == "" {
 		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
@@ -5168,7 +9792,7 @@ func updateDiscussHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	discID, err := strconv.Atoi(a)
 	if err != nil {
-		log.Printf("Error converting string '%s' to integer in function '%s': %s\n", a,
+		com.Log.Errorf("Error converting string '%s' to integer in function '%s': %s", a,
 			com.GetCurrentFunctionName(), err)
 		w.WriteHeader(http.StatusBadRequest)
 		fmt.Fprint(w, "Error when parsing discussion id value")
@@ -5233,6 +9857,19 @@ func updateDiscussHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Archived projects are read-only
+	archived, err := com.ProjectArchived(owner, folder, fileName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	if archived {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, "This project is archived, so it's read-only")
+		return
+	}
+
 	// Update the discussion text
 	err = com.UpdateDiscussion(owner, folder, fileName, loggedInUser, discID, newTitle, newTxt)
 	if err != nil {
@@ -5250,27 +9887,10 @@ func updateDiscussHandler(w http.ResponseWriter, r *http.Request) {
 func updateReleaseHandler(w http.ResponseWriter, r *http.Request) {
 	pageName := "Update Release handler"
 
-	// Retrieve session data (if any)
-	var loggedInUser string
-	var u interface{}
-	validSession := false
-	if com.Conf.Environment.Environment != "docker" {
-		sess, err := store.Get(r, "3dhub-user")
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
-		u = sess.Values["UserName"]
-	} else {
-		u = "default"
-	}
-	if u != nil {
-		loggedInUser = u.(string)
-		validSession = true
-	}
+	loggedInUser := sessionUser(r)
 
 	// Ensure we have a valid logged in user
-	if validSession != true {
+	if loggedInUser == "" {
 		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
@@ -5334,7 +9954,7 @@ func updateReleaseHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if !exists {
-		log.Printf("%s: Validation failed for database name: %s", pageName, err)
+		com.Log.Errorf("%s: Validation failed for database name: %s", pageName, err)
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
@@ -5365,6 +9985,7 @@ func updateReleaseHandler(w http.ResponseWriter, r *http.Request) {
 		Commit:        oldInfo.Commit,
 		Date:          oldInfo.Date,
 		Description:   newDesc,
+		LicenceSPDX:   oldInfo.LicenceSPDX,
 		ReleaserEmail: oldInfo.ReleaserEmail,
 		ReleaserName:  oldInfo.ReleaserName,
 		Size:          oldInfo.Size,
@@ -5384,27 +10005,10 @@ func updateReleaseHandler(w http.ResponseWriter, r *http.Request) {
 func updateTagHandler(w http.ResponseWriter, r *http.Request) {
 	pageName := "Update Tag handler"
 
-	// Retrieve session data (if any)
-	var loggedInUser string
-	var u interface{}
-	validSession := false
-	if com.Conf.Environment.Environment != "docker" {
-		sess, err := store.Get(r, "3dhub-user")
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
-		u = sess.Values["UserName"]
-	} else {
-		u = "default"
-	}
-	if u != nil {
-		loggedInUser = u.(string)
-		validSession = true
-	}
+	loggedInUser := sessionUser(r)
 
 	// Ensure we have a valid logged in user
-	if validSession != true {
+	if loggedInUser == "" {
 		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
@@ -5468,7 +10072,7 @@ func updateTagHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if !exists {
-		log.Printf("%s: Validation failed for database name: %s", pageName, err)
+		com.Log.Errorf("%s: Validation failed for database name: %s", pageName, err)
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
@@ -5523,27 +10127,10 @@ func uploadFileHandler(w http.ResponseWriter, r *http.Request) {
 	// Set the maximum accepted file size for uploading
 	r.Body = http.MaxBytesReader(w, r.Body, com.MaxFileSize*1024*1024)
 
-	// Retrieve session data (if any)
-	var loggedInUser string
-	var u interface{}
-	validSession := false
-	if com.Conf.Environment.Environment != "docker" {
-		sess, err := store.Get(r, "3dhub-user")
-		if err != nil {
-			errorPage(w, r, http.StatusBadRequest, err.Error())
-			return
-		}
-		u = sess.Values["UserName"]
-	} else {
-		u = "default"
-	}
-	if u != nil {
-		loggedInUser = u.(string)
-		validSession = true
-	}
+	loggedInUser := sessionUser(r)
 
 	// Ensure we have a valid logged in user
-	if validSession != true {
+	if loggedInUser == "" {
 		errorPage(w, r, http.StatusUnauthorized, "You need to be logged in")
 		return
 	}
@@ -5553,15 +10140,15 @@ func uploadFileHandler(w http.ResponseWriter, r *http.Request) {
 		errorPage(w, r, http.StatusBadRequest,
 			fmt.Sprintf("File is too large. Maximum file upload size is %d MB, yours is %d MB",
 				com.MaxFileSize, r.ContentLength/1024/1024))
-		log.Println(fmt.Sprintf("'%s' attempted to upload an oversized file %d MB in size.  Limit is %d MB\n",
-			loggedInUser, r.ContentLength/1024/1024, com.MaxFileSize))
+		com.Log.Warnf("'%s' attempted to upload an oversized file %d MB in size.  Limit is %d MB",
+			loggedInUser, r.ContentLength/1024/1024, com.MaxFileSize)
 		return
 	}
 
 	// Prepare the form data
 	r.ParseMultipartForm(32 << 20) // 64MB of ram max
 	if err := r.ParseForm(); err != nil {
-		log.Printf("%s: ParseForm() error: %v\n", pageName, err)
+		com.Log.Errorf("%s: ParseForm() error: %v", pageName, err)
 		errorPage(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -5569,7 +10156,7 @@ func uploadFileHandler(w http.ResponseWriter, r *http.Request) {
 	// Grab and validate the supplied "public" form field
 	public, err := com.GetPub(r)
 	if err != nil {
-		log.Printf("%s: Error when converting public value to boolean: %v\n", pageName, err)
+		com.Log.Errorf("%s: Error when converting public value to boolean: %v", pageName, err)
 		errorPage(w, r, http.StatusBadRequest, "Public value incorrect")
 		return
 	}
@@ -5604,31 +10191,118 @@ func uploadFileHandler(w http.ResponseWriter, r *http.Request) {
 	// Validate the (optional) branch name
 	branchName, err := com.GetFormBranch(r)
 	if err != nil {
-		log.Printf("%s: Error when validating branch name '%s': %v\n", pageName, branchName, err)
+		com.Log.Errorf("%s: Error when validating branch name '%s': %v", pageName, branchName, err)
 		errorPage(w, r, http.StatusBadRequest, "Branch name value failed validation")
 		return
 	}
 
+	// Validate the (optional) category
+	catID, err := com.GetFormCategory(r)
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Remove the details identifying the uploader from the model, if they've asked for that
+	scrubMeta := r.PostFormValue("scrubmetadata") == "true"
+
+	// Projects can be kept private until a chosen time, when they're made public
+	_, timeZone := requestDatePrefs(r)
+	publishAt, err := com.GetFormPublishAt(r, timeZone)
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !publishAt.IsZero() {
+		public = false
+	}
+
+	// New projects can be uploaded as drafts, which only the owner can see until they publish them
+	draft := r.PostFormValue("draft") == "true"
+
+	// New projects can be created from a template, which fills in anything not given in the upload form
+	var tpl com.ProjectTemplate
+	useTemplate := false
+	if name := r.PostFormValue("template"); name != "" {
+		tpl, useTemplate, err = com.FindProjectTemplate(loggedInUser, name)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if !useTemplate {
+			errorPage(w, r, http.StatusBadRequest, "Unknown project template")
+			return
+		}
+	}
+
+	// Read and validate the (optional) README file
+	var readme string
+	if readmeFile, _, err := r.FormFile("readme"); err == nil {
+		data, err := ioutil.ReadAll(readmeFile)
+		readmeFile.Close()
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, "Couldn't read the README file")
+			return
+		}
+		readme = string(data)
+		err = com.ValidateReadme(readme)
+		if err != nil {
+			errorPage(w, r, http.StatusBadRequest, "README failed validation")
+			return
+		}
+	}
+
 	// TODO: Add support for folders and sub-folders
 	folder := "/"
 
 	tempFile, handler, err := r.FormFile("model")
 	if err != nil {
-		log.Printf("%s: Uploading file failed: %v\n", pageName, err)
+		com.Log.Errorf("%s: Uploading file failed: %v", pageName, err)
 		errorPage(w, r, http.StatusInternalServerError, "File missing from upload data?")
 		return
 	}
-	fileName := handler.Filename
+	fileName := com.NormaliseName(handler.Filename)
 	defer tempFile.Close()
 
+	// A CSV file can be uploaded to create a database from.  The database is named after the CSV file, as is the table
+	// holding its data
+	csvUpload := r.PostFormValue("format") == "csv"
+	var tableName string
+	if csvUpload {
+		fileName = strings.TrimSuffix(fileName, filepath.Ext(fileName))
+		tableName = com.CSVFieldName(fileName)
+		if tableName == "" {
+			tableName = "data"
+		}
+		fileName += ".sqlite"
+	}
+
 	// Validate the file name
 	err = com.ValidateFileName(fileName)
 	if err != nil {
-		log.Printf("%s: Validation failed for file name: %s", pageName, err)
+		com.Log.Errorf("%s: Validation failed for file name: %s", pageName, err)
 		errorPage(w, r, http.StatusBadRequest, "Invalid file name")
 		return
 	}
 
+	// Build the database from the CSV data, and upload that instead
+	var upload io.Reader = tempFile
+	if csvUpload {
+		dbFile, err := com.CSVToSQLite(tempFile, tableName)
+		if err != nil {
+			errorPage(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		defer os.Remove(dbFile)
+		f, err := os.Open(dbFile)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+		defer f.Close()
+		upload = f
+	}
+
 	// Check if the requested file exists already
 	exists, err := com.CheckFileExists(loggedInUser, loggedInUser, folder, fileName)
 	if err != nil {
@@ -5664,23 +10338,123 @@ func uploadFileHandler(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 		commitID = branchEntry.Commit
+
+		// The new version of a public project can't be kept hidden, as the project already isn't
+		if !publishAt.IsZero() {
+			pub, err := com.ProjectPublic(loggedInUser, folder, fileName)
+			if err != nil {
+				errorPage(w, r, http.StatusInternalServerError, err.Error())
+				return
+			}
+			if pub {
+				errorPage(w, r, http.StatusBadRequest, "That project is already public, so new versions of it "+
+					"can't be published later.  Create a release with a publishing time instead")
+				return
+			}
+		}
+	}
+
+	// Apply the template to new projects
+	if exists {
+		useTemplate = false
+	}
+	if useTemplate {
+		if (licenceName == "" || licenceName == "Not specified") && tpl.Licence != "" {
+			licenceName = tpl.Licence
+		}
+		if catID == 0 {
+			catID = tpl.Category
+		}
+		if readme == "" {
+			readme = com.TemplateReadme(tpl)
+		}
+	}
+
+	// Drafts are added as private projects, then given their public setting once they're marked as a draft.  That way
+	// they're never visible to others, even briefly.  Like the public setting, this only applies to new projects
+	draftPublic := public
+	if exists {
+		draft = false
+	}
+	if draft {
+		public = false
 	}
 
 	// Sanity check the uploaded file, and if ok then add it to the system
+	com.PublishLiveUpdate(loggedInUser, folder, fileName, com.LIVE_UPLOAD, "processing")
 	numBytes, _, err := com.AddFile(r, loggedInUser, loggedInUser, folder, fileName, createBranch, branchName,
-		commitID, public, licenceName, commitMsg, sourceURL, tempFile, "webui", time.Now(), time.Time{},
-		"", "", "", "", nil, "")
+		commitID, public, licenceName, commitMsg, sourceURL, upload, "webui", time.Now(), time.Time{},
+		"", "", "", "", nil, "", scrubMeta)
 	if err != nil {
+		com.PublishLiveUpdate(loggedInUser, folder, fileName, com.LIVE_UPLOAD, "failed")
 		errorPage(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
+	com.PublishLiveUpdate(loggedInUser, folder, fileName, com.LIVE_UPLOAD, "complete")
+	if draft {
+		err = com.MakeProjectDraft(loggedInUser, folder, fileName, draftPublic)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	if useTemplate {
+		err = com.ApplyProjectTemplate(loggedInUser, folder, fileName, tpl)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	// If a category was chosen, store it.  Leaving it unset keeps the existing category for new versions of a model.
+	// The same goes for the README
+	if catID != 0 {
+		err = com.StoreProjectCategory(loggedInUser, folder, fileName, catID)
+		if err != nil {
+			errorPage(w, r, http.StatusBadRequest, "Unknown category")
+			return
+		}
+	}
+	if readme != "" {
+		err = com.StoreProjectReadme(loggedInUser, folder, fileName, readme)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	if catID != 0 || readme != "" {
+		err = com.InvalidateCacheEntry(loggedInUser, loggedInUser, folder, fileName, "")
+		if err != nil {
+			com.Log.Errorf("Error when invalidating memcache entries: %s", err.Error())
+		}
+	}
+
+	// Schedule the project to be made public
+	if !publishAt.IsZero() {
+		err = com.SchedulePublication(loggedInUser, folder, fileName, "", com.ReleaseEntry{}, publishAt)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	// Check the details of public projects (or ones which will be) for spam
+	if public || !publishAt.IsZero() {
+		checkProjectSpam(r, loggedInUser, folder, fileName, fileName+"\n"+commitMsg+"\n"+readme)
+	}
+
+	// Update the search index
+	err = com.UpdateSearchIndex(loggedInUser, folder, fileName)
+	if err != nil {
+		com.Log.Errorf("Error when updating the search index: %s", err.Error())
+	}
 
 	// Log the successful upload
-	log.Printf("%s: Username: '%s', file '%s%s%s' uploaded', bytes: %v\n", pageName, loggedInUser,
+	com.Log.Infof("%s: Username: '%s', file '%s%s%s' uploaded', bytes: %v", pageName, loggedInUser,
 		loggedInUser, folder, fileName, numBytes)
 
 	// Upload succeeded.  Bounce the user to the page for their new upload
-	http.Redirect(w, r, fmt.Sprintf("/%s%s%s", loggedInUser, "/", fileName), http.StatusSeeOther)
+	http.Redirect(w, r, com.ProjectPath(loggedInUser, "/", fileName), http.StatusSeeOther)
 }
 
 // Handles JSON requests from the front end to toggle watching of a database.
@@ -5694,27 +10468,10 @@ func watchToggleHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	folder := "/"
 
-	// Retrieve session data (if any)
-	var loggedInUser string
-	var u interface{}
-	validSession := false
-	if com.Conf.Environment.Environment != "docker" {
-		sess, err := store.Get(r, "3dhub-user")
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
-		u = sess.Values["UserName"]
-	} else {
-		u = "default"
-	}
-	if u != nil {
-		loggedInUser = u.(string)
-		validSession = true
-	}
+	loggedInUser := sessionUser(r)
 
 	// Ensure we have a valid logged in user
-	if validSession != true {
+	if loggedInUser == "" {
 		// No logged in username, so nothing to update
 		w.WriteHeader(http.StatusBadRequest)
 		return
@@ -5732,7 +10489,7 @@ func watchToggleHandler(w http.ResponseWriter, r *http.Request) {
 	err = com.InvalidateCacheEntry(loggedInUser, owner, folder, fileName, "") // Empty string indicates "for all versions"
 	if err != nil {
 		// Something went wrong when invalidating memcached entries for the database
-		log.Printf("Error when invalidating memcache entries: %s\n", err.Error())
+		com.Log.Errorf("Error when invalidating memcache entries: %s", err.Error())
 		w.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprint(w, err.Error())
 		return
@@ -5749,3 +10506,155 @@ func watchToggleHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprint(w, newStarCount)
 	return
 }
+
+// Saves a new revision of a project wiki page, or removes a page.  Only the project owner can change the wiki.
+func wikiHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := sessionUser(r)
+
+	// Ensure we have a valid logged in user
+	if loggedInUser == "" {
+		errorPage(w, r, http.StatusUnauthorized, "You need to be logged in")
+		return
+	}
+
+	// Extract and validate the form variables
+	owner, folder, fileName, err := com.GetUFD(r, false)
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	pageName, err := com.GetFormWikiPage(r)
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if strings.ToLower(owner) != strings.ToLower(loggedInUser) {
+		errorPage(w, r, http.StatusForbidden, "Only the owner of a project can change its wiki")
+		return
+	}
+	exists, err := com.CheckFileExists(loggedInUser, owner, folder, fileName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Database failure when looking up database details")
+		return
+	}
+	if !exists {
+		errorPage(w, r, http.StatusNotFound, "That database doesn't seem to exist")
+		return
+	}
+	wikiPath := "/wiki" + com.ProjectPath(owner, folder, fileName)
+
+	// Removing a page takes its history with it
+	if r.PostFormValue("action") == "delete" {
+		err = com.DeleteWikiPage(owner, folder, fileName, pageName)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+		http.Redirect(w, r, wikiPath, http.StatusSeeOther)
+		return
+	}
+
+	// Wiki pages can be as long as a README
+	body := r.PostFormValue("body")
+	if strings.TrimSpace(body) == "" {
+		errorPage(w, r, http.StatusBadRequest, "The page can't be empty")
+		return
+	}
+	err = com.ValidateReadme(body)
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, "Invalid characters in the page, or it's too long")
+		return
+	}
+	summary := strings.TrimSpace(r.PostFormValue("summary"))
+	if summary != "" {
+		err = com.Validate.Var(summary, "markdownsource,max=120")
+		if err != nil {
+			errorPage(w, r, http.StatusBadRequest, "Invalid characters in the edit summary, or it's too long")
+			return
+		}
+	}
+	_, err = com.StoreWikiPage(owner, folder, fileName, pageName, loggedInUser, body, summary)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// The wiki of a public project is visible to everyone, so check it for spam
+	public, err := com.ProjectPublic(owner, folder, fileName)
+	if err == nil && public {
+		checkProjectSpam(r, owner, folder, fileName, pageName+"\n"+body)
+	}
+
+	http.Redirect(w, r, wikiPath+"?page="+url.QueryEscape(pageName), http.StatusSeeOther)
+}
+
+// Sends live updates for a project (star count changes, new comments, upload processing status) to the front end over
+// a WebSocket connection, so project pages don't need to poll for changes.
+func wsHandler(w http.ResponseWriter, r *http.Request) {
+	pageName := "WebSocket handler"
+
+	// Extract the user and database name
+	// TODO: Add folder support
+	owner, fileName, err := com.GetOD(1, r) // 1 = Ignore "/ws/" at the start of the URL
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	folder := "/"
+
+	loggedInUser := sessionUser(r)
+
+	// Make sure the project exists, and the user is allowed to see it
+	exists, err := com.CheckFileExists(loggedInUser, owner, folder, fileName)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// Only accept connections from pages we've served, as the session cookie is sent along with the request
+	wsServer := websocket.Server{
+		Handshake: func(config *websocket.Config, req *http.Request) (err error) {
+			config.Origin, err = websocket.Origin(config, req)
+			if err != nil {
+				return err
+			}
+			if config.Origin == nil || config.Origin.Host != req.Host {
+				return fmt.Errorf("Origin '%v' not allowed", config.Origin)
+			}
+			return nil
+		},
+		Handler: func(ws *websocket.Conn) {
+			defer ws.Close()
+			updates, unsubscribe := com.SubscribeLiveUpdates(owner, folder, fileName)
+			defer unsubscribe()
+
+			// We don't expect anything from the client, so just watch for the connection closing
+			closed := make(chan struct{})
+			go func() {
+				var msg string
+				for websocket.Message.Receive(ws, &msg) == nil {
+				}
+				close(closed)
+			}()
+
+			// Pass the updates along to the client
+			for {
+				select {
+				case upd := <-updates:
+					err := websocket.JSON.Send(ws, upd)
+					if err != nil {
+						com.Log.Errorf("%s: Error when sending live update: %v", pageName, err)
+						return
+					}
+				case <-closed:
+					return
+				}
+			}
+		},
+	}
+	wsServer.ServeHTTP(w, r)
+}
@@ -0,0 +1,481 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/bradfitz/gomemcache/memcache"
+	com "github.com/justinclift/3dhub.io/common"
+)
+
+// The IP address allow and block lists, and when they were last retrieved from PostgreSQL
+var (
+	ipAllowList    []*net.IPNet
+	ipBlockList    []*net.IPNet
+	ipRulesChecked time.Time
+	ipRulesMu      sync.Mutex
+)
+
+// How long users can do destructive operations for, after confirming who they are
+const stepUpWindow = 10 * time.Minute
+
+// Request counts and timings for each handler, shown on the admin debugging pages
+var (
+	metrics   = make(map[string]*handlerMetrics)
+	metricsMu sync.Mutex
+)
+
+type handlerMetrics struct {
+	ClientErrors int64         `json:"client_errors"`
+	MaxTime      time.Duration `json:"max_time_ns"`
+	Requests     int64         `json:"requests"`
+	ServerErrors int64         `json:"server_errors"`
+	TotalTime    time.Duration `json:"total_time_ns"`
+}
+
+// Returns the kind of request a handler serves, for the project access logs.
+func accessKind(handler string) string {
+	switch {
+	case strings.Contains(strings.ToLower(handler), "download"):
+		return "download"
+	case handler == "mainHandler" || strings.HasSuffix(handler, "Page"):
+		return "view"
+	}
+	return "api"
+}
+
+// Refuses requests from IP addresses on the block list, unless they're also on the allow list (so part of a blocked
+// network can be let through).
+func checkIPRules(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		allowed, blocked := ipListed(r)
+		if blocked && !allowed {
+			http.Error(w, "Access from your network has been blocked.  Please contact us if you think this is a "+
+				"mistake.", http.StatusForbidden)
+			return
+		}
+		fn(w, r)
+	}
+}
+
+// Refuses requests which change things (eg POSTs), when they've come from a page on another site.  This stops other
+// sites from submitting forms on behalf of our logged in users (CSRF).  Browsers tell us where requests come from
+// using the Sec-Fetch-Site header, or the older Origin header.  Requests without either aren't from a browser, and
+// requests without a session can't act as anyone, so both are let through.
+func checkOrigin(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			fn(w, r)
+			return
+		}
+		if sessionUser(r) != "" {
+			crossSite := false
+			if site := r.Header.Get("Sec-Fetch-Site"); site != "" {
+				crossSite = site != "same-origin" && site != "none"
+			} else if origin := r.Header.Get("Origin"); origin != "" {
+				u, err := url.Parse(origin)
+				crossSite = err != nil || u.Host != r.Host
+			}
+			if crossSite {
+				errorPage(w, r, http.StatusForbidden, "Requests from other sites aren't allowed")
+				return
+			}
+		}
+		fn(w, r)
+	}
+}
+
+// Returns the IP address of the client making a request.
+func clientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// Returns whether the logged in user has confirmed who they are recently enough for destructive operations (eg deleting
+// a project).  Logging in counts, as does logging in again or giving a code from their authenticator app on the step
+// up page.
+func elevated(r *http.Request) bool {
+	if com.Conf().Environment.Environment == "docker" {
+		return true
+	}
+	return time.Now().Unix() < requestDetails(r).elevatedUntil
+}
+
+// Returns whether the client IP address of a request is on the allow and block lists.  The lists are only looked up
+// once a minute (or straight after an admin changes them), so requests don't each need a database query.
+func ipListed(r *http.Request) (allowed bool, blocked bool) {
+	ip := net.ParseIP(clientIP(r))
+	if ip == nil {
+		return
+	}
+
+	ipRulesMu.Lock()
+	defer ipRulesMu.Unlock()
+	if time.Since(ipRulesChecked) > time.Minute {
+		if rules, err := com.IPRules(); err == nil {
+			ipAllowList, ipBlockList = nil, nil
+			for _, rule := range rules {
+				n, err := com.ParseNetwork(rule.Network)
+				if err != nil {
+					com.Log.Warnf("Skipping IP rule %d: %v", rule.ID, err)
+					continue
+				}
+				if rule.Action == "allow" {
+					ipAllowList = append(ipAllowList, n)
+				} else {
+					ipBlockList = append(ipBlockList, n)
+				}
+			}
+		}
+		ipRulesChecked = time.Now()
+	}
+	for _, n := range ipAllowList {
+		if n.Contains(ip) {
+			allowed = true
+			break
+		}
+	}
+	for _, n := range ipBlockList {
+		if n.Contains(ip) {
+			blocked = true
+			break
+		}
+	}
+	return
+}
+
+// Applies the real client address and a request ID to incoming requests, then writes them to the request log and the
+// structured log once they've been handled.
+func logRequest(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Use the real client address when the request has come through a trusted reverse proxy
+		com.ApplyProxyHeaders(r)
+
+		// Give the request an ID, so its log entries and any error page shown can be matched up
+		r = com.SetRequestID(w, r)
+
+		// Call the next function in the chain, timing how long it takes
+		info := requestDetails(r)
+		start := time.Now()
+		fn(&statusWriter{ResponseWriter: w, info: info}, r)
+
+		// Write request details to the request log
+		reqLog.log(requestLogEntry{
+			Client:    r.RemoteAddr,
+			Duration:  time.Since(start),
+			Method:    r.Method,
+			Proto:     r.Proto,
+			Referer:   r.Referer(),
+			RequestID: com.RequestID(r),
+			Status:    info.status,
+			Time:      start,
+			URL:       r.URL.String(),
+			User:      info.user,
+			UserAgent: r.Header.Get("User-Agent"),
+		})
+		loggedInUser := info.user
+		if loggedInUser == "" {
+			loggedInUser = "-"
+		}
+
+		// Log the details of the request
+		owner, project := requestProject(r)
+		com.Log.WithFields(logrus.Fields{
+			"client":     r.RemoteAddr,
+			"duration":   time.Since(start).String(),
+			"handler":    info.handler,
+			"method":     r.Method,
+			"owner":      owner,
+			"path":       r.URL.Path,
+			"project":    project,
+			"request_id": com.RequestID(r),
+			"status":     info.status,
+			"user":       loggedInUser,
+		}).Info("Request handled")
+
+		// Add requests for other people's projects to their owners' access logs
+		if owner != "" && project != "" && strings.ToLower(owner) != strings.ToLower(info.user) {
+			com.RecordProjectAccess(owner, "/", project, com.ProjectAccess{
+				Client:    r.RemoteAddr,
+				Kind:      accessKind(info.handler),
+				Method:    r.Method,
+				Path:      r.URL.Path,
+				Status:    info.status,
+				Time:      start,
+				UserAgent: r.Header.Get("User-Agent"),
+			})
+		}
+	}
+}
+
+// Limits the number of requests each client can make each minute, when a rate limit is set in the configuration file.
+// The counts are kept in memcached so the limit applies across all of the webui instances.  If memcached can't be
+// reached the requests are let through, rather than taking the whole site down with it.  Clients on the IP allow list
+// aren't limited.
+func limitRate(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit := com.Conf().Web.RateLimit
+		if allowed, _ := ipListed(r); limit <= 0 || allowed {
+			fn(w, r)
+			return
+		}
+
+		n, err := com.CountClientRequest(clientIP(r))
+		if err != nil {
+			com.Log.Warnf("Error when counting requests for the rate limiter: %v", err)
+		} else if n > uint64(limit) {
+			w.Header().Set("Retry-After", "60")
+			errorPage(w, r, http.StatusTooManyRequests, "Too many requests, please slow down")
+			return
+		}
+		fn(w, r)
+	}
+}
+
+// Looks up the logged in user (if any) for the request, so the handlers can retrieve it with sessionUser().  Their
+// chosen language, theme, and date preferences are picked up from the session too.  Sessions for suspended accounts,
+// or which have been revoked, are ended.
+func loadSession(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		info := requestDetails(r)
+		if com.Conf().Environment.Environment == "docker" {
+			info.user = "default"
+			fn(w, r)
+			return
+		}
+
+		sess, err := store.Get(r, "3dhub-user")
+		if err != nil {
+			if com.CacheUnavailable(err) {
+				// Sessions are kept in memcached, so while it's unavailable everyone is treated as logged out
+				com.Log.Warnf("Couldn't retrieve session, as memcached is unavailable: %v", err)
+				fn(w, r)
+				return
+			}
+			if err == memcache.ErrCacheMiss {
+				// If the memcache session token is stale (eg memcached has been restarted), delete the session
+				// TODO: This should probably look for the session token in persistent storage (eg PG) instead, so
+				// TODO  restarts of memcached don't nuke everyone's saved sessions
+
+				// Delete the session
+				// Note : gorilla/sessions uses MaxAge < 0 to mean "delete this session"
+				sess.Options.MaxAge = -1
+				err = sess.Save(r, w)
+				if err != nil {
+					errorPage(w, r, http.StatusInternalServerError, err.Error())
+					return
+				}
+
+				// Reload the page
+				http.Redirect(w, r, fmt.Sprintf("%s", r.URL), http.StatusTemporaryRedirect)
+				return
+			}
+			errorPage(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		if u, ok := sess.Values["UserName"].(string); ok {
+			// End the session if the account has been suspended, or its sessions revoked since this one started
+			suspended, revokedAt, err := com.UserAccountStatus(u)
+			if err != nil {
+				errorPage(w, r, http.StatusInternalServerError, err.Error())
+				return
+			}
+			var loginTime int64
+			if t, ok := sess.Values["LoginTime"].(int64); ok {
+				loginTime = t
+			}
+			if suspended || (!revokedAt.IsZero() && loginTime < revokedAt.Unix()) {
+				sess.Options.MaxAge = -1
+				err = sess.Save(r, w)
+				if err != nil {
+					errorPage(w, r, http.StatusInternalServerError, err.Error())
+					return
+				}
+
+				// Reload the page, this time without the session
+				http.Redirect(w, r, fmt.Sprintf("%s", r.URL), http.StatusTemporaryRedirect)
+				return
+			}
+			info.user = u
+
+			// Note the user is still around, at most once a day, so their account isn't treated as inactive
+			if seen, _ := sess.Values["LastSeen"].(int64); time.Now().Unix()-seen > 24*60*60 {
+				recordActivity(u)
+				sess.Values["LastSeen"] = time.Now().Unix()
+				sess.Save(r, w)
+			}
+			info.locale, _ = sess.Values["Locale"].(string)
+			info.theme, _ = sess.Values["Theme"].(string)
+			info.timeZone, _ = sess.Values["TimeZone"].(string)
+			info.dateFormat, _ = sess.Values["DateFormat"].(string)
+			info.elevatedUntil, _ = sess.Values["ElevatedUntil"].(int64)
+		}
+		fn(w, r)
+	}
+}
+
+// Sends users who haven't confirmed who they are recently to the step up page, which returns them to the given page
+// afterwards.  The return value is true when the user has been sent there, so the handler should stop.
+func needStepUp(w http.ResponseWriter, r *http.Request, returnTo string) bool {
+	if elevated(r) {
+		return false
+	}
+	http.Redirect(w, r, "/stepup?return="+url.QueryEscape(returnTo), http.StatusSeeOther)
+	return true
+}
+
+// Notes that a user has used the site, which also cancels the reclamation of their username if one was started.
+func recordActivity(userName string) {
+	cancelled, err := com.RecordUserActivity(userName)
+	if err != nil {
+		return
+	}
+	if cancelled {
+		com.Log.Infof("Reclamation of username '%s' cancelled, as they've used the site again", userName)
+	}
+}
+
+// Adds the time taken and status of each request to the metrics for its handler.
+func recordMetrics(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		fn(w, r)
+		elapsed := time.Since(start)
+
+		info := requestDetails(r)
+		metricsMu.Lock()
+		m, ok := metrics[info.handler]
+		if !ok {
+			m = &handlerMetrics{}
+			metrics[info.handler] = m
+		}
+		m.Requests++
+		m.TotalTime += elapsed
+		if elapsed > m.MaxTime {
+			m.MaxTime = elapsed
+		}
+		switch {
+		case info.status >= 500:
+			m.ServerErrors++
+		case info.status >= 400:
+			m.ClientErrors++
+		}
+		metricsMu.Unlock()
+	}
+}
+
+// Returns the date format and timezone to show dates with.  Visitors who aren't logged in (or haven't chosen a
+// timezone) get the timezone of their browser, which is given as an empty string.
+func requestDatePrefs(r *http.Request) (dateFormat string, timeZone string) {
+	info := requestDetails(r)
+	dateFormat = info.dateFormat
+	if dateFormat == "" {
+		dateFormat = com.DateFormats[0].ID
+	}
+	return dateFormat, info.timeZone
+}
+
+// Returns the colour theme to show the pages with.  Logged in users have it saved with their preferences, and other
+// visitors can choose one which is kept in a cookie.
+func requestTheme(r *http.Request) com.Theme {
+	t := com.Theme(requestDetails(r).theme)
+	if sessionUser(r) == "" {
+		if c, err := r.Cookie("theme"); err == nil {
+			t = com.Theme(c.Value)
+		}
+	}
+	switch t {
+	case com.THEME_DARK, com.THEME_LIGHT:
+		return t
+	}
+	return com.THEME_AUTO
+}
+
+// Only lets site administrators through.
+func requireAdmin(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !com.IsAdmin(sessionUser(r)) {
+			errorPage(w, r, http.StatusForbidden, "You need to be a site administrator to access this")
+			return
+		}
+		fn(w, r)
+	}
+}
+
+// Makes anonymous downloads need a download token, when that's turned on in the configuration file.  Clients without
+// one are given a token with their first download, kept in a session cookie, and each token is limited to a number of
+// downloads an hour.  Logged in users and clients on the IP allow list aren't limited.
+func requireDownloadToken(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if allowed, _ := ipListed(r); !com.Conf().Web.DownloadTokens || sessionUser(r) != "" || allowed {
+			fn(w, r)
+			return
+		}
+
+		var token string
+		if c, err := r.Cookie("dltoken"); err == nil {
+			token = c.Value
+		}
+		valid, ok, err := com.UseDownloadToken(token)
+		if err != nil {
+			com.Log.Warnf("Error when checking a download token: %v", err)
+			fn(w, r)
+			return
+		}
+		if valid && !ok {
+			w.Header().Set("Retry-After", "3600")
+			errorPage(w, r, http.StatusTooManyRequests,
+				"Too many downloads, please try again later or log in to keep downloading")
+			return
+		}
+		if !valid {
+			token, ok, err = com.IssueDownloadToken(clientIP(r))
+			if err != nil {
+				com.Log.Warnf("Error when issuing a download token: %v", err)
+				fn(w, r)
+				return
+			}
+			if !ok {
+				w.Header().Set("Retry-After", "3600")
+				errorPage(w, r, http.StatusTooManyRequests,
+					"Too many downloads, please try again later or log in to keep downloading")
+				return
+			}
+			http.SetCookie(w, &http.Cookie{
+				Name:     "dltoken",
+				Value:    token,
+				Path:     "/",
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode,
+			})
+
+			// The first download with the new token counts towards its limit too
+			com.UseDownloadToken(token)
+		}
+		fn(w, r)
+	}
+}
+
+// Writes the request metrics for each handler, as JSON.
+func writeMetrics(w http.ResponseWriter) {
+	metricsMu.Lock()
+	data, err := json.MarshalIndent(metrics, "", "  ")
+	metricsMu.Unlock()
+	if err != nil {
+		com.Log.Errorf("Error when serialising request metrics: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, string(data))
+}
@@ -2,7 +2,7 @@ package main
 
 import (
 	"fmt"
-	"log"
+	"html/template"
 	"net/http"
 	"strconv"
 	"strings"
@@ -10,6 +10,7 @@ import (
 
 	com "github.com/justinclift/3dhub.io/common"
 	gfm "github.com/sqlitebrowser/github_flavored_markdown"
+	"golang.org/x/sync/errgroup"
 )
 
 // Renders the "About Us" page.
@@ -19,23 +20,8 @@ func aboutPage(w http.ResponseWriter, r *http.Request) {
 		Meta  com.MetaInfo
 	}
 
-	// Retrieve session data (if any)
-	var loggedInUser string
-	var u interface{}
-	if com.Conf.Environment.Environment != "docker" {
-		sess, err := store.Get(r, "3dhub-user")
-		if err != nil {
-			errorPage(w, r, http.StatusBadRequest, err.Error())
-			return
-		}
-		u = sess.Values["UserName"]
-	} else {
-		u = "default"
-	}
-	if u != nil {
-		loggedInUser = u.(string)
-		pageData.Meta.LoggedInUser = loggedInUser
-	}
+	loggedInUser := sessionUser(r)
+	pageData.Meta.LoggedInUser = loggedInUser
 
 	// Retrieve the details and status updates count for the logged in user
 	if loggedInUser != "" {
@@ -55,18 +41,408 @@ func aboutPage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	pageData.Meta.Title = "What is 3DHub.io?"
-	pageData.Meta.WebsiteName = com.Conf.Web.WebsiteName
+	pageData.Meta.WebsiteName = com.Conf().Web.WebsiteName
+	pageData.Meta.Theme = requestTheme(r)
+	pageData.Meta.DateFormat, pageData.Meta.TimeZone = requestDatePrefs(r)
 
 	// Add Auth0 info to the page data
-	pageData.Auth0.CallbackURL = "https://" + com.Conf.Web.ServerName + "/x/callback"
-	pageData.Auth0.ClientID = com.Conf.Auth0.ClientID
-	pageData.Auth0.Domain = com.Conf.Auth0.Domain
+	pageData.Auth0.CallbackURL = "https://" + com.Conf().Web.ServerName + "/x/callback"
+	pageData.Auth0.ClientID = com.Conf().Auth0.ClientID
+	pageData.Auth0.Domain = com.Conf().Auth0.Domain
 
 	// Render the page
-	t := tmpl.Lookup("aboutPage")
+	t := templates(requestLocale(r)).Lookup("aboutPage")
 	err := t.Execute(w, pageData)
 	if err != nil {
-		log.Printf("Error: %s", err)
+		com.Log.Errorf("Error: %s", err)
+	}
+}
+
+// Shows the owner of a project the recent requests for it by other people, with the client addresses anonymised.
+func accessLogPage(w http.ResponseWriter, r *http.Request) {
+	var pageData struct {
+		Auth0   com.Auth0Set
+		Days    int
+		Entries []com.ProjectAccess
+		Kind    string
+		Meta    com.MetaInfo
+	}
+	pageData.Meta.Title = "Access log"
+
+	loggedInUser := sessionUser(r)
+	pageData.Meta.LoggedInUser = loggedInUser
+
+	// Ensure we have a valid logged in user
+	if loggedInUser == "" {
+		errorPage(w, r, http.StatusUnauthorized, "You need to be logged in")
+		return
+	}
+
+	// Retrieve owner and database name
+	// TODO: Add folder support
+	folder := "/"
+	owner, fileName, err := com.GetOD(1, r) // 1 = Ignore "/accesslog/" at the start of the URL
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if owner == "" || fileName == "" {
+		errorPage(w, r, http.StatusBadRequest, "Missing database owner or database name")
+		return
+	}
+	if strings.ToLower(owner) != strings.ToLower(loggedInUser) {
+		errorPage(w, r, http.StatusForbidden, "You can only view the access logs for your own projects")
+		return
+	}
+	exists, err := com.CheckFileExists(loggedInUser, owner, folder, fileName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Database failure when looking up database details")
+		return
+	}
+	if !exists {
+		errorPage(w, r, http.StatusNotFound, "That database doesn't seem to exist")
+		return
+	}
+
+	// The entries can be filtered by the kind of request
+	switch k := r.FormValue("kind"); k {
+	case "api", "download", "view":
+		pageData.Kind = k
+	}
+	pageData.Entries, err = com.ProjectAccessLog(owner, folder, fileName, pageData.Kind, 500)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Error when retrieving the access log")
+		return
+	}
+	pageData.Days = com.Conf().Web.AccessLogDays
+
+	// Retrieve correctly capitalised username for the database owner
+	usr, err := com.User(owner)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	pageData.Meta.Owner = usr.Username
+	pageData.Meta.Database = fileName
+	if usr.AvatarURL != "" {
+		pageData.Meta.AvatarURL = usr.AvatarURL + "&s=48"
+	}
+	pageData.Meta.NumStatusUpdates, err = com.UserStatusUpdates(loggedInUser)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Add Auth0 info to the page data
+	pageData.Auth0.CallbackURL = "https://" + com.Conf().Web.ServerName + "/x/callback"
+	pageData.Auth0.ClientID = com.Conf().Auth0.ClientID
+	pageData.Auth0.Domain = com.Conf().Auth0.Domain
+
+	// Render the page
+	pageData.Meta.WebsiteName = com.Conf().Web.WebsiteName
+	pageData.Meta.Theme = requestTheme(r)
+	pageData.Meta.DateFormat, pageData.Meta.TimeZone = requestDatePrefs(r)
+	t := templates(requestLocale(r)).Lookup("accessLogPage")
+	err = t.Execute(w, pageData)
+	if err != nil {
+		com.Log.Errorf("Error: %s", err)
+	}
+}
+
+// Renders the admin page for managing the category tree.  Only available to site administrators.
+func adminCategoriesPage(w http.ResponseWriter, r *http.Request) {
+	var pageData struct {
+		Auth0      com.Auth0Set
+		Categories []com.Category
+		Meta       com.MetaInfo
+		Parents    []com.Category
+	}
+	pageData.Meta.Title = "Manage categories"
+
+	loggedInUser := sessionUser(r)
+	pageData.Meta.LoggedInUser = loggedInUser
+
+	// Retrieve the category tree
+	var err error
+	pageData.Categories, err = com.Categories()
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Error when retrieving list of categories")
+		return
+	}
+
+	// Only categories with room for another level below them can be chosen as the parent for a new one
+	for _, c := range pageData.Categories {
+		if c.Depth+1 < com.MaxCategoryDepth {
+			pageData.Parents = append(pageData.Parents, c)
+		}
+	}
+
+	// Retrieve the details and status updates count for the logged in user
+	ur, err := com.User(loggedInUser)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if ur.AvatarURL != "" {
+		pageData.Meta.AvatarURL = ur.AvatarURL + "&s=48"
+	}
+	pageData.Meta.NumStatusUpdates, err = com.UserStatusUpdates(loggedInUser)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Add Auth0 info to the page data
+	pageData.Auth0.CallbackURL = "https://" + com.Conf().Web.ServerName + "/x/callback"
+	pageData.Auth0.ClientID = com.Conf().Auth0.ClientID
+	pageData.Auth0.Domain = com.Conf().Auth0.Domain
+
+	// Render the page
+	pageData.Meta.WebsiteName = com.Conf().Web.WebsiteName
+	pageData.Meta.Theme = requestTheme(r)
+	pageData.Meta.DateFormat, pageData.Meta.TimeZone = requestDatePrefs(r)
+	t := templates(requestLocale(r)).Lookup("adminCategoriesPage")
+	err = t.Execute(w, pageData)
+	if err != nil {
+		com.Log.Errorf("Error: %s", err)
+	}
+}
+
+// Render the moderation queue, which lists reported projects, newly public ones waiting to be checked, comments held
+// by the spam check or word filter, and usernames flagged by the word filter.
+func adminModerationPage(w http.ResponseWriter, r *http.Request) {
+	var pageData struct {
+		Auth0     com.Auth0Set
+		Comments  []com.HeldCommentEntry
+		Meta      com.MetaInfo
+		Queue     []com.ModerationEntry
+		Strict    bool
+		Usernames []com.FlaggedUsername
+	}
+	pageData.Meta.Title = "Moderation queue"
+
+	loggedInUser := sessionUser(r)
+	pageData.Meta.LoggedInUser = loggedInUser
+
+	// Retrieve the projects waiting for moderation
+	var err error
+	pageData.Queue, err = com.ModerationQueue()
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Error when retrieving the moderation queue")
+		return
+	}
+	pageData.Comments, err = com.HeldComments()
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Error when retrieving the held comments")
+		return
+	}
+	pageData.Usernames, err = com.FlaggedUsernames()
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Error when retrieving the flagged usernames")
+		return
+	}
+	pageData.Strict = com.Conf().Moderation.Strict
+
+	// Retrieve the details and status updates count for the logged in user
+	ur, err := com.User(loggedInUser)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if ur.AvatarURL != "" {
+		pageData.Meta.AvatarURL = ur.AvatarURL + "&s=48"
+	}
+	pageData.Meta.NumStatusUpdates, err = com.UserStatusUpdates(loggedInUser)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Add Auth0 info to the page data
+	pageData.Auth0.CallbackURL = "https://" + com.Conf().Web.ServerName + "/x/callback"
+	pageData.Auth0.ClientID = com.Conf().Auth0.ClientID
+	pageData.Auth0.Domain = com.Conf().Auth0.Domain
+
+	// Render the page
+	pageData.Meta.WebsiteName = com.Conf().Web.WebsiteName
+	pageData.Meta.Theme = requestTheme(r)
+	pageData.Meta.DateFormat, pageData.Meta.TimeZone = requestDatePrefs(r)
+	t := templates(requestLocale(r)).Lookup("adminModerationPage")
+	err = t.Execute(w, pageData)
+	if err != nil {
+		com.Log.Errorf("Error: %s", err)
+	}
+}
+
+// Renders the main admin page, for managing user accounts.  The most recent audit log entries are shown too.
+func adminPage(w http.ResponseWriter, r *http.Request) {
+	var pageData struct {
+		Announcements  []com.Announcement
+		Auth0          com.Auth0Set
+		AuditLog       []com.AuditLogEntry
+		Backup         com.BackupInfo
+		Backups        []com.BackupRun
+		Categories     []com.Category
+		DailyQuota     int64
+		DeadJobs       []com.JobEntry
+		Features       []com.FeatureFlag
+		FilterConfig   []string
+		FilterEnabled  bool
+		FilterWords    []com.FilterWord
+		Inactive       []com.InactiveAccount
+		InactiveYears  int
+		IPRules        []com.IPRule
+		JobCounts      []com.JobTypeCount
+		Licences       map[string]com.LicenceEntry
+		Meta           com.MetaInfo
+		MinYears       int
+		Reclamations   []com.UsernameReclamation
+		ReservedNames  []com.ReservedUsername
+		ReservedWords  []string
+		TemplateAction string
+		Templates      []com.ProjectTemplate
+		Users          []com.AdminUserEntry
+		WebhookEvents  []com.AdminWebhookEvent
+		Webhooks       []com.AdminWebhookEntry
+	}
+	pageData.Meta.Title = "Site administration"
+
+	loggedInUser := sessionUser(r)
+	pageData.Meta.LoggedInUser = loggedInUser
+
+	// Retrieve the user list, recent audit log entries, announcements, IP rules, reserved usernames, the word filter,
+	// inactive accounts, features, global webhooks, background jobs, and backups
+	var err error
+	pageData.Users, err = com.AdminUserList()
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Error when retrieving list of users")
+		return
+	}
+	pageData.AuditLog, err = com.AuditLog(50)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Error when retrieving the audit log")
+		return
+	}
+	pageData.Announcements, err = com.Announcements()
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Error when retrieving the announcements")
+		return
+	}
+	pageData.IPRules, err = com.IPRules()
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Error when retrieving the IP rules")
+		return
+	}
+	pageData.ReservedNames, err = com.ReservedUsernames()
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Error when retrieving the reserved usernames")
+		return
+	}
+	pageData.ReservedWords = com.BuiltinReservedUsernames
+	pageData.FilterWords, err = com.FilterWords()
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Error when retrieving the word filter list")
+		return
+	}
+	pageData.FilterConfig = com.Conf().Filter.Words
+	pageData.FilterEnabled = com.Conf().Filter.Enabled
+
+	// Instance project templates belong to the "default" user, so their licences come from its list
+	pageData.Templates, err = com.ProjectTemplates("default")
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Error when retrieving the project templates")
+		return
+	}
+	pageData.TemplateAction = "/x/admin/projecttemplate"
+	pageData.Licences, err = com.GetLicences("default")
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Error when retrieving list of available licences")
+		return
+	}
+	pageData.Categories, err = com.Categories()
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Error when retrieving list of categories")
+		return
+	}
+
+	// The inactive accounts are only looked for when asked, as it checks every account
+	pageData.MinYears = com.ReclaimMinInactiveYears
+	pageData.InactiveYears = pageData.MinYears
+	if y := r.FormValue("inactive"); y != "" {
+		pageData.InactiveYears, err = strconv.Atoi(y)
+		if err != nil || pageData.InactiveYears < pageData.MinYears {
+			errorPage(w, r, http.StatusBadRequest, "Invalid number of years")
+			return
+		}
+		pageData.Inactive, err = com.InactiveAccounts(pageData.InactiveYears, 100)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, "Error when retrieving the inactive accounts")
+			return
+		}
+	}
+	pageData.Reclamations, err = com.UsernameReclamations()
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Error when retrieving the username reclamations")
+		return
+	}
+	pageData.Features, err = com.FeatureFlags()
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Error when retrieving the feature flags")
+		return
+	}
+	pageData.Webhooks, err = com.AdminWebhooks("")
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Error when retrieving the global webhooks")
+		return
+	}
+	pageData.WebhookEvents = com.AdminWebhookEvents
+	pageData.JobCounts, err = com.JobCounts()
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Error when retrieving the background job counts")
+		return
+	}
+	pageData.DeadJobs, err = com.DeadJobs()
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Error when retrieving the failed background jobs")
+		return
+	}
+	pageData.Backups, err = com.BackupRuns(10)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Error when retrieving the recent backups")
+		return
+	}
+	pageData.Backup = com.Conf().Backup
+	pageData.Backup.AccessKey, pageData.Backup.Passphrase, pageData.Backup.Secret = "", "", ""
+	pageData.DailyQuota = com.Conf().Quota.DailyUploadMB
+
+	// Retrieve the details and status updates count for the logged in user
+	ur, err := com.User(loggedInUser)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if ur.AvatarURL != "" {
+		pageData.Meta.AvatarURL = ur.AvatarURL + "&s=48"
+	}
+	pageData.Meta.NumStatusUpdates, err = com.UserStatusUpdates(loggedInUser)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Add Auth0 info to the page data
+	pageData.Auth0.CallbackURL = "https://" + com.Conf().Web.ServerName + "/x/callback"
+	pageData.Auth0.ClientID = com.Conf().Auth0.ClientID
+	pageData.Auth0.Domain = com.Conf().Auth0.Domain
+
+	// Render the page
+	pageData.Meta.WebsiteName = com.Conf().Web.WebsiteName
+	pageData.Meta.Theme = requestTheme(r)
+	pageData.Meta.DateFormat, pageData.Meta.TimeZone = requestDatePrefs(r)
+	t := templates(requestLocale(r)).Lookup("adminPage")
+	err = t.Execute(w, pageData)
+	if err != nil {
+		com.Log.Errorf("Error: %s", err)
 	}
 }
 
@@ -88,23 +464,8 @@ func branchesPage(w http.ResponseWriter, r *http.Request) {
 	}
 	pageData.Meta.Title = "Branch list"
 
-	// Retrieve session data (if any)
-	var loggedInUser string
-	var u interface{}
-	if com.Conf.Environment.Environment != "docker" {
-		sess, err := store.Get(r, "3dhub-user")
-		if err != nil {
-			errorPage(w, r, http.StatusBadRequest, err.Error())
-			return
-		}
-		u = sess.Values["UserName"]
-	} else {
-		u = "default"
-	}
-	if u != nil {
-		loggedInUser = u.(string)
-		pageData.Meta.LoggedInUser = loggedInUser
-	}
+	loggedInUser := sessionUser(r)
+	pageData.Meta.LoggedInUser = loggedInUser
 
 	// Retrieve the database owner & name
 	// TODO: Add folder and branch name support
@@ -197,30 +558,86 @@ func branchesPage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Add Auth0 info to the page data
-	pageData.Auth0.CallbackURL = "https://" + com.Conf.Web.ServerName + "/x/callback"
-	pageData.Auth0.ClientID = com.Conf.Auth0.ClientID
-	pageData.Auth0.Domain = com.Conf.Auth0.Domain
+	pageData.Auth0.CallbackURL = "https://" + com.Conf().Web.ServerName + "/x/callback"
+	pageData.Auth0.ClientID = com.Conf().Auth0.ClientID
+	pageData.Auth0.Domain = com.Conf().Auth0.Domain
 
 	// Render the page
-	pageData.Meta.WebsiteName = com.Conf.Web.WebsiteName
-	t := tmpl.Lookup("branchesPage")
+	pageData.Meta.WebsiteName = com.Conf().Web.WebsiteName
+	pageData.Meta.Theme = requestTheme(r)
+	pageData.Meta.DateFormat, pageData.Meta.TimeZone = requestDatePrefs(r)
+	t := templates(requestLocale(r)).Lookup("branchesPage")
 	err = t.Execute(w, pageData)
 	if err != nil {
-		log.Printf("Error: %s", err)
+		com.Log.Errorf("Error: %s", err)
 	}
 }
 
-// Render the commits page.  This shows all of the commits in a given branch, in reverse order from newest to oldest.
-func commitsPage(w http.ResponseWriter, r *http.Request) {
-	// Structure to hold page data
-	type HistEntry struct {
-		AuthorEmail    string     `json:"author_email"`
-		AuthorName     string     `json:"author_name"`
-		AuthorUserName string     `json:"author_user_name"`
-		AvatarURL      string     `json:"avatar_url"`
-		CommitterEmail string     `json:"committer_email"`
+// Renders the category browse page, which lists the full category tree.
+func categoriesPage(w http.ResponseWriter, r *http.Request) {
+	var pageData struct {
+		Auth0      com.Auth0Set
+		Categories []com.Category
+		Meta       com.MetaInfo
+	}
+	pageData.Meta.Title = "Categories"
+
+	loggedInUser := sessionUser(r)
+	pageData.Meta.LoggedInUser = loggedInUser
+
+	// Retrieve the category tree
+	var err error
+	pageData.Categories, err = com.Categories()
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Error when retrieving list of categories")
+		return
+	}
+
+	// Retrieve the details and status updates count for the logged in user
+	if loggedInUser != "" {
+		ur, err := com.User(loggedInUser)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if ur.AvatarURL != "" {
+			pageData.Meta.AvatarURL = ur.AvatarURL + "&s=48"
+		}
+		pageData.Meta.NumStatusUpdates, err = com.UserStatusUpdates(loggedInUser)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	// Add Auth0 info to the page data
+	pageData.Auth0.CallbackURL = "https://" + com.Conf().Web.ServerName + "/x/callback"
+	pageData.Auth0.ClientID = com.Conf().Auth0.ClientID
+	pageData.Auth0.Domain = com.Conf().Auth0.Domain
+
+	// Render the page
+	pageData.Meta.WebsiteName = com.Conf().Web.WebsiteName
+	pageData.Meta.Theme = requestTheme(r)
+	pageData.Meta.DateFormat, pageData.Meta.TimeZone = requestDatePrefs(r)
+	t := templates(requestLocale(r)).Lookup("categoriesPage")
+	err = t.Execute(w, pageData)
+	if err != nil {
+		com.Log.Errorf("Error: %s", err)
+	}
+}
+
+// Render the commits page.  This shows all of the commits in a given branch, in reverse order from newest to oldest.
+func commitsPage(w http.ResponseWriter, r *http.Request) {
+	// Structure to hold page data
+	type HistEntry struct {
+		AuthorEmail    string     `json:"author_email"`
+		AuthorName     string     `json:"author_name"`
+		AuthorUserName string     `json:"author_user_name"`
+		AvatarURL      string     `json:"avatar_url"`
+		CommitterEmail string     `json:"committer_email"`
 		CommitterName  string     `json:"committer_name"`
 		ID             string     `json:"id"`
+		Licence        string     `json:"licence"`
 		Message        string     `json:"message"`
 		Parent         string     `json:"parent"`
 		Timestamp      time.Time  `json:"timestamp"`
@@ -236,23 +653,8 @@ func commitsPage(w http.ResponseWriter, r *http.Request) {
 	}
 	pageData.Meta.Title = "Commits settings"
 
-	// Retrieve session data (if any)
-	var loggedInUser string
-	var u interface{}
-	if com.Conf.Environment.Environment != "docker" {
-		sess, err := store.Get(r, "3dhub-user")
-		if err != nil {
-			errorPage(w, r, http.StatusBadRequest, err.Error())
-			return
-		}
-		u = sess.Values["UserName"]
-	} else {
-		u = "default"
-	}
-	if u != nil {
-		loggedInUser = u.(string)
-		pageData.Meta.LoggedInUser = loggedInUser
-	}
+	loggedInUser := sessionUser(r)
+	pageData.Meta.LoggedInUser = loggedInUser
 
 	// Retrieve the database owner & name, and branch name
 	// TODO: Add folder support
@@ -330,9 +732,26 @@ func commitsPage(w http.ResponseWriter, r *http.Request) {
 		errorPage(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
+	// Returns the name of the licence used in a commit.  The names are cached, as most commits share a licence
+	licNames := make(map[string]string)
+	commitLicence := func(c com.CommitEntry) (string, error) {
+		licSHA := c.Tree.Entries[0].LicenceSHA
+		if licSHA == "" {
+			return "Not specified", nil
+		}
+		if l, ok := licNames[licSHA]; ok {
+			return l, nil
+		}
+		l, _, err := com.GetLicenceInfoFromSha256(owner, licSHA)
+		if err != nil {
+			return "", err
+		}
+		licNames[licSHA] = l
+		return l, nil
+	}
+
 	// TODO: Ugh, this is an ugly approach just to add the username to the commit data.  Surely there's a better way?
 	// TODO  Maybe store the username in the commit data structure in the database instead?
-	// TODO: Display licence changes too
 	uName, avatarURL, err := com.GetUsernameFromEmail(rawList[headID].AuthorEmail)
 	if err != nil {
 		errorPage(w, r, http.StatusInternalServerError, err.Error())
@@ -341,6 +760,11 @@ func commitsPage(w http.ResponseWriter, r *http.Request) {
 	if avatarURL != "" {
 		avatarURL += "&s=30"
 	}
+	lic, err := commitLicence(rawList[headID])
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
 
 	// Create the history entry
 	pageData.History = []HistEntry{
@@ -352,6 +776,7 @@ func commitsPage(w http.ResponseWriter, r *http.Request) {
 			CommitterEmail: rawList[headID].CommitterEmail,
 			CommitterName:  rawList[headID].CommitterName,
 			ID:             rawList[headID].ID,
+			Licence:        lic,
 			Message:        string(gfm.Markdown([]byte(rawList[headID].Message))),
 			Parent:         rawList[headID].Parent,
 			Timestamp:      rawList[headID].Timestamp,
@@ -372,6 +797,11 @@ func commitsPage(w http.ResponseWriter, r *http.Request) {
 		if avatarURL != "" {
 			avatarURL += "&s=30"
 		}
+		lic, err = commitLicence(commitData)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
 
 		// Create a history entry
 		newEntry := HistEntry{
@@ -382,6 +812,7 @@ func commitsPage(w http.ResponseWriter, r *http.Request) {
 			CommitterEmail: commitData.CommitterEmail,
 			CommitterName:  commitData.CommitterName,
 			ID:             commitData.ID,
+			Licence:        lic,
 			Message:        string(gfm.Markdown([]byte(commitData.Message))),
 			Parent:         commitData.Parent,
 			Timestamp:      commitData.Timestamp,
@@ -422,16 +853,18 @@ func commitsPage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Add Auth0 info to the page data
-	pageData.Auth0.CallbackURL = "https://" + com.Conf.Web.ServerName + "/x/callback"
-	pageData.Auth0.ClientID = com.Conf.Auth0.ClientID
-	pageData.Auth0.Domain = com.Conf.Auth0.Domain
+	pageData.Auth0.CallbackURL = "https://" + com.Conf().Web.ServerName + "/x/callback"
+	pageData.Auth0.ClientID = com.Conf().Auth0.ClientID
+	pageData.Auth0.Domain = com.Conf().Auth0.Domain
 
 	// Render the page
-	pageData.Meta.WebsiteName = com.Conf.Web.WebsiteName
-	t := tmpl.Lookup("commitsPage")
+	pageData.Meta.WebsiteName = com.Conf().Web.WebsiteName
+	pageData.Meta.Theme = requestTheme(r)
+	pageData.Meta.DateFormat, pageData.Meta.TimeZone = requestDatePrefs(r)
+	t := templates(requestLocale(r)).Lookup("commitsPage")
 	err = t.Execute(w, pageData)
 	if err != nil {
-		log.Printf("Error: %s", err)
+		com.Log.Errorf("Error: %s", err)
 	}
 }
 
@@ -457,28 +890,11 @@ func comparePage(w http.ResponseWriter, r *http.Request) {
 	}
 	pageData.Meta.Title = "Create a Merge Request"
 
-	// Retrieve session data (if any)
-	var loggedInUser string
-	var u interface{}
-	validSession := false
-	if com.Conf.Environment.Environment != "docker" {
-		sess, err := store.Get(r, "3dhub-user")
-		if err != nil {
-			errorPage(w, r, http.StatusBadRequest, err.Error())
-			return
-		}
-		u = sess.Values["UserName"]
-	} else {
-		u = "default"
-	}
-	if u != nil {
-		loggedInUser = u.(string)
-		pageData.Meta.LoggedInUser = loggedInUser
-		validSession = true
-	}
+	loggedInUser := sessionUser(r)
+	pageData.Meta.LoggedInUser = loggedInUser
 
 	// Ensure we have a valid logged in user
-	if validSession != true {
+	if loggedInUser == "" {
 		errorPage(w, r, http.StatusUnauthorized, "You need to be logged in")
 		return
 	}
@@ -678,16 +1094,18 @@ func comparePage(w http.ResponseWriter, r *http.Request) {
 	pageData.Meta.Database = fileName
 
 	// Add Auth0 info to the page data
-	pageData.Auth0.CallbackURL = "https://" + com.Conf.Web.ServerName + "/x/callback"
-	pageData.Auth0.ClientID = com.Conf.Auth0.ClientID
-	pageData.Auth0.Domain = com.Conf.Auth0.Domain
+	pageData.Auth0.CallbackURL = "https://" + com.Conf().Web.ServerName + "/x/callback"
+	pageData.Auth0.ClientID = com.Conf().Auth0.ClientID
+	pageData.Auth0.Domain = com.Conf().Auth0.Domain
 
 	// Render the page
-	pageData.Meta.WebsiteName = com.Conf.Web.WebsiteName
-	t := tmpl.Lookup("comparePage")
+	pageData.Meta.WebsiteName = com.Conf().Web.WebsiteName
+	pageData.Meta.Theme = requestTheme(r)
+	pageData.Meta.DateFormat, pageData.Meta.TimeZone = requestDatePrefs(r)
+	t := templates(requestLocale(r)).Lookup("comparePage")
 	err = t.Execute(w, pageData)
 	if err != nil {
-		log.Printf("Error: %s", err)
+		com.Log.Errorf("Error: %s", err)
 	}
 }
 
@@ -699,32 +1117,20 @@ func confirmDeletePage(w http.ResponseWriter, r *http.Request) {
 	}
 	pageData.Meta.Title = "Confirm database deletion"
 
-	// Retrieve session data (if any)
-	var loggedInUser string
-	var u interface{}
-	validSession := false
-	if com.Conf.Environment.Environment != "docker" {
-		sess, err := store.Get(r, "3dhub-user")
-		if err != nil {
-			errorPage(w, r, http.StatusBadRequest, err.Error())
-			return
-		}
-		u = sess.Values["UserName"]
-	} else {
-		u = "default"
-	}
-	if u != nil {
-		loggedInUser = u.(string)
-		pageData.Meta.LoggedInUser = loggedInUser
-		validSession = true
-	}
+	loggedInUser := sessionUser(r)
+	pageData.Meta.LoggedInUser = loggedInUser
 
 	// Ensure we have a valid logged in user
-	if validSession != true {
+	if loggedInUser == "" {
 		errorPage(w, r, http.StatusUnauthorized, "You need to be logged in")
 		return
 	}
 
+	// Deleting can't be undone, so the user needs to have recently confirmed who they are
+	if needStepUp(w, r, r.URL.RequestURI()) {
+		return
+	}
+
 	// Retrieve the owner and database name
 	owner, fileName, err := com.GetOD(1, r) // "1" means skip the first URL word
 	if err != nil {
@@ -779,16 +1185,113 @@ func confirmDeletePage(w http.ResponseWriter, r *http.Request) {
 	pageData.Meta.Database = fileName
 
 	// Add Auth0 info to the page data
-	pageData.Auth0.CallbackURL = "https://" + com.Conf.Web.ServerName + "/x/callback"
-	pageData.Auth0.ClientID = com.Conf.Auth0.ClientID
-	pageData.Auth0.Domain = com.Conf.Auth0.Domain
+	pageData.Auth0.CallbackURL = "https://" + com.Conf().Web.ServerName + "/x/callback"
+	pageData.Auth0.ClientID = com.Conf().Auth0.ClientID
+	pageData.Auth0.Domain = com.Conf().Auth0.Domain
+
+	// Render the page
+	pageData.Meta.WebsiteName = com.Conf().Web.WebsiteName
+	pageData.Meta.Theme = requestTheme(r)
+	pageData.Meta.DateFormat, pageData.Meta.TimeZone = requestDatePrefs(r)
+	t := templates(requestLocale(r)).Lookup("confirmDeletePage")
+	err = t.Execute(w, pageData)
+	if err != nil {
+		com.Log.Errorf("Error: %s", err)
+	}
+}
+
+// Confirms or cancels a change of email address, from the links emailed to the old and new addresses.  Following the
+// link just shows what the change is, so it's only carried out when the user presses the button for it.  Cancelling
+// from the old address also logs out all of the account's sessions, in case someone else is using one.
+func confirmEmailPage(w http.ResponseWriter, r *http.Request) {
+	var pageData struct {
+		Auth0  com.Auth0Set
+		Change com.EmailChange
+		ForOld bool
+		Meta   com.MetaInfo
+		Result string
+		Token  string
+	}
+	pageData.Meta.Title = "Confirm email address"
+
+	token := r.FormValue("token")
+	change, forOld, found, err := com.EmailChangeFromToken(token)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Error when looking up the email address change")
+		return
+	}
+	if !found {
+		errorPage(w, r, http.StatusBadRequest, "That confirmation link isn't valid, or has expired")
+		return
+	}
+	pageData.Change, pageData.ForOld, pageData.Token = change, forOld, token
+
+	if r.Method == http.MethodPost {
+		switch r.PostFormValue("action") {
+		case "confirm":
+			changed, err := com.ConfirmEmailChange(token)
+			if err != nil {
+				errorPage(w, r, http.StatusBadRequest, err.Error())
+				return
+			}
+			pageData.Result = "confirmed"
+			if changed {
+				pageData.Result = "changed"
+				com.Log.Infof("Email address for user '%s' changed to '%s'", change.UserName, change.NewEmail)
+			}
+		case "cancel":
+			err = com.CancelEmailChange(change.UserName)
+			if err != nil {
+				errorPage(w, r, http.StatusInternalServerError, "Cancelling the email address change failed")
+				return
+			}
+			if forOld {
+				err = com.RevokeUserSessions(change.UserName)
+				if err != nil {
+					errorPage(w, r, http.StatusInternalServerError, "The change was cancelled, but logging out "+
+						"the account's sessions failed")
+					return
+				}
+			}
+			pageData.Result = "cancelled"
+		default:
+			errorPage(w, r, http.StatusBadRequest, "Unknown action")
+			return
+		}
+	}
+
+	// Retrieve the details and status updates count for the logged in user
+	loggedInUser := sessionUser(r)
+	pageData.Meta.LoggedInUser = loggedInUser
+	if loggedInUser != "" {
+		ur, err := com.User(loggedInUser)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if ur.AvatarURL != "" {
+			pageData.Meta.AvatarURL = ur.AvatarURL + "&s=48"
+		}
+		pageData.Meta.NumStatusUpdates, err = com.UserStatusUpdates(loggedInUser)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	// Add Auth0 info to the page data
+	pageData.Auth0.CallbackURL = "https://" + com.Conf().Web.ServerName + "/x/callback"
+	pageData.Auth0.ClientID = com.Conf().Auth0.ClientID
+	pageData.Auth0.Domain = com.Conf().Auth0.Domain
 
 	// Render the page
-	pageData.Meta.WebsiteName = com.Conf.Web.WebsiteName
-	t := tmpl.Lookup("confirmDeletePage")
+	pageData.Meta.WebsiteName = com.Conf().Web.WebsiteName
+	pageData.Meta.Theme = requestTheme(r)
+	pageData.Meta.DateFormat, pageData.Meta.TimeZone = requestDatePrefs(r)
+	t := templates(requestLocale(r)).Lookup("confirmEmailPage")
 	err = t.Execute(w, pageData)
 	if err != nil {
-		log.Printf("Error: %s", err)
+		com.Log.Errorf("Error: %s", err)
 	}
 }
 
@@ -804,23 +1307,8 @@ func contentPage(w http.ResponseWriter, r *http.Request, owner string, folder st
 		MyWatch bool
 	}
 
-	// Retrieve session data (if any)
-	var loggedInUser string
-	var u interface{}
-	if com.Conf.Environment.Environment != "docker" {
-		sess, err := store.Get(r, "3dhub-user")
-		if err != nil {
-			errorPage(w, r, http.StatusBadRequest, err.Error())
-			return
-		}
-		u = sess.Values["UserName"]
-	} else {
-		u = "default"
-	}
-	if u != nil {
-		loggedInUser = u.(string)
-		pageData.Meta.LoggedInUser = loggedInUser
-	}
+	loggedInUser := sessionUser(r)
+	pageData.Meta.LoggedInUser = loggedInUser
 
 	// Check if the requested content exists and the user has access to view it
 	exists, err := com.CheckFileExists(loggedInUser, owner, folder, fileName)
@@ -829,6 +1317,14 @@ func contentPage(w http.ResponseWriter, r *http.Request, owner string, folder st
 		return
 	}
 	if !exists {
+		// If the project has moved (eg been transferred to another user), redirect to its new location
+		if u, ok := movedProjectURL(loggedInUser, owner, folder, fileName); ok {
+			if r.URL.RawQuery != "" {
+				u += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, u, http.StatusMovedPermanently)
+			return
+		}
 		errorPage(w, r, http.StatusNotFound, fmt.Sprintf("File '%s%s%s' doesn't exist", owner, folder,
 			fileName))
 		return
@@ -904,23 +1400,8 @@ func contributorsPage(w http.ResponseWriter, r *http.Request) {
 	}
 	pageData.Meta.Title = "Branch list"
 
-	// Retrieve session data (if any)
-	var loggedInUser string
-	var u interface{}
-	if com.Conf.Environment.Environment != "docker" {
-		sess, err := store.Get(r, "3dhub-user")
-		if err != nil {
-			errorPage(w, r, http.StatusBadRequest, err.Error())
-			return
-		}
-		u = sess.Values["UserName"]
-	} else {
-		u = "default"
-	}
-	if u != nil {
-		loggedInUser = u.(string)
-		pageData.Meta.LoggedInUser = loggedInUser
-	}
+	loggedInUser := sessionUser(r)
+	pageData.Meta.LoggedInUser = loggedInUser
 
 	// Retrieve the database owner & name
 	// TODO: Add folder and branch support
@@ -1029,16 +1510,18 @@ func contributorsPage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Add Auth0 info to the page data
-	pageData.Auth0.CallbackURL = "https://" + com.Conf.Web.ServerName + "/x/callback"
-	pageData.Auth0.ClientID = com.Conf.Auth0.ClientID
-	pageData.Auth0.Domain = com.Conf.Auth0.Domain
+	pageData.Auth0.CallbackURL = "https://" + com.Conf().Web.ServerName + "/x/callback"
+	pageData.Auth0.ClientID = com.Conf().Auth0.ClientID
+	pageData.Auth0.Domain = com.Conf().Auth0.Domain
 
 	// Render the page
-	pageData.Meta.WebsiteName = com.Conf.Web.WebsiteName
-	t := tmpl.Lookup("contributorsPage")
+	pageData.Meta.WebsiteName = com.Conf().Web.WebsiteName
+	pageData.Meta.Theme = requestTheme(r)
+	pageData.Meta.DateFormat, pageData.Meta.TimeZone = requestDatePrefs(r)
+	t := templates(requestLocale(r)).Lookup("contributorsPage")
 	err = t.Execute(w, pageData)
 	if err != nil {
-		log.Printf("Error: %s", err)
+		com.Log.Errorf("Error: %s", err)
 	}
 }
 
@@ -1051,28 +1534,11 @@ func createBranchPage(w http.ResponseWriter, r *http.Request) {
 	}
 	pageData.Meta.Title = "Create new branch"
 
-	// Retrieve session data (if any)
-	var loggedInUser string
-	var u interface{}
-	validSession := false
-	if com.Conf.Environment.Environment != "docker" {
-		sess, err := store.Get(r, "3dhub-user")
-		if err != nil {
-			errorPage(w, r, http.StatusBadRequest, err.Error())
-			return
-		}
-		u = sess.Values["UserName"]
-	} else {
-		u = "default"
-	}
-	if u != nil {
-		loggedInUser = u.(string)
-		pageData.Meta.LoggedInUser = loggedInUser
-		validSession = true
-	}
+	loggedInUser := sessionUser(r)
+	pageData.Meta.LoggedInUser = loggedInUser
 
 	// Ensure we have a valid logged in user
-	if validSession != true {
+	if loggedInUser == "" {
 		errorPage(w, r, http.StatusUnauthorized, "You need to be logged in")
 		return
 	}
@@ -1132,16 +1598,18 @@ func createBranchPage(w http.ResponseWriter, r *http.Request) {
 	pageData.Commit = commit
 
 	// Add Auth0 info to the page data
-	pageData.Auth0.CallbackURL = "https://" + com.Conf.Web.ServerName + "/x/callback"
-	pageData.Auth0.ClientID = com.Conf.Auth0.ClientID
-	pageData.Auth0.Domain = com.Conf.Auth0.Domain
+	pageData.Auth0.CallbackURL = "https://" + com.Conf().Web.ServerName + "/x/callback"
+	pageData.Auth0.ClientID = com.Conf().Auth0.ClientID
+	pageData.Auth0.Domain = com.Conf().Auth0.Domain
 
 	// Render the page
-	pageData.Meta.WebsiteName = com.Conf.Web.WebsiteName
-	t := tmpl.Lookup("createBranchPage")
+	pageData.Meta.WebsiteName = com.Conf().Web.WebsiteName
+	pageData.Meta.Theme = requestTheme(r)
+	pageData.Meta.DateFormat, pageData.Meta.TimeZone = requestDatePrefs(r)
+	t := templates(requestLocale(r)).Lookup("createBranchPage")
 	err = t.Execute(w, pageData)
 	if err != nil {
-		log.Printf("Error: %s", err)
+		com.Log.Errorf("Error: %s", err)
 	}
 }
 
@@ -1153,28 +1621,11 @@ func createDiscussionPage(w http.ResponseWriter, r *http.Request) {
 	}
 	pageData.Meta.Title = "Create new discussion"
 
-	// Retrieve session data (if any)
-	var loggedInUser string
-	var u interface{}
-	validSession := false
-	if com.Conf.Environment.Environment != "docker" {
-		sess, err := store.Get(r, "3dhub-user")
-		if err != nil {
-			errorPage(w, r, http.StatusBadRequest, err.Error())
-			return
-		}
-		u = sess.Values["UserName"]
-	} else {
-		u = "default"
-	}
-	if u != nil {
-		loggedInUser = u.(string)
-		pageData.Meta.LoggedInUser = loggedInUser
-		validSession = true
-	}
+	loggedInUser := sessionUser(r)
+	pageData.Meta.LoggedInUser = loggedInUser
 
 	// Ensure we have a valid logged in user
-	if validSession != true {
+	if loggedInUser == "" {
 		errorPage(w, r, http.StatusUnauthorized, "You need to be logged in")
 		return
 	}
@@ -1229,16 +1680,18 @@ func createDiscussionPage(w http.ResponseWriter, r *http.Request) {
 	pageData.Meta.Database = fileName
 
 	// Add Auth0 info to the page data
-	pageData.Auth0.CallbackURL = "https://" + com.Conf.Web.ServerName + "/x/callback"
-	pageData.Auth0.ClientID = com.Conf.Auth0.ClientID
-	pageData.Auth0.Domain = com.Conf.Auth0.Domain
+	pageData.Auth0.CallbackURL = "https://" + com.Conf().Web.ServerName + "/x/callback"
+	pageData.Auth0.ClientID = com.Conf().Auth0.ClientID
+	pageData.Auth0.Domain = com.Conf().Auth0.Domain
 
 	// Render the page
-	pageData.Meta.WebsiteName = com.Conf.Web.WebsiteName
-	t := tmpl.Lookup("createDiscussionPage")
+	pageData.Meta.WebsiteName = com.Conf().Web.WebsiteName
+	pageData.Meta.Theme = requestTheme(r)
+	pageData.Meta.DateFormat, pageData.Meta.TimeZone = requestDatePrefs(r)
+	t := templates(requestLocale(r)).Lookup("createDiscussionPage")
 	err = t.Execute(w, pageData)
 	if err != nil {
-		log.Printf("Error: %s", err)
+		com.Log.Errorf("Error: %s", err)
 	}
 }
 
@@ -1251,28 +1704,11 @@ func createTagPage(w http.ResponseWriter, r *http.Request) {
 	}
 	pageData.Meta.Title = "Create new tag"
 
-	// Retrieve session data (if any)
-	var loggedInUser string
-	var u interface{}
-	validSession := false
-	if com.Conf.Environment.Environment != "docker" {
-		sess, err := store.Get(r, "3dhub-user")
-		if err != nil {
-			errorPage(w, r, http.StatusBadRequest, err.Error())
-			return
-		}
-		u = sess.Values["UserName"]
-	} else {
-		u = "default"
-	}
-	if u != nil {
-		loggedInUser = u.(string)
-		pageData.Meta.LoggedInUser = loggedInUser
-		validSession = true
-	}
+	loggedInUser := sessionUser(r)
+	pageData.Meta.LoggedInUser = loggedInUser
 
 	// Ensure we have a valid logged in user
-	if validSession != true {
+	if loggedInUser == "" {
 		errorPage(w, r, http.StatusUnauthorized, "You need to be logged in")
 		return
 	}
@@ -1332,16 +1768,18 @@ func createTagPage(w http.ResponseWriter, r *http.Request) {
 	pageData.Commit = commit
 
 	// Add Auth0 info to the page data
-	pageData.Auth0.CallbackURL = "https://" + com.Conf.Web.ServerName + "/x/callback"
-	pageData.Auth0.ClientID = com.Conf.Auth0.ClientID
-	pageData.Auth0.Domain = com.Conf.Auth0.Domain
+	pageData.Auth0.CallbackURL = "https://" + com.Conf().Web.ServerName + "/x/callback"
+	pageData.Auth0.ClientID = com.Conf().Auth0.ClientID
+	pageData.Auth0.Domain = com.Conf().Auth0.Domain
 
 	// Render the page
-	pageData.Meta.WebsiteName = com.Conf.Web.WebsiteName
-	t := tmpl.Lookup("createTagPage")
+	pageData.Meta.WebsiteName = com.Conf().Web.WebsiteName
+	pageData.Meta.Theme = requestTheme(r)
+	pageData.Meta.DateFormat, pageData.Meta.TimeZone = requestDatePrefs(r)
+	t := templates(requestLocale(r)).Lookup("createTagPage")
 	err = t.Execute(w, pageData)
 	if err != nil {
-		log.Printf("Error: %s", err)
+		com.Log.Errorf("Error: %s", err)
 	}
 }
 
@@ -1350,23 +1788,33 @@ func databasePage(w http.ResponseWriter, r *http.Request, loggedInUser string, o
 	pageName := "Display database page"
 
 	var pageData struct {
-		Auth0   com.Auth0Set
-		Data    com.SQLiteRecordSet
-		DB      com.SQLiteDBinfo
-		Meta    com.MetaInfo
-		MyStar  bool
-		MyWatch bool
+		Auth0         com.Auth0Set
+		Data          com.SQLiteRecordSet
+		DB            com.SQLiteDBinfo
+		DescLanguage  string
+		DescLanguages []localeInfo
+		Meta          com.MetaInfo
+		MyStar        bool
+		MyWatch       bool
+		Tips          []com.TipLink
 	}
 	pageData.Meta.LoggedInUser = loggedInUser
 
+	// Retrieve the tip links of the project owner
+	tips, err := com.UserTipLinks(owner)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	pageData.Tips = com.VisibleTipLinks(tips)
+
 	// If a table name was supplied, validate it
-	var err error
 	dbTable := r.FormValue("table")
 	if dbTable != "" {
 		err = com.ValidatePGTable(dbTable)
 		if err != nil {
 			// Validation failed, so don't pass on the table name
-			log.Printf("%s: Validation failed for table name: %s", pageName, err)
+			com.Log.Errorf("%s: Validation failed for table name: %s", pageName, err)
 			dbTable = ""
 		}
 	}
@@ -1397,7 +1845,7 @@ func databasePage(w http.ResponseWriter, r *http.Request, loggedInUser string, o
 		// careful than usual
 		err = com.ValidateFieldName(sortCol)
 		if err != nil {
-			log.Printf("Validation failed on requested sort field name '%v': %v\n", sortCol,
+			com.Log.Errorf("Validation failed on requested sort field name '%v': %v", sortCol,
 				err.Error())
 			errorPage(w, r, http.StatusBadRequest, "Validation failed on requested sort field name")
 			return
@@ -1419,6 +1867,7 @@ func databasePage(w http.ResponseWriter, r *http.Request, loggedInUser string, o
 			errorPage(w, r, http.StatusInternalServerError, err.Error())
 			return
 		}
+		com.RecordProjectView(owner, folder, fileName, r.Referer())
 	}
 
 	// If a specific commit was requested, make sure it exists in the database commit history
@@ -1581,13 +2030,13 @@ func databasePage(w http.ResponseWriter, r *http.Request, loggedInUser string, o
 	// If a cached version of the page data exists, use it
 	ok, err := com.GetCachedData(mdataCacheKey, &pageData)
 	if err != nil {
-		log.Printf("%s: Error retrieving page data from cache: %v\n", pageName, err)
+		com.Log.Errorf("%s: Error retrieving page data from cache: %v", pageName, err)
 	}
 	if ok {
 		// Grab the cached table data as well
 		ok, err := com.GetCachedData(rowCacheKey, &pageData.Data)
 		if err != nil {
-			log.Printf("%s: Error retrieving page data from cache: %v\n", pageName, err)
+			com.Log.Errorf("%s: Error retrieving page data from cache: %v", pageName, err)
 		}
 
 		// Restore the correct MaxRow value
@@ -1629,7 +2078,7 @@ func databasePage(w http.ResponseWriter, r *http.Request, loggedInUser string, o
 				bCheck[j] = struct{}{}
 			} else {
 				// This branch name is already in the map.  Duplicate detected.  This shouldn't happen
-				log.Printf("Duplicate branch name '%s' detected in returned branch list for database '%s%s%s', "+
+				com.Log.Warnf("Duplicate branch name '%s' detected in returned branch list for database '%s%s%s', "+
 					"logged in user '%s'", j, owner, folder, fileName, loggedInUser)
 			}
 		}
@@ -1666,11 +2115,21 @@ func databasePage(w http.ResponseWriter, r *http.Request, loggedInUser string, o
 
 		// Render the page (using the caches)
 		if ok {
-			pageData.Meta.WebsiteName = com.Conf.Web.WebsiteName
-			t := tmpl.Lookup("databasePage")
+			// Show the descriptions in the visitor's language, if they've been translated into it
+			pageData.DescLanguages, pageData.DescLanguage, err = translateDescriptions(r, owner, folder, fileName,
+				&pageData.DB.Info)
+			if err != nil {
+				errorPage(w, r, http.StatusInternalServerError, err.Error())
+				return
+			}
+
+			pageData.Meta.WebsiteName = com.Conf().Web.WebsiteName
+			pageData.Meta.Theme = requestTheme(r)
+			pageData.Meta.DateFormat, pageData.Meta.TimeZone = requestDatePrefs(r)
+			t := templates(requestLocale(r)).Lookup("databasePage")
 			err = t.Execute(w, pageData)
 			if err != nil {
-				log.Printf("Error: %s", err)
+				com.Log.Errorf("Error: %s", err)
 			}
 			return
 		}
@@ -1741,7 +2200,7 @@ func databasePage(w http.ResponseWriter, r *http.Request, loggedInUser string, o
 	if sortCol != "" {
 		colList, err := sdb.Columns("", dbTable)
 		if err != nil {
-			log.Printf("Error when reading column names for table '%s': %v\n", dbTable,
+			com.Log.Errorf("Error when reading column names for table '%s': %v", dbTable,
 				err.Error())
 			errorPage(w, r, http.StatusInternalServerError, "Error when reading from the database")
 			return
@@ -1767,7 +2226,7 @@ func databasePage(w http.ResponseWriter, r *http.Request, loggedInUser string, o
 			// If the failed table name is "{{ db.Tablename }}", don't bother logging it.  It's just a search
 			// bot picking up AngularJS in a string and doing a request with it
 			if dbTable != "{{ db.Tablename }}" {
-				log.Printf("%s: Validation failed for table name: '%s': %s", pageName, dbTable, err)
+				com.Log.Errorf("%s: Validation failed for table name: '%s': %s", pageName, dbTable, err)
 			}
 			errorPage(w, r, http.StatusBadRequest, "Validation failed for table name")
 			return
@@ -1796,7 +2255,7 @@ func databasePage(w http.ResponseWriter, r *http.Request, loggedInUser string, o
 
 	// Fill out various metadata fields
 	pageData.Meta.Database = fileName
-	pageData.Meta.Server = com.Conf.Web.ServerName
+	pageData.Meta.Server = com.Conf().Web.ServerName
 	pageData.Meta.Title = fmt.Sprintf("%s %s %s", owner, folder, fileName)
 
 	// Retrieve default branch name details
@@ -1832,7 +2291,7 @@ func databasePage(w http.ResponseWriter, r *http.Request, loggedInUser string, o
 			bCheck[j] = struct{}{}
 		} else {
 			// This branch name is already in the map.  Duplicate detected.  This shouldn't happen
-			log.Printf("Duplicate branch name '%s' detected in returned branch list for database '%s%s%s', "+
+			com.Log.Warnf("Duplicate branch name '%s' detected in returned branch list for database '%s%s%s', "+
 				"logged in user '%s'", j, owner, folder, fileName, loggedInUser)
 		}
 	}
@@ -1852,9 +2311,9 @@ func databasePage(w http.ResponseWriter, r *http.Request, loggedInUser string, o
 	pageData.Meta.ForkDeleted = frkDel
 
 	// Add Auth0 info to the page data
-	pageData.Auth0.CallbackURL = "https://" + com.Conf.Web.ServerName + "/x/callback"
-	pageData.Auth0.ClientID = com.Conf.Auth0.ClientID
-	pageData.Auth0.Domain = com.Conf.Auth0.Domain
+	pageData.Auth0.CallbackURL = "https://" + com.Conf().Web.ServerName + "/x/callback"
+	pageData.Auth0.ClientID = com.Conf().Auth0.ClientID
+	pageData.Auth0.Domain = com.Conf().Auth0.Domain
 
 	// Update database star and watch status for the logged in user
 	pageData.MyStar = myStar
@@ -1863,20 +2322,30 @@ func databasePage(w http.ResponseWriter, r *http.Request, loggedInUser string, o
 	// Render the full description as markdown
 	pageData.DB.Info.FullDesc = string(gfm.Markdown([]byte(pageData.DB.Info.FullDesc)))
 
+	// Render the README as markdown too.  The renderer sanitises its output, so it's safe to include in the page
+	readme, err := com.ProjectReadme(owner, folder, fileName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Couldn't retrieve the README")
+		return
+	}
+	if readme != "" {
+		pageData.DB.Info.Readme = string(gfm.Markdown([]byte(readme)))
+	}
+
 	// Restore the correct discussion and MR count
 	pageData.DB.Info.Discussions = currentDisc
 	pageData.DB.Info.MRs = currentMRs
 
 	// Cache the page metadata
-	err = com.CacheData(mdataCacheKey, pageData, com.Conf.Memcache.DefaultCacheTime)
+	err = com.CacheData(mdataCacheKey, pageData, com.Conf().Memcache.DefaultCacheTime)
 	if err != nil {
-		log.Printf("%s: Error when caching page data: %v\n", pageName, err)
+		com.Log.Errorf("%s: Error when caching page data: %v", pageName, err)
 	}
 
 	// Grab the cached table data if it's available
 	ok, err = com.GetCachedData(rowCacheKey, &pageData.Data)
 	if err != nil {
-		log.Printf("%s: Error retrieving page data from cache: %v\n", pageName, err)
+		com.Log.Errorf("%s: Error retrieving page data from cache: %v", pageName, err)
 	}
 
 	// If the row data wasn't in cache, read it from the database
@@ -1891,17 +2360,27 @@ func databasePage(w http.ResponseWriter, r *http.Request, loggedInUser string, o
 	}
 
 	// Cache the table row data
-	err = com.CacheData(rowCacheKey, pageData.Data, com.Conf.Memcache.DefaultCacheTime)
+	err = com.CacheData(rowCacheKey, pageData.Data, com.Conf().Memcache.DefaultCacheTime)
 	if err != nil {
-		log.Printf("%s: Error when caching page data: %v\n", pageName, err)
+		com.Log.Errorf("%s: Error when caching page data: %v", pageName, err)
+	}
+
+	// Show the descriptions in the visitor's language, if they've been translated into it
+	pageData.DescLanguages, pageData.DescLanguage, err = translateDescriptions(r, owner, folder, fileName,
+		&pageData.DB.Info)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
 	}
 
 	// Render the page
-	pageData.Meta.WebsiteName = com.Conf.Web.WebsiteName
-	t := tmpl.Lookup("databasePage")
+	pageData.Meta.WebsiteName = com.Conf().Web.WebsiteName
+	pageData.Meta.Theme = requestTheme(r)
+	pageData.Meta.DateFormat, pageData.Meta.TimeZone = requestDatePrefs(r)
+	t := templates(requestLocale(r)).Lookup("databasePage")
 	err = t.Execute(w, pageData)
 	if err != nil {
-		log.Printf("Error: %s", err)
+		com.Log.Errorf("Error: %s", err)
 	}
 }
 
@@ -1917,23 +2396,8 @@ func discussPage(w http.ResponseWriter, r *http.Request) {
 		MyWatch        bool
 	}
 
-	// Retrieve session data (if any)
-	var loggedInUser string
-	var u interface{}
-	if com.Conf.Environment.Environment != "docker" {
-		sess, err := store.Get(r, "3dhub-user")
-		if err != nil {
-			errorPage(w, r, http.StatusBadRequest, err.Error())
-			return
-		}
-		u = sess.Values["UserName"]
-	} else {
-		u = "default"
-	}
-	if u != nil {
-		loggedInUser = u.(string)
-		pageData.Meta.LoggedInUser = loggedInUser
-	}
+	loggedInUser := sessionUser(r)
+	pageData.Meta.LoggedInUser = loggedInUser
 
 	// Retrieve the database owner & name
 	// TODO: Add folder support
@@ -1949,7 +2413,7 @@ func discussPage(w http.ResponseWriter, r *http.Request) {
 	if a != "" && a != "{{ row.disc_id }}" { // Search engines have a habit of passing AngularJS tags, so we ignore when the field has the AngularJS tag in it
 		pageData.SelectedID, err = strconv.Atoi(a)
 		if err != nil {
-			log.Printf("Error converting string '%s' to integer in function '%s': %s\n", a,
+			com.Log.Errorf("Error converting string '%s' to integer in function '%s': %s", a,
 				com.GetCurrentFunctionName(), err)
 			errorPage(w, r, http.StatusBadRequest, "Error when parsing discussion id value")
 			return
@@ -2057,9 +2521,9 @@ func discussPage(w http.ResponseWriter, r *http.Request) {
 	pageData.Meta.Title = "Discussion List"
 
 	// Add Auth0 info to the page data
-	pageData.Auth0.CallbackURL = "https://" + com.Conf.Web.ServerName + "/x/callback"
-	pageData.Auth0.ClientID = com.Conf.Auth0.ClientID
-	pageData.Auth0.Domain = com.Conf.Auth0.Domain
+	pageData.Auth0.CallbackURL = "https://" + com.Conf().Web.ServerName + "/x/callback"
+	pageData.Auth0.ClientID = com.Conf().Auth0.ClientID
+	pageData.Auth0.Domain = com.Conf().Auth0.Domain
 
 	// If a specific discussion ID was given, then we display the discussion comments page
 	if pageData.SelectedID != 0 {
@@ -2093,52 +2557,51 @@ func discussPage(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// Render the discussion comments page
-		pageData.Meta.WebsiteName = com.Conf.Web.WebsiteName
-		t := tmpl.Lookup("discussCommentsPage")
+		pageData.Meta.WebsiteName = com.Conf().Web.WebsiteName
+		pageData.Meta.Theme = requestTheme(r)
+		pageData.Meta.DateFormat, pageData.Meta.TimeZone = requestDatePrefs(r)
+		t := templates(requestLocale(r)).Lookup("discussCommentsPage")
 		err = t.Execute(w, pageData)
 		if err != nil {
-			log.Printf("Error: %s", err)
+			com.Log.Errorf("Error: %s", err)
 		}
 		return
 	}
 
 	// Render the main discussion list page
-	pageData.Meta.WebsiteName = com.Conf.Web.WebsiteName
-	t := tmpl.Lookup("discussListPage")
+	pageData.Meta.WebsiteName = com.Conf().Web.WebsiteName
+	pageData.Meta.Theme = requestTheme(r)
+	pageData.Meta.DateFormat, pageData.Meta.TimeZone = requestDatePrefs(r)
+	t := templates(requestLocale(r)).Lookup("discussListPage")
 	err = t.Execute(w, pageData)
 	if err != nil {
-		log.Printf("Error: %s", err)
+		com.Log.Errorf("Error: %s", err)
 	}
 }
 
 // General error display page.
 func errorPage(w http.ResponseWriter, r *http.Request, httpCode int, msg string) {
 	var pageData struct {
-		Auth0   com.Auth0Set
-		Message string
-		Meta    com.MetaInfo
+		Auth0     com.Auth0Set
+		Message   string
+		Meta      com.MetaInfo
+		RequestID string
 	}
-	pageData.Message = msg
+	pageData.Message = translate(requestLocale(r), msg)
 	pageData.Meta.Title = "Error"
+	pageData.RequestID = com.RequestID(r)
 
-	// Retrieve session data (if any)
-	var loggedInUser string
-	var u interface{}
-	if com.Conf.Environment.Environment != "docker" {
-		sess, err := store.Get(r, "3dhub-user")
-		if err != nil {
-			fmt.Fprintf(w, "An error occurred when calling errorPage(): %s", err.Error())
-			return
-		}
-		u = sess.Values["UserName"]
+	// Log the error along with the request ID shown on the page, so reports from users can be tracked down
+	entry := com.Log.WithField("request_id", pageData.RequestID).WithField("status", httpCode)
+	if httpCode >= http.StatusInternalServerError {
+		entry.Warn(msg)
 	} else {
-		u = "default"
-	}
-	if u != nil {
-		loggedInUser = u.(string)
-		pageData.Meta.LoggedInUser = loggedInUser
+		entry.Info(msg)
 	}
 
+	loggedInUser := sessionUser(r)
+	pageData.Meta.LoggedInUser = loggedInUser
+
 	// Retrieve the details and status updates count for the logged in user
 	if loggedInUser != "" {
 		ur, err := com.User(loggedInUser)
@@ -2157,17 +2620,19 @@ func errorPage(w http.ResponseWriter, r *http.Request, httpCode int, msg string)
 	}
 
 	// Add Auth0 info to the page data
-	pageData.Auth0.CallbackURL = "https://" + com.Conf.Web.ServerName + "/x/callback"
-	pageData.Auth0.ClientID = com.Conf.Auth0.ClientID
-	pageData.Auth0.Domain = com.Conf.Auth0.Domain
+	pageData.Auth0.CallbackURL = "https://" + com.Conf().Web.ServerName + "/x/callback"
+	pageData.Auth0.ClientID = com.Conf().Auth0.ClientID
+	pageData.Auth0.Domain = com.Conf().Auth0.Domain
 
 	// Render the page
 	w.WriteHeader(httpCode)
-	pageData.Meta.WebsiteName = com.Conf.Web.WebsiteName
-	t := tmpl.Lookup("errorPage")
+	pageData.Meta.WebsiteName = com.Conf().Web.WebsiteName
+	pageData.Meta.Theme = requestTheme(r)
+	pageData.Meta.DateFormat, pageData.Meta.TimeZone = requestDatePrefs(r)
+	t := templates(requestLocale(r)).Lookup("errorPage")
 	err := t.Execute(w, pageData)
 	if err != nil {
-		log.Printf("Error: %s", err)
+		com.Log.Errorf("Error: %s", err)
 	}
 }
 
@@ -2189,23 +2654,8 @@ func forksPage(w http.ResponseWriter, r *http.Request) {
 	pageData.Meta.Database = fileName
 	folder := "/"
 
-	// Retrieve session data (if any)
-	var loggedInUser string
-	var u interface{}
-	if com.Conf.Environment.Environment != "docker" {
-		sess, err := store.Get(r, "3dhub-user")
-		if err != nil {
-			errorPage(w, r, http.StatusBadRequest, err.Error())
-			return
-		}
-		u = sess.Values["UserName"]
-	} else {
-		u = "default"
-	}
-	if u != nil {
-		loggedInUser = u.(string)
-		pageData.Meta.LoggedInUser = loggedInUser
-	}
+	loggedInUser := sessionUser(r)
+	pageData.Meta.LoggedInUser = loggedInUser
 
 	// Check if the database exists
 	exists, err := com.CheckFileExists(loggedInUser, owner, folder, fileName)
@@ -2253,16 +2703,18 @@ func forksPage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Add Auth0 info to the page data
-	pageData.Auth0.CallbackURL = "https://" + com.Conf.Web.ServerName + "/x/callback"
-	pageData.Auth0.ClientID = com.Conf.Auth0.ClientID
-	pageData.Auth0.Domain = com.Conf.Auth0.Domain
+	pageData.Auth0.CallbackURL = "https://" + com.Conf().Web.ServerName + "/x/callback"
+	pageData.Auth0.ClientID = com.Conf().Auth0.ClientID
+	pageData.Auth0.Domain = com.Conf().Auth0.Domain
 
 	// Render the page
-	pageData.Meta.WebsiteName = com.Conf.Web.WebsiteName
-	t := tmpl.Lookup("forksPage")
+	pageData.Meta.WebsiteName = com.Conf().Web.WebsiteName
+	pageData.Meta.Theme = requestTheme(r)
+	pageData.Meta.DateFormat, pageData.Meta.TimeZone = requestDatePrefs(r)
+	t := templates(requestLocale(r)).Lookup("forksPage")
 	err = t.Execute(w, pageData)
 	if err != nil {
-		log.Printf("Error: %s", err)
+		com.Log.Errorf("Error: %s", err)
 	}
 }
 
@@ -2275,40 +2727,236 @@ func frontPage(w http.ResponseWriter, r *http.Request) {
 		Stats map[com.ActivityRange]com.ActivityStats
 	}
 
-	// Retrieve session data (if any)
-	var loggedInUser string
-	var u interface{}
-	if com.Conf.Environment.Environment != "docker" {
-		sess, err := store.Get(r, "3dhub-user")
+	loggedInUser := sessionUser(r)
+	pageData.Meta.LoggedInUser = loggedInUser
+
+	// Retrieve the database activity stats, along with the details and status updates count for the logged in user.
+	// They're independent of each other, so are retrieved at the same time
+	var g errgroup.Group
+	var statsAll com.ActivityStats
+	g.Go(func() (err error) {
+		statsAll, err = com.GetActivityStats()
+		return
+	})
+	if loggedInUser != "" {
+		g.Go(func() error {
+			ur, err := com.User(loggedInUser)
+			if err != nil {
+				return err
+			}
+			if ur.AvatarURL != "" {
+				pageData.Meta.AvatarURL = ur.AvatarURL + "&s=48"
+			}
+			return nil
+		})
+		g.Go(func() (err error) {
+			pageData.Meta.NumStatusUpdates, err = com.UserStatusUpdates(loggedInUser)
+			return
+		})
+	}
+	err := g.Wait()
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	pageData.Stats = make(map[com.ActivityRange]com.ActivityStats)
+	pageData.Stats[com.ALL_TIME] = statsAll
+
+	// Set other relevant metadata
+	pageData.Meta.FeedURL = "/feeds/recent"
+	pageData.Meta.Title = `SQLite storage "in the cloud"`
+
+	// Add Auth0 info to the page data
+	pageData.Auth0.CallbackURL = "https://" + com.Conf().Web.ServerName + "/x/callback"
+	pageData.Auth0.ClientID = com.Conf().Auth0.ClientID
+	pageData.Auth0.Domain = com.Conf().Auth0.Domain
+
+	// Render the page
+	pageData.Meta.WebsiteName = com.Conf().Web.WebsiteName
+	pageData.Meta.Theme = requestTheme(r)
+	pageData.Meta.DateFormat, pageData.Meta.TimeZone = requestDatePrefs(r)
+	t := templates(requestLocale(r)).Lookup("rootPage")
+	err = t.Execute(w, pageData)
+	if err != nil {
+		com.Log.Errorf("Error: %s", err)
+	}
+}
+
+// Shows the form for sending a file to a project through a guest upload link.  No account is needed.
+func guestUploadPage(w http.ResponseWriter, r *http.Request) {
+	var pageData struct {
+		Auth0    com.Auth0Set
+		FileName string
+		Folder   string
+		MaxSize  int64
+		Meta     com.MetaInfo
+		Owner    string
+		Sent     bool
+		Token    string
+	}
+	pageData.Meta.Title = "Send a file"
+
+	pageData.Token = strings.TrimPrefix(r.URL.Path, "/guestupload/")
+	owner, folder, fileName, found, err := com.GuestUploadLinkProject(pageData.Token)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !found {
+		errorPage(w, r, http.StatusNotFound, "That upload link doesn't exist, or has expired")
+		return
+	}
+	pageData.Owner, pageData.Folder, pageData.FileName = owner, folder, fileName
+	pageData.MaxSize = com.MaxFileSize
+	pageData.Sent = r.FormValue("sent") == "1"
+
+	// Retrieve the details and status updates count for the logged in user
+	loggedInUser := sessionUser(r)
+	pageData.Meta.LoggedInUser = loggedInUser
+	if loggedInUser != "" {
+		ur, err := com.User(loggedInUser)
 		if err != nil {
-			errorPage(w, r, http.StatusBadRequest, err.Error())
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if ur.AvatarURL != "" {
+			pageData.Meta.AvatarURL = ur.AvatarURL + "&s=48"
+		}
+		pageData.Meta.NumStatusUpdates, err = com.UserStatusUpdates(loggedInUser)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
 			return
 		}
-		u = sess.Values["UserName"]
-	} else {
-		u = "default"
 	}
-	if u != nil {
-		loggedInUser = u.(string)
-		pageData.Meta.LoggedInUser = loggedInUser
+
+	// Add Auth0 info to the page data
+	pageData.Auth0.CallbackURL = "https://" + com.Conf().Web.ServerName + "/x/callback"
+	pageData.Auth0.ClientID = com.Conf().Auth0.ClientID
+	pageData.Auth0.Domain = com.Conf().Auth0.Domain
+
+	// Render the page
+	pageData.Meta.WebsiteName = com.Conf().Web.WebsiteName
+	pageData.Meta.Theme = requestTheme(r)
+	pageData.Meta.DateFormat, pageData.Meta.TimeZone = requestDatePrefs(r)
+	t := templates(requestLocale(r)).Lookup("guestUploadPage")
+	err = t.Execute(w, pageData)
+	if err != nil {
+		com.Log.Errorf("Error: %s", err)
 	}
+}
 
-	// Retrieve the database activity stats
-	pageData.Stats = make(map[com.ActivityRange]com.ActivityStats)
-	statsAll, err := com.GetActivityStats()
+// Shows the forms for importing things from Thingiverse and MyMiniFactory, and for importing GitHub repositories.
+func importPage(w http.ResponseWriter, r *http.Request) {
+	var pageData struct {
+		Auth0    com.Auth0Set
+		Licences map[string]com.LicenceEntry
+		Meta     com.MetaInfo
+	}
+
+	loggedInUser := sessionUser(r)
+	pageData.Meta.LoggedInUser = loggedInUser
+
+	// Ensure we have a valid logged in user
+	if loggedInUser == "" {
+		errorPage(w, r, http.StatusUnauthorized, "You need to be logged in")
+		return
+	}
+
+	// Ensure the user has set their display name and email address, as they're used for the commits
+	usr, err := com.User(loggedInUser)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Error when retrieving user details")
+		return
+	}
+	if usr.DisplayName == "" || usr.Email == "" {
+		errorPage(w, r, http.StatusBadRequest,
+			"You need to set your full name and email address in Preferences first")
+		return
+	}
+	if usr.AvatarURL != "" {
+		pageData.Meta.AvatarURL = usr.AvatarURL + "&s=48"
+	}
+
+	// Check if there are any status updates for the user
+	pageData.Meta.NumStatusUpdates, err = com.UserStatusUpdates(loggedInUser)
 	if err != nil {
 		errorPage(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
-	pageData.Stats[com.ALL_TIME] = statsAll
 
-	// Set other relevant metadata
-	pageData.Meta.Title = `SQLite storage "in the cloud"`
+	// Populate the licence list, for GitHub imports
+	pageData.Licences, err = com.GetLicences(loggedInUser)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Error when retrieving list of available licences")
+		return
+	}
+
+	// Fill out page metadata
+	pageData.Meta.Title = "Import projects"
 
 	// Add Auth0 info to the page data
-	pageData.Auth0.CallbackURL = "https://" + com.Conf.Web.ServerName + "/x/callback"
-	pageData.Auth0.ClientID = com.Conf.Auth0.ClientID
-	pageData.Auth0.Domain = com.Conf.Auth0.Domain
+	pageData.Auth0.CallbackURL = "https://" + com.Conf().Web.ServerName + "/x/callback"
+	pageData.Auth0.ClientID = com.Conf().Auth0.ClientID
+	pageData.Auth0.Domain = com.Conf().Auth0.Domain
+
+	// Render the page
+	pageData.Meta.WebsiteName = com.Conf().Web.WebsiteName
+	pageData.Meta.Theme = requestTheme(r)
+	pageData.Meta.DateFormat, pageData.Meta.TimeZone = requestDatePrefs(r)
+	t := templates(requestLocale(r)).Lookup("importPage")
+	err = t.Execute(w, pageData)
+	if err != nil {
+		com.Log.Errorf("Error: %s", err)
+	}
+}
+
+// Shows the licence compliance report for a project, listing everything in its remix chain along with any licence
+// problems found between them.
+func licenceReportPage(w http.ResponseWriter, r *http.Request) {
+	var pageData struct {
+		Auth0  com.Auth0Set
+		Meta   com.MetaInfo
+		Report com.LicenceReport
+	}
+	pageData.Meta.Title = "Licence report"
+
+	// Retrieve user and database name
+	owner, fileName, err := com.GetOD(1, r) // 1 = Ignore "/licences/" at the start of the URL
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	pageData.Meta.Database = fileName
+	folder := "/"
+
+	loggedInUser := sessionUser(r)
+	pageData.Meta.LoggedInUser = loggedInUser
+
+	// Check if the database exists
+	exists, err := com.CheckFileExists(loggedInUser, owner, folder, fileName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Database failure when looking up database details")
+		return
+	}
+	if !exists {
+		errorPage(w, r, http.StatusNotFound, "That database doesn't seem to exist")
+		return
+	}
+
+	// Build the report
+	pageData.Report, err = com.LicenceComplianceReport(loggedInUser, owner, folder, fileName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Retrieve correctly capitalised username for the database owner
+	usr, err := com.User(owner)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	pageData.Meta.Owner = usr.Username
 
 	// Retrieve the details and status updates count for the logged in user
 	if loggedInUser != "" {
@@ -2327,12 +2975,19 @@ func frontPage(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Add Auth0 info to the page data
+	pageData.Auth0.CallbackURL = "https://" + com.Conf().Web.ServerName + "/x/callback"
+	pageData.Auth0.ClientID = com.Conf().Auth0.ClientID
+	pageData.Auth0.Domain = com.Conf().Auth0.Domain
+
 	// Render the page
-	pageData.Meta.WebsiteName = com.Conf.Web.WebsiteName
-	t := tmpl.Lookup("rootPage")
+	pageData.Meta.WebsiteName = com.Conf().Web.WebsiteName
+	pageData.Meta.Theme = requestTheme(r)
+	pageData.Meta.DateFormat, pageData.Meta.TimeZone = requestDatePrefs(r)
+	t := templates(requestLocale(r)).Lookup("licenceReportPage")
 	err = t.Execute(w, pageData)
 	if err != nil {
-		log.Printf("Error: %s", err)
+		com.Log.Errorf("Error: %s", err)
 	}
 }
 
@@ -2356,23 +3011,8 @@ func mergePage(w http.ResponseWriter, r *http.Request) {
 		MyWatch             bool
 	}
 
-	// Retrieve session data (if any)
-	var loggedInUser string
-	var u interface{}
-	if com.Conf.Environment.Environment != "docker" {
-		sess, err := store.Get(r, "3dhub-user")
-		if err != nil {
-			errorPage(w, r, http.StatusBadRequest, err.Error())
-			return
-		}
-		u = sess.Values["UserName"]
-	} else {
-		u = "default"
-	}
-	if u != nil {
-		loggedInUser = u.(string)
-		pageData.Meta.LoggedInUser = loggedInUser
-	}
+	loggedInUser := sessionUser(r)
+	pageData.Meta.LoggedInUser = loggedInUser
 
 	// Retrieve the database owner & name
 	// TODO: Add folder support
@@ -2388,7 +3028,7 @@ func mergePage(w http.ResponseWriter, r *http.Request) {
 	if a != "" && a != "{{ row.disc_id }}" { // Search engines have a habit of passing AngularJS tags, so we ignore when the field has the AngularJS tag in it
 		pageData.SelectedID, err = strconv.Atoi(a)
 		if err != nil {
-			log.Printf("Error converting string '%s' to integer in function '%s': %s\n", a,
+			com.Log.Errorf("Error converting string '%s' to integer in function '%s': %s", a,
 				com.GetCurrentFunctionName(), err)
 			errorPage(w, r, http.StatusBadRequest, "Error when parsing discussion id value")
 			return
@@ -2499,9 +3139,9 @@ func mergePage(w http.ResponseWriter, r *http.Request) {
 	pageData.StatusMessageColour = "green"
 
 	// Add Auth0 info to the page data
-	pageData.Auth0.CallbackURL = "https://" + com.Conf.Web.ServerName + "/x/callback"
-	pageData.Auth0.ClientID = com.Conf.Auth0.ClientID
-	pageData.Auth0.Domain = com.Conf.Auth0.Domain
+	pageData.Auth0.CallbackURL = "https://" + com.Conf().Web.ServerName + "/x/callback"
+	pageData.Auth0.ClientID = com.Conf().Auth0.ClientID
+	pageData.Auth0.Domain = com.Conf().Auth0.Domain
 
 	// If a specific MR ID was given, then we display the MR comments page
 	if pageData.SelectedID != 0 {
@@ -2678,32 +3318,69 @@ func mergePage(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// Render the MR comments page
-		pageData.Meta.WebsiteName = com.Conf.Web.WebsiteName
-		t := tmpl.Lookup("mergeRequestCommentsPage")
+		pageData.Meta.WebsiteName = com.Conf().Web.WebsiteName
+		pageData.Meta.Theme = requestTheme(r)
+		pageData.Meta.DateFormat, pageData.Meta.TimeZone = requestDatePrefs(r)
+		t := templates(requestLocale(r)).Lookup("mergeRequestCommentsPage")
 		err = t.Execute(w, pageData)
 		if err != nil {
-			log.Printf("Error: %s", err)
+			com.Log.Errorf("Error: %s", err)
 		}
 		return
 	}
 
 	// Render the MR list page
-	pageData.Meta.WebsiteName = com.Conf.Web.WebsiteName
-	t := tmpl.Lookup("mergeRequestListPage")
+	pageData.Meta.WebsiteName = com.Conf().Web.WebsiteName
+	pageData.Meta.Theme = requestTheme(r)
+	pageData.Meta.DateFormat, pageData.Meta.TimeZone = requestDatePrefs(r)
+	t := templates(requestLocale(r)).Lookup("mergeRequestListPage")
 	err = t.Execute(w, pageData)
 	if err != nil {
-		log.Printf("Error: %s", err)
+		com.Log.Errorf("Error: %s", err)
 	}
 }
 
 // Renders the user Preferences page.
 func prefPage(w http.ResponseWriter, r *http.Request, loggedInUser string) {
 	var pageData struct {
-		Auth0       com.Auth0Set
-		DisplayName string
-		Email       string
-		MaxRows     int
-		Meta        com.MetaInfo
+		Auth0   com.Auth0Set
+		Billing struct {
+			Enabled      bool
+			FreeLimit    int
+			Plan         string
+			Plans        []com.BillingPlan
+			PortalURL    string
+			PrivateLimit int
+			PrivateUsed  int
+		}
+		Categories     []com.Category
+		DateFormat     string
+		DateFormats    []com.DateFormat
+		DisplayName    string
+		Email          string
+		EmailChange    com.EmailChange
+		EmailFrequency com.EmailFrequency
+		EmailPending   bool
+		Exports        []com.DataExportEntry
+		Incoming       []com.ProjectTransfer
+		Licences       map[string]com.LicenceEntry
+		Locale         string
+		Locales        []localeInfo
+		MaxRows        int
+		Meta           com.MetaInfo
+		Notifications  []com.NotificationPref
+		OctoPrint      com.OctoPrintLink
+		OctoPrintLink  bool
+		Profile        com.UserProfile
+		SocialServices []com.SocialService
+		TemplateAction string
+		Templates      []com.ProjectTemplate
+		TimeZone       string
+		TipServices    []com.TipService
+		TipsEnabled    bool
+		TOTPEnabled    bool
+		TOTPPending    string
+		TOTPURI        string
 	}
 	pageData.Meta.Title = "Preferences"
 	pageData.Meta.LoggedInUser = loggedInUser
@@ -2716,9 +3393,20 @@ func prefPage(w http.ResponseWriter, r *http.Request, loggedInUser string) {
 	}
 	pageData.DisplayName = usr.DisplayName
 	pageData.Email = usr.Email
+	pageData.Profile = usr.Profile
+
+	// Show any change of email address still waiting to be confirmed
+	pageData.EmailChange, pageData.EmailPending, err = com.PendingEmailChange(loggedInUser)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Database query failed")
+		return
+	}
+	pageData.SocialServices = com.SocialServices
+	pageData.TipServices = com.TipServices
+	pageData.TipsEnabled = com.FeatureEnabled(com.FeatureTips)
 
 	// Set the server name, used for the placeholder email address suggestion
-	serverName := strings.Split(com.Conf.Web.ServerName, ":")
+	serverName := strings.Split(com.Conf().Web.ServerName, ":")
 	pageData.Meta.Server = serverName[0]
 
 	// If the email address for the user is empty, use username@server by default.  This mirrors the suggestion on the
@@ -2729,6 +3417,100 @@ func prefPage(w http.ResponseWriter, r *http.Request, loggedInUser string) {
 
 	// Retrieve the user preference data
 	pageData.MaxRows = com.PrefUserMaxRows(loggedInUser)
+	pageData.Locale = com.PrefUserLocale(loggedInUser)
+	pageData.Locales = availableLocales()
+	pageData.TimeZone, pageData.DateFormat = com.PrefUserDates(loggedInUser)
+	pageData.DateFormats = com.DateFormats
+	pageData.EmailFrequency = com.PrefUserEmailFrequency(loggedInUser)
+	pageData.Notifications, err = com.NotificationPrefs(loggedInUser)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Database query failed")
+		return
+	}
+
+	// Retrieve the projects waiting to be transferred to the user
+	pageData.Incoming, err = com.IncomingTransfers(loggedInUser)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Retrieve the user's exports of their data
+	pageData.Exports, err = com.DataExports(loggedInUser)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Retrieve the OctoPrint instance the user has linked
+	pageData.OctoPrint, pageData.OctoPrintLink, err = com.OctoPrintLinkDetails(loggedInUser)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Database query failed")
+		return
+	}
+
+	// Retrieve the user's own project templates, and the licences and categories they can choose from
+	tpls, err := com.ProjectTemplates(loggedInUser)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Error when retrieving the project templates")
+		return
+	}
+	for _, t := range tpls {
+		if !t.Instance {
+			pageData.Templates = append(pageData.Templates, t)
+		}
+	}
+	pageData.TemplateAction = "/x/projecttemplate"
+	pageData.Licences, err = com.GetLicences(loggedInUser)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Error when retrieving list of available licences")
+		return
+	}
+	pageData.Categories, err = com.Categories()
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Error when retrieving list of categories")
+		return
+	}
+
+	// Retrieve the plan the user is on, and how many of its private projects they've used
+	if com.Conf().Billing.Enabled {
+		pageData.Billing.Enabled = true
+		pageData.Billing.FreeLimit = com.Conf().Billing.FreePrivateProjects
+		pageData.Billing.PortalURL = com.Conf().Billing.PortalURL
+		pageData.Billing.Plan, err = com.UserPlan(loggedInUser)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+		pageData.Billing.PrivateLimit, err = com.PrivateProjectLimit(loggedInUser)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+		pageData.Billing.PrivateUsed, err = com.PrivateProjectCount(loggedInUser)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+		for _, p := range com.Conf().Billing.Plans {
+			p.CheckoutURL = com.BillingCheckoutURL(p, loggedInUser)
+			pageData.Billing.Plans = append(pageData.Billing.Plans, p)
+		}
+	}
+
+	// Retrieve the authenticator app details.  A secret being set up is kept in the session until it's confirmed
+	sealed, _, _, err := com.UserTOTP(loggedInUser)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	pageData.TOTPEnabled = len(sealed) != 0
+	if sess, err := store.Get(r, "3dhub-user"); err == nil {
+		if pending, ok := sess.Values["PendingTOTP"].(string); ok && pending != "" {
+			pageData.TOTPPending = pending
+			pageData.TOTPURI = com.TOTPURI(loggedInUser, pending)
+		}
+	}
 
 	// Retrieve the details and status updates count for the logged in user
 	ur, err := com.User(loggedInUser)
@@ -2746,16 +3528,18 @@ func prefPage(w http.ResponseWriter, r *http.Request, loggedInUser string) {
 	}
 
 	// Add Auth0 info to the page data
-	pageData.Auth0.CallbackURL = "https://" + com.Conf.Web.ServerName + "/x/callback"
-	pageData.Auth0.ClientID = com.Conf.Auth0.ClientID
-	pageData.Auth0.Domain = com.Conf.Auth0.Domain
+	pageData.Auth0.CallbackURL = "https://" + com.Conf().Web.ServerName + "/x/callback"
+	pageData.Auth0.ClientID = com.Conf().Auth0.ClientID
+	pageData.Auth0.Domain = com.Conf().Auth0.Domain
 
 	// Render the page
-	pageData.Meta.WebsiteName = com.Conf.Web.WebsiteName
-	t := tmpl.Lookup("prefPage")
+	pageData.Meta.WebsiteName = com.Conf().Web.WebsiteName
+	pageData.Meta.Theme = requestTheme(r)
+	pageData.Meta.DateFormat, pageData.Meta.TimeZone = requestDatePrefs(r)
+	t := templates(requestLocale(r)).Lookup("prefPage")
 	err = t.Execute(w, pageData)
 	if err != nil {
-		log.Printf("Error: %s", err)
+		com.Log.Errorf("Error: %s", err)
 	}
 }
 
@@ -2765,10 +3549,11 @@ func profilePage(w http.ResponseWriter, r *http.Request, userName string) {
 		Meta       com.MetaInfo
 		PrivateDBs []com.DBInfo
 		PublicDBs  []com.DBInfo
+		Sort       com.SortOrder
 		Stars      []com.DBEntry
 		Watching   []com.DBEntry
 	}
-	pageData.Meta.Server = com.Conf.Web.ServerName
+	pageData.Meta.Server = com.Conf().Web.ServerName
 	pageData.Meta.LoggedInUser = userName
 
 	// Check if the desired user exists
@@ -2784,15 +3569,25 @@ func profilePage(w http.ResponseWriter, r *http.Request, userName string) {
 		return
 	}
 
+	// Retrieve the requested sort order for the project lists, defaulting to the most recently updated first
+	pageData.Sort, err = com.GetSortOrder(r)
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if pageData.Sort == "" {
+		pageData.Sort = com.SORT_UPDATED
+	}
+
 	// Retrieve list of public databases for the user
-	pageData.PublicDBs, err = com.UserDBs(userName, com.DB_PUBLIC)
+	pageData.PublicDBs, err = com.UserDBs(userName, com.DB_PUBLIC, pageData.Sort)
 	if err != nil {
 		errorPage(w, r, http.StatusInternalServerError, "Database query failed")
 		return
 	}
 
 	// Retrieve list of private databases for the user
-	pageData.PrivateDBs, err = com.UserDBs(userName, com.DB_PRIVATE)
+	pageData.PrivateDBs, err = com.UserDBs(userName, com.DB_PRIVATE, pageData.Sort)
 	if err != nil {
 		errorPage(w, r, http.StatusInternalServerError, "Database query failed")
 		return
@@ -2836,20 +3631,173 @@ func profilePage(w http.ResponseWriter, r *http.Request, userName string) {
 		errorPage(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
+	pageData.Meta.FeedURL = fmt.Sprintf("/feeds/user/%s", usr.Username)
 	pageData.Meta.Owner = usr.Username
 	pageData.Meta.Title = usr.Username
 
 	// Add Auth0 info to the page data
-	pageData.Auth0.CallbackURL = "https://" + com.Conf.Web.ServerName + "/x/callback"
-	pageData.Auth0.ClientID = com.Conf.Auth0.ClientID
-	pageData.Auth0.Domain = com.Conf.Auth0.Domain
+	pageData.Auth0.CallbackURL = "https://" + com.Conf().Web.ServerName + "/x/callback"
+	pageData.Auth0.ClientID = com.Conf().Auth0.ClientID
+	pageData.Auth0.Domain = com.Conf().Auth0.Domain
+
+	// Render the page
+	pageData.Meta.WebsiteName = com.Conf().Web.WebsiteName
+	pageData.Meta.Theme = requestTheme(r)
+	pageData.Meta.DateFormat, pageData.Meta.TimeZone = requestDatePrefs(r)
+	t := templates(requestLocale(r)).Lookup("profilePage")
+	err = t.Execute(w, pageData)
+	if err != nil {
+		com.Log.Errorf("Error: %s", err)
+	}
+}
+
+// Renders the Q&A section of a project.  Without an "id" form value it lists the questions, otherwise it shows the
+// given question with its answers.  The accepted answer (if any) is shown first.
+func questionsPage(w http.ResponseWriter, r *http.Request) {
+	// A question or answer, with its Markdown rendered for the page
+	type post struct {
+		com.DiscussionCommentEntry
+		Rendered template.HTML
+	}
+	var pageData struct {
+		Answers   []post
+		Archived  bool
+		Auth0     com.Auth0Set
+		CanAccept bool
+		Held      bool
+		Meta      com.MetaInfo
+		Question  com.DiscussionEntry
+		Questions []com.DiscussionEntry
+		Rendered  template.HTML
+	}
+	pageData.Meta.Title = "Questions"
+
+	loggedInUser := sessionUser(r)
+	pageData.Meta.LoggedInUser = loggedInUser
+
+	// Retrieve the database owner & name
+	// TODO: Add folder support
+	folder := "/"
+	owner, fileName, err := com.GetOD(1, r) // 1 = Ignore "/questions/" at the start of the URL
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if owner == "" || fileName == "" {
+		errorPage(w, r, http.StatusBadRequest, "Missing database owner or database name")
+		return
+	}
+	var selectedID int
+	if a := r.FormValue("id"); a != "" {
+		selectedID, err = strconv.Atoi(a)
+		if err != nil {
+			errorPage(w, r, http.StatusBadRequest, "Error when parsing question id value")
+			return
+		}
+	}
+
+	// Check if the requested database exists
+	exists, err := com.CheckFileExists(loggedInUser, owner, folder, fileName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !exists {
+		errorPage(w, r, http.StatusNotFound, fmt.Sprintf("Database '%s%s%s' doesn't exist", owner, folder,
+			fileName))
+		return
+	}
+	pageData.Archived, err = com.ProjectArchived(owner, folder, fileName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Retrieve the questions, or the requested one
+	pageData.Questions, err = com.Discussions(owner, folder, fileName, com.QUESTION, selectedID)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if selectedID != 0 {
+		if len(pageData.Questions) == 0 {
+			errorPage(w, r, http.StatusNotFound, "Unknown question ID")
+			return
+		}
+		pageData.Question = pageData.Questions[0]
+		pageData.Rendered = template.HTML(pageData.Question.BodyRendered)
+		pageData.Meta.Title = fmt.Sprintf("Question #%d : %s", pageData.Question.ID, pageData.Question.Title)
+		pageData.CanAccept = strings.ToLower(loggedInUser) == strings.ToLower(owner) ||
+			strings.ToLower(loggedInUser) == strings.ToLower(pageData.Question.Creator)
+		pageData.Held = r.FormValue("held") == "1"
+
+		// The answers are the text comments on the question, with the accepted one first
+		comments, err := com.DiscussionComments(owner, folder, fileName, selectedID, 0)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+		for _, c := range comments {
+			if c.EntryType != com.TEXT {
+				continue
+			}
+			p := post{DiscussionCommentEntry: c, Rendered: template.HTML(c.BodyRendered)}
+			if c.ID == pageData.Question.AcceptedAnswer {
+				pageData.Answers = append([]post{p}, pageData.Answers...)
+			} else {
+				pageData.Answers = append(pageData.Answers, p)
+			}
+		}
+
+		// If this question matches one of the user's status updates, remove the status update from the list
+		if loggedInUser != "" {
+			_, err = com.StatusUpdateCheck(owner, folder, fileName, selectedID, loggedInUser)
+			if err != nil {
+				errorPage(w, r, http.StatusInternalServerError, err.Error())
+				return
+			}
+		}
+	}
+
+	// Retrieve correctly capitalised username for the database owner
+	usr, err := com.User(owner)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	pageData.Meta.Owner = usr.Username
+	pageData.Meta.Database = fileName
+
+	// Retrieve the details and status updates count for the logged in user
+	if loggedInUser != "" {
+		ur, err := com.User(loggedInUser)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if ur.AvatarURL != "" {
+			pageData.Meta.AvatarURL = ur.AvatarURL + "&s=48"
+		}
+		pageData.Meta.NumStatusUpdates, err = com.UserStatusUpdates(loggedInUser)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	// Add Auth0 info to the page data
+	pageData.Auth0.CallbackURL = "https://" + com.Conf().Web.ServerName + "/x/callback"
+	pageData.Auth0.ClientID = com.Conf().Auth0.ClientID
+	pageData.Auth0.Domain = com.Conf().Auth0.Domain
 
 	// Render the page
-	pageData.Meta.WebsiteName = com.Conf.Web.WebsiteName
-	t := tmpl.Lookup("profilePage")
+	pageData.Meta.WebsiteName = com.Conf().Web.WebsiteName
+	pageData.Meta.Theme = requestTheme(r)
+	pageData.Meta.DateFormat, pageData.Meta.TimeZone = requestDatePrefs(r)
+	t := templates(requestLocale(r)).Lookup("questionsPage")
 	err = t.Execute(w, pageData)
 	if err != nil {
-		log.Printf("Error: %s", err)
+		com.Log.Errorf("Error: %s", err)
 	}
 }
 
@@ -2871,26 +3819,12 @@ func releasesPage(w http.ResponseWriter, r *http.Request) {
 		DB          com.SQLiteDBinfo
 		Meta        com.MetaInfo
 		ReleaseList map[string]relEntry
+		Scheduled   []com.ScheduledPublication
 	}
 	pageData.Meta.Title = "Release list"
 
-	// Retrieve session data (if any)
-	var loggedInUser string
-	var u interface{}
-	if com.Conf.Environment.Environment != "docker" {
-		sess, err := store.Get(r, "3dhub-user")
-		if err != nil {
-			errorPage(w, r, http.StatusBadRequest, err.Error())
-			return
-		}
-		u = sess.Values["UserName"]
-	} else {
-		u = "default"
-	}
-	if u != nil {
-		loggedInUser = u.(string)
-		pageData.Meta.LoggedInUser = loggedInUser
-	}
+	loggedInUser := sessionUser(r)
+	pageData.Meta.LoggedInUser = loggedInUser
 
 	// Retrieve the database owner & name
 	// TODO: Add folder support
@@ -2951,6 +3885,9 @@ func releasesPage(w http.ResponseWriter, r *http.Request) {
 
 	// Fill out the metadata
 	pageData.Meta.Database = fileName
+	if pageData.DB.Info.Public && !pageData.DB.Info.Draft {
+		pageData.Meta.FeedURL = fmt.Sprintf("/feeds/releases/%s/%s", usr.Username, fileName)
+	}
 	pageData.ReleaseList = make(map[string]relEntry)
 	if len(releases) > 0 {
 		for i, j := range releases {
@@ -2968,24 +3905,277 @@ func releasesPage(w http.ResponseWriter, r *http.Request) {
 				userNameCache[j.ReleaserEmail] = userCacheEntry{AvatarURL: avatarURL, Email: eml}
 			}
 
-			// Create the tag info we pass to the tag list rendering page
-			var r string
-			if j.Description == "" {
-				r = "No description"
-			} else {
-				r = string(gfm.Markdown([]byte(j.Description)))
+			// Create the tag info we pass to the tag list rendering page
+			var r string
+			if j.Description == "" {
+				r = "No description"
+			} else {
+				r = string(gfm.Markdown([]byte(j.Description)))
+			}
+			pageData.ReleaseList[i] = relEntry{
+				AvatarURL:           userNameCache[j.ReleaserEmail].AvatarURL,
+				Commit:              j.Commit,
+				Date:                j.Date,
+				Description:         j.Description,
+				DescriptionMarkdown: r,
+				ReleaserUserName:    userNameCache[j.ReleaserEmail].Email,
+				ReleaserDisplayName: j.ReleaserName,
+				Size:                j.Size,
+			}
+		}
+	}
+
+	// Owners can see the releases they've scheduled to be published later
+	if strings.ToLower(loggedInUser) == strings.ToLower(owner) {
+		sched, err := com.ScheduledPublications(owner, folder, fileName)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+		for _, p := range sched {
+			if p.Release != "" {
+				pageData.Scheduled = append(pageData.Scheduled, p)
+			}
+		}
+	}
+
+	// Retrieve the details and status updates count for the logged in user
+	if loggedInUser != "" {
+		ur, err := com.User(loggedInUser)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if ur.AvatarURL != "" {
+			pageData.Meta.AvatarURL = ur.AvatarURL + "&s=48"
+		}
+		pageData.Meta.NumStatusUpdates, err = com.UserStatusUpdates(loggedInUser)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	// Add Auth0 info to the page data
+	pageData.Auth0.CallbackURL = "https://" + com.Conf().Web.ServerName + "/x/callback"
+	pageData.Auth0.ClientID = com.Conf().Auth0.ClientID
+	pageData.Auth0.Domain = com.Conf().Auth0.Domain
+
+	// Render the page
+	pageData.Meta.WebsiteName = com.Conf().Web.WebsiteName
+	pageData.Meta.Theme = requestTheme(r)
+	pageData.Meta.DateFormat, pageData.Meta.TimeZone = requestDatePrefs(r)
+	t := templates(requestLocale(r)).Lookup("releasesPage")
+	err = t.Execute(w, pageData)
+	if err != nil {
+		com.Log.Errorf("Error: %s", err)
+	}
+}
+
+// Shows renders of two versions of a project from the same camera position, with a slider for wiping between them.
+// Without any commits given, the default commit is compared with the one before it.
+func renderComparePage(w http.ResponseWriter, r *http.Request) {
+	var pageData struct {
+		Auth0   com.Auth0Set
+		CommitA com.CommitEntry
+		CommitB com.CommitEntry
+		Meta    com.MetaInfo
+	}
+	pageData.Meta.Title = "Compare versions"
+
+	// Retrieve user and database name
+	owner, fileName, err := com.GetOD(1, r) // 1 = Ignore "/rendercompare/" at the start of the URL
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	pageData.Meta.Database = fileName
+	folder := "/"
+	commitA, commitB := r.FormValue("a"), r.FormValue("b")
+	if (commitA != "" && com.ValidateCommitID(commitA) != nil) ||
+		(commitB != "" && com.ValidateCommitID(commitB) != nil) {
+		errorPage(w, r, http.StatusBadRequest, "Invalid commit ID")
+		return
+	}
+
+	loggedInUser := sessionUser(r)
+	pageData.Meta.LoggedInUser = loggedInUser
+
+	// Check if the database exists
+	exists, err := com.CheckFileExists(loggedInUser, owner, folder, fileName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Database failure when looking up database details")
+		return
+	}
+	if !exists {
+		errorPage(w, r, http.StatusNotFound, "That database doesn't seem to exist")
+		return
+	}
+
+	// Work out which commits to compare
+	commits, err := com.GetCommitList(owner, folder, fileName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if commitB == "" {
+		commitB, err = com.DefaultCommit(owner, folder, fileName)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	var ok bool
+	if pageData.CommitB, ok = commits[commitB]; !ok {
+		errorPage(w, r, http.StatusNotFound, "Unknown commit")
+		return
+	}
+	if commitA == "" {
+		commitA = pageData.CommitB.Parent
+		if commitA == "" {
+			errorPage(w, r, http.StatusBadRequest, "There's no earlier version to compare this one with")
+			return
+		}
+	}
+	if pageData.CommitA, ok = commits[commitA]; !ok {
+		errorPage(w, r, http.StatusNotFound, "Unknown commit")
+		return
+	}
+
+	// Retrieve correctly capitalised username for the database owner
+	usr, err := com.User(owner)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	pageData.Meta.Owner = usr.Username
+
+	// Retrieve the details and status updates count for the logged in user
+	if loggedInUser != "" {
+		ur, err := com.User(loggedInUser)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if ur.AvatarURL != "" {
+			pageData.Meta.AvatarURL = ur.AvatarURL + "&s=48"
+		}
+		pageData.Meta.NumStatusUpdates, err = com.UserStatusUpdates(loggedInUser)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	// Add Auth0 info to the page data
+	pageData.Auth0.CallbackURL = "https://" + com.Conf().Web.ServerName + "/x/callback"
+	pageData.Auth0.ClientID = com.Conf().Auth0.ClientID
+	pageData.Auth0.Domain = com.Conf().Auth0.Domain
+
+	// Render the page
+	pageData.Meta.WebsiteName = com.Conf().Web.WebsiteName
+	pageData.Meta.Theme = requestTheme(r)
+	pageData.Meta.DateFormat, pageData.Meta.TimeZone = requestDatePrefs(r)
+	t := templates(requestLocale(r)).Lookup("renderComparePage")
+	err = t.Execute(w, pageData)
+	if err != nil {
+		com.Log.Errorf("Error: %s", err)
+	}
+}
+
+// Renders the search page.  The first page of results is included with the page, with further pages being retrieved
+// by the front end from the search API.  This also renders the tag pages (eg /tagged/enclosures), which are just
+// searches for everything with the tag, and the category pages (eg /category/gadgets/enclosures).
+func searchPage(w http.ResponseWriter, r *http.Request) {
+	var pageData struct {
+		Auth0         com.Auth0Set
+		Category      com.Category
+		Licence       string
+		Licences      map[string]com.LicenceEntry
+		Meta          com.MetaInfo
+		Query         string
+		Results       []com.SearchResult
+		Sort          com.SortOrder
+		SubCategories []com.Category
+		Tag           string
+		Total         int
+	}
+	pageData.Meta.Title = "Search"
+
+	loggedInUser := sessionUser(r)
+	pageData.Meta.LoggedInUser = loggedInUser
+
+	// Retrieve the search text
+	query, filters, _, err := com.GetSearchQuery(r)
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// For tag pages, the tag comes from the URL instead
+	if strings.HasPrefix(r.URL.Path, "/tagged/") {
+		filters.Tag = strings.ToLower(strings.TrimPrefix(r.URL.Path, "/tagged/"))
+		err = com.ValidateProjectTag(filters.Tag)
+		if err != nil {
+			errorPage(w, r, http.StatusBadRequest, "Invalid tag")
+			return
+		}
+		pageData.Meta.FeedURL = fmt.Sprintf("/feeds/tagged/%s", filters.Tag)
+		pageData.Meta.Title = fmt.Sprintf("Projects tagged '%s'", filters.Tag)
+	}
+
+	// Same for category pages
+	if strings.HasPrefix(r.URL.Path, "/category/") {
+		filters.Category = strings.Trim(strings.ToLower(strings.TrimPrefix(r.URL.Path, "/category/")), "/")
+		err = com.ValidateCategoryPath(filters.Category)
+		if err != nil {
+			errorPage(w, r, http.StatusBadRequest, "Invalid category")
+			return
+		}
+	}
+
+	// If filtering on a category, look up its details and those of its direct sub-categories
+	if filters.Category != "" {
+		cats, err := com.Categories()
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, "Error when retrieving list of categories")
+			return
+		}
+		for _, c := range cats {
+			if c.SlugPath == filters.Category {
+				pageData.Category = c
 			}
-			pageData.ReleaseList[i] = relEntry{
-				AvatarURL:           userNameCache[j.ReleaserEmail].AvatarURL,
-				Commit:              j.Commit,
-				Date:                j.Date,
-				Description:         j.Description,
-				DescriptionMarkdown: r,
-				ReleaserUserName:    userNameCache[j.ReleaserEmail].Email,
-				ReleaserDisplayName: j.ReleaserName,
-				Size:                j.Size,
+		}
+		if pageData.Category.ID == 0 {
+			errorPage(w, r, http.StatusNotFound, "Unknown category")
+			return
+		}
+		for _, c := range cats {
+			if c.ParentID == pageData.Category.ID {
+				pageData.SubCategories = append(pageData.SubCategories, c)
 			}
 		}
+		pageData.Meta.Title = fmt.Sprintf("Category: %s", pageData.Category.Path)
+	}
+	pageData.Licence = filters.Licence
+	pageData.Query = query
+	pageData.Sort = filters.Sort
+	pageData.Tag = filters.Tag
+
+	// Populate the licence list, for filtering on
+	pageData.Licences, err = com.GetLicences(loggedInUser)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Error when retrieving list of available licences")
+		return
+	}
+
+	// Run the search
+	if query != "" || filters.Tag != "" || filters.Category != "" || filters.Licence != "" || filters.Sort != "" {
+		pageData.Results, pageData.Total, err = com.Search(loggedInUser, filters, 0)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, "Search failed")
+			return
+		}
 	}
 
 	// Retrieve the details and status updates count for the logged in user
@@ -3006,16 +4196,18 @@ func releasesPage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Add Auth0 info to the page data
-	pageData.Auth0.CallbackURL = "https://" + com.Conf.Web.ServerName + "/x/callback"
-	pageData.Auth0.ClientID = com.Conf.Auth0.ClientID
-	pageData.Auth0.Domain = com.Conf.Auth0.Domain
+	pageData.Auth0.CallbackURL = "https://" + com.Conf().Web.ServerName + "/x/callback"
+	pageData.Auth0.ClientID = com.Conf().Auth0.ClientID
+	pageData.Auth0.Domain = com.Conf().Auth0.Domain
 
 	// Render the page
-	pageData.Meta.WebsiteName = com.Conf.Web.WebsiteName
-	t := tmpl.Lookup("releasesPage")
+	pageData.Meta.WebsiteName = com.Conf().Web.WebsiteName
+	pageData.Meta.Theme = requestTheme(r)
+	pageData.Meta.DateFormat, pageData.Meta.TimeZone = requestDatePrefs(r)
+	t := templates(requestLocale(r)).Lookup("searchPage")
 	err = t.Execute(w, pageData)
 	if err != nil {
-		log.Printf("Error: %s", err)
+		com.Log.Errorf("Error: %s", err)
 	}
 }
 
@@ -3054,9 +4246,9 @@ func selectUserNamePage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Add Auth0 info to the page data
-	pageData.Auth0.CallbackURL = "https://" + com.Conf.Web.ServerName + "/x/callback"
-	pageData.Auth0.ClientID = com.Conf.Auth0.ClientID
-	pageData.Auth0.Domain = com.Conf.Auth0.Domain
+	pageData.Auth0.CallbackURL = "https://" + com.Conf().Web.ServerName + "/x/callback"
+	pageData.Auth0.ClientID = com.Conf().Auth0.ClientID
+	pageData.Auth0.Domain = com.Conf().Auth0.Domain
 
 	// If the Auth0 profile included a nickname, we use that to pre-fill the input field
 	ni := sess.Values["nickname"]
@@ -3065,11 +4257,13 @@ func selectUserNamePage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Render the page
-	pageData.Meta.WebsiteName = com.Conf.Web.WebsiteName
-	t := tmpl.Lookup("selectUserNamePage")
+	pageData.Meta.WebsiteName = com.Conf().Web.WebsiteName
+	pageData.Meta.Theme = requestTheme(r)
+	pageData.Meta.DateFormat, pageData.Meta.TimeZone = requestDatePrefs(r)
+	t := templates(requestLocale(r)).Lookup("selectUserNamePage")
 	err = t.Execute(w, pageData)
 	if err != nil {
-		log.Printf("Error: %s", err)
+		com.Log.Errorf("Error: %s", err)
 	}
 }
 
@@ -3077,38 +4271,40 @@ func selectUserNamePage(w http.ResponseWriter, r *http.Request) {
 func settingsPage(w http.ResponseWriter, r *http.Request) {
 	// Structures to hold page data
 	var pageData struct {
-		Auth0            com.Auth0Set
-		BranchLics       map[string]string
-		DB               com.SQLiteDBinfo
-		FullDescRendered string
-		Licences         map[string]com.LicenceEntry
-		Meta             com.MetaInfo
-		NumLicences      int
+		Analysis            com.AnalysisResult
+		Auth0               com.Auth0Set
+		BranchLics          map[string]string
+		Categories          []com.Category
+		DB                  com.SQLiteDBinfo
+		FullDescRendered    string
+		GitHub              com.GitHubImportEntry
+		GitHubHookURL       string
+		GitHubMirror        com.GitHubMirrorEntry
+		GuestLinkURL        string
+		GuestLinks          []com.GuestUploadLink
+		GuestUploads        []com.GuestUploadEntry
+		HasGitHubMirror     bool
+		HasRegeneration     bool
+		Licences            map[string]com.LicenceEntry
+		Meta                com.MetaInfo
+		NumLicences         int
+		Readme              string
+		ReadmeRendered      string
+		Regeneration        com.RegenerationHookEntry
+		RegenerationHookURL string
+		RemixLicences       []string
+		RemixSources        []com.RemixSource
+		ScheduledPublic     time.Time
+		TransferTo          string
+		Translations        []com.ProjectTranslation
 	}
 	pageData.Meta.Title = "Database settings"
 
-	// Retrieve session data (if any)
-	var loggedInUser string
-	var u interface{}
-	validSession := false
-	if com.Conf.Environment.Environment != "docker" {
-		sess, err := store.Get(r, "3dhub-user")
-		if err != nil {
-			errorPage(w, r, http.StatusBadRequest, err.Error())
-			return
-		}
-		u = sess.Values["UserName"]
-	} else {
-		u = "default"
-	}
-	if u != nil {
-		loggedInUser = u.(string)
-		pageData.Meta.LoggedInUser = loggedInUser
-		validSession = true
-	}
+	loggedInUser := sessionUser(r)
+	pageData.Meta.LoggedInUser = loggedInUser
 
 	// Ensure we have a valid logged in user
-	if validSession != true {
+	if loggedInUser == "" {
 		errorPage(w, r, http.StatusUnauthorized, "You need to be logged in")
 		return
 	}
@@ -3222,9 +4418,112 @@ func settingsPage(w http.ResponseWriter, r *http.Request) {
 	}
 	pageData.NumLicences = len(pageData.Licences)
 
+	// Populate the category list
+	pageData.Categories, err = com.Categories()
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Error when retrieving list of categories")
+		return
+	}
+
 	// Render the full description markdown
 	pageData.FullDescRendered = string(gfm.Markdown([]byte(pageData.DB.Info.FullDesc)))
 
+	// Retrieve the README, and render it for the preview
+	pageData.Readme, err = com.ProjectReadme(owner, folder, fileName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Couldn't retrieve the README")
+		return
+	}
+	pageData.ReadmeRendered = string(gfm.Markdown([]byte(pageData.Readme)))
+
+	// If the project was imported from GitHub, retrieve the details of the import
+	importID, found, err := com.ProjectGitHubImport(owner, folder, fileName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if found {
+		pageData.GitHub, err = com.GitHubImport(importID)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+		pageData.GitHubHookURL = fmt.Sprintf("https://%s/x/githubhook/%d", com.Conf().Web.ServerName, importID)
+	}
+
+	// Retrieve the details of any mirroring of the project to GitHub.  The access token is never shown again
+	pageData.GitHubMirror, pageData.HasGitHubMirror, err = com.GitHubMirror(owner, folder, fileName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	pageData.GitHubMirror.Token = ""
+
+	// Retrieve the regeneration webhook for the project, if it has one
+	pageData.Regeneration, pageData.HasRegeneration, err = com.ProjectRegenerationHook(owner, folder, fileName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if pageData.HasRegeneration {
+		pageData.RegenerationHookURL = fmt.Sprintf("https://%s/x/regenerate/%d", com.Conf().Web.ServerName,
+			pageData.Regeneration.ID)
+	}
+
+	// Retrieve the user the project is waiting to be transferred to, if any
+	pageData.TransferTo, _, err = com.ProjectTransferTo(owner, folder, fileName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Retrieve the guest upload links, and the files sent through them which are waiting for approval
+	pageData.GuestLinks, err = com.GuestUploadLinks(owner, folder, fileName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	pageData.GuestUploads, err = com.GuestUploads(owner, folder, fileName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	pageData.GuestLinkURL = "https://" + com.Conf().Web.ServerName + "/guestupload/"
+
+	// Retrieve the projects this one declares it was remixed from
+	pageData.RemixSources, err = com.RemixSources(owner, folder, fileName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	pageData.RemixLicences = com.RemixLicences
+
+	// Retrieve when the project is scheduled to be made public, if it is
+	sched, err := com.ScheduledPublications(owner, folder, fileName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	for _, p := range sched {
+		if p.Release == "" {
+			pageData.ScheduledPublic = p.PublishAt
+		}
+	}
+
+	// Retrieve the translations of the project's descriptions and README
+	pageData.Translations, err = com.ProjectTranslations(owner, folder, fileName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Retrieve the result of the last re-analysis of the project's file
+	pageData.Analysis, err = com.ProjectAnalysis(owner, folder, fileName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
 	// Retrieve correctly capitalised username for the database owner
 	usr, err := com.User(owner)
 	if err != nil {
@@ -3257,16 +4556,18 @@ func settingsPage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Add Auth0 info to the page data
-	pageData.Auth0.CallbackURL = "https://" + com.Conf.Web.ServerName + "/x/callback"
-	pageData.Auth0.ClientID = com.Conf.Auth0.ClientID
-	pageData.Auth0.Domain = com.Conf.Auth0.Domain
+	pageData.Auth0.CallbackURL = "https://" + com.Conf().Web.ServerName + "/x/callback"
+	pageData.Auth0.ClientID = com.Conf().Auth0.ClientID
+	pageData.Auth0.Domain = com.Conf().Auth0.Domain
 
 	// Render the page
-	pageData.Meta.WebsiteName = com.Conf.Web.WebsiteName
-	t := tmpl.Lookup("settingsPage")
+	pageData.Meta.WebsiteName = com.Conf().Web.WebsiteName
+	pageData.Meta.Theme = requestTheme(r)
+	pageData.Meta.DateFormat, pageData.Meta.TimeZone = requestDatePrefs(r)
+	t := templates(requestLocale(r)).Lookup("settingsPage")
 	err = t.Execute(w, pageData)
 	if err != nil {
-		log.Printf("Error: %s", err)
+		com.Log.Errorf("Error: %s", err)
 	}
 }
 
@@ -3279,23 +4580,8 @@ func starsPage(w http.ResponseWriter, r *http.Request) {
 	}
 	pageData.Meta.Title = "Stars"
 
-	// Retrieve session data (if any)
-	var loggedInUser string
-	var u interface{}
-	if com.Conf.Environment.Environment != "docker" {
-		sess, err := store.Get(r, "3dhub-user")
-		if err != nil {
-			errorPage(w, r, http.StatusBadRequest, err.Error())
-			return
-		}
-		u = sess.Values["UserName"]
-	} else {
-		u = "default"
-	}
-	if u != nil {
-		loggedInUser = u.(string)
-		pageData.Meta.LoggedInUser = loggedInUser
-	}
+	loggedInUser := sessionUser(r)
+	pageData.Meta.LoggedInUser = loggedInUser
 
 	// Retrieve owner and database name
 	owner, fileName, err := com.GetOD(1, r) // 1 = Ignore "/stars/" at the start of the URL
@@ -3324,43 +4610,229 @@ func starsPage(w http.ResponseWriter, r *http.Request) {
 		errorPage(w, r, http.StatusInternalServerError, "Database query failed")
 		return
 	}
-
-	// Retrieve correctly capitalised username for the database owner
-	usr, err := com.User(owner)
+
+	// Retrieve correctly capitalised username for the database owner
+	usr, err := com.User(owner)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	pageData.Meta.Owner = usr.Username
+
+	// Retrieve the details and status updates count for the logged in user
+	if loggedInUser != "" {
+		ur, err := com.User(loggedInUser)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if ur.AvatarURL != "" {
+			pageData.Meta.AvatarURL = ur.AvatarURL + "&s=48"
+		}
+		pageData.Meta.NumStatusUpdates, err = com.UserStatusUpdates(loggedInUser)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	// Add Auth0 info to the page data
+	pageData.Auth0.CallbackURL = "https://" + com.Conf().Web.ServerName + "/x/callback"
+	pageData.Auth0.ClientID = com.Conf().Auth0.ClientID
+	pageData.Auth0.Domain = com.Conf().Auth0.Domain
+
+	// Render the page
+	pageData.Meta.WebsiteName = com.Conf().Web.WebsiteName
+	pageData.Meta.Theme = requestTheme(r)
+	pageData.Meta.DateFormat, pageData.Meta.TimeZone = requestDatePrefs(r)
+	t := templates(requestLocale(r)).Lookup("starsPage")
+	err = t.Execute(w, pageData)
+	if err != nil {
+		com.Log.Errorf("Error: %s", err)
+	}
+}
+
+// Shows the owner of a project how many views, downloads, and stars it's had over time, and where visitors came from.
+func statsPage(w http.ResponseWriter, r *http.Request) {
+	// The statistics for a day, along with how long to draw its bar on the page
+	type statsRow struct {
+		com.ProjectDayStats
+		BarWidth int64
+	}
+	var pageData struct {
+		Auth0     com.Auth0Set
+		Days      int
+		Meta      com.MetaInfo
+		Referrers []com.ReferrerCount
+		Stats     []statsRow
+		Totals    com.ProjectDayStats
+	}
+	pageData.Meta.Title = "Project statistics"
+
+	loggedInUser := sessionUser(r)
+	pageData.Meta.LoggedInUser = loggedInUser
+
+	// Ensure we have a valid logged in user
+	if loggedInUser == "" {
+		errorPage(w, r, http.StatusUnauthorized, "You need to be logged in")
+		return
+	}
+
+	// Retrieve owner and database name
+	// TODO: Add folder support
+	folder := "/"
+	owner, fileName, err := com.GetOD(1, r) // 1 = Ignore "/stats/" at the start of the URL
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if owner == "" || fileName == "" {
+		errorPage(w, r, http.StatusBadRequest, "Missing database owner or database name")
+		return
+	}
+	if strings.ToLower(owner) != strings.ToLower(loggedInUser) {
+		errorPage(w, r, http.StatusForbidden, "You can only view the statistics for your own projects")
+		return
+	}
+	exists, err := com.CheckFileExists(loggedInUser, owner, folder, fileName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Database failure when looking up database details")
+		return
+	}
+	if !exists {
+		errorPage(w, r, http.StatusNotFound, "That database doesn't seem to exist")
+		return
+	}
+
+	// The number of days to show can be chosen, from a fixed set
+	pageData.Days = 30
+	switch r.FormValue("days") {
+	case "7":
+		pageData.Days = 7
+	case "90":
+		pageData.Days = 90
+	case "365":
+		pageData.Days = 365
+	}
+
+	// Retrieve the statistics, newest day first
+	stats, err := com.ProjectStats(owner, folder, fileName, pageData.Days)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Error when retrieving the project statistics")
+		return
+	}
+	var mostViews int64
+	for _, s := range stats {
+		pageData.Totals.Downloads += s.Downloads
+		pageData.Totals.Stars += s.Stars
+		pageData.Totals.Views += s.Views
+		if s.Views > mostViews {
+			mostViews = s.Views
+		}
+	}
+	for i := len(stats) - 1; i >= 0; i-- {
+		row := statsRow{ProjectDayStats: stats[i]}
+		if mostViews > 0 {
+			row.BarWidth = stats[i].Views * 100 / mostViews
+		}
+		pageData.Stats = append(pageData.Stats, row)
+	}
+	pageData.Referrers, err = com.ProjectReferrers(owner, folder, fileName, pageData.Days, 20)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Error when retrieving the project referrers")
+		return
+	}
+
+	// Retrieve correctly capitalised username for the database owner
+	usr, err := com.User(owner)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	pageData.Meta.Owner = usr.Username
+	pageData.Meta.Database = fileName
+	if usr.AvatarURL != "" {
+		pageData.Meta.AvatarURL = usr.AvatarURL + "&s=48"
+	}
+	pageData.Meta.NumStatusUpdates, err = com.UserStatusUpdates(loggedInUser)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Add Auth0 info to the page data
+	pageData.Auth0.CallbackURL = "https://" + com.Conf().Web.ServerName + "/x/callback"
+	pageData.Auth0.ClientID = com.Conf().Auth0.ClientID
+	pageData.Auth0.Domain = com.Conf().Auth0.Domain
+
+	// Render the page
+	pageData.Meta.WebsiteName = com.Conf().Web.WebsiteName
+	pageData.Meta.Theme = requestTheme(r)
+	pageData.Meta.DateFormat, pageData.Meta.TimeZone = requestDatePrefs(r)
+	t := templates(requestLocale(r)).Lookup("statsPage")
+	err = t.Execute(w, pageData)
+	if err != nil {
+		com.Log.Errorf("Error: %s", err)
+	}
+}
+
+// Asks the logged in user to confirm who they are before a destructive operation (eg deleting a project), by logging
+// in again or giving a code from their authenticator app.  They're then sent back to the page given in "return".
+func stepUpPage(w http.ResponseWriter, r *http.Request) {
+	var pageData struct {
+		Auth0    com.Auth0Set
+		HasTOTP  bool
+		Meta     com.MetaInfo
+		ReturnTo string
+	}
+	pageData.Meta.Title = "Confirm it's you"
+
+	loggedInUser := sessionUser(r)
+	pageData.Meta.LoggedInUser = loggedInUser
+
+	// Ensure we have a valid logged in user
+	if loggedInUser == "" {
+		errorPage(w, r, http.StatusUnauthorized, "You need to be logged in")
+		return
+	}
+	pageData.ReturnTo = stepUpReturn(r)
+
+	// Users with an authenticator app can give a code from it instead of logging in again
+	sealed, _, _, err := com.UserTOTP(loggedInUser)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	pageData.HasTOTP = len(sealed) != 0
+
+	// Retrieve the details and status updates count for the logged in user
+	ur, err := com.User(loggedInUser)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if ur.AvatarURL != "" {
+		pageData.Meta.AvatarURL = ur.AvatarURL + "&s=48"
+	}
+	pageData.Meta.NumStatusUpdates, err = com.UserStatusUpdates(loggedInUser)
 	if err != nil {
 		errorPage(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
-	pageData.Meta.Owner = usr.Username
-
-	// Retrieve the details and status updates count for the logged in user
-	if loggedInUser != "" {
-		ur, err := com.User(loggedInUser)
-		if err != nil {
-			errorPage(w, r, http.StatusInternalServerError, err.Error())
-			return
-		}
-		if ur.AvatarURL != "" {
-			pageData.Meta.AvatarURL = ur.AvatarURL + "&s=48"
-		}
-		pageData.Meta.NumStatusUpdates, err = com.UserStatusUpdates(loggedInUser)
-		if err != nil {
-			errorPage(w, r, http.StatusInternalServerError, err.Error())
-			return
-		}
-	}
 
 	// Add Auth0 info to the page data
-	pageData.Auth0.CallbackURL = "https://" + com.Conf.Web.ServerName + "/x/callback"
-	pageData.Auth0.ClientID = com.Conf.Auth0.ClientID
-	pageData.Auth0.Domain = com.Conf.Auth0.Domain
+	pageData.Auth0.CallbackURL = "https://" + com.Conf().Web.ServerName + "/x/callback"
+	pageData.Auth0.ClientID = com.Conf().Auth0.ClientID
+	pageData.Auth0.Domain = com.Conf().Auth0.Domain
 
 	// Render the page
-	pageData.Meta.WebsiteName = com.Conf.Web.WebsiteName
-	t := tmpl.Lookup("starsPage")
+	pageData.Meta.WebsiteName = com.Conf().Web.WebsiteName
+	pageData.Meta.Theme = requestTheme(r)
+	pageData.Meta.DateFormat, pageData.Meta.TimeZone = requestDatePrefs(r)
+	t := templates(requestLocale(r)).Lookup("stepUpPage")
 	err = t.Execute(w, pageData)
 	if err != nil {
-		log.Printf("Error: %s", err)
+		com.Log.Errorf("Error: %s", err)
 	}
 }
 
@@ -3384,23 +4856,8 @@ func tagsPage(w http.ResponseWriter, r *http.Request) {
 	}
 	pageData.Meta.Title = "Tag list"
 
-	// Retrieve session data (if any)
-	var loggedInUser string
-	var u interface{}
-	if com.Conf.Environment.Environment != "docker" {
-		sess, err := store.Get(r, "3dhub-user")
-		if err != nil {
-			errorPage(w, r, http.StatusBadRequest, err.Error())
-			return
-		}
-		u = sess.Values["UserName"]
-	} else {
-		u = "default"
-	}
-	if u != nil {
-		loggedInUser = u.(string)
-		pageData.Meta.LoggedInUser = loggedInUser
-	}
+	loggedInUser := sessionUser(r)
+	pageData.Meta.LoggedInUser = loggedInUser
 
 	// Retrieve the database owner & name
 	// TODO: Add folder support
@@ -3515,16 +4972,18 @@ func tagsPage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Add Auth0 info to the page data
-	pageData.Auth0.CallbackURL = "https://" + com.Conf.Web.ServerName + "/x/callback"
-	pageData.Auth0.ClientID = com.Conf.Auth0.ClientID
-	pageData.Auth0.Domain = com.Conf.Auth0.Domain
+	pageData.Auth0.CallbackURL = "https://" + com.Conf().Web.ServerName + "/x/callback"
+	pageData.Auth0.ClientID = com.Conf().Auth0.ClientID
+	pageData.Auth0.Domain = com.Conf().Auth0.Domain
 
 	// Render the page
-	pageData.Meta.WebsiteName = com.Conf.Web.WebsiteName
-	t := tmpl.Lookup("tagsPage")
+	pageData.Meta.WebsiteName = com.Conf().Web.WebsiteName
+	pageData.Meta.Theme = requestTheme(r)
+	pageData.Meta.DateFormat, pageData.Meta.TimeZone = requestDatePrefs(r)
+	t := templates(requestLocale(r)).Lookup("tagsPage")
 	err = t.Execute(w, pageData)
 	if err != nil {
-		log.Printf("Error: %s", err)
+		com.Log.Errorf("Error: %s", err)
 	}
 }
 
@@ -3533,23 +4992,48 @@ func threeDModelPage(w http.ResponseWriter, r *http.Request, loggedInUser string
 	pageName := "Display 3D model"
 
 	var pageData struct {
-		Auth0   com.Auth0Set
-		Data    com.SQLiteRecordSet
-		DB      com.SQLiteDBinfo
-		Meta    com.MetaInfo
-		MyStar  bool
-		MyWatch bool
+		Auth0         com.Auth0Set
+		Data          com.SQLiteRecordSet
+		DB            com.SQLiteDBinfo
+		DescLanguage  string
+		DescLanguages []localeInfo
+		Meta          com.MetaInfo
+		MyStar        bool
+		MyWatch       bool
+		OctoPrint     bool
+		PrintServices []com.PrintService
+		Tips          []com.TipLink
+		Torrent       bool
 	}
 	pageData.Meta.LoggedInUser = loggedInUser
+	pageData.PrintServices = com.Conf().Print.Services
 
-	// Increment the view counter for the file (excluding people viewing their own files)
+	// Check if the user has linked an OctoPrint instance to send the model to
 	var err error
+	if loggedInUser != "" {
+		_, pageData.OctoPrint, err = com.OctoPrintLinkDetails(loggedInUser)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, "Database query failed")
+			return
+		}
+	}
+
+	// Retrieve the tip links of the project owner
+	tips, err := com.UserTipLinks(owner)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	pageData.Tips = com.VisibleTipLinks(tips)
+
+	// Increment the view counter for the file (excluding people viewing their own files)
 	if strings.ToLower(loggedInUser) != strings.ToLower(owner) {
 		err = com.IncrementViewCount(owner, folder, fileName)
 		if err != nil {
 			errorPage(w, r, http.StatusInternalServerError, err.Error())
 			return
 		}
+		com.RecordProjectView(owner, folder, fileName, r.Referer())
 	}
 
 	// If a specific commit was requested, make sure it exists in the commit history
@@ -3687,7 +5171,7 @@ func threeDModelPage(w http.ResponseWriter, r *http.Request, loggedInUser string
 	// If a cached version of the page data exists, use it
 	ok, err := com.GetCachedData(mdataCacheKey, &pageData)
 	if err != nil {
-		log.Printf("%s: Error retrieving page data from cache: %v\n", pageName, err)
+		com.Log.Errorf("%s: Error retrieving page data from cache: %v", pageName, err)
 	}
 	if ok {
 		// Restore the correct username
@@ -3726,7 +5210,7 @@ func threeDModelPage(w http.ResponseWriter, r *http.Request, loggedInUser string
 				bCheck[j] = struct{}{}
 			} else {
 				// This branch name is already in the map.  Duplicate detected.  This shouldn't happen
-				log.Printf("Duplicate branch name '%s' detected in returned branch list for database '%s%s%s', "+
+				com.Log.Warnf("Duplicate branch name '%s' detected in returned branch list for database '%s%s%s', "+
 					"logged in user '%s'", j, owner, folder, fileName, loggedInUser)
 			}
 		}
@@ -3761,12 +5245,24 @@ func threeDModelPage(w http.ResponseWriter, r *http.Request, loggedInUser string
 		// Ensure the correct Avatar URL is displayed
 		pageData.Meta.AvatarURL = avatarURL
 
+		// Show the descriptions in the visitor's language, if they've been translated into it
+		pageData.DescLanguages, pageData.DescLanguage, err = translateDescriptions(r, owner, folder, fileName,
+			&pageData.DB.Info)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+
 		// Render the page (using the caches)
-		pageData.Meta.WebsiteName = com.Conf.Web.WebsiteName
-		t := tmpl.Lookup("threeDModelPage")
+		pageData.Meta.WebsiteName = com.Conf().Web.WebsiteName
+		pageData.Meta.Theme = requestTheme(r)
+		pageData.Meta.DateFormat, pageData.Meta.TimeZone = requestDatePrefs(r)
+		pageData.Torrent = pageData.DB.Info.Public && !pageData.DB.Info.Draft &&
+			com.TorrentAvailable(pageData.DB.Info.DBEntry.Size)
+		t := templates(requestLocale(r)).Lookup("threeDModelPage")
 		err = t.Execute(w, pageData)
 		if err != nil {
-			log.Printf("Error: %s", err)
+			com.Log.Errorf("Error: %s", err)
 		}
 		return
 	}
@@ -3804,7 +5300,7 @@ func threeDModelPage(w http.ResponseWriter, r *http.Request, loggedInUser string
 
 	// Fill out various metadata fields
 	pageData.Meta.Database = fileName
-	pageData.Meta.Server = com.Conf.Web.ServerName
+	pageData.Meta.Server = com.Conf().Web.ServerName
 	pageData.Meta.Title = fmt.Sprintf("%s %s %s", owner, folder, fileName)
 
 	// Retrieve default branch name details
@@ -3840,7 +5336,7 @@ func threeDModelPage(w http.ResponseWriter, r *http.Request, loggedInUser string
 			bCheck[j] = struct{}{}
 		} else {
 			// This branch name is already in the map.  Duplicate detected.  This shouldn't happen
-			log.Printf("Duplicate branch name '%s' detected in returned branch list for database '%s%s%s', "+
+			com.Log.Warnf("Duplicate branch name '%s' detected in returned branch list for database '%s%s%s', "+
 				"logged in user '%s'", j, owner, folder, fileName, loggedInUser)
 		}
 	}
@@ -3860,9 +5356,9 @@ func threeDModelPage(w http.ResponseWriter, r *http.Request, loggedInUser string
 	pageData.Meta.ForkDeleted = frkDel
 
 	// Add Auth0 info to the page data
-	pageData.Auth0.CallbackURL = "https://" + com.Conf.Web.ServerName + "/x/callback"
-	pageData.Auth0.ClientID = com.Conf.Auth0.ClientID
-	pageData.Auth0.Domain = com.Conf.Auth0.Domain
+	pageData.Auth0.CallbackURL = "https://" + com.Conf().Web.ServerName + "/x/callback"
+	pageData.Auth0.ClientID = com.Conf().Auth0.ClientID
+	pageData.Auth0.Domain = com.Conf().Auth0.Domain
 
 	// Update file star and watch status for the logged in user
 	pageData.MyStar = myStar
@@ -3871,55 +5367,114 @@ func threeDModelPage(w http.ResponseWriter, r *http.Request, loggedInUser string
 	// Render the full description as markdown
 	pageData.DB.Info.FullDesc = string(gfm.Markdown([]byte(pageData.DB.Info.FullDesc)))
 
+	// Render the README as markdown too.  The renderer sanitises its output, so it's safe to include in the page
+	readme, err := com.ProjectReadme(owner, folder, fileName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Couldn't retrieve the README")
+		return
+	}
+	if readme != "" {
+		pageData.DB.Info.Readme = string(gfm.Markdown([]byte(readme)))
+	}
+
 	// Restore the correct discussion and MR count
 	pageData.DB.Info.Discussions = currentDisc
 	pageData.DB.Info.MRs = currentMRs
 
 	// Cache the page metadata
-	err = com.CacheData(mdataCacheKey, pageData, com.Conf.Memcache.DefaultCacheTime)
+	err = com.CacheData(mdataCacheKey, pageData, com.Conf().Memcache.DefaultCacheTime)
 	if err != nil {
-		log.Printf("%s: Error when caching page data: %v\n", pageName, err)
+		com.Log.Errorf("%s: Error when caching page data: %v", pageName, err)
+	}
+
+	// Show the descriptions in the visitor's language, if they've been translated into it
+	pageData.DescLanguages, pageData.DescLanguage, err = translateDescriptions(r, owner, folder, fileName,
+		&pageData.DB.Info)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
 	}
 
 	// Render the page
-	pageData.Meta.WebsiteName = com.Conf.Web.WebsiteName
-	t := tmpl.Lookup("threeDModelPage")
+	pageData.Meta.WebsiteName = com.Conf().Web.WebsiteName
+	pageData.Meta.Theme = requestTheme(r)
+	pageData.Meta.DateFormat, pageData.Meta.TimeZone = requestDatePrefs(r)
+	pageData.Torrent = pageData.DB.Info.Public && !pageData.DB.Info.Draft &&
+		com.TorrentAvailable(pageData.DB.Info.DBEntry.Size)
+	t := templates(requestLocale(r)).Lookup("threeDModelPage")
 	err = t.Execute(w, pageData)
 	if err != nil {
-		log.Printf("Error: %s", err)
+		com.Log.Errorf("Error: %s", err)
 	}
 }
 
-// This function presents the status updates page to logged in users.
-func updatesPage(w http.ResponseWriter, r *http.Request) {
+// Turns off the activity emails for a user, from the one-click unsubscribe link in them.  The link works without
+// being logged in, so it's checked against the user's unsubscribe token.
+func unsubscribePage(w http.ResponseWriter, r *http.Request) {
 	var pageData struct {
-		Auth0   com.Auth0Set
-		Meta    com.MetaInfo
-		Updates map[string][]com.StatusUpdateEntry
+		Auth0 com.Auth0Set
+		Meta  com.MetaInfo
 	}
+	pageData.Meta.Title = "Unsubscribed"
 
-	// Retrieve session data (if any)
-	var loggedInUser string
-	var u interface{}
-	validSession := false
-	if com.Conf.Environment.Environment != "docker" {
-		sess, err := store.Get(r, "3dhub-user")
+	ok, err := com.UnsubscribeUser(r.FormValue("user"), r.FormValue("token"))
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Error when unsubscribing")
+		return
+	}
+	if !ok {
+		errorPage(w, r, http.StatusBadRequest, "That unsubscribe link isn't valid")
+		return
+	}
+
+	// Retrieve the details and status updates count for the logged in user
+	loggedInUser := sessionUser(r)
+	pageData.Meta.LoggedInUser = loggedInUser
+	if loggedInUser != "" {
+		ur, err := com.User(loggedInUser)
 		if err != nil {
-			errorPage(w, r, http.StatusBadRequest, err.Error())
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if ur.AvatarURL != "" {
+			pageData.Meta.AvatarURL = ur.AvatarURL + "&s=48"
+		}
+		pageData.Meta.NumStatusUpdates, err = com.UserStatusUpdates(loggedInUser)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
 			return
 		}
-		u = sess.Values["UserName"]
-	} else {
-		u = "default"
 	}
-	if u != nil {
-		loggedInUser = u.(string)
-		pageData.Meta.LoggedInUser = loggedInUser
-		validSession = true
+
+	// Add Auth0 info to the page data
+	pageData.Auth0.CallbackURL = "https://" + com.Conf().Web.ServerName + "/x/callback"
+	pageData.Auth0.ClientID = com.Conf().Auth0.ClientID
+	pageData.Auth0.Domain = com.Conf().Auth0.Domain
+
+	// Render the page
+	pageData.Meta.WebsiteName = com.Conf().Web.WebsiteName
+	pageData.Meta.Theme = requestTheme(r)
+	pageData.Meta.DateFormat, pageData.Meta.TimeZone = requestDatePrefs(r)
+	t := templates(requestLocale(r)).Lookup("unsubscribePage")
+	err = t.Execute(w, pageData)
+	if err != nil {
+		com.Log.Errorf("Error: %s", err)
+	}
+}
+
+// This function presents the status updates page to logged in users.
+func updatesPage(w http.ResponseWriter, r *http.Request) {
+	var pageData struct {
+		Auth0   com.Auth0Set
+		Meta    com.MetaInfo
+		Updates map[string][]com.StatusUpdateEntry
 	}
 
+	loggedInUser := sessionUser(r)
+	pageData.Meta.LoggedInUser = loggedInUser
+
 	// Ensure we have a valid logged in user
-	if validSession != true {
+	if loggedInUser == "" {
 		errorPage(w, r, http.StatusUnauthorized, "You need to be logged in")
 		return
 	}
@@ -3954,16 +5509,18 @@ func updatesPage(w http.ResponseWriter, r *http.Request) {
 	pageData.Meta.LoggedInUser = loggedInUser
 
 	// Add Auth0 info to the page data
-	pageData.Auth0.CallbackURL = "https://" + com.Conf.Web.ServerName + "/x/callback"
-	pageData.Auth0.ClientID = com.Conf.Auth0.ClientID
-	pageData.Auth0.Domain = com.Conf.Auth0.Domain
+	pageData.Auth0.CallbackURL = "https://" + com.Conf().Web.ServerName + "/x/callback"
+	pageData.Auth0.ClientID = com.Conf().Auth0.ClientID
+	pageData.Auth0.Domain = com.Conf().Auth0.Domain
 
 	// Render the page
-	pageData.Meta.WebsiteName = com.Conf.Web.WebsiteName
-	t := tmpl.Lookup("updatesPage")
+	pageData.Meta.WebsiteName = com.Conf().Web.WebsiteName
+	pageData.Meta.Theme = requestTheme(r)
+	pageData.Meta.DateFormat, pageData.Meta.TimeZone = requestDatePrefs(r)
+	t := templates(requestLocale(r)).Lookup("updatesPage")
 	err = t.Execute(w, pageData)
 	if err != nil {
-		log.Printf("Error: %s", err)
+		com.Log.Errorf("Error: %s", err)
 	}
 }
 
@@ -3973,34 +5530,19 @@ func uploadPage(w http.ResponseWriter, r *http.Request) {
 	var pageData struct {
 		Auth0         com.Auth0Set
 		Branches      []string
+		Categories    []com.Category
 		DefaultBranch string
 		Licences      map[string]com.LicenceEntry
 		Meta          com.MetaInfo
 		NumLicences   int
+		Templates     []com.ProjectTemplate
 	}
 
-	// Retrieve session data (if any)
-	var loggedInUser string
-	var u interface{}
-	validSession := false
-	if com.Conf.Environment.Environment != "docker" {
-		sess, err := store.Get(r, "3dhub-user")
-		if err != nil {
-			errorPage(w, r, http.StatusBadRequest, err.Error())
-			return
-		}
-		u = sess.Values["UserName"]
-	} else {
-		u = "default"
-	}
-	if u != nil {
-		loggedInUser = u.(string)
-		pageData.Meta.LoggedInUser = loggedInUser
-		validSession = true
-	}
+	loggedInUser := sessionUser(r)
+	pageData.Meta.LoggedInUser = loggedInUser
 
 	// Ensure we have a valid logged in user
-	if validSession != true {
+	if loggedInUser == "" {
 		errorPage(w, r, http.StatusUnauthorized, "You need to be logged in")
 		return
 	}
@@ -4025,6 +5567,20 @@ func uploadPage(w http.ResponseWriter, r *http.Request) {
 	}
 	pageData.NumLicences = len(pageData.Licences)
 
+	// Populate the category list
+	pageData.Categories, err = com.Categories()
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Error when retrieving list of categories")
+		return
+	}
+
+	// Populate the list of templates new projects can be created from
+	pageData.Templates, err = com.ProjectTemplates(loggedInUser)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Error when retrieving the project templates")
+		return
+	}
+
 	// Retrieve the details for the logged in user
 	ur, err := com.User(loggedInUser)
 	if err != nil {
@@ -4047,52 +5603,43 @@ func uploadPage(w http.ResponseWriter, r *http.Request) {
 	pageData.Meta.LoggedInUser = loggedInUser
 
 	// Add Auth0 info to the page data
-	pageData.Auth0.CallbackURL = "https://" + com.Conf.Web.ServerName + "/x/callback"
-	pageData.Auth0.ClientID = com.Conf.Auth0.ClientID
-	pageData.Auth0.Domain = com.Conf.Auth0.Domain
+	pageData.Auth0.CallbackURL = "https://" + com.Conf().Web.ServerName + "/x/callback"
+	pageData.Auth0.ClientID = com.Conf().Auth0.ClientID
+	pageData.Auth0.Domain = com.Conf().Auth0.Domain
 
 	// Render the page
-	pageData.Meta.WebsiteName = com.Conf.Web.WebsiteName
-	t := tmpl.Lookup("uploadPage")
+	pageData.Meta.WebsiteName = com.Conf().Web.WebsiteName
+	pageData.Meta.Theme = requestTheme(r)
+	pageData.Meta.DateFormat, pageData.Meta.TimeZone = requestDatePrefs(r)
+	t := templates(requestLocale(r)).Lookup("uploadPage")
 	err = t.Execute(w, pageData)
 	if err != nil {
-		log.Printf("Error: %s", err)
+		com.Log.Errorf("Error: %s", err)
 	}
 }
 
 func userPage(w http.ResponseWriter, r *http.Request, userName string) {
 	// Structure to hold page data
 	var pageData struct {
-		Auth0         com.Auth0Set
-		DBRows        []com.DBInfo
-		FullName      string
-		Meta          com.MetaInfo
-		UserAvatarURL string
+		Auth0          com.Auth0Set
+		DBRows         []com.DBInfo
+		FullName       string
+		Meta           com.MetaInfo
+		Profile        com.UserProfile
+		SocialServices []com.SocialService
+		Sort           com.SortOrder
+		Tips           []com.TipLink
+		UserAvatarURL  string
 	}
-	pageData.Meta.Server = com.Conf.Web.ServerName
+	pageData.Meta.Server = com.Conf().Web.ServerName
 
-	// Retrieve session data (if any)
-	var loggedInUser string
-	var u interface{}
-	if com.Conf.Environment.Environment != "docker" {
-		sess, err := store.Get(r, "3dhub-user")
-		if err != nil {
-			errorPage(w, r, http.StatusBadRequest, err.Error())
-			return
-		}
-		u = sess.Values["UserName"]
-	} else {
-		u = "default"
-	}
-	if u != nil {
-		loggedInUser = u.(string)
-		if strings.ToLower(loggedInUser) == strings.ToLower(userName) {
-			// The logged in user is looking at their own user page
-			profilePage(w, r, loggedInUser)
-			return
-		}
-		pageData.Meta.LoggedInUser = loggedInUser
+	loggedInUser := sessionUser(r)
+	if strings.ToLower(loggedInUser) == strings.ToLower(userName) {
+		// The logged in user is looking at their own user page
+		profilePage(w, r, loggedInUser)
+		return
 	}
+	pageData.Meta.LoggedInUser = loggedInUser
 
 	// Check if the desired user exists
 	userExists, err := com.CheckUserExists(userName)
@@ -4131,30 +5678,46 @@ func userPage(w http.ResponseWriter, r *http.Request, userName string) {
 		return
 	}
 	pageData.FullName = usr.DisplayName
+	pageData.Profile = usr.Profile
+	pageData.SocialServices = com.SocialServices
+	pageData.Tips = com.VisibleTipLinks(usr.Profile.TipLinks)
+	pageData.Meta.FeedURL = fmt.Sprintf("/feeds/user/%s", usr.Username)
 	pageData.Meta.Owner = usr.Username
 	pageData.Meta.Title = usr.Username
 	if usr.AvatarURL != "" {
 		pageData.UserAvatarURL = usr.AvatarURL + "&s=48"
 	}
 
+	// Retrieve the requested sort order, defaulting to the most recently updated first
+	pageData.Sort, err = com.GetSortOrder(r)
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if pageData.Sort == "" {
+		pageData.Sort = com.SORT_UPDATED
+	}
+
 	// Retrieve list of public databases for the user
-	pageData.DBRows, err = com.UserDBs(userName, com.DB_PUBLIC)
+	pageData.DBRows, err = com.UserDBs(userName, com.DB_PUBLIC, pageData.Sort)
 	if err != nil {
 		errorPage(w, r, http.StatusInternalServerError, "Database query failed")
 		return
 	}
 
 	// Add Auth0 info to the page data
-	pageData.Auth0.CallbackURL = "https://" + com.Conf.Web.ServerName + "/x/callback"
-	pageData.Auth0.ClientID = com.Conf.Auth0.ClientID
-	pageData.Auth0.Domain = com.Conf.Auth0.Domain
+	pageData.Auth0.CallbackURL = "https://" + com.Conf().Web.ServerName + "/x/callback"
+	pageData.Auth0.ClientID = com.Conf().Auth0.ClientID
+	pageData.Auth0.Domain = com.Conf().Auth0.Domain
 
 	// Render the page
-	pageData.Meta.WebsiteName = com.Conf.Web.WebsiteName
-	t := tmpl.Lookup("userPage")
+	pageData.Meta.WebsiteName = com.Conf().Web.WebsiteName
+	pageData.Meta.Theme = requestTheme(r)
+	pageData.Meta.DateFormat, pageData.Meta.TimeZone = requestDatePrefs(r)
+	t := templates(requestLocale(r)).Lookup("userPage")
 	err = t.Execute(w, pageData)
 	if err != nil {
-		log.Printf("Error: %s", err)
+		com.Log.Errorf("Error: %s", err)
 	}
 }
 
@@ -4167,23 +5730,8 @@ func watchersPage(w http.ResponseWriter, r *http.Request) {
 	}
 	pageData.Meta.Title = "Watchers"
 
-	// Retrieve session data (if any)
-	var loggedInUser string
-	var u interface{}
-	if com.Conf.Environment.Environment != "docker" {
-		sess, err := store.Get(r, "3dhub-user")
-		if err != nil {
-			errorPage(w, r, http.StatusBadRequest, err.Error())
-			return
-		}
-		u = sess.Values["UserName"]
-	} else {
-		u = "default"
-	}
-	if u != nil {
-		loggedInUser = u.(string)
-		pageData.Meta.LoggedInUser = loggedInUser
-	}
+	loggedInUser := sessionUser(r)
+	pageData.Meta.LoggedInUser = loggedInUser
 
 	// Retrieve owner and database name
 	owner, fileName, err := com.GetOD(1, r) // 1 = Ignore "/watchers/" at the start of the URL
@@ -4239,15 +5787,155 @@ func watchersPage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Add Auth0 info to the page data
-	pageData.Auth0.CallbackURL = "https://" + com.Conf.Web.ServerName + "/x/callback"
-	pageData.Auth0.ClientID = com.Conf.Auth0.ClientID
-	pageData.Auth0.Domain = com.Conf.Auth0.Domain
+	pageData.Auth0.CallbackURL = "https://" + com.Conf().Web.ServerName + "/x/callback"
+	pageData.Auth0.ClientID = com.Conf().Auth0.ClientID
+	pageData.Auth0.Domain = com.Conf().Auth0.Domain
+
+	// Render the page
+	pageData.Meta.WebsiteName = com.Conf().Web.WebsiteName
+	pageData.Meta.Theme = requestTheme(r)
+	pageData.Meta.DateFormat, pageData.Meta.TimeZone = requestDatePrefs(r)
+	t := templates(requestLocale(r)).Lookup("watchersPage")
+	err = t.Execute(w, pageData)
+	if err != nil {
+		com.Log.Errorf("Error: %s", err)
+	}
+}
+
+// Renders a page of a project's wiki.  The "rev" form value shows an older revision of the page, "history" lists its
+// revisions, and "edit" shows the editor (for the project owner).
+func wikiPage(w http.ResponseWriter, r *http.Request) {
+	var pageData struct {
+		Auth0    com.Auth0Set
+		Editing  bool
+		Exists   bool
+		History  []com.WikiRevision
+		Latest   int
+		Meta     com.MetaInfo
+		Page     com.WikiRevision
+		Pages    []com.WikiRevision
+		Rendered template.HTML
+	}
+	pageData.Meta.Title = "Wiki"
+
+	loggedInUser := sessionUser(r)
+	pageData.Meta.LoggedInUser = loggedInUser
+
+	// Retrieve owner and database name
+	// TODO: Add folder support
+	folder := "/"
+	owner, fileName, err := com.GetOD(1, r) // 1 = Ignore "/wiki/" at the start of the URL
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if owner == "" || fileName == "" {
+		errorPage(w, r, http.StatusBadRequest, "Missing database owner or database name")
+		return
+	}
+	pageName, err := com.GetFormWikiPage(r)
+	if err != nil {
+		errorPage(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	var revision int
+	if rev := r.FormValue("rev"); rev != "" {
+		revision, err = strconv.Atoi(rev)
+		if err != nil || revision < 1 {
+			errorPage(w, r, http.StatusBadRequest, "Invalid revision number")
+			return
+		}
+	}
+
+	// The wiki has the same access rules as the project it's for
+	exists, err := com.CheckFileExists(loggedInUser, owner, folder, fileName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Database failure when looking up database details")
+		return
+	}
+	if !exists {
+		errorPage(w, r, http.StatusNotFound, "That database doesn't seem to exist")
+		return
+	}
+	pageData.Editing = r.FormValue("edit") == "1"
+	if pageData.Editing && strings.ToLower(owner) != strings.ToLower(loggedInUser) {
+		errorPage(w, r, http.StatusForbidden, "Only the owner of a project can change its wiki")
+		return
+	}
+
+	// Retrieve the list of pages for the sidebar, and the requested revision of the page
+	pageData.Pages, err = com.WikiPages(owner, folder, fileName)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Error when retrieving the wiki pages")
+		return
+	}
+	for _, p := range pageData.Pages {
+		if p.PageName == pageName {
+			pageData.Latest = p.Revision
+		}
+	}
+	pageData.Page, pageData.Exists, err = com.WikiPage(owner, folder, fileName, pageName, revision)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, "Error when retrieving the wiki page")
+		return
+	}
+	if !pageData.Exists {
+		if revision != 0 {
+			errorPage(w, r, http.StatusNotFound, "That revision of the page doesn't exist")
+			return
+		}
+		pageData.Page.PageName = pageName
+	}
+	if r.FormValue("history") == "1" {
+		pageData.History, err = com.WikiPageHistory(owner, folder, fileName, pageName)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, "Error when retrieving the wiki page history")
+			return
+		}
+	}
+
+	// The renderer sanitises its output, so it's safe to include in the page
+	pageData.Rendered = template.HTML(gfm.Markdown([]byte(pageData.Page.Body)))
+
+	// Retrieve correctly capitalised username for the database owner
+	usr, err := com.User(owner)
+	if err != nil {
+		errorPage(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	pageData.Meta.Owner = usr.Username
+	pageData.Meta.Database = fileName
+	pageData.Meta.Title = fmt.Sprintf("%s - %s wiki", pageName, fileName)
+
+	// Retrieve the details and status updates count for the logged in user
+	if loggedInUser != "" {
+		ur, err := com.User(loggedInUser)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if ur.AvatarURL != "" {
+			pageData.Meta.AvatarURL = ur.AvatarURL + "&s=48"
+		}
+		pageData.Meta.NumStatusUpdates, err = com.UserStatusUpdates(loggedInUser)
+		if err != nil {
+			errorPage(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	// Add Auth0 info to the page data
+	pageData.Auth0.CallbackURL = "https://" + com.Conf().Web.ServerName + "/x/callback"
+	pageData.Auth0.ClientID = com.Conf().Auth0.ClientID
+	pageData.Auth0.Domain = com.Conf().Auth0.Domain
 
 	// Render the page
-	pageData.Meta.WebsiteName = com.Conf.Web.WebsiteName
-	t := tmpl.Lookup("watchersPage")
+	pageData.Meta.WebsiteName = com.Conf().Web.WebsiteName
+	pageData.Meta.Theme = requestTheme(r)
+	pageData.Meta.DateFormat, pageData.Meta.TimeZone = requestDatePrefs(r)
+	t := templates(requestLocale(r)).Lookup("wikiPage")
 	err = t.Execute(w, pageData)
 	if err != nil {
-		log.Printf("Error: %s", err)
+		com.Log.Errorf("Error: %s", err)
 	}
 }
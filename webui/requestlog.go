@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/syslog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	com "github.com/justinclift/3dhub.io/common"
+)
+
+// One entry in the request log
+type requestLogEntry struct {
+	Client    string        `json:"client"`
+	Duration  time.Duration `json:"duration_ns"`
+	Method    string        `json:"method"`
+	Proto     string        `json:"proto"`
+	Referer   string        `json:"referer"`
+	RequestID string        `json:"request_id"`
+	Status    int           `json:"status"`
+	Time      time.Time     `json:"time"`
+	URL       string        `json:"url"`
+	User      string        `json:"user"`
+	UserAgent string        `json:"user_agent"`
+}
+
+// Writes the request log, either to a local file or to syslog.  Local files are rotated once they reach the configured
+// size or age, with the old files renamed using the time of rotation and the oldest ones removed.
+type requestLogger struct {
+	file    *os.File
+	json    bool
+	maxSize int64
+	mu      sync.Mutex
+	opened  time.Time
+	period  string
+	size    int64
+	syslog  *syslog.Writer
+}
+
+// Opens the request log, using the settings in the configuration file.
+func openRequestLog() (*requestLogger, error) {
+	l := &requestLogger{maxSize: com.Conf().Web.RequestLogMaxSizeMB * 1024 * 1024}
+	switch strings.ToLower(com.Conf().Web.RequestLogFormat) {
+	case "", "combined":
+	case "json":
+		l.json = true
+	default:
+		return nil, fmt.Errorf("Unknown request log format: '%s'", com.Conf().Web.RequestLogFormat)
+	}
+	switch strings.ToLower(com.Conf().Web.RequestLogRotate) {
+	case "", "never":
+	case "daily":
+		l.period = "2006-01-02"
+	case "hourly":
+		l.period = "2006-01-02T15"
+	default:
+		return nil, fmt.Errorf("Unknown request log rotation period: '%s'", com.Conf().Web.RequestLogRotate)
+	}
+
+	// Send the log to syslog instead of a file, if one is given.  "local" means the syslog daemon on this server,
+	// anything else should be a URL like udp://logs.example.org:514
+	if dest := com.Conf().Web.RequestLogSyslog; dest != "" {
+		var network, addr string
+		if dest != "local" {
+			u, err := url.Parse(dest)
+			if err != nil || u.Host == "" || (u.Scheme != "tcp" && u.Scheme != "udp") {
+				return nil, fmt.Errorf("Invalid request log syslog destination: '%s'", dest)
+			}
+			network, addr = u.Scheme, u.Host
+		}
+		w, err := syslog.Dial(network, addr, syslog.LOG_INFO|syslog.LOG_LOCAL0, "3dhub-webui")
+		if err != nil {
+			return nil, err
+		}
+		l.syslog = w
+		com.Log.Infof("Request log sent to syslog: %s", dest)
+		return l, nil
+	}
+
+	err := l.openFile()
+	if err != nil {
+		return nil, err
+	}
+	com.Log.Infof("Request log opened: %s", com.Conf().Web.RequestLog)
+	return l, nil
+}
+
+// Removes the oldest rotated request log files, keeping the configured number of them.
+func pruneRequestLogs() {
+	keep := com.Conf().Web.RequestLogKeep
+	if keep <= 0 {
+		return
+	}
+	old, err := filepath.Glob(com.Conf().Web.RequestLog + ".*")
+	if err != nil {
+		return
+	}
+
+	// The rotated files are named using the time of rotation, so sort in name order to put the oldest first
+	sort.Strings(old)
+	for i := 0; i < len(old)-keep; i++ {
+		if err = os.Remove(old[i]); err != nil {
+			com.Log.Warnf("Removing old request log '%s' failed: %v", old[i], err)
+		}
+	}
+}
+
+// Closes the request log.
+func (l *requestLogger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.syslog != nil {
+		return l.syslog.Close()
+	}
+	return l.file.Close()
+}
+
+// Writes an entry to the request log, rotating the log file first if it's due.
+func (l *requestLogger) log(e requestLogEntry) {
+	var line string
+	if l.json {
+		data, err := json.Marshal(e)
+		if err != nil {
+			com.Log.Errorf("Error when serialising request log entry: %v", err)
+			return
+		}
+		line = string(data) + "\n"
+	} else {
+		user := e.User
+		if user == "" {
+			user = "-"
+		}
+		line = fmt.Sprintf("%v - %s [%s] \"%s %s %s\" \"-\" \"-\" \"%s\" \"%s\"\n", e.Client, user,
+			e.Time.Format(time.RFC3339Nano), e.Method, e.URL, e.Proto, e.Referer, e.UserAgent)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.syslog != nil {
+		if err := l.syslog.Info(line); err != nil {
+			com.Log.Errorf("Error when sending request log entry to syslog: %v", err)
+		}
+		return
+	}
+	if l.rotationDue(e.Time, int64(len(line))) {
+		if err := l.rotate(); err != nil {
+			com.Log.Errorf("Rotating the request log failed: %v", err)
+		}
+	}
+	n, err := l.file.WriteString(line)
+	l.size += int64(n)
+	if err != nil {
+		com.Log.Errorf("Error when writing to the request log: %v", err)
+	}
+}
+
+// Opens the request log file for appending, creating it if needed.
+func (l *requestLogger) openFile() error {
+	f, err := os.OpenFile(com.Conf().Web.RequestLog, os.O_CREATE|os.O_APPEND|os.O_WRONLY|os.O_SYNC, 0750)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.file = f
+	l.opened = info.ModTime()
+	l.size = info.Size()
+	if l.size == 0 {
+		l.opened = time.Now()
+	}
+	return nil
+}
+
+// Moves the current request log file out of the way and starts a new one.
+func (l *requestLogger) rotate() error {
+	if err := l.file.Close(); err != nil {
+		com.Log.Warnf("Closing the request log before rotating it failed: %v", err)
+	}
+	renameErr := os.Rename(com.Conf().Web.RequestLog,
+		com.Conf().Web.RequestLog+"."+time.Now().UTC().Format("20060102-150405"))
+	err := l.openFile()
+	if err != nil {
+		return err
+	}
+	if renameErr != nil {
+		// Carry on with the existing file, and don't try again until the next rotation is due
+		l.opened, l.size = time.Now(), 0
+		return renameErr
+	}
+	pruneRequestLogs()
+	return nil
+}
+
+// Returns whether the request log file needs rotating before the next entry is written.
+func (l *requestLogger) rotationDue(now time.Time, entrySize int64) bool {
+	if l.size == 0 {
+		return false
+	}
+	if l.maxSize > 0 && l.size+entrySize > l.maxSize {
+		return true
+	}
+	return l.period != "" && l.opened.UTC().Format(l.period) != now.UTC().Format(l.period)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// A middleware wraps a handler function with extra processing, eg logging or access checks.
+type middleware func(http.HandlerFunc) http.HandlerFunc
+
+// The context key used for storing the details of a request
+type requestInfoKey struct{}
+
+// Details about a request gathered by the middleware, for use by the other middleware and the handlers
+type requestInfo struct {
+	dateFormat    string
+	elevatedUntil int64
+	handler       string
+	locale        string
+	status        int
+	theme         string
+	timeZone      string
+	user          string
+}
+
+// Routes requests to handler functions by URL path and HTTP method, passing them through a chain of middleware on the
+// way.  The paths are matched by an http.ServeMux, so the usual rules for its patterns apply (eg a pattern ending in
+// "/" matches everything below it).
+type router struct {
+	chain  []middleware
+	mux    *http.ServeMux
+	routes map[string]map[string]route
+}
+
+// A handler function for one path and method, already wrapped in its middleware
+type route struct {
+	fn   http.HandlerFunc
+	name string
+}
+
+// Wraps a ResponseWriter to record the HTTP status code sent, for logging and metrics.
+type statusWriter struct {
+	http.ResponseWriter
+	info *requestInfo
+}
+
+func newRouter(mux *http.ServeMux, chain ...middleware) *router {
+	return &router{chain: chain, mux: mux, routes: make(map[string]map[string]route)}
+}
+
+// Returns the details gathered about a request so far.  Requests which haven't come through the router get an empty
+// set of details, so callers never need to check for nil.
+func requestDetails(r *http.Request) *requestInfo {
+	if info, ok := r.Context().Value(requestInfoKey{}).(*requestInfo); ok {
+		return info
+	}
+	return &requestInfo{}
+}
+
+// Returns the name of the logged in user, or an empty string if the request doesn't have a valid session.
+func sessionUser(r *http.Request) string {
+	return requestDetails(r).user
+}
+
+// Passes a request to the handler for its method, or responds with a "405 Method Not Allowed" if there isn't one.
+func (rt *router) dispatch(methods map[string]route, w http.ResponseWriter, r *http.Request) {
+	rte, ok := methods[r.Method]
+	if !ok && r.Method == http.MethodHead {
+		rte, ok = methods[http.MethodGet]
+	}
+	if !ok {
+		var allowed []string
+		for m := range methods {
+			allowed = append(allowed, m)
+		}
+		sort.Strings(allowed)
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		errorPage(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	info := &requestInfo{handler: rte.name}
+	rte.fn(w, r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info)))
+}
+
+// Registers a handler function for GET (and HEAD) requests to the path pattern.
+func (rt *router) get(pattern string, fn http.HandlerFunc, extra ...middleware) {
+	rt.handle(http.MethodGet, pattern, fn, extra...)
+}
+
+// Registers a handler function for requests to the path pattern using the given method.  The handler is wrapped in
+// the router's middleware chain, then any extra middleware given for just this route.
+func (rt *router) handle(method string, pattern string, fn http.HandlerFunc, extra ...middleware) {
+	name := strings.TrimPrefix(runtime.FuncForPC(reflect.ValueOf(fn).Pointer()).Name(), "main.")
+	for i := len(extra) - 1; i >= 0; i-- {
+		fn = extra[i](fn)
+	}
+	for i := len(rt.chain) - 1; i >= 0; i-- {
+		fn = rt.chain[i](fn)
+	}
+
+	// The first handler for a path registers it with the mux
+	methods, ok := rt.routes[pattern]
+	if !ok {
+		methods = make(map[string]route)
+		rt.routes[pattern] = methods
+		rt.mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+			rt.dispatch(methods, w, r)
+		})
+	}
+	if _, ok = methods[method]; ok {
+		panic("webui: multiple registrations for " + method + " " + pattern)
+	}
+	methods[method] = route{fn: fn, name: name}
+}
+
+// Registers a handler function for POST requests to the path pattern.
+func (rt *router) post(pattern string, fn http.HandlerFunc, extra ...middleware) {
+	rt.handle(http.MethodPost, pattern, fn, extra...)
+}
+
+// Returns a router for the same set of paths, which uses a different middleware chain.
+func (rt *router) with(chain ...middleware) *router {
+	return &router{chain: chain, mux: rt.mux, routes: rt.routes}
+}
+
+func (s *statusWriter) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Passes through to the underlying connection, as needed for WebSockets.
+func (s *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := s.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("The connection can't be hijacked")
+	}
+	if s.info.status == 0 {
+		s.info.status = http.StatusSwitchingProtocols
+	}
+	return h.Hijack()
+}
+
+func (s *statusWriter) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+func (s *statusWriter) Write(b []byte) (int, error) {
+	if s.info.status == 0 {
+		s.info.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+func (s *statusWriter) WriteHeader(code int) {
+	if s.info.status == 0 {
+		s.info.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
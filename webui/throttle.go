@@ -0,0 +1,120 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	com "github.com/justinclift/3dhub.io/common"
+)
+
+// Downloads are written in chunks of this size, so the throttling stays smooth
+const throttleChunkSize = 32 << 10
+
+// The download bandwidth in use by each user (or client IP, for anonymous downloads).  A user's bucket is shared by
+// all of their downloads, and is removed once the last of them finishes.
+var (
+	userBuckets   = make(map[string]*tokenBucket)
+	userBucketsMu sync.Mutex
+)
+
+// A token bucket, holding the number of bytes which can be sent right now.  It refills at the given rate, up to one
+// second's worth.
+type tokenBucket struct {
+	last   time.Time
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	users  int
+}
+
+// Writes to the underlying writer no faster than all of its token buckets allow.
+type throttledWriter struct {
+	buckets []*tokenBucket
+	w       io.Writer
+}
+
+// Returns a new token bucket, for the given number of bytes per second.
+func newTokenBucket(rate float64) *tokenBucket {
+	return &tokenBucket{last: time.Now(), rate: rate, tokens: rate}
+}
+
+// Takes n bytes worth of tokens from the bucket, returning how long to wait before sending them.
+func (b *tokenBucket) take(n int) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// Limits the bandwidth of a download, using the per connection and per user caps from the configuration file.  The
+// returned function needs calling once the download has finished.
+func throttleDownload(w io.Writer, key string) (io.Writer, func()) {
+	connRate := com.Conf().Web.DownloadRateKB * 1024
+	userRate := com.Conf().Web.DownloadUserRateKB * 1024
+	if connRate <= 0 && userRate <= 0 {
+		return w, func() {}
+	}
+
+	t := &throttledWriter{w: w}
+	if connRate > 0 {
+		t.buckets = append(t.buckets, newTokenBucket(float64(connRate)))
+	}
+	if userRate <= 0 {
+		return t, func() {}
+	}
+
+	// Join the user's shared bucket, creating it for their first download
+	key = strings.ToLower(key)
+	userBucketsMu.Lock()
+	b, ok := userBuckets[key]
+	if !ok {
+		b = newTokenBucket(float64(userRate))
+		userBuckets[key] = b
+	}
+	b.users++
+	userBucketsMu.Unlock()
+	t.buckets = append(t.buckets, b)
+	return t, func() {
+		userBucketsMu.Lock()
+		b.users--
+		if b.users == 0 {
+			delete(userBuckets, key)
+		}
+		userBucketsMu.Unlock()
+	}
+}
+
+// Writes the data in chunks, waiting between them for as long as the slowest bucket needs.
+func (t *throttledWriter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > throttleChunkSize {
+			chunk = chunk[:throttleChunkSize]
+		}
+		var wait time.Duration
+		for _, b := range t.buckets {
+			if d := b.take(len(chunk)); d > wait {
+				wait = d
+			}
+		}
+		time.Sleep(wait)
+		c, err := t.w.Write(chunk)
+		n += c
+		if err != nil {
+			return n, err
+		}
+		p = p[c:]
+	}
+	return n, nil
+}